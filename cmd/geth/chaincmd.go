@@ -29,6 +29,7 @@ import (
 
 	"github.com/kisexp/xdchain/cmd/utils"
 	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/consensus/istanbul"
 	"github.com/kisexp/xdchain/console/prompt"
 	"github.com/kisexp/xdchain/core"
 	"github.com/kisexp/xdchain/core/mps"
@@ -286,6 +287,12 @@ func initGenesis(ctx *cli.Context) error {
 	if err != nil {
 		utils.Fatalf("maxCodeSize data invalid: %v", err)
 	}
+	if genesis.Config.Istanbul != nil {
+		forks := &istanbul.Config{TestQBFTBlock: genesis.Config.Istanbul.TestQBFTBlock, Ceil2Nby3Block: genesis.Config.Istanbul.Ceil2Nby3Block}
+		if err := forks.ValidateForks(); err != nil {
+			utils.Fatalf("invalid istanbul forks: %v", err)
+		}
+	}
 	// End Quorum
 
 	// Open and initialise both full and light databases
@@ -26,6 +26,7 @@ import (
 
 	"github.com/kisexp/xdchain/cmd/utils"
 	"github.com/kisexp/xdchain/common/http"
+	"github.com/kisexp/xdchain/consensus/istanbul"
 	"github.com/kisexp/xdchain/eth"
 	"github.com/kisexp/xdchain/extension/privacyExtension"
 	"github.com/kisexp/xdchain/internal/ethapi"
@@ -105,7 +106,23 @@ func loadConfig(file string, cfg *gethConfig) error {
 	if _, ok := err.(*toml.LineError); ok {
 		err = errors.New(file + ", " + err.Error())
 	}
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Quorum
+	// the istanbul settings of a file written by a previous version may be missing, they are given the
+	// legacy values. The forks noted as missing are taken from the genesis.
+	istanbulConfig, notes, err := istanbul.MigrateConfig(&cfg.Eth.Istanbul)
+	if err != nil {
+		return errors.New(file + ", " + err.Error())
+	}
+	for _, note := range notes {
+		log.Debug("Migrated istanbul config", "file", file, "note", note)
+	}
+	cfg.Eth.Istanbul = *istanbulConfig
+	// End Quorum
+	return nil
 }
 
 func defaultNodeConfig() node.Config {
@@ -128,7 +145,7 @@ func makeConfigNode(ctx *cli.Context) (*node.Node, gethConfig) {
 
 	// Load defaults.
 	cfg := gethConfig{
-		Eth:  eth.DefaultConfig,
+		Eth:  eth.NewDefaultConfig(),
 		Node: defaultNodeConfig(),
 	}
 
@@ -195,7 +212,7 @@ func makeFullNode(ctx *cli.Context) (*node.Node, ethapi.Backend) {
 	}
 
 	if private.IsQuorumPrivacyEnabled() {
-		utils.RegisterExtensionService(stack, ethService)
+		utils.RegisterExtensionService(stack, ctx, ethService)
 	}
 	// End Quorum
 
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/kisexp/xdchain/consensus/istanbul"
+	"github.com/kisexp/xdchain/eth"
+	"github.com/stretchr/testify/require"
+)
+
+// a config file written by a previous version, dumped with the istanbul settings it did not know about zeroed
+const legacyIstanbulConfig = `
+[Eth.Istanbul]
+RequestTimeout = 0
+BlockPeriod = 0
+Epoch = 0
+`
+
+func TestLoadConfig_MigratesLegacyIstanbulConfig(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "config.toml")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+	_, err = tmpfile.Write([]byte(legacyIstanbulConfig))
+	require.NoError(t, err)
+	require.NoError(t, tmpfile.Close())
+
+	cfg := gethConfig{Eth: eth.NewDefaultConfig(), Node: defaultNodeConfig()}
+	require.NoError(t, loadConfig(tmpfile.Name(), &cfg))
+
+	defaults := istanbul.DefaultConfig()
+	require.Equal(t, defaults.RequestTimeout, cfg.Eth.Istanbul.RequestTimeout)
+	require.Equal(t, defaults.BlockPeriod, cfg.Eth.Istanbul.BlockPeriod)
+	require.Equal(t, defaults.Epoch, cfg.Eth.Istanbul.Epoch)
+	require.NotNil(t, cfg.Eth.Istanbul.ProposerPolicy)
+	require.NoError(t, cfg.Eth.Istanbul.Validate())
+}
@@ -165,6 +165,9 @@ var (
 		configFileFlag,
 		// Quorum
 		utils.PrivateCacheTrieJournalFlag,
+		utils.PrivateStateOpenLimitFlag,
+		utils.PrivateStatePrefetchFlag,
+		utils.PrivateCacheTrieDirtyLimitFlag,
 		utils.QuorumImmutabilityThreshold,
 		utils.EnableNodePermissionFlag,
 		utils.RaftModeFlag,
@@ -175,6 +178,9 @@ var (
 		utils.EmitCheckpointsFlag,
 		utils.IstanbulRequestTimeoutFlag,
 		utils.IstanbulBlockPeriodFlag,
+		utils.IstanbulProposerPolicyFlag,
+		utils.IstanbulProposerRegistryCapFlag,
+		utils.IstanbulPruneProposerRegistryFlag,
 		utils.PluginSettingsFlag,
 		utils.PluginSkipVerifyFlag,
 		utils.PluginLocalVerifyFlag,
@@ -184,6 +190,15 @@ var (
 		utils.MultitenancyFlag,
 		utils.RevertReasonFlag,
 		utils.QuorumEnablePrivacyMarker,
+		utils.ExtensionMaxPayloadSizeFlag,
+		utils.ExtensionMaxStateShareSizeFlag,
+		utils.ExtensionReconnectJitterFlag,
+		utils.ExtensionManagementContractsFlag,
+		utils.ExtensionQueriesFlag,
+		utils.ExtensionWatchUnknownTopicsFlag,
+		utils.ExtensionRateLimitFlag,
+		utils.ExtensionRateLimitIntervalFlag,
+		utils.ExtensionRateLimitQueueFlag,
 		utils.QuorumPTMUnixSocketFlag,
 		utils.QuorumPTMUrlFlag,
 		utils.QuorumPTMTimeoutFlag,
@@ -261,7 +261,19 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.MultitenancyFlag,
 			utils.RevertReasonFlag,
 			utils.PrivateCacheTrieJournalFlag,
+			utils.PrivateStateOpenLimitFlag,
+			utils.PrivateStatePrefetchFlag,
+			utils.PrivateCacheTrieDirtyLimitFlag,
 			utils.QuorumEnablePrivacyMarker,
+			utils.ExtensionMaxPayloadSizeFlag,
+			utils.ExtensionMaxStateShareSizeFlag,
+			utils.ExtensionReconnectJitterFlag,
+			utils.ExtensionManagementContractsFlag,
+			utils.ExtensionQueriesFlag,
+			utils.ExtensionWatchUnknownTopicsFlag,
+			utils.ExtensionRateLimitFlag,
+			utils.ExtensionRateLimitIntervalFlag,
+			utils.ExtensionRateLimitQueueFlag,
 		},
 	},
 	{
@@ -302,6 +314,9 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 		Flags: []cli.Flag{
 			utils.IstanbulRequestTimeoutFlag,
 			utils.IstanbulBlockPeriodFlag,
+			utils.IstanbulProposerPolicyFlag,
+			utils.IstanbulProposerRegistryCapFlag,
+			utils.IstanbulPruneProposerRegistryFlag,
 		},
 	},
 	// END QUORUM
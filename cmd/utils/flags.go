@@ -855,6 +855,19 @@ var (
 		Usage: "Default minimum difference between two consecutive block's timestamps in seconds",
 		Value: eth.DefaultConfig.Istanbul.BlockPeriod,
 	}
+	IstanbulProposerPolicyFlag = cli.StringFlag{
+		Name:  "istanbul.proposerpolicy",
+		Usage: "Proposer policy used instead of the one of the genesis, roundrobin or sticky (test networks only, all the validators must use the same)",
+	}
+	IstanbulProposerRegistryCapFlag = cli.Uint64Flag{
+		Name:  "istanbul.proposerregistrycap",
+		Usage: "Soft cap on the number of validator sets registered to the proposer policy, a warning is logged above it (0 = no cap)",
+		Value: eth.DefaultConfig.Istanbul.ProposerRegistryCap,
+	}
+	IstanbulPruneProposerRegistryFlag = cli.BoolFlag{
+		Name:  "istanbul.pruneproposerregistry",
+		Usage: "Prune the oldest validator sets registered to the proposer policy once the cap is exceeded",
+	}
 	// Multitenancy setting
 	MultitenancyFlag = cli.BoolFlag{
 		Name:  "multitenancy",
@@ -873,12 +886,72 @@ var (
 		Usage: "Disk journal directory for private trie cache to survive node restarts",
 		Value: eth.DefaultConfig.PrivateTrieCleanCacheJournal,
 	}
+	PrivateStateOpenLimitFlag = cli.IntFlag{
+		Name:  "private.state.openlimit",
+		Usage: "Maximum number of private state repositories opened concurrently, 0 for no limit",
+		Value: eth.DefaultConfig.PrivateStateOpenLimit,
+	}
+	PrivateStatePrefetchFlag = cli.BoolFlag{
+		Name:  "private.state.prefetch",
+		Usage: "Prefetch the private state trie nodes of the accounts of the private transactions of imported blocks (multiple private states only)",
+	}
+	PrivateCacheTrieDirtyLimitFlag = cli.IntFlag{
+		Name:  "private.cache.trie.dirtylimit",
+		Usage: "Memory ceiling (MB) of the dirty nodes of each private state trie cache, flushed to disk once approached, 0 for no ceiling (multiple private states only)",
+		Value: eth.DefaultConfig.PrivateTrieDirtyCache,
+	}
 
 	QuorumEnablePrivacyMarker = cli.BoolFlag{
 		Name:  "privacymarker.enable",
 		Usage: "Enable use of privacy marker transactions (PMT) for this node.",
 	}
 
+	// Contract extension
+	ExtensionMaxPayloadSizeFlag = cli.IntFlag{
+		Name:  "extension.maxpayloadsize",
+		Usage: "Maximum size (bytes) of the payloads sent to the private transaction manager by the contract extension. Zero value means no limit.",
+		Value: extension.DefaultConfig.MaxPrivatePayloadSize,
+	}
+	ExtensionMaxStateShareSizeFlag = cli.IntFlag{
+		Name:  "extension.maxstatesharesize",
+		Usage: "Maximum size (bytes) of the contract states shared with this node by the contract extension, larger ones are rejected. Zero value means no limit.",
+		Value: extension.DefaultConfig.MaxStateShareSize,
+	}
+	ExtensionManagementContractsFlag = cli.StringFlag{
+		Name:  "extension.managementcontracts",
+		Usage: "Comma separated extension management contract addresses to watch, each with its own subscription (default = all)",
+		Value: "",
+	}
+	ExtensionReconnectJitterFlag = cli.DurationFlag{
+		Name:  "extension.reconnectjitter",
+		Usage: "Maximum random delay added to the wait before each attempt of the contract extension to subscribe again to its events once a subscription failed",
+		Value: extension.DefaultConfig.ReconnectMaxJitter,
+	}
+	ExtensionQueriesFlag = cli.StringFlag{
+		Name:  "extension.queries",
+		Usage: "Comma separated extension events to watch among newExtension, finishedExtension and canPerformStateShare, the first two are required (default = all)",
+		Value: "",
+	}
+	ExtensionWatchUnknownTopicsFlag = cli.BoolFlag{
+		Name:  "extension.watchunknowntopics",
+		Usage: "Watch all the logs of the extension management contracts to report the events unknown to this node, e.g. once the management contract is upgraded",
+	}
+	ExtensionRateLimitFlag = cli.IntFlag{
+		Name:  "extension.ratelimit",
+		Usage: "Maximum number of new contract extensions processed per --extension.ratelimitinterval, the others are queued or rejected. Zero value means no limit.",
+		Value: extension.DefaultConfig.NewExtensionRateLimit,
+	}
+	ExtensionRateLimitIntervalFlag = cli.DurationFlag{
+		Name:  "extension.ratelimitinterval",
+		Usage: "Interval of the rate limit of the new contract extensions",
+		Value: extension.DefaultConfig.NewExtensionRateInterval,
+	}
+	ExtensionRateLimitQueueFlag = cli.IntFlag{
+		Name:  "extension.ratelimitqueue",
+		Usage: "Maximum number of new contract extensions waiting for the next interval of the rate limit, the others are rejected",
+		Value: extension.DefaultConfig.NewExtensionQueueSize,
+	}
+
 	// Quorum Private Transaction Manager connection options
 	QuorumPTMUnixSocketFlag = DirectoryFlag{
 		Name:  "ptm.socket",
@@ -1719,6 +1792,15 @@ func setIstanbul(ctx *cli.Context, cfg *eth.Config) {
 	if ctx.GlobalIsSet(IstanbulBlockPeriodFlag.Name) {
 		cfg.Istanbul.BlockPeriod = ctx.GlobalUint64(IstanbulBlockPeriodFlag.Name)
 	}
+	if ctx.GlobalIsSet(IstanbulProposerPolicyFlag.Name) {
+		cfg.IstanbulProposerPolicy = ctx.GlobalString(IstanbulProposerPolicyFlag.Name)
+	}
+	if ctx.GlobalIsSet(IstanbulProposerRegistryCapFlag.Name) {
+		cfg.Istanbul.ProposerRegistryCap = ctx.GlobalUint64(IstanbulProposerRegistryCapFlag.Name)
+	}
+	if ctx.GlobalIsSet(IstanbulPruneProposerRegistryFlag.Name) {
+		cfg.Istanbul.PruneProposerRegistry = ctx.GlobalBool(IstanbulPruneProposerRegistryFlag.Name)
+	}
 }
 
 func setRaft(ctx *cli.Context, cfg *eth.Config) {
@@ -1733,6 +1815,15 @@ func setQuorumConfig(ctx *cli.Context, cfg *eth.Config) error {
 	if ctx.GlobalIsSet(PrivateCacheTrieJournalFlag.Name) {
 		cfg.PrivateTrieCleanCacheJournal = ctx.GlobalString(PrivateCacheTrieJournalFlag.Name)
 	}
+	if ctx.GlobalIsSet(PrivateStateOpenLimitFlag.Name) {
+		cfg.PrivateStateOpenLimit = ctx.GlobalInt(PrivateStateOpenLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(PrivateStatePrefetchFlag.Name) {
+		cfg.PrivateStatePrefetch = ctx.GlobalBool(PrivateStatePrefetchFlag.Name)
+	}
+	if ctx.GlobalIsSet(PrivateCacheTrieDirtyLimitFlag.Name) {
+		cfg.PrivateTrieDirtyCache = ctx.GlobalInt(PrivateCacheTrieDirtyLimitFlag.Name)
+	}
 	if ctx.GlobalString(CacheTrieJournalFlag.Name) == cfg.PrivateTrieCleanCacheJournal {
 		return fmt.Errorf("configuration collision with '%s' and '%s' that must be different", CacheTrieJournalFlag.Name, PrivateCacheTrieJournalFlag.Name)
 	}
@@ -2122,8 +2213,8 @@ func RegisterRaftService(stack *node.Node, ctx *cli.Context, nodeCfg *node.Confi
 	log.Info("raft service registered")
 }
 
-func RegisterExtensionService(stack *node.Node, ethService *eth.Ethereum) {
-	_, err := extension.NewServicesFactory(stack, private.P, ethService)
+func RegisterExtensionService(stack *node.Node, ctx *cli.Context, ethService *eth.Ethereum) {
+	_, err := extension.NewServicesFactory(stack, private.P, ethService, MakeExtensionConfig(ctx))
 	if err != nil {
 		Fatalf("Failed to register the Extension service: %v", err)
 	}
@@ -2131,6 +2222,58 @@ func RegisterExtensionService(stack *node.Node, ethService *eth.Ethereum) {
 	log.Info("extension service registered")
 }
 
+// MakeExtensionConfig creates the settings of the contract extension service from the command line flags
+func MakeExtensionConfig(ctx *cli.Context) extension.Config {
+	cfg := extension.DefaultConfig
+	if ctx.GlobalIsSet(ExtensionMaxPayloadSizeFlag.Name) {
+		cfg.MaxPrivatePayloadSize = ctx.GlobalInt(ExtensionMaxPayloadSizeFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExtensionMaxStateShareSizeFlag.Name) {
+		cfg.MaxStateShareSize = ctx.GlobalInt(ExtensionMaxStateShareSizeFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExtensionManagementContractsFlag.Name) {
+		for _, address := range strings.Split(ctx.GlobalString(ExtensionManagementContractsFlag.Name), ",") {
+			if trimmed := strings.TrimSpace(address); !common.IsHexAddress(trimmed) {
+				Fatalf("Invalid address in --%s: %s", ExtensionManagementContractsFlag.Name, trimmed)
+			} else {
+				cfg.ManagementContracts = append(cfg.ManagementContracts, common.HexToAddress(trimmed))
+			}
+		}
+	}
+	if ctx.GlobalIsSet(ExtensionReconnectJitterFlag.Name) {
+		cfg.ReconnectMaxJitter = ctx.GlobalDuration(ExtensionReconnectJitterFlag.Name)
+		if err := cfg.Validate(); err != nil {
+			Fatalf("Invalid --%s: %v", ExtensionReconnectJitterFlag.Name, err)
+		}
+	}
+	if ctx.GlobalIsSet(ExtensionWatchUnknownTopicsFlag.Name) {
+		cfg.WatchUnknownTopics = ctx.GlobalBool(ExtensionWatchUnknownTopicsFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExtensionRateLimitFlag.Name) || ctx.GlobalIsSet(ExtensionRateLimitIntervalFlag.Name) || ctx.GlobalIsSet(ExtensionRateLimitQueueFlag.Name) {
+		if ctx.GlobalIsSet(ExtensionRateLimitFlag.Name) {
+			cfg.NewExtensionRateLimit = ctx.GlobalInt(ExtensionRateLimitFlag.Name)
+		}
+		if ctx.GlobalIsSet(ExtensionRateLimitIntervalFlag.Name) {
+			cfg.NewExtensionRateInterval = ctx.GlobalDuration(ExtensionRateLimitIntervalFlag.Name)
+		}
+		if ctx.GlobalIsSet(ExtensionRateLimitQueueFlag.Name) {
+			cfg.NewExtensionQueueSize = ctx.GlobalInt(ExtensionRateLimitQueueFlag.Name)
+		}
+		if err := cfg.Validate(); err != nil {
+			Fatalf("Invalid --%s: %v", ExtensionRateLimitFlag.Name, err)
+		}
+	}
+	if ctx.GlobalIsSet(ExtensionQueriesFlag.Name) {
+		for _, queryType := range strings.Split(ctx.GlobalString(ExtensionQueriesFlag.Name), ",") {
+			cfg.Queries = append(cfg.Queries, strings.TrimSpace(queryType))
+		}
+		if err := cfg.Validate(); err != nil {
+			Fatalf("Invalid --%s: %v", ExtensionQueriesFlag.Name, err)
+		}
+	}
+	return cfg
+}
+
 func SetupMetrics(ctx *cli.Context) {
 	if metrics.Enabled {
 		log.Info("Enabling metrics collection")
@@ -2241,13 +2384,38 @@ func MakeChain(ctx *cli.Context, stack *node.Node, readOnly bool, useExist bool)
 		engine = clique.New(config.Clique, chainDb)
 	} else if config.Istanbul != nil {
 		// for IBFT
-		istanbulConfig := istanbul.DefaultConfig
+		istanbulConfig := istanbul.DefaultConfig()
 		if config.Istanbul.Epoch != 0 {
 			istanbulConfig.Epoch = config.Istanbul.Epoch
 		}
-		istanbulConfig.ProposerPolicy = istanbul.NewProposerPolicy(istanbul.ProposerPolicyId(config.Istanbul.ProposerPolicy))
+		policyId := istanbul.ProposerPolicyId(config.Istanbul.ProposerPolicy)
+		if ctx.GlobalIsSet(IstanbulProposerPolicyFlag.Name) {
+			policy, err := istanbul.NewProposerPolicyFromString(ctx.GlobalString(IstanbulProposerPolicyFlag.Name))
+			if err != nil {
+				Fatalf("Invalid istanbul proposer policy: %v", err)
+			}
+			policyId = policy.Id
+		} else if !policyId.IsKnown() {
+			Fatalf("Unknown istanbul proposer policy %d in genesis", config.Istanbul.ProposerPolicy)
+		}
+		istanbulConfig.ProposerPolicy = istanbul.NewProposerPolicy(policyId)
+		istanbulConfig.ProposerPolicy.Seed = config.Istanbul.ProposerSeed
+		istanbulConfig.ProposerPolicy.RoundRobinFallback = config.Istanbul.RoundRobinFallback
+		istanbulConfig.ProposerPolicy.ProposerCooldown = config.Istanbul.ProposerCooldown
 		istanbulConfig.Ceil2Nby3Block = config.Istanbul.Ceil2Nby3Block
 		istanbulConfig.TestQBFTBlock = config.Istanbul.TestQBFTBlock
+		istanbulConfig.QBFTValidatorSortBy = config.Istanbul.QBFTValidatorSortBy
+		// the genesis may predate some of the settings, the missing ones are given the legacy values
+		istanbulConfig, notes, err := istanbul.MigrateConfig(istanbulConfig)
+		if err != nil {
+			Fatalf("Invalid istanbul config in genesis: %v", err)
+		}
+		for _, note := range notes {
+			log.Info("Migrated istanbul config", "note", note)
+		}
+		if err := istanbulConfig.ValidateForks(); err != nil {
+			Fatalf("Inconsistent istanbul forks in genesis: %v", err)
+		}
 		engine = istanbulBackend.New(istanbulConfig, stack.GetNodeKey(), chainDb)
 	} else if config.IsQuorum {
 		// for Raft
@@ -29,6 +29,7 @@ import (
 
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/eth"
+	"github.com/kisexp/xdchain/extension"
 	"github.com/kisexp/xdchain/node"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -77,6 +78,60 @@ func TestPrivateTrieCache(t *testing.T) {
 	assert.NoError(t, setQuorumConfig(arbitraryCLIContext, arbitraryNodeConfig))
 }
 
+func TestMakeExtensionConfig(t *testing.T) {
+	assert.Equal(t, extension.DefaultConfig, MakeExtensionConfig(cli.NewContext(nil, &flag.FlagSet{}, nil)))
+
+	fs := &flag.FlagSet{}
+	fs.Int(ExtensionMaxPayloadSizeFlag.Name, 0, "")
+	arbitraryCLIContext := cli.NewContext(nil, fs, nil)
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(ExtensionMaxPayloadSizeFlag.Name, "1024"))
+	assert.Equal(t, 1024, MakeExtensionConfig(arbitraryCLIContext).MaxPrivatePayloadSize)
+
+	fs = &flag.FlagSet{}
+	fs.Int(ExtensionMaxStateShareSizeFlag.Name, 0, "")
+	arbitraryCLIContext = cli.NewContext(nil, fs, nil)
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(ExtensionMaxStateShareSizeFlag.Name, "2048"))
+	assert.Equal(t, 2048, MakeExtensionConfig(arbitraryCLIContext).MaxStateShareSize)
+
+	fs = &flag.FlagSet{}
+	fs.Duration(ExtensionReconnectJitterFlag.Name, 0, "")
+	arbitraryCLIContext = cli.NewContext(nil, fs, nil)
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(ExtensionReconnectJitterFlag.Name, "10s"))
+	assert.Equal(t, 10*time.Second, MakeExtensionConfig(arbitraryCLIContext).ReconnectMaxJitter)
+
+	fs = &flag.FlagSet{}
+	fs.String(ExtensionManagementContractsFlag.Name, "", "")
+	arbitraryCLIContext = cli.NewContext(nil, fs, nil)
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(ExtensionManagementContractsFlag.Name, "0x1349f3e1b8d71effb47b840594ff27da7e603d17, 0x9d13c6d3afe1721beef56b55d303b09e021e27ab"))
+	assert.Equal(t, []common.Address{
+		common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17"),
+		common.HexToAddress("0x9d13c6d3afe1721beef56b55d303b09e021e27ab"),
+	}, MakeExtensionConfig(arbitraryCLIContext).ManagementContracts)
+
+	fs = &flag.FlagSet{}
+	fs.String(ExtensionQueriesFlag.Name, "", "")
+	arbitraryCLIContext = cli.NewContext(nil, fs, nil)
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(ExtensionQueriesFlag.Name, "newExtension, finishedExtension"))
+	assert.Equal(t, []string{"newExtension", "finishedExtension"}, MakeExtensionConfig(arbitraryCLIContext).Queries)
+
+	fs = &flag.FlagSet{}
+	fs.Bool(ExtensionWatchUnknownTopicsFlag.Name, false, "")
+	arbitraryCLIContext = cli.NewContext(nil, fs, nil)
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(ExtensionWatchUnknownTopicsFlag.Name, "true"))
+	assert.True(t, MakeExtensionConfig(arbitraryCLIContext).WatchUnknownTopics)
+
+	fs = &flag.FlagSet{}
+	fs.Int(ExtensionRateLimitFlag.Name, 0, "")
+	fs.Duration(ExtensionRateLimitIntervalFlag.Name, 0, "")
+	arbitraryCLIContext = cli.NewContext(nil, fs, nil)
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(ExtensionRateLimitFlag.Name, "5"))
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(ExtensionRateLimitIntervalFlag.Name, "10s"))
+	cfg := MakeExtensionConfig(arbitraryCLIContext)
+	assert.Equal(t, 5, cfg.NewExtensionRateLimit)
+	assert.Equal(t, 10*time.Second, cfg.NewExtensionRateInterval)
+	assert.Equal(t, extension.DefaultConfig.NewExtensionQueueSize, cfg.NewExtensionQueueSize)
+}
+
 func TestSetPlugins_whenPluginsNotEnabled(t *testing.T) {
 	arbitraryNodeConfig := &node.Config{}
 	arbitraryCLIContext := cli.NewContext(nil, &flag.FlagSet{}, nil)
@@ -208,10 +263,22 @@ func TestQuorumConfigFlags(t *testing.T) {
 	assert.NoError(t, arbitraryCLIContext.GlobalSet(IstanbulRequestTimeoutFlag.Name, "23"))
 	fs.Uint64(IstanbulBlockPeriodFlag.Name, 0, "")
 	assert.NoError(t, arbitraryCLIContext.GlobalSet(IstanbulBlockPeriodFlag.Name, "34"))
+	fs.String(IstanbulProposerPolicyFlag.Name, "", "")
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(IstanbulProposerPolicyFlag.Name, "sticky"))
+	fs.Uint64(IstanbulProposerRegistryCapFlag.Name, 0, "")
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(IstanbulProposerRegistryCapFlag.Name, "64"))
+	fs.Bool(IstanbulPruneProposerRegistryFlag.Name, false, "")
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(IstanbulPruneProposerRegistryFlag.Name, "true"))
 	fs.Bool(RaftModeFlag.Name, false, "")
 	assert.NoError(t, arbitraryCLIContext.GlobalSet(RaftModeFlag.Name, "true"))
 	fs.String(PrivateCacheTrieJournalFlag.Name, "", "")
 	assert.NoError(t, arbitraryCLIContext.GlobalSet(PrivateCacheTrieJournalFlag.Name, "myprivatetriecache"))
+	fs.Int(PrivateStateOpenLimitFlag.Name, 0, "")
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(PrivateStateOpenLimitFlag.Name, "8"))
+	fs.Bool(PrivateStatePrefetchFlag.Name, false, "")
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(PrivateStatePrefetchFlag.Name, "true"))
+	fs.Int(PrivateCacheTrieDirtyLimitFlag.Name, 0, "")
+	assert.NoError(t, arbitraryCLIContext.GlobalSet(PrivateCacheTrieDirtyLimitFlag.Name, "128"))
 
 	require.NoError(t, setQuorumConfig(arbitraryCLIContext, arbitraryEthConfig))
 
@@ -224,6 +291,12 @@ func TestQuorumConfigFlags(t *testing.T) {
 	assert.Equal(t, true, arbitraryEthConfig.QuorumChainConfig.PrivacyMarkerEnabled(), "QuorumEnablePrivacyMarker value is incorrect")
 	assert.Equal(t, uint64(23), arbitraryEthConfig.Istanbul.RequestTimeout, "IstanbulRequestTimeoutFlag value is incorrect")
 	assert.Equal(t, uint64(34), arbitraryEthConfig.Istanbul.BlockPeriod, "IstanbulBlockPeriodFlag value is incorrect")
+	assert.Equal(t, "sticky", arbitraryEthConfig.IstanbulProposerPolicy, "IstanbulProposerPolicyFlag value is incorrect")
+	assert.Equal(t, uint64(64), arbitraryEthConfig.Istanbul.ProposerRegistryCap, "IstanbulProposerRegistryCapFlag value is incorrect")
+	assert.True(t, arbitraryEthConfig.Istanbul.PruneProposerRegistry, "IstanbulPruneProposerRegistryFlag value is incorrect")
 	assert.Equal(t, true, arbitraryEthConfig.RaftMode, "RaftModeFlag value is incorrect")
 	assert.Equal(t, "myprivatetriecache", arbitraryEthConfig.PrivateTrieCleanCacheJournal, "PrivateTrieCleanCacheJournal value is incorrect")
+	assert.Equal(t, 8, arbitraryEthConfig.PrivateStateOpenLimit, "PrivateStateOpenLimit value is incorrect")
+	assert.True(t, arbitraryEthConfig.PrivateStatePrefetch, "PrivateStatePrefetch value is incorrect")
+	assert.Equal(t, 128, arbitraryEthConfig.PrivateTrieDirtyCache, "PrivateTrieDirtyCache value is incorrect")
 }
@@ -71,6 +71,9 @@ type Backend interface {
 	// ParentValidators returns the validator set of the given proposal's parent block
 	ParentValidators(proposal Proposal) ValidatorSet
 
+	// ProposalAuthor returns the validator which created the given proposal
+	ProposalAuthor(proposal Proposal) (common.Address, error)
+
 	// HasBadProposal returns whether the block with the hash is a bad block
 	HasBadProposal(hash common.Hash) bool
 
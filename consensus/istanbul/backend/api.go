@@ -21,6 +21,7 @@ import (
 
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/consensus"
+	"github.com/kisexp/xdchain/consensus/istanbul"
 	istanbulcommon "github.com/kisexp/xdchain/consensus/istanbul/common"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/rpc"
@@ -142,6 +143,41 @@ func (api *API) GetValidators(number *rpc.BlockNumber) ([]common.Address, error)
 	return snap.validators(), nil
 }
 
+// GetProposerPolicy retrieves the id and the parameters of the proposer policy of the running engine
+func (api *API) GetProposerPolicy() istanbul.ProposerPolicyInfo {
+	return api.backend.ProposerPolicy()
+}
+
+// GetProposerOrder retrieves the order in which the validators at the specified block take turns
+// to propose, allowing to verify the permutation derived from the proposer seed.
+func (api *API) GetProposerOrder(number *rpc.BlockNumber) ([]common.Address, error) {
+	// Retrieve the requested block number (or current if none requested)
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, istanbulcommon.ErrUnknownBlock
+	}
+	snap, err := api.backend.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return api.backend.config.Policy().ProposerOrder(snap.ValSet), nil
+}
+
+// GetPendingVotes retrieves the validator votes pending at the specified block, tallied per candidate,
+// along with the epoch checkpoint at which they will be reset.
+func (api *API) GetPendingVotes(number *rpc.BlockNumber) (*PendingVotes, error) {
+	snap, err := api.GetSnapshot(number)
+	if err != nil {
+		return nil, err
+	}
+	return snap.pendingVotes(api.backend.config), nil
+}
+
 // GetValidatorsAtHash retrieves the state snapshot at a given block.
 func (api *API) GetValidatorsAtHash(hash common.Hash) ([]common.Address, error) {
 	header := api.chain.GetHeaderByHash(hash)
@@ -38,6 +38,7 @@ import (
 	"github.com/kisexp/xdchain/ethdb"
 	"github.com/kisexp/xdchain/event"
 	"github.com/kisexp/xdchain/log"
+	"github.com/kisexp/xdchain/metrics"
 	lru "github.com/hashicorp/golang-lru"
 )
 
@@ -68,6 +69,8 @@ func New(config *istanbul.Config, privateKey *ecdsa.PrivateKey, db ethdb.Databas
 		knownMessages:    knownMessages,
 	}
 
+	config.Policy().SetRegistryGuard(metrics.GetOrRegisterGauge("consensus/istanbul/proposerpolicy/registry", nil), int(config.ProposerRegistryCap), config.PruneProposerRegistry)
+
 	sb.qbftEngine = qbftengine.NewEngine(sb.config, sb.address, sb.Sign)
 	sb.ibftEngine = ibftengine.NewEngine(sb.config, sb.address, sb.Sign)
 
@@ -118,6 +121,23 @@ type Backend struct {
 	knownMessages  *lru.ARCCache // the cache of self messages
 
 	qbftConsensusEnabled bool // qbft consensus
+
+	// validators registered for the last epoch checkpoint, compared to the ones of the next checkpoint
+	epochValidatorsMu    sync.Mutex
+	epochValidators      *epochValidators
+	validatorSetSizeFeed event.Feed
+}
+
+// epochValidators holds the validators of the ValidatorSet registered for an epoch checkpoint
+type epochValidators struct {
+	number     uint64
+	validators []common.Address
+}
+
+// SubscribeValidatorSetSizeChangeEvent registers a subscription of istanbul.ValidatorSetSizeChangeEvent,
+// posted when the number of validators changes between two consecutive epoch checkpoints
+func (sb *Backend) SubscribeValidatorSetSizeChangeEvent(ch chan<- istanbul.ValidatorSetSizeChangeEvent) event.Subscription {
+	return sb.validatorSetSizeFeed.Subscribe(ch)
 }
 
 func (sb *Backend) Engine() istanbul.Engine {
@@ -206,6 +226,23 @@ func (sb *Backend) Gossip(valSet istanbul.ValidatorSet, code uint64, payload []b
 	return nil
 }
 
+// liveValidators returns the number of validators of the set this node is connected to, counting itself
+func (sb *Backend) liveValidators(valSet istanbul.ValidatorSet) int {
+	live := 0
+	targets := make(map[common.Address]bool)
+	for _, val := range valSet.List() {
+		if val.Address() == sb.Address() {
+			live++
+		} else {
+			targets[val.Address()] = true
+		}
+	}
+	if sb.broadcaster != nil && len(targets) > 0 {
+		live += len(sb.broadcaster.FindPeers(targets))
+	}
+	return live
+}
+
 // Commit implements istanbul.Backend.Commit
 func (sb *Backend) Commit(proposal istanbul.Proposal, seals [][]byte, round *big.Int) (err error) {
 	// Check if the proposal is a valid block
@@ -222,8 +259,9 @@ func (sb *Backend) Commit(proposal istanbul.Proposal, seals [][]byte, round *big
 		return
 	}
 
+	sb.config.Policy().RecordOrderedValidatorsAt(h.Number.Uint64())
 	// Remove ValidatorSet added to ProposerPolicy registry, if not done, the registry keeps increasing size with each block height
-	sb.config.ProposerPolicy.ClearRegistry()
+	sb.config.Policy().ClearRegistry()
 
 	// update block's header
 	block = block.WithSeal(h)
@@ -322,13 +360,22 @@ func (sb *Backend) ParentValidators(proposal istanbul.Proposal) istanbul.Validat
 	if block, ok := proposal.(*types.Block); ok {
 		return sb.getValidators(block.Number().Uint64()-1, block.ParentHash())
 	}
-	return validator.NewSet(nil, sb.config.ProposerPolicy)
+	return validator.NewSet(nil, sb.config.Policy())
+}
+
+// ProposalAuthor implements istanbul.Backend.ProposalAuthor
+func (sb *Backend) ProposalAuthor(proposal istanbul.Proposal) (common.Address, error) {
+	block, ok := proposal.(*types.Block)
+	if !ok {
+		return common.Address{}, istanbulcommon.ErrInvalidProposal
+	}
+	return sb.EngineForBlockNumber(block.Number()).Author(block.Header())
 }
 
 func (sb *Backend) getValidators(number uint64, hash common.Hash) istanbul.ValidatorSet {
 	snap, err := sb.snapshot(sb.chain, number, hash, nil)
 	if err != nil {
-		return validator.NewSet(nil, sb.config.ProposerPolicy)
+		return validator.NewSet(nil, sb.config.Policy())
 	}
 	return snap.ValSet
 }
@@ -372,6 +419,11 @@ func (sb *Backend) IsQBFTConsensus() bool {
 	return false
 }
 
+// ProposerPolicy returns the id and the parameters of the proposer policy the engine currently runs with
+func (sb *Backend) ProposerPolicy() istanbul.ProposerPolicyInfo {
+	return sb.config.Policy().Info()
+}
+
 // IsQBFTConsensusForHeader checks if qbft consensus is enabled for the block height identified by the given header
 func (sb *Backend) IsQBFTConsensusAt(blockNumber *big.Int) bool {
 	return sb.config.IsQBFTConsensusAt(blockNumber)
@@ -380,7 +432,9 @@ func (sb *Backend) IsQBFTConsensusAt(blockNumber *big.Int) bool {
 func (sb *Backend) startIBFT() error {
 	sb.logger.Info("BFT: activate IBFT")
 	sb.logger.Trace("BFT: set ProposerPolicy sorter to ValidatorSortByStringFun")
-	sb.config.ProposerPolicy.Use(istanbul.ValidatorSortByString())
+	if err := sb.config.Policy().Use(istanbul.ValidatorSortByString()); err != nil {
+		return err
+	}
 	sb.qbftConsensusEnabled = false
 
 	sb.core = ibftcore.New(sb, sb.config)
@@ -394,8 +448,14 @@ func (sb *Backend) startIBFT() error {
 
 func (sb *Backend) startQBFT() error {
 	sb.logger.Info("BFT: activate QBFT")
-	sb.logger.Trace("BFT: set ProposerPolicy sorter to ValidatorSortByByteFunc")
-	sb.config.ProposerPolicy.Use(istanbul.ValidatorSortByByte())
+	by, err := sb.config.QBFTValidatorSortByFunc()
+	if err != nil {
+		return err
+	}
+	sb.logger.Trace("BFT: set ProposerPolicy sorter to the qbft ValidatorSortByFunc", "sortBy", sb.config.QBFTValidatorSortBy)
+	if err := sb.config.Policy().Use(by); err != nil {
+		return err
+	}
 	sb.qbftConsensusEnabled = true
 
 	sb.core = qbftcore.New(sb, sb.config)
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"math/big"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
@@ -28,6 +29,7 @@ import (
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/consensus/istanbul"
 	istanbulcommon "github.com/kisexp/xdchain/consensus/istanbul/common"
+	"github.com/kisexp/xdchain/consensus/istanbul/testutils"
 	"github.com/kisexp/xdchain/consensus/istanbul/validator"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/crypto"
@@ -188,6 +190,19 @@ func TestGetProposer(t *testing.T) {
 	}
 }
 
+func TestGetProposerPolicy(t *testing.T) {
+	chain, engine := newBlockChain(1, big.NewInt(0))
+	defer engine.Stop()
+	api := &API{chain: chain, backend: engine}
+	info := api.GetProposerPolicy()
+	if info.Id != engine.config.ProposerPolicy.Id {
+		t.Errorf("proposer policy id mismatch: have %v, want %v", info.Id, engine.config.ProposerPolicy.Id)
+	}
+	if info != engine.config.ProposerPolicy.Info() {
+		t.Errorf("proposer policy mismatch: have %+v, want %+v", info, engine.config.ProposerPolicy.Info())
+	}
+}
+
 // TestQBFTTransitionDeadlock test whether a deadlock occurs when testQBFTBlock is set to 1
 // This was fixed as part of commit 2a8310663ecafc0233758ca7883676bf568e926e
 func TestQBFTTransitionDeadlock(t *testing.T) {
@@ -251,6 +266,105 @@ func TestIsQBFTConsensus(t *testing.T) {
 	}
 }
 
+func TestNewChainHead_PersistsValidatorSets(t *testing.T) {
+	genesis, nodeKeys := testutils.GenesisAndKeys(1, true)
+	config := istanbul.DefaultConfig()
+	config.PersistValidatorSets = true
+	chain, engine := newBlockchainFromConfig(genesis, nodeKeys, config)
+	defer engine.Stop()
+
+	block := makeBlock(chain, engine, chain.Genesis())
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("Error inserting block: %v", err)
+	}
+	if err := engine.NewChainHead(); err != nil {
+		t.Fatalf("Error posting NewChainHead Event: %v", err)
+	}
+
+	stored, err := istanbul.LoadValidatorSets(engine.db, 1)
+	if err != nil {
+		t.Fatalf("Error loading validator sets: %v", err)
+	}
+	expected := []istanbul.StoredValidatorSet{{Number: 1, Validators: []common.Address{engine.Address()}}}
+	if !reflect.DeepEqual(stored, expected) {
+		t.Errorf("stored validator sets mismatch: have %v, want %v", stored, expected)
+	}
+
+	// a restarted engine records the proposer order of the blocks following the stored heights
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("Error stopping engine: %v", err)
+	}
+	config.ProposerPolicy = istanbul.NewProposerPolicy(config.ProposerPolicy.Id)
+	if err := engine.Start(chain, chain.CurrentBlock, chain.HasBadBlock); err != nil {
+		t.Fatalf("Error starting engine: %v", err)
+	}
+	order, err := config.ProposerPolicy.OrderedValidatorsAt(2)
+	if err != nil {
+		t.Fatalf("Error getting the proposer order: %v", err)
+	}
+	if !reflect.DeepEqual(order, []common.Address{engine.Address()}) {
+		t.Errorf("proposer order mismatch: have %v, want %v", order, []common.Address{engine.Address()})
+	}
+}
+
+func TestLoadProposerRegistry_MatchesLiveRecording(t *testing.T) {
+	genesis, nodeKeys := testutils.GenesisAndKeys(1, true)
+	config := istanbul.DefaultConfig()
+	config.PersistValidatorSets = true
+	chain, engine := newBlockchainFromConfig(genesis, nodeKeys, config)
+	defer engine.Stop()
+
+	parent := chain.Genesis()
+	for i := 0; i < 3; i++ {
+		block := makeBlock(chain, engine, parent)
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("Error inserting block: %v", err)
+		}
+		if err := engine.NewChainHead(); err != nil {
+			t.Fatalf("Error posting NewChainHead Event: %v", err)
+		}
+		parent = block
+	}
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("Error stopping engine: %v", err)
+	}
+
+	// the snapshots of blocks 1 and 2 hold distinct validators, so that an order filed under the wrong height shows
+	snapshots := map[uint64][]common.Address{
+		1: {common.HexToAddress("0x1")},
+		2: {common.HexToAddress("0x1"), common.HexToAddress("0x2")},
+	}
+	live := istanbul.NewProposerPolicy(config.ProposerPolicy.Id)
+	for _, number := range []uint64{1, 2} {
+		valSet := validator.NewSet(snapshots[number], live)
+		if err := istanbul.StoreValidatorSet(engine.db, number, valSet); err != nil {
+			t.Fatalf("Error storing validator set: %v", err)
+		}
+		// Commit of the next block records the set registered for the snapshot of its parent
+		live.RegisterValidatorSet(number, valSet)
+		live.RecordOrderedValidatorsAt(number + 1)
+		live.ClearRegistry()
+	}
+
+	config.ProposerPolicy = istanbul.NewProposerPolicy(config.ProposerPolicy.Id)
+	if err := engine.Start(chain, chain.CurrentBlock, chain.HasBadBlock); err != nil {
+		t.Fatalf("Error starting engine: %v", err)
+	}
+	for _, number := range []uint64{2, 3} {
+		expected, err := live.OrderedValidatorsAt(number)
+		if err != nil {
+			t.Fatalf("Error getting the live proposer order: %v", err)
+		}
+		order, err := config.ProposerPolicy.OrderedValidatorsAt(number)
+		if err != nil {
+			t.Fatalf("Error getting the proposer order: %v", err)
+		}
+		if !reflect.DeepEqual(order, expected) {
+			t.Errorf("proposer order of block %d mismatch: have %v, want %v", number, order, expected)
+		}
+	}
+}
+
 /**
  * SimpleBackend
  * Private key: bb047e5940b6d83354d9432db7c449ac8fca2248008aaa7271369880f9f11cc1
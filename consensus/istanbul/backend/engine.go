@@ -17,6 +17,7 @@
 package backend
 
 import (
+	"fmt"
 	"math/big"
 	"math/rand"
 	"time"
@@ -192,17 +193,28 @@ func (sb *Backend) Seal(chain consensus.ChainHeaderReader, block *types.Block, r
 		return err
 	}
 
+	// Rather halt than propose a block while too many validators are offline
+	if live := sb.liveValidators(snap.ValSet); !sb.config.HasMinValidators(live) {
+		return fmt.Errorf("%w: %d live, %d required", istanbulcommon.ErrTooFewLiveValidators, live, sb.config.MinValidators)
+	}
+
 	block, err = sb.EngineForBlockNumber(header.Number).Seal(chain, block, snap.ValSet)
 	if err != nil {
 		return err
 	}
 
-	delay := time.Until(time.Unix(int64(block.Header().Time), 0))
+	clock := sb.config.GetClock()
+	delay := time.Unix(int64(block.Header().Time), 0).Sub(clock.Now())
+	// the timestamp can't pace sub-second periods, the block is then proposed a period after sealing starts,
+	// which happens as the parent block is imported
+	if sb.config.BlockPeriodMillis > 0 && delay < sb.config.BlockPeriodDuration() {
+		delay = sb.config.BlockPeriodDuration()
+	}
 
 	go func() {
 		// wait for the timestamp of header, use this to adjust the block period
 		select {
-		case <-time.After(delay):
+		case <-clock.After(delay):
 		case <-stop:
 			results <- nil
 			return
@@ -267,6 +279,10 @@ func (sb *Backend) Start(chain consensus.ChainHeaderReader, currentBlock func()
 	sb.currentBlock = currentBlock
 	sb.hasBadBlock = hasBadBlock
 
+	if sb.config.PersistValidatorSets {
+		sb.loadProposerRegistry()
+	}
+
 	// Check if qbft Consensus needs to be used after chain is set
 	var err error
 	if sb.IsQBFTConsensus() {
@@ -284,6 +300,47 @@ func (sb *Backend) Start(chain consensus.ChainHeaderReader, currentBlock func()
 	return nil
 }
 
+// RoundMetrics returns the round change metrics of the running consensus engine
+func (sb *Backend) RoundMetrics() (istanbul.RoundMetricsSnapshot, error) {
+	sb.coreMu.RLock()
+	defer sb.coreMu.RUnlock()
+	if !sb.coreStarted || sb.core == nil {
+		return istanbul.RoundMetricsSnapshot{}, istanbul.ErrStoppedEngine
+	}
+	return sb.core.RoundMetrics(), nil
+}
+
+// loadProposerRegistry warms up the ProposerPolicy registry with the ValidatorSets stored for the retention
+// window ending at the current block. The set stored at a height is the one of its snapshot, which holds the
+// validators of the next block, its proposer order is recorded for the next block as Commit does
+func (sb *Backend) loadProposerRegistry() {
+	number := sb.currentBlock().NumberU64()
+	stored, err := istanbul.LoadValidatorSets(sb.db, number)
+	if err != nil {
+		sb.logger.Warn("BFT: failed to load proposer policy registry", "number", number, "err", err)
+		return
+	}
+	for _, valSet := range stored {
+		sb.config.Policy().RegisterValidatorSet(valSet.Number, validator.NewSet(valSet.Validators, sb.config.Policy()))
+		sb.config.Policy().RecordOrderedValidatorsAt(valSet.Number + 1)
+	}
+	sb.logger.Debug("BFT: loaded proposer policy registry", "number", number, "sets", len(stored))
+}
+
+// storeValidatorSet stores the ValidatorSet of the current block, so the ProposerPolicy registry can be
+// warmed up on restart. It is called as blocks are inserted, whether they were proposed by this node or not.
+func (sb *Backend) storeValidatorSet() {
+	block := sb.currentBlock()
+	snap, err := sb.snapshot(sb.chain, block.NumberU64(), block.Hash(), nil)
+	if err != nil {
+		sb.logger.Warn("BFT: failed to get the validators to store", "number", block.NumberU64(), "err", err)
+		return
+	}
+	if err := istanbul.StoreValidatorSet(sb.db, block.NumberU64(), snap.ValSet); err != nil {
+		sb.logger.Warn("BFT: failed to store proposer policy registry", "number", block.NumberU64(), "err", err)
+	}
+}
+
 // Stop implements consensus.Istanbul.Stop
 func (sb *Backend) Stop() error {
 	sb.coreMu.Lock()
@@ -366,7 +423,11 @@ func (sb *Backend) snapshot(chain consensus.ChainHeaderReader, number uint64, ha
 				return nil, err
 			}
 
-			snap = newSnapshot(sb.config.Epoch, 0, genesis.Hash(), validator.NewSet(validators, sb.config.ProposerPolicy))
+			valSet, err := sb.config.Policy().SeedFromGenesis(validators)
+			if err != nil {
+				return nil, err
+			}
+			snap = newSnapshot(sb.config.Epoch, 0, genesis.Hash(), valSet)
 			if err := sb.storeSnap(snap); err != nil {
 				return nil, err
 			}
@@ -404,6 +465,8 @@ func (sb *Backend) snapshot(chain consensus.ChainHeaderReader, number uint64, ha
 		return nil, err
 	}
 	sb.recents.Add(snap.Hash, snap)
+	sb.config.Policy().RegisterValidatorSet(snap.Number, snap.ValSet)
+	sb.checkEpochValidators(snap)
 
 	// If we've generated a new checkpoint snapshot, save to disk
 	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
@@ -415,6 +478,55 @@ func (sb *Backend) snapshot(chain consensus.ChainHeaderReader, number uint64, ha
 	return snap, err
 }
 
+// checkEpochValidators posts a ValidatorSetSizeChangeEvent if the validators of the snapshot registered
+// for an epoch checkpoint differ in number from the ones registered for the previous checkpoint. The
+// checkpoints at or before the last one checked are ignored, and there is nothing to compare with if the
+// previous checkpoint wasn't seen.
+//
+// The event is posted in its own goroutine so that it doesn't hold up the consensus.
+func (sb *Backend) checkEpochValidators(snap *Snapshot) {
+	number := snap.Number
+	if !sb.config.IsEpochBlock(number) {
+		return
+	}
+	validators := snap.validators()
+	sb.epochValidatorsMu.Lock()
+	defer sb.epochValidatorsMu.Unlock()
+
+	previous := sb.epochValidators
+	if previous != nil && number <= previous.number {
+		return
+	}
+	sb.epochValidators = &epochValidators{number: number, validators: validators}
+	if previous == nil || previous.number+sb.config.Epoch != number || len(previous.validators) == len(validators) {
+		return
+	}
+	ev := istanbul.ValidatorSetSizeChangeEvent{
+		EpochBlock: number,
+		OldSize:    len(previous.validators),
+		NewSize:    len(validators),
+		Added:      missingValidators(validators, previous.validators),
+		Removed:    missingValidators(previous.validators, validators),
+	}
+	sb.logger.Info("BFT: validator set size changed at epoch checkpoint", "number", number, "old", ev.OldSize, "new", ev.NewSize)
+	go sb.validatorSetSizeFeed.Send(ev)
+}
+
+// missingValidators returns the validators which are not in others
+func missingValidators(validators, others []common.Address) []common.Address {
+	known := make(map[common.Address]struct{}, len(others))
+	for _, addr := range others {
+		known[addr] = struct{}{}
+	}
+	var missing []common.Address
+	for _, addr := range validators {
+		if _, ok := known[addr]; !ok {
+			missing = append(missing, addr)
+		}
+	}
+	return missing
+}
+
 // SealHash returns the hash of a block prior to it being sealed.
 func (sb *Backend) SealHash(header *types.Header) common.Hash {
 	return sb.EngineForBlockNumber(header.Number).SealHash(header)
@@ -456,7 +568,7 @@ func (sb *Backend) snapApplyHeader(snap *Snapshot, header *types.Header) error {
 
 	// Remove any votes on checkpoint blocks
 	number := header.Number.Uint64()
-	if number%snap.Epoch == 0 {
+	if sb.config.IsEpochBlock(number) {
 		snap.Votes = nil
 		snap.Tally = make(map[common.Address]Tally)
 	}
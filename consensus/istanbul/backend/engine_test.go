@@ -19,6 +19,7 @@ package backend
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"errors"
 	"math/big"
 	"reflect"
 	"testing"
@@ -80,19 +81,13 @@ func newBlockChain(n int, qbftBlock *big.Int) (*core.BlockChain, *Backend) {
 	isQBFT := qbftBlock != nil && qbftBlock.Uint64() == 0
 	genesis, nodeKeys := testutils.GenesisAndKeys(n, isQBFT)
 
-	config := copyConfig(istanbul.DefaultConfig)
+	config := istanbul.DefaultConfig()
 
 	config.TestQBFTBlock = qbftBlock
 
 	return newBlockchainFromConfig(genesis, nodeKeys, config)
 }
 
-// copyConfig create a copy of istanbul.Config, so that changing it does not update the original
-func copyConfig(config *istanbul.Config) *istanbul.Config {
-	cpy := *config
-	return &cpy
-}
-
 func makeHeader(parent *types.Block, config *istanbul.Config) *types.Header {
 	header := &types.Header{
 		ParentHash: parent.Hash(),
@@ -316,6 +311,35 @@ func TestVerifyHeader(t *testing.T) {
 		t.Errorf("error mismatch: have %v, want %v", err, istanbulcommon.ErrInvalidTimestamp)
 	}
 
+	// timestamp of the parent, which sub-second block periods allow
+	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	header = block.Header()
+	header.Time = chain.Genesis().Time()
+	priorPeriod := engine.config.BlockPeriod
+	engine.config.BlockPeriod, engine.config.BlockPeriodMillis = 0, 500
+	err = engine.VerifyHeader(chain, header, false)
+	engine.config.BlockPeriod, engine.config.BlockPeriodMillis = priorPeriod, 0 //restore changed values
+	if err == istanbulcommon.ErrInvalidTimestamp {
+		t.Errorf("error mismatch: have %v, want any other error", err)
+	}
+
+	// timestamps closer than a sub-second block period are rejected if the block period is strict
+	priorPeriod = engine.config.BlockPeriod
+	engine.config.BlockPeriod, engine.config.BlockPeriodMillis, engine.config.StrictBlockPeriod = 0, 1500, true
+	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	header = block.Header()
+	header.Time = chain.Genesis().Time() + 1
+	err = engine.VerifyHeader(chain, header, false)
+	if err != istanbulcommon.ErrInvalidTimestamp {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbulcommon.ErrInvalidTimestamp)
+	}
+	header.Time = chain.Genesis().Time() + 2
+	err = engine.VerifyHeader(chain, header, false)
+	if err == istanbulcommon.ErrInvalidTimestamp {
+		t.Errorf("error mismatch: have %v, want any other error", err)
+	}
+	engine.config.BlockPeriod, engine.config.BlockPeriodMillis, engine.config.StrictBlockPeriod = priorPeriod, 0, false //restore changed values
+
 	// future block
 	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
 	header = block.Header()
@@ -440,3 +464,57 @@ OUT3:
 		}
 	}
 }
+
+// peersBroadcaster is connected to the given peers
+type peersBroadcaster struct {
+	peers map[common.Address]consensus.Peer
+}
+
+func (b *peersBroadcaster) Enqueue(id string, block *types.Block) {}
+
+func (b *peersBroadcaster) FindPeers(targets map[common.Address]bool) map[common.Address]consensus.Peer {
+	found := make(map[common.Address]consensus.Peer)
+	for addr, p := range b.peers {
+		if targets[addr] {
+			found[addr] = p
+		}
+	}
+	return found
+}
+
+func TestSealMinValidators(t *testing.T) {
+	genesis, nodeKeys := testutils.GenesisAndKeys(4, true)
+	config := istanbul.DefaultConfig()
+	config.MinValidators = 3
+	chain, engine := newBlockchainFromConfig(genesis, nodeKeys, config)
+	defer engine.Stop()
+	var others []common.Address
+	for _, key := range nodeKeys {
+		if addr := crypto.PubkeyToAddress(key.PublicKey); addr != engine.Address() {
+			others = append(others, addr)
+		}
+	}
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	stop := make(chan struct{})
+	defer close(stop)
+	resultCh := make(chan *types.Block, 10)
+
+	// this node and a single peer are live
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[common.Address]consensus.Peer{others[0]: nil}})
+	err := engine.Seal(chain, block, resultCh, stop)
+	if !errors.Is(err, istanbulcommon.ErrTooFewLiveValidators) {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbulcommon.ErrTooFewLiveValidators)
+	}
+
+	// peers which aren't validators are not counted
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[common.Address]consensus.Peer{others[0]: nil, common.HexToAddress("0x1"): nil}})
+	err = engine.Seal(chain, block, resultCh, stop)
+	if !errors.Is(err, istanbulcommon.ErrTooFewLiveValidators) {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbulcommon.ErrTooFewLiveValidators)
+	}
+
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[common.Address]consensus.Peer{others[0]: nil, others[1]: nil}})
+	if err := engine.Seal(chain, block, resultCh, stop); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+}
@@ -143,6 +143,9 @@ func (sb *Backend) NewChainHead() error {
 	if !sb.coreStarted {
 		return istanbul.ErrStoppedEngine
 	}
+	if sb.config.PersistValidatorSets {
+		sb.storeValidatorSet()
+	}
 	go sb.istanbulEventMux.Post(istanbul.FinalCommittedEvent{})
 	return nil
 }
@@ -46,6 +46,14 @@ type Tally struct {
 	Votes     int  `json:"votes"`     // Number of votes until now wanting to pass the proposal
 }
 
+// PendingVotes holds the votes cast since the last epoch checkpoint, which are reset at the next one.
+type PendingVotes struct {
+	Number     uint64                   `json:"number"`               // Block number at which the votes are pending
+	ResetBlock uint64                   `json:"resetBlock,omitempty"` // Next epoch checkpoint, 0 if the votes are never reset
+	Votes      []*Vote                  `json:"votes"`                // Votes cast in chronological order
+	Tally      map[common.Address]Tally `json:"tally"`                // Score of the votes per candidate
+}
+
 // Snapshot is the state of the authorization voting at a given point in time.
 type Snapshot struct {
 	Epoch uint64 // The number of blocks after which to checkpoint and reset the pending votes
@@ -114,6 +122,25 @@ func (s *Snapshot) copy() *Snapshot {
 	return cpy
 }
 
+// pendingVotes returns a copy of the votes of the snapshot and the epoch checkpoint of the config at
+// which they are reset
+func (s *Snapshot) pendingVotes(config *istanbul.Config) *PendingVotes {
+	pending := &PendingVotes{
+		Number: s.Number,
+		Votes:  make([]*Vote, len(s.Votes)),
+		Tally:  make(map[common.Address]Tally, len(s.Tally)),
+	}
+	pending.ResetBlock, _ = config.NextEpochBlock(s.Number)
+	for i, vote := range s.Votes {
+		cpy := *vote
+		pending.Votes[i] = &cpy
+	}
+	for address, tally := range s.Tally {
+		pending.Tally[address] = tally
+	}
+	return pending
+}
+
 // checkVote return whether it's a valid vote
 func (s *Snapshot) checkVote(address common.Address, authorize bool) bool {
 	_, validator := s.ValSet.GetByAddress(address)
@@ -183,6 +210,11 @@ type snapshotJSON struct {
 	// for validator set
 	Validators []common.Address          `json:"validators"`
 	Policy     istanbul.ProposerPolicyId `json:"policy"`
+	Seed       *common.Hash              `json:"seed,omitempty"`
+	// RoundRobinFallback of the sticky policy
+	RoundRobinFallback bool `json:"roundRobinFallback,omitempty"`
+	// ProposerCooldown of the round robin policy
+	ProposerCooldown uint64 `json:"proposerCooldown,omitempty"`
 }
 
 func (s *Snapshot) toJSONStruct() *snapshotJSON {
@@ -194,6 +226,10 @@ func (s *Snapshot) toJSONStruct() *snapshotJSON {
 		Tally:      s.Tally,
 		Validators: s.validators(),
 		Policy:     s.ValSet.Policy().Id,
+		Seed:       s.ValSet.Policy().Seed,
+
+		RoundRobinFallback: s.ValSet.Policy().RoundRobinFallback,
+		ProposerCooldown:   s.ValSet.Policy().ProposerCooldown,
 	}
 }
 
@@ -212,6 +248,9 @@ func (s *Snapshot) UnmarshalJSON(b []byte) error {
 
 	// Setting the By function to ValidatorSortByStringFunc should be fine, as the validator do not change only the order changes
 	pp := istanbul.NewProposerPolicyByIdAndSortFunc(j.Policy, istanbul.ValidatorSortByString())
+	pp.Seed = j.Seed
+	pp.RoundRobinFallback = j.RoundRobinFallback
+	pp.ProposerCooldown = j.ProposerCooldown
 	s.ValSet = validator.NewSet(j.Validators, pp)
 	return nil
 }
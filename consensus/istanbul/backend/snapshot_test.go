@@ -22,6 +22,7 @@ import (
 	"math/big"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/consensus/istanbul"
@@ -29,6 +30,7 @@ import (
 	qbftengine "github.com/kisexp/xdchain/consensus/istanbul/qbft/engine"
 	"github.com/kisexp/xdchain/consensus/istanbul/testutils"
 	"github.com/kisexp/xdchain/consensus/istanbul/validator"
+	"github.com/kisexp/xdchain/core"
 	"github.com/kisexp/xdchain/core/rawdb"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/crypto"
@@ -69,6 +71,37 @@ func (ap *testerAccountPool) address(account string) common.Address {
 	return crypto.PubkeyToAddress(ap.accounts[account].PublicKey)
 }
 
+// assembleVoteHeaders assembles a chain of headers, following the genesis, from the cast votes
+func assembleVoteHeaders(t *testing.T, accounts *testerAccountPool, genesis *core.Genesis, config *istanbul.Config, validators []common.Address, votes []testerVote) []*types.Header {
+	headers := make([]*types.Header, len(votes))
+	for j, vote := range votes {
+		headers[j] = &types.Header{
+			Number:     big.NewInt(int64(j) + 1),
+			Time:       uint64(int64(j) * int64(config.BlockPeriod)),
+			Coinbase:   accounts.address(vote.validator),
+			Difficulty: istanbulcommon.DefaultDifficulty,
+			MixDigest:  types.IstanbulDigest,
+		}
+		_ = qbftengine.ApplyHeaderQBFTExtra(
+			headers[j],
+			qbftengine.WriteValidators(validators),
+		)
+
+		if j > 0 {
+			headers[j].ParentHash = headers[j-1].Hash()
+		}
+
+		copy(headers[j].Extra, genesis.ExtraData)
+
+		if len(vote.voted) > 0 {
+			if err := accounts.writeValidatorVote(headers[j], vote.validator, vote.voted, vote.auth); err != nil {
+				t.Errorf("Error writeValidatorVote test: %d, validator: %s, voteType: %v (err=%v)", j, vote.voted, vote.auth, err)
+			}
+		}
+	}
+	return headers
+}
+
 // Tests that voting is evaluated correctly for various simple and complex scenarios.
 func TestVoting(t *testing.T) {
 	// Define the various voting scenarios to test
@@ -330,7 +363,7 @@ func TestVoting(t *testing.T) {
 
 		genesis := testutils.Genesis(validators, true)
 		config := new(istanbul.Config)
-		*config = *istanbul.DefaultConfig
+		*config = *istanbul.DefaultConfig()
 		config.TestQBFTBlock = big.NewInt(0)
 		if tt.epoch != 0 {
 			config.Epoch = tt.epoch
@@ -343,32 +376,7 @@ func TestVoting(t *testing.T) {
 		)
 
 		// Assemble a chain of headers from the cast votes
-		headers := make([]*types.Header, len(tt.votes))
-		for j, vote := range tt.votes {
-			headers[j] = &types.Header{
-				Number:     big.NewInt(int64(j) + 1),
-				Time:       uint64(int64(j) * int64(config.BlockPeriod)),
-				Coinbase:   accounts.address(vote.validator),
-				Difficulty: istanbulcommon.DefaultDifficulty,
-				MixDigest:  types.IstanbulDigest,
-			}
-			_ = qbftengine.ApplyHeaderQBFTExtra(
-				headers[j],
-				qbftengine.WriteValidators(validators),
-			)
-
-			if j > 0 {
-				headers[j].ParentHash = headers[j-1].Hash()
-			}
-
-			copy(headers[j].Extra, genesis.ExtraData)
-
-			if len(vote.voted) > 0 {
-				if err := accounts.writeValidatorVote(headers[j], vote.validator, vote.voted, vote.auth); err != nil {
-					t.Errorf("Error writeValidatorVote test: %d, validator: %s, voteType: %v (err=%v)", j, vote.voted, vote.auth, err)
-				}
-			}
-		}
+		headers := assembleVoteHeaders(t, accounts, genesis, config, validators, tt.votes)
 
 		// Pass all the headers through clique and ensure tallying succeeds
 		head := headers[len(headers)-1]
@@ -406,6 +414,123 @@ func TestVoting(t *testing.T) {
 	}
 }
 
+// Tests that the pending votes are accumulated until the next epoch checkpoint, which resets them.
+func TestPendingVotes(t *testing.T) {
+	accounts := newTesterAccountPool()
+	validators := []common.Address{accounts.address("A"), accounts.address("B"), accounts.address("C"), accounts.address("D")}
+	genesis := testutils.Genesis(validators, true)
+	config := istanbul.DefaultConfig()
+	config.TestQBFTBlock = big.NewInt(0)
+	config.Epoch = 4
+
+	chain, backend := newBlockchainFromConfig(genesis, []*ecdsa.PrivateKey{accounts.accounts["A"]}, config)
+	defer backend.Stop()
+
+	headers := assembleVoteHeaders(t, accounts, genesis, config, validators, []testerVote{
+		{validator: "A", voted: "E", auth: true},
+		{validator: "B", voted: "E", auth: true},
+		{validator: "C", voted: "D", auth: false},
+		{validator: "A"}, // Checkpoint block, resetting the votes
+		{validator: "B", voted: "F", auth: true},
+	})
+
+	snap, err := backend.snapshot(chain, 3, headers[2].Hash(), headers[:3])
+	if err != nil {
+		t.Fatalf("failed to create voting snapshot: %v", err)
+	}
+	want := &PendingVotes{
+		Number:     3,
+		ResetBlock: 4,
+		Votes: []*Vote{
+			{Validator: accounts.address("A"), Block: 1, Address: accounts.address("E"), Authorize: true},
+			{Validator: accounts.address("B"), Block: 2, Address: accounts.address("E"), Authorize: true},
+			{Validator: accounts.address("C"), Block: 3, Address: accounts.address("D"), Authorize: false},
+		},
+		Tally: map[common.Address]Tally{
+			accounts.address("E"): {Authorize: true, Votes: 2},
+			accounts.address("D"): {Authorize: false, Votes: 1},
+		},
+	}
+	if pending := snap.pendingVotes(config); !reflect.DeepEqual(pending, want) {
+		t.Errorf("pending votes mismatch: have %+v, want %+v", pending, want)
+	}
+
+	snap, err = backend.snapshot(chain, 5, headers[4].Hash(), headers)
+	if err != nil {
+		t.Fatalf("failed to create voting snapshot: %v", err)
+	}
+	want = &PendingVotes{
+		Number:     5,
+		ResetBlock: 8,
+		Votes: []*Vote{
+			{Validator: accounts.address("B"), Block: 5, Address: accounts.address("F"), Authorize: true},
+		},
+		Tally: map[common.Address]Tally{
+			accounts.address("F"): {Authorize: true, Votes: 1},
+		},
+	}
+	if pending := snap.pendingVotes(config); !reflect.DeepEqual(pending, want) {
+		t.Errorf("pending votes mismatch: have %+v, want %+v", pending, want)
+	}
+}
+
+// Tests that a change of the number of validators between two epoch checkpoints is posted.
+func TestValidatorSetSizeChangeEvent(t *testing.T) {
+	accounts := newTesterAccountPool()
+	validators := []common.Address{accounts.address("A"), accounts.address("B")}
+	genesis := testutils.Genesis(validators, true)
+	config := istanbul.DefaultConfig()
+	config.TestQBFTBlock = big.NewInt(0)
+	config.Epoch = 3
+
+	chain, backend := newBlockchainFromConfig(genesis, []*ecdsa.PrivateKey{accounts.accounts["A"]}, config)
+	defer backend.Stop()
+
+	events := make(chan istanbul.ValidatorSetSizeChangeEvent, 3)
+	sub := backend.SubscribeValidatorSetSizeChangeEvent(events)
+	defer sub.Unsubscribe()
+
+	headers := assembleVoteHeaders(t, accounts, genesis, config, validators, []testerVote{
+		{validator: "A", voted: "C", auth: true},
+		{validator: "B", voted: "C", auth: true}, // C is added
+		{validator: "A"},                         // Checkpoint block
+		{validator: "B", voted: "A", auth: false},
+		{validator: "C", voted: "A", auth: false}, // A is removed
+		{validator: "B"},                          // Checkpoint block
+		{validator: "B"},
+		{validator: "C"},
+		{validator: "B"}, // Checkpoint block, the validators haven't changed
+	})
+	if _, err := backend.snapshot(chain, 0, chain.Genesis().Hash(), nil); err != nil {
+		t.Fatalf("failed to create voting snapshot: %v", err)
+	}
+	for i, header := range headers {
+		if _, err := backend.snapshot(chain, header.Number.Uint64(), header.Hash(), headers[:i+1]); err != nil {
+			t.Fatalf("failed to create voting snapshot of block %d: %v", header.Number.Uint64(), err)
+		}
+	}
+
+	want := map[uint64]istanbul.ValidatorSetSizeChangeEvent{
+		3: {EpochBlock: 3, OldSize: 2, NewSize: 3, Added: []common.Address{accounts.address("C")}},
+		6: {EpochBlock: 6, OldSize: 3, NewSize: 2, Removed: []common.Address{accounts.address("A")}},
+	}
+	for range want {
+		select {
+		case ev := <-events:
+			if !reflect.DeepEqual(ev, want[ev.EpochBlock]) {
+				t.Errorf("event mismatch: have %+v, want %+v", ev, want[ev.EpochBlock])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the validator set size change events")
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
 func TestSaveAndLoad(t *testing.T) {
 	snap := &Snapshot{
 		Epoch:  5,
@@ -456,3 +581,61 @@ func TestSaveAndLoad(t *testing.T) {
 		t.Errorf("validator set mismatch: have %v, want %v", snap1.ValSet, snap.ValSet)
 	}
 }
+
+func TestSaveAndLoad_ProposerSeed(t *testing.T) {
+	seed := common.HexToHash("0xabcdef")
+	policy := istanbul.NewRoundRobinProposerPolicy()
+	policy.Seed = &seed
+	snap := &Snapshot{
+		Epoch:  5,
+		Number: 10,
+		Hash:   common.HexToHash("1234567890"),
+		ValSet: validator.NewSet([]common.Address{
+			common.StringToAddress("1234567894"),
+			common.StringToAddress("1234567895"),
+			common.StringToAddress("1234567896"),
+		}, policy),
+	}
+	db := rawdb.NewMemoryDatabase()
+	if err := snap.store(db); err != nil {
+		t.Errorf("store snapshot failed: %v", err)
+	}
+
+	snap1, err := loadSnapshot(snap.Epoch, db, snap.Hash)
+	if err != nil {
+		t.Fatalf("load snapshot failed: %v", err)
+	}
+	if have := snap1.ValSet.Policy().Seed; have == nil || *have != seed {
+		t.Errorf("proposer seed mismatch: have %v, want %v", have, seed)
+	}
+	loadedPolicy := snap1.ValSet.Policy()
+	if have, want := loadedPolicy.ProposerOrder(snap1.ValSet), policy.ProposerOrder(snap.ValSet); !reflect.DeepEqual(have, want) {
+		t.Errorf("proposer order mismatch: have %v, want %v", have, want)
+	}
+}
+
+func TestSaveAndLoad_StickyRoundRobinFallback(t *testing.T) {
+	policy := istanbul.NewStickyProposerPolicy()
+	policy.RoundRobinFallback = true
+	snap := &Snapshot{
+		Epoch:  5,
+		Number: 10,
+		Hash:   common.HexToHash("1234567890"),
+		ValSet: validator.NewSet([]common.Address{
+			common.StringToAddress("1234567894"),
+			common.StringToAddress("1234567895"),
+		}, policy),
+	}
+	db := rawdb.NewMemoryDatabase()
+	if err := snap.store(db); err != nil {
+		t.Errorf("store snapshot failed: %v", err)
+	}
+
+	snap1, err := loadSnapshot(snap.Epoch, db, snap.Hash)
+	if err != nil {
+		t.Fatalf("load snapshot failed: %v", err)
+	}
+	if !snap1.ValSet.Policy().RoundRobinFallback {
+		t.Errorf("round robin fallback of the sticky policy not restored")
+	}
+}
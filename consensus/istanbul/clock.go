@@ -0,0 +1,29 @@
+package istanbul
+
+import "time"
+
+// Clock provides the time to the timing paths of the engine: the block timestamps and period, the future
+// blocks and the round change timeouts. The engine uses the wall clock unless the Config sets another
+// Clock, e.g. a fake one so that tests control the time.
+type Clock interface {
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc waits for the duration to elapse and then calls f
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is returned by Clock.AfterFunc
+type Timer interface {
+	// Stop prevents the timer from firing, it returns false if the timer already fired or was stopped
+	Stop() bool
+}
+
+// RealClock is the Clock of the wall clock
+type RealClock struct{}
+
+func (RealClock) Now() time.Time { return time.Now() }
+
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (RealClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
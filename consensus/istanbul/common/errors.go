@@ -17,6 +17,10 @@ var (
 	// ErrUnauthorized is returned if a header is signed by a non authorized entity.
 	ErrUnauthorized = errors.New("unauthorized")
 
+	// ErrTooFewLiveValidators is returned if a block can't be proposed as less than the configured minimum
+	// number of validators are live.
+	ErrTooFewLiveValidators = errors.New("too few live validators")
+
 	// ErrInvalidDifficulty is returned if the difficulty of a block is not 1
 	ErrInvalidDifficulty = errors.New("invalid difficulty")
 
@@ -17,25 +17,58 @@
 package istanbul
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
 	"math/big"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/naoina/toml"
+	"github.com/kisexp/xdchain/common"
 )
 
 type ProposerPolicyId uint64
 
+// Names of the consensus algorithms returned by Config.ConsensusAlgoAt
+const (
+	ConsensusAlgoIstanbul = "istanbul" // qbft fork is not configured
+	ConsensusAlgoIBFT     = "ibft"     // qbft fork is configured but not yet reached
+	ConsensusAlgoQBFT     = "qbft"
+)
+
 const (
 	RoundRobin ProposerPolicyId = iota
 	Sticky
 )
 
+// IsKnown checks if the id identifies one of the supported proposer policies
+func (id ProposerPolicyId) IsKnown() bool {
+	switch id {
+	case RoundRobin, Sticky:
+		return true
+	default:
+		return false
+	}
+}
+
 // ProposerPolicy represents the Validator Proposer Policy
 type ProposerPolicy struct {
-	Id         ProposerPolicyId    // Could be RoundRobin or Sticky
-	By         ValidatorSortByFunc // func that defines how the ValidatorSet should be sorted
-	registry   []ValidatorSet      // Holds the ValidatorSet for a given block height
-	registryMU *sync.Mutex         // Mutex to lock access to changes to Registry
+	Id                 ProposerPolicyId         // Could be RoundRobin or Sticky
+	By                 ValidatorSortByFunc      // func that defines how the ValidatorSet should be sorted
+	Seed               *common.Hash             // Optional seed, when set RoundRobin follows a permutation of the sorted validators derived from it
+	RoundRobinFallback bool                     // Sticky only, when set the backup proposers follow the RoundRobin order once the proposer failed
+	ProposerCooldown   uint64                   // RoundRobin only, number of last proposers skipped by the rotation, ignored if not less than the number of validators
+	registry           []registeredValidatorSet // Holds the ValidatorSet for a given block height
+	registryMU         *sync.Mutex              // Mutex to lock access to changes to Registry
+	observer           *selectionObserver       // Notified of the proposers selected by the engine, shared by the copies of the policy
+
+	orderedValidators map[uint64][]common.Address // Proposer order of the last ValidatorSet registered at recorded block heights
+	proposers         map[uint64]common.Address   // Proposers of the blocks recorded by RecordProposerAt
+	registryGuard     registryGuard               // Reports the size of the registry, set by SetRegistryGuard
 }
 
 // NewRoundRobinProposerPolicy returns a RoundRobin ProposerPolicy with ValidatorSortByString as default sort function
@@ -53,56 +86,296 @@ func NewProposerPolicy(id ProposerPolicyId) *ProposerPolicy {
 }
 
 func NewProposerPolicyByIdAndSortFunc(id ProposerPolicyId, by ValidatorSortByFunc) *ProposerPolicy {
-	return &ProposerPolicy{Id: id, By: by, registryMU: new(sync.Mutex)}
+	return &ProposerPolicy{Id: id, By: by, registryMU: new(sync.Mutex), observer: new(selectionObserver)}
+}
+
+// NewProposerPolicyFromString returns the ProposerPolicy named by s, "roundrobin" or "sticky" regardless of
+// the case, with ValidatorSortByString as default sort function, e.g. to pick the policy of a test node from
+// an environment variable or a flag. There is no "weighted" policy: the ValidatorWeights only weigh the
+// quorum votes, the proposers are selected by one of the other policies.
+func NewProposerPolicyFromString(s string) (*ProposerPolicy, error) {
+	switch strings.ToLower(s) {
+	case "roundrobin":
+		return NewRoundRobinProposerPolicy(), nil
+	case "sticky":
+		return NewStickyProposerPolicy(), nil
+	case "weighted":
+		return nil, fmt.Errorf("unsupported proposer policy %q, the validator weights don't apply to the proposer selection", s)
+	default:
+		return nil, fmt.Errorf("unknown proposer policy %q, expected roundrobin or sticky", s)
+	}
+}
+
+// proposerPolicyInitMu guards the lazy initialization of the policies built without constructor
+var proposerPolicyInitMu sync.Mutex
+
+// ensureInitialized gives a policy built as a struct literal, rather than by a constructor, the
+// ValidatorSortByString sort function if it has none, and its registry mutex and observer
+func (p *ProposerPolicy) ensureInitialized() {
+	proposerPolicyInitMu.Lock()
+	if p.registryMU == nil {
+		p.registryMU = new(sync.Mutex)
+	}
+	if p.observer == nil {
+		p.observer = new(selectionObserver)
+	}
+	proposerPolicyInitMu.Unlock()
+
+	// the sort function is guarded by the registry lock, like in Use
+	p.registryMU.Lock()
+	defer p.registryMU.Unlock()
+	if p.By == nil {
+		p.By = ValidatorSortByString()
+	}
+}
+
+// ProposerSelectionObserver is called with the height, the round and the address of each proposer selected
+type ProposerSelectionObserver func(height, round uint64, proposer common.Address)
+
+type selectionObserver struct {
+	mu       sync.RWMutex
+	observer ProposerSelectionObserver
 }
 
 type proposerPolicyToml struct {
-	Id ProposerPolicyId
+	Id                 ProposerPolicyId
+	SortBy             string       `toml:",omitempty"`
+	Seed               *common.Hash `toml:",omitempty"`
+	RoundRobinFallback bool         `toml:",omitempty"`
+	ProposerCooldown   uint64       `toml:",omitempty"`
+}
+
+// validatorSortByNames holds the ValidatorSortByFuncs which can be configured, by name
+var (
+	validatorSortByNamesMu sync.RWMutex
+	validatorSortByNames   = map[string]ValidatorSortByFunc{
+		"string":     ValidatorSortByString(),
+		"stringDesc": ValidatorSortByStringDesc(),
+		"byte":       ValidatorSortByByte(),
+	}
+)
+
+// RegisterValidatorSortByFunc makes the ValidatorSortByFunc available to the configs under the given name,
+// e.g. to order the validators by voting power from the qbft fork. All the nodes of the network must
+// register the same function under the name. An error is returned if the name is already registered.
+func RegisterValidatorSortByFunc(name string, by ValidatorSortByFunc) error {
+	if name == "" || by == nil {
+		return errors.New("validator sort function requires a name and a function")
+	}
+	validatorSortByNamesMu.Lock()
+	defer validatorSortByNamesMu.Unlock()
+	if _, ok := validatorSortByNames[name]; ok {
+		return fmt.Errorf("validator sort function %q already registered", name)
+	}
+	validatorSortByNames[name] = by
+	return nil
 }
 
-func (p *ProposerPolicy) MarshalTOML() ([]byte, error) {
-	pp := &proposerPolicyToml{Id: p.Id}
-	return toml.Marshal(pp)
+// validatorSortByFunc returns the ValidatorSortByFunc registered under the given name
+func validatorSortByFunc(name string) (ValidatorSortByFunc, error) {
+	validatorSortByNamesMu.RLock()
+	defer validatorSortByNamesMu.RUnlock()
+	by, ok := validatorSortByNames[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown validator sort function %q", name)
+	}
+	return by, nil
+}
+
+// validatorSortByName returns the name of the ValidatorSortByFunc, the empty name for the default
+// ValidatorSortByString. Functions are identified by their code, so all the functions returned by a
+// ValidatorSortByFunc constructor have the same name.
+func validatorSortByName(by ValidatorSortByFunc) (string, error) {
+	if by == nil {
+		return "", nil
+	}
+	code := reflect.ValueOf(by).Pointer()
+	validatorSortByNamesMu.RLock()
+	defer validatorSortByNamesMu.RUnlock()
+	for name, known := range validatorSortByNames {
+		if reflect.ValueOf(known).Pointer() == code {
+			if name == "string" {
+				return "", nil
+			}
+			return name, nil
+		}
+	}
+	return "", errors.New("custom validator sort function can't be marshalled")
 }
 
-func (p *ProposerPolicy) UnmarshalTOML(input []byte) error {
+// MarshalTOML marshals the policy as a table. An error is returned if the policy uses a custom
+// ValidatorSortByFunc, which can't be restored.
+func (p *ProposerPolicy) MarshalTOML() (interface{}, error) {
+	sortBy, err := validatorSortByName(p.By)
+	if err != nil {
+		return nil, err
+	}
+	return &proposerPolicyToml{Id: p.Id, SortBy: sortBy, Seed: p.Seed, RoundRobinFallback: p.RoundRobinFallback, ProposerCooldown: p.ProposerCooldown}, nil
+}
+
+// UnmarshalTOML unmarshals the policy from a table
+func (p *ProposerPolicy) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	var pp proposerPolicyToml
-	err := toml.Unmarshal(input, &pp)
+	err := unmarshal(&pp)
 	if err != nil {
 		return err
 	}
+	if !pp.Id.IsKnown() {
+		return fmt.Errorf("unknown proposer policy id %d", pp.Id)
+	}
+	by := ValidatorSortByString()
+	if pp.SortBy != "" {
+		if by, err = validatorSortByFunc(pp.SortBy); err != nil {
+			return err
+		}
+	}
 	p.Id = pp.Id
-	p.By = ValidatorSortByString()
+	p.Seed = pp.Seed
+	p.RoundRobinFallback = pp.RoundRobinFallback
+	p.ProposerCooldown = pp.ProposerCooldown
+	p.By = by
+	p.ensureInitialized()
 	return nil
 }
 
-// Use sets the ValidatorSortByFunc for the given ProposerPolicy and sorts the validatorSets according to it
-func (p *ProposerPolicy) Use(v ValidatorSortByFunc) {
-	p.By = v
+// ProposerOrder returns the addresses of the validators of the set in the order the proposer role
+// rotates through them: the sorted order, or the permutation derived from Seed for RoundRobin and for the
+// backup proposers of Sticky with RoundRobinFallback.
+func (p *ProposerPolicy) ProposerOrder(valSet ValidatorSet) []common.Address {
+	validators := valSet.List()
+	if (p.Id == RoundRobin || p.RoundRobinFallback) && p.Seed != nil {
+		validators = ShuffleValidators(validators, *p.Seed)
+	}
+	addrs := make([]common.Address, len(validators))
+	for i, val := range validators {
+		addrs[i] = val.Address()
+	}
+	return addrs
+}
+
+// ProposerPolicyInfo describes the proposer policy in use by an engine, e.g. to report it through the RPC
+type ProposerPolicyInfo struct {
+	Id                 ProposerPolicyId `json:"id"`
+	Name               string           `json:"name"`   // roundrobin or sticky, as accepted by NewProposerPolicyFromString
+	SortBy             string           `json:"sortBy"` // name the ValidatorSortByFunc is registered under, custom if it isn't
+	Seed               *common.Hash     `json:"seed,omitempty"`
+	RoundRobinFallback bool             `json:"roundRobinFallback"`
+	ProposerCooldown   uint64           `json:"proposerCooldown"`
+}
+
+// Info returns the id and the parameters of the policy at the time of the call, so the ValidatorSortByFunc
+// switched to at the qbft fork is reported once the fork is reached
+func (p *ProposerPolicy) Info() ProposerPolicyInfo {
+	info := ProposerPolicyInfo{Id: p.Id, Seed: p.Seed, RoundRobinFallback: p.RoundRobinFallback, ProposerCooldown: p.ProposerCooldown}
+	switch p.Id {
+	case RoundRobin:
+		info.Name = "roundrobin"
+	case Sticky:
+		info.Name = "sticky"
+	default:
+		info.Name = "unknown"
+	}
+	sortBy, err := validatorSortByName(p.SortBy())
+	switch {
+	case err != nil:
+		info.SortBy = "custom"
+	case sortBy == "":
+		info.SortBy = "string"
+	default:
+		info.SortBy = sortBy
+	}
+	return info
+}
+
+// ErrNilValidatorSortByFunc is returned by Use when no ValidatorSortByFunc is given
+var ErrNilValidatorSortByFunc = errors.New("nil validator sort function")
 
-	for _, validatorSet := range p.registry {
-		validatorSet.SortValidators()
+// Use sets the ValidatorSortByFunc for the given ProposerPolicy and sorts the validatorSets according to it.
+// The policy is left unchanged if the ValidatorSortByFunc is nil.
+func (p *ProposerPolicy) Use(v ValidatorSortByFunc) error {
+	if v == nil {
+		return ErrNilValidatorSortByFunc
 	}
+	p.ensureInitialized()
+	p.registryMU.Lock()
+	defer p.registryMU.Unlock()
+
+	p.By = v
+	for _, registered := range p.registry {
+		registered.valSet.SortValidatorsBy(v)
+	}
+	return nil
 }
 
-// RegisterValidatorSet stores the given ValidatorSet in the policy registry
-func (p *ProposerPolicy) RegisterValidatorSet(valSet ValidatorSet) {
+// SortBy returns the ValidatorSortByFunc of the policy, read under the registry lock so that it is never
+// seen half way through a switch by Use
+func (p *ProposerPolicy) SortBy() ValidatorSortByFunc {
+	p.ensureInitialized()
 	p.registryMU.Lock()
 	defer p.registryMU.Unlock()
+	return p.By
+}
+
+// registeredValidatorSet is a ValidatorSet of the policy registry and the block height it was registered for
+type registeredValidatorSet struct {
+	number uint64
+	valSet ValidatorSet
+}
+
+// RegisterValidatorSet stores the given ValidatorSet in the policy registry for the block height. The
+// ValidatorSet replaces the one already registered for the height, if any, so that the registry holds a
+// single ValidatorSet per height when blocks are processed again, e.g. during a reorg.
+func (p *ProposerPolicy) RegisterValidatorSet(number uint64, valSet ValidatorSet) {
+	p.ensureInitialized()
+	p.registryMU.Lock()
+	defer p.registryMU.Unlock()
+
+	for i := range p.registry {
+		if p.registry[i].number == number {
+			p.registry[i].valSet = valSet
+			return
+		}
+	}
+	p.registry = append(p.registry, registeredValidatorSet{number: number, valSet: valSet})
+	p.checkRegistrySize()
+}
+
+// SetSelectionObserver registers the observer of the proposers selected by the engine, replacing any
+// registered one. A nil observer unregisters it.
+//
+// The observer is called in its own goroutine so that it doesn't hold up the consensus, the calls may
+// then be run in any order.
+func (p *ProposerPolicy) SetSelectionObserver(observer ProposerSelectionObserver) {
+	p.ensureInitialized()
+	p.observer.mu.Lock()
+	defer p.observer.mu.Unlock()
+
+	p.observer.observer = observer
+}
+
+// NotifySelection notifies the registered observer, if any, of the proposer selected for the round of
+// the block height without waiting for it to return
+func (p *ProposerPolicy) NotifySelection(height, round uint64, proposer common.Address) {
+	if p == nil || p.observer == nil {
+		return
+	}
+	p.observer.mu.RLock()
+	observer := p.observer.observer
+	p.observer.mu.RUnlock()
 
-	if len(p.registry) == 0 {
-		p.registry = []ValidatorSet{valSet}
-	} else {
-		p.registry = append(p.registry, valSet)
+	if observer != nil {
+		go observer(height, round, proposer)
 	}
 }
 
 // ClearRegistry removes any ValidatorSet from the ProposerPolicy registry
 func (p *ProposerPolicy) ClearRegistry() {
+	p.ensureInitialized()
 	p.registryMU.Lock()
 	defer p.registryMU.Unlock()
 
 	p.registry = nil
+	p.checkRegistrySize()
 }
 
 type Config struct {
@@ -110,6 +383,8 @@ type Config struct {
 	RequestTimeout         uint64          `toml:",omitempty"` // The timeout for each Istanbul round in milliseconds.
 	// 出块时间 (两个连续块的时间戳之间的默认最小差异（以秒为单位）)
 	BlockPeriod            uint64          `toml:",omitempty"` // Default minimum difference between two consecutive block's timestamps in second
+	BlockPeriodMillis      uint64          `toml:",omitempty"` // Minimum time between two consecutive blocks in milliseconds, overrides BlockPeriod if set
+	StrictBlockPeriod      bool            `toml:",omitempty"` // Reject the blocks whose timestamp is closer to their parent's than the block period, rounding BlockPeriodMillis up to the second instead of down, requires BlockPeriodMillis
 	ProposerPolicy         *ProposerPolicy `toml:",omitempty"` // The policy for proposer selection
 	// 检查点和重置未决投票之前的块数
 	Epoch                  uint64          `toml:",omitempty"` // The number of blocks after which to checkpoint and reset the pending votes
@@ -118,16 +393,166 @@ type Config struct {
 	// 从当前时间开始，块被视为未来块之前允许的最长时间，以秒为单位。这允许节点稍微不同步而不会收到“未来挖掘太远”消息。默认值为 0。
 	AllowedFutureBlockTime uint64          `toml:",omitempty"` // Max time (in seconds) from current time allowed for blocks, before they're considered future blocks
 	TestQBFTBlock          *big.Int        `toml:",omitempty"` // Fork block at which block confirmations are done using qbft consensus instead of ibft
+	PersistValidatorSets   bool            `toml:",omitempty"` // Store the ValidatorSets of the ProposerPolicy registry to the database to warm it up on restart
+	MinValidators          uint64          `toml:",omitempty"` // Minimum number of live validators, including this node, required to propose blocks. No minimum if 0
+	ProposerRegistryCap    uint64          `toml:",omitempty"` // Soft cap on the number of ValidatorSets registered to the ProposerPolicy, a warning is logged above it. No cap if 0
+	PruneProposerRegistry  bool            `toml:",omitempty"` // Prune the oldest ValidatorSets of the ProposerPolicy registry once ProposerRegistryCap is exceeded
+	StrictProposerCheck    bool            `toml:",omitempty"` // Reject the proposed blocks not created by a proposer the ProposerPolicy expects for their height up to their round, or whose expected proposers are unknown
+	QBFTValidatorSortBy    string          `toml:",omitempty"` // Name of the ValidatorSortByFunc the ProposerPolicy uses from TestQBFTBlock on, "byte" if not set
+	// Weights of the votes of the validators in the weighted quorum used from TestQBFTBlock on, the
+	// validators not listed weigh 1
+	ValidatorWeights map[common.Address]uint64 `toml:",omitempty"`
+	// AllowedFutureBlockTime to use from given block heights onwards, blocks before the first
+	// scheduled height use AllowedFutureBlockTime
+	AllowedFutureBlockTimeSchedule []AllowedFutureBlockTimeTransition `toml:",omitempty"`
+	// Clock the engine takes the time from, the wall clock if not set
+	Clock Clock `toml:"-"`
+}
+
+// AllowedFutureBlockTimeTransition schedules the allowed future block time to use from a block height
+type AllowedFutureBlockTimeTransition struct {
+	Block                  *big.Int // Block height from which the value applies
+	AllowedFutureBlockTime uint64   // Max time (in seconds) from current time allowed for blocks from Block onwards
+}
+
+// DefaultConfig returns a new Config holding the default settings.
+//
+// Each call returns a fresh instance with its own ProposerPolicy, so validator sets registered
+// against one default config are not seen by the others.
+func DefaultConfig() *Config {
+	return &Config{
+		RequestTimeout:         10000,
+		BlockPeriod:            1,
+		ProposerPolicy:         NewRoundRobinProposerPolicy(),
+		Epoch:                  30000,
+		Ceil2Nby3Block:         big.NewInt(0),
+		AllowedFutureBlockTime: 0,
+		TestQBFTBlock:          big.NewInt(0),
+	}
+}
+
+// ErrNoProposerPolicy is returned by Config.Validate if the config has no ProposerPolicy
+var ErrNoProposerPolicy = errors.New("istanbul proposer policy is not configured")
+
+// Validate checks that the config can be used by the engine. The fork blocks are checked by ValidateForks
+func (c *Config) Validate() error {
+	if c.ProposerPolicy == nil {
+		return ErrNoProposerPolicy
+	}
+	if !c.ProposerPolicy.Id.IsKnown() {
+		return fmt.Errorf("unknown proposer policy id %d", c.ProposerPolicy.Id)
+	}
+	if _, err := c.QBFTValidatorSortByFunc(); err != nil {
+		return err
+	}
+	if c.BlockPeriodMillis > 0 && c.BlockPeriod > 0 && c.BlockPeriod*1000 != c.BlockPeriodMillis {
+		return fmt.Errorf("BlockPeriod of %ds conflicts with BlockPeriodMillis of %dms, only one of them must be set", c.BlockPeriod, c.BlockPeriodMillis)
+	}
+	if c.StrictBlockPeriod && c.BlockPeriodMillis == 0 {
+		return errors.New("StrictBlockPeriod requires BlockPeriodMillis, whole second block periods are never rounded")
+	}
+	total := new(big.Int)
+	for validator, weight := range c.ValidatorWeights {
+		if weight == 0 {
+			return fmt.Errorf("zero weight of validator %s, remove the validator instead", validator.Hex())
+		}
+		total.Add(total, new(big.Int).SetUint64(weight))
+	}
+	if !total.IsUint64() {
+		return fmt.Errorf("total of the validator weights %v overflows", total)
+	}
+	if c.MinValidators > 0 {
+		// the quorum rule in use before the Ceil2Nby3Block fork, then after it
+		for _, blockNumber := range []*big.Int{nil, c.Ceil2Nby3Block} {
+			if quorum := c.QuorumSize(int(c.MinValidators), blockNumber); uint64(quorum) >= c.MinValidators {
+				return fmt.Errorf("MinValidators %d doesn't tolerate any faulty validator, the quorum of %d validators is %d", c.MinValidators, c.MinValidators, quorum)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateForks checks that the Ceil(2N/3) quorum rule is active wherever qbft consensus is: with the 2F+1
+// quorum, two quorums of some validator set sizes (e.g. 3 out of 5) only share F validators, which may all be
+// faulty, so the blocks qbft finalizes could conflict. The valid combinations of the fork blocks are:
+//   - neither TestQBFTBlock nor Ceil2Nby3Block, istanbul consensus with the 2F+1 quorum at all heights
+//   - Ceil2Nby3Block only, ibft consensus with the Ceil(2N/3) quorum from Ceil2Nby3Block on
+//   - both, with Ceil2Nby3Block not after TestQBFTBlock
+//
+// It isn't part of Validate so that the nodes of the existing chains configured otherwise still start, the
+// forks being checked when a new genesis is written.
+func (c *Config) ValidateForks() error {
+	if c.TestQBFTBlock == nil {
+		return nil
+	}
+	if c.Ceil2Nby3Block == nil {
+		return fmt.Errorf("TestQBFTBlock %v requires Ceil2Nby3Block, qbft consensus can't use the 2F+1 quorum", c.TestQBFTBlock)
+	}
+	if c.Ceil2Nby3Block.Cmp(c.TestQBFTBlock) > 0 {
+		return fmt.Errorf("Ceil2Nby3Block %v is after TestQBFTBlock %v, qbft consensus can't use the 2F+1 quorum", c.Ceil2Nby3Block, c.TestQBFTBlock)
+	}
+	return nil
 }
 
-var DefaultConfig = &Config{
-	RequestTimeout:         10000,
-	BlockPeriod:            1,
-	ProposerPolicy:         NewRoundRobinProposerPolicy(),
-	Epoch:                  30000,
-	Ceil2Nby3Block:         big.NewInt(0),
-	AllowedFutureBlockTime: 0,
-	TestQBFTBlock:          big.NewInt(0),
+// QBFTValidatorSortByFunc returns the ValidatorSortByFunc used by the ProposerPolicy once qbft consensus
+// is active, ValidatorSortByByte if QBFTValidatorSortBy isn't set
+func (c *Config) QBFTValidatorSortByFunc() (ValidatorSortByFunc, error) {
+	if c.QBFTValidatorSortBy == "" {
+		return ValidatorSortByByte(), nil
+	}
+	return validatorSortByFunc(c.QBFTValidatorSortBy)
+}
+
+// BlockPeriodDuration returns the minimum time between two consecutive blocks, BlockPeriodMillis if set,
+// BlockPeriod otherwise
+func (c *Config) BlockPeriodDuration() time.Duration {
+	if c.BlockPeriodMillis > 0 {
+		return time.Duration(c.BlockPeriodMillis) * time.Millisecond
+	}
+	return time.Duration(c.BlockPeriod) * time.Second
+}
+
+// MinBlockTimestampGap returns the minimum difference between the timestamps of two consecutive blocks,
+// in seconds. It's BlockPeriod unless BlockPeriodMillis is set, the period is then rounded down to the
+// second since the timestamps have second granularity, e.g. blocks 500ms apart may share their timestamp.
+// With StrictBlockPeriod the period is rounded up instead, so that no block is closer to its parent than
+// the block period.
+func (c *Config) MinBlockTimestampGap() uint64 {
+	if c.BlockPeriodMillis > 0 {
+		if c.StrictBlockPeriod {
+			return (c.BlockPeriodMillis + 999) / 1000
+		}
+		return c.BlockPeriodMillis / 1000
+	}
+	return c.BlockPeriod
+}
+
+// HasMinValidators checks if enough validators are live for the node to propose blocks, which is always
+// the case if MinValidators isn't set
+func (c *Config) HasMinValidators(liveValidators int) bool {
+	return liveValidators >= 0 && uint64(liveValidators) >= c.MinValidators
+}
+
+// GetClock returns the Clock of the config, RealClock if none is set
+func (c *Config) GetClock() Clock {
+	if c.Clock == nil {
+		return RealClock{}
+	}
+	return c.Clock
+}
+
+// policyInitMu guards the lazy initialization of the ProposerPolicy of the configs
+var policyInitMu sync.Mutex
+
+// Policy returns the ProposerPolicy of the config. A config without ProposerPolicy, such as one decoded
+// from a TOML without policy section, is given a RoundRobin ProposerPolicy on first access.
+func (c *Config) Policy() *ProposerPolicy {
+	policyInitMu.Lock()
+	defer policyInitMu.Unlock()
+	if c.ProposerPolicy == nil {
+		c.ProposerPolicy = NewRoundRobinProposerPolicy()
+	}
+	return c.ProposerPolicy
 }
 
 // QBFTBlockNumber returns the qbftBlock fork block number, returns -1 if qbftBlock is not defined
@@ -154,3 +579,296 @@ func (c *Config) IsQBFTConsensusAt(blockNumber *big.Int) bool {
 	}
 	return false
 }
+
+// IsCeil2Nby3Block checks if the Ceil(2N/3) confirmation rule is active for the block at the given height.
+//
+// Once active, the quorum size is Ceil(2N/3) of the N validators instead of 2F+1, where F = (N-1)/3.
+// The rule is never active if Ceil2Nby3Block is not defined and always active if it is set to 0,
+// otherwise a nil block number is considered to be before the fork.
+func (c *Config) IsCeil2Nby3Block(blockNumber *big.Int) bool {
+	if c.Ceil2Nby3Block == nil {
+		return false
+	}
+
+	if c.Ceil2Nby3Block.Sign() == 0 {
+		return true
+	}
+
+	if blockNumber == nil {
+		return false
+	}
+	return blockNumber.Cmp(c.Ceil2Nby3Block) >= 0
+}
+
+// QuorumSize returns the number of confirmations required from the given number of validators to move from
+// one state to the next for the block at the given height: Ceil(2N/3) once the Ceil2Nby3Block rule is active,
+// 2F+1 before, where F = Ceil(N/3)-1.
+func (c *Config) QuorumSize(validatorCount int, blockNumber *big.Int) int {
+	if c.IsCeil2Nby3Block(blockNumber) {
+		return (2*validatorCount + 2) / 3
+	}
+	f := (validatorCount+2)/3 - 1
+	return 2*f + 1
+}
+
+// CanReachQuorum checks if the live validators, out of the validator set of totalValidators, are enough to
+// reach the quorum of the block at the given height, i.e. if the network can make progress. It never can
+// with an empty validator set.
+func (c *Config) CanReachQuorum(liveValidators, totalValidators int, blockNumber *big.Int) bool {
+	if totalValidators <= 0 || liveValidators <= 0 {
+		return false
+	}
+	if liveValidators > totalValidators {
+		liveValidators = totalValidators
+	}
+	return liveValidators >= c.QuorumSize(totalValidators, blockNumber)
+}
+
+// VoteWeights returns the weight of the vote of each of the validators for the block at the given height:
+// their ValidatorWeights, or 1 if not listed, once qbft consensus is enabled, 1 before.
+func (c *Config) VoteWeights(validators []common.Address, blockNumber *big.Int) map[common.Address]uint64 {
+	weighted := c.isQBFTConsensusAt(blockNumber)
+	weights := make(map[common.Address]uint64, len(validators))
+	for _, validator := range validators {
+		weight, ok := c.ValidatorWeights[validator]
+		if !weighted || !ok {
+			weight = 1
+		}
+		weights[validator] = weight
+	}
+	return weights
+}
+
+// WeightedQuorumThreshold returns the total weight of the votes required from the validators of the
+// given weights to move from one state to the next for the block at the given height. Once qbft consensus
+// is enabled, it is the weighted equivalent of Ceil(2N/3): Ceil(2W/3) of the total weight W. Before, every
+// vote counts for 1 whatever the weights and it is the QuorumSize of the validators. It is 0 without any
+// validator.
+//
+// The threshold is capped to the maximum uint64 if it overflows, which Validate prevents for the
+// ValidatorWeights of the config.
+func (c *Config) WeightedQuorumThreshold(weights map[common.Address]uint64, blockNumber *big.Int) uint64 {
+	if len(weights) == 0 {
+		return 0
+	}
+	if !c.isQBFTConsensusAt(blockNumber) {
+		return uint64(c.QuorumSize(len(weights), blockNumber))
+	}
+	total := new(big.Int)
+	for _, weight := range weights {
+		total.Add(total, new(big.Int).SetUint64(weight))
+	}
+	threshold := total.Mul(total, big.NewInt(2))
+	threshold.Add(threshold, big.NewInt(2)).Div(threshold, big.NewInt(3))
+	if !threshold.IsUint64() {
+		return math.MaxUint64
+	}
+	return threshold.Uint64()
+}
+
+// isQBFTConsensusAt is like IsQBFTConsensusAt but a nil block number is the height before any fork
+func (c *Config) isQBFTConsensusAt(blockNumber *big.Int) bool {
+	if blockNumber == nil {
+		return c.TestQBFTBlock != nil && c.TestQBFTBlock.Sign() == 0
+	}
+	return c.IsQBFTConsensusAt(blockNumber)
+}
+
+// IsEpochBlock checks if the block at the given height is an epoch checkpoint, at which the pending votes are reset.
+//
+// The genesis block is a checkpoint. There is no checkpoint if Epoch is 0.
+func (c *Config) IsEpochBlock(blockNumber uint64) bool {
+	if c.Epoch == 0 {
+		return false
+	}
+	return blockNumber%c.Epoch == 0
+}
+
+// NextEpochBlock returns the height of the first epoch checkpoint after the block at the given height, at
+// which the votes pending at that block are reset. It returns false if there is no checkpoint, i.e. Epoch is 0.
+func (c *Config) NextEpochBlock(blockNumber uint64) (uint64, bool) {
+	if c.Epoch == 0 {
+		return 0, false
+	}
+	return (blockNumber/c.Epoch + 1) * c.Epoch, true
+}
+
+// AllowedFutureBlockTimeAt returns the max time (in seconds) from current time allowed for the block at the
+// given height before it's considered a future block.
+//
+// The value of the scheduled transition with the highest block height not after blockNumber is used,
+// AllowedFutureBlockTime is returned if there is no such transition or if blockNumber is nil.
+func (c *Config) AllowedFutureBlockTimeAt(blockNumber *big.Int) uint64 {
+	allowedFutureBlockTime := c.AllowedFutureBlockTime
+	if blockNumber == nil {
+		return allowedFutureBlockTime
+	}
+	var activeBlock *big.Int
+	for _, transition := range c.AllowedFutureBlockTimeSchedule {
+		if transition.Block == nil || transition.Block.Cmp(blockNumber) > 0 {
+			continue
+		}
+		if activeBlock == nil || transition.Block.Cmp(activeBlock) >= 0 {
+			activeBlock = transition.Block
+			allowedFutureBlockTime = transition.AllowedFutureBlockTime
+		}
+	}
+	return allowedFutureBlockTime
+}
+
+// NextTransition returns the next consensus parameter transition after the block at the given height: the
+// name of the setting which schedules it, TestQBFTBlock (which also switches the proposer policy to
+// QBFTValidatorSortBy), Ceil2Nby3Block or AllowedFutureBlockTimeSchedule, and the height it is installed at.
+// The transitions scheduled at the same height are reported in that order, the first one only.
+//
+// A nil block number is the height before the genesis block, so that the transitions at the genesis are
+// reported. It returns false if no transition is scheduled after blockNumber.
+func (c *Config) NextTransition(blockNumber *big.Int) (string, *big.Int, bool) {
+	var (
+		name    string
+		atBlock *big.Int
+	)
+	consider := func(transitionName string, block *big.Int) {
+		if block == nil || (blockNumber != nil && block.Cmp(blockNumber) <= 0) {
+			return
+		}
+		if atBlock == nil || block.Cmp(atBlock) < 0 {
+			name, atBlock = transitionName, block
+		}
+	}
+	consider("TestQBFTBlock", c.TestQBFTBlock)
+	consider("Ceil2Nby3Block", c.Ceil2Nby3Block)
+	for _, transition := range c.AllowedFutureBlockTimeSchedule {
+		consider("AllowedFutureBlockTimeSchedule", transition.Block)
+	}
+	if atBlock == nil {
+		return "", nil, false
+	}
+	return name, new(big.Int).Set(atBlock), true
+}
+
+// ConsensusAlgoAt returns the name of the consensus algorithm used to confirm the block at the given height.
+//
+// It returns ConsensusAlgoQBFT once the qbft fork is reached, ConsensusAlgoIBFT prior to the fork and
+// ConsensusAlgoIstanbul if the qbft fork is not defined at all
+func (c *Config) ConsensusAlgoAt(blockNumber *big.Int) string {
+	if c.TestQBFTBlock == nil {
+		return ConsensusAlgoIstanbul
+	}
+	if c.IsQBFTConsensusAt(blockNumber) {
+		return ConsensusAlgoQBFT
+	}
+	return ConsensusAlgoIBFT
+}
+
+// Names of the quorum rules reported in ConsensusParams
+const (
+	QuorumRule2FPlus1   = "2F+1"       // Ceil2Nby3Block fork is not reached
+	QuorumRuleCeil2Nby3 = "Ceil(2N/3)" // Ceil2Nby3Block fork is reached
+)
+
+// ConsensusParams is a snapshot of the consensus parameters in effect for a block height
+type ConsensusParams struct {
+	BlockNumber            *big.Int `json:"blockNumber"`
+	BlockPeriodMillis      uint64   `json:"blockPeriodMillis"`      // Minimum time between two consecutive blocks
+	MinBlockTimestampGap   uint64   `json:"minBlockTimestampGap"`   // Minimum difference between the timestamps of two consecutive blocks, in seconds
+	RequestTimeout         uint64   `json:"requestTimeout"`         // Minimum timeout of a round in milliseconds
+	QuorumRule             string   `json:"quorumRule"`             // QuorumRule2FPlus1 or QuorumRuleCeil2Nby3
+	ConsensusAlgo          string   `json:"consensusAlgo"`          // As returned by ConsensusAlgoAt
+	Epoch                  uint64   `json:"epoch"`                  // Number of blocks between two checkpoints, 0 if no checkpoint
+	EpochBlock             bool     `json:"epochBlock"`             // Whether the block is a checkpoint
+	AllowedFutureBlockTime uint64   `json:"allowedFutureBlockTime"` // As returned by AllowedFutureBlockTimeAt
+}
+
+// ParamsAt returns the consensus parameters in effect for the block at the given height, a nil block
+// number is considered to be the genesis block
+func (c *Config) ParamsAt(blockNumber *big.Int) ConsensusParams {
+	if blockNumber == nil {
+		blockNumber = big.NewInt(0)
+	}
+	quorumRule := QuorumRule2FPlus1
+	if c.IsCeil2Nby3Block(blockNumber) {
+		quorumRule = QuorumRuleCeil2Nby3
+	}
+	return ConsensusParams{
+		BlockNumber:            new(big.Int).Set(blockNumber),
+		BlockPeriodMillis:      uint64(c.BlockPeriodDuration() / time.Millisecond),
+		MinBlockTimestampGap:   c.MinBlockTimestampGap(),
+		RequestTimeout:         c.RequestTimeout,
+		QuorumRule:             quorumRule,
+		ConsensusAlgo:          c.ConsensusAlgoAt(blockNumber),
+		Epoch:                  c.Epoch,
+		EpochBlock:             c.IsEpochBlock(blockNumber.Uint64()),
+		AllowedFutureBlockTime: c.AllowedFutureBlockTimeAt(blockNumber),
+	}
+}
+
+// configDigest holds the consensus-critical settings of a Config hashed by Config.Hash
+type configDigest struct {
+	Epoch               uint64
+	BlockPeriodMillis   uint64
+	StrictBlockPeriod   bool
+	ProposerPolicyId    uint64
+	ProposerSeed        []byte
+	RoundRobinFallback  bool
+	ProposerCooldown    uint64
+	QBFTValidatorSortBy string
+	TestQBFTBlock       forkDigest
+	Ceil2Nby3Block      forkDigest
+	ValidatorWeights    []validatorWeightDigest // sorted by validator address
+}
+
+type validatorWeightDigest struct {
+	Validator common.Address
+	Weight    uint64
+}
+
+// forkDigest tells an undefined fork block apart from the genesis one
+type forkDigest struct {
+	Defined bool
+	Block   uint64
+}
+
+func newForkDigest(block *big.Int) forkDigest {
+	if block == nil {
+		return forkDigest{}
+	}
+	return forkDigest{Defined: true, Block: block.Uint64()}
+}
+
+// Hash returns a digest of the consensus-critical settings of the config, which the nodes of a network
+// must agree on: Epoch, the block period and StrictBlockPeriod, the ProposerPolicy, QBFTValidatorSortBy,
+// the ValidatorWeights and the TestQBFTBlock and Ceil2Nby3Block forks. The settings local to the node,
+// such as RequestTimeout, AllowedFutureBlockTime or MinValidators, don't change it.
+//
+// The block period is hashed in milliseconds, so BlockPeriod and the equivalent BlockPeriodMillis give the
+// same hash. The sort function of the ProposerPolicy isn't hashed: the engine switches it with Use when
+// consensus starts and at the TestQBFTBlock fork, to the QBFTValidatorSortBy one from then on.
+func (c *Config) Hash() common.Hash {
+	digest := configDigest{
+		Epoch:               c.Epoch,
+		BlockPeriodMillis:   uint64(c.BlockPeriodDuration() / time.Millisecond),
+		StrictBlockPeriod:   c.StrictBlockPeriod,
+		QBFTValidatorSortBy: c.QBFTValidatorSortBy,
+		TestQBFTBlock:       newForkDigest(c.TestQBFTBlock),
+		Ceil2Nby3Block:      newForkDigest(c.Ceil2Nby3Block),
+	}
+	for validator, weight := range c.ValidatorWeights {
+		digest.ValidatorWeights = append(digest.ValidatorWeights, validatorWeightDigest{Validator: validator, Weight: weight})
+	}
+	sort.Slice(digest.ValidatorWeights, func(i, j int) bool {
+		return bytes.Compare(digest.ValidatorWeights[i].Validator[:], digest.ValidatorWeights[j].Validator[:]) < 0
+	})
+	if p := c.ProposerPolicy; p != nil {
+		p.ensureInitialized()
+		p.registryMU.Lock()
+		digest.ProposerPolicyId = uint64(p.Id)
+		if p.Seed != nil {
+			digest.ProposerSeed = p.Seed.Bytes()
+		}
+		digest.RoundRobinFallback = p.RoundRobinFallback
+		digest.ProposerCooldown = p.ProposerCooldown
+		p.registryMU.Unlock()
+	}
+	return RLPHash(digest)
+}
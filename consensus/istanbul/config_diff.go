@@ -0,0 +1,205 @@
+package istanbul
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/kisexp/xdchain/common"
+)
+
+// ConsensusCriticalSuffix ends the descriptions returned by DiffConfig of the differences which fork
+// the network if they aren't applied by all the nodes at the same block
+const ConsensusCriticalSuffix = " (consensus-critical)"
+
+// DiffConfig returns a human readable description of each difference between the old and the updated config.
+//
+// Differences in the settings which all the nodes must agree on, such as Epoch, the ProposerPolicy or the
+// fork blocks, end with ConsensusCriticalSuffix. A nil config is compared as an empty config.
+func DiffConfig(old, updated *Config) []string {
+	if old == nil {
+		old = &Config{}
+	}
+	if updated == nil {
+		updated = &Config{}
+	}
+	d := &configDiff{}
+	d.uint64("RequestTimeout", old.RequestTimeout, updated.RequestTimeout, false)
+	d.uint64("BlockPeriod", old.BlockPeriod, updated.BlockPeriod, true)
+	d.uint64("BlockPeriodMillis", old.BlockPeriodMillis, updated.BlockPeriodMillis, true)
+	if old.StrictBlockPeriod != updated.StrictBlockPeriod {
+		d.add(true, "StrictBlockPeriod changed from %t to %t", old.StrictBlockPeriod, updated.StrictBlockPeriod)
+	}
+	d.proposerPolicy(old.ProposerPolicy, updated.ProposerPolicy)
+	d.uint64("Epoch", old.Epoch, updated.Epoch, true)
+	d.bigInt("Ceil2Nby3Block", old.Ceil2Nby3Block, updated.Ceil2Nby3Block, true)
+	d.uint64("AllowedFutureBlockTime", old.AllowedFutureBlockTime, updated.AllowedFutureBlockTime, false)
+	d.bigInt("TestQBFTBlock", old.TestQBFTBlock, updated.TestQBFTBlock, true)
+	if old.QBFTValidatorSortBy != updated.QBFTValidatorSortBy {
+		d.add(true, "QBFTValidatorSortBy changed from %q to %q", old.QBFTValidatorSortBy, updated.QBFTValidatorSortBy)
+	}
+	if old.PersistValidatorSets != updated.PersistValidatorSets {
+		d.add(false, "PersistValidatorSets changed from %t to %t", old.PersistValidatorSets, updated.PersistValidatorSets)
+	}
+	d.validatorWeights(old.ValidatorWeights, updated.ValidatorWeights)
+	d.uint64("MinValidators", old.MinValidators, updated.MinValidators, false)
+	d.uint64("ProposerRegistryCap", old.ProposerRegistryCap, updated.ProposerRegistryCap, false)
+	if old.PruneProposerRegistry != updated.PruneProposerRegistry {
+		d.add(false, "PruneProposerRegistry changed from %t to %t", old.PruneProposerRegistry, updated.PruneProposerRegistry)
+	}
+	if old.StrictProposerCheck != updated.StrictProposerCheck {
+		d.add(false, "StrictProposerCheck changed from %t to %t", old.StrictProposerCheck, updated.StrictProposerCheck)
+	}
+	d.allowedFutureBlockTimeSchedule(old.AllowedFutureBlockTimeSchedule, updated.AllowedFutureBlockTimeSchedule)
+	return d.diffs
+}
+
+type configDiff struct {
+	diffs []string
+}
+
+func (d *configDiff) add(critical bool, format string, args ...interface{}) {
+	diff := fmt.Sprintf(format, args...)
+	if critical {
+		diff += ConsensusCriticalSuffix
+	}
+	d.diffs = append(d.diffs, diff)
+}
+
+func (d *configDiff) uint64(name string, old, updated uint64, critical bool) {
+	if old != updated {
+		d.add(critical, "%s changed from %d to %d", name, old, updated)
+	}
+}
+
+func (d *configDiff) bigInt(name string, old, updated *big.Int, critical bool) {
+	switch {
+	case old == nil && updated == nil:
+	case old == nil:
+		d.add(critical, "%s added: %v", name, updated)
+	case updated == nil:
+		d.add(critical, "%s removed, was %v", name, old)
+	case old.Cmp(updated) != 0:
+		d.add(critical, "%s changed from %v to %v", name, old, updated)
+	}
+}
+
+// validatorWeights describes the validators whose weight is added, removed or changed, by address
+func (d *configDiff) validatorWeights(old, updated map[common.Address]uint64) {
+	validators := make([]common.Address, 0, len(old)+len(updated))
+	for validator := range old {
+		validators = append(validators, validator)
+	}
+	for validator := range updated {
+		if _, ok := old[validator]; !ok {
+			validators = append(validators, validator)
+		}
+	}
+	sort.Slice(validators, func(i, j int) bool { return bytes.Compare(validators[i][:], validators[j][:]) < 0 })
+	for _, validator := range validators {
+		oldWeight, inOld := old[validator]
+		updatedWeight, inUpdated := updated[validator]
+		switch {
+		case !inOld:
+			d.add(true, "ValidatorWeights of %s added: %d", validator.Hex(), updatedWeight)
+		case !inUpdated:
+			d.add(true, "ValidatorWeights of %s removed, was %d", validator.Hex(), oldWeight)
+		case oldWeight != updatedWeight:
+			d.add(true, "ValidatorWeights of %s changed from %d to %d", validator.Hex(), oldWeight, updatedWeight)
+		}
+	}
+}
+
+func (d *configDiff) proposerPolicy(old, updated *ProposerPolicy) {
+	switch {
+	case old == nil && updated == nil:
+		return
+	case old == nil:
+		d.add(true, "ProposerPolicy added: %s", describeProposerPolicy(updated))
+		return
+	case updated == nil:
+		d.add(true, "ProposerPolicy removed, was %s", describeProposerPolicy(old))
+		return
+	}
+	if old.Id != updated.Id {
+		d.add(true, "ProposerPolicy.Id changed from %d to %d", old.Id, updated.Id)
+	}
+	if oldBy, updatedBy := sortByDescription(old.By), sortByDescription(updated.By); oldBy != updatedBy {
+		d.add(true, "ProposerPolicy.By changed from %s to %s", oldBy, updatedBy)
+	}
+	switch {
+	case old.Seed == nil && updated.Seed == nil:
+	case old.Seed == nil:
+		d.add(true, "ProposerPolicy.Seed added: %s", updated.Seed.Hex())
+	case updated.Seed == nil:
+		d.add(true, "ProposerPolicy.Seed removed, was %s", old.Seed.Hex())
+	case *old.Seed != *updated.Seed:
+		d.add(true, "ProposerPolicy.Seed changed from %s to %s", old.Seed.Hex(), updated.Seed.Hex())
+	}
+	if old.RoundRobinFallback != updated.RoundRobinFallback {
+		d.add(true, "ProposerPolicy.RoundRobinFallback changed from %t to %t", old.RoundRobinFallback, updated.RoundRobinFallback)
+	}
+	if old.ProposerCooldown != updated.ProposerCooldown {
+		d.add(true, "ProposerPolicy.ProposerCooldown changed from %d to %d", old.ProposerCooldown, updated.ProposerCooldown)
+	}
+}
+
+func (d *configDiff) allowedFutureBlockTimeSchedule(old, updated []AllowedFutureBlockTimeTransition) {
+	oldByBlock, updatedByBlock := transitionsByBlock(old), transitionsByBlock(updated)
+	blocks := make([]*big.Int, 0, len(oldByBlock)+len(updatedByBlock))
+	for _, transition := range oldByBlock {
+		blocks = append(blocks, transition.Block)
+	}
+	for block, transition := range updatedByBlock {
+		if _, ok := oldByBlock[block]; !ok {
+			blocks = append(blocks, transition.Block)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Cmp(blocks[j]) < 0 })
+	for _, block := range blocks {
+		oldTransition, inOld := oldByBlock[block.String()]
+		updatedTransition, inUpdated := updatedByBlock[block.String()]
+		switch {
+		case !inOld:
+			d.add(false, "AllowedFutureBlockTimeSchedule transition at block %v added: %d", block, updatedTransition.AllowedFutureBlockTime)
+		case !inUpdated:
+			d.add(false, "AllowedFutureBlockTimeSchedule transition at block %v removed, was %d", block, oldTransition.AllowedFutureBlockTime)
+		case oldTransition.AllowedFutureBlockTime != updatedTransition.AllowedFutureBlockTime:
+			d.add(false, "AllowedFutureBlockTimeSchedule transition at block %v changed from %d to %d", block, oldTransition.AllowedFutureBlockTime, updatedTransition.AllowedFutureBlockTime)
+		}
+	}
+}
+
+// transitionsByBlock returns the transitions keyed by block height, transitions without block height
+// are ignored as they never apply
+func transitionsByBlock(schedule []AllowedFutureBlockTimeTransition) map[string]AllowedFutureBlockTimeTransition {
+	byBlock := make(map[string]AllowedFutureBlockTimeTransition, len(schedule))
+	for _, transition := range schedule {
+		if transition.Block != nil {
+			byBlock[transition.Block.String()] = transition
+		}
+	}
+	return byBlock
+}
+
+func describeProposerPolicy(p *ProposerPolicy) string {
+	seed := "none"
+	if p.Seed != nil {
+		seed = p.Seed.Hex()
+	}
+	return fmt.Sprintf("Id=%d By=%s Seed=%s RoundRobinFallback=%t ProposerCooldown=%d", p.Id, sortByDescription(p.By), seed, p.RoundRobinFallback, p.ProposerCooldown)
+}
+
+// sortByDescription returns the name of the ValidatorSortByFunc, custom functions can't be told apart
+func sortByDescription(by ValidatorSortByFunc) string {
+	name, err := validatorSortByName(by)
+	switch {
+	case err != nil:
+		return "custom"
+	case name == "":
+		return "string"
+	default:
+		return name
+	}
+}
@@ -0,0 +1,83 @@
+package istanbul
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfig_Unchanged(t *testing.T) {
+	assert.Empty(t, DiffConfig(DefaultConfig(), DefaultConfig()))
+}
+
+func TestDiffConfig_ChangedFields(t *testing.T) {
+	old, new := DefaultConfig(), DefaultConfig()
+	new.RequestTimeout = 5000
+	new.Epoch = 100
+	new.ProposerPolicy = NewProposerPolicyByIdAndSortFunc(Sticky, ValidatorSortByByte())
+	new.TestQBFTBlock = big.NewInt(10)
+	new.PersistValidatorSets = true
+
+	assert.Equal(t, []string{
+		"RequestTimeout changed from 10000 to 5000",
+		"ProposerPolicy.Id changed from 0 to 1" + ConsensusCriticalSuffix,
+		"ProposerPolicy.By changed from string to byte" + ConsensusCriticalSuffix,
+		"Epoch changed from 30000 to 100" + ConsensusCriticalSuffix,
+		"TestQBFTBlock changed from 0 to 10" + ConsensusCriticalSuffix,
+		"PersistValidatorSets changed from false to true",
+	}, DiffConfig(old, new))
+}
+
+func TestDiffConfig_AddedFields(t *testing.T) {
+	seed := common.HexToHash("0x1234")
+	old, new := DefaultConfig(), DefaultConfig()
+	old.Ceil2Nby3Block = nil
+	old.ProposerPolicy = nil
+	new.ProposerPolicy.Seed = &seed
+	new.AllowedFutureBlockTimeSchedule = []AllowedFutureBlockTimeTransition{{Block: big.NewInt(20), AllowedFutureBlockTime: 5}}
+
+	assert.Equal(t, []string{
+		"ProposerPolicy added: Id=0 By=string Seed=" + seed.Hex() + " RoundRobinFallback=false ProposerCooldown=0" + ConsensusCriticalSuffix,
+		"Ceil2Nby3Block added: 0" + ConsensusCriticalSuffix,
+		"AllowedFutureBlockTimeSchedule transition at block 20 added: 5",
+	}, DiffConfig(old, new))
+}
+
+func TestDiffConfig_RemovedFields(t *testing.T) {
+	seed := common.HexToHash("0x1234")
+	old, new := DefaultConfig(), DefaultConfig()
+	old.ProposerPolicy.Seed = &seed
+	old.AllowedFutureBlockTimeSchedule = []AllowedFutureBlockTimeTransition{
+		{Block: big.NewInt(20), AllowedFutureBlockTime: 5},
+		{Block: big.NewInt(10), AllowedFutureBlockTime: 3},
+	}
+	new.TestQBFTBlock = nil
+	new.AllowedFutureBlockTimeSchedule = []AllowedFutureBlockTimeTransition{{Block: big.NewInt(10), AllowedFutureBlockTime: 4}}
+
+	assert.Equal(t, []string{
+		"ProposerPolicy.Seed removed, was " + seed.Hex() + ConsensusCriticalSuffix,
+		"TestQBFTBlock removed, was 0" + ConsensusCriticalSuffix,
+		"AllowedFutureBlockTimeSchedule transition at block 10 changed from 3 to 4",
+		"AllowedFutureBlockTimeSchedule transition at block 20 removed, was 5",
+	}, DiffConfig(old, new))
+}
+
+func TestDiffConfig_ValidatorWeights(t *testing.T) {
+	addr1, addr2, addr3 := common.Address{1}, common.Address{2}, common.Address{3}
+	old, new := DefaultConfig(), DefaultConfig()
+	old.ValidatorWeights = map[common.Address]uint64{addr1: 1, addr2: 2}
+	new.ValidatorWeights = map[common.Address]uint64{addr2: 3, addr3: 4}
+
+	assert.Equal(t, []string{
+		"ValidatorWeights of " + addr1.Hex() + " removed, was 1" + ConsensusCriticalSuffix,
+		"ValidatorWeights of " + addr2.Hex() + " changed from 2 to 3" + ConsensusCriticalSuffix,
+		"ValidatorWeights of " + addr3.Hex() + " added: 4" + ConsensusCriticalSuffix,
+	}, DiffConfig(old, new))
+}
+
+func TestDiffConfig_NilConfig(t *testing.T) {
+	assert.Empty(t, DiffConfig(nil, &Config{}))
+	assert.Contains(t, DiffConfig(DefaultConfig(), nil), "Epoch changed from 30000 to 0"+ConsensusCriticalSuffix)
+}
@@ -0,0 +1,91 @@
+package istanbul
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/kisexp/xdchain/common"
+)
+
+// MigrateConfig upgrades a config loaded from a legacy genesis, which may predate the qbft fork and the
+// scheduling fields, to a config the engine can use. The settings which are missing are given the values
+// the legacy engine used instead of their zero values, and each change, or missing setting left as is
+// because it changes the consensus, is described by the returned notes. The old config isn't modified,
+// the returned config shares its ProposerPolicy if it has one.
+//
+// An error is returned if the old config is nil or if the upgraded config is invalid.
+func MigrateConfig(old *Config) (*Config, []string, error) {
+	if old == nil {
+		return nil, nil, errors.New("missing istanbul config to migrate")
+	}
+	defaults := DefaultConfig()
+	config := *old
+	config.Ceil2Nby3Block = copyBigInt(old.Ceil2Nby3Block)
+	config.TestQBFTBlock = copyBigInt(old.TestQBFTBlock)
+	if old.ValidatorWeights != nil {
+		config.ValidatorWeights = make(map[common.Address]uint64, len(old.ValidatorWeights))
+		for validator, weight := range old.ValidatorWeights {
+			config.ValidatorWeights[validator] = weight
+		}
+	}
+	var notes []string
+	note := func(format string, args ...interface{}) {
+		notes = append(notes, fmt.Sprintf(format, args...))
+	}
+
+	if config.ProposerPolicy == nil {
+		config.ProposerPolicy = defaults.ProposerPolicy
+		note("ProposerPolicy missing, the round robin policy is used")
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = defaults.RequestTimeout
+		note("RequestTimeout missing, set to %dms", config.RequestTimeout)
+	}
+	if config.BlockPeriod == 0 && config.BlockPeriodMillis == 0 {
+		config.BlockPeriod = defaults.BlockPeriod
+		note("BlockPeriod missing, set to %ds", config.BlockPeriod)
+	}
+	if config.Epoch == 0 {
+		config.Epoch = defaults.Epoch
+		note("Epoch missing, set to %d blocks", config.Epoch)
+	}
+	if config.TestQBFTBlock == nil {
+		note("TestQBFTBlock missing, istanbul consensus is used at all heights")
+		if config.QBFTValidatorSortBy != "" {
+			note("QBFTValidatorSortBy %q is unused without TestQBFTBlock", config.QBFTValidatorSortBy)
+		}
+		if len(config.ValidatorWeights) > 0 {
+			note("ValidatorWeights are unused without TestQBFTBlock")
+		}
+	}
+	if config.Ceil2Nby3Block == nil {
+		note("Ceil2Nby3Block missing, the 2F+1 quorum is used at all heights")
+	}
+	if len(old.AllowedFutureBlockTimeSchedule) > 0 {
+		config.AllowedFutureBlockTimeSchedule = nil
+		for _, transition := range old.AllowedFutureBlockTimeSchedule {
+			if transition.Block == nil {
+				note("AllowedFutureBlockTimeSchedule transition to %ds without block removed", transition.AllowedFutureBlockTime)
+				continue
+			}
+			config.AllowedFutureBlockTimeSchedule = append(config.AllowedFutureBlockTimeSchedule, AllowedFutureBlockTimeTransition{
+				Block:                  copyBigInt(transition.Block),
+				AllowedFutureBlockTime: transition.AllowedFutureBlockTime,
+			})
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, notes, fmt.Errorf("invalid migrated istanbul config: %w", err)
+	}
+	return &config, notes, nil
+}
+
+// copyBigInt returns a copy of the number, nil if it is nil
+func copyBigInt(n *big.Int) *big.Int {
+	if n == nil {
+		return nil
+	}
+	return new(big.Int).Set(n)
+}
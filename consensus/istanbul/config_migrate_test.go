@@ -0,0 +1,112 @@
+package istanbul
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateConfig_LegacyIBFT(t *testing.T) {
+	// a legacy istanbul genesis only knew the epoch and the proposer policy
+	old := &Config{Epoch: 30000, ProposerPolicy: NewStickyProposerPolicy()}
+
+	config, notes, err := MigrateConfig(old)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"RequestTimeout missing, set to 10000ms",
+		"BlockPeriod missing, set to 1s",
+		"TestQBFTBlock missing, istanbul consensus is used at all heights",
+		"Ceil2Nby3Block missing, the 2F+1 quorum is used at all heights",
+	}, notes)
+	assert.Same(t, old.ProposerPolicy, config.ProposerPolicy)
+	assert.Equal(t, uint64(10000), config.RequestTimeout)
+	assert.Equal(t, uint64(1), config.BlockPeriod)
+	assert.Equal(t, uint64(30000), config.Epoch)
+	assert.Nil(t, config.TestQBFTBlock)
+	assert.Nil(t, config.Ceil2Nby3Block)
+	assert.Equal(t, "istanbul", config.ConsensusAlgoAt(big.NewInt(1000)))
+	assert.Equal(t, 3, config.QuorumSize(4, big.NewInt(1000)))
+
+	// the old config is left untouched
+	assert.Equal(t, &Config{Epoch: 30000, ProposerPolicy: old.ProposerPolicy}, old)
+}
+
+func TestMigrateConfig_EmptyConfig(t *testing.T) {
+	config, notes, err := MigrateConfig(&Config{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"ProposerPolicy missing, the round robin policy is used",
+		"RequestTimeout missing, set to 10000ms",
+		"BlockPeriod missing, set to 1s",
+		"Epoch missing, set to 30000 blocks",
+		"TestQBFTBlock missing, istanbul consensus is used at all heights",
+		"Ceil2Nby3Block missing, the 2F+1 quorum is used at all heights",
+	}, notes)
+	assert.Equal(t, RoundRobin, config.ProposerPolicy.Id)
+	assert.Equal(t, uint64(30000), config.Epoch)
+}
+
+func TestMigrateConfig_UpToDate(t *testing.T) {
+	old := DefaultConfig()
+	old.BlockPeriod, old.BlockPeriodMillis = 0, 500
+
+	config, notes, err := MigrateConfig(old)
+
+	assert.NoError(t, err)
+	assert.Empty(t, notes)
+	assert.Equal(t, old.Hash(), config.Hash())
+	assert.Equal(t, uint64(500), config.BlockPeriodMillis)
+	assert.Equal(t, uint64(0), config.BlockPeriod)
+
+	// the fork blocks are copied
+	config.TestQBFTBlock.SetInt64(10)
+	assert.Equal(t, int64(0), old.TestQBFTBlock.Int64())
+}
+
+func TestMigrateConfig_UnusedQBFTSettings(t *testing.T) {
+	old := DefaultConfig()
+	old.TestQBFTBlock = nil
+	old.QBFTValidatorSortBy = "string"
+	old.ValidatorWeights = map[common.Address]uint64{{1}: 2}
+
+	config, notes, err := MigrateConfig(old)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"TestQBFTBlock missing, istanbul consensus is used at all heights",
+		"QBFTValidatorSortBy \"string\" is unused without TestQBFTBlock",
+		"ValidatorWeights are unused without TestQBFTBlock",
+	}, notes)
+	config.ValidatorWeights[common.Address{1}] = 3
+	assert.Equal(t, uint64(2), old.ValidatorWeights[common.Address{1}])
+}
+
+func TestMigrateConfig_AllowedFutureBlockTimeSchedule(t *testing.T) {
+	old := DefaultConfig()
+	old.AllowedFutureBlockTimeSchedule = []AllowedFutureBlockTimeTransition{
+		{Block: big.NewInt(10), AllowedFutureBlockTime: 2},
+		{Block: nil, AllowedFutureBlockTime: 3},
+	}
+
+	config, notes, err := MigrateConfig(old)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AllowedFutureBlockTimeSchedule transition to 3s without block removed"}, notes)
+	assert.Equal(t, []AllowedFutureBlockTimeTransition{{Block: big.NewInt(10), AllowedFutureBlockTime: 2}}, config.AllowedFutureBlockTimeSchedule)
+	assert.Len(t, old.AllowedFutureBlockTimeSchedule, 2)
+}
+
+func TestMigrateConfig_Invalid(t *testing.T) {
+	_, _, err := MigrateConfig(nil)
+	assert.Error(t, err)
+
+	old := DefaultConfig()
+	old.BlockPeriod, old.BlockPeriodMillis = 1, 1500
+	config, _, err := MigrateConfig(old)
+	assert.Error(t, err)
+	assert.Nil(t, config)
+}
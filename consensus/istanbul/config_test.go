@@ -17,30 +17,936 @@
 package istanbul
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/kisexp/xdchain/common"
+	"github.com/naoina/toml"
 	"github.com/stretchr/testify/assert"
 )
 
+func marshalProposerPolicy(p *ProposerPolicy) ([]byte, error) {
+	v, err := p.MarshalTOML()
+	if err != nil {
+		return nil, err
+	}
+	return toml.Marshal(v)
+}
+
+func unmarshalProposerPolicy(input []byte, p *ProposerPolicy) error {
+	return p.UnmarshalTOML(func(v interface{}) error {
+		return toml.Unmarshal(input, v)
+	})
+}
+
 func TestProposerPolicy_UnmarshalTOML(t *testing.T) {
 	input := []byte(`
-		id = 2
+		id = 1
 	`)
-	expectedId := ProposerPolicyId(2)
+	expectedId := Sticky
 	var p ProposerPolicy
-	assert.NoError(t, p.UnmarshalTOML(input))
+	assert.NoError(t, unmarshalProposerPolicy(input, &p))
 
 	assert.Equal(t, expectedId, p.Id, "ProposerPolicyId mismatch")
 }
 
+func TestProposerPolicy_UnmarshalTOML_UnknownId(t *testing.T) {
+	input := []byte(`
+		id = 99
+	`)
+	p := ProposerPolicy{Id: Sticky}
+	assert.EqualError(t, unmarshalProposerPolicy(input, &p), "unknown proposer policy id 99")
+
+	assert.Equal(t, Sticky, p.Id, "ProposerPolicyId must be left unchanged")
+}
+
+func TestProposerPolicy_UnmarshalTOML_RoundRobinFallback(t *testing.T) {
+	input := []byte(`
+		id = 1
+		roundRobinFallback = true
+	`)
+	var p ProposerPolicy
+	assert.NoError(t, unmarshalProposerPolicy(input, &p))
+
+	assert.Equal(t, Sticky, p.Id, "ProposerPolicyId mismatch")
+	assert.True(t, p.RoundRobinFallback, "RoundRobinFallback mismatch")
+
+	b, err := marshalProposerPolicy(&p)
+	assert.NoError(t, err)
+	var roundTrip ProposerPolicy
+	assert.NoError(t, unmarshalProposerPolicy(b, &roundTrip))
+	assert.True(t, roundTrip.RoundRobinFallback, "RoundRobinFallback lost on marshalling")
+}
+
+func TestProposerPolicy_UnmarshalTOML_ProposerCooldown(t *testing.T) {
+	input := []byte(`
+		id = 0
+		proposerCooldown = 3
+	`)
+	var p ProposerPolicy
+	assert.NoError(t, unmarshalProposerPolicy(input, &p))
+
+	assert.Equal(t, uint64(3), p.ProposerCooldown, "ProposerCooldown mismatch")
+
+	b, err := marshalProposerPolicy(&p)
+	assert.NoError(t, err)
+	var roundTrip ProposerPolicy
+	assert.NoError(t, unmarshalProposerPolicy(b, &roundTrip))
+	assert.Equal(t, uint64(3), roundTrip.ProposerCooldown, "ProposerCooldown lost on marshalling")
+}
+
 func TestProposerPolicy_MarshalTOML(t *testing.T) {
 	output := []byte(
 		`id = 1
 `)
 	p := &ProposerPolicy{Id: 1}
-	b, err := p.MarshalTOML()
+	b, err := marshalProposerPolicy(p)
 	if err != nil {
 		t.Errorf("error marshalling ProposerPolicy: %v", err)
 	}
 	assert.Equal(t, output, b, "ProposerPolicy MarshalTOML mismatch")
 }
+
+func TestConfig_ConsensusAlgoAt(t *testing.T) {
+	config := *DefaultConfig()
+	config.TestQBFTBlock = nil
+	assert.Equal(t, ConsensusAlgoIstanbul, config.ConsensusAlgoAt(big.NewInt(0)))
+	assert.Equal(t, ConsensusAlgoIstanbul, config.ConsensusAlgoAt(big.NewInt(100)))
+
+	config.TestQBFTBlock = big.NewInt(0)
+	assert.Equal(t, ConsensusAlgoQBFT, config.ConsensusAlgoAt(big.NewInt(0)))
+	assert.Equal(t, ConsensusAlgoQBFT, config.ConsensusAlgoAt(big.NewInt(100)))
+
+	config.TestQBFTBlock = big.NewInt(10)
+	assert.Equal(t, ConsensusAlgoIBFT, config.ConsensusAlgoAt(big.NewInt(0)))
+	assert.Equal(t, ConsensusAlgoIBFT, config.ConsensusAlgoAt(big.NewInt(9)))
+	assert.Equal(t, ConsensusAlgoQBFT, config.ConsensusAlgoAt(big.NewInt(10)))
+	assert.Equal(t, ConsensusAlgoQBFT, config.ConsensusAlgoAt(big.NewInt(11)))
+}
+
+func TestConfig_IsCeil2Nby3Block(t *testing.T) {
+	config := *DefaultConfig()
+	config.Ceil2Nby3Block = nil
+	assert.False(t, config.IsCeil2Nby3Block(nil))
+	assert.False(t, config.IsCeil2Nby3Block(big.NewInt(0)))
+	assert.False(t, config.IsCeil2Nby3Block(big.NewInt(100)))
+
+	config.Ceil2Nby3Block = big.NewInt(0)
+	assert.True(t, config.IsCeil2Nby3Block(nil))
+	assert.True(t, config.IsCeil2Nby3Block(big.NewInt(0)))
+	assert.True(t, config.IsCeil2Nby3Block(big.NewInt(100)))
+
+	config.Ceil2Nby3Block = big.NewInt(10)
+	assert.False(t, config.IsCeil2Nby3Block(nil))
+	assert.False(t, config.IsCeil2Nby3Block(big.NewInt(0)))
+	assert.False(t, config.IsCeil2Nby3Block(big.NewInt(9)))
+	assert.True(t, config.IsCeil2Nby3Block(big.NewInt(10)))
+	assert.True(t, config.IsCeil2Nby3Block(big.NewInt(11)))
+}
+
+func TestConfig_QuorumSize(t *testing.T) {
+	config := *DefaultConfig()
+	config.Ceil2Nby3Block = big.NewInt(10)
+	testCases := []struct {
+		validatorCount int
+		before, after  int // quorum size with 2F+1 and Ceil(2N/3)
+	}{
+		{1, 1, 1},
+		{2, 1, 2},
+		{3, 1, 2},
+		{4, 3, 3},
+		{5, 3, 4},
+		{6, 3, 4},
+		{7, 5, 5},
+		{10, 7, 7},
+		{12, 7, 8},
+		{100, 67, 67},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.before, config.QuorumSize(tc.validatorCount, nil), "validators=%d, no block", tc.validatorCount)
+		assert.Equal(t, tc.before, config.QuorumSize(tc.validatorCount, big.NewInt(9)), "validators=%d, before fork", tc.validatorCount)
+		assert.Equal(t, tc.after, config.QuorumSize(tc.validatorCount, big.NewInt(10)), "validators=%d, at fork", tc.validatorCount)
+		assert.Equal(t, tc.after, config.QuorumSize(tc.validatorCount, big.NewInt(11)), "validators=%d, after fork", tc.validatorCount)
+	}
+
+	config.Ceil2Nby3Block = nil
+	assert.Equal(t, 3, config.QuorumSize(5, big.NewInt(100)))
+}
+
+func TestConfig_CanReachQuorum(t *testing.T) {
+	config := *DefaultConfig()
+	config.Ceil2Nby3Block = big.NewInt(10)
+	testCases := []struct {
+		live, total   int
+		before, after bool // with 2F+1 and Ceil(2N/3)
+	}{
+		{0, 0, false, false},
+		{1, 0, false, false},
+		{0, 1, false, false},
+		{1, 1, true, true},
+		{1, 2, true, false},
+		{2, 2, true, true},
+		{1, 3, true, false},
+		{2, 3, true, true},
+		{2, 4, false, false},
+		{3, 4, true, true},
+		{3, 5, true, false},
+		{4, 5, true, true},
+		{3, 6, true, false},
+		{7, 12, true, false},
+		{8, 12, true, true},
+		{5, 4, true, true},
+		{-1, 4, false, false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.before, config.CanReachQuorum(tc.live, tc.total, nil), "live=%d, total=%d, no block", tc.live, tc.total)
+		assert.Equal(t, tc.before, config.CanReachQuorum(tc.live, tc.total, big.NewInt(9)), "live=%d, total=%d, before fork", tc.live, tc.total)
+		assert.Equal(t, tc.after, config.CanReachQuorum(tc.live, tc.total, big.NewInt(10)), "live=%d, total=%d, at fork", tc.live, tc.total)
+		assert.Equal(t, tc.after, config.CanReachQuorum(tc.live, tc.total, big.NewInt(11)), "live=%d, total=%d, after fork", tc.live, tc.total)
+	}
+}
+
+func TestConfig_WeightedQuorumThreshold(t *testing.T) {
+	config := *DefaultConfig()
+	config.TestQBFTBlock = big.NewInt(10)
+	config.Ceil2Nby3Block = nil
+	weightsOf := func(weights ...uint64) map[common.Address]uint64 {
+		byValidator := make(map[common.Address]uint64, len(weights))
+		for i, weight := range weights {
+			byValidator[common.Address{byte(i + 1)}] = weight
+		}
+		return byValidator
+	}
+	testCases := []struct {
+		name    string
+		weights map[common.Address]uint64
+		before  uint64 // 2F+1 of the validators, whatever their weights
+		after   uint64 // Ceil(2W/3) of the total weight
+	}{
+		{"no validator", weightsOf(), 0, 0},
+		{"single", weightsOf(5), 1, 4},
+		{"equal weights", weightsOf(1, 1, 1, 1), 3, 3},
+		{"equal weights, 6 validators", weightsOf(1, 1, 1, 1, 1, 1), 3, 4},
+		{"equal heavy weights", weightsOf(10, 10, 10, 10), 3, 27},
+		{"dominant validator", weightsOf(100, 1, 1, 1), 3, 69},
+		{"skewed", weightsOf(5, 3, 2, 1, 1), 3, 8},
+		{"total multiple of 3", weightsOf(4, 4, 4), 1, 8},
+		{"total 3k+1", weightsOf(4, 4, 5), 1, 9},
+		{"total 3k+2", weightsOf(4, 5, 5), 1, 10},
+		{"zero weight", weightsOf(0, 3, 3), 1, 4},
+		{"overflow", weightsOf(math.MaxUint64, math.MaxUint64), 1, math.MaxUint64},
+		{"max total", weightsOf(math.MaxUint64), 1, math.MaxUint64 - math.MaxUint64/3},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.before, config.WeightedQuorumThreshold(tc.weights, nil), "%s, no block", tc.name)
+		assert.Equal(t, tc.before, config.WeightedQuorumThreshold(tc.weights, big.NewInt(9)), "%s, before fork", tc.name)
+		assert.Equal(t, tc.after, config.WeightedQuorumThreshold(tc.weights, big.NewInt(10)), "%s, at fork", tc.name)
+		assert.Equal(t, tc.after, config.WeightedQuorumThreshold(tc.weights, big.NewInt(11)), "%s, after fork", tc.name)
+	}
+
+	// with unit weights, the weighted quorum is the Ceil(2N/3) quorum
+	config.Ceil2Nby3Block = big.NewInt(0)
+	for n := 1; n <= 100; n++ {
+		weights := make([]uint64, n)
+		for i := range weights {
+			weights[i] = 1
+		}
+		assert.Equal(t, uint64(config.QuorumSize(n, big.NewInt(10))), config.WeightedQuorumThreshold(weightsOf(weights...), big.NewInt(10)), "validators=%d", n)
+	}
+
+	// qbft from genesis
+	config.TestQBFTBlock = big.NewInt(0)
+	assert.Equal(t, uint64(69), config.WeightedQuorumThreshold(weightsOf(100, 1, 1, 1), nil))
+}
+
+func TestConfig_WeightedQuorumThreshold_Deterministic(t *testing.T) {
+	config := *DefaultConfig()
+	weights := make(map[common.Address]uint64)
+	for i := 0; i < 50; i++ {
+		weights[common.Address{byte(i)}] = uint64(i*i + 1)
+	}
+	expected := config.WeightedQuorumThreshold(weights, big.NewInt(1))
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, expected, config.WeightedQuorumThreshold(weights, big.NewInt(1)))
+	}
+}
+
+func TestConfig_VoteWeights(t *testing.T) {
+	config := *DefaultConfig()
+	config.TestQBFTBlock = big.NewInt(10)
+	addr1, addr2 := common.Address{1}, common.Address{2}
+	config.ValidatorWeights = map[common.Address]uint64{addr1: 3, {9}: 7}
+
+	assert.Equal(t, map[common.Address]uint64{addr1: 1, addr2: 1}, config.VoteWeights([]common.Address{addr1, addr2}, big.NewInt(9)))
+	assert.Equal(t, map[common.Address]uint64{addr1: 3, addr2: 1}, config.VoteWeights([]common.Address{addr1, addr2}, big.NewInt(10)))
+
+	weights := config.VoteWeights([]common.Address{addr1, addr2}, big.NewInt(10))
+	assert.Equal(t, uint64(3), config.WeightedQuorumThreshold(weights, big.NewInt(10)))
+}
+
+func TestConfig_Validate_ValidatorWeights(t *testing.T) {
+	config := DefaultConfig()
+	config.ValidatorWeights = map[common.Address]uint64{{1}: 1, {2}: 5}
+	assert.NoError(t, config.Validate())
+
+	config.ValidatorWeights = map[common.Address]uint64{{1}: 1, {2}: 0}
+	assert.Error(t, config.Validate())
+
+	config.ValidatorWeights = map[common.Address]uint64{{1}: math.MaxUint64, {2}: 1}
+	assert.Error(t, config.Validate())
+}
+
+func TestConfig_Hash_ValidatorWeightsOrder(t *testing.T) {
+	config, other := DefaultConfig(), DefaultConfig()
+	config.ValidatorWeights = make(map[common.Address]uint64)
+	other.ValidatorWeights = make(map[common.Address]uint64)
+	for i := 0; i < 20; i++ {
+		config.ValidatorWeights[common.Address{byte(i)}] = uint64(i + 1)
+		other.ValidatorWeights[common.Address{byte(19 - i)}] = uint64(20 - i)
+	}
+	assert.Equal(t, config.Hash(), other.Hash())
+
+	other.ValidatorWeights[common.Address{0}] = 2
+	assert.NotEqual(t, config.Hash(), other.Hash())
+}
+
+func TestConfig_IsEpochBlock(t *testing.T) {
+	config := *DefaultConfig()
+	config.Epoch = 10
+	assert.True(t, config.IsEpochBlock(0), "genesis block must be a checkpoint")
+	assert.False(t, config.IsEpochBlock(1))
+	assert.False(t, config.IsEpochBlock(9))
+	assert.True(t, config.IsEpochBlock(10))
+	assert.False(t, config.IsEpochBlock(11))
+	assert.True(t, config.IsEpochBlock(30))
+
+	config.Epoch = 0
+	assert.False(t, config.IsEpochBlock(0))
+	assert.False(t, config.IsEpochBlock(10))
+}
+
+func TestConfig_AllowedFutureBlockTimeAt(t *testing.T) {
+	config := *DefaultConfig()
+	config.AllowedFutureBlockTime = 5
+	assert.Equal(t, uint64(5), config.AllowedFutureBlockTimeAt(nil))
+	assert.Equal(t, uint64(5), config.AllowedFutureBlockTimeAt(big.NewInt(100)))
+
+	// transitions don't have to be ordered
+	config.AllowedFutureBlockTimeSchedule = []AllowedFutureBlockTimeTransition{
+		{Block: big.NewInt(20), AllowedFutureBlockTime: 1},
+		{Block: big.NewInt(10), AllowedFutureBlockTime: 2},
+		{Block: nil, AllowedFutureBlockTime: 3},
+	}
+	assert.Equal(t, uint64(5), config.AllowedFutureBlockTimeAt(nil))
+	assert.Equal(t, uint64(5), config.AllowedFutureBlockTimeAt(big.NewInt(0)))
+	assert.Equal(t, uint64(5), config.AllowedFutureBlockTimeAt(big.NewInt(9)))
+	assert.Equal(t, uint64(2), config.AllowedFutureBlockTimeAt(big.NewInt(10)))
+	assert.Equal(t, uint64(2), config.AllowedFutureBlockTimeAt(big.NewInt(19)))
+	assert.Equal(t, uint64(1), config.AllowedFutureBlockTimeAt(big.NewInt(20)))
+	assert.Equal(t, uint64(1), config.AllowedFutureBlockTimeAt(big.NewInt(100)))
+}
+
+func TestDefaultConfig_IndependentInstances(t *testing.T) {
+	c1 := DefaultConfig()
+	c2 := DefaultConfig()
+
+	assert.False(t, c1 == c2)
+	assert.False(t, c1.ProposerPolicy == c2.ProposerPolicy)
+
+	var valSet ValidatorSet
+	c1.ProposerPolicy.RegisterValidatorSet(1, valSet)
+	assert.Len(t, c1.ProposerPolicy.registry, 1)
+	assert.Empty(t, c2.ProposerPolicy.registry)
+
+	c1.Epoch = 1
+	c1.Ceil2Nby3Block.SetInt64(10)
+	assert.Equal(t, uint64(30000), c2.Epoch)
+	assert.Equal(t, int64(0), c2.Ceil2Nby3Block.Int64())
+}
+
+// namedValidatorSet tells the ValidatorSets registered to a ProposerPolicy apart
+type namedValidatorSet struct {
+	ValidatorSet
+	name string
+}
+
+func TestProposerPolicy_RegisterValidatorSetSameHeight(t *testing.T) {
+	p := NewRoundRobinProposerPolicy()
+	p.RegisterValidatorSet(10, namedValidatorSet{name: "first"})
+	p.RegisterValidatorSet(11, namedValidatorSet{name: "next"})
+	// height 10 processed again, e.g. during a reorg
+	p.RegisterValidatorSet(10, namedValidatorSet{name: "replayed"})
+
+	assert.Equal(t, []registeredValidatorSet{
+		{number: 10, valSet: namedValidatorSet{name: "replayed"}},
+		{number: 11, valSet: namedValidatorSet{name: "next"}},
+	}, p.registry)
+}
+
+func TestProposerPolicy_SetSelectionObserver(t *testing.T) {
+	pp := NewRoundRobinProposerPolicy()
+	proposer := common.HexToAddress("0x1")
+
+	// no observer registered
+	pp.NotifySelection(1, 0, proposer)
+
+	calls := make(chan uint64, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pp.SetSelectionObserver(func(height, round uint64, p common.Address) {
+				assert.Equal(t, proposer, p)
+				calls <- height
+			})
+			pp.NotifySelection(2, 0, proposer)
+			pp.SetSelectionObserver(nil)
+		}()
+	}
+	wg.Wait()
+
+	// a copy of the policy notifies the same observer
+	pp.SetSelectionObserver(func(height, round uint64, p common.Address) {
+		calls <- height
+	})
+	copied := *pp
+	copied.NotifySelection(3, 1, proposer)
+	for {
+		select {
+		case height := <-calls:
+			if height == 3 {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("observer of the copied policy not called")
+		}
+	}
+}
+
+func TestConfig_TOMLRoundTrip(t *testing.T) {
+	seed := common.HexToHash("0x1234")
+	nonDefault := DefaultConfig()
+	nonDefault.RequestTimeout = 5000
+	nonDefault.ProposerPolicy = NewProposerPolicyByIdAndSortFunc(Sticky, ValidatorSortByByte())
+	nonDefault.ProposerPolicy.Seed = &seed
+	nonDefault.ProposerPolicy.RoundRobinFallback = true
+	nonDefault.Ceil2Nby3Block = big.NewInt(5)
+	nonDefault.TestQBFTBlock = nil
+	nonDefault.AllowedFutureBlockTime = 7
+	nonDefault.PersistValidatorSets = true
+	nonDefault.MinValidators = 4
+	nonDefault.BlockPeriodMillis = 500
+	nonDefault.QBFTValidatorSortBy = "string"
+	nonDefault.AllowedFutureBlockTimeSchedule = []AllowedFutureBlockTimeTransition{{Block: big.NewInt(10), AllowedFutureBlockTime: 20}}
+	nonDefault.ValidatorWeights = map[common.Address]uint64{common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17"): 3}
+
+	for name, config := range map[string]*Config{"default": DefaultConfig(), "non default": nonDefault} {
+		t.Run(name, func(t *testing.T) {
+			b, err := toml.Marshal(config)
+			assert.NoError(t, err)
+
+			var reloaded Config
+			assert.NoError(t, toml.Unmarshal(b, &reloaded))
+
+			// the sort functions can't be compared, they are compared by name
+			expectedSortBy, err := validatorSortByName(config.ProposerPolicy.By)
+			assert.NoError(t, err)
+			actualSortBy, err := validatorSortByName(reloaded.ProposerPolicy.By)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedSortBy, actualSortBy, "ValidatorSortByFunc mismatch")
+
+			expected, actual := *config, reloaded
+			expectedPolicy, actualPolicy := *expected.ProposerPolicy, *actual.ProposerPolicy
+			expectedPolicy.By, actualPolicy.By = nil, nil
+			expectedPolicy.registryMU, actualPolicy.registryMU = nil, nil
+			expectedPolicy.observer, actualPolicy.observer = nil, nil
+			expected.ProposerPolicy, actual.ProposerPolicy = &expectedPolicy, &actualPolicy
+			assert.Equal(t, expected, actual)
+
+			remarshalled, err := toml.Marshal(&reloaded)
+			assert.NoError(t, err)
+			assert.Equal(t, string(b), string(remarshalled))
+		})
+	}
+}
+
+func TestProposerPolicy_MarshalTOML_CustomSortFunc(t *testing.T) {
+	p := NewProposerPolicyByIdAndSortFunc(RoundRobin, func(v1 Validator, v2 Validator) bool { return false })
+
+	_, err := p.MarshalTOML()
+
+	assert.Error(t, err)
+}
+
+func TestProposerPolicy_UnmarshalTOML_UnknownSortFunc(t *testing.T) {
+	input := []byte(`
+		id = 0
+		sortBy = "unknown"
+	`)
+	var p ProposerPolicy
+	assert.EqualError(t, unmarshalProposerPolicy(input, &p), `unknown validator sort function "unknown"`)
+}
+
+func TestConfig_WithoutProposerPolicy(t *testing.T) {
+	input := []byte(`
+		RequestTimeout = 5000
+		BlockPeriod = 2
+		Epoch = 100
+	`)
+	var config Config
+	assert.NoError(t, toml.Unmarshal(input, &config))
+	assert.Nil(t, config.ProposerPolicy)
+
+	assert.Equal(t, ErrNoProposerPolicy, config.Validate())
+
+	policy := config.Policy()
+	if assert.NotNil(t, policy) {
+		assert.Equal(t, RoundRobin, policy.Id, "ProposerPolicyId mismatch")
+		assert.NotNil(t, policy.By, "ValidatorSortByFunc not set")
+	}
+	assert.Same(t, policy, config.Policy(), "ProposerPolicy initialized more than once")
+	assert.NoError(t, config.Validate())
+}
+
+func TestNewProposerPolicyFromString(t *testing.T) {
+	for s, expected := range map[string]ProposerPolicyId{"roundrobin": RoundRobin, "RoundRobin": RoundRobin, "sticky": Sticky, "STICKY": Sticky} {
+		p, err := NewProposerPolicyFromString(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, p.Id, s)
+		assert.NotNil(t, p.By, s)
+	}
+
+	_, err := NewProposerPolicyFromString("weighted")
+	assert.EqualError(t, err, `unsupported proposer policy "weighted", the validator weights don't apply to the proposer selection`)
+	_, err = NewProposerPolicyFromString("random")
+	assert.EqualError(t, err, `unknown proposer policy "random", expected roundrobin or sticky`)
+	_, err = NewProposerPolicyFromString("")
+	assert.Error(t, err)
+}
+
+func TestConfig_Validate_UnknownProposerPolicy(t *testing.T) {
+	config := DefaultConfig()
+	config.ProposerPolicy.Id = 99
+
+	assert.EqualError(t, config.Validate(), "unknown proposer policy id 99")
+}
+
+func TestConfig_Validate_MinValidators(t *testing.T) {
+	testCases := []struct {
+		ceil2Nby3Block *big.Int
+		minValidators  uint64
+		valid          bool
+	}{
+		{big.NewInt(0), 0, true},
+		{big.NewInt(0), 1, false},
+		{big.NewInt(0), 2, false},
+		{big.NewInt(0), 3, true},
+		{big.NewInt(0), 4, true},
+		{nil, 1, false},
+		{nil, 2, true},
+		// Ceil(2N/3) is required from block 10 on
+		{big.NewInt(10), 2, false},
+		{big.NewInt(10), 3, true},
+	}
+	for _, tc := range testCases {
+		config := DefaultConfig()
+		// ibft consensus, so that the 2F+1 quorum can be used
+		config.TestQBFTBlock = nil
+		config.Ceil2Nby3Block = tc.ceil2Nby3Block
+		config.MinValidators = tc.minValidators
+
+		err := config.Validate()
+
+		assert.Equal(t, tc.valid, err == nil, "MinValidators %d with Ceil2Nby3Block %v: unexpected validation result %v", tc.minValidators, tc.ceil2Nby3Block, err)
+	}
+}
+
+func TestConfig_ValidateForks(t *testing.T) {
+	testCases := []struct {
+		testQBFTBlock  *big.Int
+		ceil2Nby3Block *big.Int
+		err            string
+	}{
+		{nil, nil, ""},
+		{nil, big.NewInt(0), ""},
+		{nil, big.NewInt(10), ""},
+		{big.NewInt(0), big.NewInt(0), ""},
+		{big.NewInt(10), big.NewInt(0), ""},
+		{big.NewInt(10), big.NewInt(10), ""},
+		{big.NewInt(0), nil, "TestQBFTBlock 0 requires Ceil2Nby3Block, qbft consensus can't use the 2F+1 quorum"},
+		{big.NewInt(10), big.NewInt(11), "Ceil2Nby3Block 11 is after TestQBFTBlock 10, qbft consensus can't use the 2F+1 quorum"},
+		{big.NewInt(0), big.NewInt(5), "Ceil2Nby3Block 5 is after TestQBFTBlock 0, qbft consensus can't use the 2F+1 quorum"},
+	}
+	for _, tc := range testCases {
+		config := DefaultConfig()
+		config.TestQBFTBlock = tc.testQBFTBlock
+		config.Ceil2Nby3Block = tc.ceil2Nby3Block
+		assert.NoError(t, config.Validate(), "forks checked by Validate")
+
+		err := config.ValidateForks()
+
+		if tc.err == "" {
+			assert.NoError(t, err, "TestQBFTBlock %v with Ceil2Nby3Block %v", tc.testQBFTBlock, tc.ceil2Nby3Block)
+		} else {
+			assert.EqualError(t, err, tc.err)
+		}
+	}
+}
+
+func TestConfig_HasMinValidators(t *testing.T) {
+	config := DefaultConfig()
+	assert.True(t, config.HasMinValidators(0), "no minimum by default")
+
+	config.MinValidators = 3
+	assert.False(t, config.HasMinValidators(2))
+	assert.True(t, config.HasMinValidators(3))
+	assert.True(t, config.HasMinValidators(4))
+}
+
+// reverseByteSortRegistration registers a sort function once for the lifetime of the test binary
+var reverseByteSortRegistration = RegisterValidatorSortByFunc("testReverseByte", func(v1 Validator, v2 Validator) bool {
+	return ValidatorSortByByte()(v2, v1)
+})
+
+func TestProposerPolicy_UnmarshalTOML_SortByStringDesc(t *testing.T) {
+	input := []byte(`
+		id = 0
+		sortBy = "stringDesc"
+	`)
+	var p ProposerPolicy
+	assert.NoError(t, unmarshalProposerPolicy(input, &p))
+	name, err := validatorSortByName(p.By)
+	assert.NoError(t, err)
+	assert.Equal(t, "stringDesc", name)
+
+	b, err := marshalProposerPolicy(&p)
+	assert.NoError(t, err)
+	var roundTrip ProposerPolicy
+	assert.NoError(t, unmarshalProposerPolicy(b, &roundTrip))
+	name, err = validatorSortByName(roundTrip.By)
+	assert.NoError(t, err)
+	assert.Equal(t, "stringDesc", name, "sort function lost on marshalling")
+}
+
+func TestRegisterValidatorSortByFunc(t *testing.T) {
+	assert.NoError(t, reverseByteSortRegistration)
+
+	assert.EqualError(t, RegisterValidatorSortByFunc("testReverseByte", ValidatorSortByByte()), `validator sort function "testReverseByte" already registered`)
+	assert.EqualError(t, RegisterValidatorSortByFunc("byte", ValidatorSortByByte()), `validator sort function "byte" already registered`)
+	assert.Error(t, RegisterValidatorSortByFunc("", ValidatorSortByByte()))
+	assert.Error(t, RegisterValidatorSortByFunc("testNil", nil))
+
+	// a registered sort function can be marshalled by name
+	p := NewProposerPolicy(RoundRobin)
+	p.By, _ = validatorSortByFunc("testReverseByte")
+	b, err := marshalProposerPolicy(p)
+	assert.NoError(t, err)
+	var reloaded ProposerPolicy
+	assert.NoError(t, unmarshalProposerPolicy(b, &reloaded))
+	name, err := validatorSortByName(reloaded.By)
+	assert.NoError(t, err)
+	assert.Equal(t, "testReverseByte", name)
+}
+
+func TestConfig_QBFTValidatorSortByFunc(t *testing.T) {
+	testCases := []struct {
+		sortBy       string
+		expectedName string
+	}{
+		{"", "byte"},
+		{"byte", "byte"},
+		{"string", ""},
+		{"testReverseByte", "testReverseByte"},
+	}
+	for _, tc := range testCases {
+		config := DefaultConfig()
+		config.QBFTValidatorSortBy = tc.sortBy
+
+		by, err := config.QBFTValidatorSortByFunc()
+
+		assert.NoError(t, err)
+		name, err := validatorSortByName(by)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedName, name, "QBFTValidatorSortBy %q", tc.sortBy)
+		assert.NoError(t, config.Validate())
+	}
+}
+
+func TestConfig_QBFTValidatorSortByFunc_Unknown(t *testing.T) {
+	config := DefaultConfig()
+	config.QBFTValidatorSortBy = "votingPower"
+
+	_, err := config.QBFTValidatorSortByFunc()
+
+	assert.EqualError(t, err, `unknown validator sort function "votingPower"`)
+	assert.EqualError(t, config.Validate(), `unknown validator sort function "votingPower"`)
+}
+
+func TestConfig_BlockPeriodMillis(t *testing.T) {
+	testCases := []struct {
+		blockPeriod, blockPeriodMillis uint64
+		expectedDuration               time.Duration
+		expectedGap                    uint64
+	}{
+		{1, 0, time.Second, 1},
+		{5, 0, 5 * time.Second, 5},
+		{0, 500, 500 * time.Millisecond, 0},
+		{0, 1500, 1500 * time.Millisecond, 1},
+		{2, 2000, 2 * time.Second, 2},
+	}
+	for _, tc := range testCases {
+		config := DefaultConfig()
+		config.BlockPeriod, config.BlockPeriodMillis = tc.blockPeriod, tc.blockPeriodMillis
+
+		assert.NoError(t, config.Validate())
+		assert.Equal(t, tc.expectedDuration, config.BlockPeriodDuration(), "BlockPeriod %d, BlockPeriodMillis %d", tc.blockPeriod, tc.blockPeriodMillis)
+		assert.Equal(t, tc.expectedGap, config.MinBlockTimestampGap(), "BlockPeriod %d, BlockPeriodMillis %d", tc.blockPeriod, tc.blockPeriodMillis)
+	}
+}
+
+func TestConfig_StrictBlockPeriod(t *testing.T) {
+	testCases := []struct {
+		blockPeriod, blockPeriodMillis uint64
+		expectedGap                    uint64
+	}{
+		{0, 500, 1},
+		{0, 1500, 2},
+		{0, 2000, 2},
+	}
+	for _, tc := range testCases {
+		config := DefaultConfig()
+		config.BlockPeriod, config.BlockPeriodMillis = tc.blockPeriod, tc.blockPeriodMillis
+		config.StrictBlockPeriod = true
+
+		assert.NoError(t, config.Validate())
+		assert.Equal(t, tc.expectedGap, config.MinBlockTimestampGap(), "BlockPeriod %d, BlockPeriodMillis %d", tc.blockPeriod, tc.blockPeriodMillis)
+	}
+}
+
+func TestConfig_Validate_StrictBlockPeriodWithoutMillis(t *testing.T) {
+	config := DefaultConfig()
+	config.StrictBlockPeriod = true
+
+	assert.EqualError(t, config.Validate(), "StrictBlockPeriod requires BlockPeriodMillis, whole second block periods are never rounded")
+}
+
+func TestConfig_Validate_BlockPeriodConflict(t *testing.T) {
+	config := DefaultConfig()
+	config.BlockPeriodMillis = 500
+
+	assert.EqualError(t, config.Validate(), "BlockPeriod of 1s conflicts with BlockPeriodMillis of 500ms, only one of them must be set")
+}
+
+func TestConfig_ParamsAt(t *testing.T) {
+	config := DefaultConfig()
+	config.BlockPeriod, config.BlockPeriodMillis = 0, 1500
+	config.Epoch = 10
+	config.Ceil2Nby3Block = big.NewInt(5)
+	config.TestQBFTBlock = big.NewInt(20)
+	config.AllowedFutureBlockTimeSchedule = []AllowedFutureBlockTimeTransition{{Block: big.NewInt(15), AllowedFutureBlockTime: 3}}
+
+	assert.Equal(t, ConsensusParams{
+		BlockNumber:          big.NewInt(0),
+		BlockPeriodMillis:    1500,
+		MinBlockTimestampGap: 1,
+		RequestTimeout:       10000,
+		QuorumRule:           QuorumRule2FPlus1,
+		ConsensusAlgo:        ConsensusAlgoIBFT,
+		Epoch:                10,
+		EpochBlock:           true,
+	}, config.ParamsAt(nil))
+	assert.Equal(t, ConsensusParams{
+		BlockNumber:            big.NewInt(20),
+		BlockPeriodMillis:      1500,
+		MinBlockTimestampGap:   1,
+		RequestTimeout:         10000,
+		QuorumRule:             QuorumRuleCeil2Nby3,
+		ConsensusAlgo:          ConsensusAlgoQBFT,
+		Epoch:                  10,
+		EpochBlock:             true,
+		AllowedFutureBlockTime: 3,
+	}, config.ParamsAt(big.NewInt(20)))
+
+	params := config.ParamsAt(big.NewInt(7))
+	assert.Equal(t, QuorumRuleCeil2Nby3, params.QuorumRule)
+	assert.Equal(t, ConsensusAlgoIBFT, params.ConsensusAlgo)
+	assert.False(t, params.EpochBlock)
+	assert.Equal(t, uint64(0), params.AllowedFutureBlockTime)
+
+	blob, err := json.Marshal(params)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"blockNumber":7,"blockPeriodMillis":1500,"minBlockTimestampGap":1,"requestTimeout":10000,"quorumRule":"Ceil(2N/3)","consensusAlgo":"ibft","epoch":10,"epochBlock":false,"allowedFutureBlockTime":0}`, string(blob))
+}
+
+func TestConfig_NextEpochBlock(t *testing.T) {
+	config := DefaultConfig()
+	config.Epoch = 10
+
+	for blockNumber, expected := range map[uint64]uint64{0: 10, 1: 10, 9: 10, 10: 20, 15: 20} {
+		next, ok := config.NextEpochBlock(blockNumber)
+		assert.True(t, ok)
+		assert.Equal(t, expected, next, "block %d", blockNumber)
+	}
+
+	config.Epoch = 0
+	_, ok := config.NextEpochBlock(5)
+	assert.False(t, ok)
+}
+
+func TestConfig_NextTransition(t *testing.T) {
+	config := DefaultConfig()
+	_, _, ok := config.NextTransition(big.NewInt(0))
+	assert.False(t, ok, "the default forks are at the genesis")
+
+	config.TestQBFTBlock = big.NewInt(20)
+	config.Ceil2Nby3Block = big.NewInt(10)
+	config.AllowedFutureBlockTimeSchedule = []AllowedFutureBlockTimeTransition{
+		{Block: big.NewInt(30), AllowedFutureBlockTime: 5},
+		{Block: big.NewInt(20), AllowedFutureBlockTime: 2},
+		{AllowedFutureBlockTime: 1},
+	}
+
+	for _, tc := range []struct {
+		blockNumber *big.Int
+		name        string
+		atBlock     int64
+	}{
+		{nil, "Ceil2Nby3Block", 10},
+		{big.NewInt(0), "Ceil2Nby3Block", 10},
+		{big.NewInt(9), "Ceil2Nby3Block", 10},
+		{big.NewInt(10), "TestQBFTBlock", 20},
+		{big.NewInt(19), "TestQBFTBlock", 20},
+		{big.NewInt(20), "AllowedFutureBlockTimeSchedule", 30},
+		{big.NewInt(29), "AllowedFutureBlockTimeSchedule", 30},
+	} {
+		name, atBlock, ok := config.NextTransition(tc.blockNumber)
+		assert.True(t, ok, "block %v", tc.blockNumber)
+		assert.Equal(t, tc.name, name, "block %v", tc.blockNumber)
+		assert.Equal(t, big.NewInt(tc.atBlock), atBlock, "block %v", tc.blockNumber)
+	}
+
+	_, _, ok = config.NextTransition(big.NewInt(30))
+	assert.False(t, ok)
+
+	// the transitions at the genesis are reported before it only
+	config.TestQBFTBlock = big.NewInt(0)
+	name, atBlock, ok := config.NextTransition(nil)
+	assert.True(t, ok)
+	assert.Equal(t, "TestQBFTBlock", name)
+	assert.Equal(t, big.NewInt(0), atBlock)
+	name, _, _ = config.NextTransition(big.NewInt(0))
+	assert.Equal(t, "Ceil2Nby3Block", name)
+}
+
+func TestConfig_Hash_LocalSettings(t *testing.T) {
+	config := DefaultConfig()
+	hash := config.Hash()
+
+	local := DefaultConfig()
+	local.RequestTimeout = 20000
+	local.AllowedFutureBlockTime = 5
+	local.AllowedFutureBlockTimeSchedule = []AllowedFutureBlockTimeTransition{{Block: big.NewInt(10), AllowedFutureBlockTime: 3}}
+	local.PersistValidatorSets = true
+	local.MinValidators = 3
+	local.Clock = RealClock{}
+	assert.Equal(t, hash, local.Hash())
+
+	// the same period in milliseconds
+	local.BlockPeriod, local.BlockPeriodMillis = 0, 1000
+	assert.Equal(t, hash, local.Hash())
+}
+
+func TestConfig_Hash_ProposerSortBySwitch(t *testing.T) {
+	config := DefaultConfig()
+	config.TestQBFTBlock = big.NewInt(10)
+	hash := config.Hash()
+
+	// the engine switches the sort function of the policy at the qbft fork
+	assert.NoError(t, config.ProposerPolicy.Use(ValidatorSortByByte()))
+	assert.Equal(t, hash, config.Hash())
+}
+
+func TestConfig_Hash_ConcurrentUse(t *testing.T) {
+	config := DefaultConfig()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			config.ProposerPolicy.Use(ValidatorSortByByte())
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		config.Hash()
+	}
+	<-done
+}
+
+func TestConfig_Hash_ConsensusSettings(t *testing.T) {
+	seed := common.HexToHash("0x1")
+	for name, change := range map[string]func(c *Config){
+		"epoch":              func(c *Config) { c.Epoch = 100 },
+		"blockPeriod":        func(c *Config) { c.BlockPeriod = 2 },
+		"blockPeriodMillis":  func(c *Config) { c.BlockPeriod, c.BlockPeriodMillis = 0, 1500 },
+		"proposerPolicyId":   func(c *Config) { c.ProposerPolicy = NewStickyProposerPolicy() },
+		"proposerSeed":       func(c *Config) { c.ProposerPolicy.Seed = &seed },
+		"roundRobinFallback": func(c *Config) { c.ProposerPolicy.RoundRobinFallback = true },
+		"proposerCooldown":   func(c *Config) { c.ProposerPolicy.ProposerCooldown = 1 },
+		"qbftSortBy":         func(c *Config) { c.QBFTValidatorSortBy = "stringDesc" },
+		"testQBFTBlock":      func(c *Config) { c.TestQBFTBlock = big.NewInt(10) },
+		"qbftUndefined":      func(c *Config) { c.TestQBFTBlock = nil },
+		"ceil2Nby3Undefined": func(c *Config) { c.Ceil2Nby3Block = nil },
+		"validatorWeights":   func(c *Config) { c.ValidatorWeights = map[common.Address]uint64{{1}: 2} },
+	} {
+		config := DefaultConfig()
+		change(config)
+		assert.NotEqual(t, DefaultConfig().Hash(), config.Hash(), name)
+	}
+}
+
+func TestProposerPolicy_ZeroValue(t *testing.T) {
+	for name, call := range map[string]func(p *ProposerPolicy){
+		"Use":                       func(p *ProposerPolicy) { assert.NoError(t, p.Use(ValidatorSortByByte())) },
+		"RegisterValidatorSet":      func(p *ProposerPolicy) { p.RegisterValidatorSet(1, namedValidatorSet{name: "first"}) },
+		"SetSelectionObserver":      func(p *ProposerPolicy) { p.SetSelectionObserver(func(uint64, uint64, common.Address) {}) },
+		"NotifySelection":           func(p *ProposerPolicy) { p.NotifySelection(1, 0, common.HexToAddress("0x1")) },
+		"ClearRegistry":             func(p *ProposerPolicy) { p.ClearRegistry() },
+		"RecordOrderedValidatorsAt": func(p *ProposerPolicy) { p.RecordOrderedValidatorsAt(1) },
+		"OrderedValidatorsAt": func(p *ProposerPolicy) {
+			_, err := p.OrderedValidatorsAt(1)
+			assert.True(t, errors.Is(err, ErrNoValidatorSetRegistered), "unexpected error %v", err)
+		},
+	} {
+		p := &ProposerPolicy{Id: RoundRobin}
+		assert.NotPanics(t, func() { call(p) }, name)
+	}
+
+	// the sort function defaults to ValidatorSortByString
+	p := &ProposerPolicy{Id: RoundRobin}
+	p.RegisterValidatorSet(1, namedValidatorSet{name: "first"})
+	name, err := validatorSortByName(p.By)
+	assert.NoError(t, err)
+	assert.Equal(t, "", name)
+	assert.NotNil(t, p.By)
+}
+
+func TestProposerPolicy_Info(t *testing.T) {
+	seed := common.HexToHash("0x1")
+	p := NewRoundRobinProposerPolicy()
+	p.Seed = &seed
+	p.ProposerCooldown = 2
+	assert.Equal(t, ProposerPolicyInfo{Id: RoundRobin, Name: "roundrobin", SortBy: "string", Seed: &seed, ProposerCooldown: 2}, p.Info())
+
+	p = NewStickyProposerPolicy()
+	p.RoundRobinFallback = true
+	assert.NoError(t, p.Use(ValidatorSortByStringDesc()))
+	assert.Equal(t, ProposerPolicyInfo{Id: Sticky, Name: "sticky", SortBy: "stringDesc", RoundRobinFallback: true}, p.Info())
+
+	assert.NoError(t, p.Use(func(v1 Validator, v2 Validator) bool { return false }))
+	assert.Equal(t, "custom", p.Info().SortBy)
+}
@@ -16,6 +16,8 @@
 
 package istanbul
 
+import "github.com/kisexp/xdchain/common"
+
 // RequestEvent is posted to propose a proposal
 type RequestEvent struct {
 	Proposal Proposal
@@ -30,3 +32,13 @@ type MessageEvent struct {
 // FinalCommittedEvent is posted when a proposal is committed
 type FinalCommittedEvent struct {
 }
+
+// ValidatorSetSizeChangeEvent is posted when the number of validators at an epoch checkpoint differs from
+// the number at the previous checkpoint
+type ValidatorSetSizeChangeEvent struct {
+	EpochBlock uint64           // Block height of the checkpoint
+	OldSize    int              // Number of validators at the previous checkpoint
+	NewSize    int              // Number of validators at the checkpoint
+	Added      []common.Address // Validators at the checkpoint which weren't at the previous one
+	Removed    []common.Address // Validators at the previous checkpoint which aren't anymore
+}
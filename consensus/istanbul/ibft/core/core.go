@@ -53,6 +53,7 @@ func New(backend istanbul.Backend, config *istanbul.Config) *core {
 		pendingRequests:    prque.New(),
 		pendingRequestsMu:  new(sync.Mutex),
 		consensusTimestamp: time.Time{},
+		roundMetrics:       istanbul.NewRoundMetrics("consensus/istanbul/core"),
 	}
 
 	c.validateFn = c.checkValidatorSignature
@@ -71,7 +72,7 @@ type core struct {
 	events                *event.TypeMuxSubscription
 	finalCommittedSub     *event.TypeMuxSubscription
 	timeoutSub            *event.TypeMuxSubscription
-	futurePreprepareTimer *time.Timer
+	futurePreprepareTimer istanbul.Timer
 
 	valSet                istanbul.ValidatorSet
 	waitingForRoundChange bool
@@ -84,12 +85,14 @@ type core struct {
 	handlerWg *sync.WaitGroup
 
 	roundChangeSet   *roundChangeSet
-	roundChangeTimer *time.Timer
+	roundChangeTimer istanbul.Timer
 
 	pendingRequests   *prque.Prque
 	pendingRequestsMu *sync.Mutex
 
 	consensusTimestamp time.Time
+
+	roundMetrics *istanbul.RoundMetrics
 }
 
 func (c *core) finalizeMessage(msg *ibfttypes.Message) ([]byte, error) {
@@ -158,6 +161,11 @@ func (c *core) IsProposer() bool {
 	return v.IsProposer(c.backend.Address())
 }
 
+// RoundMetrics returns the round change metrics of the engine
+func (c *core) RoundMetrics() istanbul.RoundMetricsSnapshot {
+	return c.roundMetrics.Snapshot()
+}
+
 func (c *core) IsCurrentProposal(blockHash common.Hash) bool {
 	return c.current != nil && c.current.pendingRequest != nil && c.current.pendingRequest.Proposal.Hash() == blockHash
 }
@@ -202,7 +210,7 @@ func (c *core) startNewRound(round *big.Int) {
 		sequenceMeter.Mark(new(big.Int).Add(diff, common.Big1).Int64())
 
 		if !c.consensusTimestamp.IsZero() {
-			consensusTimer.UpdateSince(c.consensusTimestamp)
+			consensusTimer.Update(c.config.GetClock().Now().Sub(c.consensusTimestamp))
 			c.consensusTimestamp = time.Time{}
 		}
 		logger.Trace("Catch up latest proposal", "number", lastProposal.Number().Uint64(), "hash", lastProposal.Hash())
@@ -250,8 +258,17 @@ func (c *core) startNewRound(round *big.Int) {
 	c.roundChangeSet = newRoundChangeSet(c.valSet)
 	// New snapshot for new round
 	c.updateRoundState(newView, c.valSet, roundChange)
+	if roundChange {
+		c.roundMetrics.MarkRoundChange()
+	} else {
+		c.roundMetrics.ResetTimeouts()
+	}
 	// Calculate new proposer
+	c.config.Policy().RecordProposerAt(lastProposal.Number().Uint64(), lastProposer)
 	c.valSet.CalcProposer(lastProposer, newView.Round.Uint64())
+	if proposer := c.valSet.GetProposer(); proposer != nil {
+		c.config.Policy().NotifySelection(newView.Sequence.Uint64(), newView.Round.Uint64(), proposer.Address())
+	}
 	c.waitingForRoundChange = false
 	c.setState(ibfttypes.StateAcceptRequest)
 	if roundChange && c.IsProposer() && c.current != nil {
@@ -281,6 +298,7 @@ func (c *core) catchUpRound(view *istanbul.View) {
 
 	// Need to keep block locked for round catching up
 	c.updateRoundState(view, c.valSet, true)
+	c.roundMetrics.MarkRoundChange()
 	c.roundChangeSet.Clear(view.Round)
 	c.newRoundChangeTimer()
 
@@ -337,7 +355,8 @@ func (c *core) newRoundChangeTimer() {
 	if round > 0 {
 		timeout += time.Duration(math.Pow(2, float64(round))) * time.Second
 	}
-	c.roundChangeTimer = time.AfterFunc(timeout, func() {
+	c.roundMetrics.UpdateRoundTimeout(timeout)
+	c.roundChangeTimer = c.config.GetClock().AfterFunc(timeout, func() {
 		c.sendEvent(timeoutEvent{})
 	})
 }
@@ -347,12 +366,17 @@ func (c *core) checkValidatorSignature(data []byte, sig []byte) (common.Address,
 }
 
 func (c *core) QuorumSize() int {
-	if c.config.Ceil2Nby3Block == nil || (c.current != nil && c.current.sequence.Cmp(c.config.Ceil2Nby3Block) < 0) {
+	// without a current sequence, the Ceil(2N/3) rule applies as soon as it is defined
+	sequence := c.config.Ceil2Nby3Block
+	if c.current != nil {
+		sequence = c.current.sequence
+	}
+	if c.config.IsCeil2Nby3Block(sequence) {
+		c.logger.Trace("Confirmation Formula used ceil(2N/3)")
+	} else {
 		c.logger.Trace("Confirmation Formula used 2F+ 1")
-		return (2 * c.valSet.F()) + 1
 	}
-	c.logger.Trace("Confirmation Formula used ceil(2N/3)")
-	return int(math.Ceil(float64(2*c.valSet.Size()) / 3))
+	return c.config.QuorumSize(c.valSet.Size(), sequence)
 }
 
 // PrepareCommittedSeal returns a committed seal for the given hash
@@ -26,6 +26,7 @@ import (
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/consensus/istanbul"
 	ibfttypes "github.com/kisexp/xdchain/consensus/istanbul/ibft/types"
+	"github.com/kisexp/xdchain/consensus/istanbul/testutils"
 	"github.com/kisexp/xdchain/core/types"
 	elog "github.com/kisexp/xdchain/log"
 )
@@ -130,3 +131,101 @@ func TestNilCommittedSealWithEmptyProposal(t *testing.T) {
 		t.Errorf("Unexpected committed seal: %s", msg.CommittedSeal)
 	}
 }
+
+func TestRoundMetrics(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	backend := sys.backends[0]
+	c := backend.engine
+	defer c.stopTimer()
+
+	c.roundMetrics.MarkTimeout()
+	c.startNewRound(big.NewInt(1))
+
+	baseTimeout := time.Duration(c.config.RequestTimeout) * time.Millisecond
+	expected := istanbul.RoundMetricsSnapshot{RoundChanges: 1, RoundTimeout: baseTimeout + 2*time.Second, ConsecutiveTimeouts: 1}
+	if have := c.RoundMetrics(); have != expected {
+		t.Errorf("round metrics mismatch after round change: have %+v, want %+v", have, expected)
+	}
+
+	backend.committedMsgs = append(backend.committedMsgs, testCommittedMsgs{commitProposal: makeBlock(1)})
+	c.startNewRound(common.Big0)
+
+	expected = istanbul.RoundMetricsSnapshot{RoundChanges: 1, RoundTimeout: baseTimeout, ConsecutiveTimeouts: 0}
+	if have := c.RoundMetrics(); have != expected {
+		t.Errorf("round metrics mismatch after new sequence: have %+v, want %+v", have, expected)
+	}
+}
+
+func TestProposerSelectionObserver(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	backend := sys.backends[0]
+	c := backend.engine
+	defer c.stopTimer()
+
+	type selection struct {
+		height, round uint64
+		proposer      common.Address
+	}
+	selections := make(chan selection, 2)
+	c.config.ProposerPolicy.SetSelectionObserver(func(height, round uint64, proposer common.Address) {
+		selections <- selection{height, round, proposer}
+	})
+
+	c.startNewRound(big.NewInt(1))
+	expected := selection{c.current.Sequence().Uint64(), 1, c.valSet.GetProposer().Address()}
+	select {
+	case have := <-selections:
+		if have != expected {
+			t.Errorf("selection mismatch after round change: have %+v, want %+v", have, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("observer not called after round change")
+	}
+
+	c.config.ProposerPolicy.SetSelectionObserver(nil)
+	c.startNewRound(big.NewInt(2))
+	select {
+	case have := <-selections:
+		t.Errorf("unregistered observer called with %+v", have)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRoundChangeTimer_FakeClock(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	backend := sys.backends[0]
+	c := backend.engine
+	clock := testutils.NewFakeClock(time.Unix(1000, 0))
+	c.config.Clock = clock
+	defer c.stopTimer()
+	sub := backend.EventMux().Subscribe(timeoutEvent{})
+	defer sub.Unsubscribe()
+
+	c.newRoundChangeTimer()
+	timeout := time.Duration(c.config.RequestTimeout) * time.Millisecond
+
+	clock.Advance(timeout - time.Millisecond)
+	select {
+	case <-sub.Chan():
+		t.Fatal("round change timer fired before the request timeout")
+	default:
+	}
+
+	// the timer posts the event synchronously, which blocks until received
+	go clock.Advance(time.Millisecond)
+	select {
+	case ev := <-sub.Chan():
+		if _, ok := ev.Data.(timeoutEvent); !ok {
+			t.Errorf("unexpected event %T", ev.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("round change timer didn't fire at the request timeout")
+	}
+
+	// a new timer replaces the running one
+	c.newRoundChangeTimer()
+	c.newRoundChangeTimer()
+	if pending := clock.PendingTimers(); pending != 1 {
+		t.Errorf("pending timers mismatch: have %d, want 1", pending)
+	}
+}
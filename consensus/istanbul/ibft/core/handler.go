@@ -188,6 +188,7 @@ func (c *core) handleCheckedMsg(msg *ibfttypes.Message, src istanbul.Validator)
 }
 
 func (c *core) handleTimeoutMsg() {
+	c.roundMetrics.MarkTimeout()
 	// If we're not waiting for round change yet, we can try to catch up
 	// the max round with F+1 round change message. We only need to catch up
 	// if the max round is larger than current round.
@@ -17,8 +17,6 @@
 package core
 
 import (
-	"time"
-
 	"github.com/kisexp/xdchain/consensus"
 	"github.com/kisexp/xdchain/consensus/istanbul"
 	istanbulcommon "github.com/kisexp/xdchain/consensus/istanbul/common"
@@ -79,6 +77,12 @@ func (c *core) handlePreprepare(msg *ibfttypes.Message, src istanbul.Validator)
 		logger.Warn("Ignore preprepare messages from non-proposer")
 		return istanbulcommon.ErrNotFromProposer
 	}
+	if c.config.StrictProposerCheck {
+		if err := c.checkExpectedProposer(preprepare.Proposal, preprepare.View.Round.Uint64()); err != nil {
+			logger.Warn("Ignore preprepare messages from unexpected proposer", "err", err)
+			return err
+		}
+	}
 
 	// Verify the proposal we received
 	if duration, err := c.backend.Verify(preprepare.Proposal); err != nil {
@@ -86,7 +90,7 @@ func (c *core) handlePreprepare(msg *ibfttypes.Message, src istanbul.Validator)
 		if err == consensus.ErrFutureBlock {
 			logger.Info("Proposed block will be handled in the future", "err", err, "duration", duration)
 			c.stopFuturePreprepareTimer()
-			c.futurePreprepareTimer = time.AfterFunc(duration, func() {
+			c.futurePreprepareTimer = c.config.GetClock().AfterFunc(duration, func() {
 				c.sendEvent(backlogEvent{
 					src: src,
 					msg: msg,
@@ -126,6 +130,31 @@ func (c *core) handlePreprepare(msg *ibfttypes.Message, src istanbul.Validator)
 }
 
 func (c *core) acceptPreprepare(preprepare *istanbul.Preprepare) {
-	c.consensusTimestamp = time.Now()
+	c.consensusTimestamp = c.config.GetClock().Now()
 	c.current.SetPreprepare(preprepare)
 }
+
+// checkExpectedProposer returns ErrNotFromProposer unless the proposal was created by the proposer the ProposerPolicy
+// expects for one of the rounds up to the given one of its height: the proposer of a round may propose again the
+// block created in an earlier round, never a block created by a validator out of turn. The core only checks the
+// sender of the PRE-PREPARE, not the author of the block. The proposal is rejected if the author or the
+// proposers expected can't be determined, e.g. after a restart before the proposer of the parent is recorded
+func (c *core) checkExpectedProposer(proposal istanbul.Proposal, round uint64) error {
+	author, err := c.backend.ProposalAuthor(proposal)
+	if err != nil {
+		c.logger.Warn("unable to get the author of the proposal", "err", err)
+		return istanbulcommon.ErrNotFromProposer
+	}
+	number := proposal.Number().Uint64()
+	for r := uint64(0); r <= round; r++ {
+		expected, err := c.config.Policy().IsExpectedProposer(number, r, author)
+		if err != nil {
+			c.logger.Warn("unable to check the expected proposer", "number", number, "round", r, "err", err)
+			return istanbulcommon.ErrNotFromProposer
+		}
+		if expected {
+			return nil
+		}
+	}
+	return istanbulcommon.ErrNotFromProposer
+}
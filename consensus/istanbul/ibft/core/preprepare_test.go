@@ -21,9 +21,12 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/consensus/istanbul"
 	istanbulcommon "github.com/kisexp/xdchain/consensus/istanbul/common"
 	ibfttypes "github.com/kisexp/xdchain/consensus/istanbul/ibft/types"
+	"github.com/kisexp/xdchain/consensus/istanbul/validator"
+	"github.com/kisexp/xdchain/core/types"
 )
 
 func newTestPreprepare(v *istanbul.View) *istanbul.Preprepare {
@@ -298,3 +301,52 @@ func TestHandlePreprepareWithLock(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckExpectedProposer(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine
+	policy := istanbul.NewRoundRobinProposerPolicy()
+	c.config.ProposerPolicy = policy
+	var addrs []common.Address
+	for _, b := range sys.backends {
+		addrs = append(addrs, b.address)
+	}
+	policy.RegisterValidatorSet(0, validator.NewSet(addrs, policy))
+	proposalBy := func(author common.Address) istanbul.Proposal {
+		return types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Coinbase: author})
+	}
+
+	// the proposer of the parent isn't recorded
+	if err := c.checkExpectedProposer(proposalBy(addrs[0]), 0); err != istanbulcommon.ErrNotFromProposer {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbulcommon.ErrNotFromProposer)
+	}
+
+	policy.RecordProposerAt(0, common.Address{})
+	proposers := make([]common.Address, 2)
+	for round := range proposers {
+		for _, addr := range addrs {
+			if expected, _ := policy.IsExpectedProposer(1, uint64(round), addr); expected {
+				proposers[round] = addr
+			}
+		}
+	}
+	if proposers[0] == proposers[1] {
+		t.Fatalf("same proposer %v for rounds 0 and 1", proposers[0])
+	}
+	testCases := []struct {
+		author   common.Address
+		round    uint64
+		expected error
+	}{
+		{proposers[0], 0, nil},
+		{proposers[1], 0, istanbulcommon.ErrNotFromProposer},
+		{proposers[1], 1, nil},
+		// the block created in round 0 proposed again in round 1
+		{proposers[0], 1, nil},
+	}
+	for _, test := range testCases {
+		if err := c.checkExpectedProposer(proposalBy(test.author), test.round); err != test.expected {
+			t.Errorf("error mismatch for the block of %v in round %d: have %v, want %v", test.author, test.round, err, test.expected)
+		}
+	}
+}
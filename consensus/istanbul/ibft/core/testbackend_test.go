@@ -26,6 +26,7 @@ import (
 	ibfttypes "github.com/kisexp/xdchain/consensus/istanbul/ibft/types"
 	"github.com/kisexp/xdchain/consensus/istanbul/validator"
 	"github.com/kisexp/xdchain/core/rawdb"
+	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/crypto"
 	"github.com/kisexp/xdchain/ethdb"
 	"github.com/kisexp/xdchain/event"
@@ -165,6 +166,11 @@ func (self *testSystemBackend) ParentValidators(proposal istanbul.Proposal) ista
 	return self.peers
 }
 
+// ProposalAuthor returns the coinbase of the proposed block
+func (self *testSystemBackend) ProposalAuthor(proposal istanbul.Proposal) (common.Address, error) {
+	return proposal.(*types.Block).Coinbase(), nil
+}
+
 func (sb *testSystemBackend) Close() error {
 	return nil
 }
@@ -217,7 +223,7 @@ func NewTestSystemWithBackend(n, f uint64) *testSystem {
 
 	addrs := generateValidators(int(n))
 	sys := newTestSystem(n)
-	config := istanbul.DefaultConfig
+	config := istanbul.DefaultConfig()
 
 	for i := uint64(0); i < n; i++ {
 		vset := validator.NewSet(addrs, istanbul.NewRoundRobinProposerPolicy())
@@ -80,7 +80,7 @@ func (e *Engine) VerifyBlockProposal(chain consensus.ChainHeaderReader, block *t
 		// ignore errEmptyCommittedSeals error because we don't have the committed seals yet
 		return 0, nil
 	} else if err == consensus.ErrFutureBlock {
-		return time.Until(time.Unix(int64(block.Header().Time), 0)), consensus.ErrFutureBlock
+		return time.Unix(int64(block.Header().Time), 0).Sub(e.cfg.GetClock().Now()), consensus.ErrFutureBlock
 	}
 
 	return 0, err
@@ -100,7 +100,7 @@ func (e *Engine) verifyHeader(chain consensus.ChainHeaderReader, header *types.H
 	}
 
 	// Don't waste time checking blocks from the future (adjusting for allowed threshold)
-	adjustedTimeNow := time.Now().Add(time.Duration(e.cfg.AllowedFutureBlockTime) * time.Second).Unix()
+	adjustedTimeNow := e.cfg.GetClock().Now().Add(time.Duration(e.cfg.AllowedFutureBlockTimeAt(header.Number)) * time.Second).Unix()
 	if header.Time > uint64(adjustedTimeNow) {
 		return consensus.ErrFutureBlock
 	}
@@ -156,7 +156,7 @@ func (e *Engine) verifyCascadingFields(chain consensus.ChainHeaderReader, header
 	}
 
 	// Ensure that the block's timestamp isn't too close to it's parent
-	if parent.Time+e.cfg.BlockPeriod > header.Time {
+	if parent.Time+e.cfg.MinBlockTimestampGap() > header.Time {
 		return istanbulcommon.ErrInvalidTimestamp
 	}
 
@@ -284,9 +284,9 @@ func (e *Engine) Prepare(chain consensus.ChainHeaderReader, header *types.Header
 	header.Extra = extra
 
 	// set header's timestamp
-	header.Time = parent.Time + e.cfg.BlockPeriod
-	if header.Time < uint64(time.Now().Unix()) {
-		header.Time = uint64(time.Now().Unix())
+	header.Time = parent.Time + e.cfg.MinBlockTimestampGap()
+	if now := uint64(e.cfg.GetClock().Now().Unix()); header.Time < now {
+		header.Time = now
 	}
 
 	return nil
@@ -0,0 +1,71 @@
+package istanbul
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/kisexp/xdchain/metrics"
+)
+
+// RoundMetrics tracks the round changes of a consensus engine.
+//
+// Frequent round timeouts indicate trouble in the network, the engine updates the metrics as its
+// rounds change and time out so they can be alerted on. Every update is also published to the
+// metrics registry under the prefix given to NewRoundMetrics.
+type RoundMetrics struct {
+	roundChanges        uint64 // accessed atomically
+	roundTimeout        int64  // accessed atomically
+	consecutiveTimeouts uint64 // accessed atomically
+
+	roundChangesCounter      metrics.Counter
+	roundTimeoutGauge        metrics.Gauge
+	consecutiveTimeoutsGauge metrics.Gauge
+}
+
+// RoundMetricsSnapshot is a copy of the RoundMetrics at a point in time
+type RoundMetricsSnapshot struct {
+	RoundChanges        uint64        // number of round changes since the engine started
+	RoundTimeout        time.Duration // timeout of the current round, i.e. the RequestTimeout after backoff
+	ConsecutiveTimeouts uint64        // number of rounds which timed out since the current sequence started
+}
+
+// NewRoundMetrics creates round metrics published under the given prefix
+func NewRoundMetrics(prefix string) *RoundMetrics {
+	return &RoundMetrics{
+		roundChangesCounter:      metrics.GetOrRegisterCounter(prefix+"/roundchanges", nil),
+		roundTimeoutGauge:        metrics.GetOrRegisterGauge(prefix+"/roundtimeout", nil),
+		consecutiveTimeoutsGauge: metrics.GetOrRegisterGauge(prefix+"/consecutivetimeouts", nil),
+	}
+}
+
+// MarkRoundChange records a move to a new round of the current sequence
+func (m *RoundMetrics) MarkRoundChange() {
+	atomic.AddUint64(&m.roundChanges, 1)
+	m.roundChangesCounter.Inc(1)
+}
+
+// MarkTimeout records the timeout of the current round
+func (m *RoundMetrics) MarkTimeout() {
+	m.consecutiveTimeoutsGauge.Update(int64(atomic.AddUint64(&m.consecutiveTimeouts, 1)))
+}
+
+// ResetTimeouts clears the consecutive timeouts once a new sequence starts
+func (m *RoundMetrics) ResetTimeouts() {
+	atomic.StoreUint64(&m.consecutiveTimeouts, 0)
+	m.consecutiveTimeoutsGauge.Update(0)
+}
+
+// UpdateRoundTimeout records the timeout of the current round
+func (m *RoundMetrics) UpdateRoundTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&m.roundTimeout, int64(timeout))
+	m.roundTimeoutGauge.Update(timeout.Milliseconds())
+}
+
+// Snapshot returns the current values of the metrics
+func (m *RoundMetrics) Snapshot() RoundMetricsSnapshot {
+	return RoundMetricsSnapshot{
+		RoundChanges:        atomic.LoadUint64(&m.roundChanges),
+		RoundTimeout:        time.Duration(atomic.LoadInt64(&m.roundTimeout)),
+		ConsecutiveTimeouts: atomic.LoadUint64(&m.consecutiveTimeouts),
+	}
+}
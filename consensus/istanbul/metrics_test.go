@@ -0,0 +1,27 @@
+package istanbul
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundMetrics(t *testing.T) {
+	m := NewRoundMetrics("consensus/istanbul/test")
+	assert.Equal(t, RoundMetricsSnapshot{}, m.Snapshot())
+
+	m.UpdateRoundTimeout(10 * time.Second)
+	m.MarkTimeout()
+	m.MarkRoundChange()
+	m.UpdateRoundTimeout(20 * time.Second)
+	m.MarkTimeout()
+	m.MarkRoundChange()
+
+	assert.Equal(t, RoundMetricsSnapshot{RoundChanges: 2, RoundTimeout: 20 * time.Second, ConsecutiveTimeouts: 2}, m.Snapshot())
+
+	m.ResetTimeouts()
+	m.UpdateRoundTimeout(10 * time.Second)
+
+	assert.Equal(t, RoundMetricsSnapshot{RoundChanges: 2, RoundTimeout: 10 * time.Second, ConsecutiveTimeouts: 0}, m.Snapshot())
+}
@@ -0,0 +1,590 @@
+package istanbul
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/ethdb"
+	"github.com/kisexp/xdchain/log"
+	"github.com/kisexp/xdchain/metrics"
+	"github.com/kisexp/xdchain/rlp"
+)
+
+const (
+	// dbKeyProposerRegistryPrefix prefixes the keys of the stored ValidatorSets, followed by the
+	// big endian encoded block height from which they apply
+	dbKeyProposerRegistryPrefix = "istanbul-proposer-registry-"
+
+	// proposerRegistryRetention is the number of block heights for which the registry is kept
+	proposerRegistryRetention = 128
+)
+
+// ErrNoValidatorSetRegistered is returned when no ValidatorSet registered to the proposer policy applies
+// to a block height
+var ErrNoValidatorSetRegistered = errors.New("no validator set registered")
+
+// ErrNoProposerRecorded is returned when the proposer of the block preceding a block height isn't recorded
+var ErrNoProposerRecorded = errors.New("no proposer recorded")
+
+// StoredValidatorSet holds the validator addresses of a ValidatorSet stored to the database and the
+// block height from which it applies
+type StoredValidatorSet struct {
+	Number     uint64
+	Validators []common.Address
+}
+
+// proposerRegistryKey = dbKeyProposerRegistryPrefix + number (uint64 big endian)
+func proposerRegistryKey(number uint64) []byte {
+	key := make([]byte, len(dbKeyProposerRegistryPrefix)+8)
+	copy(key, dbKeyProposerRegistryPrefix)
+	binary.BigEndian.PutUint64(key[len(dbKeyProposerRegistryPrefix):], number)
+	return key
+}
+
+// firstRetainedHeight returns the first block height of the retention window ending at number
+func firstRetainedHeight(number uint64) uint64 {
+	if number < proposerRegistryRetention {
+		return 0
+	}
+	return number - proposerRegistryRetention + 1
+}
+
+// StoreValidatorSet writes the validator addresses of the ValidatorSet applicable to the given block height
+// to the database. Nothing is written if they are the ones stored for the closest lower height, so the
+// database is only written to when the validators change.
+//
+// The ValidatorSets stored for greater heights, left over by a rewind of the chain, are removed, as well as
+// the ones falling out of the retention window except the newest of them which still applies to the first
+// heights of the window.
+func StoreValidatorSet(db ethdb.Database, number uint64, valSet ValidatorSet) error {
+	stored, err := readValidatorSets(db)
+	if err != nil {
+		return err
+	}
+	validators := valSet.List()
+	addrs := make([]common.Address, len(validators))
+	for i, validator := range validators {
+		addrs[i] = validator.Address()
+	}
+
+	batch := db.NewBatch()
+	kept := stored[:0]
+	for _, valSet := range stored {
+		if valSet.Number > number {
+			if err := batch.Delete(proposerRegistryKey(valSet.Number)); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, valSet)
+	}
+	if len(kept) == 0 || !equalAddresses(kept[len(kept)-1].Validators, addrs) {
+		blob, err := rlp.EncodeToBytes(addrs)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(proposerRegistryKey(number), blob); err != nil {
+			return err
+		}
+		if len(kept) > 0 && kept[len(kept)-1].Number == number {
+			kept = kept[:len(kept)-1]
+		}
+		kept = append(kept, StoredValidatorSet{Number: number, Validators: addrs})
+	}
+
+	// the newest ValidatorSet applicable to the first height of the window is kept
+	firstRetained := firstRetainedHeight(number)
+	for i := 0; i+1 < len(kept) && kept[i+1].Number <= firstRetained; i++ {
+		if err := batch.Delete(proposerRegistryKey(kept[i].Number)); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// LoadValidatorSets reads the ValidatorSets stored for the retention window ending at the given block
+// height, in increasing order of height. The first one may have been stored for a height before the
+// window if the validators haven't changed since.
+func LoadValidatorSets(db ethdb.Iteratee, number uint64) ([]StoredValidatorSet, error) {
+	stored, err := readValidatorSets(db)
+	if err != nil {
+		return nil, err
+	}
+	firstRetained := firstRetainedHeight(number)
+	first := 0
+	var window []StoredValidatorSet
+	for i, valSet := range stored {
+		if valSet.Number > number {
+			break
+		}
+		if valSet.Number <= firstRetained {
+			first = i
+		}
+		window = stored[first : i+1]
+	}
+	return window, nil
+}
+
+// readValidatorSets reads all the ValidatorSets stored to the database, in increasing order of height
+func readValidatorSets(db ethdb.Iteratee) ([]StoredValidatorSet, error) {
+	it := db.NewIterator([]byte(dbKeyProposerRegistryPrefix), nil)
+	defer it.Release()
+
+	var stored []StoredValidatorSet
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(dbKeyProposerRegistryPrefix)+8 {
+			continue
+		}
+		valSet := StoredValidatorSet{Number: binary.BigEndian.Uint64(key[len(dbKeyProposerRegistryPrefix):])}
+		if err := rlp.DecodeBytes(it.Value(), &valSet.Validators); err != nil {
+			return nil, fmt.Errorf("invalid validator set stored for block %d: %v", valSet.Number, err)
+		}
+		stored = append(stored, valSet)
+	}
+	return stored, it.Error()
+}
+
+// registryGuard reports the number of ValidatorSets registered to a ProposerPolicy
+type registryGuard struct {
+	gauge   metrics.Gauge // updated with the size of the registry, if set
+	softCap int           // size above which a warning is logged, no cap if 0
+	prune   bool          // prune the oldest ValidatorSets once softCap is exceeded
+	overCap bool          // set once the warning is logged, until the registry is back under softCap
+}
+
+// SetRegistryGuard makes the policy report the number of ValidatorSets in its registry to the gauge, and
+// log a warning once it exceeds the soft cap, if positive. If prune is set, the registry is then pruned
+// with PruneRegistryBelow down to the ValidatorSets of the softCap highest block heights.
+func (p *ProposerPolicy) SetRegistryGuard(gauge metrics.Gauge, softCap int, prune bool) {
+	p.ensureInitialized()
+	p.registryMU.Lock()
+	defer p.registryMU.Unlock()
+
+	p.registryGuard = registryGuard{gauge: gauge, softCap: softCap, prune: prune}
+	p.checkRegistrySize()
+}
+
+// checkRegistrySize updates the gauge of the registry and enforces its soft cap.
+// The caller must hold registryMU.
+func (p *ProposerPolicy) checkRegistrySize() {
+	guard := &p.registryGuard
+	if guard.softCap > 0 && len(p.registry) > guard.softCap {
+		if !guard.overCap {
+			log.Warn("Proposer policy registry exceeds its soft cap", "size", len(p.registry), "cap", guard.softCap, "prune", guard.prune)
+			guard.overCap = true
+		}
+		if guard.prune {
+			numbers := make([]uint64, len(p.registry))
+			for i, registered := range p.registry {
+				numbers[i] = registered.number
+			}
+			sort.Slice(numbers, func(i, j int) bool { return numbers[i] > numbers[j] })
+			p.pruneRegistryBelow(numbers[guard.softCap-1])
+		}
+	}
+	if guard.softCap <= 0 || len(p.registry) <= guard.softCap {
+		guard.overCap = false
+	}
+	if guard.gauge != nil {
+		guard.gauge.Update(int64(len(p.registry)))
+	}
+}
+
+// PruneRegistryBelow removes the ValidatorSets registered for the block heights below number, except the
+// newest of them if no ValidatorSet is registered for number, as it still applies from number on
+func (p *ProposerPolicy) PruneRegistryBelow(number uint64) {
+	p.ensureInitialized()
+	p.registryMU.Lock()
+	defer p.registryMU.Unlock()
+
+	p.pruneRegistryBelow(number)
+	p.checkRegistrySize()
+}
+
+// pruneRegistryBelow is PruneRegistryBelow without the update of the gauge.
+// The caller must hold registryMU.
+func (p *ProposerPolicy) pruneRegistryBelow(number uint64) {
+	newestBelow := -1
+	for i, registered := range p.registry {
+		if registered.number == number {
+			newestBelow = -1
+			break
+		}
+		if registered.number < number && (newestBelow < 0 || registered.number > p.registry[newestBelow].number) {
+			newestBelow = i
+		}
+	}
+	kept := p.registry[:0]
+	for i, registered := range p.registry {
+		if registered.number >= number || i == newestBelow {
+			kept = append(kept, registered)
+		}
+	}
+	for i := len(kept); i < len(p.registry); i++ {
+		p.registry[i] = registeredValidatorSet{}
+	}
+	p.registry = kept
+}
+
+// RecordOrderedValidatorsAt remembers the proposer order of the ValidatorSet of the given block height, the one
+// registered for the snapshot of its parent, so it can be retrieved with OrderedValidatorsAt once the registry
+// is cleared. Nothing is recorded if no ValidatorSet is registered for the parent, the ValidatorSets registered
+// for other heights, e.g. by the RPC calls, are never recorded in its place.
+// Orders recorded for heights falling out of the retention window are dropped.
+func (p *ProposerPolicy) RecordOrderedValidatorsAt(number uint64) {
+	p.ensureInitialized()
+	p.registryMU.Lock()
+	defer p.registryMU.Unlock()
+
+	if number == 0 {
+		return
+	}
+	valSet := p.closestValidatorSet(number - 1)
+	if valSet == nil {
+		return
+	}
+	if p.orderedValidators == nil {
+		p.orderedValidators = make(map[uint64][]common.Address)
+	}
+	p.orderedValidators[number] = p.ProposerOrder(valSet)
+	for recorded := range p.orderedValidators {
+		if recorded+proposerRegistryRetention <= number {
+			delete(p.orderedValidators, recorded)
+		}
+	}
+}
+
+// OrderedValidatorsAt returns the proposer order of the ValidatorSet applicable to the given block height.
+//
+// The order recorded for the height is used if any, heights past the last recorded one use the
+// ValidatorSets currently registered, otherwise the order recorded for the closest lower height is used.
+// The returned error wraps ErrNoValidatorSetRegistered if no ValidatorSet is applicable.
+func (p *ProposerPolicy) OrderedValidatorsAt(blockNumber uint64) ([]common.Address, error) {
+	p.ensureInitialized()
+	p.registryMU.Lock()
+	defer p.registryMU.Unlock()
+
+	if order, ok := p.orderedValidators[blockNumber]; ok {
+		return copyAddresses(order), nil
+	}
+	var (
+		closest   uint64
+		found     bool
+		newerOnes bool
+	)
+	for recorded := range p.orderedValidators {
+		if recorded > blockNumber {
+			newerOnes = true
+			continue
+		}
+		if !found || recorded > closest {
+			closest, found = recorded, true
+		}
+	}
+	if !newerOnes && len(p.registry) > 0 {
+		return p.ProposerOrder(p.registry[len(p.registry)-1].valSet), nil
+	}
+	if !found {
+		return nil, fmt.Errorf("%w for block %d", ErrNoValidatorSetRegistered, blockNumber)
+	}
+	return copyAddresses(p.orderedValidators[closest]), nil
+}
+
+// IsValidatorAt reports whether the address is one of the validators of the ValidatorSet applicable to the
+// given block height, as returned by OrderedValidatorsAt. The returned error wraps ErrNoValidatorSetRegistered
+// if no ValidatorSet is applicable, membership is then unknown.
+func (p *ProposerPolicy) IsValidatorAt(blockNumber uint64, addr common.Address) (bool, error) {
+	validators, err := p.OrderedValidatorsAt(blockNumber)
+	if err != nil {
+		return false, err
+	}
+	for _, validator := range validators {
+		if validator == addr {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PeekNextProposer returns the proposer the policy selects for the round of the given block height, e.g.
+// to preview it, following the proposer currently selected by the ValidatorSet registered for the closest
+// height not above blockNumber. The selection is made on a copy of the ValidatorSet, so unlike CalcProposer
+// it doesn't change the proposer of the registered set, nor the following selections.
+//
+// The returned error wraps ErrNoValidatorSetRegistered if no ValidatorSet, or an empty one, is registered.
+func (p *ProposerPolicy) PeekNextProposer(blockNumber, round uint64) (common.Address, error) {
+	valSet := p.registeredValidatorSetAt(blockNumber)
+	if valSet == nil || valSet.Size() == 0 {
+		return common.Address{}, fmt.Errorf("%w for block %d", ErrNoValidatorSetRegistered, blockNumber)
+	}
+	var lastProposer common.Address
+	if proposer := valSet.GetProposer(); proposer != nil {
+		lastProposer = proposer.Address()
+	}
+	preview := valSet.Copy()
+	preview.CalcProposer(lastProposer, round)
+	return preview.GetProposer().Address(), nil
+}
+
+// UpcomingProposers returns the proposers of the count blocks from fromBlock, assuming each of them is
+// proposed in its first round. The first one is the proposer returned by PeekNextProposer for round 0, each
+// following one is selected by the ValidatorSet registered for the closest height not above its block from
+// the proposer of the previous block, so the rules of the policy, e.g. the ProposerCooldown, are applied as
+// they are when the blocks are proposed. Like PeekNextProposer, it doesn't change the registered sets.
+//
+// The returned error wraps ErrNoValidatorSetRegistered if no ValidatorSet, or an empty one, is registered for
+// one of the blocks.
+func (p *ProposerPolicy) UpcomingProposers(fromBlock uint64, count int) ([]common.Address, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	proposer, err := p.PeekNextProposer(fromBlock, 0)
+	if err != nil {
+		return nil, err
+	}
+	proposers := make([]common.Address, 1, count)
+	proposers[0] = proposer
+
+	var registered, preview ValidatorSet
+	for blockNumber := fromBlock + 1; len(proposers) < count; blockNumber++ {
+		valSet := p.registeredValidatorSetAt(blockNumber)
+		if valSet == nil || valSet.Size() == 0 {
+			return nil, fmt.Errorf("%w for block %d", ErrNoValidatorSetRegistered, blockNumber)
+		}
+		if valSet != registered {
+			registered, preview = valSet, valSet.Copy()
+		}
+		preview.CalcProposer(proposers[len(proposers)-1], 0)
+		proposers = append(proposers, preview.GetProposer().Address())
+	}
+	return proposers, nil
+}
+
+// ValidatorSetAt returns the ValidatorSet applicable to the given block height: the one registered for the
+// closest height not above it. The registered set itself is returned, it must be copied before its proposer
+// is changed. The returned error wraps ErrNoValidatorSetRegistered if no ValidatorSet is registered for the
+// height or a lower one.
+func (p *ProposerPolicy) ValidatorSetAt(blockNumber uint64) (ValidatorSet, error) {
+	valSet := p.registeredValidatorSetAt(blockNumber)
+	if valSet == nil {
+		return nil, fmt.Errorf("%w for block %d", ErrNoValidatorSetRegistered, blockNumber)
+	}
+	return valSet, nil
+}
+
+// registeredValidatorSetAt returns the ValidatorSet registered for the closest height not above blockNumber,
+// nil if there is none
+func (p *ProposerPolicy) registeredValidatorSetAt(blockNumber uint64) ValidatorSet {
+	p.ensureInitialized()
+	p.registryMU.Lock()
+	defer p.registryMU.Unlock()
+	return p.closestValidatorSet(blockNumber)
+}
+
+// closestValidatorSet is registeredValidatorSetAt for the callers holding p.registryMU
+func (p *ProposerPolicy) closestValidatorSet(blockNumber uint64) ValidatorSet {
+	var valSet ValidatorSet
+	closest := uint64(0)
+	for _, registered := range p.registry {
+		if registered.number <= blockNumber && (valSet == nil || registered.number >= closest) {
+			valSet, closest = registered.valSet, registered.number
+		}
+	}
+	return valSet
+}
+
+// DiffValidatorSets compares the ValidatorSets applicable to the given block heights, as returned by
+// OrderedValidatorsAt. The added validators are in the proposer order at toBlock, the removed ones in
+// the proposer order at fromBlock. An error is returned if no ValidatorSet is applicable to one of the heights.
+func (p *ProposerPolicy) DiffValidatorSets(fromBlock, toBlock uint64) (added, removed []common.Address, err error) {
+	from, err := p.OrderedValidatorsAt(fromBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err := p.OrderedValidatorsAt(toBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+	return missingAddresses(to, from), missingAddresses(from, to), nil
+}
+
+// missingAddresses returns the addresses of addrs which are not in others
+func missingAddresses(addrs, others []common.Address) []common.Address {
+	known := make(map[common.Address]struct{}, len(others))
+	for _, addr := range others {
+		known[addr] = struct{}{}
+	}
+	var missing []common.Address
+	for _, addr := range addrs {
+		if _, ok := known[addr]; !ok {
+			missing = append(missing, addr)
+		}
+	}
+	return missing
+}
+
+func equalAddresses(addrs, others []common.Address) bool {
+	if len(addrs) != len(others) {
+		return false
+	}
+	for i := range addrs {
+		if addrs[i] != others[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyAddresses(addrs []common.Address) []common.Address {
+	cpy := make([]common.Address, len(addrs))
+	copy(cpy, addrs)
+	return cpy
+}
+
+// RecordProposerAt remembers the proposer of the block at the given height, so the proposers expected for the
+// following height can be checked by IsExpectedProposer. The zero address is recorded for the genesis block.
+// Proposers recorded for heights falling out of the retention window are dropped.
+func (p *ProposerPolicy) RecordProposerAt(number uint64, proposer common.Address) {
+	p.ensureInitialized()
+	p.registryMU.Lock()
+	defer p.registryMU.Unlock()
+
+	if p.proposers == nil {
+		p.proposers = make(map[uint64]common.Address)
+	}
+	p.proposers[number] = proposer
+	for recorded := range p.proposers {
+		if recorded+proposerRegistryRetention <= number {
+			delete(p.proposers, recorded)
+		}
+	}
+}
+
+// IsExpectedProposer checks whether addr is the proposer the policy selects for the round of the given block
+// height: the one the ValidatorSet registered for the closest height below it selects from the proposer
+// recorded for the previous block. Unlike ValidatorSet.IsProposer, it catches an out-of-turn proposer which
+// is still a validator. The registered sets aren't changed.
+//
+// The returned error wraps ErrNoValidatorSetRegistered if no ValidatorSet, or an empty one, is registered,
+// or ErrNoProposerRecorded if the proposer of the previous block isn't recorded.
+func (p *ProposerPolicy) IsExpectedProposer(blockNumber, round uint64, addr common.Address) (bool, error) {
+	if blockNumber == 0 {
+		return false, fmt.Errorf("%w for block 0", ErrNoValidatorSetRegistered)
+	}
+	valSet := p.registeredValidatorSetAt(blockNumber - 1)
+	if valSet == nil || valSet.Size() == 0 {
+		return false, fmt.Errorf("%w for block %d", ErrNoValidatorSetRegistered, blockNumber-1)
+	}
+	p.registryMU.Lock()
+	lastProposer, ok := p.proposers[blockNumber-1]
+	p.registryMU.Unlock()
+	if !ok {
+		return false, fmt.Errorf("%w for block %d", ErrNoProposerRecorded, blockNumber-1)
+	}
+	selection := valSet.Copy()
+	selection.CalcProposer(lastProposer, round)
+	return selection.GetProposer().Address() == addr, nil
+}
+
+// ProposerSelection is the proposer the policy selects for the first round of a block, written by
+// ExportSelections
+type ProposerSelection struct {
+	Height   uint64         `json:"height"`
+	Proposer common.Address `json:"proposer"`
+}
+
+// ExportSelections writes to w, as one JSON ProposerSelection per line, the proposer selected for the first
+// round of each block from the from height to the to height included. Like IsExpectedProposer, the proposer
+// of a block is the one the ValidatorSet registered for the closest height below it selects from the proposer
+// of the previous block, the one recorded for the block preceding from, then the selected ones, so the range
+// can span changes of the ValidatorSet. The selections are made on copies, the registered sets aren't changed.
+//
+// The returned error wraps ErrNoValidatorSetRegistered if no ValidatorSet, or an empty one, is registered
+// for one of the blocks, the selections of the previous blocks having been written, or ErrNoProposerRecorded
+// if the proposer of the block preceding from isn't recorded.
+func (p *ProposerPolicy) ExportSelections(from, to uint64, w io.Writer) error {
+	if from == 0 {
+		return errors.New("the genesis block has no proposer")
+	}
+	if from > to {
+		return fmt.Errorf("invalid range of blocks from %d to %d", from, to)
+	}
+	p.ensureInitialized()
+	p.registryMU.Lock()
+	lastProposer, ok := p.proposers[from-1]
+	p.registryMU.Unlock()
+	if !ok {
+		return fmt.Errorf("%w for block %d", ErrNoProposerRecorded, from-1)
+	}
+
+	encoder := json.NewEncoder(w)
+	var registered, selection ValidatorSet
+	for blockNumber := from; ; blockNumber++ {
+		valSet := p.registeredValidatorSetAt(blockNumber - 1)
+		if valSet == nil || valSet.Size() == 0 {
+			return fmt.Errorf("%w for block %d", ErrNoValidatorSetRegistered, blockNumber-1)
+		}
+		if valSet != registered {
+			registered, selection = valSet, valSet.Copy()
+		}
+		selection.CalcProposer(lastProposer, 0)
+		lastProposer = selection.GetProposer().Address()
+		if err := encoder.Encode(ProposerSelection{Height: blockNumber, Proposer: lastProposer}); err != nil {
+			return err
+		}
+		// checked last so that the loop ends at the highest height too
+		if blockNumber == to {
+			return nil
+		}
+	}
+}
+
+// ValidatorSetFactory builds the ValidatorSet of the given validators ordered by the proposer policy
+type ValidatorSetFactory func(validators []common.Address, policy *ProposerPolicy) ValidatorSet
+
+// validatorSetFactory builds the ValidatorSets seeded by SeedFromGenesis, it is set by the validator package
+var (
+	validatorSetFactoryMu sync.RWMutex
+	validatorSetFactory   ValidatorSetFactory
+)
+
+// RegisterValidatorSetFactory sets the factory SeedFromGenesis builds the genesis ValidatorSet with. The
+// validator package registers its ValidatorSet implementation when it is imported.
+func RegisterValidatorSetFactory(factory ValidatorSetFactory) {
+	validatorSetFactoryMu.Lock()
+	defer validatorSetFactoryMu.Unlock()
+	validatorSetFactory = factory
+}
+
+// SeedFromGenesis registers the ValidatorSet of the validators of the genesis extradata for block 0 and
+// records its proposer order, so the initial order doesn't depend on the order the validators are listed
+// in: the validators are sorted by the ValidatorSortByFunc of the policy and, for the seeded round robin
+// policies, permuted by Seed. The given slice isn't modified. The registered set is returned.
+//
+// An error is returned if no ValidatorSetFactory is registered.
+func (p *ProposerPolicy) SeedFromGenesis(validators []common.Address) (ValidatorSet, error) {
+	validatorSetFactoryMu.RLock()
+	factory := validatorSetFactory
+	validatorSetFactoryMu.RUnlock()
+	if factory == nil {
+		return nil, errors.New("no validator set factory registered")
+	}
+	p.ensureInitialized()
+	valSet := factory(append([]common.Address(nil), validators...), p)
+	p.RegisterValidatorSet(0, valSet)
+
+	p.registryMU.Lock()
+	defer p.registryMU.Unlock()
+	if p.orderedValidators == nil {
+		p.orderedValidators = make(map[uint64][]common.Address)
+	}
+	p.orderedValidators[0] = p.ProposerOrder(valSet)
+	return valSet, nil
+}
@@ -105,7 +105,7 @@ func (c *core) handleCommitMsg(commit *qbfttypes.Commit) error {
 	logger = logger.New("commits.count", c.current.QBFTCommits.Size(), "quorum", c.QuorumSize())
 
 	// If we reached thresho
-	if c.current.QBFTCommits.Size() >= c.QuorumSize() {
+	if c.hasQuorum(c.current.QBFTCommits) {
 		logger.Info("QBFT: received quorum of COMMIT messages")
 		c.commitQBFT()
 	} else {
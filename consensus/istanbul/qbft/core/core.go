@@ -52,6 +52,7 @@ func New(backend istanbul.Backend, config *istanbul.Config) istanbul.Core {
 		pendingRequests:    prque.New(),
 		pendingRequestsMu:  new(sync.Mutex),
 		consensusTimestamp: time.Time{},
+		roundMetrics:       istanbul.NewRoundMetrics("consensus/istanbul/qbft/core"),
 	}
 
 	c.validateFn = c.checkValidatorSignature
@@ -70,7 +71,7 @@ type core struct {
 	events                *event.TypeMuxSubscription
 	finalCommittedSub     *event.TypeMuxSubscription
 	timeoutSub            *event.TypeMuxSubscription
-	futurePreprepareTimer *time.Timer
+	futurePreprepareTimer istanbul.Timer
 
 	valSet     istanbul.ValidatorSet
 	validateFn func([]byte, []byte) (common.Address, error)
@@ -82,7 +83,7 @@ type core struct {
 	handlerWg *sync.WaitGroup
 
 	roundChangeSet   *roundChangeSet
-	roundChangeTimer *time.Timer
+	roundChangeTimer istanbul.Timer
 
 	QBFTPreparedPrepares []*qbfttypes.Prepare
 
@@ -90,6 +91,8 @@ type core struct {
 	pendingRequestsMu *sync.Mutex
 
 	consensusTimestamp time.Time
+
+	roundMetrics *istanbul.RoundMetrics
 }
 
 func (c *core) currentView() *istanbul.View {
@@ -107,6 +110,11 @@ func (c *core) IsProposer() bool {
 	return v.IsProposer(c.backend.Address())
 }
 
+// RoundMetrics returns the round change metrics of the engine
+func (c *core) RoundMetrics() istanbul.RoundMetricsSnapshot {
+	return c.roundMetrics.Snapshot()
+}
+
 func (c *core) IsCurrentProposal(blockHash common.Hash) bool {
 	return c.current != nil && c.current.pendingRequest != nil && c.current.pendingRequest.Proposal.Hash() == blockHash
 }
@@ -138,7 +146,7 @@ func (c *core) startNewRound(round *big.Int) {
 		sequenceMeter.Mark(new(big.Int).Add(diff, common.Big1).Int64())
 
 		if !c.consensusTimestamp.IsZero() {
-			consensusTimer.UpdateSince(c.consensusTimestamp)
+			consensusTimer.Update(c.config.GetClock().Now().Sub(c.consensusTimestamp))
 			c.consensusTimestamp = time.Time{}
 		}
 		logger.Debug("QBFT: catch up last block proposal")
@@ -181,9 +189,18 @@ func (c *core) startNewRound(round *big.Int) {
 
 	// New snapshot for new round
 	c.updateRoundState(newView, c.valSet, roundChange)
+	if roundChange {
+		c.roundMetrics.MarkRoundChange()
+	} else {
+		c.roundMetrics.ResetTimeouts()
+	}
 
 	// Calculate new proposer
+	c.config.Policy().RecordProposerAt(lastProposal.Number().Uint64(), lastProposer)
 	c.valSet.CalcProposer(lastProposer, newView.Round.Uint64())
+	if proposer := c.valSet.GetProposer(); proposer != nil {
+		c.config.Policy().NotifySelection(newView.Sequence.Uint64(), newView.Round.Uint64(), proposer.Address())
+	}
 	c.setState(StateAcceptRequest)
 
 	if round.Cmp(c.current.Round()) > 0 {
@@ -258,7 +275,8 @@ func (c *core) newRoundChangeTimer() {
 	timeout := baseTimeout * time.Duration(math.Pow(2, float64(round)))
 
 	c.currentLogger(true, nil).Trace("QBFT: start new ROUND-CHANGE timer", "timeout", timeout.Seconds())
-	c.roundChangeTimer = time.AfterFunc(timeout, func() {
+	c.roundMetrics.UpdateRoundTimeout(timeout)
+	c.roundChangeTimer = c.config.GetClock().AfterFunc(timeout, func() {
 		c.sendEvent(timeoutEvent{})
 	})
 }
@@ -268,12 +286,44 @@ func (c *core) checkValidatorSignature(data []byte, sig []byte) (common.Address,
 }
 
 func (c *core) QuorumSize() int {
-	if c.config.Ceil2Nby3Block == nil || (c.current != nil && c.current.sequence.Cmp(c.config.Ceil2Nby3Block) < 0) {
+	// without a current sequence, the Ceil(2N/3) rule applies as soon as it is defined
+	sequence := c.config.Ceil2Nby3Block
+	if c.current != nil {
+		sequence = c.current.sequence
+	}
+	if c.config.IsCeil2Nby3Block(sequence) {
+		c.currentLogger(true, nil).Trace("QBFT: confirmation Formula used ceil(2N/3)")
+	} else {
 		c.currentLogger(true, nil).Trace("QBFT: confirmation Formula used 2F+ 1")
-		return (2 * c.valSet.F()) + 1
 	}
-	c.currentLogger(true, nil).Trace("QBFT: confirmation Formula used ceil(2N/3)")
-	return int(math.Ceil(float64(2*c.valSet.Size()) / 3))
+	return c.config.QuorumSize(c.valSet.Size(), sequence)
+}
+
+// hasQuorum checks if the messages of the set reach the quorum of the current sequence. With ValidatorWeights
+// configured, the total weight of their senders must reach the WeightedQuorumThreshold of the validator set,
+// otherwise their number must reach the QuorumSize.
+func (c *core) hasQuorum(messages *qbftMsgSet) bool {
+	if len(c.config.ValidatorWeights) == 0 {
+		return messages.Size() >= c.QuorumSize()
+	}
+	sequence := c.current.sequence
+	validators := make([]common.Address, 0, c.valSet.Size())
+	for _, validator := range c.valSet.List() {
+		validators = append(validators, validator.Address())
+	}
+	weights := c.config.VoteWeights(validators, sequence)
+	threshold := c.config.WeightedQuorumThreshold(weights, sequence)
+
+	var weight uint64
+	for _, message := range messages.Values() {
+		if w := weights[message.Source()]; weight > math.MaxUint64-w {
+			weight = math.MaxUint64
+		} else {
+			weight += w
+		}
+	}
+	c.currentLogger(true, nil).Trace("QBFT: weighted quorum", "weight", weight, "threshold", threshold)
+	return weight >= threshold
 }
 
 // PrepareCommittedSeal returns a committed seal for the given header and takes current round under consideration
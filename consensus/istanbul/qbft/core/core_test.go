@@ -0,0 +1,73 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/consensus/istanbul"
+	qbfttypes "github.com/kisexp/xdchain/consensus/istanbul/qbft/types"
+	"github.com/kisexp/xdchain/consensus/istanbul/validator"
+	"github.com/kisexp/xdchain/log"
+)
+
+func newQuorumTestCore(weights map[common.Address]uint64, validators []common.Address) *core {
+	config := istanbul.DefaultConfig()
+	config.TestQBFTBlock = big.NewInt(0)
+	config.ValidatorWeights = weights
+	valSet := validator.NewSet(validators, istanbul.NewRoundRobinProposerPolicy())
+	view := &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)}
+	return &core{
+		config:  config,
+		valSet:  valSet,
+		current: newRoundState(view, valSet, nil, nil, nil, nil, func(common.Hash) bool { return false }),
+		logger:  log.New(),
+	}
+}
+
+func addPrepares(c *core, sources ...common.Address) {
+	for _, source := range sources {
+		c.current.QBFTPrepares.Add(qbfttypes.NewPrepareWithSigAndSource(big.NewInt(1), big.NewInt(0), common.Hash{}, nil, source))
+	}
+}
+
+func TestHasQuorum_Weighted(t *testing.T) {
+	heavy, v1, v2, v3 := common.Address{1}, common.Address{2}, common.Address{3}, common.Address{4}
+	validators := []common.Address{heavy, v1, v2, v3}
+
+	// the total weight is 7, the threshold is Ceil(14/3) = 5
+	c := newQuorumTestCore(map[common.Address]uint64{heavy: 4}, validators)
+	addPrepares(c, v1, v2, v3)
+	if c.hasQuorum(c.current.QBFTPrepares) {
+		t.Errorf("quorum reached by 3 light validators weighing 3")
+	}
+
+	c = newQuorumTestCore(map[common.Address]uint64{heavy: 4}, validators)
+	addPrepares(c, heavy, v1)
+	if !c.hasQuorum(c.current.QBFTPrepares) {
+		t.Errorf("quorum missed by the heavy validator and a light one weighing 5")
+	}
+
+	// the messages from outside the validator set weigh nothing
+	c = newQuorumTestCore(map[common.Address]uint64{heavy: 4}, validators)
+	addPrepares(c, heavy, common.Address{5})
+	if c.hasQuorum(c.current.QBFTPrepares) {
+		t.Errorf("quorum reached with the message of a non validator")
+	}
+}
+
+func TestHasQuorum_Unweighted(t *testing.T) {
+	validators := []common.Address{{1}, {2}, {3}, {4}, {5}}
+
+	// without weights every validator counts for 1
+	c := newQuorumTestCore(nil, validators)
+	quorum := c.QuorumSize()
+	addPrepares(c, validators[:quorum-1]...)
+	if c.hasQuorum(c.current.QBFTPrepares) {
+		t.Errorf("quorum reached by %d out of 5 validators, expected %d", quorum-1, quorum)
+	}
+	addPrepares(c, validators[quorum-1])
+	if !c.hasQuorum(c.current.QBFTPrepares) {
+		t.Errorf("quorum missed by %d out of 5 validators", quorum)
+	}
+}
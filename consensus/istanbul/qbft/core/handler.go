@@ -224,6 +224,7 @@ func (c *core) deliverMessage(m qbfttypes.QBFTMessage) error {
 }
 
 func (c *core) handleTimeoutMsg() {
+	c.roundMetrics.MarkTimeout()
 	logger := c.currentLogger(true, nil)
 	// Start the new round
 	round := c.current.Round()
@@ -80,7 +80,9 @@ func testParameterizedCase(
 	messageJustified bool) {
 
 	pp := istanbul.NewRoundRobinProposerPolicy()
-	pp.Use(istanbul.ValidatorSortByByte())
+	if err := pp.Use(istanbul.ValidatorSortByByte()); err != nil {
+		t.Fatalf("unable to use the byte sorter: %v", err)
+	}
 	validatorSet := validator.NewSet(generateValidators(quorumSize), pp)
 	block := makeBlock(1)
 	var round int64 = 10
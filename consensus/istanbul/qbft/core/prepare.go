@@ -91,7 +91,7 @@ func (c *core) handlePrepare(prepare *qbfttypes.Prepare) error {
 
 	// Change to "Prepared" state if we've received quorum of PREPARE messages
 	// and we are in earlier state than "Prepared"
-	if (c.current.QBFTPrepares.Size() >= c.QuorumSize()) && c.state.Cmp(StatePrepared) < 0 {
+	if c.hasQuorum(c.current.QBFTPrepares) && c.state.Cmp(StatePrepared) < 0 {
 		logger.Info("QBFT: received quorum of PREPARE messages")
 
 		// Accumulates PREPARE messages
@@ -17,10 +17,9 @@
 package core
 
 import (
-	"time"
-
 	"github.com/kisexp/xdchain/common/hexutil"
 	"github.com/kisexp/xdchain/consensus"
+	"github.com/kisexp/xdchain/consensus/istanbul"
 	qbfttypes "github.com/kisexp/xdchain/consensus/istanbul/qbft/types"
 	"github.com/kisexp/xdchain/rlp"
 )
@@ -112,6 +111,12 @@ func (c *core) handlePreprepareMsg(preprepare *qbfttypes.Preprepare) error {
 		logger.Warn("QBFT: ignore PRE-PREPARE message from non proposer", "proposer", c.valSet.GetProposer().Address())
 		return errNotFromProposer
 	}
+	if c.config.StrictProposerCheck {
+		if err := c.checkExpectedProposer(preprepare.Proposal, preprepare.Round.Uint64()); err != nil {
+			logger.Warn("QBFT: ignore PRE-PREPARE message from unexpected proposer", "err", err)
+			return err
+		}
+	}
 
 	// Validates PRE-PREPARE message justification
 	if preprepare.Round.Uint64() > 0 {
@@ -129,7 +134,7 @@ func (c *core) handlePreprepareMsg(preprepare *qbfttypes.Preprepare) error {
 
 			// start a timer to re-input PRE-PREPARE message as a backlog event
 			c.stopFuturePreprepareTimer()
-			c.futurePreprepareTimer = time.AfterFunc(duration, func() {
+			c.futurePreprepareTimer = c.config.GetClock().AfterFunc(duration, func() {
 				_, validator := c.valSet.GetByAddress(preprepare.Source())
 				c.sendEvent(backlogEvent{
 					src: validator,
@@ -149,7 +154,7 @@ func (c *core) handlePreprepareMsg(preprepare *qbfttypes.Preprepare) error {
 
 		// Re-initialize ROUND-CHANGE timer
 		c.newRoundChangeTimer()
-		c.consensusTimestamp = time.Now()
+		c.consensusTimestamp = c.config.GetClock().Now()
 
 		// Update current state
 		c.current.SetPreprepare(preprepare)
@@ -161,3 +166,28 @@ func (c *core) handlePreprepareMsg(preprepare *qbfttypes.Preprepare) error {
 
 	return nil
 }
+
+// checkExpectedProposer returns errNotFromProposer unless the proposal was created by the proposer the ProposerPolicy
+// expects for one of the rounds up to the given one of its height: the proposer of a round may propose again the
+// block created in an earlier round, never a block created by a validator out of turn. The core only checks the
+// sender of the PRE-PREPARE, not the author of the block. The proposal is rejected if the author or the
+// proposers expected can't be determined, e.g. after a restart before the proposer of the parent is recorded
+func (c *core) checkExpectedProposer(proposal istanbul.Proposal, round uint64) error {
+	author, err := c.backend.ProposalAuthor(proposal)
+	if err != nil {
+		c.logger.Warn("QBFT: unable to get the author of the proposal", "err", err)
+		return errNotFromProposer
+	}
+	number := proposal.Number().Uint64()
+	for r := uint64(0); r <= round; r++ {
+		expected, err := c.config.Policy().IsExpectedProposer(number, r, author)
+		if err != nil {
+			c.logger.Warn("QBFT: unable to check the expected proposer", "number", number, "round", r, "err", err)
+			return errNotFromProposer
+		}
+		if expected {
+			return nil
+		}
+	}
+	return errNotFromProposer
+}
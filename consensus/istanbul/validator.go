@@ -18,10 +18,13 @@ package istanbul
 
 import (
 	"bytes"
+	"encoding/binary"
+	"math/big"
 	"sort"
 	"strings"
 
 	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/crypto"
 )
 
 type Validator interface {
@@ -61,13 +64,24 @@ func ValidatorSortByString() ValidatorSortByFunc {
 	}
 }
 
+// ValidatorSortByStringDesc sorts the validators in the reverse order of ValidatorSortByString
+func ValidatorSortByStringDesc() ValidatorSortByFunc {
+	return func(v1 Validator, v2 Validator) bool {
+		return strings.Compare(v1.String(), v2.String()) > 0
+	}
+}
+
 func ValidatorSortByByte() ValidatorSortByFunc {
 	return func(v1 Validator, v2 Validator) bool {
 		return bytes.Compare(v1.Address().Bytes(), v2.Address().Bytes()) < 0
 	}
 }
 
+// Sort sorts the validators, by string if the ValidatorSortByFunc is nil
 func (by ValidatorSortByFunc) Sort(validators []Validator) {
+	if by == nil {
+		by = ValidatorSortByString()
+	}
 	v := &validatorSorter{
 		validators: validators,
 		by:         by,
@@ -75,6 +89,21 @@ func (by ValidatorSortByFunc) Sort(validators []Validator) {
 	sort.Sort(v)
 }
 
+// ShuffleValidators returns a permutation of the given validators derived from the seed with a
+// Fisher-Yates shuffle, where the swap at position i is picked by keccak256(seed || i)
+func ShuffleValidators(validators []Validator, seed common.Hash) []Validator {
+	order := make([]Validator, len(validators))
+	copy(order, validators)
+	position := make([]byte, 8)
+	for i := len(order) - 1; i > 0; i-- {
+		binary.BigEndian.PutUint64(position, uint64(i))
+		h := new(big.Int).SetBytes(crypto.Keccak256(seed.Bytes(), position))
+		j := h.Mod(h, big.NewInt(int64(i+1))).Int64()
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
 // ----------------------------------------------------------------------------
 
 type ValidatorSet interface {
@@ -105,6 +134,8 @@ type ValidatorSet interface {
 
 	// SortValidators sorts the validators based on the configured By function
 	SortValidators()
+	// SortValidatorsBy sorts the validators with the given function, e.g. by the policy switching its By function
+	SortValidatorsBy(by ValidatorSortByFunc)
 }
 
 // ----------------------------------------------------------------------------
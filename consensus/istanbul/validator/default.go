@@ -46,6 +46,18 @@ type defaultSet struct {
 	proposer    istanbul.Validator
 	validatorMu sync.RWMutex
 	selector    istanbul.ProposalSelector
+
+	// indexCache maps each validator address to its position in the sorted validators, it is
+	// dropped whenever the validators are sorted or the membership changes and rebuilt lazily
+	indexMu     sync.Mutex
+	indexCache  map[common.Address]int
+	indexHits   uint64
+	indexMisses uint64
+
+	// seededOrder caches the proposer order derived from the seed of the policy and the position
+	// of each validator in it, it is dropped along with indexCache
+	seededOrder []istanbul.Validator
+	seededIndex map[common.Address]int
 }
 
 func newDefaultSet(addrs []common.Address, policy *istanbul.ProposerPolicy) *defaultSet {
@@ -64,11 +76,22 @@ func newDefaultSet(addrs []common.Address, policy *istanbul.ProposerPolicy) *def
 		valSet.proposer = valSet.GetByIndex(0)
 	}
 	valSet.selector = roundRobinProposer
-	if policy.Id == istanbul.Sticky {
+	if policy.Id == istanbul.Sticky && policy.RoundRobinFallback {
+		valSet.selector = stickyRoundRobinFallbackProposer
+		if valSet.Size() > 0 {
+			valSet.proposer = valSet.selector(valSet, common.Address{}, 0)
+		}
+	} else if policy.Id == istanbul.Sticky {
 		valSet.selector = stickyProposer
+	} else if policy.Seed != nil {
+		valSet.selector = seededRoundRobinProposer
+		if valSet.Size() > 0 {
+			valSet.proposer = valSet.selector(valSet, common.Address{}, 0)
+		}
+	}
+	if policy.Id == istanbul.RoundRobin && policy.ProposerCooldown > 0 {
+		valSet.selector = cooldownRoundRobinProposer
 	}
-
-	policy.RegisterValidatorSet(valSet)
 
 	return valSet
 }
@@ -95,14 +118,64 @@ func (valSet *defaultSet) GetByIndex(i uint64) istanbul.Validator {
 }
 
 func (valSet *defaultSet) GetByAddress(addr common.Address) (int, istanbul.Validator) {
-	for i, val := range valSet.List() {
-		if addr == val.Address() {
-			return i, val
-		}
+	valSet.validatorMu.RLock()
+	defer valSet.validatorMu.RUnlock()
+	if i, ok := valSet.addressIndex()[addr]; ok {
+		return i, valSet.validators[i]
 	}
 	return -1, nil
 }
 
+// addressIndex returns the cached position of each validator, building it if needed.
+// The caller must hold validatorMu.
+func (valSet *defaultSet) addressIndex() map[common.Address]int {
+	valSet.indexMu.Lock()
+	defer valSet.indexMu.Unlock()
+	if valSet.indexCache != nil {
+		valSet.indexHits++
+		return valSet.indexCache
+	}
+	valSet.indexMisses++
+	index := make(map[common.Address]int, len(valSet.validators))
+	for i, val := range valSet.validators {
+		index[val.Address()] = i
+	}
+	valSet.indexCache = index
+	return index
+}
+
+// invalidateAddressIndex drops the cached position of the validators and their seeded proposer order
+func (valSet *defaultSet) invalidateAddressIndex() {
+	valSet.indexMu.Lock()
+	defer valSet.indexMu.Unlock()
+	valSet.indexCache = nil
+	valSet.seededOrder, valSet.seededIndex = nil, nil
+}
+
+// seededProposerOrder returns the cached proposer order derived from the seed of the policy and the
+// position of each validator in it, shuffling the validators if needed.
+// The caller must hold validatorMu.
+func (valSet *defaultSet) seededProposerOrder() ([]istanbul.Validator, map[common.Address]int) {
+	valSet.indexMu.Lock()
+	defer valSet.indexMu.Unlock()
+	if valSet.seededOrder == nil {
+		valSet.seededOrder = istanbul.ShuffleValidators(valSet.validators, *valSet.policy.Seed)
+		valSet.seededIndex = make(map[common.Address]int, len(valSet.seededOrder))
+		for i, val := range valSet.seededOrder {
+			valSet.seededIndex[val.Address()] = i
+		}
+	}
+	return valSet.seededOrder, valSet.seededIndex
+}
+
+// addressIndexStats returns the number of lookups served from the cached validator positions
+// and the number of times the cache had to be rebuilt
+func (valSet *defaultSet) addressIndexStats() (hits uint64, misses uint64) {
+	valSet.indexMu.Lock()
+	defer valSet.indexMu.Unlock()
+	return valSet.indexHits, valSet.indexMisses
+}
+
 func (valSet *defaultSet) GetProposer() istanbul.Validator {
 	return valSet.proposer
 }
@@ -120,7 +193,13 @@ func (valSet *defaultSet) CalcProposer(lastProposer common.Address, round uint64
 
 // ValidatorSetSorter sorts the validators based on the configured By function
 func (valSet *defaultSet) SortValidators() {
-	valSet.Policy().By.Sort(valSet.validators)
+	valSet.SortValidatorsBy(valSet.policy.SortBy())
+}
+
+// SortValidatorsBy sorts the validators with the given function
+func (valSet *defaultSet) SortValidatorsBy(by istanbul.ValidatorSortByFunc) {
+	by.Sort(valSet.validators)
+	valSet.invalidateAddressIndex()
 }
 
 func calcSeed(valSet istanbul.ValidatorSet, proposer common.Address, round uint64) uint64 {
@@ -149,6 +228,86 @@ func roundRobinProposer(valSet istanbul.ValidatorSet, proposer common.Address, r
 	return valSet.GetByIndex(pick)
 }
 
+// seededRoundRobinProposer rotates through the validators in the order given by the seed of the policy
+// instead of the sorted order. The order is cached by the set until its validators change.
+//
+// The seed is part of the static genesis config, so the order only spreads the proposer role differently
+// than the sorted order: anyone knowing the genesis and the validators can predict it.
+func seededRoundRobinProposer(valSet istanbul.ValidatorSet, proposer common.Address, round uint64) istanbul.Validator {
+	if valSet.Size() == 0 {
+		return nil
+	}
+	order, index := rotationOrder(valSet)
+	seed := round
+	if !emptyAddress(proposer) {
+		seed = uint64(index[proposer]) + round + 1
+	}
+	return order[seed%uint64(len(order))]
+}
+
+// rotationOrder returns the validators in the order the RoundRobin policy rotates through them and the
+// position of each validator in it
+func rotationOrder(valSet istanbul.ValidatorSet) ([]istanbul.Validator, map[common.Address]int) {
+	if valSet.Policy().Seed == nil {
+		order := valSet.List()
+		index := make(map[common.Address]int, len(order))
+		for i, val := range order {
+			index[val.Address()] = i
+		}
+		return order, index
+	}
+	if set, ok := valSet.(*defaultSet); ok {
+		return set.seededProposerOrder()
+	}
+	order := istanbul.ShuffleValidators(valSet.List(), *valSet.Policy().Seed)
+	index := make(map[common.Address]int, len(order))
+	for i, val := range order {
+		index[val.Address()] = i
+	}
+	return order, index
+}
+
+// cooldownRoundRobinProposer rotates through the validators like the RoundRobin policy but skips the
+// validators in their cooldown: the last proposer and the ones preceding it in the rotation order, as many
+// as the ProposerCooldown of the policy. They are the last proposers as long as no round change happened.
+// The cooldown applies from round 0: the round-th eligible validator following the last proposer is
+// selected, the rotation wrapping around the eligible validators only instead of reaching back to the ones
+// which just proposed.
+//
+// The cooldown only depends on the last proposer and the validators, so every node selects the same
+// proposer. It's ignored if it leaves no validator eligible, or if the last proposer isn't in the set.
+func cooldownRoundRobinProposer(valSet istanbul.ValidatorSet, proposer common.Address, round uint64) istanbul.Validator {
+	fallback := roundRobinProposer
+	if valSet.Policy().Seed != nil {
+		fallback = seededRoundRobinProposer
+	}
+	size := uint64(valSet.Size())
+	cooldown := valSet.Policy().ProposerCooldown
+	if size == 0 || cooldown >= size || emptyAddress(proposer) {
+		return fallback(valSet, proposer, round)
+	}
+	order, index := rotationOrder(valSet)
+	last, ok := index[proposer]
+	if !ok {
+		return fallback(valSet, proposer, round)
+	}
+	// the validators following the last proposer in the rotation order, up to the first one in its cooldown
+	eligible := make([]istanbul.Validator, 0, size-cooldown)
+	for i := uint64(1); i <= size; i++ {
+		if inCooldown(uint64(last), (uint64(last)+i)%size, cooldown, size) {
+			break
+		}
+		eligible = append(eligible, order[(uint64(last)+i)%size])
+	}
+	return eligible[round%uint64(len(eligible))]
+}
+
+// inCooldown reports whether the validator at position pos of the rotation order is in its cooldown after
+// the one at position last proposed, i.e. it's the last proposer or one of the cooldown-1 preceding it
+func inCooldown(last, pos, cooldown, size uint64) bool {
+	return (last+size-pos)%size < cooldown
+}
+
 func stickyProposer(valSet istanbul.ValidatorSet, proposer common.Address, round uint64) istanbul.Validator {
 	if valSet.Size() == 0 {
 		return nil
@@ -163,6 +322,27 @@ func stickyProposer(valSet istanbul.ValidatorSet, proposer common.Address, round
 	return valSet.GetByIndex(pick)
 }
 
+// stickyRoundRobinFallbackProposer keeps the proposer of the previous block as long as no round change happened.
+// Once the proposer failed, or if it isn't in the set anymore, the backup proposers follow the RoundRobin order
+// of the policy starting after it, so every node picks the same backups.
+func stickyRoundRobinFallbackProposer(valSet istanbul.ValidatorSet, proposer common.Address, round uint64) istanbul.Validator {
+	if valSet.Size() == 0 {
+		return nil
+	}
+	fallback := roundRobinProposer
+	if valSet.Policy().Seed != nil {
+		fallback = seededRoundRobinProposer
+	}
+	_, val := valSet.GetByAddress(proposer)
+	if val == nil {
+		return fallback(valSet, common.Address{}, round)
+	}
+	if round == 0 {
+		return val
+	}
+	return fallback(valSet, proposer, round-1)
+}
+
 func (valSet *defaultSet) AddValidator(address common.Address) bool {
 	valSet.validatorMu.Lock()
 	defer valSet.validatorMu.Unlock()
@@ -185,6 +365,7 @@ func (valSet *defaultSet) RemoveValidator(address common.Address) bool {
 	for i, v := range valSet.validators {
 		if v.Address() == address {
 			valSet.validators = append(valSet.validators[:i], valSet.validators[i+1:]...)
+			valSet.invalidateAddressIndex()
 			return true
 		}
 	}
@@ -37,6 +37,9 @@ func TestValidatorSet(t *testing.T) {
 	testNormalValSet(t)
 	testEmptyValSet(t)
 	testStickyProposer(t)
+	testSeededRoundRobinProposer(t)
+	testCooldownRoundRobinProposer(t)
+	testStickyRoundRobinFallbackProposer(t)
 	testAddAndRemoveValidator(t)
 }
 
@@ -207,3 +210,210 @@ func testStickyProposer(t *testing.T) {
 		t.Errorf("proposer mismatch: have %v, want %v", val, val2)
 	}
 }
+
+func testStickyRoundRobinFallbackProposer(t *testing.T) {
+	var addrs []common.Address
+	for i := 0; i < 5; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	seed := common.HexToHash("0x6f5d1dd1d9e0a8ea4d241c5bd4c6a52c9a2b2d6cb0f4f7b4b1e0e6f1d4d27a8b")
+	newPolicy := func() *istanbul.ProposerPolicy {
+		policy := istanbul.NewStickyProposerPolicy()
+		policy.Seed = &seed
+		policy.RoundRobinFallback = true
+		return policy
+	}
+	policy := newPolicy()
+	valSet := newDefaultSet(addrs, policy)
+	order := policy.ProposerOrder(valSet)
+
+	if val := valSet.GetProposer(); val.Address() != order[0] {
+		t.Errorf("proposer mismatch: have %v, want %v", val, order[0])
+	}
+	// the proposer sticks while it proposes
+	primary := order[2]
+	valSet.CalcProposer(primary, uint64(0))
+	if val := valSet.GetProposer(); val.Address() != primary {
+		t.Errorf("proposer mismatch: have %v, want %v", val, primary)
+	}
+	// the primary proposer failed, the backups follow the round robin order after it
+	for round := uint64(1); round <= uint64(len(order)); round++ {
+		valSet.CalcProposer(primary, round)
+		want := order[(2+round)%uint64(len(order))]
+		if val := valSet.GetProposer(); val.Address() != want {
+			t.Errorf("round %d: proposer mismatch: have %v, want %v", round, val, want)
+		}
+	}
+	// every node picks the same backup, whatever the order the validators are known in
+	reversed := make([]common.Address, len(addrs))
+	for i, addr := range addrs {
+		reversed[len(addrs)-1-i] = addr
+	}
+	otherSet := newDefaultSet(reversed, newPolicy())
+	valSet.CalcProposer(primary, uint64(3))
+	otherSet.CalcProposer(primary, uint64(3))
+	if val, other := valSet.GetProposer(), otherSet.GetProposer(); val.Address() != other.Address() {
+		t.Errorf("backup proposer mismatch between nodes: have %v, want %v", other, val)
+	}
+	// the proposer left the validator set
+	key, _ := crypto.GenerateKey()
+	valSet.CalcProposer(crypto.PubkeyToAddress(key.PublicKey), uint64(1))
+	if val := valSet.GetProposer(); val.Address() != order[1] {
+		t.Errorf("proposer mismatch: have %v, want %v", val, order[1])
+	}
+}
+
+func testCooldownRoundRobinProposer(t *testing.T) {
+	var addrs []common.Address
+	for i := 0; i < 5; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	policy := istanbul.NewRoundRobinProposerPolicy()
+	policy.ProposerCooldown = 2
+	valSet := newDefaultSet(addrs, policy)
+	order := policy.ProposerOrder(valSet)
+
+	// order[0] and order[1] are in their cooldown once order[1] proposed
+	for round, want := range []common.Address{order[2], order[3], order[4], order[2], order[3], order[4]} {
+		valSet.CalcProposer(order[1], uint64(round))
+		if val := valSet.GetProposer(); val.Address() != want {
+			t.Errorf("round %d: proposer mismatch: have %v, want %v", round, val, want)
+		}
+	}
+	// every node selects the same proposer, whatever the order the validators are known in
+	reversed := make([]common.Address, len(addrs))
+	for i, addr := range addrs {
+		reversed[len(addrs)-1-i] = addr
+	}
+	otherSet := newDefaultSet(reversed, policy)
+	otherSet.CalcProposer(order[1], 3)
+	if val := otherSet.GetProposer(); val.Address() != order[2] {
+		t.Errorf("proposer mismatch: have %v, want %v", val, order[2])
+	}
+	// the cooldown is ignored when it leaves no validator eligible
+	degenerate := istanbul.NewRoundRobinProposerPolicy()
+	degenerate.ProposerCooldown = uint64(len(addrs))
+	valSet = newDefaultSet(addrs, degenerate)
+	valSet.CalcProposer(order[1], 3)
+	if val := valSet.GetProposer(); val.Address() != order[0] {
+		t.Errorf("proposer mismatch: have %v, want %v", val, order[0])
+	}
+}
+
+func TestCooldownRoundRobinProposer_Round0(t *testing.T) {
+	var addrs []common.Address
+	for i := 0; i < 5; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	seed := common.HexToHash("0x6f5d1dd1d9e0a8ea4d241c5bd4c6a52c9a2b2d6cb0f4f7b4b1e0e6f1d4d27a8b")
+	for _, seeded := range []bool{false, true} {
+		policy := istanbul.NewRoundRobinProposerPolicy()
+		policy.ProposerCooldown = 3
+		if seeded {
+			policy.Seed = &seed
+		}
+		valSet := newDefaultSet(addrs, policy)
+		order := policy.ProposerOrder(valSet)
+
+		// whichever validator proposed last, the first round already skips the validators in their cooldown,
+		// including when the rotation wraps around
+		for last := range order {
+			cooldown := map[common.Address]bool{}
+			for i := 0; i < 3; i++ {
+				cooldown[order[(last+len(order)-i)%len(order)]] = true
+			}
+			valSet.CalcProposer(order[last], 0)
+			val := valSet.GetProposer()
+			if cooldown[val.Address()] {
+				t.Errorf("seeded %t, last proposer %d: round 0 proposer %v in its cooldown", seeded, last, val)
+			}
+			if want := order[(last+1)%len(order)]; val.Address() != want {
+				t.Errorf("seeded %t, last proposer %d: round 0 proposer mismatch: have %v, want %v", seeded, last, val, want)
+			}
+			// with 2 eligible validators, round 2 wraps around to the first one instead of one in its cooldown
+			valSet.CalcProposer(order[last], 2)
+			if want := order[(last+1)%len(order)]; valSet.GetProposer().Address() != want {
+				t.Errorf("seeded %t, last proposer %d: round 2 proposer mismatch: have %v, want %v", seeded, last, valSet.GetProposer(), want)
+			}
+		}
+	}
+}
+
+func testSeededRoundRobinProposer(t *testing.T) {
+	var addrs []common.Address
+	for i := 0; i < 10; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	seed := common.HexToHash("0x6f5d1dd1d9e0a8ea4d241c5bd4c6a52c9a2b2d6cb0f4f7b4b1e0e6f1d4d27a8b")
+	policy := istanbul.NewRoundRobinProposerPolicy()
+	policy.Seed = &seed
+	valSet := newDefaultSet(addrs, policy)
+
+	order := policy.ProposerOrder(valSet)
+	if len(order) != len(addrs) {
+		t.Fatalf("proposer order size mismatch: have %d, want %d", len(order), len(addrs))
+	}
+	if reflect.DeepEqual(order, SortedAddresses(valSet.List())) {
+		t.Errorf("proposer order should not follow the sorted validators")
+	}
+	// the order is a permutation of the validators
+	seen := make(map[common.Address]bool)
+	for _, addr := range order {
+		if _, val := valSet.GetByAddress(addr); val == nil || seen[addr] {
+			t.Fatalf("proposer order is not a permutation of the validators: %v", order)
+		}
+		seen[addr] = true
+	}
+	// every node derives the same order, whatever the order the validators are known in
+	reversed := make([]common.Address, len(addrs))
+	for i, addr := range addrs {
+		reversed[len(addrs)-1-i] = addr
+	}
+	otherPolicy := istanbul.NewRoundRobinProposerPolicy()
+	otherPolicy.Seed = &seed
+	if otherOrder := otherPolicy.ProposerOrder(newDefaultSet(reversed, otherPolicy)); !reflect.DeepEqual(order, otherOrder) {
+		t.Errorf("proposer order mismatch: have %v, want %v", otherOrder, order)
+	}
+
+	// test get proposer
+	if val := valSet.GetProposer(); val.Address() != order[0] {
+		t.Errorf("proposer mismatch: have %v, want %v", val, order[0])
+	}
+	// test calculate proposer
+	for i := range order {
+		valSet.CalcProposer(order[i], uint64(0))
+		want := order[(i+1)%len(order)]
+		if val := valSet.GetProposer(); val.Address() != want {
+			t.Errorf("proposer mismatch: have %v, want %v", val, want)
+		}
+	}
+	valSet.CalcProposer(order[0], uint64(2))
+	if val := valSet.GetProposer(); val.Address() != order[3] {
+		t.Errorf("proposer mismatch: have %v, want %v", val, order[3])
+	}
+	// test empty last proposer
+	valSet.CalcProposer(common.Address{}, uint64(4))
+	if val := valSet.GetProposer(); val.Address() != order[4] {
+		t.Errorf("proposer mismatch: have %v, want %v", val, order[4])
+	}
+
+	// the order is cached until the validators change
+	if len(valSet.seededOrder) != len(addrs) {
+		t.Fatalf("proposer order not cached: %v", valSet.seededOrder)
+	}
+	key, _ := crypto.GenerateKey()
+	if !valSet.AddValidator(crypto.PubkeyToAddress(key.PublicKey)) {
+		t.Fatalf("failed to add validator")
+	}
+	if valSet.seededOrder != nil {
+		t.Errorf("proposer order should be dropped when the validators change")
+	}
+	valSet.CalcProposer(common.Address{}, uint64(0))
+	if have, want := len(valSet.seededOrder), len(addrs)+1; have != want {
+		t.Errorf("proposer order size mismatch: have %d, want %d", have, want)
+	}
+}
@@ -17,10 +17,17 @@
 package validator
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
 	"testing"
 
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/consensus/istanbul"
+	"github.com/kisexp/xdchain/core/rawdb"
+	"github.com/kisexp/xdchain/crypto"
+	"github.com/kisexp/xdchain/metrics"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,18 +44,745 @@ func TestProposerPolicy(t *testing.T) {
 	addressSortedByString := []common.Address{addr6, addr4, addr1, addr2, addr5, addr3}
 
 	pp := istanbul.NewRoundRobinProposerPolicy()
-	pp.Use(istanbul.ValidatorSortByByte())
+	assert.NoError(t, pp.Use(istanbul.ValidatorSortByByte()))
 
 	valSet := NewSet(addrSet, pp)
+	pp.RegisterValidatorSet(1, valSet)
 	valList := valSet.List()
 
 	for i := 0; i < 6; i++ {
 		assert.Equal(t, addressSortedByByte[i].Hex(), valList[i].String(), "validatorSet not byte sorted")
 	}
 
-	pp.Use(istanbul.ValidatorSortByString())
+	assert.NoError(t, pp.Use(istanbul.ValidatorSortByString()))
 	for i := 0; i < 6; i++ {
 		assert.Equal(t, addressSortedByString[i].Hex(), valList[i].String(), "validatorSet not string sorted")
 	}
 
 }
+
+func TestProposerPolicy_SortByStringDesc(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+	addr4 := common.HexToAddress("0x784ae51f5013b51c8360afdf91c6bc5a16f586ea")
+
+	addrSet := []common.Address{addr1, addr2, addr3, addr4}
+	addressSortedByStringDesc := []common.Address{addr3, addr2, addr1, addr4}
+
+	pp := istanbul.NewProposerPolicyByIdAndSortFunc(istanbul.RoundRobin, istanbul.ValidatorSortByStringDesc())
+	valSet := NewSet(addrSet, pp)
+	pp.RegisterValidatorSet(1, valSet)
+	assert.Equal(t, addressSortedByStringDesc, pp.ProposerOrder(valSet), "validatorSet not sorted by descending string")
+
+	// the proposer rotates through the validators in the reversed order
+	for i, want := range addressSortedByStringDesc {
+		last := addressSortedByStringDesc[(i+len(addressSortedByStringDesc)-1)%len(addressSortedByStringDesc)]
+		valSet.CalcProposer(last, 0)
+		assert.Equal(t, want, valSet.GetProposer().Address(), "proposer mismatch after %s", last.Hex())
+	}
+
+	// switching the sort function re-sorts the registered validator sets
+	assert.NoError(t, pp.Use(istanbul.ValidatorSortByString()))
+	assert.Equal(t, []common.Address{addr4, addr1, addr2, addr3}, pp.ProposerOrder(valSet))
+	assert.NoError(t, pp.Use(istanbul.ValidatorSortByStringDesc()))
+	assert.Equal(t, addressSortedByStringDesc, pp.ProposerOrder(valSet))
+}
+
+func TestProposerPolicy_AddressIndexCache(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	valSet := newDefaultSet([]common.Address{addr1, addr2, addr3}, pp)
+	pp.RegisterValidatorSet(1, valSet)
+
+	valSet.CalcProposer(addr1, 0)
+	valSet.CalcProposer(addr2, 0)
+	hits, misses := valSet.addressIndexStats()
+	assert.Equal(t, uint64(1), misses, "index should be built once")
+	assert.Equal(t, uint64(1), hits, "second lookup should hit the cache")
+
+	// changing the sort function invalidates the cache
+	assert.NoError(t, pp.Use(istanbul.ValidatorSortByByte()))
+	idx, _ := valSet.GetByAddress(addr3)
+	assert.Equal(t, 1, idx)
+	_, misses = valSet.addressIndexStats()
+	assert.Equal(t, uint64(2), misses)
+
+	// membership changes invalidate the cache
+	valSet.RemoveValidator(addr1)
+	idx, _ = valSet.GetByAddress(addr3)
+	assert.Equal(t, 0, idx)
+	valSet.AddValidator(addr1)
+	idx, _ = valSet.GetByAddress(addr3)
+	assert.Equal(t, 1, idx)
+	hits, misses = valSet.addressIndexStats()
+	assert.Equal(t, uint64(4), misses)
+	assert.Equal(t, uint64(1), hits)
+}
+
+func BenchmarkRoundRobinProposer(b *testing.B) {
+	const valCnt = 200
+	addrs := make([]common.Address, valCnt)
+	for i := 0; i < valCnt; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	valSet := NewSet(addrs, istanbul.NewRoundRobinProposerPolicy())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		valSet.CalcProposer(addrs[i%valCnt], uint64(i%3))
+	}
+}
+
+func TestProposerPolicy_StoreAndLoadValidatorSets(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+	pp := istanbul.NewRoundRobinProposerPolicy()
+
+	assert.NoError(t, istanbul.StoreValidatorSet(db, 10, NewSet([]common.Address{addr1, addr2}, pp)))
+	// unchanged validators aren't written again
+	assert.NoError(t, istanbul.StoreValidatorSet(db, 11, NewSet([]common.Address{addr2, addr1}, pp)))
+	assert.NoError(t, istanbul.StoreValidatorSet(db, 12, NewSet([]common.Address{addr1, addr2, addr3}, pp)))
+
+	stored, err := istanbul.LoadValidatorSets(db, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, []istanbul.StoredValidatorSet{
+		{Number: 10, Validators: []common.Address{addr1, addr2}},
+		{Number: 12, Validators: []common.Address{addr1, addr2, addr3}},
+	}, stored)
+
+	stored, err = istanbul.LoadValidatorSets(db, 11)
+	assert.NoError(t, err)
+	assert.Len(t, stored, 1, "sets stored for greater heights are not loaded")
+
+	stored, err = istanbul.LoadValidatorSets(db, 9)
+	assert.NoError(t, err)
+	assert.Empty(t, stored)
+
+	// a rewind of the chain removes the sets stored past the new head
+	assert.NoError(t, istanbul.StoreValidatorSet(db, 11, NewSet([]common.Address{addr3}, pp)))
+	stored, err = istanbul.LoadValidatorSets(db, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, []istanbul.StoredValidatorSet{
+		{Number: 10, Validators: []common.Address{addr1, addr2}},
+		{Number: 11, Validators: []common.Address{addr3}},
+	}, stored)
+}
+
+func TestProposerPolicy_StoreValidatorSetPrunesOldHeights(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	pp := istanbul.NewRoundRobinProposerPolicy()
+
+	assert.NoError(t, istanbul.StoreValidatorSet(db, 1, NewSet([]common.Address{addr1}, pp)))
+	assert.NoError(t, istanbul.StoreValidatorSet(db, 2, NewSet([]common.Address{addr2}, pp)))
+	assert.NoError(t, istanbul.StoreValidatorSet(db, 129, NewSet([]common.Address{addr2}, pp)))
+
+	stored, err := istanbul.LoadValidatorSets(db, 129)
+	assert.NoError(t, err)
+	assert.Equal(t, []istanbul.StoredValidatorSet{{Number: 2, Validators: []common.Address{addr2}}}, stored, "the set applicable to the first height of the window is kept")
+
+	assert.NoError(t, istanbul.StoreValidatorSet(db, 130, NewSet([]common.Address{addr1}, pp)))
+	stored, err = istanbul.LoadValidatorSets(db, 130)
+	assert.NoError(t, err)
+	assert.Equal(t, []istanbul.StoredValidatorSet{
+		{Number: 2, Validators: []common.Address{addr2}},
+		{Number: 130, Validators: []common.Address{addr1}},
+	}, stored)
+
+	// the set stored at height 1 falls out of the window and is removed
+	stored, err = istanbul.LoadValidatorSets(db, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestProposerPolicy_OrderedValidatorsAt(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+
+	_, err := pp.OrderedValidatorsAt(1)
+	assert.EqualError(t, err, "no validator set registered for block 1")
+
+	// the validators of a block are the ones of the snapshot of its parent
+	pp.RegisterValidatorSet(9, NewSet([]common.Address{addr2, addr1}, pp))
+	pp.RecordOrderedValidatorsAt(10)
+	pp.ClearRegistry()
+	pp.RegisterValidatorSet(19, NewSet([]common.Address{addr3, addr2, addr1}, pp))
+	pp.RecordOrderedValidatorsAt(20)
+	pp.ClearRegistry()
+	// validator set of the block being built
+	pp.RegisterValidatorSet(21, NewSet([]common.Address{addr3, addr1}, pp))
+
+	_, err = pp.OrderedValidatorsAt(9)
+	assert.EqualError(t, err, "no validator set registered for block 9")
+
+	for number, expected := range map[uint64][]common.Address{
+		10: {addr1, addr2},
+		15: {addr1, addr2},
+		20: {addr1, addr2, addr3},
+		21: {addr1, addr3},
+		99: {addr1, addr3},
+	} {
+		order, err := pp.OrderedValidatorsAt(number)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, order, "block %d", number)
+	}
+
+	// the returned order can't alter the recorded one
+	order, _ := pp.OrderedValidatorsAt(10)
+	order[0] = addr3
+	order, _ = pp.OrderedValidatorsAt(10)
+	assert.Equal(t, []common.Address{addr1, addr2}, order)
+}
+
+func TestProposerPolicy_RecordOrderedValidatorsAt_ParentSnapshot(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	pp.RegisterValidatorSet(9, NewSet([]common.Address{addr1, addr2}, pp))
+	// registered by an RPC call between two commits
+	pp.RegisterValidatorSet(0, NewSet([]common.Address{addr3}, pp))
+	pp.RecordOrderedValidatorsAt(10)
+	pp.ClearRegistry()
+
+	order, err := pp.OrderedValidatorsAt(10)
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Address{addr1, addr2}, order)
+
+	// nothing is recorded without the set of the parent snapshot
+	pp.RegisterValidatorSet(30, NewSet([]common.Address{addr3}, pp))
+	pp.RecordOrderedValidatorsAt(20)
+	pp.ClearRegistry()
+	order, err = pp.OrderedValidatorsAt(20)
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Address{addr1, addr2}, order, "order of block 10 expected")
+}
+
+func TestProposerPolicy_OrderedValidatorsAt_Seeded(t *testing.T) {
+	addrs := make([]common.Address, 5)
+	for i := range addrs {
+		key, _ := crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	seed := common.HexToHash("0x01")
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	pp.Seed = &seed
+
+	valSet := NewSet(addrs, pp)
+	pp.RegisterValidatorSet(0, valSet)
+	pp.RecordOrderedValidatorsAt(1)
+	pp.ClearRegistry()
+
+	order, err := pp.OrderedValidatorsAt(1)
+	assert.NoError(t, err)
+	assert.Equal(t, pp.ProposerOrder(valSet), order)
+}
+
+func TestProposerPolicy_DiffValidatorSets(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	pp.RegisterValidatorSet(9, NewSet([]common.Address{addr1, addr2}, pp))
+	pp.RecordOrderedValidatorsAt(10)
+	pp.ClearRegistry()
+	pp.RegisterValidatorSet(19, NewSet([]common.Address{addr1, addr3}, pp))
+	pp.RecordOrderedValidatorsAt(20)
+	pp.ClearRegistry()
+
+	added, removed, err := pp.DiffValidatorSets(10, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Address{addr3}, added)
+	assert.Equal(t, []common.Address{addr2}, removed)
+
+	added, removed, err = pp.DiffValidatorSets(20, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Address{addr2}, added)
+	assert.Equal(t, []common.Address{addr3}, removed)
+
+	added, removed, err = pp.DiffValidatorSets(10, 15)
+	assert.NoError(t, err)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+
+	_, _, err = pp.DiffValidatorSets(5, 20)
+	assert.EqualError(t, err, "no validator set registered for block 5")
+	_, _, err = pp.DiffValidatorSets(20, 5)
+	assert.EqualError(t, err, "no validator set registered for block 5")
+}
+
+func TestProposerPolicy_RecordOrderedValidatorsAtPrunesOldHeights(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	pp.RegisterValidatorSet(0, NewSet([]common.Address{addr1}, pp))
+	pp.RecordOrderedValidatorsAt(1)
+	pp.RecordOrderedValidatorsAt(200)
+	pp.ClearRegistry()
+
+	_, err := pp.OrderedValidatorsAt(1)
+	assert.EqualError(t, err, "no validator set registered for block 1")
+	order, err := pp.OrderedValidatorsAt(200)
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Address{addr1}, order)
+}
+
+func TestProposerPolicy_UseNilSortFunc(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	assert.NoError(t, pp.Use(istanbul.ValidatorSortByByte()))
+	valSet := NewSet([]common.Address{addr2, addr1}, pp)
+
+	assert.Equal(t, istanbul.ErrNilValidatorSortByFunc, pp.Use(nil))
+	assert.NotNil(t, pp.By, "sort function must be left unchanged")
+
+	// a policy built without a sort function sorts by string
+	pp.By = nil
+	assert.NotPanics(t, valSet.SortValidators)
+	valList := valSet.List()
+	assert.Equal(t, addr1, valList[0].Address())
+	assert.Equal(t, addr2, valList[1].Address())
+}
+
+func TestProposerPolicy_UseConcurrentWithRegistry(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if i%2 == 0 {
+				assert.NoError(t, pp.Use(istanbul.ValidatorSortByByte()))
+			} else {
+				assert.NoError(t, pp.Use(istanbul.ValidatorSortByString()))
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := uint64(0); i < 100; i++ {
+			pp.RegisterValidatorSet(i, NewSet([]common.Address{addr2, addr1}, pp))
+			if i%10 == 0 {
+				pp.ClearRegistry()
+			}
+		}
+	}()
+	wg.Wait()
+
+	// the sets registered once the switch is done are sorted by the new function
+	assert.NoError(t, pp.Use(istanbul.ValidatorSortByStringDesc()))
+	valSet := NewSet([]common.Address{addr1, addr2}, pp)
+	assert.Equal(t, addr2, valSet.GetByIndex(0).Address())
+}
+
+func TestProposerPolicy_IsValidatorAt(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+
+	isValidator, err := pp.IsValidatorAt(1, addr1)
+	assert.True(t, errors.Is(err, istanbul.ErrNoValidatorSetRegistered), "unexpected error %v", err)
+	assert.False(t, isValidator)
+
+	pp.RegisterValidatorSet(9, NewSet([]common.Address{addr1, addr2}, pp))
+	pp.RecordOrderedValidatorsAt(10)
+	pp.ClearRegistry()
+	pp.RegisterValidatorSet(11, NewSet([]common.Address{addr1, addr3}, pp))
+
+	for _, tc := range []struct {
+		number   uint64
+		addr     common.Address
+		expected bool
+	}{
+		{10, addr1, true},
+		{10, addr2, true},
+		{10, addr3, false},
+		{11, addr2, false},
+		{11, addr3, true},
+	} {
+		isValidator, err := pp.IsValidatorAt(tc.number, tc.addr)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, isValidator, "block %d, address %s", tc.number, tc.addr.Hex())
+	}
+
+	_, err = pp.IsValidatorAt(9, addr1)
+	assert.True(t, errors.Is(err, istanbul.ErrNoValidatorSetRegistered), "unexpected error %v", err)
+}
+
+func TestProposerPolicy_PeekNextProposer(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+
+	for _, pp := range []*istanbul.ProposerPolicy{istanbul.NewRoundRobinProposerPolicy(), istanbul.NewStickyProposerPolicy()} {
+		_, err := pp.PeekNextProposer(1, 0)
+		assert.True(t, errors.Is(err, istanbul.ErrNoValidatorSetRegistered), "unexpected error %v", err)
+
+		valSet := NewSet([]common.Address{addr1, addr2, addr3}, pp)
+		pp.RegisterValidatorSet(1, valSet)
+		valSet.CalcProposer(addr2, 0)
+		current := valSet.GetProposer().Address()
+
+		for round := uint64(0); round < 3; round++ {
+			peeked, err := pp.PeekNextProposer(2, round)
+			assert.NoError(t, err)
+			again, err := pp.PeekNextProposer(2, round)
+			assert.NoError(t, err)
+			assert.Equal(t, peeked, again, "policy %d, round %d", pp.Id, round)
+			assert.Equal(t, current, valSet.GetProposer().Address(), "policy %d, round %d: peek changed the proposer", pp.Id, round)
+
+			selection := valSet.Copy()
+			selection.CalcProposer(current, round)
+			assert.Equal(t, selection.GetProposer().Address(), peeked, "policy %d, round %d", pp.Id, round)
+		}
+
+		peeked, err := pp.PeekNextProposer(2, 0)
+		assert.NoError(t, err)
+		valSet.CalcProposer(current, 0)
+		assert.Equal(t, peeked, valSet.GetProposer().Address(), "policy %d", pp.Id)
+		if pp.Id == istanbul.Sticky {
+			assert.Equal(t, current, peeked, "sticky proposer changed without round change")
+		} else {
+			assert.NotEqual(t, current, peeked, "round robin proposer didn't rotate")
+		}
+	}
+}
+
+func TestProposerPolicy_UpcomingProposers(t *testing.T) {
+	addrs := []common.Address{
+		common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112"),
+		common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2"),
+		common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c"),
+		common.HexToAddress("0x0a5f6e2d8a1e5e8b8e8f4a1b8f0f9d3d5d1d2a4b"),
+	}
+	cooldown := istanbul.NewRoundRobinProposerPolicy()
+	cooldown.ProposerCooldown = 2
+
+	for _, pp := range []*istanbul.ProposerPolicy{istanbul.NewRoundRobinProposerPolicy(), istanbul.NewStickyProposerPolicy(), cooldown} {
+		_, err := pp.UpcomingProposers(1, 3)
+		assert.True(t, errors.Is(err, istanbul.ErrNoValidatorSetRegistered), "unexpected error %v", err)
+
+		valSet := NewSet(addrs, pp)
+		pp.RegisterValidatorSet(1, valSet)
+		valSet.CalcProposer(addrs[1], 0)
+		current := valSet.GetProposer().Address()
+
+		none, err := pp.UpcomingProposers(2, 0)
+		assert.NoError(t, err)
+		assert.Empty(t, none)
+
+		upcoming, err := pp.UpcomingProposers(2, 8)
+		assert.NoError(t, err)
+		assert.Len(t, upcoming, 8)
+		assert.Equal(t, current, valSet.GetProposer().Address(), "policy %d, cooldown %d: the registered proposer changed", pp.Id, pp.ProposerCooldown)
+
+		// the actual selections, each block being proposed in its first round
+		last := current
+		for i, expected := range upcoming {
+			valSet.CalcProposer(last, 0)
+			last = valSet.GetProposer().Address()
+			assert.Equal(t, last, expected, "policy %d, cooldown %d, block %d", pp.Id, pp.ProposerCooldown, 2+i)
+		}
+	}
+}
+
+func TestProposerPolicy_UpcomingProposers_ValidatorSetChange(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	first := NewSet([]common.Address{addr1, addr2}, pp)
+	second := NewSet([]common.Address{addr1, addr2, addr3}, pp)
+	pp.RegisterValidatorSet(1, first)
+	pp.RegisterValidatorSet(4, second)
+	first.CalcProposer(addr1, 0)
+
+	upcoming, err := pp.UpcomingProposers(2, 5)
+	assert.NoError(t, err)
+
+	last := first.GetProposer().Address()
+	for i, expected := range upcoming {
+		valSet := first
+		if 2+i >= 4 {
+			valSet = second
+		}
+		valSet.CalcProposer(last, 0)
+		last = valSet.GetProposer().Address()
+		assert.Equal(t, last, expected, "block %d", 2+i)
+	}
+	assert.Contains(t, upcoming[2:], addr3, "the validator added at block 4 must propose")
+}
+
+func TestProposerPolicy_ValidatorSetAt(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	_, err := pp.ValidatorSetAt(0)
+	assert.True(t, errors.Is(err, istanbul.ErrNoValidatorSetRegistered), "unexpected error %v", err)
+
+	set5 := NewSet([]common.Address{addr1}, pp)
+	set10 := NewSet([]common.Address{addr1, addr2}, pp)
+	set20 := NewSet([]common.Address{addr1, addr2, addr3}, pp)
+	// registered out of order
+	pp.RegisterValidatorSet(10, set10)
+	pp.RegisterValidatorSet(20, set20)
+	pp.RegisterValidatorSet(5, set5)
+
+	for _, tc := range []struct {
+		blockNumber uint64
+		expected    istanbul.ValidatorSet
+	}{
+		{5, set5},
+		{9, set5},
+		{10, set10},
+		{19, set10},
+		{20, set20},
+		{1000, set20},
+	} {
+		valSet, err := pp.ValidatorSetAt(tc.blockNumber)
+		assert.NoError(t, err, "block %d", tc.blockNumber)
+		assert.Same(t, tc.expected, valSet, "block %d", tc.blockNumber)
+	}
+
+	_, err = pp.ValidatorSetAt(4)
+	assert.True(t, errors.Is(err, istanbul.ErrNoValidatorSetRegistered), "unexpected error %v", err)
+
+	// a set registered again for a height replaces the previous one
+	replacement := NewSet([]common.Address{addr3}, pp)
+	pp.RegisterValidatorSet(10, replacement)
+	valSet, err := pp.ValidatorSetAt(15)
+	assert.NoError(t, err)
+	assert.Same(t, replacement, valSet)
+
+	pp.ClearRegistry()
+	_, err = pp.ValidatorSetAt(1000)
+	assert.True(t, errors.Is(err, istanbul.ErrNoValidatorSetRegistered), "unexpected error %v", err)
+}
+
+func TestProposerPolicy_IsExpectedProposer(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+	validators := []common.Address{addr1, addr2, addr3}
+
+	for _, pp := range []*istanbul.ProposerPolicy{istanbul.NewRoundRobinProposerPolicy(), istanbul.NewStickyProposerPolicy()} {
+		_, err := pp.IsExpectedProposer(5, 0, addr1)
+		assert.True(t, errors.Is(err, istanbul.ErrNoValidatorSetRegistered), "unexpected error %v", err)
+
+		valSet := NewSet(validators, pp)
+		pp.RegisterValidatorSet(4, valSet)
+		_, err = pp.IsExpectedProposer(5, 0, addr1)
+		assert.True(t, errors.Is(err, istanbul.ErrNoProposerRecorded), "unexpected error %v", err)
+
+		lastProposer := valSet.GetByIndex(1).Address()
+		pp.RecordProposerAt(4, lastProposer)
+		for round := uint64(0); round < 4; round++ {
+			selection := valSet.Copy()
+			selection.CalcProposer(lastProposer, round)
+			inTurn := selection.GetProposer().Address()
+			for _, addr := range validators {
+				expected, err := pp.IsExpectedProposer(5, round, addr)
+				assert.NoError(t, err)
+				assert.Equal(t, addr == inTurn, expected, "policy %d, round %d, proposer %s", pp.Id, round, addr.Hex())
+			}
+		}
+
+		// in the first round, the sticky proposer is the last one, the round robin one is the next one
+		expected, err := pp.IsExpectedProposer(5, 0, lastProposer)
+		assert.NoError(t, err)
+		assert.Equal(t, pp.Id == istanbul.Sticky, expected, "policy %d", pp.Id)
+		expected, err = pp.IsExpectedProposer(5, 0, valSet.GetByIndex(2).Address())
+		assert.NoError(t, err)
+		assert.Equal(t, pp.Id == istanbul.RoundRobin, expected, "policy %d", pp.Id)
+
+		// an address which isn't a validator is never expected
+		expected, err = pp.IsExpectedProposer(5, 0, common.HexToAddress("0x1"))
+		assert.NoError(t, err)
+		assert.False(t, expected)
+	}
+}
+
+func TestProposerPolicy_ExportSelections(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	first := NewSet([]common.Address{addr1, addr2}, pp)
+	second := NewSet([]common.Address{addr1, addr2, addr3}, pp)
+	pp.RegisterValidatorSet(1, first)
+	pp.RegisterValidatorSet(4, second)
+
+	var out bytes.Buffer
+	err := pp.ExportSelections(2, 7, &out)
+	assert.True(t, errors.Is(err, istanbul.ErrNoProposerRecorded), "unexpected error %v", err)
+	assert.Zero(t, out.Len())
+
+	pp.RecordProposerAt(1, addr1)
+	first.CalcProposer(addr2, 0)
+	assert.NoError(t, pp.ExportSelections(2, 7, &out))
+	assert.Equal(t, addr1, first.GetProposer().Address(), "the registered proposer changed")
+
+	// the set registered at block 4 selects the proposers from block 5 on
+	var expected []istanbul.ProposerSelection
+	last := addr1
+	for blockNumber := uint64(2); blockNumber <= 7; blockNumber++ {
+		valSet := first.Copy()
+		if blockNumber > 4 {
+			valSet = second.Copy()
+		}
+		valSet.CalcProposer(last, 0)
+		last = valSet.GetProposer().Address()
+		expected = append(expected, istanbul.ProposerSelection{Height: blockNumber, Proposer: last})
+	}
+	var exported []istanbul.ProposerSelection
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var selection istanbul.ProposerSelection
+		assert.NoError(t, decoder.Decode(&selection))
+		exported = append(exported, selection)
+	}
+	assert.Equal(t, expected, exported)
+	assert.Equal(t, []istanbul.ProposerSelection{{Height: 2, Proposer: addr2}, {Height: 3, Proposer: addr1}, {Height: 4, Proposer: addr2}, {Height: 5, Proposer: addr3}, {Height: 6, Proposer: addr1}, {Height: 7, Proposer: addr2}}, exported)
+
+	out.Reset()
+	assert.Error(t, pp.ExportSelections(0, 3, &out))
+	assert.Error(t, pp.ExportSelections(3, 2, &out))
+	assert.Zero(t, out.Len())
+}
+
+func TestProposerPolicy_SeedFromGenesis(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+	addr3 := common.HexToAddress("0xc8417f834995aaeb35f342a67a4961e19cd4735c")
+	addr4 := common.HexToAddress("0x3fc62b3afd0e2d1e5e7ad0a9bd5ec8b2c1e4a8d7")
+	extraData := []common.Address{addr3, addr1, addr4, addr2}
+	reordered := []common.Address{addr2, addr4, addr1, addr3}
+
+	seed := common.HexToHash("0x2a")
+	for name, newPolicy := range map[string]func() *istanbul.ProposerPolicy{
+		"round robin": istanbul.NewRoundRobinProposerPolicy,
+		"sticky":      istanbul.NewStickyProposerPolicy,
+		"seeded round robin": func() *istanbul.ProposerPolicy {
+			pp := istanbul.NewRoundRobinProposerPolicy()
+			pp.Seed = &seed
+			return pp
+		},
+		"string desc": func() *istanbul.ProposerPolicy {
+			return istanbul.NewProposerPolicyByIdAndSortFunc(istanbul.RoundRobin, istanbul.ValidatorSortByStringDesc())
+		},
+	} {
+		pp1, pp2 := newPolicy(), newPolicy()
+		valSet1, err := pp1.SeedFromGenesis(extraData)
+		assert.NoError(t, err, name)
+		valSet2, err := pp2.SeedFromGenesis(reordered)
+		assert.NoError(t, err, name)
+
+		assert.Equal(t, []common.Address{addr3, addr1, addr4, addr2}, extraData, "%s: genesis validators modified", name)
+		assert.Equal(t, valSet1.GetProposer().Address(), valSet2.GetProposer().Address(), name)
+		assert.Equal(t, pp1.ProposerOrder(valSet1), pp2.ProposerOrder(valSet2), name)
+
+		registered, err := pp1.ValidatorSetAt(0)
+		assert.NoError(t, err, name)
+		assert.Same(t, valSet1, registered, name)
+		order1, err := pp1.OrderedValidatorsAt(0)
+		assert.NoError(t, err, name)
+		order2, err := pp2.OrderedValidatorsAt(0)
+		assert.NoError(t, err, name)
+		assert.Equal(t, order1, order2, name)
+		assert.ElementsMatch(t, extraData, order1, name)
+
+		proposers1, err := pp1.UpcomingProposers(1, 8)
+		assert.NoError(t, err, name)
+		proposers2, err := pp2.UpcomingProposers(1, 8)
+		assert.NoError(t, err, name)
+		assert.Equal(t, proposers1, proposers2, name)
+	}
+}
+
+func TestProposerPolicy_SetRegistryGuard(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+	addr2 := common.HexToAddress("0xed2d479591fe2c5626ce09bca4ed2a62e00e5bc2")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	pp.RegisterValidatorSet(1, NewSet([]common.Address{addr1}, pp))
+	gauge := new(metrics.StandardGauge)
+	pp.SetRegistryGuard(gauge, 0, false)
+	assert.Equal(t, int64(1), gauge.Value())
+
+	pp.RegisterValidatorSet(2, NewSet([]common.Address{addr1, addr2}, pp))
+	pp.RegisterValidatorSet(3, NewSet([]common.Address{addr1, addr2}, pp))
+	assert.Equal(t, int64(3), gauge.Value())
+
+	// replacing the ValidatorSet of a height doesn't grow the registry
+	pp.RegisterValidatorSet(3, NewSet([]common.Address{addr2}, pp))
+	assert.Equal(t, int64(3), gauge.Value())
+
+	pp.PruneRegistryBelow(3)
+	assert.Equal(t, int64(1), gauge.Value())
+
+	// no ValidatorSet is registered for height 4, the one of height 3 still applies to it
+	pp.RegisterValidatorSet(5, NewSet([]common.Address{addr1, addr2}, pp))
+	pp.PruneRegistryBelow(4)
+	assert.Equal(t, int64(2), gauge.Value())
+	proposer, err := pp.PeekNextProposer(4, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, addr2, proposer)
+
+	pp.ClearRegistry()
+	assert.Equal(t, int64(0), gauge.Value())
+	_, err = pp.PeekNextProposer(3, 0)
+	assert.True(t, errors.Is(err, istanbul.ErrNoValidatorSetRegistered), "unexpected error %v", err)
+}
+
+func TestProposerPolicy_SetRegistryGuard_PrunesAboveSoftCap(t *testing.T) {
+	addr1 := common.HexToAddress("0xc53f2189bf6d7bf56722731787127f90d319e112")
+
+	pp := istanbul.NewRoundRobinProposerPolicy()
+	gauge := new(metrics.StandardGauge)
+	pp.SetRegistryGuard(gauge, 3, true)
+	for number := uint64(1); number <= 10; number++ {
+		pp.RegisterValidatorSet(number, NewSet([]common.Address{addr1}, pp))
+		assert.LessOrEqual(t, gauge.Value(), int64(3), "block %d", number)
+	}
+	assert.Equal(t, int64(3), gauge.Value())
+
+	// the ValidatorSets of the highest heights are kept
+	for number := uint64(8); number <= 10; number++ {
+		_, err := pp.PeekNextProposer(number, 0)
+		assert.NoError(t, err, "block %d", number)
+	}
+	_, err := pp.PeekNextProposer(7, 0)
+	assert.True(t, errors.Is(err, istanbul.ErrNoValidatorSetRegistered), "unexpected error %v", err)
+
+	// without pruning the registry only grows beyond the soft cap
+	pp = istanbul.NewRoundRobinProposerPolicy()
+	pp.SetRegistryGuard(gauge, 3, false)
+	for number := uint64(1); number <= 5; number++ {
+		pp.RegisterValidatorSet(number, NewSet([]common.Address{addr1}, pp))
+	}
+	assert.Equal(t, int64(5), gauge.Value())
+}
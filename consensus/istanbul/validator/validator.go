@@ -23,6 +23,10 @@ import (
 	"github.com/kisexp/xdchain/consensus/istanbul"
 )
 
+func init() {
+	istanbul.RegisterValidatorSetFactory(NewSet)
+}
+
 func New(addr common.Address) istanbul.Validator {
 	return &defaultValidator{
 		address: addr,
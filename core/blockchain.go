@@ -137,6 +137,9 @@ type CacheConfig struct {
 	SnapshotWait bool // Wait for snapshot construction on startup. TODO(karalabe): This is a dirty hack for testing, nuke it
 
 	PrivateTrieCleanJournal string // Quorum: Disk journal for saving clean private cache entries.
+	PrivateStateOpenLimit   int    // Quorum: Maximum number of private state repositories opened concurrently, 0 for no limit
+	PrivateStatePrefetch    bool   // Quorum: Whether to prefetch the private state trie nodes of the accounts of the private transactions of imported blocks
+	PrivateTrieDirtyLimit   int    // Quorum: Memory ceiling (MB) of the dirty nodes of each private state trie cache, flushed to disk once approached, 0 for no ceiling (multiple private states only)
 }
 
 // defaultCacheConfig are the default caching values if none are specified by the
@@ -279,7 +282,7 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		Cache:     cacheConfig.TrieCleanLimit,
 		Journal:   cacheConfig.PrivateTrieCleanJournal,
 		Preimages: cacheConfig.Preimages,
-	}, chainConfig.IsMPS); err != nil {
+	}, chainConfig.IsMPS, cacheConfig.PrivateStateOpenLimit, cacheConfig.PrivateTrieDirtyLimit); err != nil {
 		return nil, err
 	}
 	bc.hc, err = NewHeaderChain(db, chainConfig, engine, bc.insertStopped)
@@ -787,7 +790,7 @@ func (bc *BlockChain) StatePSI(psi types.PrivateStateIdentifier) (*state.StateDB
 // StatePSI returns a new mutable public state and a mutable private state for the given PSI,
 // based on a particular point in time.
 func (bc *BlockChain) StateAtPSI(root common.Hash, psi types.PrivateStateIdentifier) (*state.StateDB, *state.StateDB, error) {
-	publicStateDb, privateStateRepo, err := bc.StateAt(root)
+	publicStateDb, privateStateRepo, err := bc.StateAtReadOnly(root)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -817,6 +820,22 @@ func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, mps.PrivateStat
 	return publicStateDb, privateStateRepo, nil
 }
 
+// StateAtReadOnly is like StateAt for the read paths, e.g. the RPC calls: the returned private state repo
+// can't be written to the database
+func (bc *BlockChain) StateAtReadOnly(root common.Hash) (*state.StateDB, mps.PrivateStateRepository, error) {
+	publicStateDb, publicStateDbErr := state.New(root, bc.stateCache, bc.snaps)
+	if publicStateDbErr != nil {
+		return nil, nil, publicStateDbErr
+	}
+
+	privateStateRepo, privateStateRepoErr := bc.privateStateManager.StateRepositoryReadOnly(root)
+	if privateStateRepoErr != nil {
+		return nil, nil, privateStateRepoErr
+	}
+
+	return publicStateDb, privateStateRepo, nil
+}
+
 // StateCache returns the caching database underpinning the blockchain instance.
 func (bc *BlockChain) StateCache() state.Database {
 	return bc.stateCache
@@ -2081,6 +2100,9 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, er
 		if err != nil {
 			return it.index, err
 		}
+		if bc.cacheConfig.PrivateStatePrefetch {
+			bc.prefetchPrivateStates(parent.Root, block)
+		}
 		// End Quorum
 
 		// If we have a followup block, run that against the current state to pre-cache
@@ -2465,6 +2487,17 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 	} else {
 		log.Error("Impossible reorg, please file an issue", "oldnum", oldBlock.Number(), "oldhash", oldBlock.Hash(), "newnum", newBlock.Number(), "newhash", newBlock.Hash())
 	}
+	// Quorum: drop the private states cached for the abandoned chain
+	abandonedRoots, insertedRoots := make([]common.Hash, len(oldChain)), make([]common.Hash, len(newChain))
+	for i, block := range oldChain {
+		abandonedRoots[i] = block.Root()
+	}
+	for i, block := range newChain {
+		insertedRoots[i] = block.Root()
+	}
+	if err := bc.privateStateManager.HandleReorg(commonBlock.Root(), abandonedRoots, insertedRoots); err != nil {
+		log.Warn("Failed to drop the private states of the abandoned chain", "number", commonBlock.Number(), "hash", commonBlock.Hash(), "err", err)
+	}
 	// Insert the new chain(except the head block(reverse order)),
 	// taking care of the proper incremental order.
 	for i := len(newChain) - 1; i >= 1; i-- {
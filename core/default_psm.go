@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/core/mps"
@@ -9,6 +10,7 @@ import (
 	"github.com/kisexp/xdchain/core/state"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/ethdb"
+	"github.com/kisexp/xdchain/log"
 	"github.com/kisexp/xdchain/rpc"
 	"github.com/kisexp/xdchain/trie"
 )
@@ -17,27 +19,71 @@ type DefaultPrivateStateManager struct {
 	// Low level persistent database to store final content in
 	db        ethdb.Database
 	repoCache state.Database
+
+	// openLimiter bounds the number of repositories opened concurrently
+	openLimiter stateRepositoryOpenLimiter
 }
 
-func newDefaultPrivateStateManager(db ethdb.Database, config *trie.Config) *DefaultPrivateStateManager {
+// NewDefaultPrivateStateManager returns the manager of the single private state of a node without
+// multiple private states. The private states are read from and written to db, through a trie cache
+// configured by config, which may be nil to use the default trie settings.
+func NewDefaultPrivateStateManager(db ethdb.Database, config *trie.Config) *DefaultPrivateStateManager {
 	return &DefaultPrivateStateManager{
 		db:        db,
 		repoCache: state.NewDatabaseWithConfig(db, config),
 	}
 }
 
+func newDefaultPrivateStateManager(db ethdb.Database, config *trie.Config) *DefaultPrivateStateManager {
+	return NewDefaultPrivateStateManager(db, config)
+}
+
 func (d *DefaultPrivateStateManager) StateRepository(blockHash common.Hash) (mps.PrivateStateRepository, error) {
-	return mps.NewDefaultPrivateStateRepository(d.db, d.repoCache, blockHash)
+	return d.StateRepositoryContext(context.Background(), blockHash)
+}
+
+func (d *DefaultPrivateStateManager) StateRepositoryContext(ctx context.Context, blockHash common.Hash) (mps.PrivateStateRepository, error) {
+	return d.openLimiter.open(ctx, func() (mps.PrivateStateRepository, error) {
+		repo, err := mps.NewDefaultPrivateStateRepository(d.db, d.repoCache, blockHash)
+		if err != nil {
+			return nil, err
+		}
+		return mps.LabelRepository(ctx, repo), nil
+	})
+}
+
+// StateRepositoryReadOnly returns the repository of the block hash with its write methods disabled
+func (d *DefaultPrivateStateManager) StateRepositoryReadOnly(blockHash common.Hash) (mps.PrivateStateRepository, error) {
+	repo, err := d.StateRepository(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	return mps.NewReadOnlyRepository(repo), nil
 }
 
-func (d *DefaultPrivateStateManager) ResolveForManagedParty(_ string) (*mps.PrivateStateMetadata, error) {
+func (d *DefaultPrivateStateManager) ResolveForManagedParty(managedParty string) (*mps.PrivateStateMetadata, error) {
+	return d.ResolveForManagedPartyContext(context.Background(), managedParty)
+}
+
+// ResolveForManagedPartyContext is like ResolveForManagedParty but returns the context error if the context
+// is already cancelled or its deadline exceeded
+func (d *DefaultPrivateStateManager) ResolveForManagedPartyContext(ctx context.Context, _ string) (*mps.PrivateStateMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return mps.DefaultPrivateStateMetadata, nil
 }
 
+func (d *DefaultPrivateStateManager) ResolveAllForManagedParty(_ string) ([]*mps.PrivateStateMetadata, error) {
+	return []*mps.PrivateStateMetadata{mps.DefaultPrivateStateMetadata}, nil
+}
+
+// ResolveForUserContext returns mps.DefaultPrivateStateMetadata, which must not be modified, when the user
+// context has no PSI or the default one
 func (d *DefaultPrivateStateManager) ResolveForUserContext(ctx context.Context) (*mps.PrivateStateMetadata, error) {
 	psi, ok := rpc.PrivateStateIdentifierFromContext(ctx)
-	if !ok {
-		psi = types.DefaultPrivateStateIdentifier
+	if !ok || psi == types.DefaultPrivateStateIdentifier {
+		return mps.DefaultPrivateStateMetadata, nil
 	}
 	return &mps.PrivateStateMetadata{ID: psi, Type: mps.Resident}, nil
 }
@@ -48,16 +94,94 @@ func (d *DefaultPrivateStateManager) PSIs() []types.PrivateStateIdentifier {
 	}
 }
 
+func (d *DefaultPrivateStateManager) PrivacyGroups() map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata {
+	return map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		types.DefaultPrivateStateIdentifier: copyPrivateStateMetadata(mps.DefaultPrivateStateMetadata),
+	}
+}
+
 func (d *DefaultPrivateStateManager) NotIncludeAny(_ *mps.PrivateStateMetadata, _ ...string) bool {
 	// with default implementation, all managedParties are members of the psm
 	return false
 }
 
 func (d *DefaultPrivateStateManager) CheckAt(root common.Hash) error {
-	_, err := state.New(rawdb.GetPrivateStateRoot(d.db, root), d.repoCache, nil)
+	_, err := d.CheckRootAt(root)
 	return err
 }
 
+// CheckRootAt checks the private state of the block hash, it returns the root of the private state
+func (d *DefaultPrivateStateManager) CheckRootAt(root common.Hash) (common.Hash, error) {
+	privateStateRoot := rawdb.GetPrivateStateRoot(d.db, root)
+	_, err := state.New(privateStateRoot, d.repoCache, nil)
+	return privateStateRoot, err
+}
+
+// CheckRange checks the private state of each block hash with the same state database
+func (d *DefaultPrivateStateManager) CheckRange(roots []common.Hash) (map[common.Hash]error, error) {
+	return checkRange(d.repoCache, roots, func(root common.Hash) common.Hash {
+		return rawdb.GetPrivateStateRoot(d.db, root)
+	})
+}
+
+// HasStateAt checks if the private state root of the block hash has been stored.
+// Only the default psi is supported by the default private state manager
+func (d *DefaultPrivateStateManager) HasStateAt(psi types.PrivateStateIdentifier, blockHash common.Hash) (bool, error) {
+	if psi != types.DefaultPrivateStateIdentifier {
+		return false, nil
+	}
+	return !common.EmptyHash(rawdb.GetPrivateStateRoot(d.db, blockHash)), nil
+}
+
+// PrivateStateRootAt returns the root of the private state stored for the block hash
+func (d *DefaultPrivateStateManager) PrivateStateRootAt(blockHash common.Hash) (common.Hash, error) {
+	root := rawdb.GetPrivateStateRoot(d.db, blockHash)
+	if common.EmptyHash(root) {
+		return common.Hash{}, fmt.Errorf("%w for block %x", mps.ErrNoPrivateStateRoot, blockHash)
+	}
+	return root, nil
+}
+
+// DumpRoots returns the root of the private state stored for the block hash, the single entry is keyed by
+// the default psi
+func (d *DefaultPrivateStateManager) DumpRoots(blockHash common.Hash) (map[types.PrivateStateIdentifier]common.Hash, error) {
+	root, err := d.PrivateStateRootAt(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	return map[types.PrivateStateIdentifier]common.Hash{types.DefaultPrivateStateIdentifier: root}, nil
+}
+
+// HandleReorg drops the private states cached in memory only for the blocks abandoned by a reorg, given the
+// block roots of the common ancestor of the old and new chains, of the abandoned blocks and of the blocks
+// inserted instead. The private states shared with the ancestor or the inserted blocks are kept. The returned
+// error wraps mps.ErrNoPrivateStateRoot if no root is stored for the ancestor, or is the error opening its
+// private state.
+func (d *DefaultPrivateStateManager) HandleReorg(ancestorRoot common.Hash, abandonedRoots, insertedRoots []common.Hash) error {
+	root, err := d.PrivateStateRootAt(ancestorRoot)
+	if err != nil {
+		return err
+	}
+	kept := d.privateStateRootsAt(insertedRoots)
+	kept[root] = true
+	dropped := discardAbandonedRoots(d.repoCache, d.privateStateRootsAt(abandonedRoots), kept)
+	log.Debug("Dropped cached private state on reorg", "ancestor", ancestorRoot, "abandoned", len(abandonedRoots), "nodes", dropped)
+
+	_, err = d.repoCache.OpenTrie(root)
+	return err
+}
+
+// privateStateRootsAt returns the roots of the private states stored for the block roots
+func (d *DefaultPrivateStateManager) privateStateRootsAt(blockRoots []common.Hash) map[common.Hash]bool {
+	roots := make(map[common.Hash]bool, len(blockRoots))
+	for _, blockRoot := range blockRoots {
+		if root := rawdb.GetPrivateStateRoot(d.db, blockRoot); !common.EmptyHash(root) {
+			roots[root] = true
+		}
+	}
+	return roots
+}
+
 func (d *DefaultPrivateStateManager) TrieDB() *trie.Database {
 	return d.repoCache.TrieDB()
 }
@@ -2,10 +2,14 @@ package core
 
 import (
 	"context"
+	"errors"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/core/mps"
+	"github.com/kisexp/xdchain/core/rawdb"
 	"github.com/kisexp/xdchain/core/state"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/core/vm"
@@ -15,6 +19,7 @@ import (
 	"github.com/kisexp/xdchain/trie"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 //Tests DefaultState, StatePSI, CommitAndWrite
@@ -72,6 +77,10 @@ func TestLegacyPrivateStateCreated(t *testing.T) {
 			_, _, err = blockchain.StateAtPSI(latestBlockRoot, types.ToPrivateStateIdentifier("other"))
 			assert.Error(t, err, "only the 'private' psi is supported by the default private state manager")
 		}
+		//the read paths can't write the private state
+		_, readOnlyRepo, err := blockchain.StateAtReadOnly(block.Root())
+		assert.NoError(t, err)
+		assert.Equal(t, mps.ErrReadOnlyRepository, readOnlyRepo.CommitAndWrite(false, block))
 	}
 }
 
@@ -100,12 +109,247 @@ func TestDefaultResolver(t *testing.T) {
 
 	psm1, _ := mpsm.ResolveForManagedParty("TEST")
 	assert.Equal(t, psm1, mps.DefaultPrivateStateMetadata)
+	psm1, _ = mpsm.ResolveForManagedPartyContext(context.Background(), "TEST")
+	assert.Same(t, mps.DefaultPrivateStateMetadata, psm1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := mpsm.ResolveForManagedPartyContext(ctx, "TEST")
+	assert.Equal(t, context.Canceled, err)
 
-	ctx := rpc.WithPrivateStateIdentifier(context.Background(), types.DefaultPrivateStateIdentifier)
+	ctx = rpc.WithPrivateStateIdentifier(context.Background(), types.DefaultPrivateStateIdentifier)
 	psm1, _ = mpsm.ResolveForUserContext(ctx)
-	assert.Equal(t, psm1, &mps.PrivateStateMetadata{ID: "private", Type: mps.Resident})
+	assert.Same(t, mps.DefaultPrivateStateMetadata, psm1)
 	psm1, _ = mpsm.ResolveForUserContext(context.Background())
-	assert.Equal(t, psm1, &mps.PrivateStateMetadata{ID: "private", Type: mps.Resident})
+	assert.Same(t, mps.DefaultPrivateStateMetadata, psm1)
+	psm1, _ = mpsm.ResolveForUserContext(rpc.WithPrivateStateIdentifier(context.Background(), "other"))
+	assert.Equal(t, psm1, &mps.PrivateStateMetadata{ID: "other", Type: mps.Resident})
 
 	assert.Equal(t, mpsm.PSIs(), []types.PrivateStateIdentifier{types.DefaultPrivateStateIdentifier})
 }
+
+func TestDefaultPrivateStateManager_StateRepositoryContext(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockptm := private.NewMockPrivateTransactionManager(mockCtrl)
+
+	saved := private.P
+	defer func() {
+		private.P = saved
+	}()
+	private.P = mockptm
+
+	mockptm.EXPECT().Receive(gomock.Any()).Return("", []string{}, common.FromHex(testCode), nil, nil).AnyTimes()
+
+	blocks, _, blockchain := buildTestChain(1, params.QuorumTestChainConfig)
+	dpsm := newDefaultPrivateStateManager(blockchain.db, nil)
+
+	repo, err := dpsm.StateRepositoryContext(context.Background(), blocks[0].Root())
+	assert.NoError(t, err)
+	assert.False(t, repo.IsMPS())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = dpsm.StateRepositoryContext(ctx, blocks[0].Root())
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestDefaultPrivateStateManager_HasStateAt(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	dpsm := newDefaultPrivateStateManager(db, nil)
+	blockRoot := common.Hash{123}
+
+	exists, err := dpsm.HasStateAt(types.DefaultPrivateStateIdentifier, blockRoot)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.NoError(t, rawdb.WritePrivateStateRoot(db, blockRoot, common.Hash{1}))
+
+	exists, err = dpsm.HasStateAt(types.DefaultPrivateStateIdentifier, blockRoot)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = dpsm.HasStateAt(types.PrivateStateIdentifier("other"), blockRoot)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestDefaultPrivateStateManager_PrivateStateRootAt(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	dpsm := newDefaultPrivateStateManager(db, nil)
+	blockRoot := common.Hash{123}
+
+	_, err := dpsm.PrivateStateRootAt(blockRoot)
+	assert.True(t, errors.Is(err, mps.ErrNoPrivateStateRoot), "unexpected error: %v", err)
+
+	assert.NoError(t, rawdb.WritePrivateStateRoot(db, blockRoot, common.Hash{1}))
+
+	root, err := dpsm.PrivateStateRootAt(blockRoot)
+	assert.NoError(t, err)
+	assert.Equal(t, common.Hash{1}, root)
+}
+
+func TestDefaultPrivateStateManager_DumpRoots(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	dpsm := newDefaultPrivateStateManager(db, nil)
+	blockRoot := common.Hash{123}
+
+	_, err := dpsm.DumpRoots(blockRoot)
+	assert.True(t, errors.Is(err, mps.ErrNoPrivateStateRoot), "unexpected error: %v", err)
+
+	assert.NoError(t, rawdb.WritePrivateStateRoot(db, blockRoot, common.Hash{1}))
+
+	roots, err := dpsm.DumpRoots(blockRoot)
+	assert.NoError(t, err)
+	assert.Equal(t, map[types.PrivateStateIdentifier]common.Hash{types.DefaultPrivateStateIdentifier: {1}}, roots)
+}
+
+func TestDefaultPrivateStateManager_CheckRange(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	dpsm := newDefaultPrivateStateManager(db, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+	corruptedBlockRoot := common.Hash{124}
+
+	repo, _ := dpsm.StateRepository(common.Hash{})
+	privateState, _ := repo.DefaultState()
+	privateState.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+	assert.NoError(t, rawdb.WritePrivateStateRoot(db, corruptedBlockRoot, common.Hash{1}))
+
+	results, err := dpsm.CheckRange([]common.Hash{block.Root(), corruptedBlockRoot})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.NoError(t, results[block.Root()])
+	assert.Error(t, results[corruptedBlockRoot])
+}
+
+func TestDefaultPrivateStateManager_CheckRootAt(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	dpsm := newDefaultPrivateStateManager(db, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+	corruptedBlockRoot := common.Hash{124}
+
+	repo, _ := dpsm.StateRepository(common.Hash{})
+	privateState, _ := repo.DefaultState()
+	privateState.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+	assert.NoError(t, rawdb.WritePrivateStateRoot(db, corruptedBlockRoot, common.Hash{1}))
+
+	root, err := dpsm.CheckRootAt(block.Root())
+	assert.NoError(t, err)
+	assert.Equal(t, rawdb.GetPrivateStateRoot(db, block.Root()), root)
+	assert.False(t, common.EmptyHash(root))
+
+	root, err = dpsm.CheckRootAt(corruptedBlockRoot)
+	assert.Error(t, err)
+	assert.Equal(t, common.Hash{1}, root)
+	assert.Equal(t, err, dpsm.CheckAt(corruptedBlockRoot))
+}
+
+func TestDefaultPrivateStateManager_PrivacyGroups(t *testing.T) {
+	dpsm := newDefaultPrivateStateManager(rawdb.NewMemoryDatabase(), nil)
+
+	groups := dpsm.PrivacyGroups()
+	assert.Equal(t, map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		types.DefaultPrivateStateIdentifier: mps.DefaultPrivateStateMetadata,
+	}, groups)
+
+	groups[types.DefaultPrivateStateIdentifier].Name = "changed"
+	assert.NotEqual(t, "changed", mps.DefaultPrivateStateMetadata.Name)
+}
+
+func TestStateRepositoryOpenLimiter_OpensInCallerWithoutCancellation(t *testing.T) {
+	// a panic can only be recovered here if the repository is opened by the calling goroutine
+	assert.PanicsWithValue(t, "opened", func() {
+		_, _ = stateRepositoryOpenLimiter{}.open(context.Background(), func() (mps.PrivateStateRepository, error) {
+			panic("opened")
+		})
+	})
+}
+
+func TestStateRepositoryOpenLimiter_WaitsForAnOpenToComplete(t *testing.T) {
+	limiter := newStateRepositoryOpenLimiter(1)
+	opening, release := make(chan struct{}), make(chan struct{})
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := limiter.open(context.Background(), func() (mps.PrivateStateRepository, error) {
+			close(opening)
+			<-release
+			return nil, nil
+		})
+		firstDone <- err
+	}()
+	<-opening
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := limiter.open(ctx, func() (mps.PrivateStateRepository, error) {
+		t.Error("opened while the limit is reached")
+		return nil, nil
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(release)
+	require.NoError(t, <-firstDone)
+	opened := false
+	_, err = limiter.open(context.Background(), func() (mps.PrivateStateRepository, error) {
+		opened = true
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, opened)
+}
+
+func TestStateRepositoryOpenLimiter_HoldsTheSlotUntilACancelledOpenCompletes(t *testing.T) {
+	limiter := newStateRepositoryOpenLimiter(1)
+	opening, release := make(chan struct{}), make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-opening
+		cancel()
+	}()
+	_, err := limiter.open(ctx, func() (mps.PrivateStateRepository, error) {
+		close(opening)
+		<-release
+		return nil, nil
+	})
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Len(t, limiter.slots, 1, "the open still running must count against the limit")
+	close(release)
+	// polls instead of assert.Eventually whose late checks may send on its closed channel
+	for deadline := time.Now().Add(time.Second); len(limiter.slots) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Len(t, limiter.slots, 0, "the slot must be released once the open completes")
+}
+
+func TestStateRepositoryOpenLimiter_IsUnlimitedWhenNotPositive(t *testing.T) {
+	assert.Nil(t, newStateRepositoryOpenLimiter(0).slots)
+	assert.Nil(t, newStateRepositoryOpenLimiter(-1).slots)
+}
+
+func BenchmarkDefaultPrivateStateManager_ResolveForUserContext(b *testing.B) {
+	dpsm := newDefaultPrivateStateManager(rawdb.NewMemoryDatabase(), nil)
+	ctx := rpc.WithPrivateStateIdentifier(context.Background(), types.DefaultPrivateStateIdentifier)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := dpsm.ResolveForUserContext(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDefaultPrivateStateManager_StateRepositoryContext_TraceLabel(t *testing.T) {
+	dpsm := newDefaultPrivateStateManager(rawdb.NewMemoryDatabase(), nil)
+
+	repo, err := dpsm.StateRepositoryContext(mps.WithTraceLabel(context.Background(), "eth_call#42"), common.Hash{})
+	assert.NoError(t, err)
+	assert.Equal(t, "eth_call#42", mps.RepositoryLabel(repo))
+	assert.Equal(t, "eth_call#42", mps.RepositoryLabel(repo.Copy()))
+
+	repo, err = dpsm.StateRepository(common.Hash{})
+	assert.NoError(t, err)
+	assert.Empty(t, mps.RepositoryLabel(repo))
+}
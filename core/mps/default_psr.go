@@ -1,6 +1,7 @@
 package mps
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/kisexp/xdchain/common"
@@ -20,6 +21,8 @@ type DefaultPrivateStateRepository struct {
 	// stateDB gives access to the underlying state
 	stateDB *state.StateDB
 	root    common.Hash
+	// label is the trace label of the request the repository has been opened for
+	label string
 }
 
 func NewDefaultPrivateStateRepository(db ethdb.Database, cache state.Database, previousBlockHash common.Hash) (*DefaultPrivateStateRepository, error) {
@@ -57,6 +60,16 @@ func (dpsr *DefaultPrivateStateRepository) StatePSI(psi types.PrivateStateIdenti
 	return dpsr.stateDB, nil
 }
 
+// AccountIterator iterates over a copy of the private state, so the iteration isn't affected by
+// the changes made to the state meanwhile
+func (dpsr *DefaultPrivateStateRepository) AccountIterator(ctx context.Context, psi types.PrivateStateIdentifier) (*state.AccountIterator, error) {
+	stateDB, err := dpsr.StatePSI(psi)
+	if err != nil {
+		return nil, err
+	}
+	return stateDB.Copy().NewAccountIterator(ctx, nil), nil
+}
+
 func (dpsr *DefaultPrivateStateRepository) Reset() error {
 	// TODO - see if we need to  store the original root
 	return dpsr.stateDB.Reset(dpsr.root)
@@ -70,7 +83,7 @@ func (dpsr *DefaultPrivateStateRepository) CommitAndWrite(isEIP158 bool, block *
 	}
 
 	if err := rawdb.WritePrivateStateRoot(dpsr.db, block.Root(), privateRoot); err != nil {
-		log.Error("Failed writing private state root", "err", err)
+		log.Error("Failed writing private state root", "label", dpsr.label, "err", err)
 		return err
 	}
 	return dpsr.stateCache.TrieDB().Commit(privateRoot, false, nil)
@@ -89,9 +102,20 @@ func (dpsr *DefaultPrivateStateRepository) Copy() PrivateStateRepository {
 		stateCache: dpsr.stateCache,
 		stateDB:    dpsr.stateDB.Copy(),
 		root:       dpsr.root,
+		label:      dpsr.label,
 	}
 }
 
+// Label returns the trace label of the repository
+func (dpsr *DefaultPrivateStateRepository) Label() string {
+	return dpsr.label
+}
+
+// SetLabel sets the trace label of the repository, which is kept by its copies
+func (dpsr *DefaultPrivateStateRepository) SetLabel(label string) {
+	dpsr.label = label
+}
+
 // Given a slice of public receipts and an overlapping (smaller) slice of
 // private receipts, return a new slice where the default for each location is
 // the public receipt but we take the private receipt in each place we have
@@ -0,0 +1,15 @@
+package mps
+
+import "context"
+
+// DynamicResolver resolves the private states dynamically, e.g. from an external directory service,
+// instead of from the static metadata of the private state manager. A nil metadata with a nil error
+// tells the manager to fall back to its static metadata.
+//
+// The resolution of the PSI of the user context is free to ignore the PSI of the context.
+type DynamicResolver interface {
+	// ResolveForManagedParty returns the private state metadata the managed party is a member of
+	ResolveForManagedParty(ctx context.Context, managedParty string) (*PrivateStateMetadata, error)
+	// ResolveForUserContext returns the private state metadata of the user context
+	ResolveForUserContext(ctx context.Context) (*PrivateStateMetadata, error)
+}
@@ -16,17 +16,56 @@ type PrivateStateManager interface {
 	PrivateStateMetadataResolver
 	// StateRepository returns repository corresponding to a block hash
 	StateRepository(blockHash common.Hash) (PrivateStateRepository, error)
+	// StateRepositoryContext is like StateRepository but gives up opening the repository
+	// once the context is cancelled or its deadline is exceeded
+	StateRepositoryContext(ctx context.Context, blockHash common.Hash) (PrivateStateRepository, error)
+	// StateRepositoryReadOnly is like StateRepository for read paths, the returned repository can't
+	// be written to the database: its commits fail with ErrReadOnlyRepository
+	StateRepositoryReadOnly(blockHash common.Hash) (PrivateStateRepository, error)
 	// CheckAt verifies if there's a state being managed at a block hash
 	CheckAt(blockHash common.Hash) error
+	// CheckRootAt is like CheckAt but also returns the root of the private state(s) checked, which is
+	// resolved the same way as PrivateStateRootAt. The root is returned even if the check fails
+	CheckRootAt(blockHash common.Hash) (common.Hash, error)
+	// CheckRange is like CheckAt for each of the block hashes, it returns the result of each check
+	// keyed by block hash. An error is returned if the check has to be aborted
+	CheckRange(blockHashes []common.Hash) (map[common.Hash]error, error)
+	// HasStateAt checks if the private state identified by psi exists at a block hash
+	// without opening the repository. It returns false without error if there is no such state
+	HasStateAt(psi types.PrivateStateIdentifier, blockHash common.Hash) (bool, error)
+	// PrivateStateRootAt returns the root stored for the private state(s) at a block hash: the root of the
+	// private state for the default manager, the root of the private states trie for the multiple one.
+	// The returned error wraps ErrNoPrivateStateRoot if no root is stored
+	PrivateStateRootAt(blockHash common.Hash) (common.Hash, error)
+	// DumpRoots returns the root of each private state stored at a block hash keyed by psi, e.g. for a
+	// diagnostic, without opening the states. The returned error wraps ErrNoPrivateStateRoot if no root is stored
+	DumpRoots(blockHash common.Hash) (map[types.PrivateStateIdentifier]common.Hash, error)
+	// HandleReorg drops the private states cached in memory only for the blocks abandoned by a reorg, given
+	// the block roots of the common ancestor, of the abandoned blocks and of the blocks inserted instead
+	HandleReorg(ancestorRoot common.Hash, abandonedRoots, insertedRoots []common.Hash) error
 	// TrieDB returns the trie database
 	TrieDB() *trie.Database
 }
 
 type PrivateStateMetadataResolver interface {
+	// ResolveForManagedParty returns the private state metadata the managed party is a member of, the
+	// returned error wraps ErrUnknownManagedParty if there is none
 	ResolveForManagedParty(managedParty string) (*PrivateStateMetadata, error)
+	// ResolveForManagedPartyContext is like ResolveForManagedParty but gives up once the context is
+	// cancelled or its deadline is exceeded
+	ResolveForManagedPartyContext(ctx context.Context, managedParty string) (*PrivateStateMetadata, error)
+	// ResolveAllForManagedParty returns all the private state metadata the managed party is a member of, the
+	// returned error wraps ErrUnknownManagedParty if there is none
+	ResolveAllForManagedParty(managedParty string) ([]*PrivateStateMetadata, error)
+	// ResolveForUserContext returns the private state metadata of the PSI of the user context, the returned
+	// metadata may be shared and must be treated as read-only. The returned error wraps ErrUnknownPSI if the
+	// PSI is unknown
 	ResolveForUserContext(ctx context.Context) (*PrivateStateMetadata, error)
 	// PSIs returns list of types.PrivateStateIdentifier being managed
 	PSIs() []types.PrivateStateIdentifier
+	// PrivacyGroups returns a copy of the private state metadata being managed keyed by
+	// types.PrivateStateIdentifier, changes to it are not reflected in the resolver
+	PrivacyGroups() map[types.PrivateStateIdentifier]*PrivateStateMetadata
 	// NotIncludeAny returns true if NONE of the managedParties is a member
 	// of the given psm, otherwise returns false
 	NotIncludeAny(psm *PrivateStateMetadata, managedParties ...string) bool
@@ -36,6 +75,9 @@ type PrivateStateMetadataResolver interface {
 // retrieving from and peristing private states to the underlying database
 type PrivateStateRepository interface {
 	StatePSI(psi types.PrivateStateIdentifier) (*state.StateDB, error)
+	// AccountIterator returns an iterator over the accounts committed to the private state identified by
+	// psi, the iteration stops once the context is done
+	AccountIterator(ctx context.Context, psi types.PrivateStateIdentifier) (*state.AccountIterator, error)
 	CommitAndWrite(isEIP158 bool, block *types.Block) error
 	Commit(isEIP158 bool, block *types.Block) error
 	Copy() PrivateStateRepository
@@ -52,6 +52,80 @@ func (mr *MockPrivateStateManagerMockRecorder) CheckAt(blockHash interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckAt", reflect.TypeOf((*MockPrivateStateManager)(nil).CheckAt), blockHash)
 }
 
+// CheckRange mocks base method.
+func (m *MockPrivateStateManager) CheckRange(blockHashes []common.Hash) (map[common.Hash]error, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckRange", blockHashes)
+	ret0, _ := ret[0].(map[common.Hash]error)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CheckRange indicates an expected call of CheckRange.
+func (mr *MockPrivateStateManagerMockRecorder) CheckRange(blockHashes interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckRange", reflect.TypeOf((*MockPrivateStateManager)(nil).CheckRange), blockHashes)
+}
+
+// CheckRootAt mocks base method.
+func (m *MockPrivateStateManager) CheckRootAt(blockHash common.Hash) (common.Hash, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckRootAt", blockHash)
+	ret0, _ := ret[0].(common.Hash)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CheckRootAt indicates an expected call of CheckRootAt.
+func (mr *MockPrivateStateManagerMockRecorder) CheckRootAt(blockHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckRootAt", reflect.TypeOf((*MockPrivateStateManager)(nil).CheckRootAt), blockHash)
+}
+
+// DumpRoots mocks base method.
+func (m *MockPrivateStateManager) DumpRoots(blockHash common.Hash) (map[types.PrivateStateIdentifier]common.Hash, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DumpRoots", blockHash)
+	ret0, _ := ret[0].(map[types.PrivateStateIdentifier]common.Hash)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DumpRoots indicates an expected call of DumpRoots.
+func (mr *MockPrivateStateManagerMockRecorder) DumpRoots(blockHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DumpRoots", reflect.TypeOf((*MockPrivateStateManager)(nil).DumpRoots), blockHash)
+}
+
+// HandleReorg mocks base method.
+func (m *MockPrivateStateManager) HandleReorg(ancestorRoot common.Hash, abandonedRoots, insertedRoots []common.Hash) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HandleReorg", ancestorRoot, abandonedRoots, insertedRoots)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// HandleReorg indicates an expected call of HandleReorg.
+func (mr *MockPrivateStateManagerMockRecorder) HandleReorg(ancestorRoot, abandonedRoots, insertedRoots interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleReorg", reflect.TypeOf((*MockPrivateStateManager)(nil).HandleReorg), ancestorRoot, abandonedRoots, insertedRoots)
+}
+
+// HasStateAt mocks base method.
+func (m *MockPrivateStateManager) HasStateAt(psi types.PrivateStateIdentifier, blockHash common.Hash) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasStateAt", psi, blockHash)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HasStateAt indicates an expected call of HasStateAt.
+func (mr *MockPrivateStateManagerMockRecorder) HasStateAt(psi, blockHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasStateAt", reflect.TypeOf((*MockPrivateStateManager)(nil).HasStateAt), psi, blockHash)
+}
+
 // NotIncludeAny mocks base method.
 func (m *MockPrivateStateManager) NotIncludeAny(psm *PrivateStateMetadata, managedParties ...string) bool {
 	m.ctrl.T.Helper()
@@ -85,6 +159,50 @@ func (mr *MockPrivateStateManagerMockRecorder) PSIs() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PSIs", reflect.TypeOf((*MockPrivateStateManager)(nil).PSIs))
 }
 
+// PrivacyGroups mocks base method.
+func (m *MockPrivateStateManager) PrivacyGroups() map[types.PrivateStateIdentifier]*PrivateStateMetadata {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PrivacyGroups")
+	ret0, _ := ret[0].(map[types.PrivateStateIdentifier]*PrivateStateMetadata)
+	return ret0
+}
+
+// PrivacyGroups indicates an expected call of PrivacyGroups.
+func (mr *MockPrivateStateManagerMockRecorder) PrivacyGroups() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrivacyGroups", reflect.TypeOf((*MockPrivateStateManager)(nil).PrivacyGroups))
+}
+
+// PrivateStateRootAt mocks base method.
+func (m *MockPrivateStateManager) PrivateStateRootAt(blockHash common.Hash) (common.Hash, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PrivateStateRootAt", blockHash)
+	ret0, _ := ret[0].(common.Hash)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PrivateStateRootAt indicates an expected call of PrivateStateRootAt.
+func (mr *MockPrivateStateManagerMockRecorder) PrivateStateRootAt(blockHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrivateStateRootAt", reflect.TypeOf((*MockPrivateStateManager)(nil).PrivateStateRootAt), blockHash)
+}
+
+// ResolveAllForManagedParty mocks base method.
+func (m *MockPrivateStateManager) ResolveAllForManagedParty(managedParty string) ([]*PrivateStateMetadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveAllForManagedParty", managedParty)
+	ret0, _ := ret[0].([]*PrivateStateMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveAllForManagedParty indicates an expected call of ResolveAllForManagedParty.
+func (mr *MockPrivateStateManagerMockRecorder) ResolveAllForManagedParty(managedParty interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveAllForManagedParty", reflect.TypeOf((*MockPrivateStateManager)(nil).ResolveAllForManagedParty), managedParty)
+}
+
 // ResolveForManagedParty mocks base method.
 func (m *MockPrivateStateManager) ResolveForManagedParty(managedParty string) (*PrivateStateMetadata, error) {
 	m.ctrl.T.Helper()
@@ -100,6 +218,21 @@ func (mr *MockPrivateStateManagerMockRecorder) ResolveForManagedParty(managedPar
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveForManagedParty", reflect.TypeOf((*MockPrivateStateManager)(nil).ResolveForManagedParty), managedParty)
 }
 
+// ResolveForManagedPartyContext mocks base method.
+func (m *MockPrivateStateManager) ResolveForManagedPartyContext(ctx context.Context, managedParty string) (*PrivateStateMetadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveForManagedPartyContext", ctx, managedParty)
+	ret0, _ := ret[0].(*PrivateStateMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveForManagedPartyContext indicates an expected call of ResolveForManagedPartyContext.
+func (mr *MockPrivateStateManagerMockRecorder) ResolveForManagedPartyContext(ctx, managedParty interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveForManagedPartyContext", reflect.TypeOf((*MockPrivateStateManager)(nil).ResolveForManagedPartyContext), ctx, managedParty)
+}
+
 // ResolveForUserContext mocks base method.
 func (m *MockPrivateStateManager) ResolveForUserContext(ctx context.Context) (*PrivateStateMetadata, error) {
 	m.ctrl.T.Helper()
@@ -130,6 +263,36 @@ func (mr *MockPrivateStateManagerMockRecorder) StateRepository(blockHash interfa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StateRepository", reflect.TypeOf((*MockPrivateStateManager)(nil).StateRepository), blockHash)
 }
 
+// StateRepositoryContext mocks base method.
+func (m *MockPrivateStateManager) StateRepositoryContext(ctx context.Context, blockHash common.Hash) (PrivateStateRepository, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StateRepositoryContext", ctx, blockHash)
+	ret0, _ := ret[0].(PrivateStateRepository)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StateRepositoryContext indicates an expected call of StateRepositoryContext.
+func (mr *MockPrivateStateManagerMockRecorder) StateRepositoryContext(ctx, blockHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StateRepositoryContext", reflect.TypeOf((*MockPrivateStateManager)(nil).StateRepositoryContext), ctx, blockHash)
+}
+
+// StateRepositoryReadOnly mocks base method.
+func (m *MockPrivateStateManager) StateRepositoryReadOnly(blockHash common.Hash) (PrivateStateRepository, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StateRepositoryReadOnly", blockHash)
+	ret0, _ := ret[0].(PrivateStateRepository)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StateRepositoryReadOnly indicates an expected call of StateRepositoryReadOnly.
+func (mr *MockPrivateStateManagerMockRecorder) StateRepositoryReadOnly(blockHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StateRepositoryReadOnly", reflect.TypeOf((*MockPrivateStateManager)(nil).StateRepositoryReadOnly), blockHash)
+}
+
 // TrieDB mocks base method.
 func (m *MockPrivateStateManager) TrieDB() *trie.Database {
 	m.ctrl.T.Helper()
@@ -200,6 +363,35 @@ func (mr *MockPrivateStateMetadataResolverMockRecorder) PSIs() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PSIs", reflect.TypeOf((*MockPrivateStateMetadataResolver)(nil).PSIs))
 }
 
+// PrivacyGroups mocks base method.
+func (m *MockPrivateStateMetadataResolver) PrivacyGroups() map[types.PrivateStateIdentifier]*PrivateStateMetadata {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PrivacyGroups")
+	ret0, _ := ret[0].(map[types.PrivateStateIdentifier]*PrivateStateMetadata)
+	return ret0
+}
+
+// PrivacyGroups indicates an expected call of PrivacyGroups.
+func (mr *MockPrivateStateMetadataResolverMockRecorder) PrivacyGroups() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrivacyGroups", reflect.TypeOf((*MockPrivateStateMetadataResolver)(nil).PrivacyGroups))
+}
+
+// ResolveAllForManagedParty mocks base method.
+func (m *MockPrivateStateMetadataResolver) ResolveAllForManagedParty(managedParty string) ([]*PrivateStateMetadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveAllForManagedParty", managedParty)
+	ret0, _ := ret[0].([]*PrivateStateMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveAllForManagedParty indicates an expected call of ResolveAllForManagedParty.
+func (mr *MockPrivateStateMetadataResolverMockRecorder) ResolveAllForManagedParty(managedParty interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveAllForManagedParty", reflect.TypeOf((*MockPrivateStateMetadataResolver)(nil).ResolveAllForManagedParty), managedParty)
+}
+
 // ResolveForManagedParty mocks base method.
 func (m *MockPrivateStateMetadataResolver) ResolveForManagedParty(managedParty string) (*PrivateStateMetadata, error) {
 	m.ctrl.T.Helper()
@@ -215,6 +407,21 @@ func (mr *MockPrivateStateMetadataResolverMockRecorder) ResolveForManagedParty(m
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveForManagedParty", reflect.TypeOf((*MockPrivateStateMetadataResolver)(nil).ResolveForManagedParty), managedParty)
 }
 
+// ResolveForManagedPartyContext mocks base method.
+func (m *MockPrivateStateMetadataResolver) ResolveForManagedPartyContext(ctx context.Context, managedParty string) (*PrivateStateMetadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveForManagedPartyContext", ctx, managedParty)
+	ret0, _ := ret[0].(*PrivateStateMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveForManagedPartyContext indicates an expected call of ResolveForManagedPartyContext.
+func (mr *MockPrivateStateMetadataResolverMockRecorder) ResolveForManagedPartyContext(ctx, managedParty interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveForManagedPartyContext", reflect.TypeOf((*MockPrivateStateMetadataResolver)(nil).ResolveForManagedPartyContext), ctx, managedParty)
+}
+
 // ResolveForUserContext mocks base method.
 func (m *MockPrivateStateMetadataResolver) ResolveForUserContext(ctx context.Context) (*PrivateStateMetadata, error) {
 	m.ctrl.T.Helper()
@@ -253,6 +460,21 @@ func (m *MockPrivateStateRepository) EXPECT() *MockPrivateStateRepositoryMockRec
 	return m.recorder
 }
 
+// AccountIterator mocks base method.
+func (m *MockPrivateStateRepository) AccountIterator(ctx context.Context, psi types.PrivateStateIdentifier) (*state.AccountIterator, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AccountIterator", ctx, psi)
+	ret0, _ := ret[0].(*state.AccountIterator)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AccountIterator indicates an expected call of AccountIterator.
+func (mr *MockPrivateStateRepositoryMockRecorder) AccountIterator(ctx, psi interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AccountIterator", reflect.TypeOf((*MockPrivateStateRepository)(nil).AccountIterator), ctx, psi)
+}
+
 // Commit mocks base method.
 func (m *MockPrivateStateRepository) Commit(isEIP158 bool, block *types.Block) error {
 	m.ctrl.T.Helper()
@@ -1,6 +1,7 @@
 package mps
 
 import (
+	"context"
 	"sync"
 
 	"github.com/kisexp/xdchain/common"
@@ -8,6 +9,7 @@ import (
 	"github.com/kisexp/xdchain/core/state"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/ethdb"
+	"github.com/kisexp/xdchain/log"
 )
 
 type StateRootProviderFunc func(isEIP158 bool) (common.Hash, error)
@@ -29,6 +31,19 @@ type MultiplePrivateStateRepository struct {
 	mux sync.Mutex
 	// managed states map
 	managedStates map[types.PrivateStateIdentifier]*managedState
+
+	// writeLock, if set, is held while the private states are written to the database
+	writeLock sync.Locker
+
+	// stateCaches holds the caches of the private states configured with their own trie config, shared
+	// with the copies of the repository
+	stateCaches map[types.PrivateStateIdentifier]state.Database
+	// sharedStateCache is the cache of the other private states, shared with the copies of the repository,
+	// each private state gets a cache of its own if nil
+	sharedStateCache state.Database
+
+	// label is the trace label of the request the repository has been opened for, guarded by mux
+	label string
 }
 
 func NewMultiplePrivateStateRepository(db ethdb.Database, cache state.Database, privateStatesTrieRoot common.Hash) (*MultiplePrivateStateRepository, error) {
@@ -113,7 +128,7 @@ func (mpsr *MultiplePrivateStateRepository) StatePSI(psi types.PrivateStateIdent
 		stateDB = emptyState.Copy()
 		stateCache = ms.stateCache
 	} else {
-		stateCache = state.NewDatabase(mpsr.db)
+		stateCache = mpsr.stateCacheOf(psi)
 		stateDB, err = state.New(common.BytesToHash(privateStateRoot), stateCache, nil)
 		if err != nil {
 			return nil, err
@@ -130,6 +145,16 @@ func (mpsr *MultiplePrivateStateRepository) StatePSI(psi types.PrivateStateIdent
 	return stateDB, nil
 }
 
+// AccountIterator iterates over a copy of the private state, so the iteration isn't affected by
+// the changes made to the state meanwhile
+func (mpsr *MultiplePrivateStateRepository) AccountIterator(ctx context.Context, psi types.PrivateStateIdentifier) (*state.AccountIterator, error) {
+	stateDB, err := mpsr.StatePSI(psi)
+	if err != nil {
+		return nil, err
+	}
+	return stateDB.Copy().NewAccountIterator(ctx, nil), nil
+}
+
 func (mpsr *MultiplePrivateStateRepository) Reset() error {
 	mpsr.mux.Lock()
 	defer mpsr.mux.Unlock()
@@ -155,6 +180,10 @@ func (mpsr *MultiplePrivateStateRepository) Reset() error {
 func (mpsr *MultiplePrivateStateRepository) CommitAndWrite(isEIP158 bool, block *types.Block) error {
 	mpsr.mux.Lock()
 	defer mpsr.mux.Unlock()
+	if mpsr.writeLock != nil {
+		mpsr.writeLock.Lock()
+		defer mpsr.writeLock.Unlock()
+	}
 	// commit each managed state
 	for psi, managedState := range mpsr.managedStates {
 		// calculate and commit state root if required
@@ -178,6 +207,7 @@ func (mpsr *MultiplePrivateStateRepository) CommitAndWrite(isEIP158 bool, block
 	}
 	privateTriedb := mpsr.repoCache.TrieDB()
 	err = privateTriedb.Commit(mtRoot, false, nil)
+	log.Trace("Wrote the private states", "block", block.Root(), "root", mtRoot, "states", len(mpsr.managedStates), "label", mpsr.label, "err", err)
 	return err
 }
 
@@ -213,11 +243,67 @@ func (mpsr *MultiplePrivateStateRepository) Copy() PrivateStateRepository {
 		managedStatesCopy[key] = value.Copy()
 	}
 	return &MultiplePrivateStateRepository{
-		db:            mpsr.db,
-		repoCache:     mpsr.repoCache,
-		trie:          mpsr.repoCache.CopyTrie(mpsr.trie),
-		managedStates: managedStatesCopy,
+		db:               mpsr.db,
+		repoCache:        mpsr.repoCache,
+		trie:             mpsr.repoCache.CopyTrie(mpsr.trie),
+		managedStates:    managedStatesCopy,
+		writeLock:        mpsr.writeLock,
+		stateCaches:      mpsr.stateCaches,
+		sharedStateCache: mpsr.sharedStateCache,
+		label:            mpsr.label,
+	}
+}
+
+// Label returns the trace label of the repository
+func (mpsr *MultiplePrivateStateRepository) Label() string {
+	mpsr.mux.Lock()
+	defer mpsr.mux.Unlock()
+	return mpsr.label
+}
+
+// SetLabel sets the trace label of the repository, which is kept by its copies
+func (mpsr *MultiplePrivateStateRepository) SetLabel(label string) {
+	mpsr.mux.Lock()
+	defer mpsr.mux.Unlock()
+	mpsr.label = label
+}
+
+// SetWriteLock sets the lock to hold while the private states are written to the database,
+// the lock is shared with the copies of the repository.
+func (mpsr *MultiplePrivateStateRepository) SetWriteLock(writeLock sync.Locker) {
+	mpsr.mux.Lock()
+	defer mpsr.mux.Unlock()
+	mpsr.writeLock = writeLock
+}
+
+// SetStateCaches sets the caches to open the given private states with instead of a cache of their own,
+// the caches are shared with the copies of the repository and must not be modified. A private state used
+// for the first time branches from the empty state and shares its cache until it is opened again.
+func (mpsr *MultiplePrivateStateRepository) SetStateCaches(stateCaches map[types.PrivateStateIdentifier]state.Database) {
+	mpsr.mux.Lock()
+	defer mpsr.mux.Unlock()
+	mpsr.stateCaches = stateCaches
+}
+
+// SetSharedStateCache sets the cache to open the private states without cache set by SetStateCaches with,
+// instead of a cache of their own, e.g. so that their nodes are kept in its clean cache across repositories
+func (mpsr *MultiplePrivateStateRepository) SetSharedStateCache(stateCache state.Database) {
+	mpsr.mux.Lock()
+	defer mpsr.mux.Unlock()
+	mpsr.sharedStateCache = stateCache
+}
+
+// stateCacheOf returns the cache to open the private state with
+func (mpsr *MultiplePrivateStateRepository) stateCacheOf(psi types.PrivateStateIdentifier) state.Database {
+	mpsr.mux.Lock()
+	defer mpsr.mux.Unlock()
+	if stateCache, ok := mpsr.stateCaches[psi]; ok {
+		return stateCache
+	}
+	if mpsr.sharedStateCache != nil {
+		return mpsr.sharedStateCache
 	}
+	return state.NewDatabase(mpsr.db)
 }
 
 // Given a slice of public receipts and an overlapping (smaller) slice of
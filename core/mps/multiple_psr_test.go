@@ -1,6 +1,7 @@
 package mps
 
 import (
+	"context"
 	"math/big"
 	"sync"
 	"testing"
@@ -428,3 +429,31 @@ func TestMultiplePSRRemovalFromPrivateState(t *testing.T) {
 	assert.False(t, testState1.Exist(removedAddress))
 	assert.True(t, emptyState.Exist(removedAddress))
 }
+
+//TestMultiplePSRAccountIterator tests that only the accounts of the given private state are iterated
+func TestMultiplePSRAccountIterator(t *testing.T) {
+	testdb := rawdb.NewMemoryDatabase()
+	testCache := state.NewDatabase(testdb)
+	psr, _ := NewMultiplePrivateStateRepository(testdb, testCache, common.Hash{})
+	header := &types.Header{Number: big.NewInt(int64(1)), Root: common.Hash{123}}
+	block := types.NewBlockWithHeader(header)
+
+	testState, _ := psr.StatePSI(types.PrivateStateIdentifier("test"))
+	privState, _ := psr.StatePSI(types.DefaultPrivateStateIdentifier)
+	testAddr, privAddr := common.BytesToAddress([]byte{1}), common.BytesToAddress([]byte{2})
+	testState.AddBalance(testAddr, big.NewInt(1))
+	privState.AddBalance(privAddr, big.NewInt(2))
+	assert.NoError(t, psr.CommitAndWrite(false, block))
+
+	it, err := psr.AccountIterator(context.Background(), types.PrivateStateIdentifier("test"))
+	assert.NoError(t, err)
+
+	var addresses []common.Address
+	for it.Next() {
+		addr, ok := it.Address()
+		assert.True(t, ok)
+		addresses = append(addresses, addr)
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, []common.Address{testAddr}, addresses)
+}
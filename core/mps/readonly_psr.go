@@ -0,0 +1,51 @@
+package mps
+
+import (
+	"errors"
+
+	"github.com/kisexp/xdchain/core/types"
+)
+
+// ErrReadOnlyRepository is returned by the write methods of a repository returned by NewReadOnlyRepository
+var ErrReadOnlyRepository = errors.New("private state repository is read-only")
+
+// readOnlyRepository disables the write methods of the PrivateStateRepository it wraps
+type readOnlyRepository struct {
+	PrivateStateRepository
+}
+
+// NewReadOnlyRepository wraps the repository so that its private states can't be written to the database.
+//
+// The state DBs it returns can still be modified in memory, e.g. to run calls, but the changes are never
+// committed: CommitAndWrite and Commit fail with ErrReadOnlyRepository.
+func NewReadOnlyRepository(repo PrivateStateRepository) PrivateStateRepository {
+	if _, ok := repo.(*readOnlyRepository); ok {
+		return repo
+	}
+	return &readOnlyRepository{PrivateStateRepository: repo}
+}
+
+func (r *readOnlyRepository) CommitAndWrite(isEIP158 bool, block *types.Block) error {
+	return ErrReadOnlyRepository
+}
+
+func (r *readOnlyRepository) Commit(isEIP158 bool, block *types.Block) error {
+	return ErrReadOnlyRepository
+}
+
+// Copy returns a read-only copy of the repository
+func (r *readOnlyRepository) Copy() PrivateStateRepository {
+	return &readOnlyRepository{PrivateStateRepository: r.PrivateStateRepository.Copy()}
+}
+
+// Label returns the trace label of the wrapped repository
+func (r *readOnlyRepository) Label() string {
+	return RepositoryLabel(r.PrivateStateRepository)
+}
+
+// SetLabel sets the trace label of the wrapped repository, if it can carry one
+func (r *readOnlyRepository) SetLabel(label string) {
+	if labeled, ok := r.PrivateStateRepository.(LabeledRepository); ok {
+		labeled.SetLabel(label)
+	}
+}
@@ -0,0 +1,45 @@
+package mps
+
+import "context"
+
+// traceLabelKey is the context key of the trace label
+type traceLabelKey struct{}
+
+// WithTraceLabel returns a copy of the context carrying the label, e.g. the RPC method and request ID,
+// which the private state managers attach to the repositories opened with the context so that the
+// operations on their private states can be attributed to the request
+func WithTraceLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, traceLabelKey{}, label)
+}
+
+// TraceLabelFromContext returns the label of the context set by WithTraceLabel, the empty string if none
+func TraceLabelFromContext(ctx context.Context) string {
+	label, _ := ctx.Value(traceLabelKey{}).(string)
+	return label
+}
+
+// LabeledRepository is implemented by the repositories which can carry a trace label, the label is
+// added to the logs of the repository and is kept by its copies
+type LabeledRepository interface {
+	Label() string
+	SetLabel(label string)
+}
+
+// RepositoryLabel returns the trace label of the repository, the empty string if it has none
+func RepositoryLabel(repo PrivateStateRepository) string {
+	if labeled, ok := repo.(LabeledRepository); ok {
+		return labeled.Label()
+	}
+	return ""
+}
+
+// LabelRepository sets the trace label of the context, if any, to the repository opened with the context
+// and returns the repository
+func LabelRepository(ctx context.Context, repo PrivateStateRepository) PrivateStateRepository {
+	if label := TraceLabelFromContext(ctx); label != "" {
+		if labeled, ok := repo.(LabeledRepository); ok {
+			labeled.SetLabel(label)
+		}
+	}
+	return repo
+}
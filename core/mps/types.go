@@ -1,6 +1,8 @@
 package mps
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/kisexp/xdchain/core/types"
@@ -25,8 +27,41 @@ var (
 		Resident,
 		nil,
 	)
+
+	// ErrUnknownManagedParty is returned when no private state can be resolved for a managed party
+	ErrUnknownManagedParty = errors.New("unable to find private state metadata for managed party")
+	// ErrUnknownPSI is returned when no private state can be resolved for a PSI
+	ErrUnknownPSI = errors.New("unable to find private state for context psi")
+	// ErrNoPrivateStateRoot is returned when no private state root is stored for a block
+	ErrNoPrivateStateRoot = errors.New("no private state root stored")
+	// ErrNoPrivateStates is returned when the private states trie of the root stored for a block can't be opened
+	ErrNoPrivateStates = errors.New("no private states at this block")
+	// ErrConflictingPrivateStateMetadata is returned when different metadata are given for the same PSI
+	ErrConflictingPrivateStateMetadata = errors.New("conflicting private state metadata")
+	// ErrUnknownPrivacyGroupName is returned when no private state can be resolved for a privacy group name
+	ErrUnknownPrivacyGroupName = errors.New("unable to find private state for privacy group name")
+	// ErrAmbiguousPrivacyGroupName is returned when several private states have the privacy group name
+	ErrAmbiguousPrivacyGroupName = errors.New("ambiguous privacy group name")
+	// ErrDeactivatedPSI is returned when a private state which has been deactivated is resolved to be
+	// written to, e.g. to send a new transaction
+	ErrDeactivatedPSI = errors.New("private state is deactivated")
 )
 
+// writeIntentKey is the context key of the write intent
+type writeIntentKey struct{}
+
+// WithWriteIntent returns a copy of the context telling the resolvers that the private state is resolved
+// to be written to, e.g. to send a new transaction. The deactivated private states are then rejected.
+func WithWriteIntent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writeIntentKey{}, true)
+}
+
+// HasWriteIntent checks if the private state is resolved to be written to, see WithWriteIntent
+func HasWriteIntent(ctx context.Context) bool {
+	writeIntent, _ := ctx.Value(writeIntentKey{}).(bool)
+	return writeIntent
+}
+
 type PrivateStateType uint64
 
 const (
@@ -2,14 +2,19 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/core/mps"
 	"github.com/kisexp/xdchain/core/rawdb"
 	"github.com/kisexp/xdchain/core/state"
 	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/crypto"
 	"github.com/kisexp/xdchain/ethdb"
+	"github.com/kisexp/xdchain/log"
 	"github.com/kisexp/xdchain/rpc"
 	"github.com/kisexp/xdchain/trie"
 )
@@ -19,61 +24,648 @@ type MultiplePrivateStateManager struct {
 	db                     ethdb.Database
 	privateStatesTrieCache state.Database
 
-	residentGroupByKey map[string]*mps.PrivateStateMetadata
+	// residentGroupByKey maps a managed party to all the resident groups it is a member of
+	residentGroupByKey map[string][]*mps.PrivateStateMetadata
 	privacyGroupById   map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata
+	// psisByName indexes the PSIs of privacyGroupById by the name of their privacy group
+	psisByName map[string][]types.PrivateStateIdentifier
+	// metadataMu guards the replacement of the metadata maps by ReloadMetadata, the maps themselves
+	// are never modified
+	metadataMu sync.RWMutex
+	// dynamicResolver is delegated the resolutions of the private states if set, guarded by metadataMu
+	dynamicResolver mps.DynamicResolver
+
+	// psiTrieCaches holds the trie caches of the private states configured with their own trie.Config,
+	// the map is never modified
+	psiTrieCaches map[types.PrivateStateIdentifier]state.Database
+
+	// pruneMu prevents reading and writing the private states while they are pruned
+	pruneMu sync.RWMutex
+
+	// openLimiter bounds the number of repositories opened concurrently
+	openLimiter stateRepositoryOpenLimiter
+
+	// trieCacheCeiling bounds the memory held by the dirty nodes of each trie cache, 0 for no ceiling
+	trieCacheCeiling common.StorageSize
 }
 
-func newMultiplePrivateStateManager(db ethdb.Database, config *trie.Config, residentGroupByKey map[string]*mps.PrivateStateMetadata, privacyGroupById map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) (*MultiplePrivateStateManager, error) {
+// NewMultiplePrivateStateManager returns the manager of the private states of the resident groups of
+// a node with multiple private states. The private states are read from and written to db, through a
+// trie cache configured by config, which may be nil to use the default trie settings.
+//
+// residentGroupByKey maps each managed party to the resident groups it is a member of, in the order
+// returned by the transaction manager, and privacyGroupById maps each private state identifier to its
+// privacy group. The maps are owned by the manager from then on, ReloadMetadata replaces them.
+func NewMultiplePrivateStateManager(db ethdb.Database, config *trie.Config, residentGroupByKey map[string][]*mps.PrivateStateMetadata, privacyGroupById map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) (*MultiplePrivateStateManager, error) {
+	return newMultiplePrivateStateManagerWithCache(db, state.NewDatabaseWithConfig(db, config), residentGroupByKey, privacyGroupById)
+}
+
+// NewMultiplePrivateStateManagerWithTrieConfigs is like NewMultiplePrivateStateManager but the private states
+// of the PSIs of psiConfigs are read through a trie cache of their own, configured by their trie.Config,
+// e.g. to give the large privacy groups a larger clean cache. The other private states are read as without
+// override.
+//
+// Each override keeps its clean cache, up to the Cache megabytes of its trie.Config, for the lifetime of the
+// manager, on top of the cache shared by the private states trie and the private states without override.
+// The overrides should then be kept for the few privacy groups which are read the most.
+func NewMultiplePrivateStateManagerWithTrieConfigs(db ethdb.Database, config *trie.Config, psiConfigs map[types.PrivateStateIdentifier]*trie.Config, residentGroupByKey map[string][]*mps.PrivateStateMetadata, privacyGroupById map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) (*MultiplePrivateStateManager, error) {
+	mpsm, err := NewMultiplePrivateStateManager(db, config, residentGroupByKey, privacyGroupById)
+	if err != nil {
+		return nil, err
+	}
+	if len(psiConfigs) > 0 {
+		mpsm.psiTrieCaches = make(map[types.PrivateStateIdentifier]state.Database, len(psiConfigs))
+		for psi, psiConfig := range psiConfigs {
+			mpsm.psiTrieCaches[psi] = state.NewDatabaseWithConfig(db, psiConfig)
+		}
+	}
+	return mpsm, nil
+}
+
+func newMultiplePrivateStateManager(db ethdb.Database, config *trie.Config, residentGroupByKey map[string][]*mps.PrivateStateMetadata, privacyGroupById map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) (*MultiplePrivateStateManager, error) {
+	return NewMultiplePrivateStateManager(db, config, residentGroupByKey, privacyGroupById)
+}
+
+// newMultiplePrivateStateManagerWithCache is like newMultiplePrivateStateManager but reuses the given
+// trie cache, which must be backed by db, instead of creating its own. The cache is shared with its
+// other users: Prune resets its clean cache.
+func newMultiplePrivateStateManagerWithCache(db ethdb.Database, trieCache state.Database, residentGroupByKey map[string][]*mps.PrivateStateMetadata, privacyGroupById map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) (*MultiplePrivateStateManager, error) {
+	if trieCache == nil {
+		return nil, errors.New("missing trie cache of the private states")
+	}
+	if err := validateMetadata(residentGroupByKey, privacyGroupById); err != nil {
+		return nil, err
+	}
 	return &MultiplePrivateStateManager{
 		db:                     db,
-		privateStatesTrieCache: state.NewDatabaseWithConfig(db, config),
+		privateStatesTrieCache: trieCache,
 		residentGroupByKey:     residentGroupByKey,
 		privacyGroupById:       privacyGroupById,
+		psisByName:             indexPSIsByName(privacyGroupById),
 	}, nil
 }
 
 func (m *MultiplePrivateStateManager) StateRepository(blockHash common.Hash) (mps.PrivateStateRepository, error) {
-	privateStatesTrieRoot := rawdb.GetPrivateStatesTrieRoot(m.db, blockHash)
-	return mps.NewMultiplePrivateStateRepository(m.db, m.privateStatesTrieCache, privateStatesTrieRoot)
+	return m.StateRepositoryContext(context.Background(), blockHash)
+}
+
+func (m *MultiplePrivateStateManager) StateRepositoryContext(ctx context.Context, blockHash common.Hash) (mps.PrivateStateRepository, error) {
+	return m.openLimiter.open(ctx, func() (mps.PrivateStateRepository, error) {
+		m.pruneMu.RLock()
+		defer m.pruneMu.RUnlock()
+		m.capTrieCaches()
+		privateStatesTrieRoot := rawdb.GetPrivateStatesTrieRoot(m.db, blockHash)
+		if privateStatesTrieRoot == types.EmptyRootHash {
+			privateStatesTrieRoot = common.Hash{}
+		}
+		// no root is stored for the genesis block and the blocks before the upgrade to MPS, the private
+		// states then start empty
+		if common.EmptyHash(privateStatesTrieRoot) {
+			log.Trace("No private states trie root stored, using empty private states", "block", blockHash)
+		}
+		repo, err := mps.NewMultiplePrivateStateRepository(m.db, m.privateStatesTrieCache, privateStatesTrieRoot)
+		if err != nil {
+			return nil, fmt.Errorf("%w: block %x, private states trie root %x: %v", mps.ErrNoPrivateStates, blockHash, privateStatesTrieRoot, err)
+		}
+		// writing private states is blocked while pruning
+		repo.SetWriteLock(m.pruneMu.RLocker())
+		repo.SetStateCaches(m.psiTrieCaches)
+		repo.SetSharedStateCache(m.privateStatesTrieCache)
+		return mps.LabelRepository(ctx, repo), nil
+	})
 }
 
+// StateRepositoryReadOnly returns the repository of the block hash with its write methods disabled
+func (m *MultiplePrivateStateManager) StateRepositoryReadOnly(blockHash common.Hash) (mps.PrivateStateRepository, error) {
+	repo, err := m.StateRepository(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	return mps.NewReadOnlyRepository(repo), nil
+}
+
+// capTrieCaches flushes to disk the dirty nodes of the trie caches, the one of the private states trie and the
+// overrides, whose size reaches 90% of trieCacheCeiling, down to half of it. The private states are then read
+// from disk rather than growing the caches unbounded under memory pressure. The caller must hold pruneMu.
+func (m *MultiplePrivateStateManager) capTrieCaches() {
+	if m.trieCacheCeiling == 0 {
+		return
+	}
+	capTrieCache(m.privateStatesTrieCache.TrieDB(), m.trieCacheCeiling, "private states trie")
+	for psi, trieCache := range m.psiTrieCaches {
+		capTrieCache(trieCache.TrieDB(), m.trieCacheCeiling, string(psi))
+	}
+}
+
+func capTrieCache(triedb *trie.Database, ceiling common.StorageSize, name string) {
+	nodes, preimages := triedb.Size()
+	if size := nodes + preimages; size >= ceiling*9/10 {
+		if err := triedb.Cap(ceiling / 2); err != nil {
+			log.Error("Failed to flush the private state trie cache", "cache", name, "size", size, "ceiling", ceiling, "err", err)
+			return
+		}
+		flushed, _ := triedb.Size()
+		log.Info("Flushed the private state trie cache approaching its ceiling", "cache", name, "size", size, "ceiling", ceiling, "remaining", flushed)
+	}
+}
+
+// ResolveForManagedParty returns the resident group the managed party is a member of.
+//
+// If the managed party is a member of multiple resident groups, the first group
+// in the order returned by the transaction manager is selected. Use
+// ResolveAllForManagedParty to retrieve all of them.
 func (m *MultiplePrivateStateManager) ResolveForManagedParty(managedParty string) (*mps.PrivateStateMetadata, error) {
-	psm, found := m.residentGroupByKey[managedParty]
-	if !found {
-		return nil, fmt.Errorf("unable to find private state metadata for managed party %s", managedParty)
+	return m.ResolveForManagedPartyContext(context.Background(), managedParty)
+}
+
+// ResolveForManagedPartyContext is like ResolveForManagedParty but gives up once the context is cancelled
+// or its deadline is exceeded, the context error is then returned
+func (m *MultiplePrivateStateManager) ResolveForManagedPartyContext(ctx context.Context, managedParty string) (*mps.PrivateStateMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	psm, err := m.resolveDynamically(func(resolver mps.DynamicResolver) (*mps.PrivateStateMetadata, error) {
+		return resolver.ResolveForManagedParty(ctx, managedParty)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if psm == nil {
+		psms, err := m.ResolveAllForManagedParty(managedParty)
+		if err != nil {
+			return nil, err
+		}
+		psm = psms[0]
+	}
+	if mps.HasWriteIntent(ctx) && m.IsDeactivated(psm.ID) {
+		return nil, fmt.Errorf("%w %s", mps.ErrDeactivatedPSI, psm.ID)
 	}
 	return psm, nil
 }
 
+// ResolveAllForManagedParty returns all the resident groups the managed party is a member of,
+// in the order returned by the transaction manager
+func (m *MultiplePrivateStateManager) ResolveAllForManagedParty(managedParty string) ([]*mps.PrivateStateMetadata, error) {
+	residentGroupByKey, _ := m.metadata()
+	psms, found := residentGroupByKey[managedParty]
+	if !found || len(psms) == 0 {
+		return nil, fmt.Errorf("%w %s", mps.ErrUnknownManagedParty, managedParty)
+	}
+	return psms, nil
+}
+
+// MissingManagedParties returns the managed parties of expected which aren't a member of any resident
+// group, in the order of expected, e.g. to check that new participants are known before going live
+func (m *MultiplePrivateStateManager) MissingManagedParties(expected []string) []string {
+	residentGroupByKey, _ := m.metadata()
+	var missing []string
+	for _, managedParty := range expected {
+		if psms := residentGroupByKey[managedParty]; len(psms) == 0 {
+			missing = append(missing, managedParty)
+		}
+	}
+	return missing
+}
+
 func (m *MultiplePrivateStateManager) ResolveForUserContext(ctx context.Context) (*mps.PrivateStateMetadata, error) {
-	psi, ok := rpc.PrivateStateIdentifierFromContext(ctx)
-	if !ok {
-		psi = types.DefaultPrivateStateIdentifier
+	psm, err := m.resolveDynamically(func(resolver mps.DynamicResolver) (*mps.PrivateStateMetadata, error) {
+		return resolver.ResolveForUserContext(ctx)
+	})
+	if err != nil {
+		return nil, err
 	}
-	psm, found := m.privacyGroupById[psi]
-	if !found {
-		return nil, fmt.Errorf("unable to find private state for context psi %s", psi)
+	if psm == nil {
+		psi, ok := rpc.PrivateStateIdentifierFromContext(ctx)
+		if !ok {
+			psi = types.DefaultPrivateStateIdentifier
+		}
+		_, privacyGroupById := m.metadata()
+		var found bool
+		if psm, found = privacyGroupById[psi]; !found {
+			return nil, fmt.Errorf("%w %s", mps.ErrUnknownPSI, psi)
+		}
+	}
+	if mps.HasWriteIntent(ctx) && m.IsDeactivated(psm.ID) {
+		return nil, fmt.Errorf("%w %s", mps.ErrDeactivatedPSI, psm.ID)
 	}
 	return psm, nil
 }
 
+// SetDynamicResolver delegates the resolutions of ResolveForManagedPartyContext and ResolveForUserContext
+// to the resolver, they fall back to the static metadata if it resolves no private state. A nil resolver
+// restores the static resolutions.
+func (m *MultiplePrivateStateManager) SetDynamicResolver(resolver mps.DynamicResolver) {
+	m.metadataMu.Lock()
+	defer m.metadataMu.Unlock()
+	m.dynamicResolver = resolver
+}
+
+// resolveDynamically resolves a private state with the dynamic resolver, it returns no private state if
+// there is no dynamic resolver
+func (m *MultiplePrivateStateManager) resolveDynamically(resolve func(mps.DynamicResolver) (*mps.PrivateStateMetadata, error)) (*mps.PrivateStateMetadata, error) {
+	m.metadataMu.RLock()
+	resolver := m.dynamicResolver
+	m.metadataMu.RUnlock()
+	if resolver == nil {
+		return nil, nil
+	}
+	return resolve(resolver)
+}
+
 func (m *MultiplePrivateStateManager) PSIs() []types.PrivateStateIdentifier {
-	psis := make([]types.PrivateStateIdentifier, 0, len(m.privacyGroupById))
-	for psi := range m.privacyGroupById {
+	_, privacyGroupById := m.metadata()
+	psis := make([]types.PrivateStateIdentifier, 0, len(privacyGroupById))
+	for psi := range privacyGroupById {
 		psis = append(psis, psi)
 	}
 	return psis
 }
 
+// DeactivatePSI marks the private state identified by psi as deactivated, e.g. once its privacy group is
+// decommissioned. The deactivation is persisted. The private state can still be read, but it's rejected
+// with mps.ErrDeactivatedPSI when resolved with the write intent of mps.WithWriteIntent, so that no new
+// transaction is sent for it. The transactions already sent are still applied to it.
+//
+// The returned error wraps mps.ErrUnknownPSI if no privacy group is known for psi.
+func (m *MultiplePrivateStateManager) DeactivatePSI(psi types.PrivateStateIdentifier) error {
+	_, privacyGroupById := m.metadata()
+	if _, found := privacyGroupById[psi]; !found {
+		return fmt.Errorf("%w %s", mps.ErrUnknownPSI, psi)
+	}
+	return rawdb.WritePSIDeactivated(m.db, psi)
+}
+
+// IsDeactivated checks if the private state identified by psi has been deactivated by DeactivatePSI
+func (m *MultiplePrivateStateManager) IsDeactivated(psi types.PrivateStateIdentifier) bool {
+	return rawdb.IsPSIDeactivated(m.db, psi)
+}
+
+// IsResident reports whether the private state identified by psi is the one of a resident group of the
+// node, rather than of a legacy or pantheon privacy group. The returned error wraps mps.ErrUnknownPSI if
+// no privacy group is known for psi.
+func (m *MultiplePrivateStateManager) IsResident(psi types.PrivateStateIdentifier) (bool, error) {
+	_, privacyGroupById := m.metadata()
+	psm, found := privacyGroupById[psi]
+	if !found {
+		return false, fmt.Errorf("%w %s", mps.ErrUnknownPSI, psi)
+	}
+	return psm.Type == mps.Resident, nil
+}
+
+// ResolveByName returns the privacy group with the given name. The returned error wraps
+// mps.ErrUnknownPrivacyGroupName if no privacy group has the name, and mps.ErrAmbiguousPrivacyGroupName
+// if several of them have it, the PSI is then needed to tell them apart.
+func (m *MultiplePrivateStateManager) ResolveByName(name string) (*mps.PrivateStateMetadata, error) {
+	m.metadataMu.RLock()
+	privacyGroupById, psis := m.privacyGroupById, m.psisByName[name]
+	m.metadataMu.RUnlock()
+	switch len(psis) {
+	case 0:
+		return nil, fmt.Errorf("%w %q", mps.ErrUnknownPrivacyGroupName, name)
+	case 1:
+		return privacyGroupById[psis[0]], nil
+	default:
+		return nil, fmt.Errorf("%w %q, shared by the private states %v", mps.ErrAmbiguousPrivacyGroupName, name, psis)
+	}
+}
+
+func (m *MultiplePrivateStateManager) PrivacyGroups() map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata {
+	_, privacyGroupById := m.metadata()
+	groups := make(map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata, len(privacyGroupById))
+	for psi, psm := range privacyGroupById {
+		groups[psi] = copyPrivateStateMetadata(psm)
+	}
+	return groups
+}
+
+// ReloadMetadata replaces the resident groups and privacy groups the managed parties and private state
+// identifiers are resolved against, e.g. once the privacy group membership changed in the transaction
+// manager. The maps are owned by the manager from then on and must not be modified by the caller. Readers
+// see either the previous or the new metadata, never a mix of both.
+//
+// The metadata are left unchanged if the new ones are inconsistent, the returned error then wraps
+// mps.ErrConflictingPrivateStateMetadata.
+func (m *MultiplePrivateStateManager) ReloadMetadata(residentGroupByKey map[string][]*mps.PrivateStateMetadata, privacyGroupById map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) error {
+	if err := validateMetadata(residentGroupByKey, privacyGroupById); err != nil {
+		return err
+	}
+	m.metadataMu.Lock()
+	defer m.metadataMu.Unlock()
+	m.residentGroupByKey = residentGroupByKey
+	m.privacyGroupById = privacyGroupById
+	m.psisByName = indexPSIsByName(privacyGroupById)
+	return nil
+}
+
+// indexPSIsByName returns the PSIs of the privacy groups by the name of their group, in increasing order
+func indexPSIsByName(privacyGroupById map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) map[string][]types.PrivateStateIdentifier {
+	psisByName := make(map[string][]types.PrivateStateIdentifier, len(privacyGroupById))
+	for psi, psm := range privacyGroupById {
+		psisByName[psm.Name] = append(psisByName[psm.Name], psi)
+	}
+	for _, psis := range psisByName {
+		sort.Slice(psis, func(i, j int) bool { return psis[i] < psis[j] })
+	}
+	return psisByName
+}
+
+// validateMetadata checks that the privacy groups are keyed by their PSI and that the resident groups
+// and the privacy groups give the same metadata for each PSI. The returned error wraps
+// mps.ErrConflictingPrivateStateMetadata and names the conflicting entries.
+func validateMetadata(residentGroupByKey map[string][]*mps.PrivateStateMetadata, privacyGroupById map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) error {
+	known := make(map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata, len(privacyGroupById))
+	sources := make(map[types.PrivateStateIdentifier]string, len(privacyGroupById))
+	for psi, psm := range privacyGroupById {
+		if psm.ID != psi {
+			return fmt.Errorf("%w: privacy group %s has psi %s", mps.ErrConflictingPrivateStateMetadata, psi, psm.ID)
+		}
+		known[psi], sources[psi] = psm, fmt.Sprintf("privacy group %s", psi)
+	}
+	// the keys are sorted so that the same conflict is reported whatever the iteration order
+	keys := make([]string, 0, len(residentGroupByKey))
+	for key := range residentGroupByKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, psm := range residentGroupByKey[key] {
+			existing, ok := known[psm.ID]
+			if !ok {
+				known[psm.ID], sources[psm.ID] = psm, fmt.Sprintf("resident group of %s", key)
+				continue
+			}
+			if !sameMetadata(existing, psm) {
+				return fmt.Errorf("%w for psi %s: %s has name=%s type=%d, resident group of %s has name=%s type=%d",
+					mps.ErrConflictingPrivateStateMetadata, psm.ID, sources[psm.ID], existing.Name, existing.Type, key, psm.Name, psm.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// sameMetadata checks if the metadata describe the same private state, with the same members in the same order
+func sameMetadata(psm, other *mps.PrivateStateMetadata) bool {
+	if psm == other {
+		return true
+	}
+	if psm.ID != other.ID || psm.Name != other.Name || psm.Description != other.Description || psm.Type != other.Type || len(psm.Addresses) != len(other.Addresses) {
+		return false
+	}
+	for i := range psm.Addresses {
+		if psm.Addresses[i] != other.Addresses[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// metadata returns the current resident groups and privacy groups
+func (m *MultiplePrivateStateManager) metadata() (map[string][]*mps.PrivateStateMetadata, map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) {
+	m.metadataMu.RLock()
+	defer m.metadataMu.RUnlock()
+	return m.residentGroupByKey, m.privacyGroupById
+}
+
 func (m *MultiplePrivateStateManager) NotIncludeAny(psm *mps.PrivateStateMetadata, managedParties ...string) bool {
 	return psm.NotIncludeAny(managedParties...)
 }
 
 func (m *MultiplePrivateStateManager) CheckAt(root common.Hash) error {
-	_, err := state.New(rawdb.GetPrivateStatesTrieRoot(m.db, root), m.privateStatesTrieCache, nil)
+	_, err := m.CheckRootAt(root)
+	return err
+}
+
+// CheckRootAt checks the private states trie of the block hash, it returns the root of the trie
+func (m *MultiplePrivateStateManager) CheckRootAt(root common.Hash) (common.Hash, error) {
+	m.pruneMu.RLock()
+	defer m.pruneMu.RUnlock()
+	privateStatesTrieRoot := rawdb.GetPrivateStatesTrieRoot(m.db, root)
+	_, err := state.New(privateStatesTrieRoot, m.privateStatesTrieCache, nil)
+	return privateStatesTrieRoot, err
+}
+
+// CheckRange checks the private states trie of each block hash with the same state database
+func (m *MultiplePrivateStateManager) CheckRange(roots []common.Hash) (map[common.Hash]error, error) {
+	m.pruneMu.RLock()
+	defer m.pruneMu.RUnlock()
+	return checkRange(m.privateStatesTrieCache, roots, func(root common.Hash) common.Hash {
+		return rawdb.GetPrivateStatesTrieRoot(m.db, root)
+	})
+}
+
+// HasStateAt checks if the private states trie at the block hash holds a root for the psi
+func (m *MultiplePrivateStateManager) HasStateAt(psi types.PrivateStateIdentifier, blockHash common.Hash) (bool, error) {
+	m.pruneMu.RLock()
+	defer m.pruneMu.RUnlock()
+	privateStatesTrieRoot := rawdb.GetPrivateStatesTrieRoot(m.db, blockHash)
+	if common.EmptyHash(privateStatesTrieRoot) {
+		return false, nil
+	}
+	tr, err := m.privateStatesTrieCache.OpenTrie(privateStatesTrieRoot)
+	if err != nil {
+		return false, err
+	}
+	privateStateRoot, err := tr.TryGet([]byte(psi))
+	if err != nil {
+		return false, err
+	}
+	return len(privateStateRoot) > 0, nil
+}
+
+// PrivateStateRootAt returns the root of the private states trie stored for the block hash
+func (m *MultiplePrivateStateManager) PrivateStateRootAt(blockHash common.Hash) (common.Hash, error) {
+	m.pruneMu.RLock()
+	defer m.pruneMu.RUnlock()
+	root := rawdb.GetPrivateStatesTrieRoot(m.db, blockHash)
+	if common.EmptyHash(root) {
+		return common.Hash{}, fmt.Errorf("%w for block %x", mps.ErrNoPrivateStateRoot, blockHash)
+	}
+	return root, nil
+}
+
+// Checkpoint writes to disk the nodes of the private states trie stored for the block hash which are only
+// held in memory, along with the private states it refers to, and returns its root. GetPrivateStatesTrieRoot
+// returns the root for the block hash, the private states can then be read from disk alone, e.g. by a backup
+// of the database. The returned error wraps mps.ErrNoPrivateStateRoot if no root is stored for the block.
+func (m *MultiplePrivateStateManager) Checkpoint(blockHash common.Hash) (common.Hash, error) {
+	m.pruneMu.RLock()
+	defer m.pruneMu.RUnlock()
+	root := rawdb.GetPrivateStatesTrieRoot(m.db, blockHash)
+	if common.EmptyHash(root) {
+		return common.Hash{}, fmt.Errorf("%w for block %x", mps.ErrNoPrivateStateRoot, blockHash)
+	}
+	if err := m.privateStatesTrieCache.TrieDB().Commit(root, false, nil); err != nil {
+		return common.Hash{}, err
+	}
+	return root, nil
+}
+
+// stateCacheOf returns the trie cache the private state of the psi is read through, its override if any
+func (m *MultiplePrivateStateManager) stateCacheOf(psi types.PrivateStateIdentifier) state.Database {
+	if trieCache, ok := m.psiTrieCaches[psi]; ok {
+		return trieCache
+	}
+	return m.privateStatesTrieCache
+}
+
+// HandleReorg drops the private states cached in memory only for the blocks abandoned by a reorg, given the
+// block roots of the common ancestor of the old and new chains, of the abandoned blocks and of the blocks
+// inserted instead: the private states tries of the abandoned blocks and the private states read through
+// their own trie cache. The ones shared with the ancestor or the inserted blocks are kept, as are the ones
+// written to disk. The returned error wraps mps.ErrNoPrivateStateRoot if no root is stored for the ancestor,
+// or is the error opening its private states.
+func (m *MultiplePrivateStateManager) HandleReorg(ancestorRoot common.Hash, abandonedRoots, insertedRoots []common.Hash) error {
+	m.pruneMu.Lock()
+	defer m.pruneMu.Unlock()
+	root := rawdb.GetPrivateStatesTrieRoot(m.db, ancestorRoot)
+	if common.EmptyHash(root) {
+		return fmt.Errorf("%w for block %x", mps.ErrNoPrivateStateRoot, ancestorRoot)
+	}
+	kept := m.cachedRootsAt(append([]common.Hash{ancestorRoot}, insertedRoots...))
+	dropped := 0
+	for trieCache, abandoned := range m.cachedRootsAt(abandonedRoots) {
+		dropped += discardAbandonedRoots(trieCache, abandoned, kept[trieCache])
+	}
+	log.Debug("Dropped cached private states on reorg", "ancestor", ancestorRoot, "abandoned", len(abandonedRoots), "nodes", dropped)
+
+	_, err := m.privateStatesTrieCache.OpenTrie(root)
 	return err
 }
 
+// cachedRootsAt returns the roots stored for the block roots in the trie cache they are read through: the
+// roots of the private states tries and of the private states. The blocks whose private states trie can't
+// be read only contribute its root. The caller must hold pruneMu.
+func (m *MultiplePrivateStateManager) cachedRootsAt(blockRoots []common.Hash) map[state.Database]map[common.Hash]bool {
+	roots := map[state.Database]map[common.Hash]bool{m.privateStatesTrieCache: {}}
+	// the private states trie is keyed by the hash of the psi
+	overrides := make(map[string]state.Database, len(m.psiTrieCaches))
+	for psi, trieCache := range m.psiTrieCaches {
+		roots[trieCache] = make(map[common.Hash]bool)
+		overrides[string(crypto.Keccak256([]byte(psi)))] = trieCache
+	}
+	for _, blockRoot := range blockRoots {
+		privateStatesTrieRoot := rawdb.GetPrivateStatesTrieRoot(m.db, blockRoot)
+		if common.EmptyHash(privateStatesTrieRoot) {
+			continue
+		}
+		roots[m.privateStatesTrieCache][privateStatesTrieRoot] = true
+		tr, err := m.privateStatesTrieCache.OpenTrie(privateStatesTrieRoot)
+		if err != nil {
+			continue
+		}
+		it := trie.NewIterator(tr.NodeIterator(nil))
+		for it.Next() {
+			trieCache, ok := overrides[string(it.Key)]
+			if !ok {
+				trieCache = m.privateStatesTrieCache
+			}
+			roots[trieCache][common.BytesToHash(it.Value)] = true
+		}
+	}
+	return roots
+}
+
+// AuditOrphans cross-checks the privacy groups known to the manager with the private states stored in the
+// private states trie at the block hash, in both directions:
+//   - danglingPSIs are the identifiers of the private states stored without privacy group metadata, e.g. the
+//     group has been removed from the transaction manager. Their state remains on disk but can't be resolved.
+//     They are identified by the preimage of their trie key if recorded, by the hex encoded key hash otherwise.
+//   - missingStates are the identifiers of the privacy groups without private state stored. A group gets its
+//     state on its first private transaction, until then this is expected rather than a sign of corruption.
+//
+// The empty private state, which has no privacy group, is never reported. Both lists are sorted.
+// The returned error wraps mps.ErrNoPrivateStateRoot if no root is stored for the block.
+func (m *MultiplePrivateStateManager) AuditOrphans(blockHash common.Hash) (danglingPSIs, missingStates []types.PrivateStateIdentifier, err error) {
+	m.pruneMu.RLock()
+	defer m.pruneMu.RUnlock()
+	privateStatesTrieRoot := rawdb.GetPrivateStatesTrieRoot(m.db, blockHash)
+	if common.EmptyHash(privateStatesTrieRoot) {
+		return nil, nil, fmt.Errorf("%w for block %x", mps.ErrNoPrivateStateRoot, blockHash)
+	}
+	tr, err := m.privateStatesTrieCache.OpenTrie(privateStatesTrieRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, privacyGroupById := m.metadata()
+	known := knownPSIsByKey(privacyGroupById)
+	stored := make(map[types.PrivateStateIdentifier]struct{}, len(known))
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		if psi, ok := known[common.BytesToHash(it.Key)]; ok {
+			stored[psi] = struct{}{}
+			continue
+		}
+		danglingPSIs = append(danglingPSIs, psiOfUnknownKey(tr, it.Key))
+	}
+	if it.Err != nil {
+		return nil, nil, it.Err
+	}
+	for psi := range privacyGroupById {
+		if _, ok := stored[psi]; !ok {
+			missingStates = append(missingStates, psi)
+		}
+	}
+	sortPSIs(danglingPSIs)
+	sortPSIs(missingStates)
+	return danglingPSIs, missingStates, nil
+}
+
+// DumpRoots returns the root of each private state stored in the private states trie at the block hash, keyed by
+// psi, reading the trie without opening the states. The private states stored without privacy group metadata
+// are identified as by AuditOrphans. The returned error wraps mps.ErrNoPrivateStateRoot if no root is stored
+// for the block.
+func (m *MultiplePrivateStateManager) DumpRoots(blockHash common.Hash) (map[types.PrivateStateIdentifier]common.Hash, error) {
+	m.pruneMu.RLock()
+	defer m.pruneMu.RUnlock()
+	privateStatesTrieRoot := rawdb.GetPrivateStatesTrieRoot(m.db, blockHash)
+	if common.EmptyHash(privateStatesTrieRoot) {
+		return nil, fmt.Errorf("%w for block %x", mps.ErrNoPrivateStateRoot, blockHash)
+	}
+	tr, err := m.privateStatesTrieCache.OpenTrie(privateStatesTrieRoot)
+	if err != nil {
+		return nil, err
+	}
+	_, privacyGroupById := m.metadata()
+	known := knownPSIsByKey(privacyGroupById)
+	roots := make(map[types.PrivateStateIdentifier]common.Hash)
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		psi, ok := known[common.BytesToHash(it.Key)]
+		if !ok {
+			psi = psiOfUnknownKey(tr, it.Key)
+		}
+		roots[psi] = common.BytesToHash(it.Value)
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	return roots, nil
+}
+
+// knownPSIsByKey indexes the psis of the privacy groups, and the empty psi, by their key in the private states
+// trie, the hash of the psi
+func knownPSIsByKey(privacyGroupById map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) map[common.Hash]types.PrivateStateIdentifier {
+	known := make(map[common.Hash]types.PrivateStateIdentifier, len(privacyGroupById)+1)
+	known[crypto.Keccak256Hash([]byte(types.EmptyPrivateStateIdentifier))] = types.EmptyPrivateStateIdentifier
+	for psi := range privacyGroupById {
+		known[crypto.Keccak256Hash([]byte(psi))] = psi
+	}
+	return known
+}
+
+// psiOfUnknownKey identifies the private state stored under the key by the preimage of the key if recorded,
+// by the hex encoded key otherwise
+func psiOfUnknownKey(tr state.Trie, key []byte) types.PrivateStateIdentifier {
+	if preimage := tr.GetKey(key); preimage != nil {
+		return types.ToPrivateStateIdentifier(string(preimage))
+	}
+	return types.ToPrivateStateIdentifier(common.BytesToHash(key).Hex())
+}
+
+func sortPSIs(psis []types.PrivateStateIdentifier) {
+	sort.Slice(psis, func(i, j int) bool { return psis[i] < psis[j] })
+}
+
 func (m *MultiplePrivateStateManager) TrieDB() *trie.Database {
 	return m.privateStatesTrieCache.TrieDB()
 }
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/rawdb"
+	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/log"
+)
+
+// Prefetch loads in the background the private state trie nodes of the given accounts of each private
+// state at the block hash, so that they are served from the clean cache the private states are read
+// through when the states are read next, e.g. by the transactions of the following block. It returns
+// immediately.
+//
+// Prefetching is best effort: the private states or accounts which can't be read are skipped. The nodes
+// are only kept if the trie cache of the private state, the one of the manager unless overridden, has a
+// clean cache.
+func (m *MultiplePrivateStateManager) Prefetch(blockHash common.Hash, accounts map[types.PrivateStateIdentifier][]common.Address) {
+	go m.prefetch(blockHash, accounts)
+}
+
+// prefetch is the synchronous Prefetch, it returns the number of accounts found in the private states
+func (m *MultiplePrivateStateManager) prefetch(blockHash common.Hash, accounts map[types.PrivateStateIdentifier][]common.Address) int {
+	m.pruneMu.RLock()
+	defer m.pruneMu.RUnlock()
+	privateStatesTrieRoot := rawdb.GetPrivateStatesTrieRoot(m.db, blockHash)
+	if common.EmptyHash(privateStatesTrieRoot) {
+		return 0
+	}
+	tr, err := m.privateStatesTrieCache.OpenTrie(privateStatesTrieRoot)
+	if err != nil {
+		log.Debug("Failed to prefetch the private states", "block", blockHash, "err", err)
+		return 0
+	}
+	found := 0
+	for psi, addresses := range accounts {
+		privateStateRoot, err := tr.TryGet([]byte(psi))
+		if err != nil || len(privateStateRoot) == 0 {
+			continue
+		}
+		// through the trie cache the private state is read with, so that the nodes land in its clean cache
+		stateTrie, err := m.stateCacheOf(psi).OpenTrie(common.BytesToHash(privateStateRoot))
+		if err != nil {
+			log.Debug("Failed to prefetch the private state", "block", blockHash, "psi", psi, "err", err)
+			continue
+		}
+		for _, address := range addresses {
+			if account, err := stateTrie.TryGet(address.Bytes()); err == nil && len(account) > 0 {
+				found++
+			}
+		}
+	}
+	return found
+}
+
+// prefetchPrivateStates prefetches the recipients of the private transactions of the block in all the
+// private states at the parent root, if the chain has multiple private states
+func (bc *BlockChain) prefetchPrivateStates(parentRoot common.Hash, block *types.Block) {
+	mpsm, ok := bc.privateStateManager.(*MultiplePrivateStateManager)
+	if !ok {
+		return
+	}
+	targets := privateTransactionTargets(block)
+	if len(targets) == 0 {
+		return
+	}
+	accounts := make(map[types.PrivateStateIdentifier][]common.Address)
+	for _, psi := range mpsm.PSIs() {
+		accounts[psi] = targets
+	}
+	mpsm.Prefetch(parentRoot, accounts)
+}
+
+// privateTransactionTargets returns the recipients of the private transactions of the block, the
+// accounts read by the private states first when the block is processed
+func privateTransactionTargets(block *types.Block) []common.Address {
+	var targets []common.Address
+	seen := make(map[common.Address]struct{})
+	for _, tx := range block.Transactions() {
+		if !tx.IsPrivate() || tx.To() == nil {
+			continue
+		}
+		if _, ok := seen[*tx.To()]; !ok {
+			seen[*tx.To()] = struct{}{}
+			targets = append(targets, *tx.To())
+		}
+	}
+	return targets
+}
@@ -0,0 +1,204 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/rawdb"
+	"github.com/kisexp/xdchain/core/state"
+	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/ethdb"
+	"github.com/kisexp/xdchain/log"
+	"github.com/kisexp/xdchain/rlp"
+	"github.com/kisexp/xdchain/trie"
+)
+
+var (
+	errNoBlockToRetain = errors.New("at least one block must be retained")
+	errUnknownHead     = errors.New("unable to find the head of the chain")
+)
+
+// nodeSet holds the hashes of trie nodes
+type nodeSet map[common.Hash]struct{}
+
+// Prune deletes the private state trie nodes which are only reachable from canonical blocks older than
+// the last retainBlocks blocks of the chain.
+//
+// Pruning is a conservative mark-and-sweep. The nodes reachable from the private states of the retained
+// blocks, canonical or not, and from any public state still in the database are marked first. The nodes
+// reachable from the private states of the older canonical blocks are then deleted unless marked, the
+// deletions being written in batches. States whose root is not in the database are skipped. Contract code
+// is kept as it may be shared with other states. Pruning is aborted without deleting anything if a node
+// can't be read while marking.
+//
+// The marked and deleted node hashes are held in memory, so the memory used grows with the size of the
+// retained private states and of the public states still in the database.
+//
+// No private state repository can be opened and no private state can be written while pruning,
+// repositories opened before must not be used to read the states of pruned blocks. The clean cache of
+// the private states is reset once the nodes are deleted.
+func (m *MultiplePrivateStateManager) Prune(retainBlocks uint64) error {
+	if retainBlocks == 0 {
+		return errNoBlockToRetain
+	}
+	m.pruneMu.Lock()
+	defer m.pruneMu.Unlock()
+
+	headNumber := rawdb.ReadHeaderNumber(m.db, rawdb.ReadHeadHeaderHash(m.db))
+	if headNumber == nil {
+		return errUnknownHead
+	}
+	if *headNumber < retainBlocks {
+		return nil
+	}
+	firstRetained := *headNumber - retainBlocks + 1
+	walker := &trieNodeWalker{db: m.db, triedb: trie.NewDatabase(m.db)}
+
+	marked := make(nodeSet)
+	for number := uint64(0); number <= *headNumber; number++ {
+		for _, hash := range rawdb.ReadAllHashes(m.db, number) {
+			header := rawdb.ReadHeader(m.db, hash, number)
+			if header == nil {
+				continue
+			}
+			if privateStatesTrieRoot := rawdb.GetPrivateStatesTrieRoot(m.db, header.Root); number >= firstRetained && walker.onDisk(privateStatesTrieRoot) {
+				if err := walker.walkPrivateStates(privateStatesTrieRoot, marked, nil); err != nil {
+					return fmt.Errorf("prune aborted, unable to mark the private states of block %d: %w", number, err)
+				}
+			}
+			if walker.onDisk(header.Root) {
+				if err := walker.walkState(header.Root, marked, nil); err != nil {
+					return fmt.Errorf("prune aborted, unable to mark the public state of block %d: %w", number, err)
+				}
+			}
+		}
+	}
+
+	candidates := make(nodeSet)
+	for number := uint64(0); number < firstRetained; number++ {
+		header := rawdb.ReadHeader(m.db, rawdb.ReadCanonicalHash(m.db, number), number)
+		if header == nil {
+			continue
+		}
+		// the private states of a block may have been partially pruned already, the nodes which
+		// can't be reached anymore are simply not candidates
+		privateStatesTrieRoot := rawdb.GetPrivateStatesTrieRoot(m.db, header.Root)
+		if !walker.onDisk(privateStatesTrieRoot) {
+			continue
+		}
+		if err := walker.walkPrivateStates(privateStatesTrieRoot, candidates, marked); err != nil {
+			log.Debug("Unable to walk the private states of a pruned block", "number", number, "err", err)
+		}
+	}
+
+	batch := m.db.NewBatch()
+	deleted := 0
+	for hash := range candidates {
+		if _, ok := marked[hash]; ok {
+			continue
+		}
+		if err := batch.Delete(hash.Bytes()); err != nil {
+			return err
+		}
+		deleted++
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	m.privateStatesTrieCache.TrieDB().ResetCleanCache()
+	for _, trieCache := range m.psiTrieCaches {
+		trieCache.TrieDB().ResetCleanCache()
+	}
+	log.Info("Pruned private states", "retained", retainBlocks, "head", *headNumber, "nodes", deleted)
+	return nil
+}
+
+// trieNodeWalker collects the hashes of the trie nodes reachable from state roots
+type trieNodeWalker struct {
+	db     ethdb.Database
+	triedb *trie.Database
+}
+
+// onDisk reports whether the root node of the trie is in the database
+func (w *trieNodeWalker) onDisk(root common.Hash) bool {
+	if root == (common.Hash{}) || root == types.EmptyRootHash {
+		return false
+	}
+	has, _ := w.db.Has(root.Bytes())
+	return has
+}
+
+// walkPrivateStates adds to visited the nodes of the trie of private states and of each private state
+func (w *trieNodeWalker) walkPrivateStates(root common.Hash, visited nodeSet, skip nodeSet) error {
+	return w.walkTrie(root, visited, skip, func(blob []byte) error {
+		return w.walkState(common.BytesToHash(blob), visited, skip)
+	})
+}
+
+// walkState adds to visited the nodes of the state trie, of the storage tries of its accounts and
+// of its AccountExtraData trie
+func (w *trieNodeWalker) walkState(root common.Hash, visited nodeSet, skip nodeSet) error {
+	if _, ok := visited[root]; ok {
+		return nil
+	}
+	err := w.walkTrie(root, visited, skip, func(blob []byte) error {
+		var account state.Account
+		if err := rlp.DecodeBytes(blob, &account); err != nil {
+			return err
+		}
+		return w.walkTrie(account.Root, visited, skip, nil)
+	})
+	if err != nil {
+		return err
+	}
+	return w.walkTrie(rawdb.GetAccountExtraDataRoot(w.db, root), visited, skip, nil)
+}
+
+// walkTrie adds to visited the nodes of the trie, calling onLeaf with the value of each leaf.
+// Subtries whose root is in visited or skip are not walked again.
+func (w *trieNodeWalker) walkTrie(root common.Hash, visited nodeSet, skip nodeSet, onLeaf func([]byte) error) error {
+	if root == (common.Hash{}) || root == types.EmptyRootHash {
+		return nil
+	}
+	if _, ok := visited[root]; ok {
+		return nil
+	}
+	if _, ok := skip[root]; ok {
+		return nil
+	}
+	t, err := trie.New(root, w.triedb)
+	if err != nil {
+		return err
+	}
+	it := t.NodeIterator(nil)
+	for descend := true; it.Next(descend); {
+		descend = true
+		hash := it.Hash()
+		if hash == (common.Hash{}) {
+			// embedded node or leaf value
+			if it.Leaf() && onLeaf != nil {
+				if err := onLeaf(it.LeafBlob()); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if _, ok := visited[hash]; ok {
+			descend = false
+			continue
+		}
+		if _, ok := skip[hash]; ok {
+			descend = false
+			continue
+		}
+		visited[hash] = struct{}{}
+	}
+	return it.Error()
+}
@@ -3,8 +3,12 @@ package core
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"math/big"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/consensus/ethash"
@@ -14,10 +18,12 @@ import (
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/core/vm"
 	"github.com/kisexp/xdchain/crypto"
+	"github.com/kisexp/xdchain/ethdb"
 	"github.com/kisexp/xdchain/params"
 	"github.com/kisexp/xdchain/private"
 	"github.com/kisexp/xdchain/private/engine"
 	"github.com/kisexp/xdchain/rpc"
+	"github.com/kisexp/xdchain/trie"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -93,7 +99,10 @@ func TestMultiplePSMRStateCreated(t *testing.T) {
 	for _, block := range blocks {
 		parent := blockmap[block.ParentHash()]
 		statedb, _ := state.New(parent.Root(), blockchain.StateCache(), nil)
-		mockpsm.EXPECT().StateRepository(gomock.Any()).Return(mps.NewMultiplePrivateStateRepository(blockchain.db, cache, common.Hash{})).AnyTimes()
+		repo, err := mps.NewMultiplePrivateStateRepository(blockchain.db, cache, common.Hash{})
+		assert.NoError(t, err)
+		mockpsm.EXPECT().StateRepository(gomock.Any()).Return(repo, nil).AnyTimes()
+		mockpsm.EXPECT().StateRepositoryReadOnly(gomock.Any()).Return(mps.NewReadOnlyRepository(repo), nil).AnyTimes()
 
 		privateStateRepo, err := blockchain.PrivateStateManager().StateRepository(parent.Root())
 		assert.NoError(t, err)
@@ -253,22 +262,250 @@ func TestPrivateStateMetadataResolver(t *testing.T) {
 	_, err := mpsm.ResolveForManagedParty("TEST")
 	assert.Equal(t, psm1, privacyGroupToPrivateStateMetadata(PG1))
 	assert.Equal(t, psm2, privacyGroupToPrivateStateMetadata(PG2))
-	assert.Error(t, err, "unable to find private state metadata for managed party TEST")
+	assert.EqualError(t, err, "unable to find private state metadata for managed party TEST")
+	assert.True(t, errors.Is(err, mps.ErrUnknownManagedParty))
 
 	ctx := rpc.WithPrivateStateIdentifier(context.Background(), types.ToPrivateStateIdentifier("RG1"))
 	psm1, _ = mpsm.ResolveForUserContext(ctx)
 	assert.Equal(t, psm1, privacyGroupToPrivateStateMetadata(PG1))
 	ctx = rpc.WithPrivateStateIdentifier(context.Background(), types.ToPrivateStateIdentifier("OTHER"))
 	_, err = mpsm.ResolveForUserContext(ctx)
-	assert.Error(t, err, "unable to find private state for context psi OTHER")
+	assert.EqualError(t, err, "unable to find private state for context psi OTHER")
+	assert.True(t, errors.Is(err, mps.ErrUnknownPSI))
 	_, err = mpsm.ResolveForUserContext(context.Background())
-	assert.Error(t, err, "unable to find private state for context psi private")
+	assert.EqualError(t, err, "unable to find private state for context psi private")
+	assert.True(t, errors.Is(err, mps.ErrUnknownPSI))
 
 	assert.Contains(t, mpsm.PSIs(), types.PrivateStateIdentifier("RG1"))
 	assert.Contains(t, mpsm.PSIs(), types.PrivateStateIdentifier("RG2"))
 	assert.Contains(t, mpsm.PSIs(), types.PrivateStateIdentifier("LEGACY1"))
 }
 
+func TestPrivateStateMetadataResolver_ManagedPartyInMultipleGroups(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockptm := private.NewMockPrivateTransactionManager(mockCtrl)
+
+	saved := private.P
+	defer func() {
+		private.P = saved
+	}()
+	private.P = mockptm
+
+	overlappingGroups := append(append([]engine.PrivacyGroup{}, PrivacyGroups...), engine.PrivacyGroup{
+		Type:           "RESIDENT",
+		Name:           "RG3",
+		PrivacyGroupId: base64.StdEncoding.EncodeToString([]byte("RG3")),
+		Description:    "Resident Group 3",
+		From:           "",
+		Members:        []string{"BBB", "CCC"},
+	})
+	mockptm.EXPECT().HasFeature(engine.MultiplePrivateStates).Return(true)
+	mockptm.EXPECT().Groups().Return(overlappingGroups, nil)
+
+	mpsm, err := newPrivateStateManager(rawdb.NewMemoryDatabase(), nil, true, 0, 0)
+	assert.NoError(t, err)
+
+	psms, err := mpsm.ResolveAllForManagedParty("BBB")
+	assert.NoError(t, err)
+	if assert.Len(t, psms, 2) {
+		assert.Equal(t, types.ToPrivateStateIdentifier("RG1"), psms[0].ID)
+		assert.Equal(t, types.ToPrivateStateIdentifier("RG3"), psms[1].ID)
+	}
+	psms, err = mpsm.ResolveAllForManagedParty("AAA")
+	assert.NoError(t, err)
+	assert.Len(t, psms, 1)
+	_, err = mpsm.ResolveAllForManagedParty("TEST")
+	assert.EqualError(t, err, "unable to find private state metadata for managed party TEST")
+	assert.True(t, errors.Is(err, mps.ErrUnknownManagedParty))
+
+	// the single result lookup selects the first group returned by the transaction manager
+	psm, err := mpsm.ResolveForManagedParty("CCC")
+	assert.NoError(t, err)
+	assert.Equal(t, types.ToPrivateStateIdentifier("RG2"), psm.ID)
+}
+
+func TestMultiplePrivateStateManager_MissingManagedParties(t *testing.T) {
+	mpsm, _ := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, map[string][]*mps.PrivateStateMetadata{
+		"AAA": {&PSI1PSM},
+		"BBB": {&PSI1PSM, &PSI2PSM},
+		"CCC": {},
+	}, nil)
+
+	assert.Equal(t, []string{"DDD", "CCC"}, mpsm.MissingManagedParties([]string{"AAA", "DDD", "BBB", "CCC"}))
+	assert.Empty(t, mpsm.MissingManagedParties([]string{"BBB", "AAA"}))
+	assert.Empty(t, mpsm.MissingManagedParties(nil))
+}
+
+func TestMultiplePrivateStateManager_ResolveForManagedPartyContext(t *testing.T) {
+	mpsm, _ := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, map[string][]*mps.PrivateStateMetadata{
+		"AAA": {&PSI1PSM, &PSI2PSM},
+	}, nil)
+
+	psm, err := mpsm.ResolveForManagedPartyContext(context.Background(), "AAA")
+	assert.NoError(t, err)
+	assert.Same(t, &PSI1PSM, psm)
+	_, err = mpsm.ResolveForManagedPartyContext(context.Background(), "BBB")
+	assert.True(t, errors.Is(err, mps.ErrUnknownManagedParty), "unexpected error %v", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = mpsm.ResolveForManagedPartyContext(ctx, "AAA")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestMultiplePrivateStateManager_ReloadMetadata(t *testing.T) {
+	first := func() (map[string][]*mps.PrivateStateMetadata, map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) {
+		return map[string][]*mps.PrivateStateMetadata{"AAA": {&PSI1PSM}},
+			map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{PSI1PSM.ID: &PSI1PSM}
+	}
+	second := func() (map[string][]*mps.PrivateStateMetadata, map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata) {
+		return map[string][]*mps.PrivateStateMetadata{"AAA": {&PSI2PSM}, "BBB": {&PSI2PSM}},
+			map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{PSI2PSM.ID: &PSI2PSM}
+	}
+	residentGroupByKey, privacyGroupById := first()
+	mpsm, _ := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, residentGroupByKey, privacyGroupById)
+
+	const iterations = 1000
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			if i%2 == 0 {
+				assert.NoError(t, mpsm.ReloadMetadata(second()))
+			} else {
+				assert.NoError(t, mpsm.ReloadMetadata(first()))
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			psm, err := mpsm.ResolveForManagedParty("AAA")
+			assert.NoError(t, err)
+			assert.Contains(t, []*mps.PrivateStateMetadata{&PSI1PSM, &PSI2PSM}, psm)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			psis := mpsm.PSIs()
+			assert.Len(t, psis, 1)
+			assert.Contains(t, []types.PrivateStateIdentifier{PSI1PSM.ID, PSI2PSM.ID}, psis[0])
+		}
+	}()
+	wg.Wait()
+
+	assert.NoError(t, mpsm.ReloadMetadata(second()))
+	psm, err := mpsm.ResolveForManagedParty("BBB")
+	assert.NoError(t, err)
+	assert.Same(t, &PSI2PSM, psm)
+	assert.Equal(t, []types.PrivateStateIdentifier{PSI2PSM.ID}, mpsm.PSIs())
+	_, err = mpsm.ResolveForUserContext(rpc.WithPrivateStateIdentifier(context.Background(), PSI1PSM.ID))
+	assert.True(t, errors.Is(err, mps.ErrUnknownPSI), "unexpected error %v", err)
+}
+
+func TestMultiplePrivateStateManager_ConsistentMetadata(t *testing.T) {
+	// the same private state described by distinct but equal metadata
+	psi1Copy := copyPrivateStateMetadata(&PSI1PSM)
+	_, err := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, map[string][]*mps.PrivateStateMetadata{
+		"AAA": {&PSI1PSM},
+		"BBB": {psi1Copy, &PSI2PSM},
+	}, map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		PSI1PSM.ID: copyPrivateStateMetadata(&PSI1PSM),
+		PSI2PSM.ID: &PSI2PSM,
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestMultiplePrivateStateManager_ConflictingMetadata(t *testing.T) {
+	renamed := copyPrivateStateMetadata(&PSI1PSM)
+	renamed.Name = "renamed"
+	retyped := copyPrivateStateMetadata(&PSI1PSM)
+	retyped.Type = mps.Legacy
+
+	testCases := []struct {
+		name               string
+		residentGroupByKey map[string][]*mps.PrivateStateMetadata
+		privacyGroupById   map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata
+		expectedErr        string
+	}{
+		{
+			name:               "resident groups with different names",
+			residentGroupByKey: map[string][]*mps.PrivateStateMetadata{"AAA": {&PSI1PSM}, "BBB": {renamed}},
+			expectedErr:        "conflicting private state metadata for psi psi1: resident group of AAA has name=psi1 type=0, resident group of BBB has name=renamed type=0",
+		},
+		{
+			name:               "resident group and privacy group with different types",
+			residentGroupByKey: map[string][]*mps.PrivateStateMetadata{"AAA": {&PSI1PSM}},
+			privacyGroupById:   map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{PSI1PSM.ID: retyped},
+			expectedErr:        "conflicting private state metadata for psi psi1: privacy group psi1 has name=psi1 type=1, resident group of AAA has name=psi1 type=0",
+		},
+		{
+			name:             "privacy group under another psi",
+			privacyGroupById: map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{PSI2PSM.ID: &PSI1PSM},
+			expectedErr:      "conflicting private state metadata: privacy group psi2 has psi psi1",
+		},
+	}
+	for _, tc := range testCases {
+		_, err := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, tc.residentGroupByKey, tc.privacyGroupById)
+
+		assert.True(t, errors.Is(err, mps.ErrConflictingPrivateStateMetadata), "%s: unexpected error %v", tc.name, err)
+		assert.EqualError(t, err, tc.expectedErr, tc.name)
+	}
+
+	// the metadata are left unchanged when reloading conflicting ones
+	mpsm, _ := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, map[string][]*mps.PrivateStateMetadata{"AAA": {&PSI1PSM}}, nil)
+	err := mpsm.ReloadMetadata(testCases[0].residentGroupByKey, nil)
+	assert.True(t, errors.Is(err, mps.ErrConflictingPrivateStateMetadata), "unexpected error %v", err)
+	assert.Equal(t, []string{"BBB"}, mpsm.MissingManagedParties([]string{"AAA", "BBB"}))
+}
+
+func TestMultiplePrivateStateManagerWithCache_SharesTrieCache(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	trieCache := state.NewDatabase(db)
+
+	first, err := newMultiplePrivateStateManagerWithCache(db, trieCache, nil, nil)
+	assert.NoError(t, err)
+	second, err := newMultiplePrivateStateManagerWithCache(db, trieCache, nil, nil)
+	assert.NoError(t, err)
+	assert.Same(t, first.TrieDB(), second.TrieDB())
+
+	// a private state committed through one manager is read through the other
+	repo, err := first.StateRepository(common.Hash{})
+	assert.NoError(t, err)
+	psi1State, err := repo.StatePSI(PSI1PSM.ID)
+	assert.NoError(t, err)
+	psi1State.SetState(common.HexToAddress("0x1"), common.Hash{1}, common.Hash{2})
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{1}})
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+
+	repo, err = second.StateRepository(block.Root())
+	assert.NoError(t, err)
+	psi1State, err = repo.StatePSI(PSI1PSM.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, common.Hash{2}, psi1State.GetState(common.HexToAddress("0x1"), common.Hash{1}))
+
+	_, err = newMultiplePrivateStateManagerWithCache(db, nil, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestMultiplePrivateStateManager_StateRepositoryContext(t *testing.T) {
+	mpsm, _ := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, nil, nil)
+
+	repo, err := mpsm.StateRepositoryContext(context.Background(), common.Hash{})
+	assert.NoError(t, err)
+	assert.True(t, repo.IsMPS())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_, err = mpsm.StateRepositoryContext(ctx, common.Hash{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
 var PSI1PSM = mps.PrivateStateMetadata{
 	ID:          "psi1",
 	Name:        "psi1",
@@ -329,3 +566,820 @@ var PrivacyGroups = []engine.PrivacyGroup{
 		Members:        []string{"LEG1", "LEG2"},
 	},
 }
+
+func TestMultiplePrivateStateManager_StateRepository_NoPrivateStatesTrieRoot(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, nil)
+	genesisRoot, emptyRoot := common.Hash{1}, common.Hash{2}
+	assert.NoError(t, rawdb.WritePrivateStatesTrieRoot(db, emptyRoot, types.EmptyRootHash))
+
+	for _, blockHash := range []common.Hash{genesisRoot, emptyRoot} {
+		repo, err := mpsm.StateRepository(blockHash)
+		assert.NoError(t, err, "block %x", blockHash)
+		defaultState, err := repo.DefaultState()
+		assert.NoError(t, err, "block %x", blockHash)
+		assert.Equal(t, big.NewInt(0), defaultState.GetBalance(common.HexToAddress("0x1")))
+		psi1State, err := repo.StatePSI(PSI1PSM.ID)
+		assert.NoError(t, err, "block %x", blockHash)
+		assert.False(t, psi1State.Exist(common.HexToAddress("0x1")))
+	}
+}
+
+func TestMultiplePrivateStateManager_StateRepository_PrivateStatesTrieRoot(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+	missingRoot := common.Hash{124}
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	psi1State.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+	assert.NoError(t, rawdb.WritePrivateStatesTrieRoot(db, missingRoot, common.Hash{1}))
+
+	repo, err := mpsm.StateRepository(block.Root())
+	assert.NoError(t, err)
+	psi1State, err = repo.StatePSI(PSI1PSM.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(1), psi1State.GetBalance(common.HexToAddress("0x1")))
+
+	_, err = mpsm.StateRepository(missingRoot)
+	assert.True(t, errors.Is(err, mps.ErrNoPrivateStates), "unexpected error: %v", err)
+}
+
+func TestMultiplePrivateStateManagerWithTrieConfigs(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, err := NewMultiplePrivateStateManagerWithTrieConfigs(db, nil, map[types.PrivateStateIdentifier]*trie.Config{PSI1PSM.ID: {Cache: 16}}, nil, map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		PSI1PSM.ID: &PSI1PSM,
+		PSI2PSM.ID: &PSI2PSM,
+	})
+	assert.NoError(t, err)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	for _, psi := range []types.PrivateStateIdentifier{PSI1PSM.ID, PSI2PSM.ID} {
+		privateState, _ := repo.StatePSI(psi)
+		privateState.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	}
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+
+	repo, err = mpsm.StateRepository(block.Root())
+	assert.NoError(t, err)
+	psi1State, err := repo.StatePSI(PSI1PSM.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, mpsm.psiTrieCaches[PSI1PSM.ID], psi1State.Database(), "the override applies to psi1")
+	assert.Equal(t, big.NewInt(1), psi1State.GetBalance(common.HexToAddress("0x1")))
+	psi2State, err := repo.StatePSI(PSI2PSM.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, mpsm.privateStatesTrieCache, psi2State.Database(), "psi2 falls back to the cache of the manager")
+	assert.Equal(t, big.NewInt(1), psi2State.GetBalance(common.HexToAddress("0x1")))
+
+	// the copies of the repository keep the override
+	copied, err := repo.Copy().StatePSI(PSI1PSM.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, mpsm.psiTrieCaches[PSI1PSM.ID], copied.Database())
+	fresh, _ := mpsm.StateRepository(block.Root())
+	psi1State, err = fresh.StatePSI(PSI1PSM.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, mpsm.psiTrieCaches[PSI1PSM.ID], psi1State.Database())
+}
+
+func TestMultiplePrivateStateManager_TrieCacheCeiling(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, err := NewMultiplePrivateStateManagerWithTrieConfigs(db, nil, map[types.PrivateStateIdentifier]*trie.Config{PSI1PSM.ID: {}}, nil, map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		PSI1PSM.ID: &PSI1PSM,
+	})
+	assert.NoError(t, err)
+	ceiling := common.StorageSize(64 * 1024)
+	mpsm.trieCacheCeiling = ceiling
+	triedb := mpsm.psiTrieCaches[PSI1PSM.ID].TrieDB()
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	privateState, _ := repo.StatePSI(PSI1PSM.ID)
+	privateState.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+
+	for i := 0; i < 20; i++ {
+		repo, err := mpsm.StateRepository(block.Root())
+		assert.NoError(t, err)
+		nodes, preimages := triedb.Size()
+		assert.True(t, nodes+preimages < ceiling, "round %d: dirty nodes of %v above the ceiling once opened", i, nodes+preimages)
+
+		// the nodes are committed to the cache only, as done while mining
+		privateState, _ := repo.StatePSI(PSI1PSM.ID)
+		for j := 0; j < 100; j++ {
+			privateState.SetState(common.BigToAddress(big.NewInt(int64(i*100+j+1))), common.Hash{1}, common.Hash{byte(i + 1)})
+		}
+		assert.NoError(t, repo.Commit(false, nil))
+	}
+	nodes, _ := triedb.Size()
+	assert.True(t, nodes > ceiling*9/10, "the ceiling is enforced when opening the repositories, got %v", nodes)
+
+	_, err = mpsm.StateRepository(block.Root())
+	assert.NoError(t, err)
+	nodes, _ = triedb.Size()
+	assert.True(t, nodes <= ceiling/2, "dirty nodes of %v not flushed", nodes)
+}
+
+func TestMultiplePrivateStateManager_HasStateAt(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+
+	exists, err := mpsm.HasStateAt(PSI1PSM.ID, block.Root())
+	assert.NoError(t, err)
+	assert.False(t, exists, "no private states trie stored for the block")
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	psi1State.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+
+	exists, err = mpsm.HasStateAt(PSI1PSM.ID, block.Root())
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = mpsm.HasStateAt(types.EmptyPrivateStateIdentifier, block.Root())
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = mpsm.HasStateAt(PSI2PSM.ID, block.Root())
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestMultiplePrivateStateManager_PrivateStateRootAt(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+
+	_, err := mpsm.PrivateStateRootAt(block.Root())
+	assert.True(t, errors.Is(err, mps.ErrNoPrivateStateRoot), "unexpected error: %v", err)
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	psi1State.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+
+	root, err := mpsm.PrivateStateRootAt(block.Root())
+	assert.NoError(t, err)
+	assert.Equal(t, rawdb.GetPrivateStatesTrieRoot(db, block.Root()), root)
+	assert.NotEqual(t, common.Hash{}, root)
+}
+
+func TestMultiplePrivateStateManager_AuditOrphans(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	removedPSI := types.ToPrivateStateIdentifier("removed")
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		PSI1PSM.ID: &PSI1PSM,
+		PSI2PSM.ID: &PSI2PSM,
+	})
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+
+	_, _, err := mpsm.AuditOrphans(block.Root())
+	assert.True(t, errors.Is(err, mps.ErrNoPrivateStateRoot), "unexpected error: %v", err)
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	for _, psi := range []types.PrivateStateIdentifier{PSI1PSM.ID, removedPSI} {
+		privateState, _ := repo.StatePSI(psi)
+		privateState.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	}
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+
+	danglingPSIs, missingStates, err := mpsm.AuditOrphans(block.Root())
+
+	assert.NoError(t, err)
+	// the state of the removed group is stored without metadata, the empty state isn't reported
+	assert.Equal(t, []types.PrivateStateIdentifier{removedPSI}, danglingPSIs)
+	// psi2 has metadata but no state yet
+	assert.Equal(t, []types.PrivateStateIdentifier{PSI2PSM.ID}, missingStates)
+}
+
+func TestMultiplePrivateStateManager_DumpRoots(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	removedPSI := types.ToPrivateStateIdentifier("removed")
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		PSI1PSM.ID: &PSI1PSM,
+		PSI2PSM.ID: &PSI2PSM,
+	})
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+
+	_, err := mpsm.DumpRoots(block.Root())
+	assert.True(t, errors.Is(err, mps.ErrNoPrivateStateRoot), "unexpected error: %v", err)
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	for i, psi := range []types.PrivateStateIdentifier{PSI1PSM.ID, PSI2PSM.ID, removedPSI} {
+		privateState, _ := repo.StatePSI(psi)
+		privateState.AddBalance(common.HexToAddress("0x1"), big.NewInt(int64(i+1)))
+	}
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+
+	roots, err := mpsm.DumpRoots(block.Root())
+
+	assert.NoError(t, err)
+	reopened, _ := mpsm.StateRepository(block.Root())
+	expected := make(map[types.PrivateStateIdentifier]common.Hash)
+	for _, psi := range []types.PrivateStateIdentifier{types.EmptyPrivateStateIdentifier, PSI1PSM.ID, PSI2PSM.ID, removedPSI} {
+		privateState, err := reopened.StatePSI(psi)
+		assert.NoError(t, err)
+		expected[psi] = privateState.IntermediateRoot(false)
+	}
+	assert.Equal(t, expected, roots)
+	assert.NotEqual(t, roots[PSI1PSM.ID], roots[PSI2PSM.ID])
+}
+
+func TestMultiplePrivateStateManager_StateRepositoryReadOnly(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+
+	repo, err := mpsm.StateRepositoryReadOnly(common.Hash{})
+	assert.NoError(t, err)
+	psi1State, err := repo.StatePSI(PSI1PSM.ID)
+	assert.NoError(t, err)
+	psi1State.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+
+	assert.Equal(t, mps.ErrReadOnlyRepository, repo.CommitAndWrite(false, block))
+	assert.Equal(t, mps.ErrReadOnlyRepository, repo.Commit(false, block))
+	assert.Equal(t, mps.ErrReadOnlyRepository, repo.Copy().CommitAndWrite(false, block))
+	_, err = mpsm.PrivateStateRootAt(block.Root())
+	assert.True(t, errors.Is(err, mps.ErrNoPrivateStateRoot), "nothing must be written, got: %v", err)
+
+	// reads are unaffected
+	balance := psi1State.GetBalance(common.HexToAddress("0x1"))
+	assert.Equal(t, big.NewInt(1), balance)
+}
+
+func TestMultiplePrivateStateManager_CheckRange(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+	corruptedBlockRoot := common.Hash{124}
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	psi1State.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+	assert.NoError(t, rawdb.WritePrivateStatesTrieRoot(db, corruptedBlockRoot, common.Hash{1}))
+
+	results, err := mpsm.CheckRange([]common.Hash{block.Root(), corruptedBlockRoot, block.Root()})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.NoError(t, results[block.Root()])
+	assert.Equal(t, mpsm.CheckAt(corruptedBlockRoot), results[corruptedBlockRoot])
+	assert.Error(t, results[corruptedBlockRoot])
+}
+
+func TestMultiplePrivateStateManager_CheckRootAt(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+	corruptedBlockRoot := common.Hash{124}
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	psi1State.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+	assert.NoError(t, rawdb.WritePrivateStatesTrieRoot(db, corruptedBlockRoot, common.Hash{1}))
+
+	root, err := mpsm.CheckRootAt(block.Root())
+	assert.NoError(t, err)
+	assert.Equal(t, rawdb.GetPrivateStatesTrieRoot(db, block.Root()), root)
+	assert.False(t, common.EmptyHash(root))
+
+	root, err = mpsm.CheckRootAt(corruptedBlockRoot)
+	assert.Error(t, err)
+	assert.Equal(t, common.Hash{1}, root)
+	assert.Equal(t, err, mpsm.CheckAt(corruptedBlockRoot))
+}
+
+func TestMultiplePrivateStateManager_PrivacyGroups(t *testing.T) {
+	pg1 := privacyGroupToPrivateStateMetadata(PG1)
+	pg2 := privacyGroupToPrivateStateMetadata(PG2)
+	privacyGroupById := map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		pg1.ID: pg1,
+		pg2.ID: pg2,
+	}
+	mpsm, _ := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, nil, privacyGroupById)
+
+	groups := mpsm.PrivacyGroups()
+	assert.Len(t, groups, 2)
+	assert.Equal(t, pg1, groups[pg1.ID])
+	assert.Equal(t, pg2, groups[pg2.ID])
+
+	// changes to the returned groups don't leak into the manager
+	delete(groups, pg2.ID)
+	groups[pg1.ID].Name = "changed"
+	groups[pg1.ID].Addresses[0] = "changed"
+	assert.Len(t, mpsm.PrivacyGroups(), 2)
+	assert.Equal(t, privacyGroupToPrivateStateMetadata(PG1), mpsm.PrivacyGroups()[pg1.ID])
+}
+
+func TestMultiplePrivateStateManager_HandleReorg(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, &trie.Config{Cache: 16}, nil, nil)
+	ancestor := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{1}})
+	abandoned := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(2), Root: common.Hash{2}})
+	inserted := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(2), Root: common.Hash{3}})
+	sharedAbandoned := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(3), Root: common.Hash{4}})
+	sharedInserted := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(3), Root: common.Hash{5}})
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	psi1State.SetState(common.HexToAddress("0x1"), common.Hash{1}, common.Hash{1})
+	assert.NoError(t, repo.CommitAndWrite(false, ancestor))
+
+	// the private states of the blocks after the ancestor are only committed to the trie cache
+	commitInMemory := func(value common.Hash, blocks ...*types.Block) {
+		tr, err := mpsm.privateStatesTrieCache.OpenTrie(rawdb.GetPrivateStatesTrieRoot(db, ancestor.Root()))
+		assert.NoError(t, err)
+		assert.NoError(t, tr.TryUpdate([]byte(PSI2PSM.ID), value.Bytes()))
+		root, err := tr.Commit(nil)
+		assert.NoError(t, err)
+		for _, block := range blocks {
+			assert.NoError(t, rawdb.WritePrivateStatesTrieRoot(db, block.Root(), root))
+			assert.NoError(t, mpsm.CheckAt(block.Root()))
+		}
+	}
+	commitInMemory(common.Hash{2}, abandoned)
+	commitInMemory(common.Hash{3}, inserted)
+	commitInMemory(common.Hash{4}, sharedAbandoned, sharedInserted)
+	abandonedRoot := rawdb.GetPrivateStatesTrieRoot(db, abandoned.Root())
+	insertedRoot := rawdb.GetPrivateStatesTrieRoot(db, inserted.Root())
+	sharedRoot := rawdb.GetPrivateStatesTrieRoot(db, sharedInserted.Root())
+
+	assert.NoError(t, mpsm.HandleReorg(ancestor.Root(), []common.Hash{abandoned.Root(), sharedAbandoned.Root()}, []common.Hash{inserted.Root(), sharedInserted.Root()}))
+
+	assert.NotContains(t, mpsm.TrieDB().Nodes(), abandonedRoot)
+	assert.Contains(t, mpsm.TrieDB().Nodes(), insertedRoot, "only the abandoned private states must be dropped")
+	assert.Contains(t, mpsm.TrieDB().Nodes(), sharedRoot, "the private states shared with the new chain must be kept")
+	_, err := mpsm.StateRepository(abandoned.Root())
+	assert.Error(t, err, "the abandoned private states must not be served anymore")
+	assert.Error(t, mpsm.CheckAt(abandoned.Root()))
+	assert.NoError(t, mpsm.CheckAt(ancestor.Root()))
+	assert.NoError(t, mpsm.CheckAt(inserted.Root()))
+	assert.NoError(t, mpsm.CheckAt(sharedInserted.Root()), "the private states shared with the new chain must be kept")
+
+	err = mpsm.HandleReorg(common.Hash{6}, nil, nil)
+	assert.True(t, errors.Is(err, mps.ErrNoPrivateStateRoot), "unexpected error %v", err)
+}
+
+func TestMultiplePrivateStateManager_Prune(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, &trie.Config{Cache: 16}, nil, nil)
+	contract := common.HexToAddress("0x1")
+	sharedContract := common.HexToAddress("0x2")
+
+	parentRoot := common.Hash{}
+	var blocks []*types.Block
+	for i := 1; i <= 5; i++ {
+		header := &types.Header{Number: big.NewInt(int64(i)), Root: common.Hash{byte(i)}}
+		block := types.NewBlockWithHeader(header)
+		repo, err := mpsm.StateRepository(parentRoot)
+		assert.NoError(t, err)
+		psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+		psi1State.SetState(contract, common.Hash{byte(i)}, common.Hash{byte(i)})
+		if i == 1 {
+			psi1State.SetState(sharedContract, common.Hash{1}, common.Hash{1})
+		}
+		assert.NoError(t, repo.CommitAndWrite(false, block))
+
+		rawdb.WriteHeader(db, header)
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		rawdb.WriteHeadHeaderHash(db, block.Hash())
+		blocks = append(blocks, block)
+		parentRoot = block.Root()
+	}
+	repo, _ := mpsm.StateRepository(blocks[0].Root())
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	sharedStorageRoot := psi1State.StorageTrie(sharedContract).Hash()
+
+	assert.Error(t, mpsm.Prune(0))
+	assert.NoError(t, mpsm.Prune(5), "nothing to prune when all blocks are retained")
+	assert.NoError(t, mpsm.Prune(2))
+
+	// the blocks before the last 2 blocks are pruned, and not served from the clean cache anymore
+	for _, block := range blocks[:3] {
+		has, _ := db.Has(rawdb.GetPrivateStatesTrieRoot(db, block.Root()).Bytes())
+		assert.False(t, has, "private states of block %d must be pruned", block.NumberU64())
+		assert.Error(t, mpsm.CheckAt(block.Root()))
+	}
+	// nodes shared with the retained blocks are kept
+	has, _ := db.Has(sharedStorageRoot.Bytes())
+	assert.True(t, has)
+	// the retained blocks are complete
+	readManager, _ := newMultiplePrivateStateManager(db, nil, nil, nil)
+	walker := &trieNodeWalker{db: db, triedb: trie.NewDatabase(db)}
+	for _, block := range blocks[3:] {
+		assert.NoError(t, readManager.CheckAt(block.Root()))
+		assert.NoError(t, walker.walkPrivateStates(rawdb.GetPrivateStatesTrieRoot(db, block.Root()), make(nodeSet), nil))
+
+		repo, err := readManager.StateRepository(block.Root())
+		assert.NoError(t, err)
+		psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+		for i := 1; i <= int(block.NumberU64()); i++ {
+			assert.Equal(t, common.Hash{byte(i)}, psi1State.GetState(contract, common.Hash{byte(i)}))
+		}
+		assert.Equal(t, common.Hash{1}, psi1State.GetState(sharedContract, common.Hash{1}))
+	}
+}
+
+func TestMultiplePrivateStateManager_PruneBlocksWrites(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{1}})
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	psi1State.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+
+	// hold the lock as Prune does
+	mpsm.pruneMu.Lock()
+	written := make(chan error)
+	go func() {
+		written <- repo.Copy().CommitAndWrite(false, block)
+	}()
+	select {
+	case <-written:
+		t.Fatal("private states written while pruning")
+	case <-time.After(50 * time.Millisecond):
+	}
+	mpsm.pruneMu.Unlock()
+
+	select {
+	case err := <-written:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("private states not written once pruning is done")
+	}
+	exists, err := mpsm.HasStateAt(PSI1PSM.ID, block.Root())
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestMultiplePrivateStateManager_Prefetch(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, &trie.Config{Cache: 16}, nil, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+	accounts := map[types.PrivateStateIdentifier][]common.Address{
+		PSI1PSM.ID: {common.HexToAddress("0x1"), common.HexToAddress("0x3")},
+		PSI2PSM.ID: {common.HexToAddress("0x1")},
+	}
+
+	assert.Zero(t, mpsm.prefetch(block.Root(), accounts), "no private states trie stored for the block")
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	psi1State.AddBalance(common.HexToAddress("0x1"), big.NewInt(1))
+	psi1State.AddBalance(common.HexToAddress("0x2"), big.NewInt(1))
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+
+	// psi2 has no state and 0x3 isn't in the state of psi1
+	assert.Equal(t, 1, mpsm.prefetch(block.Root(), accounts))
+}
+
+// slowDatabase counts the reads of the database, each delayed by latency as if read from disk
+type slowDatabase struct {
+	ethdb.Database
+	latency time.Duration
+	reads   int64
+}
+
+func (db *slowDatabase) Get(key []byte) ([]byte, error) {
+	atomic.AddInt64(&db.reads, 1)
+	time.Sleep(db.latency)
+	return db.Database.Get(key)
+}
+
+func TestMultiplePrivateStateManager_Prefetch_WarmsTheCacheReadThrough(t *testing.T) {
+	db := &slowDatabase{Database: rawdb.NewMemoryDatabase()}
+	mpsm, _ := newMultiplePrivateStateManager(db, &trie.Config{Cache: 16}, nil, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	addresses := make([]common.Address, 100)
+	for i := range addresses {
+		addresses[i] = common.BigToAddress(big.NewInt(int64(i + 1)))
+		psi1State.AddBalance(addresses[i], big.NewInt(1))
+	}
+	assert.NoError(t, repo.CommitAndWrite(false, block))
+	readAccounts := func() int64 {
+		reads := atomic.LoadInt64(&db.reads)
+		repo, err := mpsm.StateRepository(block.Root())
+		assert.NoError(t, err)
+		psi1State, err := repo.StatePSI(PSI1PSM.ID)
+		assert.NoError(t, err)
+		for _, address := range addresses {
+			assert.Equal(t, big.NewInt(1), psi1State.GetBalance(address))
+		}
+		return atomic.LoadInt64(&db.reads) - reads
+	}
+
+	mpsm.privateStatesTrieCache.TrieDB().ResetCleanCache()
+	cold := readAccounts()
+	mpsm.privateStatesTrieCache.TrieDB().ResetCleanCache()
+	assert.Equal(t, len(addresses), mpsm.prefetch(block.Root(), map[types.PrivateStateIdentifier][]common.Address{PSI1PSM.ID: addresses}))
+	prefetched := readAccounts()
+
+	// only the roots stored for the block and the private state are read from disk, not the trie nodes
+	assert.Less(t, prefetched, cold)
+	assert.LessOrEqual(t, prefetched, int64(2), "the prefetched nodes must be read from the clean cache")
+}
+
+func TestPrivateTransactionTargets(t *testing.T) {
+	to1, to2 := common.HexToAddress("0x1"), common.HexToAddress("0x2")
+	privateTx := func(nonce uint64, to *common.Address) *types.Transaction {
+		tx := types.NewContractCreation(nonce, big.NewInt(0), 0, big.NewInt(0), nil)
+		if to != nil {
+			tx = types.NewTransaction(nonce, *to, big.NewInt(0), 0, big.NewInt(0), nil)
+		}
+		tx.SetPrivate()
+		return tx
+	}
+	block := types.NewBlock(&types.Header{Number: big.NewInt(1)}, []*types.Transaction{
+		privateTx(0, &to1),
+		types.NewTransaction(1, to2, big.NewInt(0), 0, big.NewInt(0), nil),
+		privateTx(2, nil),
+		privateTx(3, &to2),
+		privateTx(4, &to1),
+	}, nil, nil, new(trie.Trie))
+
+	assert.Equal(t, []common.Address{to1, to2}, privateTransactionTargets(block))
+}
+
+// BenchmarkMultiplePrivateStateManager_Prefetch compares reading the accounts of a private state from disk
+// to reading them once prefetched, the prefetch itself isn't timed as it runs alongside the block import.
+// The reads of the database are delayed as if from disk, the benchmark fails unless the prefetched reads
+// are fewer and faster.
+func BenchmarkMultiplePrivateStateManager_Prefetch(b *testing.B) {
+	db := &slowDatabase{Database: rawdb.NewMemoryDatabase()}
+	mpsm, _ := newMultiplePrivateStateManager(db, &trie.Config{Cache: 64}, nil, nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{123}})
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	privateState, _ := repo.StatePSI(PSI1PSM.ID)
+	addresses := make([]common.Address, 1000)
+	for i := range addresses {
+		addresses[i] = common.BigToAddress(big.NewInt(int64(i + 1)))
+		privateState.AddBalance(addresses[i], big.NewInt(1))
+	}
+	if err := repo.CommitAndWrite(false, block); err != nil {
+		b.Fatal(err)
+	}
+	accounts := map[types.PrivateStateIdentifier][]common.Address{PSI1PSM.ID: addresses[:100]}
+	db.latency = 10 * time.Microsecond
+
+	readsPerOp := make(map[bool]float64)
+	nsPerOp := make(map[bool]float64)
+	for _, prefetch := range []bool{false, true} {
+		name := "cold"
+		if prefetch {
+			name = "prefetched"
+		}
+		prefetch := prefetch
+		b.Run(name, func(b *testing.B) {
+			var reads int64
+			var elapsed time.Duration
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				mpsm.privateStatesTrieCache.TrieDB().ResetCleanCache()
+				if prefetch {
+					mpsm.prefetch(block.Root(), accounts)
+				}
+				start, startReads := time.Now(), atomic.LoadInt64(&db.reads)
+				b.StartTimer()
+
+				repo, err := mpsm.StateRepository(block.Root())
+				if err != nil {
+					b.Fatal(err)
+				}
+				privateState, _ := repo.StatePSI(PSI1PSM.ID)
+				for _, address := range accounts[PSI1PSM.ID] {
+					privateState.GetBalance(address)
+				}
+
+				b.StopTimer()
+				reads += atomic.LoadInt64(&db.reads) - startReads
+				elapsed += time.Since(start)
+				b.StartTimer()
+			}
+			readsPerOp[prefetch] = float64(reads) / float64(b.N)
+			nsPerOp[prefetch] = float64(elapsed.Nanoseconds()) / float64(b.N)
+			b.ReportMetric(readsPerOp[prefetch], "reads/op")
+		})
+	}
+	if readsPerOp[true] >= readsPerOp[false] || nsPerOp[true] >= nsPerOp[false] {
+		b.Fatalf("prefetching doesn't pay off: %.1f reads and %.0fns per op once prefetched, %.1f reads and %.0fns cold", readsPerOp[true], nsPerOp[true], readsPerOp[false], nsPerOp[false])
+	}
+}
+
+func TestMultiplePrivateStateManager_Checkpoint(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, nil, nil)
+	parent := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Root: common.Hash{1}})
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(2), Root: common.Hash{2}})
+
+	_, err := mpsm.Checkpoint(block.Root())
+	assert.True(t, errors.Is(err, mps.ErrNoPrivateStateRoot), "unexpected error %v", err)
+
+	repo, _ := mpsm.StateRepository(common.Hash{})
+	psi1State, _ := repo.StatePSI(PSI1PSM.ID)
+	psi1State.SetState(common.HexToAddress("0x1"), common.Hash{1}, common.Hash{1})
+	assert.NoError(t, repo.CommitAndWrite(false, parent))
+
+	// the private states of the block are only committed to the trie cache
+	tr, err := mpsm.privateStatesTrieCache.OpenTrie(rawdb.GetPrivateStatesTrieRoot(db, parent.Root()))
+	assert.NoError(t, err)
+	assert.NoError(t, tr.TryUpdate([]byte(PSI2PSM.ID), common.Hash{2}.Bytes()))
+	root, err := tr.Commit(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, rawdb.WritePrivateStatesTrieRoot(db, block.Root(), root))
+	_, err = state.NewDatabase(db).OpenTrie(root)
+	assert.Error(t, err, "the private states trie must not be on disk yet")
+
+	checkpoint, err := mpsm.Checkpoint(block.Root())
+
+	assert.NoError(t, err)
+	assert.Equal(t, root, checkpoint)
+	assert.Equal(t, checkpoint, rawdb.GetPrivateStatesTrieRoot(db, block.Root()))
+	fromDisk, err := state.NewDatabase(db).OpenTrie(checkpoint)
+	assert.NoError(t, err, "the private states trie must be read from disk")
+	psi2Root, err := fromDisk.TryGet([]byte(PSI2PSM.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, common.Hash{2}.Bytes(), psi2Root)
+}
+
+func TestMultiplePrivateStateManager_IsResident(t *testing.T) {
+	resident := privacyGroupToPrivateStateMetadata(PG1)
+	legacy := privacyGroupToPrivateStateMetadata(PrivacyGroups[2])
+	privacyGroupById := map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		resident.ID: resident,
+		legacy.ID:   legacy,
+	}
+	mpsm, _ := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, nil, privacyGroupById)
+
+	isResident, err := mpsm.IsResident(resident.ID)
+	assert.NoError(t, err)
+	assert.True(t, isResident)
+
+	isResident, err = mpsm.IsResident(legacy.ID)
+	assert.NoError(t, err)
+	assert.False(t, isResident)
+
+	_, err = mpsm.IsResident(types.ToPrivateStateIdentifier("unknown"))
+	assert.True(t, errors.Is(err, mps.ErrUnknownPSI))
+}
+
+func TestMultiplePrivateStateManager_ResolveByName(t *testing.T) {
+	pg1 := privacyGroupToPrivateStateMetadata(PG1)
+	pg2 := privacyGroupToPrivateStateMetadata(PG2)
+	privacyGroupById := map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		pg1.ID: pg1,
+		pg2.ID: pg2,
+	}
+	mpsm, _ := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, nil, privacyGroupById)
+
+	psm, err := mpsm.ResolveByName("RG2")
+	assert.NoError(t, err)
+	assert.Same(t, pg2, psm)
+
+	_, err = mpsm.ResolveByName("unknown")
+	assert.True(t, errors.Is(err, mps.ErrUnknownPrivacyGroupName), "unexpected error %v", err)
+
+	// a reload with another group named RG2 makes the name ambiguous
+	duplicate := mps.NewPrivateStateMetadata(types.ToPrivateStateIdentifier("other"), "RG2", "Other Group", mps.Resident, []string{"EEE"})
+	assert.NoError(t, mpsm.ReloadMetadata(nil, map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		pg1.ID:       pg1,
+		pg2.ID:       pg2,
+		duplicate.ID: duplicate,
+	}))
+
+	_, err = mpsm.ResolveByName("RG2")
+	assert.True(t, errors.Is(err, mps.ErrAmbiguousPrivacyGroupName), "unexpected error %v", err)
+	psm, err = mpsm.ResolveByName("RG1")
+	assert.NoError(t, err)
+	assert.Same(t, pg1, psm)
+}
+
+func TestMultiplePrivateStateManager_DeactivatePSI(t *testing.T) {
+	pg1 := privacyGroupToPrivateStateMetadata(PG1)
+	pg2 := privacyGroupToPrivateStateMetadata(PG2)
+	privacyGroupById := map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{
+		pg1.ID: pg1,
+		pg2.ID: pg2,
+	}
+	residentGroupByKey := map[string][]*mps.PrivateStateMetadata{}
+	for _, pg := range []*mps.PrivateStateMetadata{pg1, pg2} {
+		for _, address := range pg.Addresses {
+			residentGroupByKey[address] = append(residentGroupByKey[address], pg)
+		}
+	}
+	db := rawdb.NewMemoryDatabase()
+	mpsm, _ := newMultiplePrivateStateManager(db, nil, residentGroupByKey, privacyGroupById)
+
+	err := mpsm.DeactivatePSI(types.ToPrivateStateIdentifier("unknown"))
+	assert.True(t, errors.Is(err, mps.ErrUnknownPSI), "unexpected error %v", err)
+	assert.NoError(t, mpsm.DeactivatePSI(pg1.ID))
+	assert.True(t, mpsm.IsDeactivated(pg1.ID))
+	assert.False(t, mpsm.IsDeactivated(pg2.ID))
+
+	// reads are unaffected
+	ctx := rpc.WithPrivateStateIdentifier(context.Background(), pg1.ID)
+	psm, err := mpsm.ResolveForUserContext(ctx)
+	assert.NoError(t, err)
+	assert.Same(t, pg1, psm)
+	psm, err = mpsm.ResolveForManagedParty(pg1.Addresses[0])
+	assert.NoError(t, err)
+	assert.Equal(t, pg1.ID, psm.ID)
+	repo, err := mpsm.StateRepository(common.Hash{})
+	assert.NoError(t, err)
+	_, err = repo.StatePSI(pg1.ID)
+	assert.NoError(t, err)
+
+	// writes are rejected
+	_, err = mpsm.ResolveForUserContext(mps.WithWriteIntent(ctx))
+	assert.True(t, errors.Is(err, mps.ErrDeactivatedPSI), "unexpected error %v", err)
+	_, err = mpsm.ResolveForManagedPartyContext(mps.WithWriteIntent(context.Background()), pg1.Addresses[0])
+	assert.True(t, errors.Is(err, mps.ErrDeactivatedPSI), "unexpected error %v", err)
+	psm, err = mpsm.ResolveForUserContext(mps.WithWriteIntent(rpc.WithPrivateStateIdentifier(context.Background(), pg2.ID)))
+	assert.NoError(t, err)
+	assert.Same(t, pg2, psm)
+
+	// the deactivation is persisted
+	reopened, _ := newMultiplePrivateStateManager(db, nil, residentGroupByKey, privacyGroupById)
+	assert.True(t, reopened.IsDeactivated(pg1.ID))
+	_, err = reopened.ResolveForUserContext(mps.WithWriteIntent(ctx))
+	assert.True(t, errors.Is(err, mps.ErrDeactivatedPSI), "unexpected error %v", err)
+}
+
+// fakeDynamicResolver resolves the managed parties and the PSIs of the user context it is given, and
+// nothing otherwise
+type fakeDynamicResolver struct {
+	byManagedParty map[string]*mps.PrivateStateMetadata
+	byPSI          map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata
+	err            error
+}
+
+func (r *fakeDynamicResolver) ResolveForManagedParty(_ context.Context, managedParty string) (*mps.PrivateStateMetadata, error) {
+	return r.byManagedParty[managedParty], r.err
+}
+
+func (r *fakeDynamicResolver) ResolveForUserContext(ctx context.Context) (*mps.PrivateStateMetadata, error) {
+	psi, _ := rpc.PrivateStateIdentifierFromContext(ctx)
+	return r.byPSI[psi], r.err
+}
+
+func TestMultiplePrivateStateManager_DynamicResolver(t *testing.T) {
+	pg1 := privacyGroupToPrivateStateMetadata(PG1)
+	pg1Copy := *pg1
+	dynamic := mps.NewPrivateStateMetadata(types.ToPrivateStateIdentifier("dynamic"), "dynamic", "Dynamic Group", mps.Resident, []string{"ZZZ"})
+	mpsm, _ := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil,
+		map[string][]*mps.PrivateStateMetadata{pg1.Addresses[0]: {pg1}},
+		map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{pg1.ID: pg1})
+	resolver := &fakeDynamicResolver{
+		byManagedParty: map[string]*mps.PrivateStateMetadata{"ZZZ": dynamic, pg1.Addresses[0]: &pg1Copy},
+		byPSI:          map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{dynamic.ID: dynamic},
+	}
+	mpsm.SetDynamicResolver(resolver)
+
+	psm, err := mpsm.ResolveForManagedParty("ZZZ")
+	assert.NoError(t, err)
+	assert.Same(t, dynamic, psm)
+	psm, err = mpsm.ResolveForManagedParty(pg1.Addresses[0])
+	assert.NoError(t, err)
+	assert.Same(t, &pg1Copy, psm, "the dynamic resolution takes precedence")
+	psm, err = mpsm.ResolveForUserContext(rpc.WithPrivateStateIdentifier(context.Background(), dynamic.ID))
+	assert.NoError(t, err)
+	assert.Same(t, dynamic, psm)
+
+	// fallback to the static metadata
+	psm, err = mpsm.ResolveForUserContext(rpc.WithPrivateStateIdentifier(context.Background(), pg1.ID))
+	assert.NoError(t, err)
+	assert.Same(t, pg1, psm)
+	_, err = mpsm.ResolveForManagedParty("unknown")
+	assert.True(t, errors.Is(err, mps.ErrUnknownManagedParty), "unexpected error %v", err)
+
+	resolver.err = errors.New("directory unavailable")
+	_, err = mpsm.ResolveForManagedParty("ZZZ")
+	assert.Equal(t, resolver.err, err)
+	_, err = mpsm.ResolveForUserContext(context.Background())
+	assert.Equal(t, resolver.err, err)
+
+	mpsm.SetDynamicResolver(nil)
+	_, err = mpsm.ResolveForManagedParty("ZZZ")
+	assert.True(t, errors.Is(err, mps.ErrUnknownManagedParty), "unexpected error %v", err)
+	psm, err = mpsm.ResolveForManagedParty(pg1.Addresses[0])
+	assert.NoError(t, err)
+	assert.Same(t, pg1, psm)
+}
+
+func TestMultiplePrivateStateManager_StateRepositoryContext_TraceLabel(t *testing.T) {
+	mpsm, _ := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil, nil, nil)
+
+	repo, err := mpsm.StateRepositoryContext(mps.WithTraceLabel(context.Background(), "eth_call#42"), common.Hash{})
+	assert.NoError(t, err)
+	assert.Equal(t, "eth_call#42", mps.RepositoryLabel(repo))
+	assert.Equal(t, "eth_call#42", mps.RepositoryLabel(repo.Copy()))
+	assert.Equal(t, "eth_call#42", mps.RepositoryLabel(mps.NewReadOnlyRepository(repo)))
+
+	repo, err = mpsm.StateRepository(common.Hash{})
+	assert.NoError(t, err)
+	assert.Empty(t, mps.RepositoryLabel(repo))
+}
@@ -1,23 +1,30 @@
 package core
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
+	"time"
 
+	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/core/mps"
+	"github.com/kisexp/xdchain/core/state"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/ethdb"
+	"github.com/kisexp/xdchain/metrics"
 	"github.com/kisexp/xdchain/private"
 	"github.com/kisexp/xdchain/private/engine"
 	"github.com/kisexp/xdchain/trie"
 )
 
 // newPrivateStateManager instantiates an instance of mps.PrivateStateManager based on
-// the given isMPS flag.
+// the given isMPS flag. Up to openLimit private state repositories can be opened concurrently,
+// there is no limit if openLimit is not positive. The dirty nodes of the trie caches of the multiple
+// private states are flushed to disk once they approach dirtyLimit megabytes, unless it is 0.
 //
 // If isMPS is true, it also does the validation to make sure
 // the target private.PrivateTransactionManager supports MPS
-func newPrivateStateManager(db ethdb.Database, config *trie.Config, isMPS bool) (mps.PrivateStateManager, error) {
+func newPrivateStateManager(db ethdb.Database, config *trie.Config, isMPS bool, openLimit int, dirtyLimit int) (mps.PrivateStateManager, error) {
 	if isMPS {
 		// validation
 		if !private.P.HasFeature(engine.MultiplePrivateStates) {
@@ -27,7 +34,7 @@ func newPrivateStateManager(db ethdb.Database, config *trie.Config, isMPS bool)
 		if err != nil {
 			return nil, err
 		}
-		residentGroupByKey := make(map[string]*mps.PrivateStateMetadata)
+		residentGroupByKey := make(map[string][]*mps.PrivateStateMetadata)
 		privacyGroupById := make(map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata)
 		for _, group := range groups {
 			if group.Type == engine.PrivacyGroupResident {
@@ -45,19 +52,142 @@ func newPrivateStateManager(db ethdb.Database, config *trie.Config, isMPS bool)
 			}
 			privacyGroupById[psi] = privacyGroupToPrivateStateMetadata(group)
 			if group.Type == engine.PrivacyGroupResident {
+				// an address may be a member of more than one resident group, keep them in
+				// the order they are returned by the transaction manager
+				psm := privacyGroupToPrivateStateMetadata(group)
 				for _, address := range group.Members {
-					existing, found := residentGroupByKey[address]
-					if found {
-						return nil, fmt.Errorf("same address is part of two different groups: address=%s existing.Name=%s duplicate.Name=%s", address, existing.Name, group.Name)
-					}
-					residentGroupByKey[address] = privacyGroupToPrivateStateMetadata(group)
+					residentGroupByKey[address] = append(residentGroupByKey[address], psm)
 				}
 			}
 		}
-		return newMultiplePrivateStateManager(db, config, residentGroupByKey, privacyGroupById)
+		mpsm, err := newMultiplePrivateStateManager(db, config, residentGroupByKey, privacyGroupById)
+		if err != nil {
+			return nil, err
+		}
+		mpsm.openLimiter = newStateRepositoryOpenLimiter(openLimit)
+		mpsm.trieCacheCeiling = common.StorageSize(dirtyLimit) * 1024 * 1024
+		return mpsm, nil
 	} else {
-		return newDefaultPrivateStateManager(db, config), nil
+		dpsm := newDefaultPrivateStateManager(db, config)
+		dpsm.openLimiter = newStateRepositoryOpenLimiter(openLimit)
+		return dpsm, nil
+	}
+}
+
+var (
+	privateStateOpenInFlightGauge = metrics.NewRegisteredGauge("chain/privatestate/opens/inflight", nil)
+	privateStateOpenWaitTimer     = metrics.NewRegisteredTimer("chain/privatestate/opens/wait", nil)
+)
+
+// stateRepositoryOpenLimiter bounds the number of private state repositories opened concurrently.
+// The zero value doesn't limit the opens.
+type stateRepositoryOpenLimiter struct {
+	slots chan struct{}
+}
+
+// newStateRepositoryOpenLimiter returns a limiter allowing up to limit concurrent opens, there is
+// no limit if limit is not positive
+func newStateRepositoryOpenLimiter(limit int) stateRepositoryOpenLimiter {
+	if limit <= 0 {
+		return stateRepositoryOpenLimiter{}
+	}
+	return stateRepositoryOpenLimiter{slots: make(chan struct{}, limit)}
+}
+
+// open opens the private state repository and returns early with the context error if the context
+// is done first. If the limit of concurrent opens is reached, it first waits for an open to complete.
+//
+// The repository is opened in the background only if the context can be done, the underlying open
+// is not interrupted: it keeps running until it completes and its result is then dropped. The open
+// counts against the limit until it completes.
+func (l stateRepositoryOpenLimiter) open(ctx context.Context, open func() (mps.PrivateStateRepository, error)) (mps.PrivateStateRepository, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if l.slots != nil {
+		start := time.Now()
+		select {
+		case l.slots <- struct{}{}:
+			privateStateOpenWaitTimer.UpdateSince(start)
+		case <-ctx.Done():
+			privateStateOpenWaitTimer.UpdateSince(start)
+			return nil, ctx.Err()
+		}
+	}
+	tracked := func() (mps.PrivateStateRepository, error) {
+		privateStateOpenInFlightGauge.Inc(1)
+		defer func() {
+			privateStateOpenInFlightGauge.Dec(1)
+			if l.slots != nil {
+				<-l.slots
+			}
+		}()
+		return open()
+	}
+	if ctx.Done() == nil {
+		return tracked()
+	}
+	type result struct {
+		repo mps.PrivateStateRepository
+		err  error
+	}
+	resultC := make(chan result, 1)
+	go func() {
+		repo, err := tracked()
+		resultC <- result{repo, err}
+	}()
+	select {
+	case r := <-resultC:
+		return r.repo, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// checkRange opens the trie stored for each of the block hashes in the given state database and
+// returns the outcome of each. A missing trie is reported as the result of its block hash, any other
+// failure to read the database aborts the check
+func checkRange(db state.Database, roots []common.Hash, trieRootAt func(common.Hash) common.Hash) (map[common.Hash]error, error) {
+	results := make(map[common.Hash]error, len(roots))
+	for _, root := range roots {
+		if _, done := results[root]; done {
+			continue
+		}
+		_, err := db.OpenTrie(trieRootAt(root))
+		if err != nil {
+			if _, ok := err.(*trie.MissingNodeError); !ok {
+				return results, fmt.Errorf("check of block %x aborted: %w", root, err)
+			}
+		}
+		results[root] = err
+	}
+	return results, nil
+}
+
+// discardAbandonedRoots drops from the memory database of the given state database the roots of abandoned
+// which aren't in kept, and are only held in memory without being referenced. It returns the number of
+// dropped nodes
+func discardAbandonedRoots(db state.Database, abandoned, kept map[common.Hash]bool) int {
+	var roots []common.Hash
+	for root := range abandoned {
+		if !kept[root] {
+			roots = append(roots, root)
+		}
+	}
+	if len(roots) == 0 {
+		return 0
+	}
+	return db.TrieDB().DiscardUnreferenced(roots)
+}
+
+// copyPrivateStateMetadata returns a copy of the metadata that doesn't share its addresses
+func copyPrivateStateMetadata(psm *mps.PrivateStateMetadata) *mps.PrivateStateMetadata {
+	var addresses []string
+	if psm.Addresses != nil {
+		addresses = make([]string, len(psm.Addresses))
+		copy(addresses, psm.Addresses)
 	}
+	return mps.NewPrivateStateMetadata(psm.ID, psm.Name, psm.Description, psm.Type, addresses)
 }
 
 func privacyGroupToPrivateStateMetadata(group engine.PrivacyGroup) *mps.PrivateStateMetadata {
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kisexp/xdchain/core/mps"
+	"github.com/kisexp/xdchain/core/rawdb"
+	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// conformanceManagedParty is the managed party member of the default private state in the conformance tests
+const conformanceManagedParty = "conformance-party"
+
+// resolution is the outcome of a resolution which must be the same for all the private state managers: the
+// metadata are compared by identifier and type only, their name and description being informational
+type resolution struct {
+	IDs   []types.PrivateStateIdentifier
+	Types []mps.PrivateStateType
+	Err   error
+}
+
+func newResolution(err error, psms ...*mps.PrivateStateMetadata) resolution {
+	r := resolution{Err: err}
+	for _, psm := range psms {
+		r.IDs = append(r.IDs, psm.ID)
+		r.Types = append(r.Types, psm.Type)
+	}
+	return r
+}
+
+// defaultPSIConformanceCases are the resolutions of the default PSI the private state managers agree on, with
+// the expected outcome. The resolutions of unknown managed parties or PSIs aren't part of them: the default
+// manager resolves them to the default private state, or to the PSI itself, where the multiple manager fails.
+var defaultPSIConformanceCases = []struct {
+	name     string
+	resolve  func(psm mps.PrivateStateManager) resolution
+	expected resolution
+}{
+	{
+		name: "ResolveForUserContext without PSI",
+		resolve: func(psm mps.PrivateStateManager) resolution {
+			metadata, err := psm.ResolveForUserContext(context.Background())
+			return newResolution(err, metadata)
+		},
+		expected: newResolution(nil, mps.DefaultPrivateStateMetadata),
+	},
+	{
+		name: "ResolveForUserContext with the default PSI",
+		resolve: func(psm mps.PrivateStateManager) resolution {
+			metadata, err := psm.ResolveForUserContext(rpc.WithPrivateStateIdentifier(context.Background(), types.DefaultPrivateStateIdentifier))
+			return newResolution(err, metadata)
+		},
+		expected: newResolution(nil, mps.DefaultPrivateStateMetadata),
+	},
+	{
+		name: "ResolveForManagedParty",
+		resolve: func(psm mps.PrivateStateManager) resolution {
+			metadata, err := psm.ResolveForManagedParty(conformanceManagedParty)
+			return newResolution(err, metadata)
+		},
+		expected: newResolution(nil, mps.DefaultPrivateStateMetadata),
+	},
+	{
+		name: "ResolveForManagedPartyContext with a cancelled context",
+		resolve: func(psm mps.PrivateStateManager) resolution {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := psm.ResolveForManagedPartyContext(ctx, conformanceManagedParty)
+			return newResolution(err)
+		},
+		expected: newResolution(context.Canceled),
+	},
+	{
+		name: "ResolveAllForManagedParty",
+		resolve: func(psm mps.PrivateStateManager) resolution {
+			psms, err := psm.ResolveAllForManagedParty(conformanceManagedParty)
+			return newResolution(err, psms...)
+		},
+		expected: newResolution(nil, mps.DefaultPrivateStateMetadata),
+	},
+	{
+		name: "PSIs",
+		resolve: func(psm mps.PrivateStateManager) resolution {
+			return resolution{IDs: psm.PSIs()}
+		},
+		expected: resolution{IDs: []types.PrivateStateIdentifier{types.DefaultPrivateStateIdentifier}},
+	},
+	{
+		name: "PrivacyGroups",
+		resolve: func(psm mps.PrivateStateManager) resolution {
+			groups := psm.PrivacyGroups()
+			psms := make([]*mps.PrivateStateMetadata, 0, len(groups))
+			for _, metadata := range groups {
+				psms = append(psms, metadata)
+			}
+			return newResolution(nil, psms...)
+		},
+		expected: newResolution(nil, mps.DefaultPrivateStateMetadata),
+	},
+}
+
+// assertDefaultPSIConformance runs the conformance cases of the default PSI against each of the managers,
+// conformanceManagedParty being a member of their default private state: each of them must give the
+// expected outcome, so that they all resolve the default PSI the same way
+func assertDefaultPSIConformance(t *testing.T, managers map[string]mps.PrivateStateManager) {
+	for _, tc := range defaultPSIConformanceCases {
+		for name, psm := range managers {
+			assert.Equal(t, tc.expected, tc.resolve(psm), "%s: %s", name, tc.name)
+		}
+	}
+}
+
+func TestPrivateStateManager_DefaultPSIConformance(t *testing.T) {
+	defaultGroup := mps.NewPrivateStateMetadata(types.DefaultPrivateStateIdentifier, "private", "single resident group", mps.Resident, []string{conformanceManagedParty})
+	mpsm, err := newMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil,
+		map[string][]*mps.PrivateStateMetadata{conformanceManagedParty: {defaultGroup}},
+		map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{types.DefaultPrivateStateIdentifier: defaultGroup})
+	assert.NoError(t, err)
+
+	assertDefaultPSIConformance(t, map[string]mps.PrivateStateManager{
+		"default": newDefaultPrivateStateManager(rawdb.NewMemoryDatabase(), nil),
+		"mps":     mpsm,
+	})
+}
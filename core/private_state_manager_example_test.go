@@ -0,0 +1,41 @@
+package core_test
+
+import (
+	"fmt"
+
+	"github.com/kisexp/xdchain/core"
+	"github.com/kisexp/xdchain/core/mps"
+	"github.com/kisexp/xdchain/core/rawdb"
+	"github.com/kisexp/xdchain/core/types"
+)
+
+func ExampleNewDefaultPrivateStateManager() {
+	psm := core.NewDefaultPrivateStateManager(rawdb.NewMemoryDatabase(), nil)
+
+	fmt.Println(psm.PSIs())
+	// Output: [private]
+}
+
+func ExampleNewMultiplePrivateStateManager() {
+	group := &mps.PrivateStateMetadata{
+		ID:        types.ToPrivateStateIdentifier("RG1"),
+		Name:      "RG1",
+		Type:      mps.Resident,
+		Addresses: []string{"AAA", "BBB"},
+	}
+	psm, err := core.NewMultiplePrivateStateManager(rawdb.NewMemoryDatabase(), nil,
+		map[string][]*mps.PrivateStateMetadata{"AAA": {group}, "BBB": {group}},
+		map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata{group.ID: group})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	resolved, err := psm.ResolveForManagedParty("BBB")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(resolved.ID)
+	// Output: RG1
+}
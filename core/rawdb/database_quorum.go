@@ -21,6 +21,10 @@
 package rawdb
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/ethdb"
@@ -31,6 +35,12 @@ var (
 	privateStatesTrieRootPrefix = []byte("PSTP")
 	privateBloomPrefix          = []byte("Pb")
 	quorumEIP155ActivatedPrefix = []byte("quorum155active")
+	// extensionWatermarkPrefix + psi + 0x00 + watcher -> last processed block number (uint64 big endian)
+	extensionWatermarkPrefix = []byte("quorum-extension-watermark-")
+	// extensionHandledLogsPrefix + psi + 0x00 + watcher -> logs handled above the watermark (encoded by the extension service)
+	extensionHandledLogsPrefix = []byte("quorum-extension-handled-logs-")
+	// deactivatedPSIPrefix + psi -> flag set once the private state is deactivated
+	deactivatedPSIPrefix = []byte("quorum-mps-deactivated-")
 	// Quorum
 	// we introduce a generic approach to store extra data for an account. PrivacyMetadata is wrapped.
 	// However, this value is kept as-is to support backward compatibility
@@ -96,6 +106,89 @@ func GetPrivateBlockBloom(db ethdb.Database, number uint64) (bloom types.Bloom)
 	return bloom
 }
 
+// deactivatedPSIKey = deactivatedPSIPrefix + psi
+func deactivatedPSIKey(psi types.PrivateStateIdentifier) []byte {
+	return append(append([]byte{}, deactivatedPSIPrefix...), psi...)
+}
+
+// WritePSIDeactivated records that the private state identified by psi is deactivated
+func WritePSIDeactivated(db ethdb.KeyValueWriter, psi types.PrivateStateIdentifier) error {
+	return db.Put(deactivatedPSIKey(psi), []byte{1})
+}
+
+// IsPSIDeactivated checks if the private state identified by psi has been deactivated
+func IsPSIDeactivated(db ethdb.KeyValueReader, psi types.PrivateStateIdentifier) bool {
+	data, _ := db.Get(deactivatedPSIKey(psi))
+	return len(data) == 1
+}
+
+// extensionWatermarkKey = extensionWatermarkPrefix + psi + 0x00 + watcher
+func extensionWatermarkKey(psi types.PrivateStateIdentifier, watcher string) []byte {
+	key := append(append([]byte{}, extensionWatermarkPrefix...), psi...)
+	return append(append(key, 0), watcher...)
+}
+
+// WriteExtensionWatermark stores the last block number processed by the contract extension log watcher of the PSI
+func WriteExtensionWatermark(db ethdb.KeyValueWriter, psi types.PrivateStateIdentifier, watcher string, number uint64) error {
+	return db.Put(extensionWatermarkKey(psi, watcher), encodeBlockNumber(number))
+}
+
+// ReadExtensionWatermarks retrieves the last block number processed by each contract extension
+// log watcher of each PSI. Entries which can't be decoded are reported as an error.
+func ReadExtensionWatermarks(db ethdb.Iteratee) (map[types.PrivateStateIdentifier]map[string]uint64, error) {
+	it := db.NewIterator(extensionWatermarkPrefix, nil)
+	defer it.Release()
+
+	watermarks := make(map[types.PrivateStateIdentifier]map[string]uint64)
+	for it.Next() {
+		key := it.Key()[len(extensionWatermarkPrefix):]
+		separator := bytes.IndexByte(key, 0)
+		if separator < 0 || len(it.Value()) != 8 {
+			return nil, fmt.Errorf("invalid extension watermark entry %x", it.Key())
+		}
+		psi := types.PrivateStateIdentifier(key[:separator])
+		if watermarks[psi] == nil {
+			watermarks[psi] = make(map[string]uint64)
+		}
+		watermarks[psi][string(key[separator+1:])] = binary.BigEndian.Uint64(it.Value())
+	}
+	return watermarks, it.Error()
+}
+
+// extensionHandledLogsKey = extensionHandledLogsPrefix + psi + 0x00 + watcher
+func extensionHandledLogsKey(psi types.PrivateStateIdentifier, watcher string) []byte {
+	key := append(append([]byte{}, extensionHandledLogsPrefix...), psi...)
+	return append(append(key, 0), watcher...)
+}
+
+// WriteExtensionHandledLogs stores the encoded logs handled by the contract extension log watcher of the PSI
+// in the blocks above its watermark
+func WriteExtensionHandledLogs(db ethdb.KeyValueWriter, psi types.PrivateStateIdentifier, watcher string, data []byte) error {
+	return db.Put(extensionHandledLogsKey(psi, watcher), data)
+}
+
+// ReadExtensionHandledLogs retrieves the encoded logs handled by each contract extension log watcher of
+// each PSI in the blocks above its watermark. Entries which can't be decoded are reported as an error.
+func ReadExtensionHandledLogs(db ethdb.Iteratee) (map[types.PrivateStateIdentifier]map[string][]byte, error) {
+	it := db.NewIterator(extensionHandledLogsPrefix, nil)
+	defer it.Release()
+
+	handledLogs := make(map[types.PrivateStateIdentifier]map[string][]byte)
+	for it.Next() {
+		key := it.Key()[len(extensionHandledLogsPrefix):]
+		separator := bytes.IndexByte(key, 0)
+		if separator < 0 {
+			return nil, fmt.Errorf("invalid extension handled logs entry %x", it.Key())
+		}
+		psi := types.PrivateStateIdentifier(key[:separator])
+		if handledLogs[psi] == nil {
+			handledLogs[psi] = make(map[string][]byte)
+		}
+		handledLogs[psi][string(key[separator+1:])] = common.CopyBytes(it.Value())
+	}
+	return handledLogs, it.Error()
+}
+
 // AccountExtraDataLinker maintains mapping between root hash of the state trie
 // and root hash of state.AccountExtraData trie
 type AccountExtraDataLinker interface {
@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/ethdb/memorydb"
 	"github.com/stretchr/testify/assert"
 )
@@ -182,3 +183,53 @@ func TestPrivateStateRoot(t *testing.T) {
 	retrievedEmptyRoot := GetPrivateStateRoot(db, common.Hash{})
 	assert.Equal(t, common.Hash{}, retrievedEmptyRoot)
 }
+
+func TestExtensionWatermarks(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	watermarks, err := ReadExtensionWatermarks(db)
+	assert.Nil(t, err)
+	assert.Empty(t, watermarks)
+
+	assert.Nil(t, WriteExtensionWatermark(db, types.DefaultPrivateStateIdentifier, "newExtension", 10))
+	assert.Nil(t, WriteExtensionWatermark(db, types.DefaultPrivateStateIdentifier, "newExtension:0x1349f3e1B8D71eFfb47B840594Ff27dA7E603d17", 5))
+	assert.Nil(t, WriteExtensionWatermark(db, types.PrivateStateIdentifier("psi1"), "newExtension", 3))
+	assert.Nil(t, WriteExtensionWatermark(db, types.PrivateStateIdentifier("psi1"), "newExtension", 4))
+
+	watermarks, err = ReadExtensionWatermarks(db)
+	assert.Nil(t, err)
+	assert.Equal(t, map[types.PrivateStateIdentifier]map[string]uint64{
+		types.DefaultPrivateStateIdentifier: {
+			"newExtension": 10,
+			"newExtension:0x1349f3e1B8D71eFfb47B840594Ff27dA7E603d17": 5,
+		},
+		"psi1": {"newExtension": 4},
+	}, watermarks)
+
+	assert.Nil(t, db.Put(append(extensionWatermarkPrefix, []byte("corrupted")...), []byte{1}))
+	_, err = ReadExtensionWatermarks(db)
+	assert.Error(t, err)
+}
+
+func TestExtensionHandledLogs(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	handledLogs, err := ReadExtensionHandledLogs(db)
+	assert.Nil(t, err)
+	assert.Empty(t, handledLogs)
+
+	assert.Nil(t, WriteExtensionHandledLogs(db, types.DefaultPrivateStateIdentifier, "newExtension", []byte{1}))
+	assert.Nil(t, WriteExtensionHandledLogs(db, types.PrivateStateIdentifier("psi1"), "newExtension", []byte{2}))
+	assert.Nil(t, WriteExtensionHandledLogs(db, types.PrivateStateIdentifier("psi1"), "newExtension", []byte{3}))
+
+	handledLogs, err = ReadExtensionHandledLogs(db)
+	assert.Nil(t, err)
+	assert.Equal(t, map[types.PrivateStateIdentifier]map[string][]byte{
+		types.DefaultPrivateStateIdentifier: {"newExtension": {1}},
+		"psi1":                              {"newExtension": {3}},
+	}, handledLogs)
+
+	assert.Nil(t, db.Put(append(extensionHandledLogsPrefix, []byte("corrupted")...), []byte{1}))
+	_, err = ReadExtensionHandledLogs(db)
+	assert.Error(t, err)
+}
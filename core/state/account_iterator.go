@@ -0,0 +1,151 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/rlp"
+	"github.com/kisexp/xdchain/trie"
+)
+
+// AccountIterator iterates over the accounts committed to the trie of a state, in the order of their
+// secure keys. Accounts and their storage are loaded one at a time, so the state can be walked without
+// holding it in memory.
+type AccountIterator struct {
+	ctx   context.Context
+	state *StateDB
+	it    *trie.Iterator
+
+	key     common.Hash // Secure key of the current account
+	account Account     // Current account
+	err     error       // Failure which stopped the iteration
+}
+
+// NewAccountIterator returns an iterator over the accounts of the state starting at the given secure
+// key. The iteration stops once the context is done.
+func (s *StateDB) NewAccountIterator(ctx context.Context, start []byte) *AccountIterator {
+	return &AccountIterator{
+		ctx:   ctx,
+		state: s,
+		it:    trie.NewIterator(s.trie.NodeIterator(start)),
+	}
+}
+
+// Next moves the iterator to the next account, returning whether there is one. Err reports the
+// failure which ended the iteration, if any.
+func (it *AccountIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		return false
+	}
+	if !it.it.Next() {
+		it.err = it.it.Err
+		return false
+	}
+	var account Account
+	if err := rlp.DecodeBytes(it.it.Value, &account); err != nil {
+		it.err = fmt.Errorf("can't decode account %x: %v", it.it.Key, err)
+		return false
+	}
+	it.key, it.account = common.BytesToHash(it.it.Key), account
+	return true
+}
+
+// Err returns the failure which ended the iteration, nil if all the accounts were iterated
+func (it *AccountIterator) Err() error {
+	return it.err
+}
+
+// Key returns the secure key of the current account
+func (it *AccountIterator) Key() common.Hash {
+	return it.key
+}
+
+// Address returns the address of the current account, false is returned if its preimage is missing
+func (it *AccountIterator) Address() (common.Address, bool) {
+	addrBytes := it.state.trie.GetKey(it.key[:])
+	if addrBytes == nil {
+		return common.Address{}, false
+	}
+	return common.BytesToAddress(addrBytes), true
+}
+
+// Account returns the current account
+func (it *AccountIterator) Account() Account {
+	return it.account
+}
+
+// Code returns the code of the current account, nil if it isn't a contract
+func (it *AccountIterator) Code() ([]byte, error) {
+	if bytes.Equal(it.account.CodeHash, emptyCodeHash) {
+		return nil, nil
+	}
+	return it.state.db.ContractCode(it.key, common.BytesToHash(it.account.CodeHash))
+}
+
+// Storage returns an iterator over the storage of the current account, it shares the context of the
+// account iterator.
+func (it *AccountIterator) Storage() (*StorageIterator, error) {
+	storageTrie, err := it.state.db.OpenStorageTrie(it.key, it.account.Root)
+	if err != nil {
+		return nil, err
+	}
+	return &StorageIterator{
+		ctx:   it.ctx,
+		state: it.state,
+		it:    trie.NewIterator(storageTrie.NodeIterator(nil)),
+	}, nil
+}
+
+// StorageIterator iterates over the storage of an account, in the order of the secure keys of its slots
+type StorageIterator struct {
+	ctx   context.Context
+	state *StateDB
+	it    *trie.Iterator
+
+	value []byte // Content of the current slot
+	err   error  // Failure which stopped the iteration
+}
+
+// Next moves the iterator to the next storage slot, returning whether there is one. Err reports the
+// failure which ended the iteration, if any.
+func (it *StorageIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		return false
+	}
+	if !it.it.Next() {
+		it.err = it.it.Err
+		return false
+	}
+	_, content, _, err := rlp.Split(it.it.Value)
+	if err != nil {
+		it.err = fmt.Errorf("can't decode storage slot %x: %v", it.it.Key, err)
+		return false
+	}
+	it.value = content
+	return true
+}
+
+// Err returns the failure which ended the iteration, nil if all the slots were iterated
+func (it *StorageIterator) Err() error {
+	return it.err
+}
+
+// Key returns the key of the current slot, the zero hash if its preimage is missing
+func (it *StorageIterator) Key() common.Hash {
+	return common.BytesToHash(it.state.trie.GetKey(it.it.Key))
+}
+
+// Value returns the content of the current slot
+func (it *StorageIterator) Value() []byte {
+	return it.value
+}
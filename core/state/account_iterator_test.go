@@ -0,0 +1,115 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/crypto"
+)
+
+func TestAccountIterator(t *testing.T) {
+	db, root, accounts := makeTestState()
+	state, err := New(root, db, nil)
+	if err != nil {
+		t.Fatalf("failed to create state trie at %x: %v", root, err)
+	}
+	expected := make(map[common.Address]*testAccount)
+	for _, acc := range accounts {
+		expected[acc.address] = acc
+	}
+
+	it := state.NewAccountIterator(context.Background(), nil)
+	var count int
+	for it.Next() {
+		addr, ok := it.Address()
+		if !ok {
+			t.Fatalf("missing preimage of account %x", it.Key())
+		}
+		acc, ok := expected[addr]
+		if !ok {
+			t.Fatalf("unexpected account %x", addr)
+		}
+		if it.Key() != crypto.Keccak256Hash(addr[:]) {
+			t.Errorf("account %x: key mismatch: have %x", addr, it.Key())
+		}
+		if it.Account().Balance.Cmp(acc.balance) != 0 || it.Account().Nonce != acc.nonce {
+			t.Errorf("account %x: balance or nonce mismatch: have %v/%d, want %v/%d", addr, it.Account().Balance, it.Account().Nonce, acc.balance, acc.nonce)
+		}
+		code, err := it.Code()
+		if err != nil || !bytes.Equal(code, acc.code) {
+			t.Errorf("account %x: code mismatch: have %x (%v), want %x", addr, code, err, acc.code)
+		}
+		storage, err := it.Storage()
+		if err != nil {
+			t.Fatalf("account %x: failed to open storage: %v", addr, err)
+		}
+		slots := make(map[common.Hash]common.Hash)
+		for storage.Next() {
+			slots[storage.Key()] = common.BytesToHash(storage.Value())
+		}
+		if storage.Err() != nil {
+			t.Fatalf("account %x: storage iteration failed: %v", addr, storage.Err())
+		}
+		for slot, value := range slots {
+			if want := state.GetState(addr, slot); value != want {
+				t.Errorf("account %x: slot %x mismatch: have %x, want %x", addr, slot, value, want)
+			}
+		}
+		if addr[len(addr)-1]%5 == 0 && len(slots) != 5 {
+			t.Errorf("account %x: storage slot count mismatch: have %d, want 5", addr, len(slots))
+		}
+		count++
+	}
+	if it.Err() != nil {
+		t.Fatalf("iteration failed: %v", it.Err())
+	}
+	if count != len(accounts) {
+		t.Errorf("account count mismatch: have %d, want %d", count, len(accounts))
+	}
+}
+
+func TestAccountIterator_Cancelled(t *testing.T) {
+	db, root, _ := makeTestState()
+	state, _ := New(root, db, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	it := state.NewAccountIterator(ctx, nil)
+	if !it.Next() {
+		t.Fatalf("no account iterated: %v", it.Err())
+	}
+	storage, err := it.Storage()
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	cancel()
+
+	if it.Next() {
+		t.Error("account iterated after cancellation")
+	}
+	if it.Err() != context.Canceled {
+		t.Errorf("error mismatch: have %v, want %v", it.Err(), context.Canceled)
+	}
+	if storage.Next() {
+		t.Error("storage slot iterated after cancellation")
+	}
+	if storage.Err() != context.Canceled {
+		t.Errorf("storage error mismatch: have %v, want %v", storage.Err(), context.Canceled)
+	}
+}
+
+func TestAccountIterator_UncommittedChangesIgnored(t *testing.T) {
+	db, root, accounts := makeTestState()
+	state, _ := New(root, db, nil)
+	state.AddBalance(common.HexToAddress("0x1234"), big.NewInt(1))
+
+	var count int
+	for it := state.NewAccountIterator(context.Background(), nil); it.Next(); {
+		count++
+	}
+	if count != len(accounts) {
+		t.Errorf("account count mismatch: have %d, want %d", count, len(accounts))
+	}
+}
@@ -332,7 +332,7 @@ func (api *PublicDebugAPI) PrivateStateRoot(ctx context.Context, blockNr rpc.Blo
 }
 
 func (api *PublicDebugAPI) DefaultStateRoot(ctx context.Context, blockNr rpc.BlockNumber) (common.Hash, error) {
-	psm, err := api.eth.blockchain.PrivateStateManager().StateRepository(api.eth.blockchain.CurrentBlock().Hash())
+	psm, err := api.eth.blockchain.PrivateStateManager().StateRepositoryContext(ctx, api.eth.blockchain.CurrentBlock().Hash())
 	if err != nil {
 		return common.Hash{}, err
 	}
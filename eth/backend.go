@@ -149,12 +149,16 @@ func New(stack *node.Node, config *Config) (*Ethereum, error) {
 		rawdb.WriteQuorumEIP155Activation(chainDb)
 	}
 
+	engine, err := CreateConsensusEngine(stack, chainConfig, config, config.Miner.Notify, config.Miner.Noverify, chainDb)
+	if err != nil {
+		return nil, err
+	}
 	eth := &Ethereum{
 		config:                          config,
 		chainDb:                         chainDb,
 		eventMux:                        stack.EventMux(),
 		accountManager:                  stack.AccountManager(),
-		engine:                          CreateConsensusEngine(stack, chainConfig, config, config.Miner.Notify, config.Miner.Noverify, chainDb),
+		engine:                          engine,
 		closeBloomHandler:               make(chan struct{}),
 		networkID:                       config.NetworkId,
 		gasPrice:                        config.Miner.GasPrice,
@@ -221,6 +225,9 @@ func New(stack *node.Node, config *Config) (*Ethereum, error) {
 			Preimages:           config.Preimages,
 			// Quorum
 			PrivateTrieCleanJournal: stack.ResolvePath(config.PrivateTrieCleanCacheJournal),
+			PrivateStateOpenLimit:   config.PrivateStateOpenLimit,
+			PrivateStatePrefetch:    config.PrivateStatePrefetch,
+			PrivateTrieDirtyLimit:   config.PrivateTrieDirtyCache,
 		}
 	)
 	newBlockChainFunc := core.NewBlockChain
@@ -298,43 +305,73 @@ func makeExtraData(extra []byte, isQuorum bool) []byte {
 	return extra
 }
 
-// CreateConsensusEngine creates the required type of consensus engine instance for an Ethereum service
-func CreateConsensusEngine(stack *node.Node, chainConfig *params.ChainConfig, config *Config, notify []string, noverify bool, db ethdb.Database) consensus.Engine {
+// CreateConsensusEngine creates the required type of consensus engine instance for an Ethereum service,
+// it fails if the Istanbul proposer policy of the genesis, or the one overriding it, is unknown or its forks are inconsistent
+func CreateConsensusEngine(stack *node.Node, chainConfig *params.ChainConfig, config *Config, notify []string, noverify bool, db ethdb.Database) (consensus.Engine, error) {
 	// If proof-of-authority is requested, set it up
 	if chainConfig.Clique != nil {
 		chainConfig.Clique.AllowedFutureBlockTime = config.Miner.AllowedFutureBlockTime //Quorum
-		return clique.New(chainConfig.Clique, db)
+		return clique.New(chainConfig.Clique, db), nil
 	}
 	// If Istanbul is requested, set it up
 	if chainConfig.Istanbul != nil {
 		if chainConfig.Istanbul.Epoch != 0 {
 			config.Istanbul.Epoch = chainConfig.Istanbul.Epoch
 		}
-		config.Istanbul.ProposerPolicy = istanbul.NewProposerPolicy(istanbul.ProposerPolicyId(chainConfig.Istanbul.ProposerPolicy))
+		policyId := istanbul.ProposerPolicyId(chainConfig.Istanbul.ProposerPolicy)
+		if config.IstanbulProposerPolicy != "" {
+			policy, err := istanbul.NewProposerPolicyFromString(config.IstanbulProposerPolicy)
+			if err != nil {
+				return nil, err
+			}
+			log.Warn("Istanbul proposer policy of the genesis overridden", "genesis", chainConfig.Istanbul.ProposerPolicy, "policy", config.IstanbulProposerPolicy)
+			policyId = policy.Id
+		} else if !policyId.IsKnown() {
+			return nil, fmt.Errorf("unknown istanbul proposer policy %d in genesis", chainConfig.Istanbul.ProposerPolicy)
+		}
+		config.Istanbul.ProposerPolicy = istanbul.NewProposerPolicy(policyId)
+		config.Istanbul.ProposerPolicy.Seed = chainConfig.Istanbul.ProposerSeed
+		config.Istanbul.ProposerPolicy.RoundRobinFallback = chainConfig.Istanbul.RoundRobinFallback
+		config.Istanbul.ProposerPolicy.ProposerCooldown = chainConfig.Istanbul.ProposerCooldown
 		config.Istanbul.Ceil2Nby3Block = chainConfig.Istanbul.Ceil2Nby3Block
 		config.Istanbul.AllowedFutureBlockTime = config.Miner.AllowedFutureBlockTime //Quorum
 		config.Istanbul.TestQBFTBlock = chainConfig.Istanbul.TestQBFTBlock
+		if chainConfig.Istanbul.QBFTValidatorSortBy != "" {
+			config.Istanbul.QBFTValidatorSortBy = chainConfig.Istanbul.QBFTValidatorSortBy
+		}
+		// the genesis may predate some of the settings, the missing ones are given the legacy values
+		istanbulConfig, notes, err := istanbul.MigrateConfig(&config.Istanbul)
+		if err != nil {
+			return nil, err
+		}
+		for _, note := range notes {
+			log.Info("Migrated istanbul config", "note", note)
+		}
+		config.Istanbul = *istanbulConfig
+		if err := config.Istanbul.ValidateForks(); err != nil {
+			return nil, fmt.Errorf("inconsistent istanbul forks in genesis: %w", err)
+		}
 
-		return istanbulBackend.New(&config.Istanbul, stack.GetNodeKey(), db)
+		return istanbulBackend.New(&config.Istanbul, stack.GetNodeKey(), db), nil
 	}
 
 	// Otherwise assume proof-of-work
 	switch config.Ethash.PowMode {
 	case ethash.ModeFake:
 		log.Warn("Ethash used in fake mode")
-		return ethash.NewFaker()
+		return ethash.NewFaker(), nil
 	case ethash.ModeTest:
 		log.Warn("Ethash used in test mode")
-		return ethash.NewTester(nil, noverify)
+		return ethash.NewTester(nil, noverify), nil
 	case ethash.ModeShared:
 		log.Warn("Ethash used in shared mode")
-		return ethash.NewShared()
+		return ethash.NewShared(), nil
 	default:
 		// For Quorum, Raft run as a separate service, so
 		// the Ethereum service still needs a consensus engine,
 		// use the consensus with the lightest overhead
 		log.Warn("Ethash used in full fake mode")
-		return ethash.NewFullFaker()
+		return ethash.NewFullFaker(), nil
 	}
 }
 
@@ -83,7 +83,7 @@ var DefaultConfig = Config{
 	RPCTxFeeCap: 1, // 1 ether
 
 	// Quorum
-	Istanbul:                     *istanbul.DefaultConfig, // Quorum
+	Istanbul:                     *istanbul.DefaultConfig(), // Quorum
 	PrivateTrieCleanCacheJournal: "privatetriecache",
 }
 
@@ -108,6 +108,14 @@ func init() {
 	}
 }
 
+// NewDefaultConfig returns a copy of DefaultConfig with its own Istanbul config, so the
+// ProposerPolicy and the fork blocks of the copy aren't shared with DefaultConfig.
+func NewDefaultConfig() Config {
+	cfg := DefaultConfig
+	cfg.Istanbul = *istanbul.DefaultConfig()
+	return cfg
+}
+
 //go:generate gencodec -type Config -formats toml -out gen_config.go
 
 type Config struct {
@@ -208,4 +216,8 @@ type Config struct {
 
 	// Quorum
 	PrivateTrieCleanCacheJournal string `toml:",omitempty"` // Disk journal directory for private trie cache to survive node restarts
+	PrivateStateOpenLimit        int    `toml:",omitempty"` // Maximum number of private state repositories opened concurrently, 0 for no limit
+	PrivateStatePrefetch         bool   `toml:",omitempty"` // Prefetch the private state trie nodes of the accounts of the private transactions of imported blocks
+	PrivateTrieDirtyCache        int    `toml:",omitempty"` // Memory ceiling (MB) of the dirty nodes of each private state trie cache, 0 for no ceiling
+	IstanbulProposerPolicy       string `toml:",omitempty"` // Istanbul proposer policy by name, roundrobin or sticky, used instead of the policy of the genesis by test networks
 }
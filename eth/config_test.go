@@ -1,9 +1,15 @@
 package eth
 
 import (
+	"encoding/json"
 	"testing"
 
+	"github.com/kisexp/xdchain/consensus/istanbul"
+	"github.com/kisexp/xdchain/core/rawdb"
+	"github.com/kisexp/xdchain/node"
+	"github.com/kisexp/xdchain/params"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestQuorumDefautConfig(t *testing.T) {
@@ -19,3 +25,81 @@ func TestQuorumDefautConfig(t *testing.T) {
 		assert.Equal(t, v.expected, v.actual, k+" value mismatch")
 	}
 }
+
+func TestNewDefaultConfig_DoesNotShareIstanbulConfig(t *testing.T) {
+	cfg := NewDefaultConfig()
+	other := NewDefaultConfig()
+
+	cfg.Istanbul.ProposerPolicy.Id = istanbul.Sticky
+	cfg.Istanbul.Ceil2Nby3Block.SetUint64(10)
+
+	assert.Equal(t, istanbul.RoundRobin, other.Istanbul.ProposerPolicy.Id)
+	assert.Equal(t, istanbul.RoundRobin, DefaultConfig.Istanbul.ProposerPolicy.Id)
+	assert.Equal(t, uint64(0), other.Istanbul.Ceil2Nby3Block.Uint64())
+	assert.Equal(t, uint64(0), DefaultConfig.Istanbul.Ceil2Nby3Block.Uint64())
+	assert.False(t, cfg.Istanbul.ProposerPolicy == DefaultConfig.Istanbul.ProposerPolicy)
+}
+
+func TestCreateConsensusEngine_UnknownGenesisProposerPolicy(t *testing.T) {
+	var chainConfig params.ChainConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"chainId": 10, "istanbul": {"epoch": 30000, "policy": 99}}`), &chainConfig))
+	cfg := NewDefaultConfig()
+
+	_, err := CreateConsensusEngine(nil, &chainConfig, &cfg, nil, false, rawdb.NewMemoryDatabase())
+
+	assert.EqualError(t, err, "unknown istanbul proposer policy 99 in genesis")
+}
+
+func TestCreateConsensusEngine_MigratesLegacyIstanbulConfig(t *testing.T) {
+	var chainConfig params.ChainConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"chainId": 10, "istanbul": {"epoch": 30000, "policy": 0}}`), &chainConfig))
+	stack, err := node.New(&node.Config{})
+	require.NoError(t, err)
+	defer stack.Close()
+	// the request timeout was not part of the config before
+	cfg := NewDefaultConfig()
+	cfg.Istanbul.RequestTimeout = 0
+
+	engine, err := CreateConsensusEngine(stack, &chainConfig, &cfg, nil, false, rawdb.NewMemoryDatabase())
+
+	require.NoError(t, err)
+	assert.NotNil(t, engine)
+	assert.Equal(t, istanbul.DefaultConfig().RequestTimeout, cfg.Istanbul.RequestTimeout)
+	assert.Equal(t, uint64(30000), cfg.Istanbul.Epoch)
+}
+
+func TestCreateConsensusEngine_InconsistentGenesisForks(t *testing.T) {
+	var chainConfig params.ChainConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"chainId": 10, "istanbul": {"epoch": 30000, "policy": 0, "testQBFTBlock": 10}}`), &chainConfig))
+	cfg := NewDefaultConfig()
+
+	_, err := CreateConsensusEngine(nil, &chainConfig, &cfg, nil, false, rawdb.NewMemoryDatabase())
+
+	assert.EqualError(t, err, "inconsistent istanbul forks in genesis: TestQBFTBlock 10 requires Ceil2Nby3Block, qbft consensus can't use the 2F+1 quorum")
+}
+
+func TestCreateConsensusEngine_OverriddenProposerPolicy(t *testing.T) {
+	var chainConfig params.ChainConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"chainId": 10, "istanbul": {"epoch": 30000, "policy": 0}}`), &chainConfig))
+	stack, err := node.New(&node.Config{})
+	require.NoError(t, err)
+	defer stack.Close()
+	cfg := NewDefaultConfig()
+	cfg.IstanbulProposerPolicy = "Sticky"
+
+	_, err = CreateConsensusEngine(stack, &chainConfig, &cfg, nil, false, rawdb.NewMemoryDatabase())
+
+	require.NoError(t, err)
+	assert.Equal(t, istanbul.Sticky, cfg.Istanbul.ProposerPolicy.Id)
+}
+
+func TestCreateConsensusEngine_UnknownOverriddenProposerPolicy(t *testing.T) {
+	var chainConfig params.ChainConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"chainId": 10, "istanbul": {"epoch": 30000, "policy": 0}}`), &chainConfig))
+	cfg := NewDefaultConfig()
+	cfg.IstanbulProposerPolicy = "random"
+
+	_, err := CreateConsensusEngine(nil, &chainConfig, &cfg, nil, false, rawdb.NewMemoryDatabase())
+
+	assert.EqualError(t, err, `unknown proposer policy "random", expected roundrobin or sticky`)
+}
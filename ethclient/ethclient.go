@@ -37,8 +37,15 @@ import (
 type Client struct {
 	c  *rpc.Client
 	pc privateTransactionManagerClient // Tessera/Constellation client
+
+	maxPrivatePayloadSize int // maximum size in bytes of a payload sent to the private transaction manager, 0 for no limit
 }
 
+// Quorum
+//
+// ErrPrivatePayloadTooLarge is returned when a payload exceeds the size limit configured for the private transaction manager
+var ErrPrivatePayloadTooLarge = errors.New("private payload too large")
+
 // Dial connects a client to the given URL.
 func Dial(rawurl string) (*Client, error) {
 	return DialContext(context.Background(), rawurl)
@@ -54,14 +61,22 @@ func DialContext(ctx context.Context, rawurl string) (*Client, error) {
 
 // NewClient creates a client that uses the given RPC client.
 func NewClient(c *rpc.Client) *Client {
-	return &Client{c, nil}
+	return &Client{c: c}
 }
 
 // Quorum
 //
 // NewClientWithPTM creates a client that uses the given RPC client and the privateTransactionManager client
 func NewClientWithPTM(c *rpc.Client, ptm privateTransactionManagerClient) *Client {
-	return &Client{c, ptm}
+	return &Client{c: c, pc: ptm}
+}
+
+// Quorum
+//
+// SetMaxPrivatePayloadSize limits the size in bytes of the payloads sent to the private transaction manager.
+// Larger payloads are rejected with ErrPrivatePayloadTooLarge before reaching it. A size of 0 removes the limit.
+func (ec *Client) SetMaxPrivatePayloadSize(size int) {
+	ec.maxPrivatePayloadSize = size
 }
 
 // provides support for private transactions
@@ -560,6 +575,9 @@ func (ec *Client) PreparePrivateTransaction(data []byte, privateFrom string) (co
 	if ec.pc == nil {
 		return common.EncryptedPayloadHash{}, errors.New("missing private transaction manager client configuration")
 	}
+	if ec.maxPrivatePayloadSize > 0 && len(data) > ec.maxPrivatePayloadSize {
+		return common.EncryptedPayloadHash{}, fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrPrivatePayloadTooLarge, len(data), ec.maxPrivatePayloadSize)
+	}
 	payLoadHash, err := ec.pc.StoreRaw(data, privateFrom)
 	return payLoadHash, err
 }
@@ -397,3 +397,18 @@ type privateTransactionManagerStubClient struct {
 func (s *privateTransactionManagerStubClient) StoreRaw(data []byte, from string) (common.EncryptedPayloadHash, error) {
 	return common.BytesToEncryptedPayloadHash(data), nil
 }
+
+func TestClient_PreparePrivateTransaction_whenPayloadTooLarge(t *testing.T) {
+	testObject := NewClient(nil)
+	testObject.pc = &privateTransactionManagerStubClient{}
+	testObject.SetMaxPrivatePayloadSize(8)
+
+	_, err := testObject.PreparePrivateTransaction([]byte("arbitrary payload"), "arbitrary private from")
+
+	assert.True(t, errors.Is(err, ErrPrivatePayloadTooLarge))
+	assert.EqualError(t, err, "private payload too large: 17 bytes exceeds the limit of 8 bytes")
+
+	_, err = testObject.PreparePrivateTransaction([]byte("payload"), "arbitrary private from")
+
+	assert.NoError(t, err)
+}
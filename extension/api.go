@@ -2,13 +2,14 @@ package extension
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
 
 	"github.com/kisexp/xdchain/accounts/abi/bind"
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/ethclient"
+	"github.com/kisexp/xdchain/extension/privacyExtension"
 	"github.com/kisexp/xdchain/internal/ethapi"
 	"github.com/kisexp/xdchain/multitenancy"
 	"github.com/kisexp/xdchain/permission/core"
@@ -17,10 +18,14 @@ import (
 var (
 	errNotAcceptor = errors.New("account is not acceptor of this extension request")
 	errNotCreator  = errors.New("account is not the creator of this extension request")
+
+	errExtensionNotInFlight = errors.New("no extension in progress for this management contract")
 )
 
 const extensionCompleted = "DONE"
 const extensionInProgress = "ACTIVE"
+const extensionCancelled = "CANCELLED"
+const extensionRejected = "REJECTED"
 
 type PrivateExtensionAPI struct {
 	privacyService *PrivacyService
@@ -34,8 +39,8 @@ func NewPrivateExtensionAPI(privacyService *PrivacyService) *PrivateExtensionAPI
 
 // ActiveExtensionContracts returns the list of all currently outstanding extension contracts
 func (api *PrivateExtensionAPI) ActiveExtensionContracts(ctx context.Context) []ExtensionContract {
-	api.privacyService.mu.Lock()
-	defer api.privacyService.mu.Unlock()
+	api.privacyService.mu.RLock()
+	defer api.privacyService.mu.RUnlock()
 
 	psi, err := api.privacyService.apiBackendHelper.PSMR().ResolveForUserContext(ctx)
 	if err != nil {
@@ -50,10 +55,11 @@ func (api *PrivateExtensionAPI) ActiveExtensionContracts(ctx context.Context) []
 	return extracted
 }
 
-// checks of the passed contract address is under extension process
+// checks of the passed contract address is under extension process, either as the extended
+// contract or bundled with it
 func (api *PrivateExtensionAPI) checkIfContractUnderExtension(ctx context.Context, toExtend common.Address) bool {
 	for _, v := range api.ActiveExtensionContracts(ctx) {
-		if v.ContractExtended == toExtend {
+		if v.ContractExtended == toExtend || checkAddressInList(toExtend, v.BundledContracts) {
 			return true
 		}
 	}
@@ -85,6 +91,34 @@ func (api *PrivateExtensionAPI) checkIfExtensionComplete(addressToVoteOn, from c
 	return status, nil
 }
 
+// returns the status of a finished extension: completed if its state has been shared, rejected if it
+// has been voted down and cancelled otherwise
+func (api *PrivateExtensionAPI) getFinishedExtensionStatus(managementContract common.Address, psi types.PrivateStateIdentifier) (string, error) {
+	psiManagementContractClient := api.privacyService.managementContract(psi)
+	defer psiManagementContractClient.Close()
+	caller, err := psiManagementContractClient.Caller(managementContract)
+	if err != nil {
+		return "", err
+	}
+	opts := bind.CallOpts{Pending: true}
+
+	sharedDataHash, err := caller.SharedDataHash(&opts)
+	if err != nil {
+		return "", err
+	}
+	if sharedDataHash != "" {
+		return extensionCompleted, nil
+	}
+	voteOutcome, err := caller.VoteOutcome(&opts)
+	if err != nil {
+		return "", err
+	}
+	if !voteOutcome {
+		return extensionRejected, nil
+	}
+	return extensionCancelled, nil
+}
+
 // returns the contract being extended for the given management contract
 func (api *PrivateExtensionAPI) getContractExtended(addressToVoteOn, from common.Address, psi types.PrivateStateIdentifier) (common.Address, error) {
 	psiManagementContractClient := api.privacyService.managementContract(psi)
@@ -218,7 +252,83 @@ func (api *PrivateExtensionAPI) ApproveExtension(ctx context.Context, addressToV
 // - the contract address we want to extend
 // - the new PTM public key
 // - the Ethereum addresses of who can vote to extend the contract
-func (api *PrivateExtensionAPI) ExtendContract(ctx context.Context, toExtend common.Address, newRecipientPtmPublicKey string, recipientAddr common.Address, txa ethapi.SendTxArgs) (string, error) {
+// - optionally, an idempotency key making the retries of the request return the extension already initiated
+func (api *PrivateExtensionAPI) ExtendContract(ctx context.Context, toExtend common.Address, newRecipientPtmPublicKey string, recipientAddr common.Address, txa ethapi.SendTxArgs, idempotencyKey *string) (string, error) {
+	return api.extendContractOnce(ctx, toExtend, nil, newRecipientPtmPublicKey, recipientAddr, txa, idempotencyKey)
+}
+
+// ExtendContractBundle deploys a new extension management contract extending a contract along with the bundled
+// contracts: once the extension is accepted, the states of all of them are shared with the new participant in a
+// single state share.
+//
+// The state share is applied by the new participant as a whole: if the state of any of the contracts can't be
+// set, none of them is. If the state of any of the contracts can't be fetched when sharing, no state is shared
+// and the failure is logged by the node of the initiator, the extension can then be cancelled.
+//
+// The bundled contracts must be standard private contracts, created by the initiator, which are not already
+// under extension, as must the extended contract.
+func (api *PrivateExtensionAPI) ExtendContractBundle(ctx context.Context, toExtend common.Address, bundledContracts []common.Address, newRecipientPtmPublicKey string, recipientAddr common.Address, txa ethapi.SendTxArgs, idempotencyKey *string) (string, error) {
+	return api.extendContractOnce(ctx, toExtend, bundledContracts, newRecipientPtmPublicKey, recipientAddr, txa, idempotencyKey)
+}
+
+// extendContractOnce initiates the extension, unless the initiator already initiated the extension of the
+// contract in the same private state with the same idempotency key, within extensionRequestTTL, in which case the hash of the
+// transaction creating its management contract is returned. The requests without key are never deduplicated.
+func (api *PrivateExtensionAPI) extendContractOnce(ctx context.Context, toExtend common.Address, bundledContracts []common.Address, newRecipientPtmPublicKey string, recipientAddr common.Address, txa ethapi.SendTxArgs, idempotencyKey *string) (string, error) {
+	if idempotencyKey == nil || *idempotencyKey == "" || api.privacyService.requests == nil {
+		return api.extendContract(ctx, toExtend, bundledContracts, newRecipientPtmPublicKey, recipientAddr, txa)
+	}
+	psm, err := api.privacyService.apiBackendHelper.PSMR().ResolveForUserContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	key := extensionRequestKey{psi: psm.ID, initiator: txa.From, contract: toExtend, nonce: *idempotencyKey}
+	return api.privacyService.requests.submit(ctx, key, func() (string, error) {
+		return api.extendContract(ctx, toExtend, bundledContracts, newRecipientPtmPublicKey, recipientAddr, txa)
+	})
+}
+
+// checkBundledContracts checks that the contracts can be extended in a bundle with the extended contract
+func (api *PrivateExtensionAPI) checkBundledContracts(ctx context.Context, psi types.PrivateStateIdentifier, toExtend common.Address, bundledContracts []common.Address) error {
+	blockHash := api.privacyService.stateFetcher.getCurrentBlockHash()
+	seen := make(map[common.Address]bool, len(bundledContracts))
+	for _, address := range append([]common.Address{toExtend}, bundledContracts...) {
+		if seen[address] {
+			return fmt.Errorf("contract %s given more than once in the bundle", address.Hex())
+		}
+		seen[address] = true
+		privacyMetaData, err := api.privacyService.stateFetcher.GetPrivacyMetaData(blockHash, address, psi)
+		if err == nil && !privacyMetaData.PrivacyFlag.IsStandardPrivate() {
+			return fmt.Errorf("contract %s is not a standard private contract, it can't be extended in a bundle", address.Hex())
+		}
+		if address == toExtend {
+			continue
+		}
+		if api.checkIfContractUnderExtension(ctx, address) {
+			return fmt.Errorf("contract extension in progress for the bundled contract %s", address.Hex())
+		}
+		isPublic, err := api.checkIfPublicContract(address)
+		if err != nil {
+			return err
+		}
+		if isPublic {
+			return fmt.Errorf("bundled contract %s is a public contract, not allowed", address.Hex())
+		}
+		privateContractExists, err := api.checkIfPrivateStateExists(psi, address)
+		if err != nil {
+			return err
+		}
+		if !privateContractExists {
+			return fmt.Errorf("bundled contract %s is a non-existent private contract, not allowed", address.Hex())
+		}
+		if !api.privacyService.CheckIfContractCreator(blockHash, address, psi) {
+			return fmt.Errorf("operation not allowed on the bundled contract %s", address.Hex())
+		}
+	}
+	return nil
+}
+
+func (api *PrivateExtensionAPI) extendContract(ctx context.Context, toExtend common.Address, bundledContracts []common.Address, newRecipientPtmPublicKey string, recipientAddr common.Address, txa ethapi.SendTxArgs) (string, error) {
 	// check if the contract to be extended is already under extension
 	// if yes throw an error
 	if api.checkIfContractUnderExtension(ctx, toExtend) {
@@ -263,6 +373,12 @@ func (api *PrivateExtensionAPI) ExtendContract(ctx context.Context, toExtend com
 		return "", errors.New("operation not allowed")
 	}
 
+	if len(bundledContracts) > 0 {
+		if err := api.checkBundledContracts(ctx, psm.ID, toExtend, bundledContracts); err != nil {
+			return "", err
+		}
+	}
+
 	// if running in permissioned mode with new permissions model
 	// ensure that the account extending the contract is an admin
 	// account and recipient account is an admin account as well
@@ -276,9 +392,14 @@ func (api *PrivateExtensionAPI) ExtendContract(ctx context.Context, toExtend com
 		return "", errors.New("recipient account address is not an org admin account. cannot accept extension")
 	}
 
-	// check the new key is valid
-	if _, err := base64.StdEncoding.DecodeString(newRecipientPtmPublicKey); err != nil {
-		return "", errors.New("invalid new recipient transaction manager key provided")
+	// check the keys are valid, the key of the initiator being the default one of the node if not given
+	if err := validatePtmKey(newRecipientPtmPublicKey); err != nil {
+		return "", fmt.Errorf("new recipient: %w", err)
+	}
+	if txa.PrivateFrom != "" {
+		if err := validatePtmKey(txa.PrivateFrom); err != nil {
+			return "", fmt.Errorf("initiator: %w", err)
+		}
 	}
 
 	// check the the intended new recipient will actually receive the extension request
@@ -299,6 +420,18 @@ func (api *PrivateExtensionAPI) ExtendContract(ctx context.Context, toExtend com
 		txa.PrivateFor = append(txa.PrivateFor, participants...)
 	}
 
+	// check the extension is allowed by the node before submitting it
+	err = api.privacyService.authorizeExtension(ExtensionContract{
+		ContractExtended: toExtend,
+		BundledContracts: bundledContracts,
+		Initiator:        txa.From,
+		Recipient:        recipientAddr,
+		RecipientPtmKey:  newRecipientPtmPublicKey,
+	})
+	if err != nil {
+		return "", err
+	}
+
 	//generate some valid transaction options for sending in the transaction
 	txArgs, err := api.privacyService.GenerateTransactOptions(txa)
 	if err != nil {
@@ -308,8 +441,16 @@ func (api *PrivateExtensionAPI) ExtendContract(ctx context.Context, toExtend com
 	psiManagementContractClient := api.privacyService.managementContract(psm.ID)
 	defer psiManagementContractClient.Close()
 	//Deploy the contract
-	tx, err := psiManagementContractClient.Deploy(txArgs, toExtend, recipientAddr, newRecipientPtmPublicKey)
+	var tx *types.Transaction
+	if len(bundledContracts) > 0 {
+		tx, err = psiManagementContractClient.DeployBundle(txArgs, toExtend, bundledContracts, recipientAddr, newRecipientPtmPublicKey)
+	} else {
+		tx, err = psiManagementContractClient.Deploy(txArgs, toExtend, recipientAddr, newRecipientPtmPublicKey)
+	}
 	if err != nil {
+		if errors.Is(err, ethclient.ErrPrivatePayloadTooLarge) {
+			return "", fmt.Errorf("extension of contract %s rejected: %w", toExtend.Hex(), err)
+		}
 		return "", err
 	}
 
@@ -363,12 +504,7 @@ func (api *PrivateExtensionAPI) CancelExtension(ctx context.Context, extensionCo
 		return "", errNotCreator
 	}
 
-	extender, err := psiManagementContractClient.Transactor(extensionContract)
-	if err != nil {
-		return "", err
-	}
-
-	tx, err := extender.Finish(txArgs)
+	tx, err := api.privacyService.CancelExtension(psm.ID, extensionContract, txArgs)
 	if err != nil {
 		return "", err
 	}
@@ -388,8 +524,51 @@ func (api *PrivateExtensionAPI) GetExtensionStatus(ctx context.Context, extensio
 	}
 
 	if status {
-		return extensionCompleted, nil
+		return api.getFinishedExtensionStatus(extensionContract, psm.ID)
 	}
 
 	return extensionInProgress, nil
 }
+
+// GetStateShareRejection returns why the last state share of the extension managed by the management
+// contract was rejected by the node, the empty string if none was rejected
+func (api *PrivateExtensionAPI) GetStateShareRejection(ctx context.Context, extensionContract common.Address) (string, error) {
+	psm, err := api.privacyService.apiBackendHelper.PSMR().ResolveForUserContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if privacyExtension.DefaultExtensionHandler == nil {
+		return "", nil
+	}
+	if err := privacyExtension.DefaultExtensionHandler.StateShareRejection(psm.ID, extensionContract); err != nil {
+		return err.Error(), nil
+	}
+	return "", nil
+}
+
+// GetThrottleRejection returns why the processing of the extension managed by the management contract
+// was rejected by the throttle of the new extensions, the empty string if it wasn't
+func (api *PrivateExtensionAPI) GetThrottleRejection(ctx context.Context, extensionContract common.Address) (string, error) {
+	psm, err := api.privacyService.apiBackendHelper.PSMR().ResolveForUserContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if api.privacyService.throttle == nil {
+		return "", nil
+	}
+	return api.privacyService.throttle.rejection(psm.ID, extensionContract), nil
+}
+
+// GetExtensionProgress returns how many of the recipients of the in-flight extension have had the state
+// shared with them
+func (api *PrivateExtensionAPI) GetExtensionProgress(ctx context.Context, extensionContract common.Address) (*ExtensionProgress, error) {
+	psm, err := api.privacyService.apiBackendHelper.PSMR().ResolveForUserContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	progress, ok := api.privacyService.ExtensionProgress(psm.ID, extensionContract)
+	if !ok {
+		return nil, errExtensionNotInFlight
+	}
+	return &progress, nil
+}
@@ -1,20 +1,25 @@
 package extension
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/big"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/kisexp/xdchain/accounts"
 	"github.com/kisexp/xdchain/accounts/abi/bind"
 	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/state"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/ethclient"
 	"github.com/kisexp/xdchain/event"
 	"github.com/kisexp/xdchain/extension/extensionContracts"
+	"github.com/kisexp/xdchain/extension/privacyExtension"
 	"github.com/kisexp/xdchain/internal/ethapi"
 	"github.com/kisexp/xdchain/log"
 	"github.com/kisexp/xdchain/node"
@@ -29,14 +34,75 @@ type PrivacyService struct {
 	accountManager   *accounts.Manager
 	dataHandler      DataHandler
 	stopFeed         event.Feed
+	stateShareFeed   event.Feed     // posts the privacyExtension.StateShareAppliedEvent
+	watchers         sync.WaitGroup // tracks the running log watchers
 	apiBackendHelper APIBackendHelper
+	config           Config
 
-	mu           sync.Mutex
+	// mu guards the tracked extensions, read by the APIs while the watchers update them
+	mu           sync.RWMutex
 	psiContracts map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract
 
+	// watermarks holds the last block number processed by each log watcher of a PSI
+	watermarkMu      sync.Mutex
+	watermarks       map[types.PrivateStateIdentifier]map[string]uint64
+	watermarkVersion uint64 // incremented on each change of the watermarks
+	// heldWatermarks keeps the watermarks of the watchers below the logs they haven't processed yet
+	heldWatermarks map[types.PrivateStateIdentifier]map[string]*heldWatermark
+	// watermarkLogs holds the logs handled by each log watcher of a PSI in the blocks above its watermark,
+	// they are skipped when the blocks are replayed
+	watermarkLogs map[types.PrivateStateIdentifier]map[string]map[HandledLog]struct{}
+
+	// watermarkSaveMu serializes the writes of the watermarks, savedWatermarkVersion is the last written version
+	watermarkSaveMu       sync.Mutex
+	savedWatermarkVersion uint64
+
+	// handleMu serialises the handling of the logs by the live watchers and the backfills, handledLogs
+	// records the logs handled while at least one backfill is running
+	handleMu    sync.Mutex
+	backfills   int
+	handledLogs map[handledLog]struct{}
+
+	// unknownTopics counts the logs of the management contracts whose topic isn't a known event
+	unknownTopicsMu sync.Mutex
+	unknownTopics   map[common.Hash]uint64
+
+	// throttle limits the rate of the new extensions processed
+	throttle *extensionThrottle
+
+	// requests remembers the extensions initiated for the idempotency keys supplied by the clients
+	requests *extensionRequests
+
+	// pauseMu guards the pause of the processing of the logs, pausedLogs holds the handling of the logs
+	// received while paused and dropped counts the logs dropped since the start of the pause. The logs are
+	// still buffered while resuming, until the buffered ones are processed. resumeMu serializes the resumes
+	pauseMu    sync.Mutex
+	paused     bool
+	resuming   bool
+	pausedLogs []func()
+	dropped    uint64
+	resumeMu   sync.Mutex
+
+	// stateShareHook is called with the state shares applied, set by SetStateShareAppliedHook
+	stateShareHook stateShareHook
+
 	node *node.Node
 }
 
+// handledLog identifies a log handled for a PSI
+type handledLog struct {
+	psi    types.PrivateStateIdentifier
+	txHash common.Hash
+	index  uint
+}
+
+// heldWatermark keeps the watermark of a watcher below the logs it has accepted, or rejected, without
+// processing them yet, so that they are replayed after a restart
+type heldWatermark struct {
+	logs      map[handledLog]uint64 // the block numbers of the held logs
+	processed uint64                // the highest block marked processed while held
+}
+
 var (
 	//default gas limit to use if not passed in sendTxArgs
 	defaultGasLimit = uint64(4712384)
@@ -45,6 +111,15 @@ var (
 
 	//Private participants must be specified for contract extension related transactions
 	errNotPrivate = errors.New("must specify private participants")
+
+	//An extension can't be cancelled once its state has been shared
+	errStateAlreadyShared = errors.New("contract extension state already shared. nothing to cancel")
+
+	//maximum time to wait for the log watchers to exit when unsubscribing
+	watcherShutdownTimeout = 10 * time.Second
+
+	//number of state share applied events buffered for each subscriber
+	stateShareAppliedEventBuffer = 16
 )
 
 // to signal all watches when service is stopped
@@ -57,7 +132,28 @@ func (service *PrivacyService) newEthClient(psi types.PrivateStateIdentifier) *e
 		// AttachWithPSI does not return non-nil error. This is just a defensive check
 		panic("this should not happen: " + err.Error())
 	}
-	return ethclient.NewClientWithPTM(rpcClient, service.ptm)
+	client := ethclient.NewClientWithPTM(rpcClient, service.ptm)
+	client.SetMaxPrivatePayloadSize(service.config.MaxPrivatePayloadSize)
+	return client
+}
+
+// checkPayloadSize rejects a payload for the private transaction manager exceeding the configured limit
+func (service *PrivacyService) checkPayloadSize(contractToExtend common.Address, payload []byte) error {
+	if limit := service.config.MaxPrivatePayloadSize; limit > 0 && len(payload) > limit {
+		return fmt.Errorf("extension of contract %s rejected: %w: %d bytes exceeds the limit of %d bytes", contractToExtend.Hex(), ethclient.ErrPrivatePayloadTooLarge, len(payload), limit)
+	}
+	return nil
+}
+
+// ErrExtensionNotAuthorized is returned when the configured authorizer rejects the initiation of an extension
+var ErrExtensionNotAuthorized = errors.New("extension not authorized")
+
+// authorizeExtension rejects an extension not allowed by the configured authorizer
+func (service *PrivacyService) authorizeExtension(extension ExtensionContract) error {
+	if authorize := service.config.AuthorizeExtension; authorize != nil && !authorize(extension) {
+		return fmt.Errorf("extension of contract %s by %s rejected: %w", extension.ContractExtended.Hex(), extension.Initiator.Hex(), ErrExtensionNotAuthorized)
+	}
+	return nil
 }
 
 func (service *PrivacyService) client(psi types.PrivateStateIdentifier) Client {
@@ -68,13 +164,273 @@ func (service *PrivacyService) managementContract(psi types.PrivateStateIdentifi
 	return NewManagementContractFacade(service.newEthClient(psi))
 }
 
+// reconnectBackoff returns the wait before the attempts to subscribe again to the logs of the extension events
+func (service *PrivacyService) reconnectBackoff() reconnectBackoff {
+	return reconnectBackoff{initial: reconnectInitialBackoff, max: reconnectMaxBackoff, maxJitter: service.config.ReconnectMaxJitter}
+}
+
 func (service *PrivacyService) subscribeStopEvent() (chan stopEvent, event.Subscription) {
-	c := make(chan stopEvent)
+	// buffered so that sending the stop event doesn't block on a watcher busy handling a log
+	c := make(chan stopEvent, 1)
 	s := service.stopFeed.Subscribe(c)
 	return c, s
 }
 
-func New(stack *node.Node, ptm private.PrivateTransactionManager, manager *accounts.Manager, handler DataHandler, fetcher *StateFetcher, apiBackendHelper APIBackendHelper) (*PrivacyService, error) {
+// SubscribeStateShareAppliedEvent subscribes to the state shares applied to the private states of the node,
+// the events are delivered in the order the shares are applied. The state is applied while processing the
+// block sharing it, so the subscriber must keep up with the events.
+func (service *PrivacyService) SubscribeStateShareAppliedEvent() (chan privacyExtension.StateShareAppliedEvent, event.Subscription) {
+	c := make(chan privacyExtension.StateShareAppliedEvent, stateShareAppliedEventBuffer)
+	s := service.stateShareFeed.Subscribe(c)
+	return c, s
+}
+
+func (service *PrivacyService) postStateShareApplied(ev privacyExtension.StateShareAppliedEvent) {
+	service.stateShareFeed.Send(ev)
+	service.stateShareHook.enqueue(ev)
+}
+
+// resumeBlock returns the block number from which the given watcher should start replaying logs.
+// It returns false if the watcher has never processed any log.
+func (service *PrivacyService) resumeBlock(psi types.PrivateStateIdentifier, watcher string) (uint64, bool) {
+	service.watermarkMu.Lock()
+	defer service.watermarkMu.Unlock()
+
+	processed, ok := service.watermarks[psi][watcher]
+	if !ok {
+		return 0, false
+	}
+	return processed + 1, true
+}
+
+// markProcessed records that the given watcher has processed the logs of the block
+func (service *PrivacyService) markProcessed(psi types.PrivateStateIdentifier, watcher string, blockNumber uint64) {
+	service.updateWatermark(psi, watcher, blockNumber, nil)
+}
+
+// markHandled records that the given watcher has handled the log. The logs after it in its block may not
+// have been handled yet, so only the blocks before are recorded as processed: the block of the log is
+// recorded once a log of a later block is handled or the head is scanned. Until then the log is recorded
+// as handled, so that it is skipped when its block is replayed.
+func (service *PrivacyService) markHandled(psi types.PrivateStateIdentifier, watcher string, l types.Log) {
+	var processed uint64
+	if l.BlockNumber > 0 {
+		processed = l.BlockNumber - 1
+	}
+	service.updateWatermark(psi, watcher, processed, &l)
+}
+
+// isHandled returns whether the given watcher has already handled the log, in a block above its watermark
+func (service *PrivacyService) isHandled(psi types.PrivateStateIdentifier, watcher string, l types.Log) bool {
+	service.watermarkMu.Lock()
+	defer service.watermarkMu.Unlock()
+
+	_, ok := service.watermarkLogs[psi][watcher][HandledLog{BlockNumber: l.BlockNumber, TxHash: l.TxHash, Index: l.Index}]
+	return ok
+}
+
+// updateWatermark moves the watermark of the watcher up to the block and records the handled log, if any,
+// until the watermark reaches its block. The watermarks are saved without holding the lock used to read
+// them, a save is skipped if newer watermarks have been saved.
+// The watermark stays below the logs held by holdWatermark, the block is recorded once they are released.
+func (service *PrivacyService) updateWatermark(psi types.PrivateStateIdentifier, watcher string, blockNumber uint64, handled *types.Log) {
+	service.watermarkMu.Lock()
+
+	moved := true
+	if held := service.heldWatermarks[psi][watcher]; held != nil && len(held.logs) > 0 {
+		if blockNumber > held.processed {
+			held.processed = blockNumber
+		}
+		for _, heldBlock := range held.logs {
+			if heldBlock <= blockNumber {
+				if heldBlock == 0 {
+					moved = false
+					break
+				}
+				blockNumber = heldBlock - 1
+			}
+		}
+	}
+	if service.watermarks == nil {
+		service.watermarks = make(map[types.PrivateStateIdentifier]map[string]uint64)
+	}
+	if service.watermarks[psi] == nil {
+		service.watermarks[psi] = make(map[string]uint64)
+	}
+	processed, ok := service.watermarks[psi][watcher]
+	if ok && processed >= blockNumber {
+		moved = false
+	}
+	if moved {
+		processed, ok = blockNumber, true
+		service.watermarks[psi][watcher] = processed
+		for l := range service.watermarkLogs[psi][watcher] {
+			if l.BlockNumber <= processed {
+				delete(service.watermarkLogs[psi][watcher], l)
+			}
+		}
+	}
+	recorded := false
+	if handled != nil && (!ok || handled.BlockNumber > processed) {
+		if service.watermarkLogs == nil {
+			service.watermarkLogs = make(map[types.PrivateStateIdentifier]map[string]map[HandledLog]struct{})
+		}
+		if service.watermarkLogs[psi] == nil {
+			service.watermarkLogs[psi] = make(map[string]map[HandledLog]struct{})
+		}
+		if service.watermarkLogs[psi][watcher] == nil {
+			service.watermarkLogs[psi][watcher] = make(map[HandledLog]struct{})
+		}
+		service.watermarkLogs[psi][watcher][HandledLog{BlockNumber: handled.BlockNumber, TxHash: handled.TxHash, Index: handled.Index}] = struct{}{}
+		recorded = true
+	}
+	if !moved && !recorded {
+		service.watermarkMu.Unlock()
+		return
+	}
+	service.watermarkVersion++
+	version, watermarks, handledLogs := service.watermarkVersion, copyWatermarks(service.watermarks), copyWatermarkLogs(service.watermarkLogs)
+	service.watermarkMu.Unlock()
+
+	// written outside of the critical section, a newer version may already have been written. The watermarks
+	// are written first: if the node stops in between, the handled logs missing are handled again.
+	service.watermarkSaveMu.Lock()
+	defer service.watermarkSaveMu.Unlock()
+	if version <= service.savedWatermarkVersion {
+		return
+	}
+	if err := service.dataHandler.SaveWatermarks(watermarks); err != nil {
+		log.Error("Failed to store extension watcher watermarks", "error", err)
+		return
+	}
+	if err := service.dataHandler.SaveHandledLogs(handledLogs); err != nil {
+		log.Error("Failed to store extension watcher handled logs", "error", err)
+		return
+	}
+	service.savedWatermarkVersion = version
+}
+
+// holdWatermark keeps the watermark of the watcher below the block of the log until it is released, e.g.
+// while the log waits for its turn to be processed
+func (service *PrivacyService) holdWatermark(psi types.PrivateStateIdentifier, watcher string, l types.Log) {
+	service.watermarkMu.Lock()
+	defer service.watermarkMu.Unlock()
+
+	if service.heldWatermarks == nil {
+		service.heldWatermarks = make(map[types.PrivateStateIdentifier]map[string]*heldWatermark)
+	}
+	if service.heldWatermarks[psi] == nil {
+		service.heldWatermarks[psi] = make(map[string]*heldWatermark)
+	}
+	held := service.heldWatermarks[psi][watcher]
+	if held == nil {
+		held = &heldWatermark{logs: make(map[handledLog]uint64)}
+		service.heldWatermarks[psi][watcher] = held
+	}
+	held.logs[handledLog{psi: psi, txHash: l.TxHash, index: l.Index}] = l.BlockNumber
+}
+
+// releaseWatermark releases the log held by holdWatermark once processed, the watermark of the watcher then
+// moves up to the highest block marked processed in the meantime, or below the logs still held
+func (service *PrivacyService) releaseWatermark(psi types.PrivateStateIdentifier, watcher string, l types.Log) {
+	service.watermarkMu.Lock()
+	held := service.heldWatermarks[psi][watcher]
+	if held == nil {
+		service.watermarkMu.Unlock()
+		return
+	}
+	delete(held.logs, handledLog{psi: psi, txHash: l.TxHash, index: l.Index})
+	processed := held.processed
+	if len(held.logs) == 0 {
+		delete(service.heldWatermarks[psi], watcher)
+	}
+	service.watermarkMu.Unlock()
+
+	if processed > 0 {
+		service.markProcessed(psi, watcher, processed)
+	}
+}
+
+// copyWatermarkLogs returns the logs handled above the watermarks, sorted by block, transaction and index
+func copyWatermarkLogs(watermarkLogs map[types.PrivateStateIdentifier]map[string]map[HandledLog]struct{}) map[types.PrivateStateIdentifier]map[string][]HandledLog {
+	cpy := make(map[types.PrivateStateIdentifier]map[string][]HandledLog, len(watermarkLogs))
+	for psi, psiLogs := range watermarkLogs {
+		cpy[psi] = make(map[string][]HandledLog, len(psiLogs))
+		for watcher, logs := range psiLogs {
+			sorted := make([]HandledLog, 0, len(logs))
+			for l := range logs {
+				sorted = append(sorted, l)
+			}
+			sort.Slice(sorted, func(i, j int) bool {
+				if sorted[i].BlockNumber != sorted[j].BlockNumber {
+					return sorted[i].BlockNumber < sorted[j].BlockNumber
+				}
+				if sorted[i].TxHash != sorted[j].TxHash {
+					return bytes.Compare(sorted[i].TxHash[:], sorted[j].TxHash[:]) < 0
+				}
+				return sorted[i].Index < sorted[j].Index
+			})
+			cpy[psi][watcher] = sorted
+		}
+	}
+	return cpy
+}
+
+// loadWatermarks reads back the watermarks of the log watchers and the logs handled above them. The handled
+// logs of the blocks already covered by the watermarks are dropped, they have been written before the
+// watermarks moved.
+func (service *PrivacyService) loadWatermarks() error {
+	watermarks, err := service.dataHandler.LoadWatermarks()
+	if err != nil {
+		return err
+	}
+	handledLogs, err := service.dataHandler.LoadHandledLogs()
+	if err != nil {
+		return err
+	}
+	watermarkLogs := make(map[types.PrivateStateIdentifier]map[string]map[HandledLog]struct{})
+	for psi, psiLogs := range handledLogs {
+		for watcher, logs := range psiLogs {
+			processed, ok := watermarks[psi][watcher]
+			if !ok {
+				continue
+			}
+			for _, l := range logs {
+				if l.BlockNumber <= processed {
+					continue
+				}
+				if watermarkLogs[psi] == nil {
+					watermarkLogs[psi] = make(map[string]map[HandledLog]struct{})
+				}
+				if watermarkLogs[psi][watcher] == nil {
+					watermarkLogs[psi][watcher] = make(map[HandledLog]struct{})
+				}
+				watermarkLogs[psi][watcher][l] = struct{}{}
+			}
+		}
+	}
+
+	service.watermarkMu.Lock()
+	defer service.watermarkMu.Unlock()
+	service.watermarks, service.watermarkLogs = watermarks, watermarkLogs
+	return nil
+}
+
+func copyWatermarks(watermarks map[types.PrivateStateIdentifier]map[string]uint64) map[types.PrivateStateIdentifier]map[string]uint64 {
+	cpy := make(map[types.PrivateStateIdentifier]map[string]uint64, len(watermarks))
+	for psi, psiWatermarks := range watermarks {
+		cpy[psi] = make(map[string]uint64, len(psiWatermarks))
+		for watcher, processed := range psiWatermarks {
+			cpy[psi][watcher] = processed
+		}
+	}
+	return cpy
+}
+
+func New(stack *node.Node, ptm private.PrivateTransactionManager, manager *accounts.Manager, handler DataHandler, fetcher *StateFetcher, apiBackendHelper APIBackendHelper, config Config) (*PrivacyService, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	service := &PrivacyService{
 		psiContracts:     make(map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract),
 		ptm:              ptm,
@@ -82,6 +438,9 @@ func New(stack *node.Node, ptm private.PrivateTransactionManager, manager *accou
 		stateFetcher:     fetcher,
 		accountManager:   manager,
 		apiBackendHelper: apiBackendHelper,
+		config:           config,
+		throttle:         newExtensionThrottle(config),
+		requests:         newExtensionRequests(extensionRequestTTL),
 		node:             stack,
 	}
 
@@ -90,6 +449,9 @@ func New(stack *node.Node, ptm private.PrivateTransactionManager, manager *accou
 	if err != nil {
 		return nil, errors.New("could not load existing extension contracts: " + err.Error())
 	}
+	if err := service.loadWatermarks(); err != nil {
+		return nil, errors.New("could not load extension watcher watermarks: " + err.Error())
+	}
 
 	// Register service to node
 	stack.RegisterAPIs(service.apis())
@@ -98,20 +460,119 @@ func New(stack *node.Node, ptm private.PrivateTransactionManager, manager *accou
 	return service, nil
 }
 
-func (service *PrivacyService) watchForNewContracts(psi types.PrivateStateIdentifier) error {
-	handler := NewSubscriptionHandler(service.node, psi, service.ptm, service)
+// watchExtensionEvents subscribes once to all the events of the given extension management contracts
+// of the PSI, or of any management contract if none is given
+func (service *PrivacyService) watchExtensionEvents(psi types.PrivateStateIdentifier, managementContracts []common.Address) error {
+	handler, err := NewSubscriptionHandler(service.node, psi, service.ptm, service, managementContracts...)
+	if err != nil {
+		return err
+	}
+	if err := handler.createTopicsSub(handler.ManagementContracts(), service.extensionWatchers(psi)); err != nil {
+		return err
+	}
+	if service.config.WatchUnknownTopics {
+		return handler.watchUnknownTopics(handler.ManagementContracts())
+	}
+	return nil
+}
+
+// isTracked checks if the management contract is the one of a tracked extension of the PSI
+func (service *PrivacyService) isTracked(psi types.PrivateStateIdentifier, managementContract common.Address) bool {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	_, ok := service.psiContracts[psi][managementContract]
+	return ok
+}
+
+// recordUnknownTopic counts the log of a management contract whose topic isn't a known event, a warning
+// is logged the first time a topic is seen
+func (service *PrivacyService) recordUnknownTopic(psi types.PrivateStateIdentifier, l types.Log) {
+	var topic common.Hash
+	if len(l.Topics) > 0 {
+		topic = l.Topics[0]
+	}
+	service.unknownTopicsMu.Lock()
+	defer service.unknownTopicsMu.Unlock()
+	if service.unknownTopics == nil {
+		service.unknownTopics = make(map[common.Hash]uint64)
+	}
+	service.unknownTopics[topic]++
+	if service.unknownTopics[topic] == 1 {
+		log.Warn("Extension: management contract emitted an unknown event, the management contract may have been upgraded", "psi", psi, "managementContract", l.Address, "topic", topic, "blockNumber", l.BlockNumber)
+	} else {
+		log.Debug("Extension: management contract emitted an unknown event", "psi", psi, "managementContract", l.Address, "topic", topic, "blockNumber", l.BlockNumber)
+	}
+}
+
+// throttleNewExtension waits until the new extension managed by the management contract can begin processing
+// according to the rate limit of the new extensions, the returned error wraps ErrExtensionThrottled if the
+// extension is rejected. The extensions already tracked aren't throttled.
+func (service *PrivacyService) throttleNewExtension(psi types.PrivateStateIdentifier, managementContract common.Address) error {
+	queued, err := service.reserveNewExtension(psi, managementContract)
+	if err != nil || !queued {
+		return err
+	}
+	stopChan, stopSubscription := service.subscribeStopEvent()
+	defer stopSubscription.Unsubscribe()
+	return service.throttle.waitQueued(psi, managementContract, stopChan)
+}
+
+// reserveNewExtension is like throttleNewExtension but doesn't wait: the queued extension must then wait
+// for its turn with the waitQueued method of the throttle.
+func (service *PrivacyService) reserveNewExtension(psi types.PrivateStateIdentifier, managementContract common.Address) (queued bool, err error) {
+	if service.throttle == nil || service.throttle.limit <= 0 || service.isTracked(psi, managementContract) {
+		return false, nil
+	}
+	return service.throttle.reserve(psi, managementContract)
+}
+
+// UnknownTopics returns the number of logs of the management contracts seen for each topic which isn't an
+// event of the management contract ABI, the logs without topic are counted under the empty hash. Logs are
+// only watched for unknown topics if enabled by the config.
+func (service *PrivacyService) UnknownTopics() map[common.Hash]uint64 {
+	service.unknownTopicsMu.Lock()
+	defer service.unknownTopicsMu.Unlock()
+	counts := make(map[common.Hash]uint64, len(service.unknownTopics))
+	for topic, count := range service.unknownTopics {
+		counts[topic] = count
+	}
+	return counts
+}
+
+// extensionWatchers returns the watchers of the extension events of the PSI enabled by the config
+func (service *PrivacyService) extensionWatchers(psi types.PrivateStateIdentifier) []topicWatcher {
+	var watchers []topicWatcher
+	for _, w := range []topicWatcher{
+		service.newContractsWatcher(psi),       // watch for new extension contract creation event
+		service.cancelledContractsWatcher(psi), // watch for extension contract cancellation event
+		service.completionEventsWatcher(psi),   // watch for extension contract voting complete event
+	} {
+		if service.config.queryEnabled(w.queryType) {
+			watchers = append(watchers, w)
+		}
+	}
+	return watchers
+}
 
-	cb := func(foundLog types.Log) {
+func (service *PrivacyService) newContractsWatcher(psi types.PrivateStateIdentifier) topicWatcher {
+	cb := func(logger log.Logger, foundLog types.Log) {
 		service.mu.Lock()
+		tracked, ok := service.psiContracts[psi][foundLog.Address]
+		if ok && tracked.CreationBlockHash == foundLog.BlockHash {
+			// already handled, e.g. the log has been replayed after a restart
+			logger.Debug("Extension: extension contract already tracked", "address", foundLog.Address.Hex())
+			service.mu.Unlock()
+			return
+		}
 		psiClient := service.client(psi)
 		defer psiClient.Close()
 		tx, _ := service.client(psi).TransactionInBlock(foundLog.BlockHash, foundLog.TxIndex)
 		from, _ := types.QuorumPrivateTxSigner{}.Sender(tx)
 
-		newExtensionEvent, err := extensionContracts.UnpackNewExtensionCreatedLog(foundLog.Data)
+		newExtensionEvent, err := extensionContracts.UnpackNewExtensionCreatedLogStrict(foundLog.Data)
 		if err != nil {
-			log.Error("Error unpacking extension creation log", "error", err)
-			log.Debug("Errored log", foundLog)
+			logger.Error("Error unpacking extension creation log", "error", err)
+			logger.Debug("Errored log", foundLog)
 			service.mu.Unlock()
 			return
 		}
@@ -123,27 +584,36 @@ func (service *PrivacyService) watchForNewContracts(psi types.PrivateStateIdenti
 			RecipientPtmKey:           newExtensionEvent.RecipientPTMKey,
 			ManagementContractAddress: foundLog.Address,
 			CreationData:              tx.Data(),
+			CreationBlockHash:         foundLog.BlockHash,
 		}
 
 		enclaveKey := common.BytesToEncryptedPayloadHash(tx.Data())
-		privateFrom, _, _, _, err := service.ptm.Receive(enclaveKey)
+		privateFrom, _, creationPayload, _, err := service.ptm.Receive(enclaveKey)
 		if err != nil {
-			log.Error("Error receiving private payload", "error", err)
+			logger.Error("Error receiving private payload", "error", err)
 			service.mu.Unlock()
 			return
 		}
-
-		if service.psiContracts[psi] == nil {
-			service.psiContracts[psi] = make(map[common.Address]*ExtensionContract)
-		}
-		service.psiContracts[psi][foundLog.Address] = &newContractExtension
-
-		if err := service.dataHandler.Save(service.psiContracts); err != nil {
-			log.Error("Error writing extension data to file", "error", err)
+		newContractExtension.BundledContracts, err = extensionContracts.UnpackBundledContracts(creationPayload)
+		if err != nil {
+			logger.Error("Error unpacking contracts bundled with the extension", "error", err)
 			service.mu.Unlock()
 			return
 		}
+
+		reconciled, err := service.trackExtension(psi, &newContractExtension)
 		service.mu.Unlock()
+		if reconciled {
+			// the creation event has been emitted again in another block, e.g. after a reorg: the extension
+			// has been voted for already, only its views are reconciled
+			logger.Info("Extension: reconciled extension contract views", "address", foundLog.Address.Hex(), "blockHash", foundLog.BlockHash, "trackedBlockHash", tracked.CreationBlockHash)
+		}
+		if err != nil {
+			logger.Error("Error writing extension data to file", "error", err)
+		}
+		if reconciled || err != nil {
+			return
+		}
 
 		// if party is sender then complete self voting
 
@@ -152,7 +622,7 @@ func (service *PrivacyService) watchForNewContracts(psi types.PrivateStateIdenti
 		if isSender {
 			fetchedParties, err := service.ptm.GetParticipants(enclaveKey)
 			if err != nil || len(fetchedParties) == 0 {
-				log.Error("Extension: unable to fetch all parties for extension management contract", "error", err)
+				logger.Error("Extension: unable to fetch all parties for extension management contract", "error", err)
 				return
 			}
 
@@ -174,36 +644,35 @@ func (service *PrivacyService) watchForNewContracts(psi types.PrivateStateIdenti
 			_, err = extensionAPI.ApproveExtension(ctx, newContractExtension.ManagementContractAddress, true, txArgs)
 
 			if err != nil {
-				log.Error("Extension: initiator vote on management contract failed", "error", err)
+				logger.Error("Extension: initiator vote on management contract failed", "error", err)
 			}
 		}
 	}
 
-	return handler.createSub(newExtensionQuery, cb)
+	return topicWatcher{queryType: newExtensionQueryType, topic: common.HexToHash(extensionContracts.NewContractExtensionContractCreatedTopicHash), handle: cb, throttled: true}
 }
 
-func (service *PrivacyService) watchForCancelledContracts(psi types.PrivateStateIdentifier) error {
-	handler := NewSubscriptionHandler(service.node, psi, service.ptm, service)
+// isCanonical checks whether the block is part of the canonical chain, no block is if the chain can't be read
+func (service *PrivacyService) isCanonical(blockHash common.Hash) bool {
+	if service.stateFetcher == nil || service.stateFetcher.chainAccessor == nil {
+		return false
+	}
+	return service.stateFetcher.isCanonical(blockHash)
+}
 
-	cb := func(l types.Log) {
+func (service *PrivacyService) cancelledContractsWatcher(psi types.PrivateStateIdentifier) topicWatcher {
+	cb := func(_ log.Logger, l types.Log) {
 		service.mu.Lock()
-		if _, ok := service.psiContracts[psi][l.Address]; ok {
-			delete(service.psiContracts[psi], l.Address)
-			if err := service.dataHandler.Save(service.psiContracts); err != nil {
-				log.Error("Failed to store list of contracts being extended", "error", err)
-			}
-		}
+		service.untrackExtension(psi, l.Address)
 		service.mu.Unlock()
 	}
 
-	return handler.createSub(finishedExtensionQuery, cb)
+	return topicWatcher{queryType: finishedExtensionQueryType, topic: common.HexToHash(extensionContracts.ExtensionFinishedTopicHash), handle: cb}
 }
 
-func (service *PrivacyService) watchForCompletionEvents(psi types.PrivateStateIdentifier) error {
-	handler := NewSubscriptionHandler(service.node, psi, service.ptm, service)
-
-	cb := func(l types.Log) {
-		log.Debug("Extension: Received a completion event", "address", l.Address.Hex(), "blockNumber", l.BlockNumber)
+func (service *PrivacyService) completionEventsWatcher(psi types.PrivateStateIdentifier) topicWatcher {
+	cb := func(logger log.Logger, l types.Log) {
+		logger.Debug("Extension: Received a completion event", "address", l.Address.Hex(), "blockNumber", l.BlockNumber)
 		service.mu.Lock()
 		defer func() {
 			service.mu.Unlock()
@@ -211,7 +680,12 @@ func (service *PrivacyService) watchForCompletionEvents(psi types.PrivateStateId
 		extensionEntry, ok := service.psiContracts[psi][l.Address]
 		if !ok {
 			// we didn't have this management contract, so ignore it
-			log.Debug("Extension: this node doesn't participate in the contract extender", "address", l.Address.Hex())
+			logger.Debug("Extension: this node doesn't participate in the contract extender", "address", l.Address.Hex())
+			return
+		}
+		if extensionEntry.StateShared {
+			// the event is replayed after a restart, the state has been shared already
+			logger.Debug("Extension: state already shared", "address", l.Address.Hex())
 			return
 		}
 
@@ -220,17 +694,17 @@ func (service *PrivacyService) watchForCompletionEvents(psi types.PrivateStateId
 		//Find the extension contract in order to interact with it
 		caller, err := psiManagementContractClient.Caller(l.Address)
 		if err != nil {
-			log.Error("service.managementContractFacade.Caller", "address", l.Address.Hex(), "error", err)
+			logger.Error("service.managementContractFacade.Caller", "address", l.Address.Hex(), "error", err)
 			return
 		}
 		contractCreator, err := caller.Creator(nil)
 		if err != nil {
-			log.Error("[contract] caller.Creator", "error", err)
+			logger.Error("[contract] caller.Creator", "error", err)
 			return
 		}
-		log.Debug("Extension: check if this node has the account that created the contract extender", "account", contractCreator)
+		logger.Debug("Extension: check if this node has the account that created the contract extender", "account", contractCreator)
 		if _, err := service.accountManager.Find(accounts.Account{Address: contractCreator}); err != nil {
-			log.Warn("Account used to sign extension contract no longer available", "account", contractCreator.Hex())
+			logger.Warn("Account used to sign extension contract no longer available", "account", contractCreator.Hex())
 			return
 		}
 
@@ -238,21 +712,21 @@ func (service *PrivacyService) watchForCompletionEvents(psi types.PrivateStateId
 		payload := common.BytesToEncryptedPayloadHash(extensionEntry.CreationData)
 		fetchedParties, err := service.ptm.GetParticipants(payload)
 		if err != nil || len(fetchedParties) == 0 {
-			log.Error("Extension: Unable to fetch all parties for extension management contract", "error", err)
+			logger.Error("Extension: Unable to fetch all parties for extension management contract", "error", err)
 			return
 		}
-		log.Debug("Extension: able to fetch all parties", "parties", fetchedParties)
+		logger.Debug("Extension: able to fetch all parties", "parties", fetchedParties)
 
 		privateFrom, _, _, _, err := service.ptm.Receive(payload)
 		if err != nil || len(privateFrom) == 0 {
-			log.Error("Extension: unable to fetch privateFrom(sender) for extension management contract", "error", err)
+			logger.Error("Extension: unable to fetch privateFrom(sender) for extension management contract", "error", err)
 			return
 		}
-		log.Debug("Extension: able to fetch privateFrom(sender)", "privateFrom", privateFrom)
+		logger.Debug("Extension: able to fetch privateFrom(sender)", "privateFrom", privateFrom)
 
 		txPsi, err := service.apiBackendHelper.PSMR().ResolveForManagedParty(privateFrom)
 		if err != nil {
-			log.Error("Extension: unable to resolve private state metadata for sender", "error", err)
+			logger.Error("Extension: unable to resolve private state metadata for sender", "error", err)
 			return
 		}
 		if txPsi.ID != psi {
@@ -260,37 +734,45 @@ func (service *PrivacyService) watchForCompletionEvents(psi types.PrivateStateId
 		}
 		txArgs, err := service.GenerateTransactOptions(ethapi.SendTxArgs{From: contractCreator, PrivateTxArgs: ethapi.PrivateTxArgs{PrivateFor: fetchedParties, PrivateFrom: privateFrom}})
 		if err != nil {
-			log.Error("service.accountManager.GenerateTransactOptions", "error", err, "contractCreator", contractCreator.Hex(), "privateFor", fetchedParties)
+			logger.Error("service.accountManager.GenerateTransactOptions", "error", err, "contractCreator", contractCreator.Hex(), "privateFor", fetchedParties)
 			return
 		}
 
 		//we found the account, so we can send
 		contractToExtend, err := caller.ContractToExtend(nil)
 		if err != nil {
-			log.Error("[contract] caller.ContractToExtend", "error", err)
+			logger.Error("[contract] caller.ContractToExtend", "error", err)
 			return
 		}
-		log.Debug("Extension: dump current state", "block", l.BlockHash, "contract", contractToExtend.Hex(), "psi", txPsi.ID)
-		entireStateData, err := service.stateFetcher.GetAddressStateFromBlock(l.BlockHash, contractToExtend, txPsi.ID)
+		// the states of the bundled contracts are shared along with the state of the extended contract,
+		// nothing is shared if the state of any of them can't be fetched
+		contractsToShare := append([]common.Address{contractToExtend}, extensionEntry.BundledContracts...)
+		logger.Debug("Extension: dump current state", "block", l.BlockHash, "contract", contractToExtend.Hex(), "bundled", extensionEntry.BundledContracts, "psi", txPsi.ID)
+		entireStateData, err := service.stateFetcher.GetAddressesStateFromBlock(l.BlockHash, contractsToShare, txPsi.ID)
 		if err != nil {
-			log.Error("[state] service.stateFetcher.GetAddressStateFromBlock", "block", l.BlockHash.Hex(), "contract", contractToExtend.Hex(), "error", err)
+			logger.Error("[state] service.stateFetcher.GetAddressesStateFromBlock", "block", l.BlockHash.Hex(), "contract", contractToExtend.Hex(), "bundled", extensionEntry.BundledContracts, "error", err)
+			return
+		}
+
+		if err := service.checkPayloadSize(contractToExtend, entireStateData); err != nil {
+			logger.Error("Extension: unable to share the state dump", "error", err)
 			return
 		}
 
-		log.Debug("Extension: send the state dump to the new recipient", "recipients", fetchedParties)
+		logger.Debug("Extension: send the state dump to the new recipient", "recipients", fetchedParties)
 
 		// PSV & PP changes
 		// send the new transaction with state dump to all participants
 		extraMetaData := engine.ExtraMetadata{PrivacyFlag: engine.PrivacyFlagStandardPrivate}
 		privacyMetaData, err := service.stateFetcher.GetPrivacyMetaData(l.BlockHash, contractToExtend, txPsi.ID)
 		if err != nil {
-			log.Error("[privacyMetaData] fetch err", "err", err)
+			logger.Error("[privacyMetaData] fetch err", "err", err)
 		} else {
 			extraMetaData.PrivacyFlag = privacyMetaData.PrivacyFlag
 			if privacyMetaData.PrivacyFlag == engine.PrivacyFlagStateValidation {
 				storageRoot, err := service.stateFetcher.GetStorageRoot(l.BlockHash, contractToExtend, txPsi.ID)
 				if err != nil {
-					log.Error("[storageRoot] fetch err", "err", err)
+					logger.Error("[storageRoot] fetch err", "err", err)
 				}
 				extraMetaData.ACMerkleRoot = storageRoot
 			}
@@ -298,10 +780,10 @@ func (service *PrivacyService) watchForCompletionEvents(psi types.PrivateStateId
 			if privacyMetaData.PrivacyFlag == engine.PrivacyFlagMandatoryRecipients {
 				fetchedMandatoryRecipients, err := service.ptm.GetMandatory(privacyMetaData.CreationTxHash)
 				if err != nil || len(fetchedMandatoryRecipients) == 0 {
-					log.Error("Extension: Unable to fetch mandatory parties for extension management contract", "error", err)
+					logger.Error("Extension: Unable to fetch mandatory parties for extension management contract", "error", err)
 					return
 				}
-				log.Debug("Extension: able to fetch mandatory recipients", "mandatory", fetchedMandatoryRecipients)
+				logger.Debug("Extension: able to fetch mandatory recipients", "mandatory", fetchedMandatoryRecipients)
 				extraMetaData.MandatoryRecipients = fetchedMandatoryRecipients
 			}
 		}
@@ -309,25 +791,170 @@ func (service *PrivacyService) watchForCompletionEvents(psi types.PrivateStateId
 		_, _, hashOfStateData, err := service.ptm.Send(entireStateData, privateFrom, fetchedParties, &extraMetaData)
 
 		if err != nil {
-			log.Error("[ptm] service.ptm.Send", "stateDataInHex", hex.EncodeToString(entireStateData[:]), "recipients", fetchedParties, "error", err)
+			logger.Error("[ptm] service.ptm.Send", "stateDataInHex", hex.EncodeToString(entireStateData[:]), "recipients", fetchedParties, "error", err)
 			return
 		}
 		hashofStateDataBase64 := hashOfStateData.ToBase64()
 
 		transactor, err := psiManagementContractClient.Transactor(l.Address)
 		if err != nil {
-			log.Error("service.managementContractFacade.Transactor", "address", l.Address.Hex(), "error", err)
+			logger.Error("service.managementContractFacade.Transactor", "address", l.Address.Hex(), "error", err)
 			return
 		}
-		log.Debug("Extension: store the encrypted payload hash of dump state", "contract", l.Address.Hex())
+		logger.Debug("Extension: store the encrypted payload hash of dump state", "contract", l.Address.Hex())
 		if tx, err := transactor.SetSharedStateHash(txArgs, hashofStateDataBase64); err != nil {
-			log.Error("[contract] transactor.SetSharedStateHash", "error", err, "hashOfStateInBase64", hashofStateDataBase64)
+			logger.Error("[contract] transactor.SetSharedStateHash", "error", err, "hashOfStateInBase64", hashofStateDataBase64)
 		} else {
-			log.Debug("Extension: transaction carrying shared state", "txhash", tx.Hash(), "private", tx.IsPrivate())
+			logger.Debug("Extension: transaction carrying shared state", "txhash", tx.Hash(), "private", tx.IsPrivate())
+			// stored so that the extension can't be cancelled after a restart either
+			extensionEntry.StateShared = true
+			if err := service.dataHandler.Save(service.psiContracts); err != nil {
+				logger.Error("Failed to store list of contracts being extended", "error", err)
+			}
+		}
+	}
+
+	return topicWatcher{queryType: canPerformStateShareQueryType, topic: common.HexToHash(extensionContracts.CanPerformStateShareTopicHash), handle: cb}
+}
+
+// CancelExtension submits the transaction finishing the given extension management contract and
+// stops tracking the extension, so that the watchers ignore any further event emitted for it.
+//
+// Cancelling is serialised with the handling of the CanPerformStateShare events: an extension
+// whose state has already been shared can't be cancelled anymore, and the state of a cancelled
+// extension is never shared.
+func (service *PrivacyService) CancelExtension(psi types.PrivateStateIdentifier, managementContractAddress common.Address, txArgs *bind.TransactOpts) (*types.Transaction, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if extension, ok := service.psiContracts[psi][managementContractAddress]; ok && extension.StateShared {
+		return nil, errStateAlreadyShared
+	}
+
+	psiManagementContractClient := service.managementContract(psi)
+	defer psiManagementContractClient.Close()
+	extender, err := psiManagementContractClient.Transactor(managementContractAddress)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := extender.Finish(txArgs)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Extension: cancelled extension", "address", managementContractAddress.Hex(), "txhash", tx.Hash())
+
+	service.untrackExtension(psi, managementContractAddress)
+	return tx, nil
+}
+
+// ReplayExtension applies again the state shared by the extension of the management contract to the private
+// state of the psi at the head of the chain, e.g. once the private state got corrupted. The shared state is
+// fetched from the transaction manager with the hash stored by the management contract, whether or not the
+// extension is finished. The accounts already in the private state are overwritten.
+//
+// The returned error wraps privacyExtension.ErrStateShareNotFound if the transaction manager doesn't hold
+// the shared state anymore.
+func (service *PrivacyService) ReplayExtension(psi types.PrivateStateIdentifier, managementContractAddress common.Address) error {
+	if privacyExtension.DefaultExtensionHandler == nil {
+		return errors.New("extension handler not initialised")
+	}
+	psiManagementContractClient := service.managementContract(psi)
+	defer psiManagementContractClient.Close()
+	caller, err := psiManagementContractClient.Caller(managementContractAddress)
+	if err != nil {
+		return err
+	}
+	contractExtended, err := caller.ContractToExtend(nil)
+	if err != nil {
+		return err
+	}
+	sharedDataHash, err := caller.SharedDataHash(nil)
+	if err != nil {
+		return err
+	}
+	if sharedDataHash == "" {
+		return fmt.Errorf("no state shared by extension %s", managementContractAddress.Hex())
+	}
+
+	log.Warn("Extension: manual replay of the state share, overriding the accounts of the private state", "managementContract", managementContractAddress.Hex(), "contract", contractExtended.Hex(), "psi", psi, "hash", sharedDataHash)
+	return service.stateFetcher.updateHeadPrivateState(psi, func(privateState *state.StateDB) error {
+		return privacyExtension.DefaultExtensionHandler.ReapplyStateShare(privateState, contractExtended, sharedDataHash)
+	})
+}
+
+// ExtensionProgress returns the progress of the in-flight extension of the management contract, false
+// is returned if the extension isn't in-flight
+func (service *PrivacyService) ExtensionProgress(psi types.PrivateStateIdentifier, managementContractAddress common.Address) (ExtensionProgress, bool) {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+
+	extension, ok := service.psiContracts[psi][managementContractAddress]
+	if !ok {
+		return ExtensionProgress{}, false
+	}
+	return extension.progress(), true
+}
+
+// ExtensionsByInitiator returns the tracked extensions of all the private states which have been started
+// by the address, ordered by management contract
+func (service *PrivacyService) ExtensionsByInitiator(addr common.Address) []ExtensionContract {
+	return service.filterExtensions(func(extension *ExtensionContract) bool {
+		return extension.Initiator == addr
+	})
+}
+
+// ExtensionsByRecipient returns the tracked extensions of all the private states which extend a contract
+// to the address, ordered by management contract
+func (service *PrivacyService) ExtensionsByRecipient(addr common.Address) []ExtensionContract {
+	return service.filterExtensions(func(extension *ExtensionContract) bool {
+		return extension.Recipient == addr
+	})
+}
+
+func (service *PrivacyService) filterExtensions(accept func(extension *ExtensionContract) bool) []ExtensionContract {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+
+	filtered := make([]ExtensionContract, 0)
+	for _, contracts := range service.psiContracts {
+		for _, extension := range contracts {
+			if accept(extension) {
+				filtered = append(filtered, *extension)
+			}
 		}
 	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return bytes.Compare(filtered[i].ManagementContractAddress.Bytes(), filtered[j].ManagementContractAddress.Bytes()) < 0
+	})
+	return filtered
+}
+
+// trackExtension adds the extension to the list of contracts being extended and stores the list. An
+// extension already tracked is reconciled with the new one instead, reconciled is then true.
+// The caller must hold service.mu
+func (service *PrivacyService) trackExtension(psi types.PrivateStateIdentifier, extension *ExtensionContract) (reconciled bool, err error) {
+	if tracked, ok := service.psiContracts[psi][extension.ManagementContractAddress]; ok {
+		extension, reconciled = extension.merge(tracked, service.isCanonical), true
+	}
+	if service.psiContracts[psi] == nil {
+		service.psiContracts[psi] = make(map[common.Address]*ExtensionContract)
+	}
+	service.psiContracts[psi][extension.ManagementContractAddress] = extension
+	return reconciled, service.dataHandler.Save(service.psiContracts)
+}
 
-	return handler.createSub(canPerformStateShareQuery, cb)
+// untrackExtension removes the extension from the list of contracts being extended.
+// The caller must hold service.mu
+func (service *PrivacyService) untrackExtension(psi types.PrivateStateIdentifier, managementContractAddress common.Address) {
+	if privacyExtension.DefaultExtensionHandler != nil {
+		privacyExtension.DefaultExtensionHandler.ForgetAppliedShares(managementContractAddress)
+	}
+	if _, ok := service.psiContracts[psi][managementContractAddress]; ok {
+		delete(service.psiContracts[psi], managementContractAddress)
+		if err := service.dataHandler.Save(service.psiContracts); err != nil {
+			log.Error("Failed to store list of contracts being extended", "error", err)
+		}
+	}
 }
 
 // utility methods
@@ -342,6 +969,72 @@ func (service *PrivacyService) apis() []rpc.API {
 	}
 }
 
+// watchedManagementContracts returns the management contracts of each subscription of a PSI: one
+// subscription per configured management contract, each with its own last processed blocks, or a
+// single subscription to all the management contracts if none is configured
+func (service *PrivacyService) watchedManagementContracts() [][]common.Address {
+	if len(service.config.ManagementContracts) == 0 {
+		return [][]common.Address{nil}
+	}
+	watched := make([][]common.Address, len(service.config.ManagementContracts))
+	for i, managementContract := range service.config.ManagementContracts {
+		watched[i] = []common.Address{managementContract}
+	}
+	return watched
+}
+
+// Backfill handles the extension events emitted between the from and to blocks, inclusive, with the handlers
+// of the live watchers and returns once done, e.g. to index historical extensions. A nil from block is the
+// genesis, a nil to block is the current head.
+//
+// It can run along with the live watchers: a log emitted while the backfill runs is handled once, by either
+// of them. The logs handled by the live watchers before are handled again, the handlers ignore the events
+// already handled, e.g. the creation of an extension already tracked.
+func (service *PrivacyService) Backfill(from, to *big.Int) error {
+	service.handleMu.Lock()
+	if service.backfills == 0 {
+		service.handledLogs = make(map[handledLog]struct{})
+	}
+	service.backfills++
+	service.handleMu.Unlock()
+	defer func() {
+		service.handleMu.Lock()
+		if service.backfills--; service.backfills == 0 {
+			service.handledLogs = nil
+		}
+		service.handleMu.Unlock()
+	}()
+
+	for _, psi := range service.apiBackendHelper.PSMR().PSIs() {
+		handler, err := NewSubscriptionHandler(service.node, psi, service.ptm, service)
+		if err != nil {
+			return err
+		}
+		for _, managementContracts := range service.watchedManagementContracts() {
+			if err := handler.backfill(managementContracts, service.extensionWatchers(psi), from, to); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handleLogOnce calls handle unless the log has already been handled for the psi while backfills are
+// running. The logs are handled one at a time.
+func (service *PrivacyService) handleLogOnce(psi types.PrivateStateIdentifier, l types.Log, handle func()) {
+	service.handleMu.Lock()
+	defer service.handleMu.Unlock()
+
+	if service.handledLogs != nil {
+		key := handledLog{psi: psi, txHash: l.TxHash, index: l.Index}
+		if _, ok := service.handledLogs[key]; ok {
+			return
+		}
+		service.handledLogs[key] = struct{}{}
+	}
+	handle()
+}
+
 // node.Lifecycle interface methods:
 
 func (service *PrivacyService) Start() error {
@@ -350,12 +1043,12 @@ func (service *PrivacyService) Start() error {
 	defer service.mu.Unlock()
 
 	for _, psi := range service.apiBackendHelper.PSMR().PSIs() {
-		for _, f := range []func(identifier types.PrivateStateIdentifier) error{
-			service.watchForNewContracts,       // watch for new extension contract creation event
-			service.watchForCancelledContracts, // watch for extension contract cancellation event
-			service.watchForCompletionEvents,   // watch for extension contract voting complete event
-		} {
-			if err := f(psi); err != nil {
+		for _, managementContracts := range service.watchedManagementContracts() {
+			if err := service.watchExtensionEvents(psi, managementContracts); err != nil {
+				if errors.Is(err, ErrPTMUnavailable) {
+					log.Warn("extension service: contract extension disabled", "error", err)
+					return nil
+				}
 				return err
 			}
 		}
@@ -366,11 +1059,31 @@ func (service *PrivacyService) Start() error {
 
 func (service *PrivacyService) Stop() error {
 	log.Info("extension service: stopping")
-	service.stopFeed.Send(stopEvent{})
+	if err := service.UnsubscribeAll(); err != nil {
+		log.Warn("extension service: watchers did not stop cleanly", "error", err)
+	}
 	log.Info("extension service: stopped")
 	return nil
 }
 
+// UnsubscribeAll stops all the log watchers and waits for them to exit. An error is returned
+// if they are still running after watcherShutdownTimeout.
+func (service *PrivacyService) UnsubscribeAll() error {
+	service.stopFeed.Send(stopEvent{})
+
+	done := make(chan struct{})
+	go func() {
+		service.watchers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(watcherShutdownTimeout):
+		return fmt.Errorf("timed out after %v waiting for extension watchers to stop", watcherShutdownTimeout)
+	}
+}
+
 func (service *PrivacyService) GenerateTransactOptions(txa ethapi.SendTxArgs) (*bind.TransactOpts, error) {
 	if txa.PrivateFor == nil {
 		return nil, errNotPrivate
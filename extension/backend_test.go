@@ -1,17 +1,24 @@
 package extension
 
 import (
+	"errors"
 	"math/big"
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/kisexp/xdchain"
 	"github.com/kisexp/xdchain/accounts"
+	"github.com/kisexp/xdchain/accounts/abi/bind"
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/common/hexutil"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/eth"
+	"github.com/kisexp/xdchain/ethclient"
 	"github.com/kisexp/xdchain/event"
+	"github.com/kisexp/xdchain/extension/privacyExtension"
 	"github.com/kisexp/xdchain/internal/ethapi"
+	"github.com/kisexp/xdchain/log"
 )
 
 type MockBackend struct {
@@ -222,3 +229,368 @@ func TestGenerateTransactionOptionsGivesNonDefaultsWhenSpecified(t *testing.T) {
 		return
 	}
 }
+
+func TestCheckPayloadSize(t *testing.T) {
+	contractToExtend := common.HexToAddress("0x1932c48b2bf8102ba33b4a6b545c32236e342f34")
+	service := &PrivacyService{}
+
+	if err := service.checkPayloadSize(contractToExtend, make([]byte, 1024)); err != nil {
+		t.Errorf("expected no limit to be enforced by default, but was '%s'", err.Error())
+	}
+
+	service.config.MaxPrivatePayloadSize = 16
+	if err := service.checkPayloadSize(contractToExtend, make([]byte, 16)); err != nil {
+		t.Errorf("expected err to be '%s', but was '%s'", "nil", err.Error())
+	}
+
+	err := service.checkPayloadSize(contractToExtend, make([]byte, 17))
+	if err == nil {
+		t.Fatalf("expected err to not be nil")
+	}
+	if !errors.Is(err, ethclient.ErrPrivatePayloadTooLarge) {
+		t.Errorf("expected err to wrap '%s', but was '%s'", ethclient.ErrPrivatePayloadTooLarge, err.Error())
+	}
+	expectedErr := "extension of contract 0x1932c48b2bF8102Ba33B4A6B545C32236e342f34 rejected: private payload too large: 17 bytes exceeds the limit of 16 bytes"
+	if err.Error() != expectedErr {
+		t.Errorf("expected err to be '%s', but was '%s'", expectedErr, err.Error())
+	}
+}
+
+func TestAuthorizeExtension(t *testing.T) {
+	owner := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	extension := ExtensionContract{
+		ContractExtended: common.HexToAddress("0x1932c48b2bf8102ba33b4a6b545c32236e342f34"),
+		Initiator:        common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		Recipient:        common.HexToAddress("0x3333333333333333333333333333333333333333"),
+	}
+	service := &PrivacyService{}
+
+	if err := service.authorizeExtension(extension); err != nil {
+		t.Errorf("expected any extension to be allowed by default, but was '%s'", err.Error())
+	}
+
+	var authorized ExtensionContract
+	service.config.AuthorizeExtension = func(extension ExtensionContract) bool {
+		authorized = extension
+		return extension.Initiator == owner
+	}
+	err := service.authorizeExtension(extension)
+	if err == nil {
+		t.Fatalf("expected err to not be nil")
+	}
+	if !errors.Is(err, ErrExtensionNotAuthorized) {
+		t.Errorf("expected err to wrap '%s', but was '%s'", ErrExtensionNotAuthorized, err.Error())
+	}
+	expectedErr := "extension of contract 0x1932c48b2bF8102Ba33B4A6B545C32236e342f34 by 0x1111111111111111111111111111111111111111 rejected: extension not authorized"
+	if err.Error() != expectedErr {
+		t.Errorf("expected err to be '%s', but was '%s'", expectedErr, err.Error())
+	}
+	if !reflect.DeepEqual(authorized, extension) {
+		t.Errorf("expected the authorizer to be given %v, but was %v", extension, authorized)
+	}
+
+	extension.Initiator = owner
+	if err := service.authorizeExtension(extension); err != nil {
+		t.Errorf("expected err to be '%s', but was '%s'", "nil", err.Error())
+	}
+}
+
+func TestCancelExtension_whenStateAlreadyShared(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	managementContract := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	service := &PrivacyService{
+		psiContracts: map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract{
+			psi: {managementContract: &ExtensionContract{ManagementContractAddress: managementContract, StateShared: true}},
+		},
+	}
+
+	_, err := service.CancelExtension(psi, managementContract, &bind.TransactOpts{})
+
+	if err != errStateAlreadyShared {
+		t.Errorf("expected err to be '%s', but was '%v'", errStateAlreadyShared, err)
+	}
+	if _, ok := service.psiContracts[psi][managementContract]; !ok {
+		t.Errorf("expected extension to still be tracked")
+	}
+}
+
+func TestExtensionsByInitiatorAndRecipient(t *testing.T) {
+	alice := common.HexToAddress("0x0000000000000000000000000000000000000a11")
+	bob := common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	first := ExtensionContract{ManagementContractAddress: common.HexToAddress("0x1"), Initiator: alice, Recipient: bob}
+	second := ExtensionContract{ManagementContractAddress: common.HexToAddress("0x2"), Initiator: bob, Recipient: alice}
+	third := ExtensionContract{ManagementContractAddress: common.HexToAddress("0x3"), Initiator: alice, Recipient: bob}
+	otherPSI := types.ToPrivateStateIdentifier("other")
+	service := &PrivacyService{
+		psiContracts: map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract{
+			types.DefaultPrivateStateIdentifier: {
+				third.ManagementContractAddress:  &third,
+				second.ManagementContractAddress: &second,
+			},
+			otherPSI: {first.ManagementContractAddress: &first},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		got      []ExtensionContract
+		expected []ExtensionContract
+	}{
+		{"initiated by alice", service.ExtensionsByInitiator(alice), []ExtensionContract{first, third}},
+		{"initiated by bob", service.ExtensionsByInitiator(bob), []ExtensionContract{second}},
+		{"received by alice", service.ExtensionsByRecipient(alice), []ExtensionContract{second}},
+		{"received by bob", service.ExtensionsByRecipient(bob), []ExtensionContract{first, third}},
+		{"initiated by unknown", service.ExtensionsByInitiator(common.HexToAddress("0x4")), []ExtensionContract{}},
+	}
+	for _, tc := range testCases {
+		if !reflect.DeepEqual(tc.got, tc.expected) {
+			t.Errorf("%s: expected %v, but was %v", tc.name, tc.expected, tc.got)
+		}
+	}
+}
+
+func TestUntrackExtension(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	managementContract := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	dataHandler := NewJsonFileDataHandler(t.TempDir())
+	service := &PrivacyService{
+		dataHandler: dataHandler,
+		psiContracts: map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract{
+			psi: {managementContract: &ExtensionContract{ManagementContractAddress: managementContract, StateShared: true}},
+		},
+	}
+
+	service.untrackExtension(psi, managementContract)
+
+	if _, ok := service.psiContracts[psi][managementContract]; ok {
+		t.Errorf("expected extension to no longer be tracked")
+	}
+	stored, err := dataHandler.Load()
+	if err != nil {
+		t.Fatalf("expected err to be '%s', but was '%s'", "nil", err.Error())
+	}
+	if len(stored[psi]) != 0 {
+		t.Errorf("expected no stored extension, but found %d", len(stored[psi]))
+	}
+}
+
+func TestCancelExtension_whenStateSharedBeforeRestart(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	managementContract := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	dataHandler := NewJsonFileDataHandler(t.TempDir())
+	err := dataHandler.Save(map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract{
+		psi: {managementContract: &ExtensionContract{ManagementContractAddress: managementContract, StateShared: true}},
+	})
+	if err != nil {
+		t.Fatalf("expected err to be '%s', but was '%s'", "nil", err.Error())
+	}
+
+	psiContracts, err := dataHandler.Load()
+	if err != nil {
+		t.Fatalf("expected err to be '%s', but was '%s'", "nil", err.Error())
+	}
+	service := &PrivacyService{dataHandler: dataHandler, psiContracts: psiContracts}
+
+	_, err = service.CancelExtension(psi, managementContract, &bind.TransactOpts{})
+
+	if err != errStateAlreadyShared {
+		t.Errorf("expected err to be '%s', but was '%v'", errStateAlreadyShared, err)
+	}
+}
+
+func TestWatchedManagementContracts(t *testing.T) {
+	service := &PrivacyService{}
+	if watched := service.watchedManagementContracts(); !reflect.DeepEqual(watched, [][]common.Address{nil}) {
+		t.Errorf("expected a single subscription to all the management contracts, but was %v", watched)
+	}
+
+	first := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	second := common.HexToAddress("0x9d13c6d3afe1721beef56b55d303b09e021e27ab")
+	service.config.ManagementContracts = []common.Address{first, second}
+	if watched := service.watchedManagementContracts(); !reflect.DeepEqual(watched, [][]common.Address{{first}, {second}}) {
+		t.Errorf("expected a subscription per management contract, but was %v", watched)
+	}
+}
+
+func TestExtensionWatchers(t *testing.T) {
+	queryTypes := func(watchers []topicWatcher) []string {
+		var queries []string
+		for _, w := range watchers {
+			queries = append(queries, w.queryType)
+		}
+		return queries
+	}
+
+	service := &PrivacyService{}
+	expected := []string{newExtensionQueryType, finishedExtensionQueryType, canPerformStateShareQueryType}
+	if watched := queryTypes(service.extensionWatchers(types.DefaultPrivateStateIdentifier)); !reflect.DeepEqual(watched, expected) {
+		t.Errorf("expected all the extension events to be watched, but was %v", watched)
+	}
+
+	service.config.Queries = []string{finishedExtensionQueryType, newExtensionQueryType}
+	expected = []string{newExtensionQueryType, finishedExtensionQueryType}
+	if watched := queryTypes(service.extensionWatchers(types.DefaultPrivateStateIdentifier)); !reflect.DeepEqual(watched, expected) {
+		t.Errorf("expected the state share approvals not to be watched, but was %v", watched)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := [][]string{
+		nil,
+		{newExtensionQueryType, finishedExtensionQueryType},
+		{newExtensionQueryType, finishedExtensionQueryType, canPerformStateShareQueryType},
+	}
+	for _, queries := range valid {
+		if err := (&Config{Queries: queries}).Validate(); err != nil {
+			t.Errorf("expected queries %v to be valid, but got '%v'", queries, err)
+		}
+	}
+
+	invalid := [][]string{
+		{newExtensionQueryType, finishedExtensionQueryType, "unknown"},
+		{newExtensionQueryType, canPerformStateShareQueryType},
+		{finishedExtensionQueryType},
+	}
+	for _, queries := range invalid {
+		if err := (&Config{Queries: queries}).Validate(); err == nil {
+			t.Errorf("expected queries %v to be rejected", queries)
+		}
+	}
+}
+
+func TestExtensionProgress(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	managementContract := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	recipient := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	extension := &ExtensionContract{ManagementContractAddress: managementContract, Recipient: recipient}
+	service := &PrivacyService{
+		psiContracts: map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract{
+			psi: {managementContract: extension},
+		},
+	}
+
+	progress, ok := service.ExtensionProgress(psi, managementContract)
+	if !ok {
+		t.Fatalf("expected extension to be in-flight")
+	}
+	if expected := (ExtensionProgress{ManagementContractAddress: managementContract, Processed: 0, Total: 1}); progress != expected {
+		t.Errorf("expected progress to be %+v, but was %+v", expected, progress)
+	}
+
+	extension.StateShared = true
+	progress, _ = service.ExtensionProgress(psi, managementContract)
+	if expected := (ExtensionProgress{ManagementContractAddress: managementContract, Processed: 1, Total: 1}); progress != expected {
+		t.Errorf("expected progress to be %+v, but was %+v", expected, progress)
+	}
+
+	if _, ok := service.ExtensionProgress(psi, common.HexToAddress("0x1")); ok {
+		t.Errorf("expected unknown extension not to be in-flight")
+	}
+}
+
+func TestTrackExtension(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	managementContract := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	recipient := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	service := &PrivacyService{
+		dataHandler:  NewJsonFileDataHandler(t.TempDir()),
+		psiContracts: make(map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract),
+	}
+
+	reconciled, err := service.trackExtension(psi, &ExtensionContract{ManagementContractAddress: managementContract, CreationBlockHash: common.Hash{1}})
+	if err != nil || reconciled {
+		t.Fatalf("expected new extension to be tracked, but was reconciled %v with err %v", reconciled, err)
+	}
+	reconciled, err = service.trackExtension(psi, &ExtensionContract{ManagementContractAddress: managementContract, Recipient: recipient, CreationBlockHash: common.Hash{2}})
+	if err != nil || !reconciled {
+		t.Fatalf("expected tracked extension to be reconciled, but was reconciled %v with err %v", reconciled, err)
+	}
+	if tracked := service.psiContracts[psi][managementContract]; tracked.Recipient != recipient {
+		t.Errorf("expected recipient to be %v, but was %v", recipient, tracked.Recipient)
+	}
+	stored, err := service.dataHandler.Load()
+	if err != nil {
+		t.Fatalf("expected err to be '%s', but was '%s'", "nil", err.Error())
+	}
+	if len(stored[psi]) != 1 {
+		t.Errorf("expected 1 stored extension, but found %d", len(stored[psi]))
+	}
+}
+
+// run with -race: the tracked extensions are read by the APIs while the watchers update them
+func TestTrackedExtensions_ConcurrentReadsDuringWrites(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	initiator := common.HexToAddress("0x0000000000000000000000000000000000000a11")
+	service := &PrivacyService{
+		dataHandler:  NewJsonFileDataHandler(t.TempDir()),
+		psiContracts: make(map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract),
+	}
+	untrack := service.cancelledContractsWatcher(psi).handle
+
+	const extensions = 50
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < extensions; i++ {
+			managementContract := common.BigToAddress(big.NewInt(int64(i + 1)))
+			service.mu.Lock()
+			_, err := service.trackExtension(psi, &ExtensionContract{ManagementContractAddress: managementContract, Initiator: initiator})
+			if err == nil {
+				service.psiContracts[psi][managementContract].StateShared = true
+			}
+			service.mu.Unlock()
+			if err != nil {
+				t.Errorf("expected err to be '%s', but was '%s'", "nil", err.Error())
+				return
+			}
+			if i%2 == 0 {
+				untrack(log.Root(), types.Log{Address: managementContract})
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < extensions; i++ {
+			managementContract := common.BigToAddress(big.NewInt(int64(i + 1)))
+			for _, extension := range service.ExtensionsByInitiator(initiator) {
+				if extension.Initiator != initiator {
+					t.Errorf("expected initiator to be %v, but was %v", initiator, extension.Initiator)
+				}
+			}
+			service.ExtensionsByRecipient(initiator)
+			service.ExtensionProgress(psi, managementContract)
+			service.isTracked(psi, managementContract)
+		}
+	}()
+	wg.Wait()
+
+	if tracked := service.ExtensionsByInitiator(initiator); len(tracked) != extensions/2 {
+		t.Errorf("expected %d tracked extensions, but found %d", extensions/2, len(tracked))
+	}
+}
+
+func TestSubscribeStateShareAppliedEvent(t *testing.T) {
+	service := &PrivacyService{}
+	events, subscription := service.SubscribeStateShareAppliedEvent()
+
+	first := privacyExtension.StateShareAppliedEvent{ContractExtended: common.HexToAddress("0x1"), PSI: "psi1", Uuid: "uuid1"}
+	second := privacyExtension.StateShareAppliedEvent{ContractExtended: common.HexToAddress("0x2"), PSI: "psi2", Uuid: "uuid2"}
+	service.postStateShareApplied(first)
+	service.postStateShareApplied(second)
+
+	if ev := <-events; ev != first {
+		t.Errorf("expected first event to be %+v, but was %+v", first, ev)
+	}
+	if ev := <-events; ev != second {
+		t.Errorf("expected second event to be %+v, but was %+v", second, ev)
+	}
+
+	subscription.Unsubscribe()
+	service.postStateShareApplied(first)
+	select {
+	case ev := <-events:
+		t.Errorf("expected no event after unsubscribing, but got %+v", ev)
+	default:
+	}
+}
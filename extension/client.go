@@ -11,6 +11,8 @@ import (
 
 type Client interface {
 	SubscribeToLogs(query ethereum.FilterQuery) (<-chan types.Log, ethereum.Subscription, error)
+	FilterLogs(query ethereum.FilterQuery) ([]types.Log, error)
+	BlockNumber() (uint64, error)
 	NextNonce(from common.Address) (uint64, error)
 	TransactionByHash(hash common.Hash) (*types.Transaction, error)
 	TransactionInBlock(blockHash common.Hash, txIndex uint) (*types.Transaction, error)
@@ -33,6 +35,14 @@ func (client *InProcessClient) SubscribeToLogs(query ethereum.FilterQuery) (<-ch
 	return retrievedLogsChan, sub, err
 }
 
+func (client *InProcessClient) FilterLogs(query ethereum.FilterQuery) ([]types.Log, error) {
+	return client.client.FilterLogs(context.Background(), query)
+}
+
+func (client *InProcessClient) BlockNumber() (uint64, error) {
+	return client.client.BlockNumber(context.Background())
+}
+
 func (client *InProcessClient) NextNonce(from common.Address) (uint64, error) {
 	return client.client.PendingNonceAt(context.Background(), from)
 }
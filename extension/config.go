@@ -0,0 +1,107 @@
+package extension
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kisexp/xdchain/common"
+)
+
+// Config holds the settings of the privacy service
+type Config struct {
+	// MaxPrivatePayloadSize is the maximum size in bytes of the payloads sent to the private
+	// transaction manager by the extension flow, 0 for no limit
+	MaxPrivatePayloadSize int
+
+	// MaxStateShareSize is the maximum size in bytes of the states shared with the node applied to its
+	// private states, 0 for no limit. Larger shares are rejected
+	MaxStateShareSize int
+
+	// ManagementContracts restricts the watchers to the events of the given extension management
+	// contracts, each contract being watched by its own subscription. The events of all the
+	// management contracts are watched if empty
+	ManagementContracts []common.Address
+
+	// Queries lists the types of the extension events watched, all of them if empty. The creation
+	// and finished events must be watched, the watch of the state share approvals can be left out
+	Queries []string
+
+	// ReconnectMaxJitter is the maximum random delay added to the wait before each attempt to subscribe
+	// again to the extension events once a subscription failed, to spread the reconnections of the nodes
+	// which lost their subscriptions at the same time
+	ReconnectMaxJitter time.Duration
+
+	// WatchUnknownTopics subscribes to all the logs of the watched management contracts, or of the tracked
+	// ones if any management contract is watched, to report the logs which aren't known extension events
+	WatchUnknownTopics bool
+
+	// NewExtensionRateLimit is the maximum number of new extensions which begin processing per
+	// NewExtensionRateInterval, 0 for no limit. The new extensions in excess wait for the next interval,
+	// up to NewExtensionQueueSize of them, the others are rejected
+	NewExtensionRateLimit    int
+	NewExtensionRateInterval time.Duration
+	NewExtensionQueueSize    int
+
+	// PauseBufferSize is the maximum number of logs buffered while the processing of the extension events
+	// is paused, the others are dropped
+	PauseBufferSize int
+
+	// AuthorizeExtension, if set, must allow an extension before the node submits the transaction
+	// creating its management contract
+	AuthorizeExtension ExtensionAuthorizer
+}
+
+// ExtensionAuthorizer reports whether the extension about to be initiated is allowed, the address of
+// its management contract isn't known yet
+type ExtensionAuthorizer func(extension ExtensionContract) bool
+
+// DefaultConfig contains the default settings of the privacy service
+var DefaultConfig = Config{
+	MaxPrivatePayloadSize: 0,
+	ReconnectMaxJitter:    5 * time.Second,
+
+	NewExtensionRateInterval: time.Minute,
+	NewExtensionQueueSize:    16,
+
+	PauseBufferSize: 1024,
+}
+
+// Validate checks that the watched queries are known and include the required ones, that the
+// reconnect jitter isn't negative and that the rate limit of the new extensions is consistent
+func (c *Config) Validate() error {
+	if c.ReconnectMaxJitter < 0 {
+		return fmt.Errorf("negative extension reconnect jitter %v", c.ReconnectMaxJitter)
+	}
+	if c.NewExtensionRateLimit < 0 || c.NewExtensionQueueSize < 0 {
+		return fmt.Errorf("negative new extension rate limit %d or queue size %d", c.NewExtensionRateLimit, c.NewExtensionQueueSize)
+	}
+	if c.NewExtensionRateLimit > 0 && c.NewExtensionRateInterval <= 0 {
+		return fmt.Errorf("invalid new extension rate interval %v", c.NewExtensionRateInterval)
+	}
+	for _, queryType := range c.Queries {
+		switch queryType {
+		case newExtensionQueryType, finishedExtensionQueryType, canPerformStateShareQueryType:
+		default:
+			return fmt.Errorf("unknown extension query %q", queryType)
+		}
+	}
+	for _, required := range []string{newExtensionQueryType, finishedExtensionQueryType} {
+		if !c.queryEnabled(required) {
+			return fmt.Errorf("extension query %q must be enabled", required)
+		}
+	}
+	return nil
+}
+
+// queryEnabled checks if the events of the query type are watched
+func (c *Config) queryEnabled(queryType string) bool {
+	if len(c.Queries) == 0 {
+		return true
+	}
+	for _, enabled := range c.Queries {
+		if enabled == queryType {
+			return true
+		}
+	}
+	return false
+}
@@ -14,6 +14,7 @@ type ManagementContractFacade interface {
 	Transactor(managementAddress common.Address) (*extensionContracts.ContractExtenderTransactor, error)
 	Caller(managementAddress common.Address) (*extensionContracts.ContractExtenderCaller, error)
 	Deploy(args *bind.TransactOpts, toExtend common.Address, recipientAddress common.Address, recipientHash string) (*types.Transaction, error)
+	DeployBundle(args *bind.TransactOpts, toExtend common.Address, bundledContracts []common.Address, recipientAddress common.Address, recipientHash string) (*types.Transaction, error)
 
 	GetAllVoters(addressToVoteOn common.Address) ([]common.Address, error)
 	Close()
@@ -40,6 +41,11 @@ func (facade EthclientManagementContractFacade) Deploy(args *bind.TransactOpts,
 	return tx, err
 }
 
+func (facade EthclientManagementContractFacade) DeployBundle(args *bind.TransactOpts, toExtend common.Address, bundledContracts []common.Address, recipientAddress common.Address, recipientHash string) (*types.Transaction, error) {
+	_, tx, _, err := extensionContracts.DeployContractExtenderBundle(args, facade.client, toExtend, bundledContracts, recipientAddress, recipientHash)
+	return tx, err
+}
+
 func (facade EthclientManagementContractFacade) GetAllVoters(addressToVoteOn common.Address) ([]common.Address, error) {
 	caller, err := facade.Caller(addressToVoteOn)
 	if err != nil {
@@ -7,8 +7,11 @@ import (
 	"path/filepath"
 
 	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/rawdb"
 	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/ethdb"
 	"github.com/kisexp/xdchain/log"
+	"github.com/kisexp/xdchain/rlp"
 )
 
 /*
@@ -35,19 +38,56 @@ import (
 
 const extensionContractData = "activeExtensions.json"
 
+// The watermarks file records, for each PSI, the last block number processed by each of the
+// extension log watchers:
+//
+//	{
+//		"psi1": {
+//			"newExtension": 10,
+//			"finishedExtension": 8,
+//			"canPerformStateShare": 9
+//		},
+//		...
+//	}
+const extensionWatermarkData = "extensionWatermarks.json"
+
+// The handled logs file records, for each PSI, the logs handled by each of the extension log watchers in
+// the blocks above its watermark:
+//
+//	{
+//		"psi1": {
+//			"newExtension": [{"blockNumber": 11, "txHash": "0x...", "index": 0}],
+//			...
+//		},
+//		...
+//	}
+const extensionHandledLogsData = "extensionHandledLogs.json"
+
 type DataHandler interface {
 	Load() (map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract, error)
 
 	Save(extensionContracts map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract) error
+
+	LoadWatermarks() (map[types.PrivateStateIdentifier]map[string]uint64, error)
+
+	SaveWatermarks(watermarks map[types.PrivateStateIdentifier]map[string]uint64) error
+
+	LoadHandledLogs() (map[types.PrivateStateIdentifier]map[string][]HandledLog, error)
+
+	SaveHandledLogs(handledLogs map[types.PrivateStateIdentifier]map[string][]HandledLog) error
 }
 
 type JsonFileDataHandler struct {
-	saveFile string
+	saveFile        string
+	watermarkFile   string
+	handledLogsFile string
 }
 
 func NewJsonFileDataHandler(dataDirectory string) *JsonFileDataHandler {
 	return &JsonFileDataHandler{
-		saveFile: filepath.Join(dataDirectory, extensionContractData),
+		saveFile:        filepath.Join(dataDirectory, extensionContractData),
+		watermarkFile:   filepath.Join(dataDirectory, extensionWatermarkData),
+		handledLogsFile: filepath.Join(dataDirectory, extensionHandledLogsData),
 	}
 }
 
@@ -105,3 +145,174 @@ func (handler *JsonFileDataHandler) Save(extensionContracts map[types.PrivateSta
 	}
 	return nil
 }
+
+// LoadWatermarks returns the last processed block number of each extension log watcher.
+// An empty map is returned if nothing has been processed yet.
+func (handler *JsonFileDataHandler) LoadWatermarks() (map[types.PrivateStateIdentifier]map[string]uint64, error) {
+	watermarks := make(map[types.PrivateStateIdentifier]map[string]uint64)
+	if _, err := os.Stat(handler.watermarkFile); os.IsNotExist(err) {
+		return watermarks, nil
+	}
+
+	blob, err := ioutil.ReadFile(handler.watermarkFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(blob, &watermarks); err != nil {
+		return nil, err
+	}
+	return watermarks, nil
+}
+
+// SaveWatermarks writes the watermarks to a temporary file which then replaces the watermarks file,
+// so that the file is never left partially written.
+func (handler *JsonFileDataHandler) SaveWatermarks(watermarks map[types.PrivateStateIdentifier]map[string]uint64) error {
+	//no unmarshallable types, so can't error
+	output, _ := json.Marshal(&watermarks)
+
+	if errSaving := writeFileAtomically(handler.watermarkFile, output); errSaving != nil {
+		log.Error("Couldn't save extension watcher watermarks")
+		return errSaving
+	}
+	return nil
+}
+
+// LoadHandledLogs returns the logs handled by each extension log watcher above its watermark. An empty map
+// is returned if nothing has been handled yet.
+func (handler *JsonFileDataHandler) LoadHandledLogs() (map[types.PrivateStateIdentifier]map[string][]HandledLog, error) {
+	handledLogs := make(map[types.PrivateStateIdentifier]map[string][]HandledLog)
+	if _, err := os.Stat(handler.handledLogsFile); os.IsNotExist(err) {
+		return handledLogs, nil
+	}
+
+	blob, err := ioutil.ReadFile(handler.handledLogsFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(blob, &handledLogs); err != nil {
+		return nil, err
+	}
+	return handledLogs, nil
+}
+
+func (handler *JsonFileDataHandler) SaveHandledLogs(handledLogs map[types.PrivateStateIdentifier]map[string][]HandledLog) error {
+	//no unmarshallable types, so can't error
+	output, _ := json.Marshal(&handledLogs)
+
+	if errSaving := writeFileAtomically(handler.handledLogsFile, output); errSaving != nil {
+		log.Error("Couldn't save extension watcher handled logs")
+		return errSaving
+	}
+	return nil
+}
+
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// DatabaseWatermarkDataHandler stores the watermarks of the extension log watchers in the node
+// database, everything else is delegated to the wrapped DataHandler.
+//
+// The watermarks are written in a single batch so that a crash can't leave them partially updated.
+// As a watermark is only moved to a block once all its logs have been handled, the block being handled
+// during a crash is replayed after the restart: the logs of the block saved as handled are skipped, the
+// log being handled is handled again.
+type DatabaseWatermarkDataHandler struct {
+	DataHandler
+	db ethdb.Database
+}
+
+func NewDatabaseWatermarkDataHandler(handler DataHandler, db ethdb.Database) *DatabaseWatermarkDataHandler {
+	return &DatabaseWatermarkDataHandler{
+		DataHandler: handler,
+		db:          db,
+	}
+}
+
+// LoadWatermarks returns the watermarks stored in the database. If there are none, the watermarks
+// of the wrapped DataHandler are returned so that the ones saved by a previous version are kept.
+func (handler *DatabaseWatermarkDataHandler) LoadWatermarks() (map[types.PrivateStateIdentifier]map[string]uint64, error) {
+	watermarks, err := rawdb.ReadExtensionWatermarks(handler.db)
+	if err != nil {
+		return nil, err
+	}
+	if len(watermarks) > 0 {
+		return watermarks, nil
+	}
+	return handler.DataHandler.LoadWatermarks()
+}
+
+func (handler *DatabaseWatermarkDataHandler) SaveWatermarks(watermarks map[types.PrivateStateIdentifier]map[string]uint64) error {
+	batch := handler.db.NewBatch()
+	for psi, psiWatermarks := range watermarks {
+		for watcher, processed := range psiWatermarks {
+			if err := rawdb.WriteExtensionWatermark(batch, psi, watcher, processed); err != nil {
+				return err
+			}
+		}
+	}
+	if err := batch.Write(); err != nil {
+		log.Error("Couldn't save extension watcher watermarks")
+		return err
+	}
+	return nil
+}
+
+// LoadHandledLogs returns the logs handled above the watermarks stored in the database
+func (handler *DatabaseWatermarkDataHandler) LoadHandledLogs() (map[types.PrivateStateIdentifier]map[string][]HandledLog, error) {
+	encoded, err := rawdb.ReadExtensionHandledLogs(handler.db)
+	if err != nil {
+		return nil, err
+	}
+	handledLogs := make(map[types.PrivateStateIdentifier]map[string][]HandledLog, len(encoded))
+	for psi, psiHandledLogs := range encoded {
+		handledLogs[psi] = make(map[string][]HandledLog, len(psiHandledLogs))
+		for watcher, data := range psiHandledLogs {
+			var logs []HandledLog
+			if err := rlp.DecodeBytes(data, &logs); err != nil {
+				return nil, err
+			}
+			handledLogs[psi][watcher] = logs
+		}
+	}
+	return handledLogs, nil
+}
+
+func (handler *DatabaseWatermarkDataHandler) SaveHandledLogs(handledLogs map[types.PrivateStateIdentifier]map[string][]HandledLog) error {
+	batch := handler.db.NewBatch()
+	for psi, psiHandledLogs := range handledLogs {
+		for watcher, logs := range psiHandledLogs {
+			data, err := rlp.EncodeToBytes(logs)
+			if err != nil {
+				return err
+			}
+			if err := rawdb.WriteExtensionHandledLogs(batch, psi, watcher, data); err != nil {
+				return err
+			}
+		}
+	}
+	if err := batch.Write(); err != nil {
+		log.Error("Couldn't save extension watcher handled logs")
+		return err
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/rawdb"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -78,3 +79,86 @@ func TestLoadOldContents(t *testing.T) {
 		t.Errorf("expected data from file different to data written, expected %v, got %v", string(expected), string(actual))
 	}
 }
+
+func TestWatermarksRoundTrip(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	dataHandler := NewJsonFileDataHandler(datadir)
+
+	loadedWatermarks, err := dataHandler.LoadWatermarks()
+	assert.Nil(t, err, "error reading watermarks when no file exists")
+	assert.Empty(t, loadedWatermarks)
+
+	watermarks := map[types.PrivateStateIdentifier]map[string]uint64{
+		types.DefaultPrivateStateIdentifier: {newExtensionQueryType: 10, canPerformStateShareQueryType: 7},
+		"somekey":                           {finishedExtensionQueryType: 3},
+	}
+	err = dataHandler.SaveWatermarks(watermarks)
+	assert.Nil(t, err, "error writing watermarks to file")
+
+	loadedWatermarks, err = dataHandler.LoadWatermarks()
+	assert.Nil(t, err, "error reading watermarks from file")
+	assert.Equal(t, watermarks, loadedWatermarks)
+
+	// the temporary file written first is renamed
+	files, err := ioutil.ReadDir(datadir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, extensionWatermarkData, files[0].Name())
+}
+
+func TestDatabaseWatermarkDataHandler(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	// watermarks saved in the file by a previous version
+	fileWatermarks := map[types.PrivateStateIdentifier]map[string]uint64{
+		types.DefaultPrivateStateIdentifier: {newExtensionQueryType: 5},
+	}
+	assert.Nil(t, NewJsonFileDataHandler(datadir).SaveWatermarks(fileWatermarks))
+
+	dataHandler := NewDatabaseWatermarkDataHandler(NewJsonFileDataHandler(datadir), rawdb.NewMemoryDatabase())
+
+	loadedWatermarks, err := dataHandler.LoadWatermarks()
+	assert.Nil(t, err)
+	assert.Equal(t, fileWatermarks, loadedWatermarks, "the file watermarks are used until some are stored in the database")
+
+	watermarks := map[types.PrivateStateIdentifier]map[string]uint64{
+		types.DefaultPrivateStateIdentifier: {newExtensionQueryType: 10, canPerformStateShareQueryType: 7},
+		"somekey":                           {finishedExtensionQueryType: 3},
+	}
+	err = dataHandler.SaveWatermarks(watermarks)
+	assert.Nil(t, err, "error writing watermarks to the database")
+
+	loadedWatermarks, err = dataHandler.LoadWatermarks()
+	assert.Nil(t, err, "error reading watermarks from the database")
+	assert.Equal(t, watermarks, loadedWatermarks)
+}
+
+func TestHandledLogsRoundTrip(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	handledLogs := map[types.PrivateStateIdentifier]map[string][]HandledLog{
+		types.DefaultPrivateStateIdentifier: {newExtensionQueryType: {{BlockNumber: 11, TxHash: common.Hash{1}}, {BlockNumber: 11, TxHash: common.Hash{2}, Index: 1}}},
+		"somekey":                           {finishedExtensionQueryType: {}},
+	}
+	for name, dataHandler := range map[string]DataHandler{
+		"file":     NewJsonFileDataHandler(datadir),
+		"database": NewDatabaseWatermarkDataHandler(NewJsonFileDataHandler(datadir), rawdb.NewMemoryDatabase()),
+	} {
+		loadedHandledLogs, err := dataHandler.LoadHandledLogs()
+		assert.Nil(t, err, "%s: error reading handled logs when none saved", name)
+		assert.Empty(t, loadedHandledLogs)
+
+		assert.Nil(t, dataHandler.SaveHandledLogs(handledLogs), "%s: error writing handled logs", name)
+
+		loadedHandledLogs, err = dataHandler.LoadHandledLogs()
+		assert.Nil(t, err, "%s: error reading handled logs", name)
+		assert.Equal(t, handledLogs, loadedHandledLogs, name)
+	}
+}
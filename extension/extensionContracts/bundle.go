@@ -0,0 +1,69 @@
+package extensionContracts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/kisexp/xdchain/accounts/abi"
+	"github.com/kisexp/xdchain/accounts/abi/bind"
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/types"
+)
+
+// legacyRecipientPTMKeyOffset is the offset of recipientPTMKey in the constructor arguments of a
+// management contract deployed without bundled contracts: the string follows the three head words
+var legacyRecipientPTMKeyOffset = big.NewInt(3 * 32)
+
+// contractExtenderBundleABI is the ABI of the management contract with a constructor also taking
+// the contracts bundled with the contract to extend. The management contract ignores the extra
+// argument, the bundle is only carried by the creation payload shared with the participants of
+// the extension.
+var contractExtenderBundleABI = func() abi.ABI {
+	bundleABI := ContractExtenderParsedABI
+	addresses, _ := abi.NewType("address[]", "address[]", nil)
+	inputs := append(append(abi.Arguments{}, ContractExtenderParsedABI.Constructor.Inputs...), abi.Argument{Name: "bundledContracts", Type: addresses})
+	constructor := ContractExtenderParsedABI.Constructor
+	bundleABI.Constructor = abi.NewMethod("", "", abi.Constructor, constructor.StateMutability, constructor.Constant, constructor.Payable, inputs, nil)
+	return bundleABI
+}()
+
+// DeployContractExtenderBundle deploys a new management contract extending the contract along with
+// the bundled contracts, whose states are shared together with the state of the contract.
+func DeployContractExtenderBundle(auth *bind.TransactOpts, backend bind.ContractBackend, contractAddress common.Address, bundledContracts []common.Address, recipientAddress common.Address, recipientPTMKey string) (common.Address, *types.Transaction, *ContractExtender, error) {
+	if len(bundledContracts) == 0 {
+		return DeployContractExtender(auth, backend, contractAddress, recipientAddress, recipientPTMKey)
+	}
+	address, tx, contract, err := bind.DeployContract(auth, contractExtenderBundleABI, common.FromHex(ContractExtenderBin), backend, contractAddress, recipientAddress, recipientPTMKey, bundledContracts)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return address, tx, &ContractExtender{ContractExtenderCaller: ContractExtenderCaller{contract: contract}, ContractExtenderTransactor: ContractExtenderTransactor{contract: contract}, ContractExtenderFilterer: ContractExtenderFilterer{contract: contract}}, nil
+}
+
+// UnpackBundledContracts returns the contracts bundled with the contract to extend from the creation
+// payload of a management contract. Management contracts deployed without bundled contracts have
+// none.
+func UnpackBundledContracts(creationPayload []byte) ([]common.Address, error) {
+	bin := common.FromHex(ContractExtenderBin)
+	if !bytes.HasPrefix(creationPayload, bin) {
+		return nil, errors.New("creation payload is not a management contract deployment")
+	}
+	args := creationPayload[len(bin):]
+	if len(args) < 3*32 {
+		return nil, fmt.Errorf("management contract constructor arguments too short: %d bytes", len(args))
+	}
+	if new(big.Int).SetBytes(args[2*32:3*32]).Cmp(legacyRecipientPTMKeyOffset) == 0 {
+		return nil, nil
+	}
+	values, err := contractExtenderBundleABI.Constructor.Inputs.Unpack(args)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unpack bundled contracts: %w", err)
+	}
+	bundledContracts, ok := values[3].([]common.Address)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T of bundled contracts", values[3])
+	}
+	return bundledContracts, nil
+}
@@ -0,0 +1,48 @@
+package extensionContracts
+
+import (
+	"testing"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func creationPayload(args []byte) []byte {
+	return append(common.FromHex(ContractExtenderBin), args...)
+}
+
+func TestUnpackBundledContracts(t *testing.T) {
+	bundled := []common.Address{common.HexToAddress("0x3333333333333333333333333333333333333333"), common.HexToAddress("0x4444444444444444444444444444444444444444")}
+	args, err := contractExtenderBundleABI.Pack("", testToExtend, testRecipientAddress, testRecipientPTMKey, bundled)
+	if err != nil {
+		t.Fatalf("unable to pack constructor arguments: %v", err)
+	}
+
+	actual, err := UnpackBundledContracts(creationPayload(args))
+
+	assert.NoError(t, err)
+	assert.Equal(t, bundled, actual)
+
+	// the arguments of the contract to extend are left untouched
+	values, err := ContractExtenderParsedABI.Constructor.Inputs.Unpack(args)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{testToExtend, testRecipientAddress, testRecipientPTMKey}, values)
+}
+
+func TestUnpackBundledContracts_WithoutBundle(t *testing.T) {
+	args, err := ContractExtenderParsedABI.Pack("", testToExtend, testRecipientAddress, testRecipientPTMKey)
+	if err != nil {
+		t.Fatalf("unable to pack constructor arguments: %v", err)
+	}
+
+	actual, err := UnpackBundledContracts(creationPayload(args))
+
+	assert.NoError(t, err)
+	assert.Empty(t, actual)
+}
+
+func TestUnpackBundledContracts_NotManagementContract(t *testing.T) {
+	_, err := UnpackBundledContracts([]byte{0x60, 0x80})
+
+	assert.Error(t, err)
+}
@@ -1413,7 +1413,7 @@ func (it *ContractExtenderStateSharedIterator) Close() error {
 // ContractExtenderStateShared represents a StateShared event raised by the ContractExtender contract.
 type ContractExtenderStateShared struct {
 	ToExtend    common.Address
-	Tesserahash string
+	Tesserahash string // hash of the shared state in the private transaction manager, not specific to Tessera
 	Uuid        string
 	Raw         types.Log // Blockchain specific contextual infos
 }
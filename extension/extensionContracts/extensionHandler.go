@@ -1,18 +1,138 @@
 package extensionContracts
 
-import "github.com/kisexp/xdchain/common"
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
 
-func UnpackStateSharedLog(logData []byte) (common.Address, string, string, error) {
+	"github.com/kisexp/xdchain/common"
+)
+
+// minNewExtensionCreatedLogDataLength is the minimum length of the NewContractExtensionContractCreated
+// log data: the head words of toExtend, recipientPTMKey offset and recipientAddress followed by
+// the length word of recipientPTMKey
+const minNewExtensionCreatedLogDataLength = 4 * 32
+
+// PtmHashValidator checks that the hash referencing the shared state in a StateShared log is
+// well formed for a particular private transaction manager.
+type PtmHashValidator func(ptmHash string) error
+
+var (
+	ptmHashValidatorsMu sync.RWMutex
+	ptmHashValidators   = map[string]PtmHashValidator{
+		"Tessera":       validateBase64PtmHash,
+		"Constellation": validateBase64PtmHash,
+	}
+)
+
+// RegisterPtmHashValidator sets the validator used for hashes produced by the named private
+// transaction manager, replacing any existing one. A nil validator removes the registration.
+func RegisterPtmHashValidator(ptmName string, validator PtmHashValidator) {
+	ptmHashValidatorsMu.Lock()
+	defer ptmHashValidatorsMu.Unlock()
+	if validator == nil {
+		delete(ptmHashValidators, ptmName)
+		return
+	}
+	ptmHashValidators[ptmName] = validator
+}
+
+// ValidatePtmHash validates the hash using the validator registered for the named private
+// transaction manager. Hashes of a private transaction manager without a validator are accepted.
+func ValidatePtmHash(ptmName string, ptmHash string) error {
+	ptmHashValidatorsMu.RLock()
+	validator, ok := ptmHashValidators[ptmName]
+	ptmHashValidatorsMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	if err := validator(ptmHash); err != nil {
+		return fmt.Errorf("invalid %s hash %q: %w", ptmName, ptmHash, err)
+	}
+	return nil
+}
+
+func validateBase64PtmHash(ptmHash string) error {
+	_, err := base64.StdEncoding.DecodeString(ptmHash)
+	return err
+}
+
+// UnpackStateSharedLog decodes the data of a StateShared log into the extended contract address,
+// the hash of the shared state in the private transaction manager and the uuid of the extension.
+// The hash is returned as emitted, its format depends on the private transaction manager in use
+// and can be checked with ValidatePtmHash.
+func UnpackStateSharedLog(logData []byte) (toExtend common.Address, ptmHash string, uuid string, err error) {
 	decodedLog := new(ContractExtenderStateShared)
 	if err := ContractExtenderParsedABI.UnpackIntoInterface(decodedLog, "StateShared", logData); err != nil {
 		return common.Address{}, "", "", err
 	}
+	// the Tesserahash field holds the hash of whichever private transaction manager shared the state
 	return decodedLog.ToExtend, decodedLog.Tesserahash, decodedLog.Uuid, nil
 }
 
+// SharedState is the content of a StateShared log
+type SharedState struct {
+	ToExtend common.Address // the extended contract
+	PtmHash  string         // the hash of the shared state in the private transaction manager
+	Uuid     string         // the uuid of the extension
+}
+
+// UnpackStateSharedLogs decodes the data of StateShared logs, in order, for the logs to be replayed. Decoding
+// stops at the first malformed log: the logs decoded before it are returned along with an error holding
+// its index.
+func UnpackStateSharedLogs(logsData [][]byte) ([]SharedState, error) {
+	sharedStates := make([]SharedState, 0, len(logsData))
+	for i, logData := range logsData {
+		toExtend, ptmHash, uuid, err := UnpackStateSharedLog(logData)
+		if err != nil {
+			return sharedStates, fmt.Errorf("unable to unpack StateShared log at index %d: %w", i, err)
+		}
+		sharedStates = append(sharedStates, SharedState{ToExtend: toExtend, PtmHash: ptmHash, Uuid: uuid})
+	}
+	return sharedStates, nil
+}
+
+// UnpackNewExtensionCreatedLog decodes the data of a NewContractExtensionContractCreated log.
+// An error is returned if the data is too short to hold the event or if the contract to extend
+// or the recipient PTM key are missing.
 func UnpackNewExtensionCreatedLog(data []byte) (*ContractExtenderNewContractExtensionContractCreated, error) {
+	if len(data) < minNewExtensionCreatedLogDataLength {
+		return nil, fmt.Errorf("log data too short for topic %s: expected at least %d bytes, got %d", NewContractExtensionContractCreatedTopicHash, minNewExtensionCreatedLogDataLength, len(data))
+	}
+
 	newExtensionEvent := new(ContractExtenderNewContractExtensionContractCreated)
-	err := ContractExtenderParsedABI.UnpackIntoInterface(newExtensionEvent, "NewContractExtensionContractCreated", data)
+	if err := ContractExtenderParsedABI.UnpackIntoInterface(newExtensionEvent, "NewContractExtensionContractCreated", data); err != nil {
+		return nil, fmt.Errorf("unable to unpack log for topic %s: %w", NewContractExtensionContractCreatedTopicHash, err)
+	}
 
-	return newExtensionEvent, err
+	if newExtensionEvent.ToExtend == (common.Address{}) {
+		return nil, fmt.Errorf("missing contract to extend in log for topic %s", NewContractExtensionContractCreatedTopicHash)
+	}
+	if newExtensionEvent.RecipientPTMKey == "" {
+		return nil, fmt.Errorf("missing recipient PTM key in log for topic %s", NewContractExtensionContractCreatedTopicHash)
+	}
+	return newExtensionEvent, nil
+}
+
+// UnpackNewExtensionCreatedLogStrict is like UnpackNewExtensionCreatedLog but also rejects logs
+// whose decoded fields look inconsistent, e.g. after an incompatible change of the management
+// contract ABI: the recipient address must be set, the recipient PTM key must be base64 encoded
+// and the data must not contain anything beyond the encoded event.
+func UnpackNewExtensionCreatedLogStrict(data []byte) (*ContractExtenderNewContractExtensionContractCreated, error) {
+	newExtensionEvent, err := UnpackNewExtensionCreatedLog(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if newExtensionEvent.RecipientAddress == (common.Address{}) {
+		return nil, fmt.Errorf("missing recipient address in log for topic %s", NewContractExtensionContractCreatedTopicHash)
+	}
+	if _, err := base64.StdEncoding.DecodeString(newExtensionEvent.RecipientPTMKey); err != nil {
+		return nil, fmt.Errorf("invalid recipient PTM key in log for topic %s: %w", NewContractExtensionContractCreatedTopicHash, err)
+	}
+	paddedKeyLength := (len(newExtensionEvent.RecipientPTMKey) + 31) / 32 * 32
+	if expected := minNewExtensionCreatedLogDataLength + paddedKeyLength; len(data) != expected {
+		return nil, fmt.Errorf("unexpected log data length for topic %s: expected %d bytes, got %d", NewContractExtensionContractCreatedTopicHash, expected, len(data))
+	}
+	return newExtensionEvent, nil
 }
@@ -0,0 +1,137 @@
+package extensionContracts
+
+import (
+	"testing"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/common/hexutil"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testToExtend         = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testRecipientAddress = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	testRecipientPTMKey  = "BULeR8JyUWhiuuCMU/HLA0Q5pzkYT+cHII3ZKBey3Bo="
+)
+
+func packNewExtensionCreatedLog(t *testing.T, toExtend common.Address, recipientPTMKey string, recipientAddress common.Address) []byte {
+	data, err := ContractExtenderParsedABI.Events["NewContractExtensionContractCreated"].Inputs.Pack(toExtend, recipientPTMKey, recipientAddress)
+	if err != nil {
+		t.Fatalf("unable to pack log data: %v", err)
+	}
+	return data
+}
+
+func TestUnpackNewExtensionCreatedLog(t *testing.T) {
+	data := packNewExtensionCreatedLog(t, testToExtend, testRecipientPTMKey, testRecipientAddress)
+
+	event, err := UnpackNewExtensionCreatedLog(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testToExtend, event.ToExtend)
+	assert.Equal(t, testRecipientPTMKey, event.RecipientPTMKey)
+	assert.Equal(t, testRecipientAddress, event.RecipientAddress)
+}
+
+func TestUnpackNewExtensionCreatedLog_DataTooShort(t *testing.T) {
+	data := packNewExtensionCreatedLog(t, testToExtend, testRecipientPTMKey, testRecipientAddress)
+
+	_, err := UnpackNewExtensionCreatedLog(data[:minNewExtensionCreatedLogDataLength-1])
+
+	assert.EqualError(t, err, "log data too short for topic "+NewContractExtensionContractCreatedTopicHash+": expected at least 128 bytes, got 127")
+}
+
+func TestUnpackNewExtensionCreatedLog_MissingFields(t *testing.T) {
+	_, err := UnpackNewExtensionCreatedLog(packNewExtensionCreatedLog(t, common.Address{}, testRecipientPTMKey, testRecipientAddress))
+	assert.EqualError(t, err, "missing contract to extend in log for topic "+NewContractExtensionContractCreatedTopicHash)
+
+	_, err = UnpackNewExtensionCreatedLog(packNewExtensionCreatedLog(t, testToExtend, "", testRecipientAddress))
+	assert.EqualError(t, err, "missing recipient PTM key in log for topic "+NewContractExtensionContractCreatedTopicHash)
+}
+
+func TestUnpackNewExtensionCreatedLog_TruncatedString(t *testing.T) {
+	data := packNewExtensionCreatedLog(t, testToExtend, testRecipientPTMKey, testRecipientAddress)
+
+	_, err := UnpackNewExtensionCreatedLog(data[:minNewExtensionCreatedLogDataLength])
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to unpack log for topic "+NewContractExtensionContractCreatedTopicHash)
+}
+
+func TestUnpackNewExtensionCreatedLogStrict(t *testing.T) {
+	data := packNewExtensionCreatedLog(t, testToExtend, testRecipientPTMKey, testRecipientAddress)
+
+	_, err := UnpackNewExtensionCreatedLogStrict(data)
+	assert.NoError(t, err)
+
+	// the lenient version tolerates what the strict version rejects
+	inconsistent := [][]byte{
+		packNewExtensionCreatedLog(t, testToExtend, testRecipientPTMKey, common.Address{}),
+		packNewExtensionCreatedLog(t, testToExtend, "not base64!", testRecipientAddress),
+		append(data, make([]byte, 32)...),
+	}
+	for _, d := range inconsistent {
+		_, err := UnpackNewExtensionCreatedLog(d)
+		assert.NoError(t, err)
+		_, err = UnpackNewExtensionCreatedLogStrict(d)
+		assert.Error(t, err)
+	}
+}
+
+func TestUnpackStateSharedLog_NonTesseraHash(t *testing.T) {
+	ptmHash := "0x9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	data, err := ContractExtenderParsedABI.Events["StateShared"].Inputs.Pack(testToExtend, ptmHash, "some-uuid")
+	if err != nil {
+		t.Fatalf("unable to pack log data: %v", err)
+	}
+
+	toExtend, hash, uuid, err := UnpackStateSharedLog(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testToExtend, toExtend)
+	assert.Equal(t, ptmHash, hash)
+	assert.Equal(t, "some-uuid", uuid)
+
+	assert.Error(t, ValidatePtmHash("Tessera", hash))
+
+	RegisterPtmHashValidator("HexPTM", func(ptmHash string) error {
+		_, err := hexutil.Decode(ptmHash)
+		return err
+	})
+	defer RegisterPtmHashValidator("HexPTM", nil)
+
+	assert.NoError(t, ValidatePtmHash("HexPTM", hash))
+	assert.Error(t, ValidatePtmHash("HexPTM", testRecipientPTMKey))
+	assert.NoError(t, ValidatePtmHash("UnknownPTM", hash))
+}
+
+func TestUnpackStateSharedLogs(t *testing.T) {
+	pack := func(uuid string) []byte {
+		data, err := ContractExtenderParsedABI.Events["StateShared"].Inputs.Pack(testToExtend, testRecipientPTMKey, uuid)
+		if err != nil {
+			t.Fatalf("unable to pack log data: %v", err)
+		}
+		return data
+	}
+
+	sharedStates, err := UnpackStateSharedLogs([][]byte{pack("uuid-1"), pack("uuid-2")})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []SharedState{
+		{ToExtend: testToExtend, PtmHash: testRecipientPTMKey, Uuid: "uuid-1"},
+		{ToExtend: testToExtend, PtmHash: testRecipientPTMKey, Uuid: "uuid-2"},
+	}, sharedStates)
+}
+
+func TestUnpackStateSharedLogs_StopsAtMalformedLog(t *testing.T) {
+	valid, err := ContractExtenderParsedABI.Events["StateShared"].Inputs.Pack(testToExtend, testRecipientPTMKey, "uuid-1")
+	if err != nil {
+		t.Fatalf("unable to pack log data: %v", err)
+	}
+
+	sharedStates, err := UnpackStateSharedLogs([][]byte{valid, valid[:40], valid})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "index 1")
+	assert.Equal(t, []SharedState{{ToExtend: testToExtend, PtmHash: testRecipientPTMKey, Uuid: "uuid-1"}}, sharedStates)
+}
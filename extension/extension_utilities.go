@@ -1,6 +1,10 @@
 package extension
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/private"
 	"github.com/kisexp/xdchain/private/engine"
@@ -36,3 +40,22 @@ func checkAddressInList(addressToFind common.Address, addressList []common.Addre
 	}
 	return false
 }
+
+// ptmPublicKeyLength is the length in bytes of the public keys of the private transaction manager
+const ptmPublicKeyLength = 32
+
+// ErrInvalidPtmKey is returned when a private transaction manager public key of an extension is malformed
+var ErrInvalidPtmKey = errors.New("invalid transaction manager public key")
+
+// validatePtmKey checks that the key is a well-formed private transaction manager public key: the standard
+// base64 encoding of ptmPublicKeyLength bytes. The returned error wraps ErrInvalidPtmKey.
+func validatePtmKey(key string) error {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("%w %q: not base64 encoded", ErrInvalidPtmKey, key)
+	}
+	if len(decoded) != ptmPublicKeyLength {
+		return fmt.Errorf("%w %q: %d bytes instead of %d", ErrInvalidPtmKey, key, len(decoded), ptmPublicKeyLength)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package extension
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePtmKey(t *testing.T) {
+	for _, key := range []string{
+		"BULeR8JyUWhiuuCMU/HLA0Q5pzkYT+cHII3ZKBey3Bo=",
+		"QfeDAys9MPDs2XHExtc84jKGHxZg/aj52DTh0vtA3Xc=",
+	} {
+		assert.NoError(t, validatePtmKey(key), key)
+	}
+
+	for key, reason := range map[string]string{
+		"":             "0 bytes instead of 32",
+		"not a key":    "not base64 encoded",
+		"BULeR8JyUWhi": "9 bytes instead of 32",
+		"BULeR8JyUWhiuuCMU/HLA0Q5pzkYT+cHII3ZKBey3Bo":  "not base64 encoded",
+		"BULeR8JyUWhiuuCMU_HLA0Q5pzkYT-cHII3ZKBey3Bo=": "not base64 encoded",
+		"BULeR8JyUWhiuuCMU/HLA0Q5pzkYT+cHII3ZKBey3BoA": "33 bytes instead of 32",
+	} {
+		err := validatePtmKey(key)
+		assert.True(t, errors.Is(err, ErrInvalidPtmKey), "key %q: unexpected error %v", key, err)
+		assert.Contains(t, err.Error(), reason, key)
+	}
+}
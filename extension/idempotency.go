@@ -0,0 +1,103 @@
+package extension
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/types"
+)
+
+// extensionRequestTTL is how long the extension submitted for an idempotency key is returned to the
+// requests retried with the same key
+const extensionRequestTTL = 10 * time.Minute
+
+// maxExtensionRequests bounds the number of idempotency keys remembered, the submitted extensions
+// expiring first are forgotten once it is reached
+var maxExtensionRequests = 1024
+
+// extensionRequestKey identifies the requests of a client to extend a contract of a private state
+type extensionRequestKey struct {
+	psi       types.PrivateStateIdentifier
+	initiator common.Address
+	contract  common.Address
+	nonce     string
+}
+
+type extensionRequest struct {
+	done    chan struct{} // closed once the extension is submitted, or failed to be
+	txHash  string
+	err     error
+	expires time.Time
+}
+
+// extensionRequests remembers the extensions initiated for the idempotency keys supplied by the clients,
+// so that a request retried with the same key returns the extension already submitted instead of
+// creating a duplicate management contract
+type extensionRequests struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	requests map[extensionRequestKey]*extensionRequest
+}
+
+func newExtensionRequests(ttl time.Duration) *extensionRequests {
+	return &extensionRequests{ttl: ttl, requests: make(map[extensionRequestKey]*extensionRequest)}
+}
+
+// submit calls initiate unless an extension has been submitted for the key less than ttl ago, or is being
+// submitted, in which case the hash of its transaction is returned once known. The key of a failed
+// submission is forgotten, so that it can be retried, the requests waiting for it get the same error.
+func (r *extensionRequests) submit(ctx context.Context, key extensionRequestKey, initiate func() (string, error)) (string, error) {
+	r.mu.Lock()
+	if req, ok := r.requests[key]; ok && (req.expires.IsZero() || time.Now().Before(req.expires)) {
+		r.mu.Unlock()
+		select {
+		case <-req.done:
+			return req.txHash, req.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	r.prune()
+	req := &extensionRequest{done: make(chan struct{})}
+	r.requests[key] = req
+	r.mu.Unlock()
+
+	txHash, err := initiate()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req.txHash, req.err = txHash, err
+	if err != nil {
+		delete(r.requests, key)
+	} else {
+		req.expires = time.Now().Add(r.ttl)
+	}
+	close(req.done)
+	return txHash, err
+}
+
+// prune forgets the expired keys and, if maxExtensionRequests are still remembered, the submitted extension
+// expiring first. The extensions being submitted are kept. r.mu must be held
+func (r *extensionRequests) prune() {
+	now := time.Now()
+	var oldest *extensionRequestKey
+	for key, req := range r.requests {
+		if req.expires.IsZero() {
+			continue
+		}
+		if !now.Before(req.expires) {
+			delete(r.requests, key)
+			continue
+		}
+		if oldest == nil || req.expires.Before(r.requests[*oldest].expires) {
+			key := key
+			oldest = &key
+		}
+	}
+	if len(r.requests) >= maxExtensionRequests && oldest != nil {
+		delete(r.requests, *oldest)
+	}
+}
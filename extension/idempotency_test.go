@@ -0,0 +1,146 @@
+package extension
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtensionRequests_DuplicateSubmissions(t *testing.T) {
+	requests := newExtensionRequests(time.Minute)
+	key := extensionRequestKey{initiator: common.HexToAddress("0x1"), contract: common.HexToAddress("0x2"), nonce: "nonce1"}
+	initiated := 0
+	initiate := func() (string, error) {
+		initiated++
+		return fmt.Sprintf("0x%d", initiated), nil
+	}
+
+	txHash, err := requests.submit(context.Background(), key, initiate)
+	assert.NoError(t, err)
+	assert.Equal(t, "0x1", txHash)
+
+	txHash, err = requests.submit(context.Background(), key, initiate)
+	assert.NoError(t, err)
+	assert.Equal(t, "0x1", txHash, "duplicate submission not deduplicated")
+	assert.Equal(t, 1, initiated)
+}
+
+func TestExtensionRequests_DistinctSubmissions(t *testing.T) {
+	requests := newExtensionRequests(time.Minute)
+	key := extensionRequestKey{initiator: common.HexToAddress("0x1"), contract: common.HexToAddress("0x2"), nonce: "nonce1"}
+	initiated := 0
+	initiate := func() (string, error) {
+		initiated++
+		return fmt.Sprintf("0x%d", initiated), nil
+	}
+
+	for i, distinct := range []extensionRequestKey{
+		key,
+		{initiator: key.initiator, contract: key.contract, nonce: "nonce2"},
+		{initiator: common.HexToAddress("0x3"), contract: key.contract, nonce: key.nonce},
+		{initiator: key.initiator, contract: common.HexToAddress("0x4"), nonce: key.nonce},
+		{psi: types.PrivateStateIdentifier("psi1"), initiator: key.initiator, contract: key.contract, nonce: key.nonce},
+	} {
+		txHash, err := requests.submit(context.Background(), distinct, initiate)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("0x%d", i+1), txHash)
+	}
+	assert.Equal(t, 5, initiated)
+}
+
+func TestExtensionRequests_InFlightDuplicateWaits(t *testing.T) {
+	requests := newExtensionRequests(time.Minute)
+	key := extensionRequestKey{initiator: common.HexToAddress("0x1"), contract: common.HexToAddress("0x2"), nonce: "nonce1"}
+	started, release := make(chan struct{}), make(chan struct{})
+	go requests.submit(context.Background(), key, func() (string, error) {
+		close(started)
+		<-release
+		return "0x1", nil
+	})
+	<-started
+
+	result := make(chan string)
+	go func() {
+		txHash, _ := requests.submit(context.Background(), key, func() (string, error) {
+			t.Error("in flight extension initiated twice")
+			return "0x2", nil
+		})
+		result <- txHash
+	}()
+	select {
+	case <-result:
+		t.Fatal("duplicate returned before the extension is submitted")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case txHash := <-result:
+		assert.Equal(t, "0x1", txHash)
+	case <-time.After(time.Second):
+		t.Fatal("duplicate still waiting once the extension is submitted")
+	}
+
+	// the wait is abandoned with the request
+	started, release = make(chan struct{}), make(chan struct{})
+	defer close(release)
+	other := extensionRequestKey{initiator: key.initiator, contract: key.contract, nonce: "nonce2"}
+	go requests.submit(context.Background(), other, func() (string, error) {
+		close(started)
+		<-release
+		return "0x3", nil
+	})
+	<-started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := requests.submit(ctx, other, func() (string, error) { return "0x4", nil })
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+}
+
+func TestExtensionRequests_FailedSubmissionRetried(t *testing.T) {
+	requests := newExtensionRequests(time.Minute)
+	key := extensionRequestKey{initiator: common.HexToAddress("0x1"), contract: common.HexToAddress("0x2"), nonce: "nonce1"}
+	failure := errors.New("ptm unavailable")
+
+	_, err := requests.submit(context.Background(), key, func() (string, error) { return "", failure })
+	assert.True(t, errors.Is(err, failure), "unexpected error %v", err)
+
+	txHash, err := requests.submit(context.Background(), key, func() (string, error) { return "0x1", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "0x1", txHash)
+}
+
+func TestExtensionRequests_Expiry(t *testing.T) {
+	requests := newExtensionRequests(10 * time.Millisecond)
+	key := extensionRequestKey{initiator: common.HexToAddress("0x1"), contract: common.HexToAddress("0x2"), nonce: "nonce1"}
+
+	_, err := requests.submit(context.Background(), key, func() (string, error) { return "0x1", nil })
+	assert.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	txHash, err := requests.submit(context.Background(), key, func() (string, error) { return "0x2", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "0x2", txHash, "expired key not forgotten")
+}
+
+func TestExtensionRequests_Bounded(t *testing.T) {
+	defer func(max int) { maxExtensionRequests = max }(maxExtensionRequests)
+	maxExtensionRequests = 2
+
+	requests := newExtensionRequests(time.Minute)
+	for i := 0; i < 5; i++ {
+		key := extensionRequestKey{initiator: common.HexToAddress("0x1"), contract: common.HexToAddress("0x2"), nonce: fmt.Sprint(i)}
+		_, err := requests.submit(context.Background(), key, func() (string, error) { return "0x1", nil })
+		assert.NoError(t, err)
+		// each extension expires after the previous one
+		time.Sleep(time.Millisecond)
+	}
+	assert.Len(t, requests.requests, 2)
+	_, ok := requests.requests[extensionRequestKey{initiator: common.HexToAddress("0x1"), contract: common.HexToAddress("0x2"), nonce: "4"}]
+	assert.True(t, ok, "last key forgotten")
+}
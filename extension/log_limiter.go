@@ -0,0 +1,67 @@
+package extension
+
+import (
+	"sync"
+	"time"
+)
+
+// errorLogInterval is the minimum interval between two logs of the same error by a watcher
+var errorLogInterval = time.Minute
+
+// errorLogLimiter logs each distinct error, identified by its message and error text, at most once per
+// interval so that a watcher failing repeatedly, e.g. while its endpoint is down, doesn't flood the logs.
+// The occurrences of an error suppressed since it was last logged are reported the next time it is.
+type errorLogLimiter struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	errors map[string]*loggedError
+}
+
+// loggedError is the last time an error has been logged and the number of its occurrences since
+type loggedError struct {
+	at         time.Time
+	suppressed int
+}
+
+func newErrorLogLimiter(interval time.Duration) *errorLogLimiter {
+	return &errorLogLimiter{interval: interval, now: time.Now, errors: make(map[string]*loggedError)}
+}
+
+// log logs the message along with the error and the context with write, e.g. log.Error, unless the same
+// error has been logged with the same message less than the interval ago. The number of occurrences since
+// the error was last logged is added to the context when some have been suppressed.
+func (l *errorLogLimiter) log(write func(msg string, ctx ...interface{}), msg string, err error, ctx ...interface{}) {
+	key := msg
+	if err != nil {
+		key += "\x00" + err.Error()
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	logged, ok := l.errors[key]
+	if ok && now.Sub(logged.at) < l.interval {
+		logged.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := 0
+	if ok {
+		suppressed = logged.suppressed
+	}
+	l.errors[key] = &loggedError{at: now}
+	for k, e := range l.errors {
+		// the errors which haven't occurred for an interval are forgotten, so the map doesn't grow
+		if now.Sub(e.at) >= l.interval && e.suppressed == 0 && k != key {
+			delete(l.errors, k)
+		}
+	}
+	l.mu.Unlock()
+
+	ctx = append(ctx, "error", err)
+	if suppressed > 0 {
+		ctx = append(ctx, "occurrences", suppressed+1)
+	}
+	write(msg, ctx...)
+}
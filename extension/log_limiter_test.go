@@ -0,0 +1,51 @@
+package extension
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kisexp/xdchain/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorLogLimiter_BoundsRepeatedErrors(t *testing.T) {
+	var records []*log.Record
+	logger := log.New()
+	logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+	now := time.Now()
+	limiter := newErrorLogLimiter(time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	endpointDown := errors.New("connection refused")
+	for i := 0; i < 100; i++ {
+		limiter.log(logger.Error, "Contract extension watcher subscription error", endpointDown, "psi", "private")
+	}
+	assert.Len(t, records, 1, "identical errors logged once per interval")
+
+	// a different error, or the same error with another message, is logged
+	limiter.log(logger.Error, "Contract extension watcher subscription error", errors.New("timeout"), "psi", "private")
+	limiter.log(logger.Warn, "Extension: failed to resubscribe to the logs", endpointDown, "psi", "private")
+	assert.Len(t, records, 3)
+
+	// once the interval is over, the error is logged again with its occurrences since it was last logged
+	now = now.Add(time.Minute)
+	limiter.log(logger.Error, "Contract extension watcher subscription error", endpointDown, "psi", "private")
+	assert.Len(t, records, 4)
+	ctx := make(map[interface{}]interface{})
+	for i := 0; i+1 < len(records[3].Ctx); i += 2 {
+		ctx[records[3].Ctx[i]] = records[3].Ctx[i+1]
+	}
+	assert.Equal(t, 100, ctx["occurrences"])
+	assert.Equal(t, endpointDown, ctx["error"])
+	assert.Equal(t, "private", ctx["psi"])
+
+	// without occurrence suppressed, no count is reported
+	now = now.Add(time.Minute)
+	limiter.log(logger.Error, "Contract extension watcher subscription error", endpointDown)
+	assert.Len(t, records, 5)
+	assert.NotContains(t, records[4].Ctx, "occurrences")
+}
@@ -0,0 +1,89 @@
+package extension
+
+import (
+	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/log"
+)
+
+// PauseStatus describes the pause of the processing of the extension events
+type PauseStatus struct {
+	Paused   bool   `json:"paused"`
+	Buffered int    `json:"buffered"` // number of logs waiting for Resume
+	Dropped  uint64 `json:"dropped"`  // number of logs dropped during the current or last pause, the buffer being full
+}
+
+// Pause stops the processing of the extension events, e.g. during a maintenance window, so that the
+// private states aren't modified by the extensions. The subscriptions are kept alive: up to
+// Config.PauseBufferSize logs received while paused are buffered to be processed on Resume, the others
+// are dropped. Pausing while resuming stops the resume once the logs being processed are. Pausing a
+// paused service does nothing.
+func (service *PrivacyService) Pause() {
+	service.pauseMu.Lock()
+	defer service.pauseMu.Unlock()
+	if service.resuming {
+		service.resuming = false
+		log.Info("Extension: resume of the processing of the extension events interrupted", "buffered", len(service.pausedLogs))
+		return
+	}
+	if service.paused {
+		return
+	}
+	service.paused, service.dropped = true, 0
+	log.Info("Extension: processing of the extension events paused")
+}
+
+// Resume processes the logs buffered while paused, in the order they were received, then resumes the
+// processing of the extension events. The logs are processed without holding the pause: the logs received
+// meanwhile are buffered, and processed once the ones buffered before are. Resuming a service which isn't
+// paused does nothing.
+func (service *PrivacyService) Resume() {
+	service.resumeMu.Lock()
+	defer service.resumeMu.Unlock()
+
+	service.pauseMu.Lock()
+	if !service.paused {
+		service.pauseMu.Unlock()
+		return
+	}
+	service.resuming = true
+	log.Info("Extension: processing of the extension events resumed", "buffered", len(service.pausedLogs), "dropped", service.dropped)
+	for service.resuming && len(service.pausedLogs) > 0 {
+		buffered := service.pausedLogs
+		service.pausedLogs = nil
+		service.pauseMu.Unlock()
+		for _, handle := range buffered {
+			handle()
+		}
+		service.pauseMu.Lock()
+	}
+	if service.resuming {
+		service.paused, service.resuming = false, false
+	}
+	service.pauseMu.Unlock()
+}
+
+// PauseStatus returns whether the processing of the extension events is paused and the logs buffered
+// or dropped during the current or last pause
+func (service *PrivacyService) PauseStatus() PauseStatus {
+	service.pauseMu.Lock()
+	defer service.pauseMu.Unlock()
+	return PauseStatus{Paused: service.paused, Buffered: len(service.pausedLogs), Dropped: service.dropped}
+}
+
+// handleUnlessPaused calls handle to process the log of the psi, unless the service is paused: the log is
+// then buffered until Resume, or dropped if the buffer is full
+func (service *PrivacyService) handleUnlessPaused(psi types.PrivateStateIdentifier, l types.Log, handle func()) {
+	service.pauseMu.Lock()
+	if !service.paused {
+		service.pauseMu.Unlock()
+		handle()
+		return
+	}
+	defer service.pauseMu.Unlock()
+	if len(service.pausedLogs) >= service.config.PauseBufferSize {
+		service.dropped++
+		log.Warn("Extension: log dropped while paused, the buffer is full", "psi", psi, "managementContract", l.Address, "txHash", l.TxHash, "blockNumber", l.BlockNumber)
+		return
+	}
+	service.pausedLogs = append(service.pausedLogs, handle)
+}
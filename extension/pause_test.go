@@ -0,0 +1,117 @@
+package extension
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/extension/extensionContracts"
+	"github.com/kisexp/xdchain/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivacyService_PauseResume(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.DefaultPrivateStateIdentifier
+	newTopic := common.HexToHash(extensionContracts.NewContractExtensionContractCreatedTopicHash)
+	service := &PrivacyService{dataHandler: NewJsonFileDataHandler(datadir), config: Config{PauseBufferSize: 2}}
+	defer service.Stop()
+	client := &mockClient{incomingLogs: make(chan types.Log)}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	handled := make(chan types.Log, 10)
+	err = handler.createTopicsSub(nil, []topicWatcher{{queryType: newExtensionQueryType, topic: newTopic, handle: func(_ log.Logger, l types.Log) { handled <- l }}})
+	assert.NoError(t, err)
+
+	client.incomingLogs <- types.Log{BlockNumber: 1, Topics: []common.Hash{newTopic}}
+	assert.Equal(t, uint64(1), waitForLogs(t, handled, 1)[0].BlockNumber)
+
+	service.Pause()
+	assert.Equal(t, PauseStatus{Paused: true}, service.PauseStatus())
+	for blockNumber := uint64(2); blockNumber <= 4; blockNumber++ {
+		client.incomingLogs <- types.Log{BlockNumber: blockNumber, Topics: []common.Hash{newTopic}}
+	}
+	// the subscription is kept alive but nothing is processed, the log in excess of the buffer is dropped
+	assert.Eventually(t, func() bool {
+		return service.PauseStatus() == PauseStatus{Paused: true, Buffered: 2, Dropped: 1}
+	}, time.Second, time.Millisecond)
+	assert.Empty(t, handled)
+	assert.Equal(t, uint64(0), service.watermarks[psi][newExtensionQueryType], "the watermark must not move while paused")
+
+	service.Resume()
+	assert.Equal(t, PauseStatus{Dropped: 1}, service.PauseStatus())
+	logs := waitForLogs(t, handled, 2)
+	assert.Equal(t, uint64(2), logs[0].BlockNumber)
+	assert.Equal(t, uint64(3), logs[1].BlockNumber)
+
+	client.incomingLogs <- types.Log{BlockNumber: 5, Topics: []common.Hash{newTopic}}
+	assert.Equal(t, uint64(5), waitForLogs(t, handled, 1)[0].BlockNumber)
+	assert.Empty(t, handled)
+
+	// pausing or resuming twice does nothing
+	service.Resume()
+	service.Pause()
+	service.Pause()
+	assert.True(t, service.PauseStatus().Paused)
+	service.Resume()
+	assert.False(t, service.PauseStatus().Paused)
+}
+
+func TestPrivacyService_Resume_DoesNotHoldThePause(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	service := &PrivacyService{config: Config{PauseBufferSize: 10}}
+	var handled []uint64
+	handle := func(blockNumber uint64) func() {
+		return func() { handled = append(handled, blockNumber) }
+	}
+
+	service.Pause()
+	service.handleUnlessPaused(psi, types.Log{BlockNumber: 1}, func() {
+		handled = append(handled, 1)
+		// the logs received while resuming are processed once the ones buffered before are
+		assert.True(t, service.PauseStatus().Paused)
+		service.handleUnlessPaused(psi, types.Log{BlockNumber: 3}, handle(3))
+	})
+	service.handleUnlessPaused(psi, types.Log{BlockNumber: 2}, handle(2))
+
+	done := make(chan struct{})
+	go func() {
+		service.Resume()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("resume blocked by the processing of the buffered logs")
+	}
+	assert.Equal(t, []uint64{1, 2, 3}, handled)
+	assert.Equal(t, PauseStatus{}, service.PauseStatus())
+}
+
+func TestPrivacyService_PauseWhileResuming(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	service := &PrivacyService{config: Config{PauseBufferSize: 10}}
+	var handled []uint64
+
+	service.Pause()
+	service.handleUnlessPaused(psi, types.Log{BlockNumber: 1}, func() {
+		handled = append(handled, 1)
+		service.Pause()
+		service.handleUnlessPaused(psi, types.Log{BlockNumber: 2}, func() { handled = append(handled, 2) })
+	})
+	service.Resume()
+
+	// the resume stops once the logs being processed are
+	assert.Equal(t, []uint64{1}, handled)
+	assert.Equal(t, PauseStatus{Paused: true, Buffered: 1}, service.PauseStatus())
+
+	service.Resume()
+	assert.Equal(t, []uint64{1, 2}, handled)
+	assert.Equal(t, PauseStatus{}, service.PauseStatus())
+}
@@ -96,3 +96,29 @@ func validateAccountsExist(expectedAccounts []common.Address, actualAccounts map
 	}
 	return true
 }
+
+// validateBundleAccounts checks that the extended contract is present in the state map and that
+// all the accounts of the state map, the extended contract and the contracts bundled with it,
+// are contracts
+func validateBundleAccounts(extendedContract common.Address, actualAccounts map[string]extension.AccountWithMetadata) bool {
+	if _, exists := actualAccounts[extendedContract.String()]; !exists {
+		return false
+	}
+	for _, account := range actualAccounts {
+		if account.State.Code == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// existingAccounts returns the accounts of the state map which already have code in the private state
+func existingAccounts(privateState *state.StateDB, accounts map[string]extension.AccountWithMetadata) []common.Address {
+	var existing []common.Address
+	for key := range accounts {
+		if address := common.HexToAddress(key); privateState.GetCode(address) != nil {
+			existing = append(existing, address)
+		}
+	}
+	return existing
+}
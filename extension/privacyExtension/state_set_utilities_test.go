@@ -366,3 +366,20 @@ func (mpm *mockPrivateTransactionManager) DecryptPayload(payload common.DecryptR
 func (mpm *mockPrivateTransactionManager) GetCache() state.Database {
 	return nil
 }
+
+func Test_validateBundleAccounts(t *testing.T) {
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	contract := extension.AccountWithMetadata{State: state.DumpAccount{Code: "03"}}
+
+	assert.True(t, validateBundleAccounts(address, map[string]extension.AccountWithMetadata{
+		address.String(): contract,
+		"0x3333333333333333333333333333333333333333": contract,
+	}))
+	assert.False(t, validateBundleAccounts(address, map[string]extension.AccountWithMetadata{
+		"0x3333333333333333333333333333333333333333": contract,
+	}), "the extended contract is missing")
+	assert.False(t, validateBundleAccounts(address, map[string]extension.AccountWithMetadata{
+		address.String(): contract,
+		"0x3333333333333333333333333333333333333333": {},
+	}), "an account without code is not a contract")
+}
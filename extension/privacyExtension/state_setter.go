@@ -3,6 +3,9 @@ package privacyExtension
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/core/mps"
@@ -15,10 +18,37 @@ import (
 
 var DefaultExtensionHandler *ExtensionHandler
 
+// ErrStateShareNotFound is returned by ReapplyStateShare when the transaction manager doesn't hold the
+// shared state anymore
+var ErrStateShareNotFound = errors.New("shared state not found in the transaction manager")
+
+// ErrStateShareTooLarge is the error of the state shares rejected for exceeding the maximum size
+var ErrStateShareTooLarge = errors.New("shared state too large")
+
 type ExtensionHandler struct {
 	ptm           private.PrivateTransactionManager
 	psmr          mps.PrivateStateMetadataResolver
 	isMultitenant bool
+
+	// appliedShares holds the uuids of the state shares applied to each private state, by management contract,
+	// rejectedShares the error of the last state share rejected for each private state, by management contract
+	appliedSharesMu sync.Mutex
+	appliedShares   map[types.PrivateStateIdentifier]map[common.Address]map[string]bool
+	rejectedShares  map[types.PrivateStateIdentifier]map[common.Address]error
+
+	maxStateShareSize int // maximum size in bytes of the shared states applied, 0 for no limit
+
+	observerMu sync.RWMutex
+	observer   func(StateShareAppliedEvent) // notified of the state shares applied, optional
+}
+
+// StateShareAppliedEvent is posted once the state of an extended contract has been shared with the node
+// and applied to one of its private states
+type StateShareAppliedEvent struct {
+	ContractExtended common.Address
+	PSI              types.PrivateStateIdentifier
+	Hash             string // hash of the shared state in the transaction manager
+	Uuid             string
 }
 
 func Init() {
@@ -37,6 +67,82 @@ func (handler *ExtensionHandler) SetPSMR(psmr mps.PrivateStateMetadataResolver)
 	handler.psmr = psmr
 }
 
+// SetMaxStateShareSize limits the size in bytes of the shared states applied, 0 for no limit. The size
+// is queried before fetching the state if the transaction manager is a private.PayloadSizer, it is
+// otherwise checked once fetched.
+func (handler *ExtensionHandler) SetMaxStateShareSize(size int) {
+	handler.maxStateShareSize = size
+}
+
+// checkStateShareSize rejects a shared state of the given size exceeding the limit
+func (handler *ExtensionHandler) checkStateShareSize(hash string, size int) error {
+	if limit := handler.maxStateShareSize; limit > 0 && size > limit {
+		return fmt.Errorf("%w: %s has %d bytes, exceeding the limit of %d bytes", ErrStateShareTooLarge, hash, size, limit)
+	}
+	return nil
+}
+
+// checkStateShareSizeBeforeFetch queries the size of the shared state, if the limit is set and the
+// transaction manager can report it, and rejects it if it exceeds the limit
+func (handler *ExtensionHandler) checkStateShareSizeBeforeFetch(hash string) error {
+	sizer, ok := handler.ptm.(private.PayloadSizer)
+	if !ok || handler.maxStateShareSize <= 0 {
+		return nil
+	}
+	ptmHash, err := common.Base64ToEncryptedPayloadHash(hash)
+	if err != nil {
+		return err
+	}
+	size, err := sizer.PayloadSize(ptmHash)
+	if err != nil {
+		return fmt.Errorf("unable to query the size of the shared state %s: %v", hash, err)
+	}
+	return handler.checkStateShareSize(hash, size)
+}
+
+// StateShareRejection returns the error of the last state share of the extension managed by the
+// management contract rejected for the private state, nil if none was rejected
+func (handler *ExtensionHandler) StateShareRejection(psi types.PrivateStateIdentifier, managementContract common.Address) error {
+	handler.appliedSharesMu.Lock()
+	defer handler.appliedSharesMu.Unlock()
+
+	return handler.rejectedShares[psi][managementContract]
+}
+
+func (handler *ExtensionHandler) rejectShare(psi types.PrivateStateIdentifier, managementContract common.Address, err error) {
+	log.Error("Extension: state share rejected", "managementContract", managementContract, "psi", psi, "err", err)
+
+	handler.appliedSharesMu.Lock()
+	defer handler.appliedSharesMu.Unlock()
+
+	if handler.rejectedShares == nil {
+		handler.rejectedShares = make(map[types.PrivateStateIdentifier]map[common.Address]error)
+	}
+	if handler.rejectedShares[psi] == nil {
+		handler.rejectedShares[psi] = make(map[common.Address]error)
+	}
+	handler.rejectedShares[psi][managementContract] = err
+}
+
+// SetStateShareAppliedObserver replaces the observer called, in order, with each state share applied
+// to a private state, a nil observer unregisters it. The state is applied while processing the block
+// sharing it, so the observer must not block.
+func (handler *ExtensionHandler) SetStateShareAppliedObserver(observer func(StateShareAppliedEvent)) {
+	handler.observerMu.Lock()
+	defer handler.observerMu.Unlock()
+
+	handler.observer = observer
+}
+
+func (handler *ExtensionHandler) notifyStateShareApplied(event StateShareAppliedEvent) {
+	handler.observerMu.RLock()
+	defer handler.observerMu.RUnlock()
+
+	if handler.observer != nil {
+		handler.observer(event)
+	}
+}
+
 func (handler *ExtensionHandler) CheckExtensionAndSetPrivateState(txLogs []*types.Log, privateState *state.StateDB, psi types.PrivateStateIdentifier) {
 	extraMetaDataUpdated := false
 	for _, txLog := range txLogs {
@@ -48,6 +154,15 @@ func (handler *ExtensionHandler) CheckExtensionAndSetPrivateState(txLogs []*type
 		if err != nil {
 			continue
 		}
+		if err := extension.ValidatePtmHash(handler.ptm.Name(), hash); err != nil {
+			log.Error("Extension: skipping state share", "address", address, "err", err)
+			continue
+		}
+		// the same log is delivered again on reorgs, the state must only be applied once
+		if handler.isShareApplied(psi, txLog.Address, uuid) {
+			log.Debug("Extension: state share already applied", "managementContract", txLog.Address, "uuid", uuid, "psi", psi)
+			continue
+		}
 
 		// check if state exists for the extension address. If yes then skip
 		// processing
@@ -63,6 +178,7 @@ func (handler *ExtensionHandler) CheckExtensionAndSetPrivateState(txLogs []*type
 				setManagedParties(handler.ptm, privateState, address, hash)
 			}
 			extraMetaDataUpdated = true
+			handler.markShareApplied(psi, txLog.Address, uuid)
 		} else {
 			managedParties, accounts, privacyMetaData, found := handler.FetchStateData(txLog.Address, hash, uuid, psi)
 			if !found {
@@ -71,30 +187,120 @@ func (handler *ExtensionHandler) CheckExtensionAndSetPrivateState(txLogs []*type
 			if !handler.isMultitenant {
 				managedParties = nil
 			}
-			if !validateAccountsExist([]common.Address{address}, accounts) {
+			// the state map holds the extended contract and the contracts bundled with it, which are
+			// applied all or none
+			if !validateBundleAccounts(address, accounts) {
 				log.Error("Account mismatch", "expected", address, "found", accounts)
 				continue
 			}
+			if existing := existingAccounts(privateState, accounts); len(existing) > 0 {
+				log.Error("Extension: state share rejected, bundled contracts already exist", "address", address, "existing", existing, "managementContract", txLog.Address)
+				continue
+			}
 			snapshotId := privateState.Snapshot()
 
 			if success := setState(privateState, accounts, privacyMetaData, managedParties); !success {
 				privateState.RevertToSnapshot(snapshotId)
+				log.Error("Extension: state share rolled back", "address", address, "accounts", len(accounts), "managementContract", txLog.Address)
+				continue
 			}
+			handler.markShareApplied(psi, txLog.Address, uuid)
+			handler.notifyStateShareApplied(StateShareAppliedEvent{ContractExtended: address, PSI: psi, Hash: hash, Uuid: uuid})
 		}
 	}
 }
 
+func (handler *ExtensionHandler) isShareApplied(psi types.PrivateStateIdentifier, managementContract common.Address, uuid string) bool {
+	handler.appliedSharesMu.Lock()
+	defer handler.appliedSharesMu.Unlock()
+
+	return handler.appliedShares[psi][managementContract][uuid]
+}
+
+func (handler *ExtensionHandler) markShareApplied(psi types.PrivateStateIdentifier, managementContract common.Address, uuid string) {
+	handler.appliedSharesMu.Lock()
+	defer handler.appliedSharesMu.Unlock()
+
+	if handler.appliedShares == nil {
+		handler.appliedShares = make(map[types.PrivateStateIdentifier]map[common.Address]map[string]bool)
+	}
+	if handler.appliedShares[psi] == nil {
+		handler.appliedShares[psi] = make(map[common.Address]map[string]bool)
+	}
+	if handler.appliedShares[psi][managementContract] == nil {
+		handler.appliedShares[psi][managementContract] = make(map[string]bool)
+	}
+	handler.appliedShares[psi][managementContract][uuid] = true
+}
+
+// ForgetAppliedShares removes the record of the state shares applied for the management contract,
+// to be called once its extension is finished
+func (handler *ExtensionHandler) ForgetAppliedShares(managementContract common.Address) {
+	handler.appliedSharesMu.Lock()
+	defer handler.appliedSharesMu.Unlock()
+
+	for _, applied := range handler.appliedShares {
+		delete(applied, managementContract)
+	}
+}
+
+// ReapplyStateShare applies again to the private state the state shared for the extension of the contract,
+// fetched from the transaction manager with its hash, e.g. to repair a corrupted private state. Unlike
+// the state shares applied while processing blocks, the accounts already in the private state are
+// overwritten. Nothing is applied if the state of any of the shared accounts can't be set.
+//
+// The returned error wraps ErrStateShareNotFound if the transaction manager doesn't hold the shared state.
+func (handler *ExtensionHandler) ReapplyStateShare(privateState *state.StateDB, contractExtended common.Address, hash string) error {
+	if err := extension.ValidatePtmHash(handler.ptm.Name(), hash); err != nil {
+		return err
+	}
+	if err := handler.checkStateShareSizeBeforeFetch(hash); err != nil {
+		return err
+	}
+	managedParties, stateData, privacyMetaData, ok := handler.FetchDataFromPTM(hash)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrStateShareNotFound, hash)
+	}
+	if err := handler.checkStateShareSize(hash, len(stateData)); err != nil {
+		return err
+	}
+	var accounts map[string]extension.AccountWithMetadata
+	if err := json.Unmarshal(stateData, &accounts); err != nil {
+		return fmt.Errorf("invalid shared state %s: %v", hash, err)
+	}
+	if !validateBundleAccounts(contractExtended, accounts) {
+		return fmt.Errorf("shared state %s doesn't hold the state of contract %s", hash, contractExtended.Hex())
+	}
+	if !handler.isMultitenant {
+		managedParties = nil
+	}
+	snapshotId := privateState.Snapshot()
+	if success := setState(privateState, accounts, privacyMetaData, managedParties); !success {
+		privateState.RevertToSnapshot(snapshotId)
+		return fmt.Errorf("unable to set the shared state %s", hash)
+	}
+	return nil
+}
+
 func (handler *ExtensionHandler) FetchStateData(address common.Address, hash string, uuid string, psi types.PrivateStateIdentifier) ([]string, map[string]extension.AccountWithMetadata, *state.PrivacyMetadata, bool) {
 	if uuidIsSentByUs := handler.UuidIsOwn(address, uuid, psi); !uuidIsSentByUs {
 		return nil, nil, nil, false
 	}
 
+	if err := handler.checkStateShareSizeBeforeFetch(hash); err != nil {
+		handler.rejectShare(psi, address, err)
+		return nil, nil, nil, false
+	}
 	managedParties, stateData, privacyMetaData, ok := handler.FetchDataFromPTM(hash)
 	if !ok {
 		//there is nothing to do here, the state wasn't shared with us
 		log.Error("Extension: No state shared with us")
 		return nil, nil, nil, false
 	}
+	if err := handler.checkStateShareSize(hash, len(stateData)); err != nil {
+		handler.rejectShare(psi, address, err)
+		return nil, nil, nil, false
+	}
 
 	var accounts map[string]extension.AccountWithMetadata
 	if err := json.Unmarshal(stateData, &accounts); err != nil {
@@ -6,8 +6,11 @@ import (
 
 	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/core/mps"
+	"github.com/kisexp/xdchain/core/rawdb"
 	"github.com/kisexp/xdchain/core/state"
 	"github.com/kisexp/xdchain/core/types"
+	extension "github.com/kisexp/xdchain/extension/extensionContracts"
+	"github.com/kisexp/xdchain/private/engine"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -216,3 +219,260 @@ func TestExtensionHandler_UuidIsOwn_PrivatePSMRSucceeds(t *testing.T) {
 
 	assert.True(t, isOwn)
 }
+
+func TestExtensionHandler_CheckExtensionAndSetPrivateState_DuplicateStateSharedLogIsNoop(t *testing.T) {
+	ptm := &mockPrivateTransactionManager{}
+	handler := NewExtensionHandler(ptm)
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	managementContract := common.HexToAddress("0x9ccd1e1089c79fe1cca81601fc9ccfa24f77eb58")
+	initialHash := common.BytesToEncryptedPayloadHash([]byte{10})
+	statedb := createStateDb(t, &state.PrivacyMetadata{})
+	statedb.SetPrivacyMetadata(address, &state.PrivacyMetadata{CreationTxHash: initialHash, PrivacyFlag: engine.PrivacyFlagPartyProtection})
+
+	sharedHash := common.BytesToEncryptedPayloadHash([]byte{20})
+	data, err := extension.ContractExtenderParsedABI.Events["StateShared"].Inputs.Pack(address, sharedHash.ToBase64(), "0xabcd")
+	assert.NoError(t, err)
+	stateSharedLogs := []*types.Log{{
+		Address: managementContract,
+		Topics:  []common.Hash{common.HexToHash(extension.StateSharedTopicHash)},
+		Data:    data,
+	}}
+
+	handler.CheckExtensionAndSetPrivateState(stateSharedLogs, statedb, types.DefaultPrivateStateIdentifier)
+
+	privacyMetaData, _ := statedb.GetPrivacyMetadata(address)
+	assert.Equal(t, sharedHash, privacyMetaData.CreationTxHash)
+
+	// deliver the same log again, e.g. after a reorg
+	statedb.SetPrivacyMetadata(address, &state.PrivacyMetadata{CreationTxHash: initialHash, PrivacyFlag: engine.PrivacyFlagPartyProtection})
+	handler.CheckExtensionAndSetPrivateState(stateSharedLogs, statedb, types.DefaultPrivateStateIdentifier)
+
+	privacyMetaData, _ = statedb.GetPrivacyMetadata(address)
+	assert.Equal(t, initialHash, privacyMetaData.CreationTxHash, "second delivery must be a no-op")
+
+	// once the extension is finished the log is handled again
+	handler.ForgetAppliedShares(managementContract)
+	handler.CheckExtensionAndSetPrivateState(stateSharedLogs, statedb, types.DefaultPrivateStateIdentifier)
+
+	privacyMetaData, _ = statedb.GetPrivacyMetadata(address)
+	assert.Equal(t, sharedHash, privacyMetaData.CreationTxHash)
+}
+
+func bundleStateSharedLogs(t *testing.T, managementContract common.Address, address common.Address) []*types.Log {
+	sharedHash := common.BytesToEncryptedPayloadHash([]byte{20})
+	data, err := extension.ContractExtenderParsedABI.Events["StateShared"].Inputs.Pack(address, sharedHash.ToBase64(), "0xabcd")
+	assert.NoError(t, err)
+	return []*types.Log{{
+		Address: managementContract,
+		Topics:  []common.Hash{common.HexToHash(extension.StateSharedTopicHash)},
+		Data:    data,
+	}}
+}
+
+func bundleStateShareHandler(managementContract common.Address, stateData string) *ExtensionHandler {
+	ptm := &mockPrivateTransactionManager{
+		returns: map[string][]interface{}{
+			"IsSender":       {true, nil},
+			"Receive":        {"psi1", nil, []byte(stateData), &engine.ExtraMetadata{PrivacyFlag: engine.PrivacyFlagStandardPrivate}, nil},
+			"DecryptPayload": {managementContract.Bytes(), nil, nil},
+		},
+	}
+	handler := NewExtensionHandler(ptm)
+	handler.SetPSMR(&mockPSMR{
+		returns: map[string][]interface{}{
+			"ResolveForManagedParty": {&mps.PrivateStateMetadata{ID: "psi1", Type: mps.Resident}, nil},
+		},
+	})
+	return handler
+}
+
+func TestExtensionHandler_CheckExtensionAndSetPrivateState_AppliesBundle(t *testing.T) {
+	managementContract := common.HexToAddress("0x9ccd1e1089c79fe1cca81601fc9ccfa24f77eb58")
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	bundled := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	handler := bundleStateShareHandler(managementContract, `{
+		"0x2222222222222222222222222222222222222222": {"state": {"balance": "22", "nonce": 1, "code": "03030303"}},
+		"0x3333333333333333333333333333333333333333": {"state": {"balance": "33", "nonce": 1, "code": "04040404"}}
+	}`)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	handler.CheckExtensionAndSetPrivateState(bundleStateSharedLogs(t, managementContract, address), statedb, "psi1")
+
+	assert.Equal(t, []byte{3, 3, 3, 3}, statedb.GetCode(address))
+	assert.Equal(t, []byte{4, 4, 4, 4}, statedb.GetCode(bundled))
+}
+
+func TestExtensionHandler_CheckExtensionAndSetPrivateState_NotifiesStateShareApplied(t *testing.T) {
+	managementContract := common.HexToAddress("0x9ccd1e1089c79fe1cca81601fc9ccfa24f77eb58")
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	handler := bundleStateShareHandler(managementContract, `{
+		"0x2222222222222222222222222222222222222222": {"state": {"balance": "22", "nonce": 1, "code": "03030303"}}
+	}`)
+	var notified []StateShareAppliedEvent
+	handler.SetStateShareAppliedObserver(func(ev StateShareAppliedEvent) {
+		notified = append(notified, ev)
+	})
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	handler.CheckExtensionAndSetPrivateState(bundleStateSharedLogs(t, managementContract, address), statedb, "psi1")
+	// the log delivered again isn't applied twice
+	handler.CheckExtensionAndSetPrivateState(bundleStateSharedLogs(t, managementContract, address), statedb, "psi1")
+
+	hash := common.BytesToEncryptedPayloadHash([]byte{20}).ToBase64()
+	assert.Equal(t, []StateShareAppliedEvent{{ContractExtended: address, PSI: "psi1", Hash: hash, Uuid: "0xabcd"}}, notified)
+}
+
+func TestExtensionHandler_CheckExtensionAndSetPrivateState_BundleRolledBackOnFailure(t *testing.T) {
+	managementContract := common.HexToAddress("0x9ccd1e1089c79fe1cca81601fc9ccfa24f77eb58")
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	bundled := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	handler := bundleStateShareHandler(managementContract, `{
+		"0x2222222222222222222222222222222222222222": {"state": {"balance": "22", "nonce": 1, "code": "03030303"}},
+		"0x3333333333333333333333333333333333333333": {"state": {"balance": "invalid", "nonce": 1, "code": "04040404"}}
+	}`)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	handler.SetStateShareAppliedObserver(func(ev StateShareAppliedEvent) {
+		t.Errorf("unexpected state share applied %+v", ev)
+	})
+
+	handler.CheckExtensionAndSetPrivateState(bundleStateSharedLogs(t, managementContract, address), statedb, "psi1")
+
+	assert.Nil(t, statedb.GetCode(address))
+	assert.Nil(t, statedb.GetCode(bundled))
+	assert.False(t, handler.isShareApplied("psi1", managementContract, "0xabcd"))
+}
+
+func TestExtensionHandler_CheckExtensionAndSetPrivateState_BundlePartiallyExistingRejected(t *testing.T) {
+	managementContract := common.HexToAddress("0x9ccd1e1089c79fe1cca81601fc9ccfa24f77eb58")
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	bundled := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	handler := bundleStateShareHandler(managementContract, `{
+		"0x2222222222222222222222222222222222222222": {"state": {"balance": "22", "nonce": 1, "code": "03030303"}},
+		"0x3333333333333333333333333333333333333333": {"state": {"balance": "33", "nonce": 1, "code": "04040404"}}
+	}`)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(bundled, []byte{5})
+
+	handler.CheckExtensionAndSetPrivateState(bundleStateSharedLogs(t, managementContract, address), statedb, "psi1")
+
+	assert.Nil(t, statedb.GetCode(address))
+	assert.Equal(t, []byte{5}, statedb.GetCode(bundled))
+}
+
+func TestExtensionHandler_ReapplyStateShare_OverwritesExistingAccounts(t *testing.T) {
+	managementContract := common.HexToAddress("0x9ccd1e1089c79fe1cca81601fc9ccfa24f77eb58")
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	bundled := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	handler := bundleStateShareHandler(managementContract, `{
+		"0x2222222222222222222222222222222222222222": {"state": {"balance": "22", "nonce": 1, "code": "03030303", "storage": {"0x0000000000000000000000000000000000000000000000000000000000000001": "05"}}},
+		"0x3333333333333333333333333333333333333333": {"state": {"balance": "33", "nonce": 1, "code": "04040404"}}
+	}`)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	// corrupted state of the extended contract
+	statedb.SetCode(address, []byte{9})
+	statedb.SetState(address, common.HexToHash("0x01"), common.HexToHash("0x09"))
+
+	err := handler.ReapplyStateShare(statedb, address, common.BytesToEncryptedPayloadHash([]byte{20}).ToBase64())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{3, 3, 3, 3}, statedb.GetCode(address))
+	assert.Equal(t, common.HexToHash("0x05"), statedb.GetState(address, common.HexToHash("0x01")))
+	assert.Equal(t, []byte{4, 4, 4, 4}, statedb.GetCode(bundled))
+}
+
+func TestExtensionHandler_ReapplyStateShare_StateShareNotFound(t *testing.T) {
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	handler := NewExtensionHandler(&mockPrivateTransactionManager{
+		returns: map[string][]interface{}{"Receive": {"", nil, nil, nil, nil}},
+	})
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(address, []byte{9})
+
+	err := handler.ReapplyStateShare(statedb, address, common.BytesToEncryptedPayloadHash([]byte{20}).ToBase64())
+
+	assert.True(t, errors.Is(err, ErrStateShareNotFound), "unexpected error %v", err)
+	assert.Equal(t, []byte{9}, statedb.GetCode(address))
+}
+
+func TestExtensionHandler_ReapplyStateShare_ContractNotShared(t *testing.T) {
+	managementContract := common.HexToAddress("0x9ccd1e1089c79fe1cca81601fc9ccfa24f77eb58")
+	handler := bundleStateShareHandler(managementContract, `{
+		"0x2222222222222222222222222222222222222222": {"state": {"balance": "22", "nonce": 1, "code": "03030303"}}
+	}`)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	other := common.HexToAddress("0x4444444444444444444444444444444444444444")
+
+	err := handler.ReapplyStateShare(statedb, other, common.BytesToEncryptedPayloadHash([]byte{20}).ToBase64())
+
+	assert.Error(t, err)
+	assert.Nil(t, statedb.GetCode(common.HexToAddress("0x2222222222222222222222222222222222222222")))
+}
+
+// sizingPrivateTransactionManager reports the size of the payloads before they are received
+type sizingPrivateTransactionManager struct {
+	*mockPrivateTransactionManager
+	size     int
+	received []common.EncryptedPayloadHash
+}
+
+func (ptm *sizingPrivateTransactionManager) PayloadSize(common.EncryptedPayloadHash) (int, error) {
+	return ptm.size, nil
+}
+
+func (ptm *sizingPrivateTransactionManager) Receive(data common.EncryptedPayloadHash) (string, []string, []byte, *engine.ExtraMetadata, error) {
+	ptm.received = append(ptm.received, data)
+	return ptm.mockPrivateTransactionManager.Receive(data)
+}
+
+func TestExtensionHandler_CheckExtensionAndSetPrivateState_StateShareTooLarge(t *testing.T) {
+	managementContract := common.HexToAddress("0x9ccd1e1089c79fe1cca81601fc9ccfa24f77eb58")
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	handler := bundleStateShareHandler(managementContract, `{
+		"0x2222222222222222222222222222222222222222": {"state": {"balance": "22", "nonce": 1, "code": "03030303"}}
+	}`)
+	handler.SetMaxStateShareSize(10)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	handler.CheckExtensionAndSetPrivateState(bundleStateSharedLogs(t, managementContract, address), statedb, "psi1")
+
+	assert.Nil(t, statedb.GetCode(address))
+	err := handler.StateShareRejection("psi1", managementContract)
+	assert.True(t, errors.Is(err, ErrStateShareTooLarge), "unexpected error %v", err)
+	assert.NoError(t, handler.StateShareRejection("psi2", managementContract))
+}
+
+func TestExtensionHandler_CheckExtensionAndSetPrivateState_StateShareSizeQueriedFirst(t *testing.T) {
+	managementContract := common.HexToAddress("0x9ccd1e1089c79fe1cca81601fc9ccfa24f77eb58")
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	handler := bundleStateShareHandler(managementContract, `{
+		"0x2222222222222222222222222222222222222222": {"state": {"balance": "22", "nonce": 1, "code": "03030303"}}
+	}`)
+	ptm := &sizingPrivateTransactionManager{mockPrivateTransactionManager: handler.ptm.(*mockPrivateTransactionManager), size: 1 << 20}
+	handler.ptm = ptm
+	handler.SetMaxStateShareSize(1 << 10)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	handler.CheckExtensionAndSetPrivateState(bundleStateSharedLogs(t, managementContract, address), statedb, "psi1")
+
+	stateHash := common.BytesToEncryptedPayloadHash([]byte{20})
+	assert.NotContains(t, ptm.received, stateHash, "the oversized state must not be fetched")
+	assert.Nil(t, statedb.GetCode(address))
+	err := handler.StateShareRejection("psi1", managementContract)
+	assert.True(t, errors.Is(err, ErrStateShareTooLarge), "unexpected error %v", err)
+}
+
+func TestExtensionHandler_ReapplyStateShare_StateShareTooLarge(t *testing.T) {
+	managementContract := common.HexToAddress("0x9ccd1e1089c79fe1cca81601fc9ccfa24f77eb58")
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	handler := bundleStateShareHandler(managementContract, `{
+		"0x2222222222222222222222222222222222222222": {"state": {"balance": "22", "nonce": 1, "code": "03030303"}}
+	}`)
+	handler.SetMaxStateShareSize(10)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	err := handler.ReapplyStateShare(statedb, address, common.BytesToEncryptedPayloadHash([]byte{20}).ToBase64())
+
+	assert.True(t, errors.Is(err, ErrStateShareTooLarge), "unexpected error %v", err)
+	assert.Nil(t, statedb.GetCode(address))
+}
@@ -19,18 +19,18 @@ type ServicesFactory interface {
 type DefaultServicesFactory struct {
 	backendService *PrivacyService
 	accountManager *accounts.Manager
-	dataHandler    *JsonFileDataHandler
+	dataHandler    DataHandler
 	stateFetcher   *StateFetcher
 }
 
-func NewServicesFactory(stack *node.Node, ptm private.PrivateTransactionManager, ethService *eth.Ethereum) (*DefaultServicesFactory, error) {
+func NewServicesFactory(stack *node.Node, ptm private.PrivateTransactionManager, ethService *eth.Ethereum, config Config) (*DefaultServicesFactory, error) {
 	factory := &DefaultServicesFactory{}
 
 	factory.accountManager = ethService.AccountManager()
-	factory.dataHandler = NewJsonFileDataHandler(stack.InstanceDir())
+	factory.dataHandler = NewDatabaseWatermarkDataHandler(NewJsonFileDataHandler(stack.InstanceDir()), ethService.ChainDb())
 	factory.stateFetcher = NewStateFetcher(ethService.BlockChain())
 
-	backendService, err := New(stack, ptm, factory.AccountManager(), factory.DataHandler(), factory.StateFetcher(), ethService.APIBackend)
+	backendService, err := New(stack, ptm, factory.AccountManager(), factory.DataHandler(), factory.StateFetcher(), ethService.APIBackend, config)
 	if err != nil {
 		return nil, err
 	}
@@ -39,6 +39,8 @@ func NewServicesFactory(stack *node.Node, ptm private.PrivateTransactionManager,
 	isMultitenant := ethService.BlockChain().SupportsMultitenancy(context.Background())
 	privacyExtension.DefaultExtensionHandler.SupportMultitenancy(isMultitenant)
 	privacyExtension.DefaultExtensionHandler.SetPSMR(ethService.BlockChain().PrivateStateManager())
+	privacyExtension.DefaultExtensionHandler.SetMaxStateShareSize(config.MaxStateShareSize)
+	privacyExtension.DefaultExtensionHandler.SetStateShareAppliedObserver(backendService.postStateShareApplied)
 
 	ethService.BlockChain().PopulateSetPrivateState(privacyExtension.DefaultExtensionHandler.CheckExtensionAndSetPrivateState)
 
@@ -11,6 +11,7 @@ import (
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/core/vm"
 	"github.com/kisexp/xdchain/extension/extensionContracts"
+	"github.com/kisexp/xdchain/params"
 	"github.com/kisexp/xdchain/rpc"
 	"github.com/jpmorganchase/quorum-security-plugin-sdk-go/proto"
 )
@@ -19,10 +20,13 @@ import (
 type ChainAccessor interface {
 	// GetBlockByHash retrieves a block from the local chain.
 	GetBlockByHash(common.Hash) *types.Block
+	// GetCanonicalHash returns the hash of the canonical block at the given height.
+	GetCanonicalHash(number uint64) common.Hash
 	StateAt(root common.Hash) (*state.StateDB, mps.PrivateStateRepository, error)
 	StateAtPSI(root common.Hash, psi types.PrivateStateIdentifier) (*state.StateDB, *state.StateDB, error)
 	State() (*state.StateDB, mps.PrivateStateRepository, error)
 	CurrentBlock() *types.Block
+	Config() *params.ChainConfig
 }
 
 // Only extract required methods from EthAPIBackend
@@ -52,15 +56,27 @@ func (fetcher *StateFetcher) getCurrentBlockHash() common.Hash {
 	return fetcher.chainAccessor.CurrentBlock().Hash()
 }
 
+// isCanonical checks whether the block is part of the canonical chain, it isn't if the block is unknown
+func (fetcher *StateFetcher) isCanonical(blockHash common.Hash) bool {
+	block := fetcher.chainAccessor.GetBlockByHash(blockHash)
+	return block != nil && fetcher.chainAccessor.GetCanonicalHash(block.NumberU64()) == blockHash
+}
+
 // GetAddressStateFromBlock is a public method that combines the other
 // functions of a StateFetcher, retrieving the state of an address at a given
 // block, represented in JSON.
 func (fetcher *StateFetcher) GetAddressStateFromBlock(blockHash common.Hash, addressToFetch common.Address, psi types.PrivateStateIdentifier) ([]byte, error) {
+	return fetcher.GetAddressesStateFromBlock(blockHash, []common.Address{addressToFetch}, psi)
+}
+
+// GetAddressesStateFromBlock retrieves the states of the addresses at a given block as a single JSON map.
+// No state is returned if the state of any of the addresses can't be fetched.
+func (fetcher *StateFetcher) GetAddressesStateFromBlock(blockHash common.Hash, addressesToFetch []common.Address, psi types.PrivateStateIdentifier) ([]byte, error) {
 	privateState, err := fetcher.privateState(blockHash, psi)
 	if err != nil {
 		return nil, err
 	}
-	stateData, err := fetcher.addressStateAsJson(privateState, addressToFetch)
+	stateData, err := fetcher.addressesStateAsJson(privateState, addressesToFetch)
 	if err != nil {
 		return nil, err
 	}
@@ -75,17 +91,38 @@ func (fetcher *StateFetcher) privateState(blockHash common.Hash, psi types.Priva
 	return privateState, err
 }
 
-// addressStateAsJson returns the state of an address, including the balance,
+// updateHeadPrivateState applies the update to the private state of the psi at the head of the chain and
+// writes the updated private states in place of the ones of the head block. The blocks imported from then
+// on build on the updated private state, blocks imported concurrently may lose the update.
+func (fetcher *StateFetcher) updateHeadPrivateState(psi types.PrivateStateIdentifier, update func(privateState *state.StateDB) error) error {
+	head := fetcher.chainAccessor.CurrentBlock()
+	_, privateStateRepo, err := fetcher.chainAccessor.StateAt(head.Root())
+	if err != nil {
+		return err
+	}
+	privateState, err := privateStateRepo.StatePSI(psi)
+	if err != nil {
+		return err
+	}
+	if err := update(privateState); err != nil {
+		return err
+	}
+	return privateStateRepo.CommitAndWrite(fetcher.chainAccessor.Config().IsEIP158(head.Number()), head)
+}
+
+// addressesStateAsJson returns the state of each address, including the balance,
 // nonce, code and state data as a JSON map.
-func (fetcher *StateFetcher) addressStateAsJson(privateState *state.StateDB, addressToShare common.Address) ([]byte, error) {
+func (fetcher *StateFetcher) addressesStateAsJson(privateState *state.StateDB, addressesToShare []common.Address) ([]byte, error) {
 	keepAddresses := make(map[string]extensionContracts.AccountWithMetadata)
 
-	if account, found := privateState.DumpAddress(addressToShare); found {
+	for _, addressToShare := range addressesToShare {
+		account, found := privateState.DumpAddress(addressToShare)
+		if !found {
+			return nil, fmt.Errorf("error in contract state fetch of %s", addressToShare.Hex())
+		}
 		keepAddresses[addressToShare.Hex()] = extensionContracts.AccountWithMetadata{
 			State: account,
 		}
-	} else {
-		return nil, fmt.Errorf("error in contract state fetch")
 	}
 	//types can be marshalled, so errors can't occur
 	out, _ := json.Marshal(&keepAddresses)
@@ -23,7 +23,7 @@ func TestDumpAddressWhenFound(t *testing.T) {
 	statedb.SetCode(address, []byte{3, 3, 3, 3, 3, 3, 3})
 	statedb.Commit(false)
 
-	out, _ := stateFetcher.addressStateAsJson(statedb, address)
+	out, _ := stateFetcher.addressesStateAsJson(statedb, []common.Address{address})
 
 	want := `{"0x2222222222222222222222222222222222222222":{"state":{"balance":"22","nonce":0,"root":"56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421","codeHash":"87874902497a5bb968da31a2998d8f22e949d1ef6214bcdedd8bae24cca4b9e3","code":"03030303030303"}}}`
 
@@ -41,9 +41,29 @@ func TestDumpAddressWhenNotFound(t *testing.T) {
 	stateFetcher := NewStateFetcher(nil)
 
 	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
-	out, _ := stateFetcher.addressStateAsJson(statedb, address)
+	out, _ := stateFetcher.addressesStateAsJson(statedb, []common.Address{address})
 
 	if out != nil {
 		t.Errorf("dump mismatch:\ngot: %s\nwant: nil\n", string(out))
 	}
 }
+
+func TestDumpAddressesWhenOneNotFound(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	address := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	statedb.SetCode(address, []byte{3, 3, 3, 3, 3, 3, 3})
+	statedb.Commit(false)
+
+	stateFetcher := NewStateFetcher(nil)
+
+	missing := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	out, err := stateFetcher.addressesStateAsJson(statedb, []common.Address{address, missing})
+
+	if out != nil {
+		t.Errorf("dump mismatch:\ngot: %s\nwant: nil\n", string(out))
+	}
+	if err == nil {
+		t.Errorf("expected an error for the missing address")
+	}
+}
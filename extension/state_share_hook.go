@@ -0,0 +1,75 @@
+package extension
+
+import (
+	"sync"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/extension/privacyExtension"
+	"github.com/kisexp/xdchain/log"
+)
+
+// stateShareHookQueueSize bounds the number of state shares applied waiting for the StateShareAppliedHook
+const stateShareHookQueueSize = 64
+
+// StateShareAppliedHook is called once the state shared by an extension has been applied to the private
+// state of the psi: contractExtended is the extended contract, ptmHash the hash of the shared state in the
+// transaction manager and uuid the one of the state share
+type StateShareAppliedHook func(psi types.PrivateStateIdentifier, contractExtended common.Address, ptmHash string, uuid string)
+
+// stateShareHook runs the StateShareAppliedHook in a worker, the state shares applied while the queue is
+// full are dropped so that the processing of the blocks is never blocked
+type stateShareHook struct {
+	mu    sync.Mutex
+	hook  StateShareAppliedHook
+	queue chan privacyExtension.StateShareAppliedEvent // nil until the worker is started
+}
+
+// SetStateShareAppliedHook replaces the hook called, in order, with each state share applied to a private
+// state of the node, e.g. to notify an off-chain system, a nil hook unregisters it. The hook is called by a
+// worker, up to stateShareHookQueueSize state shares wait for it, the others are dropped with a warning.
+// The worker stops with the service.
+func (service *PrivacyService) SetStateShareAppliedHook(hook StateShareAppliedHook) {
+	h := &service.stateShareHook
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hook = hook
+	if hook == nil || h.queue != nil {
+		return
+	}
+	h.queue = make(chan privacyExtension.StateShareAppliedEvent, stateShareHookQueueSize)
+	stopChan, stopSubscription := service.subscribeStopEvent()
+	go func(queue <-chan privacyExtension.StateShareAppliedEvent) {
+		defer stopSubscription.Unsubscribe()
+		for {
+			select {
+			case ev := <-queue:
+				if hook := h.current(); hook != nil {
+					hook(ev.PSI, ev.ContractExtended, ev.Hash, ev.Uuid)
+				}
+			case <-stopChan:
+				return
+			}
+		}
+	}(h.queue)
+}
+
+func (h *stateShareHook) current() StateShareAppliedHook {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.hook
+}
+
+// enqueue hands the state share applied to the worker, without waiting for it
+func (h *stateShareHook) enqueue(ev privacyExtension.StateShareAppliedEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hook == nil || h.queue == nil {
+		return
+	}
+	select {
+	case h.queue <- ev:
+	default:
+		log.Warn("Extension: state share applied not passed to the hook, the queue is full", "contract", ev.ContractExtended, "psi", ev.PSI, "uuid", ev.Uuid)
+	}
+}
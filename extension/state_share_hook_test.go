@@ -0,0 +1,73 @@
+package extension
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/extension/privacyExtension"
+	"github.com/stretchr/testify/assert"
+)
+
+type stateShareHookCall struct {
+	psi              types.PrivateStateIdentifier
+	contractExtended common.Address
+	ptmHash          string
+	uuid             string
+}
+
+func TestPrivacyService_SetStateShareAppliedHook(t *testing.T) {
+	service := &PrivacyService{}
+	calls := make(chan stateShareHookCall, 2)
+	service.SetStateShareAppliedHook(func(psi types.PrivateStateIdentifier, contractExtended common.Address, ptmHash string, uuid string) {
+		calls <- stateShareHookCall{psi, contractExtended, ptmHash, uuid}
+	})
+
+	// posted by the extension handler once the state share is applied
+	service.postStateShareApplied(privacyExtension.StateShareAppliedEvent{ContractExtended: common.HexToAddress("0x1"), PSI: "psi1", Hash: "hash1", Uuid: "uuid1"})
+	service.postStateShareApplied(privacyExtension.StateShareAppliedEvent{ContractExtended: common.HexToAddress("0x2"), PSI: "psi2", Hash: "hash2", Uuid: "uuid2"})
+
+	for _, expected := range []stateShareHookCall{
+		{"psi1", common.HexToAddress("0x1"), "hash1", "uuid1"},
+		{"psi2", common.HexToAddress("0x2"), "hash2", "uuid2"},
+	} {
+		select {
+		case call := <-calls:
+			assert.Equal(t, expected, call)
+		case <-time.After(time.Second):
+			t.Fatalf("hook not called for %+v", expected)
+		}
+	}
+
+	service.SetStateShareAppliedHook(nil)
+	service.postStateShareApplied(privacyExtension.StateShareAppliedEvent{PSI: "psi1"})
+	select {
+	case call := <-calls:
+		t.Errorf("unexpected call of the unregistered hook %+v", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPrivacyService_SetStateShareAppliedHook_DoesNotBlock(t *testing.T) {
+	service := &PrivacyService{}
+	release := make(chan struct{})
+	service.SetStateShareAppliedHook(func(types.PrivateStateIdentifier, common.Address, string, string) {
+		<-release
+	})
+	defer close(release)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*stateShareHookQueueSize; i++ {
+			service.postStateShareApplied(privacyExtension.StateShareAppliedEvent{PSI: "psi1"})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("state shares applied blocked by the hook")
+	}
+	service.stopFeed.Send(stopEvent{})
+}
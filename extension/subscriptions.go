@@ -1,58 +1,540 @@
 package extension
 
 import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"time"
+
 	"github.com/kisexp/xdchain"
+	"github.com/kisexp/xdchain/common"
 	"github.com/kisexp/xdchain/core/types"
 	"github.com/kisexp/xdchain/ethclient"
+	"github.com/kisexp/xdchain/extension/extensionContracts"
 	"github.com/kisexp/xdchain/log"
 	"github.com/kisexp/xdchain/node"
 	"github.com/kisexp/xdchain/private"
+	"github.com/kisexp/xdchain/private/engine/notinuse"
+	"github.com/kisexp/xdchain/rpc"
+)
+
+var (
+	// ErrRPCAttachFailed is returned when no in-process RPC client can be attached for a PSI
+	ErrRPCAttachFailed = errors.New("extension: could not connect to ethereum client rpc")
+
+	// ErrPTMUnavailable is returned when no private transaction manager is configured
+	ErrPTMUnavailable = errors.New("extension: private transaction manager is not available")
 )
 
 type subscriptionHandler struct {
-	facade  ManagementContractFacade
-	client  Client
-	service *PrivacyService
+	psi                 types.PrivateStateIdentifier
+	managementContracts []common.Address // watched management contracts, any if empty
+	facade              ManagementContractFacade
+	client              Client
+	service             *PrivacyService
 }
 
-func NewSubscriptionHandler(node *node.Node, psi types.PrivateStateIdentifier, ptm private.PrivateTransactionManager, service *PrivacyService) *subscriptionHandler {
-	rpcClient, err := node.AttachWithPSI(psi)
+// NewSubscriptionHandler creates the handler of the log subscriptions of the PSI watching the given
+// management contracts, or any management contract if none is given. The returned error wraps
+// ErrPTMUnavailable if the private transaction manager isn't configured and ErrRPCAttachFailed if
+// the node can't be reached, once the attach has been retried attachRetries times.
+func NewSubscriptionHandler(node *node.Node, psi types.PrivateStateIdentifier, ptm private.PrivateTransactionManager, service *PrivacyService, managementContracts ...common.Address) (*subscriptionHandler, error) {
+	if _, notInUse := ptm.(*notinuse.PrivateTransactionManager); ptm == nil || notInUse {
+		return nil, fmt.Errorf("%w for psi %s", ErrPTMUnavailable, psi)
+	}
+	rpcClient, err := attachWithRetry(node, psi)
 	if err != nil {
-		panic("extension: could not connect to ethereum client rpc")
+		return nil, fmt.Errorf("%w for psi %s: %v", ErrRPCAttachFailed, psi, err)
 	}
 
 	client := ethclient.NewClientWithPTM(rpcClient, ptm)
 
 	return &subscriptionHandler{
-		facade:  NewManagementContractFacade(client),
-		client:  NewInProcessClient(client),
-		service: service,
+		psi:                 psi,
+		managementContracts: append([]common.Address(nil), managementContracts...),
+		facade:              NewManagementContractFacade(client),
+		client:              NewInProcessClient(client),
+		service:             service,
+	}, nil
+}
+
+var (
+	// attachRetries is the number of times the attach to the node is retried, e.g. while the RPC stack
+	// of the node is still starting up
+	attachRetries        = 5
+	attachInitialBackoff = 100 * time.Millisecond
+	attachMaxBackoff     = 2 * time.Second
+
+	attachWithPSI = func(node *node.Node, psi types.PrivateStateIdentifier) (*rpc.Client, error) {
+		return node.AttachWithPSI(psi)
+	}
+)
+
+// attachWithRetry attaches an RPC client for the PSI to the node, retrying with backoff if it fails.
+// The error of the last attempt is returned if none succeeds.
+func attachWithRetry(node *node.Node, psi types.PrivateStateIdentifier) (*rpc.Client, error) {
+	backoff := reconnectBackoff{initial: attachInitialBackoff, max: attachMaxBackoff}
+	for attempt := 0; ; attempt++ {
+		rpcClient, err := attachWithPSI(node, psi)
+		if err == nil {
+			return rpcClient, nil
+		}
+		if attempt >= attachRetries {
+			return nil, err
+		}
+		log.Debug("Extension: failed to attach to the node, retrying", "psi", psi, "attempt", attempt+1, "err", err)
+		time.Sleep(backoff.delay(attempt))
 	}
 }
 
-func (handler *subscriptionHandler) createSub(query ethereum.FilterQuery, logHandlerCb func(types.Log)) error {
-	incomingLogs, subscription, err := handler.client.SubscribeToLogs(query)
+// ManagementContracts returns the management contracts watched by the handler, nil if it watches
+// any management contract
+func (handler *subscriptionHandler) ManagementContracts() []common.Address {
+	return append([]common.Address(nil), handler.managementContracts...)
+}
+
+// topicWatcher handles the logs of a single topic, it keeps track of its own last processed block
+type topicWatcher struct {
+	queryType string
+	topic     common.Hash
+	handle    func(log.Logger, types.Log)
+	// throttled watchers handle new extensions, admitted by the throttle of the service: the logs queued by the
+	// throttle are handled by a worker of the watcher, so that they don't hold up the other watchers
+	throttled bool
+}
+
+// createSub subscribes to the logs matching the query. If the watcher of the query has
+// processed logs before, the logs emitted since the last processed block are replayed first.
+//
+// The callback is given a logger carrying the PSI, the query type and the management contract
+// of the log being handled.
+func (handler *subscriptionHandler) createSub(queryType string, query ethereum.FilterQuery, logHandlerCb func(log.Logger, types.Log)) error {
+	return handler.createTopicsSub(query.Addresses, []topicWatcher{{queryType: queryType, topic: query.Topics[0][0], handle: logHandlerCb}})
+}
+
+// createTopicsSub subscribes once to the logs of all the topics of the watchers emitted by the
+// management contracts, the logs are dispatched to the watcher of their first topic.
+//
+// Each watcher keeps its own last processed block: the logs emitted since the oldest one are
+// replayed first, and a watcher only gets the replayed logs it hasn't handled yet. Once the
+// replay is done, the head at the time of subscribing is recorded as processed by all the
+// watchers so that the blocks imported while the node is down are replayed after a restart.
+func (handler *subscriptionHandler) createTopicsSub(managementContracts []common.Address, watchers []topicWatcher) error {
+	type watcherState struct {
+		topicWatcher
+		key           string
+		logger        log.Logger
+		replayed      bool
+		replayedFrom  uint64
+		replayedUntil uint64
+		queue         chan types.Log // the logs queued by the throttle, nil if not throttled
+	}
+
+	topics := make([]common.Hash, 0, len(watchers))
+	byTopic := make(map[common.Hash]*watcherState, len(watchers))
+	for _, w := range watchers {
+		logger := log.New("psi", handler.psi, "query", w.queryType)
+		if len(managementContracts) > 0 {
+			logger = logger.New("managementContracts", managementContracts)
+		}
+		topics = append(topics, w.topic)
+		byTopic[w.topic] = &watcherState{topicWatcher: w, key: watermarkKey(w.queryType, managementContracts), logger: logger}
+		if throttle := handler.service.throttle; w.throttled && throttle != nil && throttle.limit > 0 {
+			// the throttle never queues more logs than its queue size, sending to the queue doesn't block
+			byTopic[w.topic].queue = make(chan types.Log, throttle.queueSize)
+		}
+	}
+	query := ethereum.FilterQuery{
+		Topics:    [][]common.Hash{topics},
+		Addresses: append([]common.Address{}, managementContracts...),
+	}
+
+	// topicsSubscription is a subscription to the logs of the query along with the logs to replay
+	type topicsSubscription struct {
+		incomingLogs <-chan types.Log
+		subscription ethereum.Subscription
+		missedLogs   []types.Log
+		head         uint64
+	}
+	subscribe := func() (*topicsSubscription, error) {
+		incomingLogs, subscription, err := handler.client.SubscribeToLogs(query)
+		if err != nil {
+			return nil, err
+		}
 
+		// subscribing first guarantees no logs are lost between the replay and the subscription,
+		// logs from blocks already covered by the replay are then skipped
+		head, err := handler.client.BlockNumber()
+		if err != nil {
+			subscription.Unsubscribe()
+			return nil, err
+		}
+		sub := &topicsSubscription{incomingLogs: incomingLogs, subscription: subscription, head: head}
+		var (
+			resuming  bool
+			fromBlock uint64
+		)
+		for _, w := range byTopic {
+			w.replayed = false
+			if resumeFrom, ok := handler.service.resumeBlock(handler.psi, w.key); ok {
+				w.replayed, w.replayedFrom = true, resumeFrom
+				if !resuming || resumeFrom < fromBlock {
+					fromBlock = resumeFrom
+				}
+				resuming = true
+			}
+		}
+		if resuming {
+			for _, w := range byTopic {
+				w.replayedUntil = head
+			}
+			if fromBlock <= head {
+				replayQuery := query
+				replayQuery.FromBlock = new(big.Int).SetUint64(fromBlock)
+				replayQuery.ToBlock = new(big.Int).SetUint64(head)
+				if sub.missedLogs, err = handler.client.FilterLogs(replayQuery); err != nil {
+					subscription.Unsubscribe()
+					return nil, err
+				}
+				for _, w := range byTopic {
+					if w.replayed {
+						w.logger.Debug("Extension: replaying missed logs", "from", w.replayedFrom, "to", head)
+					}
+				}
+			}
+		}
+		return sub, nil
+	}
+	sub, err := subscribe()
 	if err != nil {
 		return err
 	}
 
+	watcherOf := func(l types.Log) *watcherState {
+		if len(watchers) == 1 {
+			// a single watcher gets all the logs of the query
+			for _, w := range byTopic {
+				return w
+			}
+		}
+		if len(l.Topics) == 0 {
+			return nil
+		}
+		return byTopic[l.Topics[0]]
+	}
+	process := func(w *watcherState, l types.Log) {
+		handler.service.handleLogOnce(handler.psi, l, func() {
+			w.handle(w.logger.New("managementContract", l.Address), l)
+		})
+	}
+	handleLog := func(w *watcherState, l types.Log) {
+		handler.service.handleUnlessPaused(handler.psi, l, func() {
+			if handler.service.isHandled(handler.psi, w.key, l) {
+				// handled before the node restarted or the subscription failed, its block is replayed
+				return
+			}
+			if w.queue != nil {
+				queued, err := handler.service.reserveNewExtension(handler.psi, l.Address)
+				if err != nil {
+					// the rejected log is replayed once the node restarts or the subscription fails
+					handler.service.holdWatermark(handler.psi, w.key, l)
+					w.logger.Warn("Extension: new extension not processed", "managementContract", l.Address, "blockNumber", l.BlockNumber, "error", err)
+					return
+				}
+				if queued {
+					handler.service.holdWatermark(handler.psi, w.key, l)
+					w.queue <- l
+					return
+				}
+			}
+			process(w, l)
+			if w.queue != nil {
+				// the log may have been rejected before, e.g. it is replayed after a failure of the subscription
+				handler.service.releaseWatermark(handler.psi, w.key, l)
+			}
+			handler.service.markHandled(handler.psi, w.key, l)
+		})
+	}
+
+	// subscribe to the stop event before starting the watcher so a stop can't be missed
+	stopChan, stopSubscription := handler.service.subscribeStopEvent()
+	errorLogs := newErrorLogLimiter(errorLogInterval)
+
+	// run handles the logs of the subscription until it fails or the watcher is stopped, it
+	// returns whether the watcher is stopped
+	run := func(sub *topicsSubscription) bool {
+		for _, missedLog := range sub.missedLogs {
+			select {
+			case <-stopChan:
+				return true
+			default:
+			}
+			if w := watcherOf(missedLog); w != nil && w.replayed && missedLog.BlockNumber >= w.replayedFrom {
+				handleLog(w, missedLog)
+			}
+		}
+		// the blocks up to the head are scanned, so they aren't replayed again after a restart
+		// even if a watcher had no log to handle
+		for _, w := range byTopic {
+			handler.service.markProcessed(handler.psi, w.key, sub.head)
+		}
+
+		for {
+			select {
+			case err := <-sub.subscription.Err():
+				errorLogs.log(log.Error, "Contract extension watcher subscription error", err, "psi", handler.psi)
+				return false
+			case foundLog := <-sub.incomingLogs:
+				w := watcherOf(foundLog)
+				if w == nil {
+					log.Debug("Extension: ignoring log without watcher", "psi", handler.psi, "address", foundLog.Address, "topics", foundLog.Topics)
+					continue
+				}
+				if w.replayed && foundLog.BlockNumber <= w.replayedUntil {
+					// already handled as part of the replay
+					continue
+				}
+				if foundLog.Removed {
+					// the block of the log has been orphaned by a reorg, the log is emitted again if its
+					// transaction is included in the canonical chain
+					w.logger.Debug("Extension: ignoring log removed by a reorg", "managementContract", foundLog.Address, "blockHash", foundLog.BlockHash)
+					continue
+				}
+				handleLog(w, foundLog)
+			case <-stopChan:
+				return true
+			}
+		}
+	}
+
+	// resubscribe subscribes again once the subscription failed, until it succeeds or the watcher is
+	// stopped. The logs emitted in the meantime are replayed by the new subscription.
+	resubscribe := func() *topicsSubscription {
+		backoff := handler.service.reconnectBackoff()
+		for attempt := 0; ; attempt++ {
+			select {
+			case <-time.After(backoff.delay(attempt)):
+			case <-stopChan:
+				return nil
+			}
+			sub, err := subscribe()
+			if err == nil {
+				log.Info("Extension: resubscribed to the logs", "psi", handler.psi, "attempts", attempt+1)
+				return sub
+			}
+			errorLogs.log(log.Warn, "Extension: failed to resubscribe to the logs", err, "psi", handler.psi, "attempt", attempt+1)
+		}
+	}
+
+	for _, w := range byTopic {
+		if w := w; w.queue != nil {
+			queueStopChan, queueStopSubscription := handler.service.subscribeStopEvent()
+			handler.service.watchers.Add(1)
+			go func() {
+				defer handler.service.watchers.Done()
+				defer queueStopSubscription.Unsubscribe()
+				handler.processQueued(w.key, w.logger, w.queue, queueStopChan, func(l types.Log) { process(w, l) })
+			}()
+		}
+	}
+
+	handler.service.watchers.Add(1)
+	go func() {
+		defer handler.service.watchers.Done()
+		defer stopSubscription.Unsubscribe()
+
+		for {
+			stopped := run(sub)
+			sub.subscription.Unsubscribe()
+			if stopped {
+				return
+			}
+			if sub = resubscribe(); sub == nil {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// processQueued processes the logs queued by the throttle, in order, once their turn comes, until the watcher
+// is stopped. The logs still queued then are rejected, they are replayed once the node restarts.
+func (handler *subscriptionHandler) processQueued(watcher string, logger log.Logger, queue <-chan types.Log, stopChan <-chan stopEvent, process func(types.Log)) {
+	for {
+		select {
+		case l := <-queue:
+			if err := handler.service.throttle.waitQueued(handler.psi, l.Address, stopChan); err != nil {
+				logger.Warn("Extension: new extension not processed", "managementContract", l.Address, "blockNumber", l.BlockNumber, "error", err)
+				handler.drainQueue(queue)
+				return
+			}
+			process(l)
+			handler.service.releaseWatermark(handler.psi, watcher, l)
+			handler.service.markHandled(handler.psi, watcher, l)
+		case <-stopChan:
+			handler.drainQueue(queue)
+			return
+		}
+	}
+}
+
+// drainQueue rejects the logs left in the queue of the throttle
+func (handler *subscriptionHandler) drainQueue(queue <-chan types.Log) {
+	for {
+		select {
+		case l := <-queue:
+			handler.service.throttle.cancelQueued(handler.psi, l.Address)
+		default:
+			return
+		}
+	}
+}
+
+// knownTopics are the topics of the events of the management contract ABI, the watchers only handle
+// some of them
+var knownTopics = func() map[common.Hash]struct{} {
+	topics := make(map[common.Hash]struct{}, len(extensionContracts.ContractExtenderParsedABI.Events))
+	for _, event := range extensionContracts.ContractExtenderParsedABI.Events {
+		topics[event.ID] = struct{}{}
+	}
+	return topics
+}()
+
+// watchUnknownTopics subscribes to all the logs of the management contracts, or of the tracked ones if
+// none is given, to report the logs whose topic isn't an event of the management contract ABI, e.g.
+// once the management contract is upgraded. The logs emitted while the subscription is down are missed.
+func (handler *subscriptionHandler) watchUnknownTopics(managementContracts []common.Address) error {
+	query := ethereum.FilterQuery{Addresses: append([]common.Address{}, managementContracts...)}
+	incomingLogs, subscription, err := handler.client.SubscribeToLogs(query)
+	if err != nil {
+		return err
+	}
+	stopChan, stopSubscription := handler.service.subscribeStopEvent()
+	errorLogs := newErrorLogLimiter(errorLogInterval)
+
+	handler.service.watchers.Add(1)
 	go func() {
-		stopChan, stopSubscription := handler.service.subscribeStopEvent()
+		defer handler.service.watchers.Done()
 		defer stopSubscription.Unsubscribe()
 
+		backoff := handler.service.reconnectBackoff()
 		for {
 			select {
 			case err := <-subscription.Err():
-				log.Error("Contract extension watcher subscription error", "error", err)
-				break
-			case foundLog := <-incomingLogs:
-				logHandlerCb(foundLog)
+				subscription.Unsubscribe()
+				errorLogs.log(log.Error, "Extension: unknown topics subscription error", err, "psi", handler.psi)
+				for attempt := 0; ; attempt++ {
+					select {
+					case <-time.After(backoff.delay(attempt)):
+					case <-stopChan:
+						return
+					}
+					if incomingLogs, subscription, err = handler.client.SubscribeToLogs(query); err == nil {
+						break
+					}
+					errorLogs.log(log.Warn, "Extension: failed to resubscribe to the unknown topics", err, "psi", handler.psi, "attempt", attempt+1)
+				}
+			case l := <-incomingLogs:
+				if len(l.Topics) > 0 {
+					if _, known := knownTopics[l.Topics[0]]; known {
+						continue
+					}
+				}
+				if len(managementContracts) == 0 && !handler.service.isTracked(handler.psi, l.Address) {
+					continue
+				}
+				handler.service.recordUnknownTopic(handler.psi, l)
 			case <-stopChan:
+				subscription.Unsubscribe()
 				return
 			}
 		}
 	}()
+	return nil
+}
+
+// reconnectBackoff is the wait before each attempt to subscribe again to the logs once a subscription
+// failed: it doubles from initial up to max, plus a random jitter of up to maxJitter so that the nodes
+// which lost their subscriptions at the same time don't all subscribe again at once
+type reconnectBackoff struct {
+	initial   time.Duration
+	max       time.Duration
+	maxJitter time.Duration
+}
+
+var (
+	reconnectInitialBackoff = time.Second
+	reconnectMaxBackoff     = time.Minute
+)
 
+// delay returns the wait before the given attempt, counted from 0
+func (b reconnectBackoff) delay(attempt int) time.Duration {
+	delay := b.initial
+	for i := 0; i < attempt && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	if b.maxJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(b.maxJitter) + 1))
+	}
+	return delay
+}
+
+// backfill handles the logs of all the topics of the watchers emitted by the management contracts between
+// the from and to blocks, inclusive, in the order they were emitted. A nil from block is the genesis, a nil
+// to block is the current head. The watermarks of the watchers are left unchanged.
+func (handler *subscriptionHandler) backfill(managementContracts []common.Address, watchers []topicWatcher, from, to *big.Int) error {
+	if from == nil {
+		from = new(big.Int)
+	}
+	if to == nil {
+		head, err := handler.client.BlockNumber()
+		if err != nil {
+			return err
+		}
+		to = new(big.Int).SetUint64(head)
+	}
+	topics := make([]common.Hash, 0, len(watchers))
+	byTopic := make(map[common.Hash]topicWatcher, len(watchers))
+	for _, w := range watchers {
+		topics = append(topics, w.topic)
+		byTopic[w.topic] = w
+	}
+	logs, err := handler.client.FilterLogs(ethereum.FilterQuery{
+		FromBlock: from,
+		ToBlock:   to,
+		Topics:    [][]common.Hash{topics},
+		Addresses: append([]common.Address{}, managementContracts...),
+	})
+	if err != nil {
+		return err
+	}
+	logger := log.New("psi", handler.psi, "backfill", true)
+	logger.Debug("Extension: backfilling logs", "from", from, "to", to, "logs", len(logs))
+	for _, l := range logs {
+		if len(l.Topics) == 0 {
+			continue
+		}
+		w, ok := byTopic[l.Topics[0]]
+		if !ok {
+			continue
+		}
+		l := l
+		handler.service.handleUnlessPaused(handler.psi, l, func() {
+			if w.throttled {
+				if err := handler.service.throttleNewExtension(handler.psi, l.Address); err != nil {
+					logger.Warn("Extension: new extension not processed", "managementContract", l.Address, "blockNumber", l.BlockNumber, "error", err)
+					return
+				}
+			}
+			handler.service.handleLogOnce(handler.psi, l, func() {
+				w.handle(logger.New("query", w.queryType, "managementContract", l.Address), l)
+			})
+		})
+	}
 	return nil
 }
@@ -0,0 +1,758 @@
+package extension
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/kisexp/xdchain"
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/rawdb"
+	"github.com/kisexp/xdchain/core/types"
+	"github.com/kisexp/xdchain/extension/extensionContracts"
+	"github.com/kisexp/xdchain/log"
+	"github.com/kisexp/xdchain/node"
+	"github.com/kisexp/xdchain/private"
+	"github.com/kisexp/xdchain/private/engine/notinuse"
+	"github.com/kisexp/xdchain/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockSubscription struct {
+	errC chan error
+}
+
+func (s *mockSubscription) Unsubscribe() {}
+
+func (s *mockSubscription) Err() <-chan error { return s.errC }
+
+type mockClient struct {
+	incomingLogs  chan types.Log
+	subscriptions chan *mockSubscription // receives the subscriptions created, if set
+	pastLogs      []types.Log
+	blockNumber   uint64
+	filterQuery   *ethereum.FilterQuery
+	subQuery      *ethereum.FilterQuery
+}
+
+func (c *mockClient) SubscribeToLogs(query ethereum.FilterQuery) (<-chan types.Log, ethereum.Subscription, error) {
+	c.subQuery = &query
+	sub := &mockSubscription{errC: make(chan error)}
+	if c.subscriptions != nil {
+		c.subscriptions <- sub
+	}
+	return c.incomingLogs, sub, nil
+}
+
+func (c *mockClient) FilterLogs(query ethereum.FilterQuery) ([]types.Log, error) {
+	c.filterQuery = &query
+	var logs []types.Log
+	for _, l := range c.pastLogs {
+		if l.BlockNumber >= query.FromBlock.Uint64() && l.BlockNumber <= query.ToBlock.Uint64() {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+func (c *mockClient) BlockNumber() (uint64, error) { return c.blockNumber, nil }
+
+func (c *mockClient) NextNonce(from common.Address) (uint64, error) { panic("not implemented") }
+
+func (c *mockClient) TransactionByHash(hash common.Hash) (*types.Transaction, error) {
+	panic("not implemented")
+}
+
+func (c *mockClient) TransactionInBlock(blockHash common.Hash, txIndex uint) (*types.Transaction, error) {
+	panic("not implemented")
+}
+
+func (c *mockClient) Close() {}
+
+func waitForLogs(t *testing.T, handled <-chan types.Log, n int) []types.Log {
+	var logs []types.Log
+	for i := 0; i < n; i++ {
+		select {
+		case l := <-handled:
+			logs = append(logs, l)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for log %d", i)
+		}
+	}
+	return logs
+}
+
+func TestSubscriptionHandler_createSub_ReplaysMissedLogs(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.DefaultPrivateStateIdentifier
+	service := &PrivacyService{
+		dataHandler: NewJsonFileDataHandler(datadir),
+		watermarks:  map[types.PrivateStateIdentifier]map[string]uint64{psi: {newExtensionQueryType: 4}},
+	}
+	defer service.Stop()
+	client := &mockClient{
+		incomingLogs: make(chan types.Log),
+		pastLogs:     []types.Log{{BlockNumber: 3}, {BlockNumber: 5}, {BlockNumber: 6}},
+		blockNumber:  6,
+	}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	handled := make(chan types.Log)
+	err = handler.createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) { handled <- l })
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint64(5), client.filterQuery.FromBlock.Uint64())
+	assert.Equal(t, uint64(6), client.filterQuery.ToBlock.Uint64())
+	replayedLogs := waitForLogs(t, handled, 2)
+	assert.Equal(t, []types.Log{{BlockNumber: 5}, {BlockNumber: 6}}, replayedLogs)
+
+	// logs from blocks covered by the replay are skipped
+	client.incomingLogs <- types.Log{BlockNumber: 6}
+	client.incomingLogs <- types.Log{BlockNumber: 7}
+	newLogs := waitForLogs(t, handled, 1)
+	assert.Equal(t, uint64(7), newLogs[0].BlockNumber)
+
+	// the block of the log is recorded once a log of a later block is handled, it may hold more logs
+	client.incomingLogs <- types.Log{BlockNumber: 9}
+	waitForLogs(t, handled, 1)
+	assert.Eventually(t, func() bool {
+		resumeFrom, ok := service.resumeBlock(psi, newExtensionQueryType)
+		return ok && resumeFrom == 9
+	}, time.Second, 10*time.Millisecond)
+	persisted, err := service.dataHandler.LoadWatermarks()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8), persisted[psi][newExtensionQueryType])
+}
+
+func TestSubscriptionHandler_createSub_NoReplayWithoutWatermark(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.DefaultPrivateStateIdentifier
+	service := &PrivacyService{dataHandler: NewJsonFileDataHandler(datadir)}
+	defer service.Stop()
+	client := &mockClient{
+		incomingLogs: make(chan types.Log),
+		pastLogs:     []types.Log{{BlockNumber: 3}},
+		blockNumber:  6,
+	}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	handled := make(chan types.Log)
+	err = handler.createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) { handled <- l })
+	assert.NoError(t, err)
+	assert.Nil(t, client.filterQuery, "no replay expected")
+
+	client.incomingLogs <- types.Log{BlockNumber: 2}
+	newLogs := waitForLogs(t, handled, 1)
+	assert.Equal(t, uint64(2), newLogs[0].BlockNumber)
+}
+
+func TestSubscriptionHandler_createSub_IgnoresRemovedLogs(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.DefaultPrivateStateIdentifier
+	service := &PrivacyService{dataHandler: NewJsonFileDataHandler(datadir)}
+	defer service.Stop()
+	client := &mockClient{incomingLogs: make(chan types.Log), blockNumber: 6}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	handled := make(chan types.Log)
+	err = handler.createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) { handled <- l })
+	assert.NoError(t, err)
+
+	// the log of the orphaned block is removed by the reorg, then emitted again in the canonical block
+	client.incomingLogs <- types.Log{BlockNumber: 7, BlockHash: common.Hash{1}, Removed: true}
+	client.incomingLogs <- types.Log{BlockNumber: 7, BlockHash: common.Hash{2}}
+	newLogs := waitForLogs(t, handled, 1)
+	assert.Equal(t, common.Hash{2}, newLogs[0].BlockHash)
+	assert.False(t, newLogs[0].Removed)
+}
+
+func TestSubscriptionHandler_createSub_RecordsScannedHeadWithoutLogs(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.DefaultPrivateStateIdentifier
+	service := &PrivacyService{dataHandler: NewJsonFileDataHandler(datadir)}
+	defer service.Stop()
+	client := &mockClient{incomingLogs: make(chan types.Log), blockNumber: 6}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	err = handler.createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) {})
+	assert.NoError(t, err)
+
+	// no log handled, the logs of the blocks imported after the head are replayed after a restart
+	assert.Eventually(t, func() bool {
+		persisted, err := service.dataHandler.LoadWatermarks()
+		return err == nil && persisted[psi][newExtensionQueryType] == 6
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestPrivacyService_UnsubscribeAll(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	service := &PrivacyService{dataHandler: NewJsonFileDataHandler(datadir)}
+	for _, psi := range []types.PrivateStateIdentifier{"psi1", "psi2"} {
+		handler := &subscriptionHandler{psi: psi, client: &mockClient{incomingLogs: make(chan types.Log)}, service: service}
+		err = handler.createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) {})
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, service.UnsubscribeAll())
+}
+
+func TestPrivacyService_UnsubscribeAll_TimesOut(t *testing.T) {
+	saved := watcherShutdownTimeout
+	defer func() { watcherShutdownTimeout = saved }()
+	watcherShutdownTimeout = 10 * time.Millisecond
+
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	service := &PrivacyService{dataHandler: NewJsonFileDataHandler(datadir)}
+	client := &mockClient{incomingLogs: make(chan types.Log)}
+	handler := &subscriptionHandler{psi: types.DefaultPrivateStateIdentifier, client: client, service: service}
+	release := make(chan struct{})
+	err = handler.createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) { <-release })
+	assert.NoError(t, err)
+	// the watcher is busy handling a log so it can't stop in time
+	client.incomingLogs <- types.Log{BlockNumber: 1}
+
+	assert.Error(t, service.UnsubscribeAll())
+
+	close(release)
+	service.watchers.Wait()
+}
+
+func TestSubscriptionHandler_createSub_IndependentWatchersPerManagementContract(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.DefaultPrivateStateIdentifier
+	contract1 := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	contract2 := common.HexToAddress("0x9d13c6d3afe1721beef56b55d303b09e021e27ab")
+	query1, query2 := newExtensionQuery(contract1), newExtensionQuery(contract2)
+	service := &PrivacyService{
+		dataHandler: NewJsonFileDataHandler(datadir),
+		watermarks: map[types.PrivateStateIdentifier]map[string]uint64{psi: {
+			watermarkKey(newExtensionQueryType, query1.Addresses): 4,
+		}},
+	}
+	defer service.Stop()
+
+	client1 := &mockClient{incomingLogs: make(chan types.Log), pastLogs: []types.Log{{BlockNumber: 5}}, blockNumber: 5}
+	client2 := &mockClient{incomingLogs: make(chan types.Log), pastLogs: []types.Log{{BlockNumber: 5}}, blockNumber: 5}
+	handled1, handled2 := make(chan types.Log), make(chan types.Log)
+
+	err = (&subscriptionHandler{psi: psi, client: client1, service: service}).createSub(newExtensionQueryType, query1, func(_ log.Logger, l types.Log) { handled1 <- l })
+	assert.NoError(t, err)
+	err = (&subscriptionHandler{psi: psi, client: client2, service: service}).createSub(newExtensionQueryType, query2, func(_ log.Logger, l types.Log) { handled2 <- l })
+	assert.NoError(t, err)
+
+	// only the watcher with a watermark replays, and each query filters on its own contract
+	assert.Equal(t, []common.Address{contract1}, client1.filterQuery.Addresses)
+	assert.Nil(t, client2.filterQuery, "no replay expected")
+	waitForLogs(t, handled1, 1)
+
+	client2.incomingLogs <- types.Log{BlockNumber: 9}
+	waitForLogs(t, handled2, 1)
+
+	assert.Eventually(t, func() bool {
+		resumeFrom, ok := service.resumeBlock(psi, watermarkKey(newExtensionQueryType, query2.Addresses))
+		return ok && resumeFrom == 9
+	}, time.Second, 10*time.Millisecond)
+	resumeFrom, _ := service.resumeBlock(psi, watermarkKey(newExtensionQueryType, query1.Addresses))
+	assert.Equal(t, uint64(6), resumeFrom)
+	_, ok := service.resumeBlock(psi, newExtensionQueryType)
+	assert.False(t, ok, "the watcher of all contracts is not affected")
+}
+
+func TestLogQueries_NotShared(t *testing.T) {
+	query := newExtensionQuery()
+	assert.Empty(t, query.Addresses)
+	assert.Equal(t, newExtensionQueryType, watermarkKey(newExtensionQueryType, query.Addresses))
+
+	query.Addresses = append(query.Addresses, common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17"))
+	query.Topics[0][0] = common.Hash{}
+
+	fresh := newExtensionQuery()
+	assert.Empty(t, fresh.Addresses)
+	assert.Equal(t, common.HexToHash(extensionContracts.NewContractExtensionContractCreatedTopicHash), fresh.Topics[0][0])
+}
+
+func TestSubscriptionHandler_createSub_LoggerCarriesWatcherContext(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.PrivateStateIdentifier("psi1")
+	service := &PrivacyService{dataHandler: NewJsonFileDataHandler(datadir)}
+	defer service.Stop()
+	client := &mockClient{incomingLogs: make(chan types.Log)}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	records := make(chan *log.Record, 1)
+	err = handler.createSub(newExtensionQueryType, newExtensionQuery(), func(logger log.Logger, l types.Log) {
+		logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+			records <- r
+			return nil
+		}))
+		logger.Info("handled")
+	})
+	assert.NoError(t, err)
+
+	managementContract := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	client.incomingLogs <- types.Log{Address: managementContract, BlockNumber: 1}
+
+	select {
+	case r := <-records:
+		ctx := make(map[interface{}]interface{})
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			ctx[r.Ctx[i]] = r.Ctx[i+1]
+		}
+		assert.Equal(t, psi, ctx["psi"])
+		assert.Equal(t, newExtensionQueryType, ctx["query"])
+		assert.Equal(t, managementContract, ctx["managementContract"])
+	case <-time.After(time.Second):
+		t.Fatal("log not handled")
+	}
+}
+
+func TestNewSubscriptionHandler_PTMUnavailable(t *testing.T) {
+	for _, ptm := range []private.PrivateTransactionManager{nil, &notinuse.PrivateTransactionManager{}} {
+		handler, err := NewSubscriptionHandler(nil, types.DefaultPrivateStateIdentifier, ptm, &PrivacyService{})
+
+		assert.Nil(t, handler)
+		assert.True(t, errors.Is(err, ErrPTMUnavailable), "unexpected error %v", err)
+		assert.False(t, errors.Is(err, ErrRPCAttachFailed))
+	}
+}
+
+func TestNewSubscriptionHandler_RetriesAttach(t *testing.T) {
+	defer func(attach func(*node.Node, types.PrivateStateIdentifier) (*rpc.Client, error), backoff time.Duration) {
+		attachWithPSI, attachInitialBackoff = attach, backoff
+	}(attachWithPSI, attachInitialBackoff)
+	attachInitialBackoff = time.Millisecond
+
+	ptm := private.NewMockPrivateTransactionManager(gomock.NewController(t))
+	attempts := 0
+	attachWithPSI = func(_ *node.Node, psi types.PrivateStateIdentifier) (*rpc.Client, error) {
+		if attempts++; attempts == 1 {
+			return nil, errors.New("rpc not ready")
+		}
+		return rpc.DialInProc(rpc.NewServer()).WithPSI(psi), nil
+	}
+
+	handler, err := NewSubscriptionHandler(nil, types.DefaultPrivateStateIdentifier, ptm, &PrivacyService{})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, handler)
+	assert.Equal(t, 2, attempts)
+
+	attempts = 0
+	attachWithPSI = func(*node.Node, types.PrivateStateIdentifier) (*rpc.Client, error) {
+		attempts++
+		return nil, errors.New("rpc not ready")
+	}
+
+	handler, err = NewSubscriptionHandler(nil, types.DefaultPrivateStateIdentifier, ptm, &PrivacyService{})
+
+	assert.Nil(t, handler)
+	assert.True(t, errors.Is(err, ErrRPCAttachFailed), "unexpected error %v", err)
+	assert.Equal(t, attachRetries+1, attempts)
+}
+
+func TestSubscriptionHandler_createTopicsSub_RoutesLogsByTopic(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.DefaultPrivateStateIdentifier
+	newTopic := common.HexToHash(extensionContracts.NewContractExtensionContractCreatedTopicHash)
+	finishedTopic := common.HexToHash(extensionContracts.ExtensionFinishedTopicHash)
+	stateShareTopic := common.HexToHash(extensionContracts.CanPerformStateShareTopicHash)
+	service := &PrivacyService{
+		dataHandler: NewJsonFileDataHandler(datadir),
+		watermarks: map[types.PrivateStateIdentifier]map[string]uint64{psi: {
+			newExtensionQueryType:      2,
+			finishedExtensionQueryType: 4,
+		}},
+	}
+	defer service.Stop()
+	client := &mockClient{
+		incomingLogs: make(chan types.Log),
+		pastLogs: []types.Log{
+			{BlockNumber: 3, Topics: []common.Hash{newTopic}},
+			{BlockNumber: 4, Topics: []common.Hash{finishedTopic}},
+			{BlockNumber: 5, Topics: []common.Hash{finishedTopic}},
+			{BlockNumber: 5, Topics: []common.Hash{stateShareTopic}},
+		},
+		blockNumber: 5,
+	}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	handled := map[string]chan types.Log{
+		newExtensionQueryType:         make(chan types.Log, 10),
+		finishedExtensionQueryType:    make(chan types.Log, 10),
+		canPerformStateShareQueryType: make(chan types.Log, 10),
+	}
+	watcher := func(queryType string, topic common.Hash) topicWatcher {
+		return topicWatcher{queryType: queryType, topic: topic, handle: func(_ log.Logger, l types.Log) { handled[queryType] <- l }}
+	}
+	err = handler.createTopicsSub(nil, []topicWatcher{
+		watcher(newExtensionQueryType, newTopic),
+		watcher(finishedExtensionQueryType, finishedTopic),
+		watcher(canPerformStateShareQueryType, stateShareTopic),
+	})
+	assert.NoError(t, err)
+
+	// a single subscription for all the topics, replaying from the oldest watermark
+	assert.Equal(t, [][]common.Hash{{newTopic, finishedTopic, stateShareTopic}}, client.subQuery.Topics)
+	assert.Equal(t, uint64(3), client.filterQuery.FromBlock.Uint64())
+
+	// each watcher only gets the replayed logs it hasn't processed, the watcher without
+	// a watermark doesn't replay
+	assert.Equal(t, uint64(3), waitForLogs(t, handled[newExtensionQueryType], 1)[0].BlockNumber)
+	assert.Equal(t, uint64(5), waitForLogs(t, handled[finishedExtensionQueryType], 1)[0].BlockNumber)
+
+	client.incomingLogs <- types.Log{BlockNumber: 5, Topics: []common.Hash{newTopic}}
+	client.incomingLogs <- types.Log{BlockNumber: 6, Topics: []common.Hash{stateShareTopic}}
+	client.incomingLogs <- types.Log{BlockNumber: 7, Topics: []common.Hash{finishedTopic}}
+	client.incomingLogs <- types.Log{BlockNumber: 8, Topics: []common.Hash{newTopic}}
+
+	assert.Equal(t, uint64(6), waitForLogs(t, handled[canPerformStateShareQueryType], 1)[0].BlockNumber)
+	assert.Equal(t, uint64(7), waitForLogs(t, handled[finishedExtensionQueryType], 1)[0].BlockNumber)
+	assert.Equal(t, uint64(8), waitForLogs(t, handled[newExtensionQueryType], 1)[0].BlockNumber)
+	for queryType, c := range handled {
+		assert.Empty(t, c, "unexpected log for %s", queryType)
+	}
+}
+
+func TestSubscriptionHandler_createTopicsSub_ThrottlesNewExtensions(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.DefaultPrivateStateIdentifier
+	newTopic := common.HexToHash(extensionContracts.NewContractExtensionContractCreatedTopicHash)
+	stateShareTopic := common.HexToHash(extensionContracts.CanPerformStateShareTopicHash)
+	interval := 200 * time.Millisecond
+	service := &PrivacyService{
+		dataHandler: NewJsonFileDataHandler(datadir),
+		throttle:    newExtensionThrottle(Config{NewExtensionRateLimit: 1, NewExtensionRateInterval: interval, NewExtensionQueueSize: 1}),
+	}
+	defer service.Stop()
+	client := &mockClient{incomingLogs: make(chan types.Log), blockNumber: 4}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	handled := map[string]chan types.Log{
+		newExtensionQueryType:         make(chan types.Log, 10),
+		canPerformStateShareQueryType: make(chan types.Log, 10),
+	}
+	err = handler.createTopicsSub(nil, []topicWatcher{
+		{queryType: newExtensionQueryType, topic: newTopic, throttled: true, handle: func(_ log.Logger, l types.Log) { handled[newExtensionQueryType] <- l }},
+		{queryType: canPerformStateShareQueryType, topic: stateShareTopic, handle: func(_ log.Logger, l types.Log) { handled[canPerformStateShareQueryType] <- l }},
+	})
+	assert.NoError(t, err)
+	newExtensionKey := watermarkKey(newExtensionQueryType, nil)
+	assert.Eventually(t, func() bool {
+		resumeFrom, ok := service.resumeBlock(psi, newExtensionQueryType)
+		return ok && resumeFrom == 5
+	}, time.Second, 10*time.Millisecond)
+
+	// the first new extension is admitted, the second is queued and the third is rejected
+	start := time.Now()
+	client.incomingLogs <- types.Log{BlockNumber: 5, TxHash: common.Hash{5}, Topics: []common.Hash{newTopic}}
+	client.incomingLogs <- types.Log{BlockNumber: 6, TxHash: common.Hash{6}, Topics: []common.Hash{newTopic}}
+	client.incomingLogs <- types.Log{BlockNumber: 7, TxHash: common.Hash{7}, Topics: []common.Hash{newTopic}}
+	client.incomingLogs <- types.Log{BlockNumber: 8, TxHash: common.Hash{8}, Topics: []common.Hash{stateShareTopic}}
+
+	// the other watchers aren't held up by the queued extension
+	assert.Equal(t, uint64(8), waitForLogs(t, handled[canPerformStateShareQueryType], 1)[0].BlockNumber)
+	assert.Equal(t, uint64(5), waitForLogs(t, handled[newExtensionQueryType], 1)[0].BlockNumber)
+	assert.Less(t, int64(time.Since(start)), int64(interval), "state share log held up by the throttle")
+	resumeFrom, _ := service.resumeBlock(psi, newExtensionKey)
+	assert.Equal(t, uint64(5), resumeFrom, "watermark moved past the queued extension")
+
+	assert.Equal(t, uint64(6), waitForLogs(t, handled[newExtensionQueryType], 1)[0].BlockNumber)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(interval))
+	assert.Empty(t, handled[newExtensionQueryType], "rejected extension processed")
+	assert.NotEmpty(t, service.throttle.rejection(psi, common.Address{}))
+
+	// the watermark stays below the rejected extension so that it's replayed after a restart
+	assert.Eventually(t, func() bool {
+		resumeFrom, _ := service.resumeBlock(psi, newExtensionKey)
+		return resumeFrom == 6
+	}, time.Second, 10*time.Millisecond)
+	persisted, err := service.dataHandler.LoadWatermarks()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), persisted[psi][newExtensionKey])
+	assert.Equal(t, uint64(7), persisted[psi][watermarkKey(canPerformStateShareQueryType, nil)])
+}
+
+func TestSubscriptionHandler_createSub_ResumesAfterRestart(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.DefaultPrivateStateIdentifier
+	db := rawdb.NewMemoryDatabase()
+	service := &PrivacyService{dataHandler: NewDatabaseWatermarkDataHandler(NewJsonFileDataHandler(datadir), db)}
+	client := &mockClient{incomingLogs: make(chan types.Log)}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	handled, release := make(chan types.Log), make(chan struct{})
+	err = handler.createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) {
+		handled <- l
+		if l.BlockNumber == 5 {
+			<-release
+		}
+	})
+	assert.NoError(t, err)
+	defer func() {
+		close(release)
+		service.Stop()
+	}()
+
+	for _, number := range []uint64{3, 4} {
+		client.incomingLogs <- types.Log{BlockNumber: number}
+		waitForLogs(t, handled, 1)
+	}
+	// the node stops while the log of block 5 is being handled
+	client.incomingLogs <- types.Log{BlockNumber: 5}
+	waitForLogs(t, handled, 1)
+
+	// the restarted node reads the watermarks back from the database
+	restarted := &PrivacyService{dataHandler: NewDatabaseWatermarkDataHandler(NewJsonFileDataHandler(datadir), db)}
+	assert.NoError(t, restarted.loadWatermarks())
+	defer restarted.Stop()
+	restartedClient := &mockClient{
+		incomingLogs: make(chan types.Log),
+		pastLogs:     []types.Log{{BlockNumber: 3}, {BlockNumber: 4}, {BlockNumber: 5}, {BlockNumber: 6}},
+		blockNumber:  6,
+	}
+	restartedHandled := make(chan types.Log)
+	err = (&subscriptionHandler{psi: psi, client: restartedClient, service: restarted}).createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) { restartedHandled <- l })
+	assert.NoError(t, err)
+
+	// the block of the last log handled is replayed as it may hold more logs, the log itself is skipped. The
+	// log being handled when the node stopped is handled again.
+	assert.Equal(t, uint64(4), restartedClient.filterQuery.FromBlock.Uint64())
+	assert.Equal(t, []types.Log{{BlockNumber: 5}, {BlockNumber: 6}}, waitForLogs(t, restartedHandled, 2))
+}
+
+func TestSubscriptionHandler_createSub_RestartWithinBlock(t *testing.T) {
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	psi := types.DefaultPrivateStateIdentifier
+	db := rawdb.NewMemoryDatabase()
+	service := &PrivacyService{dataHandler: NewDatabaseWatermarkDataHandler(NewJsonFileDataHandler(datadir), db)}
+	client := &mockClient{incomingLogs: make(chan types.Log), blockNumber: 4}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	first := types.Log{BlockNumber: 5, TxHash: common.Hash{1}, Index: 0}
+	second := types.Log{BlockNumber: 5, TxHash: common.Hash{2}, Index: 1}
+	handled := make(chan types.Log)
+	err = handler.createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) { handled <- l })
+	assert.NoError(t, err)
+
+	// the node stops once the first log of block 5 is handled, before the second one is received
+	client.incomingLogs <- first
+	waitForLogs(t, handled, 1)
+	assert.Eventually(t, func() bool {
+		resumeFrom, ok := service.resumeBlock(psi, newExtensionQueryType)
+		return ok && resumeFrom == 5
+	}, time.Second, 10*time.Millisecond)
+	service.Stop()
+
+	restarted := &PrivacyService{dataHandler: NewDatabaseWatermarkDataHandler(NewJsonFileDataHandler(datadir), db)}
+	assert.NoError(t, restarted.loadWatermarks())
+	assert.Equal(t, uint64(4), restarted.watermarks[psi][newExtensionQueryType], "block 5 recorded before all its logs are handled")
+	defer restarted.Stop()
+	restartedClient := &mockClient{incomingLogs: make(chan types.Log), pastLogs: []types.Log{first, second}, blockNumber: 5}
+	restartedHandled := make(chan types.Log, 2)
+	err = (&subscriptionHandler{psi: psi, client: restartedClient, service: restarted}).createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) { restartedHandled <- l })
+	assert.NoError(t, err)
+
+	// block 5 is replayed, its second log isn't lost and its first one isn't handled twice
+	assert.Equal(t, uint64(5), restartedClient.filterQuery.FromBlock.Uint64())
+	assert.Equal(t, []types.Log{second}, waitForLogs(t, restartedHandled, 1))
+
+	// the first log is forgotten once block 5 is recorded
+	restartedClient.incomingLogs <- types.Log{BlockNumber: 6, TxHash: common.Hash{3}}
+	waitForLogs(t, restartedHandled, 1)
+	assert.Eventually(t, func() bool {
+		handledLogs, err := restarted.dataHandler.LoadHandledLogs()
+		return err == nil && assert.ObjectsAreEqual([]HandledLog{{BlockNumber: 6, TxHash: common.Hash{3}}}, handledLogs[psi][newExtensionQueryType])
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, restartedHandled, "replayed log handled twice")
+}
+
+func TestSubscriptionHandler_backfill(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	newTopic := common.HexToHash(extensionContracts.NewContractExtensionContractCreatedTopicHash)
+	finishedTopic := common.HexToHash(extensionContracts.ExtensionFinishedTopicHash)
+	managementContract := common.HexToAddress("0x1")
+	logs := []types.Log{
+		{BlockNumber: 2, TxHash: common.HexToHash("0x2"), Topics: []common.Hash{newTopic}},
+		{BlockNumber: 3, TxHash: common.HexToHash("0x3"), Topics: []common.Hash{newTopic}},
+		{BlockNumber: 4, TxHash: common.HexToHash("0x4"), Topics: []common.Hash{finishedTopic}},
+		{BlockNumber: 6, TxHash: common.HexToHash("0x6"), Topics: []common.Hash{finishedTopic}},
+		{BlockNumber: 7, TxHash: common.HexToHash("0x7"), Topics: []common.Hash{newTopic}},
+	}
+	// a backfill is running and the live watchers already handled the log of block 4
+	service := &PrivacyService{backfills: 1, handledLogs: make(map[handledLog]struct{})}
+	service.handleLogOnce(psi, logs[2], func() {})
+	client := &mockClient{pastLogs: logs, blockNumber: 6}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	var handled []uint64
+	watcher := func(topic common.Hash) topicWatcher {
+		return topicWatcher{topic: topic, handle: func(_ log.Logger, l types.Log) { handled = append(handled, l.BlockNumber) }}
+	}
+	err := handler.backfill([]common.Address{managementContract}, []topicWatcher{watcher(newTopic), watcher(finishedTopic)}, big.NewInt(3), nil)
+	assert.NoError(t, err)
+
+	// the range ends at the head and the log handled live isn't handled again
+	assert.Equal(t, uint64(6), client.filterQuery.ToBlock.Uint64())
+	assert.Equal(t, [][]common.Hash{{newTopic, finishedTopic}}, client.filterQuery.Topics)
+	assert.Equal(t, []common.Address{managementContract}, client.filterQuery.Addresses)
+	assert.Equal(t, []uint64{3, 6}, handled)
+
+	// neither is a log handled by the backfill once the live watchers get it
+	service.handleLogOnce(psi, logs[1], func() { t.Error("log handled twice") })
+}
+
+func TestSubscriptionHandler_ManagementContracts(t *testing.T) {
+	assert.Nil(t, (&subscriptionHandler{}).ManagementContracts(), "any management contract expected")
+
+	contracts := []common.Address{common.HexToAddress("0x1"), common.HexToAddress("0x2")}
+	handler := &subscriptionHandler{managementContracts: contracts}
+
+	got := handler.ManagementContracts()
+	assert.Equal(t, contracts, got)
+
+	got[0] = common.HexToAddress("0x3")
+	assert.Equal(t, common.HexToAddress("0x1"), handler.ManagementContracts()[0], "the handler contracts must not be shared")
+}
+
+func TestReconnectBackoff_Delay(t *testing.T) {
+	backoff := reconnectBackoff{initial: time.Second, max: 8 * time.Second, maxJitter: 500 * time.Millisecond}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second, 8 * time.Second}
+
+	for i := 0; i < 100; i++ {
+		for attempt, base := range expected {
+			delay := backoff.delay(attempt)
+			assert.True(t, delay >= base && delay <= base+backoff.maxJitter, "delay %v of attempt %d out of [%v, %v]", delay, attempt, base, base+backoff.maxJitter)
+		}
+	}
+
+	backoff.maxJitter = 0
+	for attempt, base := range expected {
+		assert.Equal(t, base, backoff.delay(attempt))
+	}
+}
+
+func TestSubscriptionHandler_createTopicsSub_Resubscribes(t *testing.T) {
+	defer func(initial time.Duration) { reconnectInitialBackoff = initial }(reconnectInitialBackoff)
+	reconnectInitialBackoff = time.Millisecond
+
+	datadir, err := ioutil.TempDir("", t.Name())
+	defer os.RemoveAll(datadir)
+	assert.Nil(t, err, "could not create temp directory for test")
+
+	service := &PrivacyService{dataHandler: NewJsonFileDataHandler(datadir), config: Config{ReconnectMaxJitter: time.Millisecond}}
+	defer service.Stop()
+	client := &mockClient{incomingLogs: make(chan types.Log), subscriptions: make(chan *mockSubscription, 2), blockNumber: 5}
+	handler := &subscriptionHandler{psi: types.DefaultPrivateStateIdentifier, client: client, service: service}
+
+	handled := make(chan types.Log, 10)
+	err = handler.createSub(newExtensionQueryType, newExtensionQuery(), func(_ log.Logger, l types.Log) { handled <- l })
+	assert.NoError(t, err)
+
+	first := <-client.subscriptions
+	first.errC <- errors.New("connection lost")
+	select {
+	case <-client.subscriptions:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the resubscription")
+	}
+
+	client.incomingLogs <- types.Log{BlockNumber: 6}
+	assert.Equal(t, uint64(6), waitForLogs(t, handled, 1)[0].BlockNumber)
+}
+
+func TestSubscriptionHandler_watchUnknownTopics(t *testing.T) {
+	managementContract := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	unknownTopic := common.HexToHash("0x01")
+
+	service := &PrivacyService{}
+	defer service.Stop()
+	client := &mockClient{incomingLogs: make(chan types.Log)}
+	handler := &subscriptionHandler{psi: types.DefaultPrivateStateIdentifier, client: client, service: service}
+
+	err := handler.watchUnknownTopics([]common.Address{managementContract})
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Address{managementContract}, client.subQuery.Addresses)
+	assert.Empty(t, client.subQuery.Topics)
+
+	client.incomingLogs <- types.Log{Address: managementContract, Topics: []common.Hash{common.HexToHash(extensionContracts.NewVoteTopicHash)}}
+	client.incomingLogs <- types.Log{Address: managementContract, Topics: []common.Hash{unknownTopic}, BlockNumber: 1}
+	client.incomingLogs <- types.Log{Address: managementContract, Topics: []common.Hash{common.HexToHash(extensionContracts.ExtensionFinishedTopicHash)}}
+	client.incomingLogs <- types.Log{Address: managementContract, Topics: []common.Hash{unknownTopic}, BlockNumber: 2}
+
+	assert.Eventually(t, func() bool {
+		return service.UnknownTopics()[unknownTopic] == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Len(t, service.UnknownTopics(), 1)
+}
+
+func TestSubscriptionHandler_watchUnknownTopics_AnyManagementContract(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	tracked := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	untracked := common.HexToAddress("0x9d13c6d3afe1721beef56b55d303b09e021e27ab")
+	unknownTopic := common.HexToHash("0x01")
+
+	service := &PrivacyService{
+		psiContracts: map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract{
+			psi: {tracked: &ExtensionContract{ManagementContractAddress: tracked}},
+		},
+	}
+	defer service.Stop()
+	client := &mockClient{incomingLogs: make(chan types.Log)}
+	handler := &subscriptionHandler{psi: psi, client: client, service: service}
+
+	assert.NoError(t, handler.watchUnknownTopics(nil))
+
+	// the logs of the contracts which aren't tracked management contracts are ignored
+	client.incomingLogs <- types.Log{Address: untracked, Topics: []common.Hash{unknownTopic}}
+	client.incomingLogs <- types.Log{Address: tracked, Topics: []common.Hash{unknownTopic}}
+
+	assert.Eventually(t, func() bool {
+		return service.UnknownTopics()[unknownTopic] == 1
+	}, time.Second, 10*time.Millisecond)
+	client.incomingLogs <- types.Log{Address: untracked, Topics: []common.Hash{unknownTopic}}
+	client.incomingLogs <- types.Log{Address: tracked}
+	assert.Eventually(t, func() bool {
+		return service.UnknownTopics()[common.Hash{}] == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(1), service.UnknownTopics()[unknownTopic])
+}
@@ -0,0 +1,144 @@
+package extension
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/types"
+)
+
+// ErrExtensionThrottled is returned when a new extension is rejected because too many new extensions
+// are being processed
+var ErrExtensionThrottled = errors.New("extension throttled")
+
+// maxThrottledExtensionsRecorded bounds the number of throttled extensions whose reason is recorded
+var maxThrottledExtensionsRecorded = 1024
+
+// extensionThrottle limits how many new extensions begin processing per interval. The extensions in
+// excess wait for the next interval, up to queueSize of them, the others are rejected
+type extensionThrottle struct {
+	limit     int // 0 for no limit
+	interval  time.Duration
+	queueSize int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	started     int // number of extensions started in the current window
+	queued      int
+
+	// rejections records why the extensions have been rejected, by PSI and management contract
+	rejections map[types.PrivateStateIdentifier]map[common.Address]string
+	rejected   int
+}
+
+func newExtensionThrottle(config Config) *extensionThrottle {
+	return &extensionThrottle{
+		limit:      config.NewExtensionRateLimit,
+		interval:   config.NewExtensionRateInterval,
+		queueSize:  config.NewExtensionQueueSize,
+		rejections: make(map[types.PrivateStateIdentifier]map[common.Address]string),
+	}
+}
+
+// reserve admits the new extension managed by the management contract if it can begin processing in the
+// current interval and none is queued before it, or queues it otherwise, without waiting: the queued
+// extension must then wait for its turn with waitQueued, or be released with cancelQueued. The returned
+// error wraps ErrExtensionThrottled if the extension is rejected.
+func (t *extensionThrottle) reserve(psi types.PrivateStateIdentifier, managementContract common.Address) (queued bool, err error) {
+	if t.limit <= 0 {
+		return false, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.startWindow(time.Now())
+	if t.started < t.limit && t.queued == 0 {
+		t.started++
+		return false, nil
+	}
+	if t.queued >= t.queueSize {
+		return false, t.reject(psi, managementContract, fmt.Errorf("%w: more than %d new extensions per %v, %d already queued", ErrExtensionThrottled, t.limit, t.interval, t.queued))
+	}
+	t.queued++
+	return true, nil
+}
+
+// waitQueued waits until the new extension queued by reserve can begin processing, the queued extensions
+// must wait in the order they have been reserved. The wait is abandoned, and the extension rejected, once
+// stop is closed or receives.
+func (t *extensionThrottle) waitQueued(psi types.PrivateStateIdentifier, managementContract common.Address, stop <-chan stopEvent) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for {
+		now := time.Now()
+		t.startWindow(now)
+		if t.started < t.limit {
+			t.queued--
+			t.started++
+			return nil
+		}
+		wait := t.windowStart.Add(t.interval).Sub(now)
+		t.mu.Unlock()
+		select {
+		case <-time.After(wait):
+			t.mu.Lock()
+		case <-stop:
+			t.mu.Lock()
+			t.queued--
+			return t.reject(psi, managementContract, fmt.Errorf("%w: stopped while queued", ErrExtensionThrottled))
+		}
+	}
+}
+
+// cancelQueued rejects the new extension queued by reserve without waiting for its turn
+func (t *extensionThrottle) cancelQueued(psi types.PrivateStateIdentifier, managementContract common.Address) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queued--
+	return t.reject(psi, managementContract, fmt.Errorf("%w: stopped while queued", ErrExtensionThrottled))
+}
+
+// startWindow starts a new interval if the current one is over, t.mu must be held
+func (t *extensionThrottle) startWindow(now time.Time) {
+	if now.Sub(t.windowStart) >= t.interval {
+		t.windowStart, t.started = now, 0
+	}
+}
+
+// reject records why the extension has been rejected and returns the error, t.mu must be held
+func (t *extensionThrottle) reject(psi types.PrivateStateIdentifier, managementContract common.Address, err error) error {
+	t.rejected++
+	if t.rejections[psi] == nil {
+		t.rejections[psi] = make(map[common.Address]string)
+	}
+	if _, ok := t.rejections[psi][managementContract]; ok || t.recorded() < maxThrottledExtensionsRecorded {
+		t.rejections[psi][managementContract] = err.Error()
+	}
+	return err
+}
+
+// recorded returns the number of rejections recorded, t.mu must be held
+func (t *extensionThrottle) recorded() int {
+	count := 0
+	for _, byContract := range t.rejections {
+		count += len(byContract)
+	}
+	return count
+}
+
+// rejection returns why the extension managed by the management contract has been rejected, the empty
+// string if it hasn't been
+func (t *extensionThrottle) rejection(psi types.PrivateStateIdentifier, managementContract common.Address) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rejections[psi][managementContract]
+}
+
+// stats returns the number of extensions queued and the number rejected since the start
+func (t *extensionThrottle) stats() (queued, rejected int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.queued, t.rejected
+}
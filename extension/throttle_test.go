@@ -0,0 +1,98 @@
+package extension
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivacyService_throttleNewExtension_CapsFlood(t *testing.T) {
+	interval := 200 * time.Millisecond
+	service := &PrivacyService{throttle: newExtensionThrottle(Config{NewExtensionRateLimit: 3, NewExtensionRateInterval: interval, NewExtensionQueueSize: 2})}
+	psi := types.DefaultPrivateStateIdentifier
+
+	start := time.Now()
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		startedAt []time.Duration
+		rejected  []common.Address
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(managementContract common.Address) {
+			defer wg.Done()
+			err := service.throttleNewExtension(psi, managementContract)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				assert.True(t, errors.Is(err, ErrExtensionThrottled), "unexpected error %v", err)
+				rejected = append(rejected, managementContract)
+				return
+			}
+			startedAt = append(startedAt, time.Since(start))
+		}(common.Address{byte(i + 1)})
+	}
+	wg.Wait()
+
+	assert.Len(t, startedAt, 5, "the limit and the queue")
+	assert.Len(t, rejected, 5)
+	inFirstInterval := 0
+	for _, at := range startedAt {
+		if at < interval {
+			inFirstInterval++
+		}
+	}
+	assert.Equal(t, 3, inFirstInterval)
+	for _, managementContract := range rejected {
+		assert.Contains(t, service.throttle.rejection(psi, managementContract), ErrExtensionThrottled.Error())
+	}
+	queued, rejectedCount := service.throttle.stats()
+	assert.Equal(t, 0, queued)
+	assert.Equal(t, 5, rejectedCount)
+}
+
+func TestPrivacyService_throttleNewExtension_StopWhileQueued(t *testing.T) {
+	service := &PrivacyService{throttle: newExtensionThrottle(Config{NewExtensionRateLimit: 1, NewExtensionRateInterval: time.Hour, NewExtensionQueueSize: 1})}
+	psi := types.DefaultPrivateStateIdentifier
+	assert.NoError(t, service.throttleNewExtension(psi, common.Address{1}))
+
+	result := make(chan error, 1)
+	go func() {
+		result <- service.throttleNewExtension(psi, common.Address{2})
+	}()
+	for queued, _ := service.throttle.stats(); queued == 0; queued, _ = service.throttle.stats() {
+		time.Sleep(time.Millisecond)
+	}
+	service.stopFeed.Send(stopEvent{})
+
+	select {
+	case err := <-result:
+		assert.True(t, errors.Is(err, ErrExtensionThrottled), "unexpected error %v", err)
+		assert.NotEmpty(t, service.throttle.rejection(psi, common.Address{2}))
+	case <-time.After(time.Second):
+		t.Fatal("queued extension not released on stop")
+	}
+}
+
+func TestPrivacyService_throttleNewExtension_Unlimited(t *testing.T) {
+	psi := types.DefaultPrivateStateIdentifier
+	service := &PrivacyService{throttle: newExtensionThrottle(Config{})}
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, service.throttleNewExtension(psi, common.Address{1}))
+	}
+
+	// the tracked extensions, e.g. replayed after a restart, aren't throttled
+	service = &PrivacyService{
+		throttle:     newExtensionThrottle(Config{NewExtensionRateLimit: 1, NewExtensionRateInterval: time.Hour}),
+		psiContracts: map[types.PrivateStateIdentifier]map[common.Address]*ExtensionContract{psi: {common.Address{1}: {}}},
+	}
+	assert.NoError(t, service.throttleNewExtension(psi, common.Address{2}))
+	assert.NoError(t, service.throttleNewExtension(psi, common.Address{1}))
+	assert.True(t, errors.Is(service.throttleNewExtension(psi, common.Address{3}), ErrExtensionThrottled))
+}
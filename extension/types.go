@@ -1,40 +1,167 @@
 package extension
 
 import (
+	"bytes"
+	"encoding/json"
+
 	"github.com/kisexp/xdchain"
 	"github.com/kisexp/xdchain/common"
+	"github.com/kisexp/xdchain/common/hexutil"
 	"github.com/kisexp/xdchain/extension/extensionContracts"
 )
 
-var (
-	//Log queries
-	newExtensionQuery = ethereum.FilterQuery{
-		FromBlock: nil,
-		ToBlock:   nil,
-		Topics:    [][]common.Hash{{common.HexToHash(extensionContracts.NewContractExtensionContractCreatedTopicHash)}},
-		Addresses: []common.Address{},
-	}
+// Identifiers of the log queries, used to track the last block processed by each watcher
+const (
+	newExtensionQueryType         = "newExtension"
+	finishedExtensionQueryType    = "finishedExtension"
+	canPerformStateShareQueryType = "canPerformStateShare"
+)
 
-	finishedExtensionQuery = ethereum.FilterQuery{
+// Log queries, each watcher builds its own so that watchers filtering on different management
+// contracts don't share any state. No management contract address means logs of any contract.
+
+func newExtensionQuery(managementContracts ...common.Address) ethereum.FilterQuery {
+	return newTopicQuery(extensionContracts.NewContractExtensionContractCreatedTopicHash, managementContracts)
+}
+
+func newTopicQuery(topicHash string, managementContracts []common.Address) ethereum.FilterQuery {
+	return ethereum.FilterQuery{
 		FromBlock: nil,
 		ToBlock:   nil,
-		Topics:    [][]common.Hash{{common.HexToHash(extensionContracts.ExtensionFinishedTopicHash)}},
-		Addresses: []common.Address{},
+		Topics:    [][]common.Hash{{common.HexToHash(topicHash)}},
+		Addresses: append([]common.Address{}, managementContracts...),
 	}
+}
 
-	canPerformStateShareQuery = ethereum.FilterQuery{
-		FromBlock: nil,
-		ToBlock:   nil,
-		Topics:    [][]common.Hash{{common.HexToHash(extensionContracts.CanPerformStateShareTopicHash)}},
-		Addresses: []common.Address{},
+// watermarkKey identifies the watcher of the query type filtering on the given management contracts,
+// so that watchers of different management contracts keep track of their own last processed block
+func watermarkKey(queryType string, managementContracts []common.Address) string {
+	key := queryType
+	for _, address := range managementContracts {
+		key += ":" + address.Hex()
 	}
-)
+	return key
+}
+
+// HandledLog identifies a log handled by a watcher in a block above its watermark, so that it isn't handled
+// again when the block is replayed
+type HandledLog struct {
+	BlockNumber uint64      `json:"blockNumber"`
+	TxHash      common.Hash `json:"txHash"`
+	Index       uint        `json:"index"`
+}
 
 type ExtensionContract struct {
-	ContractExtended          common.Address `json:"contractExtended"`
-	Initiator                 common.Address `json:"initiator"`
-	Recipient                 common.Address `json:"recipient"`
+	ContractExtended          common.Address   `json:"contractExtended"`
+	BundledContracts          []common.Address `json:"bundledContracts,omitempty"` // Contracts whose states are shared together with the state of the extended contract, all or none
+	Initiator                 common.Address   `json:"initiator"`
+	Recipient                 common.Address   `json:"recipient"`
+	ManagementContractAddress common.Address   `json:"managementContractAddress"`
+	RecipientPtmKey           string           `json:"recipientPtmKey"`
+	CreationData              []byte           `json:"creationData"`
+	StateShared               bool             `json:"stateShared,omitempty"` // Set once the transaction sharing the state is submitted, the extension can't be cancelled anymore
+	CreationBlockHash         common.Hash      `json:"creationBlockHash"`     // Block of the creation event in the chain followed by the node, not part of CanonicalJSON
+}
+
+// merge reconciles two views of the extension managed by the same management contract, e.g. built from
+// its creation event included in different blocks across a reorg. The view whose creation block is
+// canonical is kept, the receiver if both or none are. The fields it doesn't set are taken from the other
+// view, unless the creation block of the other view is known and orphaned: the fragments of an orphaned
+// branch are discarded. The views aren't modified.
+func (e *ExtensionContract) merge(other *ExtensionContract, isCanonical func(blockHash common.Hash) bool) *ExtensionContract {
+	kept, fragment := e, other
+	if !isCanonical(e.CreationBlockHash) && isCanonical(other.CreationBlockHash) {
+		kept, fragment = other, e
+	}
+	merged := *kept
+	merged.BundledContracts = append([]common.Address(nil), kept.BundledContracts...)
+	merged.CreationData = common.CopyBytes(kept.CreationData)
+	if !common.EmptyHash(fragment.CreationBlockHash) && !isCanonical(fragment.CreationBlockHash) {
+		return &merged
+	}
+	if merged.ContractExtended == (common.Address{}) {
+		merged.ContractExtended = fragment.ContractExtended
+	}
+	if len(merged.BundledContracts) == 0 {
+		merged.BundledContracts = append([]common.Address(nil), fragment.BundledContracts...)
+	}
+	if merged.Initiator == (common.Address{}) {
+		merged.Initiator = fragment.Initiator
+	}
+	if merged.Recipient == (common.Address{}) {
+		merged.Recipient = fragment.Recipient
+	}
+	if merged.RecipientPtmKey == "" {
+		merged.RecipientPtmKey = fragment.RecipientPtmKey
+	}
+	if len(merged.CreationData) == 0 {
+		merged.CreationData = common.CopyBytes(fragment.CreationData)
+	}
+	merged.StateShared = merged.StateShared || fragment.StateShared
+	if common.EmptyHash(merged.CreationBlockHash) {
+		merged.CreationBlockHash = fragment.CreationBlockHash
+	}
+	return &merged
+}
+
+// canonicalExtensionContract lists the fields of ExtensionContract in the order of their keys, none of
+// them being omitted
+type canonicalExtensionContract struct {
+	BundledContracts          []common.Address `json:"bundledContracts"`
+	ContractExtended          common.Address   `json:"contractExtended"`
+	CreationData              hexutil.Bytes    `json:"creationData"`
+	Initiator                 common.Address   `json:"initiator"`
+	ManagementContractAddress common.Address   `json:"managementContractAddress"`
+	Recipient                 common.Address   `json:"recipient"`
+	RecipientPtmKey           string           `json:"recipientPtmKey"`
+	StateShared               bool             `json:"stateShared"`
+}
+
+// CanonicalJSON returns a deterministic JSON encoding of the extension, e.g. to hash it the same way across
+// languages:
+//   - all the fields are present, keys are sorted and there is no whitespace
+//   - addresses are 0x prefixed lowercase hex strings, bundledContracts is [] if there is none
+//   - creationData is a 0x prefixed lowercase hex string, "0x" if empty
+//   - strings are escaped as required by JSON only, HTML characters are not escaped
+func (e *ExtensionContract) CanonicalJSON() ([]byte, error) {
+	canonical := canonicalExtensionContract{
+		BundledContracts:          append([]common.Address{}, e.BundledContracts...),
+		ContractExtended:          e.ContractExtended,
+		CreationData:              e.CreationData,
+		Initiator:                 e.Initiator,
+		ManagementContractAddress: e.ManagementContractAddress,
+		Recipient:                 e.Recipient,
+		RecipientPtmKey:           e.RecipientPtmKey,
+		StateShared:               e.StateShared,
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(canonical); err != nil {
+		return nil, err
+	}
+	// the encoder terminates the value with a newline
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// ExtensionProgress reports how many of the recipients of an in-flight extension have had the state
+// shared with them
+type ExtensionProgress struct {
 	ManagementContractAddress common.Address `json:"managementContractAddress"`
-	RecipientPtmKey           string         `json:"recipientPtmKey"`
-	CreationData              []byte         `json:"creationData"`
+	Processed                 int            `json:"processed"` // Recipients for which the transaction sharing the state has been submitted
+	Total                     int            `json:"total"`     // Recipients of the extension
+}
+
+// progress returns the progress of the extension. The recipients are those of the
+// NewContractExtensionContractCreated event, which names a single recipient, so the progress of
+// an extension is either none or complete.
+func (e *ExtensionContract) progress() ExtensionProgress {
+	progress := ExtensionProgress{ManagementContractAddress: e.ManagementContractAddress}
+	if e.Recipient != (common.Address{}) {
+		progress.Total = 1
+	}
+	if e.StateShared {
+		progress.Processed = progress.Total
+	}
+	return progress
 }
@@ -0,0 +1,93 @@
+package extension
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/kisexp/xdchain/common"
+)
+
+func TestExtensionContract_CanonicalJSON(t *testing.T) {
+	extension := &ExtensionContract{
+		ContractExtended:          common.HexToAddress("0x1932c48b2bf8102ba33b4a6b545c32236e342f34"),
+		BundledContracts:          []common.Address{common.HexToAddress("0x2222222222222222222222222222222222222222"), common.HexToAddress("0x3333333333333333333333333333333333333333")},
+		Initiator:                 common.HexToAddress("0xed9d02e382b34818e88b88a309c7fe71e65f419d"),
+		Recipient:                 common.HexToAddress("0xca843569e3427144cead5e4d5999a3d0ccf92b8e"),
+		ManagementContractAddress: common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17"),
+		RecipientPtmKey:           "BULeR8JyUWhiuuCMU/HLA0Q5pzkYT+cHII3ZKBey3Bo=",
+		CreationData:              []byte{0xde, 0xad, 0xbe, 0xef},
+		StateShared:               true,
+	}
+	golden, err := ioutil.ReadFile("testdata/canonical_extension_contract.json")
+	if err != nil {
+		t.Fatalf("failed to read the golden file: %v", err)
+	}
+
+	encoded, err := extension.CanonicalJSON()
+	if err != nil {
+		t.Fatalf("expected no error, but got '%s'", err.Error())
+	}
+	if !bytes.Equal(encoded, golden) {
+		t.Errorf("expected the canonical encoding to be\n%s\nbut was\n%s", golden, encoded)
+	}
+}
+
+func TestExtensionContract_CanonicalJSON_EmptyFields(t *testing.T) {
+	encoded, err := (&ExtensionContract{}).CanonicalJSON()
+	if err != nil {
+		t.Fatalf("expected no error, but got '%s'", err.Error())
+	}
+	expected := `{"bundledContracts":[],"contractExtended":"0x0000000000000000000000000000000000000000","creationData":"0x","initiator":"0x0000000000000000000000000000000000000000","managementContractAddress":"0x0000000000000000000000000000000000000000","recipient":"0x0000000000000000000000000000000000000000","recipientPtmKey":"","stateShared":false}`
+	if string(encoded) != expected {
+		t.Errorf("expected the canonical encoding to be\n%s\nbut was\n%s", expected, encoded)
+	}
+}
+
+func TestExtensionContract_merge_Reorg(t *testing.T) {
+	managementContract := common.HexToAddress("0x1349f3e1b8d71effb47b840594ff27da7e603d17")
+	orphanedBlock, canonicalBlock := common.Hash{1}, common.Hash{2}
+	isCanonical := func(blockHash common.Hash) bool { return blockHash == canonicalBlock }
+
+	// the creation and the completion events were handled on the branch orphaned by the reorg, then the
+	// creation event is emitted again in a block of the canonical chain
+	orphaned := &ExtensionContract{
+		ContractExtended:          common.HexToAddress("0x1932c48b2bf8102ba33b4a6b545c32236e342f34"),
+		Initiator:                 common.HexToAddress("0xed9d02e382b34818e88b88a309c7fe71e65f419d"),
+		Recipient:                 common.HexToAddress("0xca843569e3427144cead5e4d5999a3d0ccf92b8e"),
+		ManagementContractAddress: managementContract,
+		RecipientPtmKey:           "BULeR8JyUWhiuuCMU/HLA0Q5pzkYT+cHII3ZKBey3Bo=",
+		CreationData:              []byte{0xde, 0xad},
+		StateShared:               true,
+		CreationBlockHash:         orphanedBlock,
+	}
+	canonical := &ExtensionContract{
+		ContractExtended:          common.HexToAddress("0x1932c48b2bf8102ba33b4a6b545c32236e342f34"),
+		BundledContracts:          []common.Address{common.HexToAddress("0x2222222222222222222222222222222222222222")},
+		Recipient:                 common.HexToAddress("0xca843569e3427144cead5e4d5999a3d0ccf92b8e"),
+		ManagementContractAddress: managementContract,
+		RecipientPtmKey:           "BULeR8JyUWhiuuCMU/HLA0Q5pzkYT+cHII3ZKBey3Bo=",
+		CreationData:              []byte{0xbe, 0xef},
+		CreationBlockHash:         canonicalBlock,
+	}
+	expected := *canonical
+
+	for _, merged := range []*ExtensionContract{canonical.merge(orphaned, isCanonical), orphaned.merge(canonical, isCanonical)} {
+		if !reflect.DeepEqual(&expected, merged) {
+			t.Errorf("expected the canonical view without the orphaned fragments\n%+v\nbut was\n%+v", expected, *merged)
+		}
+	}
+	if !orphaned.StateShared || orphaned.CreationBlockHash != orphanedBlock || canonical.Initiator != (common.Address{}) {
+		t.Errorf("the views must not be modified")
+	}
+
+	// a view whose creation block isn't known, e.g. tracked before the upgrade, completes the canonical view
+	legacy := *orphaned
+	legacy.CreationBlockHash = common.Hash{}
+	merged := canonical.merge(&legacy, isCanonical)
+	expected.Initiator, expected.StateShared = legacy.Initiator, true
+	if !reflect.DeepEqual(&expected, merged) {
+		t.Errorf("expected the canonical view completed by the legacy view\n%+v\nbut was\n%+v", expected, *merged)
+	}
+}
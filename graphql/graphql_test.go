@@ -610,12 +610,21 @@ type StubPSMR struct {
 func (psmr *StubPSMR) ResolveForManagedParty(managedParty string) (*mps.PrivateStateMetadata, error) {
 	panic("implement me")
 }
+func (psmr *StubPSMR) ResolveForManagedPartyContext(ctx context.Context, managedParty string) (*mps.PrivateStateMetadata, error) {
+	panic("implement me")
+}
+func (psmr *StubPSMR) ResolveAllForManagedParty(managedParty string) ([]*mps.PrivateStateMetadata, error) {
+	panic("implement me")
+}
 func (psmr *StubPSMR) ResolveForUserContext(ctx context.Context) (*mps.PrivateStateMetadata, error) {
 	return mps.DefaultPrivateStateMetadata, nil
 }
 func (psmr *StubPSMR) PSIs() []types.PrivateStateIdentifier {
 	panic("implement me")
 }
+func (psmr *StubPSMR) PrivacyGroups() map[types.PrivateStateIdentifier]*mps.PrivateStateMetadata {
+	panic("implement me")
+}
 func (psmr *StubPSMR) NotIncludeAny(psm *mps.PrivateStateMetadata, managedParties ...string) bool {
 	return false
 }
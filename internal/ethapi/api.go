@@ -1808,7 +1808,7 @@ type PrivateTxArgs struct {
 
 func (args *PrivateTxArgs) SetDefaultPrivateFrom(ctx context.Context, b Backend) error {
 	if args.PrivateFor != nil && len(args.PrivateFrom) == 0 && b.ChainConfig().IsMPS {
-		psm, err := b.PSMR().ResolveForUserContext(ctx)
+		psm, err := b.PSMR().ResolveForUserContext(mps.WithWriteIntent(ctx))
 		if err != nil {
 			return err
 		}
@@ -1830,7 +1830,7 @@ func (args *PrivateTxArgs) SetRawTransactionPrivateFrom(ctx context.Context, b B
 		if args.PrivateFrom != retrievedPrivateFrom {
 			return fmt.Errorf("The PrivateFrom address retrieved from the privacy manager does not match private PrivateFrom (%s) specified in transaction arguments.", args.PrivateFrom)
 		}
-		psm, err := b.PSMR().ResolveForUserContext(ctx)
+		psm, err := b.PSMR().ResolveForUserContext(mps.WithWriteIntent(ctx))
 		if err != nil {
 			return err
 		}
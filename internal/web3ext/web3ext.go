@@ -161,6 +161,11 @@ web3._extend({
 			call: 'admin_reloadPlugin',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'reconfigurePlugin',
+			call: 'admin_reconfigurePlugin',
+			params: 1
+		}),
 		new web3._extend.Method({
 			name: 'addPeer',
 			call: 'admin_addPeer',
@@ -1093,6 +1098,22 @@ web3._extend({
 			call: 'istanbul_getValidatorsAtHash',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'getProposerPolicy',
+			call: 'istanbul_getProposerPolicy'
+		}),
+		new web3._extend.Method({
+			name: 'getProposerOrder',
+			call: 'istanbul_getProposerOrder',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new web3._extend.Method({
+			name: 'getPendingVotes',
+			call: 'istanbul_getPendingVotes',
+			params: 1,
+			inputFormatter: [null]
+		}),
 		new web3._extend.Method({
 			name: 'propose',
 			call: 'istanbul_propose',
@@ -1226,8 +1247,14 @@ web3._extend({
 		new web3._extend.Method({
 			name: 'extendContract',
 			call: 'quorumExtension_extendContract',
-			params: 4,
-			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputTransactionFormatter]
+			params: 5,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputTransactionFormatter, null]
+		}),
+		new web3._extend.Method({
+			name: 'extendContractBundle',
+			call: 'quorumExtension_extendContractBundle',
+			params: 6,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, null, web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputTransactionFormatter, null]
 		}),
 		new web3._extend.Method({
 			name: 'cancelExtension',
@@ -1246,6 +1273,24 @@ web3._extend({
 			params: 1,
 			inputFormatter: [web3._extend.formatters.inputAddressFormatter]
 		}),
+		new web3._extend.Method({
+			name: 'getExtensionProgress',
+			call: 'quorumExtension_getExtensionProgress',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'getStateShareRejection',
+			call: 'quorumExtension_getStateShareRejection',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'getThrottleRejection',
+			call: 'quorumExtension_getThrottleRejection',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter]
+		}),
 
 	],
 	properties:
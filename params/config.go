@@ -378,10 +378,17 @@ func (c *CliqueConfig) String() string {
 
 // IstanbulConfig is the consensus engine configs for Istanbul based sealing.
 type IstanbulConfig struct {
-	Epoch          uint64   `json:"epoch"`                    // Epoch length to reset votes and checkpoint
-	ProposerPolicy uint64   `json:"policy"`                   // The policy for proposer selection
-	Ceil2Nby3Block *big.Int `json:"ceil2Nby3Block,omitempty"` // Number of confirmations required to move from one state to next [2F + 1 to Ceil(2N/3)]
-	TestQBFTBlock  *big.Int `json:"testQBFTBlock,omitempty"`  // Fork block at which block confirmations are done using qbft consensus instead of ibft
+	Epoch          uint64       `json:"epoch"`                    // Epoch length to reset votes and checkpoint
+	ProposerPolicy uint64       `json:"policy"`                   // The policy for proposer selection
+	Ceil2Nby3Block *big.Int     `json:"ceil2Nby3Block,omitempty"` // Number of confirmations required to move from one state to next [2F + 1 to Ceil(2N/3)]
+	TestQBFTBlock  *big.Int     `json:"testQBFTBlock,omitempty"`  // Fork block at which block confirmations are done using qbft consensus instead of ibft
+	ProposerSeed   *common.Hash `json:"proposerSeed,omitempty"`   // Seed of the permutation of the validators followed by the round robin policy
+	// Sticky policy only, pick the backup proposers in the round robin order once the proposer failed
+	RoundRobinFallback bool `json:"roundRobinFallback,omitempty"`
+	// Round robin policy only, number of last proposers skipped by the rotation
+	ProposerCooldown uint64 `json:"proposerCooldown,omitempty"`
+	// Name of the registered validator sort function the proposer policy uses from TestQBFTBlock on, byte order if empty
+	QBFTValidatorSortBy string `json:"qbftValidatorSortBy,omitempty"`
 }
 
 // String implements the stringer interface, returning the consensus engine details.
@@ -763,6 +770,9 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int, isQuor
 	if c.Istanbul != nil && newcfg.Istanbul != nil && isForkIncompatible(c.Istanbul.TestQBFTBlock, newcfg.Istanbul.TestQBFTBlock, head) {
 		return newCompatError("Test QBFT fork block", c.Istanbul.TestQBFTBlock, newcfg.Istanbul.TestQBFTBlock)
 	}
+	if c.Istanbul != nil && newcfg.Istanbul != nil && isForked(c.Istanbul.TestQBFTBlock, head) && c.Istanbul.QBFTValidatorSortBy != newcfg.Istanbul.QBFTValidatorSortBy {
+		return newCompatError("Test QBFT validator sort function", c.Istanbul.TestQBFTBlock, c.Istanbul.TestQBFTBlock)
+	}
 	if isForkIncompatible(c.QIP714Block, newcfg.QIP714Block, head) {
 		return newCompatError("permissions fork block", c.QIP714Block, newcfg.QIP714Block)
 	}
@@ -209,6 +209,23 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     19,
 			},
 		},
+		{
+			stored:  &ChainConfig{Istanbul: &IstanbulConfig{TestQBFTBlock: big.NewInt(50)}},
+			new:     &ChainConfig{Istanbul: &IstanbulConfig{TestQBFTBlock: big.NewInt(50), QBFTValidatorSortBy: "string"}},
+			head:    40,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{Istanbul: &IstanbulConfig{TestQBFTBlock: big.NewInt(20)}},
+			new:    &ChainConfig{Istanbul: &IstanbulConfig{TestQBFTBlock: big.NewInt(20), QBFTValidatorSortBy: "string"}},
+			head:   30,
+			wantErr: &ConfigCompatError{
+				What:         "Test QBFT validator sort function",
+				StoredConfig: big.NewInt(20),
+				NewConfig:    big.NewInt(20),
+				RewindTo:     19,
+			},
+		},
 		{
 			stored: &ChainConfig{MaxCodeSizeChangeBlock: big.NewInt(10)},
 			new:    &ChainConfig{MaxCodeSizeChangeBlock: big.NewInt(20)},
@@ -13,3 +13,7 @@ func NewPluginManagerAPI(pm *PluginManager) *PluginManagerAPI {
 func (pmapi *PluginManagerAPI) ReloadPlugin(name PluginInterfaceName) (bool, error) {
 	return pmapi.pm.Reload(name)
 }
+
+func (pmapi *PluginManagerAPI) ReconfigurePlugin(name PluginInterfaceName) (bool, error) {
+	return pmapi.pm.Reconfigure(name)
+}
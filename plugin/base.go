@@ -27,6 +27,11 @@ type managedPlugin interface {
 	Info() (PluginInterfaceName, interface{})
 }
 
+// reconfigurablePlugin is implemented by the plugins which can re-apply their configuration while running
+type reconfigurablePlugin interface {
+	Reconfigure() error
+}
+
 // Plugin-meta.json
 type MetaData struct {
 	Version    string   `json:"version"`
@@ -50,7 +55,7 @@ type basePlugin struct {
 var basePluginPointerType = reflect.TypeOf(&basePlugin{})
 
 func newBasePlugin(pm *PluginManager, pluginInterface PluginInterfaceName, pluginDefinition PluginDefinition, gateways plugin.PluginSet) (*basePlugin, error) {
-	gateways[initializer.ConnectorName] = &initializer.PluginConnector{}
+	gateways[initializer.ConnectorName] = &initializer.PluginConnector{PluginName: string(pluginInterface)}
 
 	// build basePlugin
 	return &basePlugin{
@@ -185,6 +190,27 @@ func (bp *basePlugin) init() error {
 	return c.Init(context.Background(), bp.pm.nodeName, rawConfig)
 }
 
+// Reconfigure re-reads the configuration of the plugin and pushes it to the running plugin
+func (bp *basePlugin) Reconfigure() error {
+	if bp.client == nil {
+		return fmt.Errorf("plugin is not started")
+	}
+	bp.logger.Info("Reconfiguring plugin")
+	raw, err := bp.dispense(initializer.ConnectorName)
+	if err != nil {
+		return err
+	}
+	c, ok := raw.(initializer.PluginInitializer)
+	if !ok {
+		return fmt.Errorf("missing plugin initializer. Make sure it is in the plugin set")
+	}
+	rawConfig, err := ReadMultiFormatConfig(bp.pluginDefinition.Config)
+	if err != nil {
+		return err
+	}
+	return c.Reconfigure(context.Background(), rawConfig)
+}
+
 func (bp *basePlugin) dispense(name string) (interface{}, error) {
 	rpcClient, err := bp.client.Client()
 	if err != nil {
@@ -0,0 +1,29 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/kisexp/xdchain/plugin/initializer"
+)
+
+var errConfigNotJSON = errors.New("configuration is not valid JSON")
+
+// the raw configuration of the built-in plugins is checked by the node before it is sent to the
+// plugins, hosts embedding the plugin manager register the validators of their own plugins the same way
+func init() {
+	for _, name := range []PluginInterfaceName{HelloWorldPluginInterfaceName, SecurityPluginInterfaceName, AccountPluginInterfaceName} {
+		initializer.RegisterConfigValidator(string(name), validateJSONConfig)
+	}
+}
+
+// validateJSONConfig accepts an empty configuration or a JSON document, the built-in plugins being
+// configured with JSON
+func validateJSONConfig(rawConfiguration []byte) error {
+	trimmed := bytes.TrimSpace(rawConfiguration)
+	if len(trimmed) == 0 || json.Valid(trimmed) {
+		return nil
+	}
+	return errConfigNotJSON
+}
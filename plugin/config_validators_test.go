@@ -0,0 +1,33 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kisexp/xdchain/plugin/initializer"
+	testifyassert "github.com/stretchr/testify/assert"
+)
+
+func TestValidateJSONConfig(t *testing.T) {
+	assert := testifyassert.New(t)
+
+	assert.NoError(validateJSONConfig(nil))
+	assert.NoError(validateJSONConfig([]byte(" \n")))
+	assert.NoError(validateJSONConfig([]byte(`{"language": "en"}`)))
+	assert.NoError(validateJSONConfig([]byte(`["arbitrary value1", "arbitrary value2"]`)))
+	assert.EqualError(validateJSONConfig([]byte(`{"language": "en"`)), errConfigNotJSON.Error())
+	assert.EqualError(validateJSONConfig([]byte("file:///missing/config.json")), errConfigNotJSON.Error())
+}
+
+func TestBuiltInPluginsHaveConfigValidators(t *testing.T) {
+	assert := testifyassert.New(t)
+
+	for name := range pluginProviders {
+		gateway, err := (&initializer.PluginConnector{PluginName: string(name)}).GRPCClient(context.Background(), nil, nil)
+		assert.NoError(err)
+
+		// the configuration is rejected before reaching the plugin
+		err = gateway.(*initializer.PluginGateway).Init(context.Background(), "arbitrary node", []byte("not json"))
+		assert.EqualError(err, "invalid configuration for plugin "+string(name)+": "+errConfigNotJSON.Error())
+	}
+}
@@ -110,6 +110,8 @@ func (m *PluginInitialization_Request) GetRawConfiguration() []byte {
 }
 
 type PluginInitialization_Response struct {
+	// Capabilities supported by the plugin, negotiated once at initialization
+	Capabilities         []string `protobuf:"bytes,1,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -140,30 +142,149 @@ func (m *PluginInitialization_Response) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_PluginInitialization_Response proto.InternalMessageInfo
 
+func (m *PluginInitialization_Response) GetCapabilities() []string {
+	if m != nil {
+		return m.Capabilities
+	}
+	return nil
+}
+
+//*
+// A wrapper message to logically group other messages
+type PluginReconfiguration struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PluginReconfiguration) Reset()         { *m = PluginReconfiguration{} }
+func (m *PluginReconfiguration) String() string { return proto.CompactTextString(m) }
+func (*PluginReconfiguration) ProtoMessage()    {}
+func (*PluginReconfiguration) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8d036da5b4a9bcf3, []int{1}
+}
+
+func (m *PluginReconfiguration) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PluginReconfiguration.Unmarshal(m, b)
+}
+func (m *PluginReconfiguration) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PluginReconfiguration.Marshal(b, m, deterministic)
+}
+func (m *PluginReconfiguration) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PluginReconfiguration.Merge(m, src)
+}
+func (m *PluginReconfiguration) XXX_Size() int {
+	return xxx_messageInfo_PluginReconfiguration.Size(m)
+}
+func (m *PluginReconfiguration) XXX_DiscardUnknown() {
+	xxx_messageInfo_PluginReconfiguration.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PluginReconfiguration proto.InternalMessageInfo
+
+//
+// Updated configuration to be re-applied by a running plugin
+type PluginReconfiguration_Request struct {
+	// Raw configuration to be processed by the plugin
+	RawConfiguration     []byte   `protobuf:"bytes,1,opt,name=rawConfiguration,proto3" json:"rawConfiguration,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PluginReconfiguration_Request) Reset()         { *m = PluginReconfiguration_Request{} }
+func (m *PluginReconfiguration_Request) String() string { return proto.CompactTextString(m) }
+func (*PluginReconfiguration_Request) ProtoMessage()    {}
+func (*PluginReconfiguration_Request) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8d036da5b4a9bcf3, []int{1, 0}
+}
+
+func (m *PluginReconfiguration_Request) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PluginReconfiguration_Request.Unmarshal(m, b)
+}
+func (m *PluginReconfiguration_Request) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PluginReconfiguration_Request.Marshal(b, m, deterministic)
+}
+func (m *PluginReconfiguration_Request) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PluginReconfiguration_Request.Merge(m, src)
+}
+func (m *PluginReconfiguration_Request) XXX_Size() int {
+	return xxx_messageInfo_PluginReconfiguration_Request.Size(m)
+}
+func (m *PluginReconfiguration_Request) XXX_DiscardUnknown() {
+	xxx_messageInfo_PluginReconfiguration_Request.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PluginReconfiguration_Request proto.InternalMessageInfo
+
+func (m *PluginReconfiguration_Request) GetRawConfiguration() []byte {
+	if m != nil {
+		return m.RawConfiguration
+	}
+	return nil
+}
+
+type PluginReconfiguration_Response struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PluginReconfiguration_Response) Reset()         { *m = PluginReconfiguration_Response{} }
+func (m *PluginReconfiguration_Response) String() string { return proto.CompactTextString(m) }
+func (*PluginReconfiguration_Response) ProtoMessage()    {}
+func (*PluginReconfiguration_Response) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8d036da5b4a9bcf3, []int{1, 1}
+}
+
+func (m *PluginReconfiguration_Response) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PluginReconfiguration_Response.Unmarshal(m, b)
+}
+func (m *PluginReconfiguration_Response) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PluginReconfiguration_Response.Marshal(b, m, deterministic)
+}
+func (m *PluginReconfiguration_Response) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PluginReconfiguration_Response.Merge(m, src)
+}
+func (m *PluginReconfiguration_Response) XXX_Size() int {
+	return xxx_messageInfo_PluginReconfiguration_Response.Size(m)
+}
+func (m *PluginReconfiguration_Response) XXX_DiscardUnknown() {
+	xxx_messageInfo_PluginReconfiguration_Response.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PluginReconfiguration_Response proto.InternalMessageInfo
+
 func init() {
 	proto.RegisterType((*PluginInitialization)(nil), "proto_common.PluginInitialization")
 	proto.RegisterType((*PluginInitialization_Request)(nil), "proto_common.PluginInitialization.Request")
 	proto.RegisterType((*PluginInitialization_Response)(nil), "proto_common.PluginInitialization.Response")
+	proto.RegisterType((*PluginReconfiguration)(nil), "proto_common.PluginReconfiguration")
+	proto.RegisterType((*PluginReconfiguration_Request)(nil), "proto_common.PluginReconfiguration.Request")
+	proto.RegisterType((*PluginReconfiguration_Response)(nil), "proto_common.PluginReconfiguration.Response")
 }
 
 func init() { proto.RegisterFile("init.proto", fileDescriptor_8d036da5b4a9bcf3) }
 
 var fileDescriptor_8d036da5b4a9bcf3 = []byte{
-	// 210 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0xca, 0xcc, 0xcb, 0x2c,
-	0xd1, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x01, 0x53, 0xf1, 0xc9, 0xf9, 0xb9, 0xb9, 0xf9,
-	0x79, 0x4a, 0xb5, 0x5c, 0x22, 0x01, 0x39, 0xa5, 0xe9, 0x99, 0x79, 0x9e, 0x79, 0x99, 0x25, 0x99,
-	0x89, 0x39, 0x99, 0x55, 0x89, 0x25, 0x99, 0xf9, 0x79, 0x52, 0x91, 0x5c, 0xec, 0x41, 0xa9, 0x85,
-	0xa5, 0xa9, 0xc5, 0x25, 0x42, 0x4a, 0x5c, 0x3c, 0x19, 0xf9, 0xc5, 0x25, 0x9e, 0x29, 0xa9, 0x79,
-	0x25, 0x99, 0x25, 0x95, 0x12, 0x8c, 0x0a, 0x8c, 0x1a, 0x9c, 0x41, 0x28, 0x62, 0x42, 0x5a, 0x5c,
-	0x02, 0x45, 0x89, 0xe5, 0xce, 0xf9, 0x79, 0x69, 0x99, 0xe9, 0xa5, 0x45, 0x60, 0x23, 0x24, 0x98,
-	0x14, 0x18, 0x35, 0x78, 0x82, 0x30, 0xc4, 0xa5, 0xb8, 0xb8, 0x38, 0x82, 0x52, 0x8b, 0x0b, 0xf2,
-	0xf3, 0x8a, 0x53, 0x8d, 0x4a, 0xb8, 0x04, 0xd1, 0xac, 0x4f, 0x2d, 0x12, 0x8a, 0xe7, 0x62, 0x01,
-	0x71, 0x85, 0xb4, 0xf4, 0x90, 0x9d, 0xaa, 0x87, 0xcd, 0x9d, 0x7a, 0x50, 0x47, 0x4a, 0x69, 0x13,
-	0xa5, 0x16, 0x62, 0xab, 0x93, 0x09, 0x97, 0x78, 0x72, 0x7e, 0xae, 0x5e, 0x61, 0x69, 0x7e, 0x51,
-	0x69, 0xae, 0x5e, 0x01, 0x58, 0x2d, 0x44, 0xbf, 0x13, 0x37, 0x92, 0x43, 0xa2, 0x50, 0x82, 0x2a,
-	0x89, 0x0d, 0xcc, 0x33, 0x06, 0x04, 0x00, 0x00, 0xff, 0xff, 0x97, 0x47, 0x58, 0xf3, 0x4d, 0x01,
-	0x00, 0x00,
+	// 270 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x52, 0xcb, 0x4a, 0xc3, 0x40,
+	0x14, 0x65, 0x54, 0x7c, 0xdc, 0x66, 0xa1, 0x83, 0x62, 0xc8, 0x2a, 0x64, 0x55, 0xaa, 0xcc, 0xc2,
+	0xc7, 0x0f, 0xd4, 0x55, 0x77, 0x92, 0x9d, 0xdd, 0x94, 0x69, 0x1c, 0xdb, 0x0b, 0xc9, 0xdc, 0x74,
+	0x1e, 0x88, 0xfe, 0x8d, 0x3f, 0xe6, 0xb7, 0x48, 0x92, 0x62, 0x92, 0x1a, 0xa1, 0xab, 0xe1, 0x1e,
+	0xce, 0x9c, 0xc7, 0xe5, 0x02, 0xa0, 0x46, 0x27, 0x4a, 0x43, 0x8e, 0x78, 0x50, 0x3f, 0x8b, 0x8c,
+	0x8a, 0x82, 0x74, 0xf2, 0xc5, 0xe0, 0xf2, 0x39, 0xf7, 0x2b, 0xd4, 0x33, 0x8d, 0x0e, 0x65, 0x8e,
+	0x9f, 0xd2, 0x21, 0xe9, 0xe8, 0x05, 0x4e, 0x52, 0xb5, 0xf1, 0xca, 0x3a, 0x9e, 0x40, 0xb0, 0x26,
+	0xeb, 0x66, 0xaf, 0x4a, 0x3b, 0x74, 0x1f, 0x21, 0x8b, 0xd9, 0xf8, 0x2c, 0xed, 0x61, 0x7c, 0x02,
+	0xe7, 0x46, 0xbe, 0x3f, 0x91, 0x7e, 0xc3, 0x95, 0x37, 0xb5, 0x44, 0x78, 0x10, 0xb3, 0x71, 0x90,
+	0xfe, 0xc1, 0x23, 0x01, 0xa7, 0xa9, 0xb2, 0x25, 0x69, 0xab, 0x2a, 0xed, 0x4c, 0x96, 0x72, 0x89,
+	0x39, 0x3a, 0x54, 0x36, 0x64, 0xf1, 0x61, 0xa5, 0xdd, 0xc5, 0x92, 0x39, 0x5c, 0x35, 0x11, 0x53,
+	0x95, 0xf5, 0x84, 0x1e, 0xdb, 0x8c, 0x43, 0xfe, 0xec, 0x1f, 0x7f, 0x68, 0xfd, 0xef, 0xbe, 0x19,
+	0x5c, 0xec, 0xf4, 0x57, 0x86, 0x2f, 0xe0, 0xa8, 0x1a, 0xf9, 0x44, 0x74, 0x97, 0x25, 0x86, 0x16,
+	0x25, 0xb6, 0x09, 0xa2, 0x9b, 0xbd, 0xb8, 0xdb, 0xda, 0x6b, 0x18, 0xb5, 0x65, 0x14, 0x1f, 0xfc,
+	0xbb, 0xd3, 0xf6, 0xd7, 0xe8, 0x76, 0x3f, 0x72, 0xe3, 0x34, 0x7d, 0x80, 0xeb, 0x8c, 0x0a, 0xb1,
+	0xf1, 0x64, 0x7c, 0x21, 0xca, 0x9a, 0xdc, 0x08, 0x4c, 0x47, 0x9d, 0xca, 0xf3, 0xde, 0x59, 0x2c,
+	0x8f, 0xeb, 0xe9, 0xfe, 0x67, 0x00, 0xb7, 0xd9, 0x77, 0x92, 0x39, 0x02, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -179,6 +300,7 @@ const _ = grpc.SupportPackageIsVersion4
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type PluginInitializerClient interface {
 	Init(ctx context.Context, in *PluginInitialization_Request, opts ...grpc.CallOption) (*PluginInitialization_Response, error)
+	Reconfigure(ctx context.Context, in *PluginReconfiguration_Request, opts ...grpc.CallOption) (*PluginReconfiguration_Response, error)
 }
 
 type pluginInitializerClient struct {
@@ -198,9 +320,19 @@ func (c *pluginInitializerClient) Init(ctx context.Context, in *PluginInitializa
 	return out, nil
 }
 
+func (c *pluginInitializerClient) Reconfigure(ctx context.Context, in *PluginReconfiguration_Request, opts ...grpc.CallOption) (*PluginReconfiguration_Response, error) {
+	out := new(PluginReconfiguration_Response)
+	err := c.cc.Invoke(ctx, "/proto_common.PluginInitializer/Reconfigure", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PluginInitializerServer is the server API for PluginInitializer service.
 type PluginInitializerServer interface {
 	Init(context.Context, *PluginInitialization_Request) (*PluginInitialization_Response, error)
+	Reconfigure(context.Context, *PluginReconfiguration_Request) (*PluginReconfiguration_Response, error)
 }
 
 // UnimplementedPluginInitializerServer can be embedded to have forward compatible implementations.
@@ -210,6 +342,9 @@ type UnimplementedPluginInitializerServer struct {
 func (*UnimplementedPluginInitializerServer) Init(ctx context.Context, req *PluginInitialization_Request) (*PluginInitialization_Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Init not implemented")
 }
+func (*UnimplementedPluginInitializerServer) Reconfigure(ctx context.Context, req *PluginReconfiguration_Request) (*PluginReconfiguration_Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reconfigure not implemented")
+}
 
 func RegisterPluginInitializerServer(s *grpc.Server, srv PluginInitializerServer) {
 	s.RegisterService(&_PluginInitializer_serviceDesc, srv)
@@ -233,6 +368,24 @@ func _PluginInitializer_Init_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PluginInitializer_Reconfigure_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PluginReconfiguration_Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginInitializerServer).Reconfigure(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto_common.PluginInitializer/Reconfigure",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginInitializerServer).Reconfigure(ctx, req.(*PluginReconfiguration_Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _PluginInitializer_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "proto_common.PluginInitializer",
 	HandlerType: (*PluginInitializerServer)(nil),
@@ -241,6 +394,10 @@ var _PluginInitializer_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Init",
 			Handler:    _PluginInitializer_Init_Handler,
 		},
+		{
+			MethodName: "Reconfigure",
+			Handler:    _PluginInitializer_Reconfigure_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "init.proto",
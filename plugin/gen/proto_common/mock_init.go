@@ -55,6 +55,26 @@ func (mr *MockPluginInitializerClientMockRecorder) Init(ctx, in interface{}, opt
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Init", reflect.TypeOf((*MockPluginInitializerClient)(nil).Init), varargs...)
 }
 
+// Reconfigure mocks base method
+func (m *MockPluginInitializerClient) Reconfigure(ctx context.Context, in *PluginReconfiguration_Request, opts ...grpc.CallOption) (*PluginReconfiguration_Response, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Reconfigure", varargs...)
+	ret0, _ := ret[0].(*PluginReconfiguration_Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reconfigure indicates an expected call of Reconfigure
+func (mr *MockPluginInitializerClientMockRecorder) Reconfigure(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reconfigure", reflect.TypeOf((*MockPluginInitializerClient)(nil).Reconfigure), varargs...)
+}
+
 // MockPluginInitializerServer is a mock of PluginInitializerServer interface
 type MockPluginInitializerServer struct {
 	ctrl     *gomock.Controller
@@ -92,3 +112,18 @@ func (mr *MockPluginInitializerServerMockRecorder) Init(arg0, arg1 interface{})
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Init", reflect.TypeOf((*MockPluginInitializerServer)(nil).Init), arg0, arg1)
 }
+
+// Reconfigure mocks base method
+func (m *MockPluginInitializerServer) Reconfigure(arg0 context.Context, arg1 *PluginReconfiguration_Request) (*PluginReconfiguration_Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reconfigure", arg0, arg1)
+	ret0, _ := ret[0].(*PluginReconfiguration_Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reconfigure indicates an expected call of Reconfigure
+func (mr *MockPluginInitializerServerMockRecorder) Reconfigure(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reconfigure", reflect.TypeOf((*MockPluginInitializerServer)(nil).Reconfigure), arg0, arg1)
+}
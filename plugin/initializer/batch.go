@@ -0,0 +1,60 @@
+package initializer
+
+import (
+	"context"
+	"sync"
+)
+
+// PluginInit is the initialization of one plugin by InitAll
+type PluginInit struct {
+	Identity         string // identifies the plugin in the errors returned by InitAll
+	Initializer      PluginInitializer
+	RawConfiguration []byte
+}
+
+// InitAll initializes the plugins concurrently, running at most parallelism Init calls at once, or all
+// of them if parallelism isn't positive. The plugins are started in order and the calls share the
+// context, a plugin whose initialization hasn't started when the context is done fails with the error
+// of the context.
+//
+// The errors are returned by plugin identity, the plugins initialized successfully aren't in the map.
+func InitAll(ctx context.Context, nodeIdentity string, parallelism int, plugins []PluginInit) map[string]error {
+	if parallelism <= 0 || parallelism > len(plugins) {
+		parallelism = len(plugins)
+	}
+	var (
+		mu   sync.Mutex
+		errs = make(map[string]error)
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, parallelism)
+	)
+	fail := func(identity string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs[identity] = err
+	}
+	for _, p := range plugins {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			fail(p.Identity, ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(p PluginInit) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			err := ctx.Err()
+			if err == nil {
+				err = p.Initializer.Init(ctx, nodeIdentity, p.RawConfiguration)
+			}
+			if err != nil {
+				fail(p.Identity, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,79 @@
+package initializer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeInitializer struct {
+	delay    time.Duration
+	err      error
+	running  *int32
+	maxSeen  *int32
+	received []byte
+}
+
+func (f *fakeInitializer) Init(ctx context.Context, nodeIdentity string, rawConfiguration []byte) error {
+	if f.running != nil {
+		n := atomic.AddInt32(f.running, 1)
+		defer atomic.AddInt32(f.running, -1)
+		for {
+			max := atomic.LoadInt32(f.maxSeen)
+			if n <= max || atomic.CompareAndSwapInt32(f.maxSeen, max, n) {
+				break
+			}
+		}
+	}
+	f.received = rawConfiguration
+	select {
+	case <-time.After(f.delay):
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *fakeInitializer) Reconfigure(ctx context.Context, rawConfiguration []byte) error {
+	return nil
+}
+
+func TestInitAll(t *testing.T) {
+	var running, maxSeen int32
+	failure := errors.New("arbitrary error")
+	fast := &fakeInitializer{running: &running, maxSeen: &maxSeen}
+	slow := &fakeInitializer{delay: 20 * time.Millisecond, running: &running, maxSeen: &maxSeen}
+	failing := &fakeInitializer{err: failure, running: &running, maxSeen: &maxSeen}
+	other := &fakeInitializer{delay: 10 * time.Millisecond, running: &running, maxSeen: &maxSeen}
+
+	errs := InitAll(context.Background(), "arbitraryName", 2, []PluginInit{
+		{Identity: "fast", Initializer: fast, RawConfiguration: []byte("fast config")},
+		{Identity: "slow", Initializer: slow, RawConfiguration: []byte("slow config")},
+		{Identity: "failing", Initializer: failing},
+		{Identity: "other", Initializer: other},
+	})
+
+	assert.Equal(t, map[string]error{"failing": failure}, errs)
+	assert.Equal(t, []byte("fast config"), fast.received)
+	assert.Equal(t, []byte("slow config"), slow.received)
+	assert.True(t, maxSeen <= 2, "%d concurrent Init calls", maxSeen)
+}
+
+func TestInitAll_WhenContextTimesOut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	errs := InitAll(ctx, "arbitraryName", 1, []PluginInit{
+		{Identity: "fast", Initializer: &fakeInitializer{}},
+		{Identity: "stuck", Initializer: &fakeInitializer{delay: time.Minute}},
+		{Identity: "queued", Initializer: &fakeInitializer{}},
+	})
+
+	assert.NotContains(t, errs, "fast")
+	assert.Equal(t, context.DeadlineExceeded, errs["stuck"])
+	assert.Equal(t, context.DeadlineExceeded, errs["queued"])
+}
@@ -0,0 +1,57 @@
+package initializer
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	capabilitiesMu sync.RWMutex
+	capabilities   = make(map[string]map[string]struct{}) // capabilities of the initialized plugins by plugin identity
+)
+
+// HasCapability checks if the plugin with the given identity has reported the capability in the response
+// to its last successful Init, so that the features relying on the plugin can be gated on its support
+// without calling it again. It returns false if the plugin isn't initialized.
+func HasCapability(identity, capability string) bool {
+	capabilitiesMu.RLock()
+	defer capabilitiesMu.RUnlock()
+
+	_, ok := capabilities[identity][capability]
+	return ok
+}
+
+// Capabilities returns the sorted capabilities the plugin with the given identity has reported in the response
+// to its last successful Init, nil if the plugin isn't initialized
+func Capabilities(identity string) []string {
+	capabilitiesMu.RLock()
+	defer capabilitiesMu.RUnlock()
+
+	byName, ok := capabilities[identity]
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recordCapabilities replaces the capabilities of the plugin with the ones negotiated at its Init, nil
+// capabilities forget them, e.g. once the initialization failed
+func recordCapabilities(identity string, negotiated []string) {
+	capabilitiesMu.Lock()
+	defer capabilitiesMu.Unlock()
+
+	if negotiated == nil {
+		delete(capabilities, identity)
+		return
+	}
+	byName := make(map[string]struct{}, len(negotiated))
+	for _, name := range negotiated {
+		byName[name] = struct{}{}
+	}
+	capabilities[identity] = byName
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 
 	iplugin "github.com/kisexp/xdchain/internal/plugin"
-	"github.com/kisexp/xdchain/plugin/gen/proto_common"
 	"github.com/hashicorp/go-plugin"
 	"google.golang.org/grpc"
 )
@@ -13,6 +12,8 @@ const ConnectorName = "init"
 
 type PluginConnector struct {
 	plugin.Plugin
+	// PluginName identifies the plugin whose registered ConfigValidator is used, if any
+	PluginName string
 }
 
 func (p *PluginConnector) GRPCServer(b *plugin.GRPCBroker, s *grpc.Server) error {
@@ -20,7 +21,5 @@ func (p *PluginConnector) GRPCServer(b *plugin.GRPCBroker, s *grpc.Server) error
 }
 
 func (p *PluginConnector) GRPCClient(ctx context.Context, b *plugin.GRPCBroker, cc *grpc.ClientConn) (interface{}, error) {
-	return &PluginGateway{
-		client: proto_common.NewPluginInitializerClient(cc),
-	}, nil
+	return newPluginGateway(p.PluginName, cc), nil
 }
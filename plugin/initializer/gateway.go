@@ -2,18 +2,128 @@ package initializer
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/kisexp/xdchain/plugin/gen/proto_common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// ErrReconfigureNotSupported is returned by Reconfigure when the plugin can't re-apply
+// configuration while running, the plugin keeps the configuration it has been started with
+var ErrReconfigureNotSupported = errors.New("plugin does not support reconfiguration")
+
 type PluginGateway struct {
-	client proto_common.PluginInitializerClient
+	client     proto_common.PluginInitializerClient
+	pluginName string
+	validate   ConfigValidator  // optional host side validation of the raw configuration
+	conn       *grpc.ClientConn // connection owned by the gateway, nil if the plugin is started by the host
+	observe    InitObserver     // optional, notified of the outcome of Init
+	redial     RedialPolicy     // redial of the plugin by NewPluginGateway, no redial by default
+
+	interceptors []grpc.UnaryClientInterceptor // run around the calls to the plugin, none by default
+}
+
+// InitObserver is called once the initialization of the plugin completes, with the time it took and
+// the error it failed with, nil on success. It's called on the goroutine running Init.
+type InitObserver func(pluginName string, duration time.Duration, err error)
+
+// GatewayOption configures a gateway returned by NewPluginGateway
+type GatewayOption func(g *PluginGateway)
+
+// WithInitObserver registers the observer notified of the outcome of Init, e.g. to record metrics
+func WithInitObserver(observer InitObserver) GatewayOption {
+	return func(g *PluginGateway) {
+		g.observe = observer
+	}
+}
+
+// NewPluginGateway returns a gateway to the initializer of the plugin reached through the transport,
+// it must be closed once done with the plugin
+func NewPluginGateway(ctx context.Context, pluginName string, transport Transport, opts ...GatewayOption) (*PluginGateway, error) {
+	g := &PluginGateway{
+		pluginName: pluginName,
+		validate:   configValidatorFor(pluginName),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	conn, err := g.connect(ctx, transport)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to plugin %s: %v", pluginName, err)
+	}
+	g.client = proto_common.NewPluginInitializerClient(conn)
+	g.conn = conn
+	if len(g.interceptors) > 0 {
+		g.client = &interceptedClient{next: g.client, cc: conn, interceptors: g.interceptors}
+	}
+	return g, nil
+}
+
+func newPluginGateway(pluginName string, cc *grpc.ClientConn) *PluginGateway {
+	return &PluginGateway{
+		client:     proto_common.NewPluginInitializerClient(cc),
+		pluginName: pluginName,
+		validate:   configValidatorFor(pluginName),
+	}
 }
 
+// Close closes the connection of a gateway returned by NewPluginGateway, it does nothing otherwise
+func (g *PluginGateway) Close() error {
+	if g.conn == nil {
+		return nil
+	}
+	return g.conn.Close()
+}
+
+// Init initializes the plugin with the raw configuration, the registered InitObserver is notified of
+// the outcome, including the failure of the host side validation of the configuration. The capabilities
+// the plugin reports on success are then queried by HasCapability.
 func (g *PluginGateway) Init(ctx context.Context, nodeIdentity string, rawConfiguration []byte) error {
-	_, err := g.client.Init(ctx, &proto_common.PluginInitialization_Request{
+	if g.observe == nil {
+		return g.init(ctx, nodeIdentity, rawConfiguration)
+	}
+	start := time.Now()
+	err := g.init(ctx, nodeIdentity, rawConfiguration)
+	g.observe(g.pluginName, time.Since(start), err)
+	return err
+}
+
+func (g *PluginGateway) init(ctx context.Context, nodeIdentity string, rawConfiguration []byte) error {
+	if g.validate != nil {
+		if err := g.validate(rawConfiguration); err != nil {
+			return fmt.Errorf("invalid configuration for plugin %s: %v", g.pluginName, err)
+		}
+	}
+	resp, err := g.client.Init(ctx, &proto_common.PluginInitialization_Request{
 		HostIdentity:     nodeIdentity,
 		RawConfiguration: rawConfiguration,
 	})
+	if err != nil {
+		recordCapabilities(g.pluginName, nil)
+		return err
+	}
+	// a plugin reporting no capability is initialized all the same
+	recordCapabilities(g.pluginName, append([]string{}, resp.GetCapabilities()...))
+	return nil
+}
+
+// Reconfigure pushes updated configuration to the running plugin, which re-applies it without a restart.
+// ErrReconfigureNotSupported is returned if the plugin doesn't implement reconfiguration.
+func (g *PluginGateway) Reconfigure(ctx context.Context, rawConfiguration []byte) error {
+	if g.validate != nil {
+		if err := g.validate(rawConfiguration); err != nil {
+			return fmt.Errorf("invalid configuration for plugin %s: %v", g.pluginName, err)
+		}
+	}
+	_, err := g.client.Reconfigure(ctx, &proto_common.PluginReconfiguration_Request{
+		RawConfiguration: rawConfiguration,
+	})
+	if status.Code(err) == codes.Unimplemented {
+		return fmt.Errorf("%w: %s", ErrReconfigureNotSupported, g.pluginName)
+	}
 	return err
 }
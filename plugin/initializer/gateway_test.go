@@ -2,11 +2,17 @@ package initializer
 
 import (
 	"context"
+	"errors"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/kisexp/xdchain/plugin/gen/proto_common"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestPluginGateway_Init(t *testing.T) {
@@ -30,3 +36,210 @@ func TestPluginGateway_Init(t *testing.T) {
 
 	assert.NoError(t, err)
 }
+
+func TestPluginGateway_Init_RecordsCapabilities(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := proto_common.NewMockPluginInitializerClient(ctrl)
+	gomock.InOrder(
+		mockClient.EXPECT().Init(gomock.Any(), gomock.Any()).Return(&proto_common.PluginInitialization_Response{Capabilities: []string{"signing", "key-rotation"}}, nil),
+		mockClient.EXPECT().Init(gomock.Any(), gomock.Any()).Return(nil, errors.New("arbitrary error")),
+	)
+	testObject := &PluginGateway{client: mockClient, pluginName: "capabilities-test"}
+	assert.False(t, HasCapability("capabilities-test", "signing"))
+
+	assert.NoError(t, testObject.Init(context.Background(), "arbitraryName", nil))
+
+	assert.True(t, HasCapability("capabilities-test", "signing"))
+	assert.True(t, HasCapability("capabilities-test", "key-rotation"))
+	assert.False(t, HasCapability("capabilities-test", "arbitrary capability"))
+	assert.False(t, HasCapability("other-plugin", "signing"))
+	assert.Equal(t, []string{"key-rotation", "signing"}, Capabilities("capabilities-test"))
+
+	// the capabilities are forgotten once the plugin fails to initialize
+	assert.Error(t, testObject.Init(context.Background(), "arbitraryName", nil))
+
+	assert.False(t, HasCapability("capabilities-test", "signing"))
+	assert.Nil(t, Capabilities("capabilities-test"))
+}
+
+func TestPluginGateway_Init_WhenConfigurationIsInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// no RPC is expected as the validation fails on the host
+	mockClient := proto_common.NewMockPluginInitializerClient(ctrl)
+
+	testObject := &PluginGateway{
+		client:     mockClient,
+		pluginName: "arbitraryPlugin",
+		validate: func(rawConfiguration []byte) error {
+			return errors.New("unknown field")
+		},
+	}
+
+	err := testObject.Init(context.Background(), "arbitraryName", []byte("arbitrary config"))
+
+	assert.EqualError(t, err, "invalid configuration for plugin arbitraryPlugin: unknown field")
+}
+
+func TestPluginGateway_Init_WhenConfigurationIsValid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	req := &proto_common.PluginInitialization_Request{
+		HostIdentity:     "arbitraryName",
+		RawConfiguration: []byte("arbitrary config"),
+	}
+
+	mockClient := proto_common.NewMockPluginInitializerClient(ctrl)
+	mockClient.
+		EXPECT().
+		Init(gomock.Any(), gomock.Eq(req)).
+		Return(&proto_common.PluginInitialization_Response{}, nil)
+
+	var validated []byte
+	testObject := &PluginGateway{
+		client: mockClient,
+		validate: func(rawConfiguration []byte) error {
+			validated = rawConfiguration
+			return nil
+		},
+	}
+
+	err := testObject.Init(context.Background(), req.HostIdentity, req.RawConfiguration)
+
+	assert.NoError(t, err)
+	assert.Equal(t, req.RawConfiguration, validated)
+}
+
+func TestPluginGateway_Init_NotifiesInitObserver(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := proto_common.NewMockPluginInitializerClient(ctrl)
+	mockClient.
+		EXPECT().
+		Init(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, req *proto_common.PluginInitialization_Request, opts ...grpc.CallOption) (*proto_common.PluginInitialization_Response, error) {
+			time.Sleep(10 * time.Millisecond)
+			return &proto_common.PluginInitialization_Response{}, nil
+		})
+	mockClient.
+		EXPECT().
+		Init(gomock.Any(), gomock.Any()).
+		Return(nil, errors.New("arbitrary error"))
+
+	var (
+		observedName     string
+		observedDuration time.Duration
+		observedErr      error
+	)
+	testObject := &PluginGateway{client: mockClient, pluginName: "arbitraryPlugin"}
+	WithInitObserver(func(pluginName string, duration time.Duration, err error) {
+		observedName, observedDuration, observedErr = pluginName, duration, err
+	})(testObject)
+
+	err := testObject.Init(context.Background(), "arbitraryName", []byte("arbitrary config"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "arbitraryPlugin", observedName)
+	assert.True(t, observedDuration >= 10*time.Millisecond && observedDuration < 5*time.Second, "implausible duration %v", observedDuration)
+	assert.NoError(t, observedErr)
+
+	err = testObject.Init(context.Background(), "arbitraryName", []byte("arbitrary config"))
+
+	assert.EqualError(t, err, "arbitrary error")
+	assert.Equal(t, err, observedErr)
+}
+
+func TestRegisterConfigValidator(t *testing.T) {
+	defer RegisterConfigValidator("arbitraryPlugin", nil)
+
+	assert.Nil(t, configValidatorFor("arbitraryPlugin"))
+
+	RegisterConfigValidator("arbitraryPlugin", func(rawConfiguration []byte) error { return nil })
+	assert.NotNil(t, configValidatorFor("arbitraryPlugin"))
+
+	RegisterConfigValidator("arbitraryPlugin", nil)
+	assert.Nil(t, configValidatorFor("arbitraryPlugin"))
+}
+
+func TestPluginGateway_Reconfigure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	req := &proto_common.PluginReconfiguration_Request{
+		RawConfiguration: []byte("arbitrary config"),
+	}
+
+	mockClient := proto_common.NewMockPluginInitializerClient(ctrl)
+	mockClient.
+		EXPECT().
+		Reconfigure(gomock.Any(), gomock.Eq(req)).
+		Return(&proto_common.PluginReconfiguration_Response{}, nil)
+
+	testObject := &PluginGateway{client: mockClient}
+
+	err := testObject.Reconfigure(context.Background(), req.RawConfiguration)
+
+	assert.NoError(t, err)
+}
+
+func TestPluginGateway_Reconfigure_WhenConfigurationIsInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// no RPC is expected as the validation fails on the host
+	mockClient := proto_common.NewMockPluginInitializerClient(ctrl)
+
+	testObject := &PluginGateway{
+		client:     mockClient,
+		pluginName: "arbitraryPlugin",
+		validate: func(rawConfiguration []byte) error {
+			return errors.New("unknown field")
+		},
+	}
+
+	err := testObject.Reconfigure(context.Background(), []byte("arbitrary config"))
+
+	assert.EqualError(t, err, "invalid configuration for plugin arbitraryPlugin: unknown field")
+}
+
+func TestPluginGateway_Reconfigure_WhenPluginFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := proto_common.NewMockPluginInitializerClient(ctrl)
+	mockClient.
+		EXPECT().
+		Reconfigure(gomock.Any(), gomock.Any()).
+		Return(nil, status.Error(codes.InvalidArgument, "bad config"))
+
+	testObject := &PluginGateway{client: mockClient}
+
+	err := testObject.Reconfigure(context.Background(), []byte("arbitrary config"))
+
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrReconfigureNotSupported))
+}
+
+func TestPluginGateway_Reconfigure_WhenNotSupportedByPlugin(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	proto_common.RegisterPluginInitializerServer(server, &proto_common.UnimplementedPluginInitializerServer{})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	testObject := &PluginGateway{client: proto_common.NewPluginInitializerClient(conn), pluginName: "arbitraryPlugin"}
+
+	err = testObject.Reconfigure(context.Background(), []byte("arbitrary config"))
+
+	assert.True(t, errors.Is(err, ErrReconfigureNotSupported), "unexpected error: %v", err)
+}
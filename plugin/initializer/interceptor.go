@@ -0,0 +1,66 @@
+package initializer
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/kisexp/xdchain/plugin/gen/proto_common"
+	"google.golang.org/grpc"
+)
+
+// WithUnaryInterceptors makes the gateway run its calls to the plugin through the interceptors, e.g. to
+// add tracing or authentication metadata to the outgoing context. The first interceptor is the outermost.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) GatewayOption {
+	return func(g *PluginGateway) {
+		g.interceptors = append(g.interceptors, interceptors...)
+	}
+}
+
+// interceptedClient is a PluginInitializerClient running the calls of the wrapped client through the
+// interceptors
+type interceptedClient struct {
+	next         proto_common.PluginInitializerClient
+	cc           *grpc.ClientConn // given to the interceptors, nil if unknown
+	interceptors []grpc.UnaryClientInterceptor
+}
+
+func (c *interceptedClient) Init(ctx context.Context, in *proto_common.PluginInitialization_Request, opts ...grpc.CallOption) (*proto_common.PluginInitialization_Response, error) {
+	out := new(proto_common.PluginInitialization_Response)
+	err := c.invoke(ctx, "/proto_common.PluginInitializer/Init", in, out, func(ctx context.Context, opts ...grpc.CallOption) (proto.Message, error) {
+		return c.next.Init(ctx, in, opts...)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *interceptedClient) Reconfigure(ctx context.Context, in *proto_common.PluginReconfiguration_Request, opts ...grpc.CallOption) (*proto_common.PluginReconfiguration_Response, error) {
+	out := new(proto_common.PluginReconfiguration_Response)
+	err := c.invoke(ctx, "/proto_common.PluginInitializer/Reconfigure", in, out, func(ctx context.Context, opts ...grpc.CallOption) (proto.Message, error) {
+		return c.next.Reconfigure(ctx, in, opts...)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// invoke runs the call through the chain of interceptors, the response of the call is merged into reply
+func (c *interceptedClient) invoke(ctx context.Context, method string, req, reply proto.Message, call func(ctx context.Context, opts ...grpc.CallOption) (proto.Message, error), opts ...grpc.CallOption) error {
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		resp, err := call(ctx, opts...)
+		if err != nil {
+			return err
+		}
+		proto.Merge(reply.(proto.Message), resp)
+		return nil
+	}
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := c.interceptors[i], invoker
+		invoker = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return interceptor(ctx, method, req, reply, cc, next, opts...)
+		}
+	}
+	return invoker(ctx, method, req, reply, c.cc, opts...)
+}
@@ -0,0 +1,72 @@
+package initializer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/kisexp/xdchain/plugin/gen/proto_common"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type metadataRecordingServer struct {
+	proto_common.UnimplementedPluginInitializerServer
+	received metadata.MD
+}
+
+func (s *metadataRecordingServer) Init(ctx context.Context, req *proto_common.PluginInitialization_Request) (*proto_common.PluginInitialization_Response, error) {
+	s.received, _ = metadata.FromIncomingContext(ctx)
+	return &proto_common.PluginInitialization_Response{}, nil
+}
+
+func TestNewPluginGateway_WithUnaryInterceptors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	initializer := &metadataRecordingServer{}
+	server := grpc.NewServer()
+	proto_common.RegisterPluginInitializerServer(server, initializer)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	var (
+		calls           []string
+		methods         []string
+		outgoing        []metadata.MD
+		receivedReplies []interface{}
+	)
+	injector := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		calls = append(calls, "injector")
+		return invoker(metadata.AppendToOutgoingContext(ctx, "x-request-id", "arbitrary id"), method, req, reply, cc, opts...)
+	}
+	recorder := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		calls = append(calls, "recorder")
+		md, _ := metadata.FromOutgoingContext(ctx)
+		methods, outgoing = append(methods, method), append(outgoing, md)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		receivedReplies = append(receivedReplies, reply)
+		return err
+	}
+	transport := &DialerTransport{Target: listener.Addr().String(), Options: []grpc.DialOption{grpc.WithInsecure()}}
+	testObject, err := NewPluginGateway(context.Background(), "arbitraryPlugin", transport, WithUnaryInterceptors(injector, recorder))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer testObject.Close()
+
+	err = testObject.Init(context.Background(), "arbitraryName", []byte("arbitrary config"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"injector", "recorder"}, calls)
+	assert.Equal(t, []string{"/proto_common.PluginInitializer/Init"}, methods)
+	assert.Equal(t, []string{"arbitrary id"}, outgoing[0].Get("x-request-id"))
+	assert.Equal(t, []string{"arbitrary id"}, initializer.received.Get("x-request-id"))
+	assert.IsType(t, &proto_common.PluginInitialization_Response{}, receivedReplies[0])
+
+	err = testObject.Reconfigure(context.Background(), []byte("arbitrary config"))
+
+	assert.True(t, errors.Is(err, ErrReconfigureNotSupported), "unexpected error: %v", err)
+	assert.Equal(t, []string{"/proto_common.PluginInitializer/Init", "/proto_common.PluginInitializer/Reconfigure"}, methods)
+}
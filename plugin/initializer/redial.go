@@ -0,0 +1,77 @@
+package initializer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kisexp/xdchain/metrics"
+	"google.golang.org/grpc"
+)
+
+// RedialPolicy configures the redial of a plugin whose transport fails to connect, the wait
+// between two attempts doubles from InitialBackoff up to MaxBackoff
+type RedialPolicy struct {
+	MaxAttempts    int // number of redials after the first connection attempt
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration // Optional, the wait isn't capped if zero
+}
+
+// WithRedial makes NewPluginGateway redial the plugin according to the policy when the transport
+// fails to connect
+func WithRedial(policy RedialPolicy) GatewayOption {
+	return func(g *PluginGateway) {
+		g.redial = policy
+	}
+}
+
+// redialMetrics are the metrics of the redials of a plugin, registered to the default registry:
+//   - plugin/<name>/redial/attempts counts the redials
+//   - plugin/<name>/redial/successes counts the redials which connected to the plugin
+//   - plugin/<name>/redial/failures is the number of consecutive failed connection attempts
+type redialMetrics struct {
+	attempts  metrics.Counter
+	successes metrics.Counter
+	failures  metrics.Gauge
+}
+
+func newRedialMetrics(pluginName string) *redialMetrics {
+	prefix := fmt.Sprintf("plugin/%s/redial/", pluginName)
+	return &redialMetrics{
+		attempts:  metrics.GetOrRegisterCounter(prefix+"attempts", nil),
+		successes: metrics.GetOrRegisterCounter(prefix+"successes", nil),
+		failures:  metrics.GetOrRegisterGauge(prefix+"failures", nil),
+	}
+}
+
+// connect connects to the plugin through the transport, redialing it according to the redial policy
+// of the gateway. The error of the last attempt is returned if none succeeds.
+func (g *PluginGateway) connect(ctx context.Context, transport Transport) (*grpc.ClientConn, error) {
+	m := newRedialMetrics(g.pluginName)
+	backoff := g.redial.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			m.attempts.Inc(1)
+		}
+		conn, err := transport.Connect(ctx)
+		if err == nil {
+			if attempt > 0 {
+				m.successes.Inc(1)
+			}
+			m.failures.Update(0)
+			return conn, nil
+		}
+		m.failures.Inc(1)
+		if attempt >= g.redial.MaxAttempts {
+			return nil, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		if backoff *= 2; g.redial.MaxBackoff > 0 && backoff > g.redial.MaxBackoff {
+			backoff = g.redial.MaxBackoff
+		}
+	}
+}
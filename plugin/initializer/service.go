@@ -4,4 +4,6 @@ import "context"
 
 type PluginInitializer interface {
 	Init(ctx context.Context, nodeIdentity string, rawConfiguration []byte) error
+	// Reconfigure pushes updated configuration to the running plugin
+	Reconfigure(ctx context.Context, rawConfiguration []byte) error
 }
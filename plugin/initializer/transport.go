@@ -0,0 +1,117 @@
+package initializer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+)
+
+// Transport connects the host to the initializer of a plugin which isn't started by the host
+type Transport interface {
+	// Connect returns a new client connection to the plugin, it's closed by the caller
+	Connect(ctx context.Context) (*grpc.ClientConn, error)
+}
+
+// DialerTransport connects to the plugin with a gRPC dial of Target.
+//
+// Options are used as is, so they must configure the security of the connection,
+// e.g. grpc.WithInsecure() if the connections returned by Dialer are already secured.
+type DialerTransport struct {
+	Target  string
+	Dialer  func(ctx context.Context, addr string) (net.Conn, error) // Optional, establishes the connections to Target
+	Options []grpc.DialOption
+}
+
+func (t *DialerTransport) Connect(ctx context.Context) (*grpc.ClientConn, error) {
+	opts := t.Options
+	if t.Dialer != nil {
+		opts = append(append([]grpc.DialOption{}, opts...), grpc.WithContextDialer(t.Dialer))
+	}
+	return grpc.DialContext(ctx, t.Target, opts...)
+}
+
+// NewWebSocketTransport returns a Transport tunneling gRPC through a WebSocket connection to the endpoint,
+// e.g. wss://tunnel.example.com/plugin. The security of the connection is the one of the WebSocket.
+func NewWebSocketTransport(endpoint string, dialer *websocket.Dialer) *DialerTransport {
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	return &DialerTransport{
+		Target: endpoint,
+		Dialer: func(ctx context.Context, addr string) (net.Conn, error) {
+			conn, _, err := dialer.DialContext(ctx, addr, nil)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open websocket to %s: %v", addr, err)
+			}
+			return NewWebSocketConn(conn), nil
+		},
+		Options: []grpc.DialOption{grpc.WithInsecure()},
+	}
+}
+
+// webSocketConn is a net.Conn exchanging the stream in binary WebSocket messages
+type webSocketConn struct {
+	*websocket.Conn
+
+	readMu sync.Mutex
+	reader io.Reader // Reader of the message being read, nil until the next message is read
+
+	writeMu sync.Mutex
+}
+
+// NewWebSocketConn returns a net.Conn streaming over the WebSocket connection, e.g. to serve gRPC to the
+// host on the plugin side of a WebSocket tunnel
+func NewWebSocketConn(conn *websocket.Conn) net.Conn {
+	return &webSocketConn{Conn: conn}
+}
+
+func (c *webSocketConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		if c.reader == nil {
+			messageType, reader, err := c.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			c.reader = reader
+		}
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *webSocketConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *webSocketConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
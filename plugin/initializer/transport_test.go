@@ -0,0 +1,152 @@
+package initializer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kisexp/xdchain/metrics"
+	"github.com/kisexp/xdchain/plugin/gen/proto_common"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// webSocketListener accepts the connections tunneled through the WebSockets upgraded by its handler
+type webSocketListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newWebSocketListener() *webSocketListener {
+	return &webSocketListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *webSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	select {
+	case l.conns <- NewWebSocketConn(conn):
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *webSocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *webSocketListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *webSocketListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+type recordingInitializerServer struct {
+	proto_common.UnimplementedPluginInitializerServer
+	requests chan *proto_common.PluginInitialization_Request
+}
+
+func (s *recordingInitializerServer) Init(ctx context.Context, req *proto_common.PluginInitialization_Request) (*proto_common.PluginInitialization_Response, error) {
+	s.requests <- req
+	return &proto_common.PluginInitialization_Response{}, nil
+}
+
+func TestNewPluginGateway_OverWebSocket(t *testing.T) {
+	listener := newWebSocketListener()
+	httpServer := httptest.NewServer(listener)
+	defer httpServer.Close()
+	initializer := &recordingInitializerServer{requests: make(chan *proto_common.PluginInitialization_Request, 1)}
+	server := grpc.NewServer()
+	proto_common.RegisterPluginInitializerServer(server, initializer)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	endpoint := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+	testObject, err := NewPluginGateway(context.Background(), "arbitraryPlugin", NewWebSocketTransport(endpoint, nil))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer testObject.Close()
+
+	err = testObject.Init(context.Background(), "arbitraryName", []byte("arbitrary config"))
+
+	assert.NoError(t, err)
+	req := <-initializer.requests
+	assert.Equal(t, "arbitraryName", req.HostIdentity)
+	assert.Equal(t, []byte("arbitrary config"), req.RawConfiguration)
+}
+
+type failingTransport struct{}
+
+func (failingTransport) Connect(ctx context.Context) (*grpc.ClientConn, error) {
+	return nil, errors.New("tunnel down")
+}
+
+func TestNewPluginGateway_WhenTransportFails(t *testing.T) {
+	_, err := NewPluginGateway(context.Background(), "arbitraryPlugin", failingTransport{})
+
+	assert.EqualError(t, err, "unable to connect to plugin arbitraryPlugin: tunnel down")
+}
+
+func TestPluginGateway_Close_WhenStartedByHost(t *testing.T) {
+	assert.NoError(t, (&PluginGateway{}).Close())
+}
+
+// flappingTransport fails the given number of connection attempts before connecting
+type flappingTransport struct {
+	failures int
+	attempts int
+}
+
+func (t *flappingTransport) Connect(ctx context.Context) (*grpc.ClientConn, error) {
+	t.attempts++
+	if t.attempts <= t.failures {
+		return nil, errors.New("tunnel down")
+	}
+	return grpc.DialContext(ctx, "arbitraryTarget", grpc.WithInsecure())
+}
+
+func TestNewPluginGateway_RedialMetrics(t *testing.T) {
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+	policy := RedialPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	m := newRedialMetrics("flappingPlugin")
+
+	testObject, err := NewPluginGateway(context.Background(), "flappingPlugin", &flappingTransport{failures: 2}, WithRedial(policy))
+	assert.NoError(t, err)
+	assert.NoError(t, testObject.Close())
+	assert.Equal(t, int64(2), m.attempts.Count())
+	assert.Equal(t, int64(1), m.successes.Count())
+	assert.Equal(t, int64(0), m.failures.Value())
+
+	_, err = NewPluginGateway(context.Background(), "flappingPlugin", &flappingTransport{failures: 5}, WithRedial(policy))
+	assert.EqualError(t, err, "unable to connect to plugin flappingPlugin: tunnel down")
+	assert.Equal(t, int64(5), m.attempts.Count())
+	assert.Equal(t, int64(1), m.successes.Count())
+	assert.Equal(t, int64(4), m.failures.Value())
+}
+
+func TestNewPluginGateway_NoRedialByDefault(t *testing.T) {
+	transport := &flappingTransport{failures: 1}
+
+	_, err := NewPluginGateway(context.Background(), "arbitraryPlugin", transport)
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, transport.attempts)
+}
@@ -0,0 +1,35 @@
+package initializer
+
+import "sync"
+
+// ConfigValidator checks the raw configuration of a plugin on the host side. It runs before
+// the configuration is sent to the plugin so misconfiguration is reported by the node itself.
+type ConfigValidator func(rawConfiguration []byte) error
+
+var (
+	configValidatorsMu sync.RWMutex
+	configValidators   = make(map[string]ConfigValidator)
+)
+
+// RegisterConfigValidator registers the validator run against the raw configuration of the
+// given plugin before it is initialized. Registering a nil validator removes any existing one.
+//
+// The validators of the built-in plugins are registered by the plugin package, other hosts must
+// register theirs before the plugins are started, e.g. from an init function.
+func RegisterConfigValidator(pluginName string, validator ConfigValidator) {
+	configValidatorsMu.Lock()
+	defer configValidatorsMu.Unlock()
+
+	if validator == nil {
+		delete(configValidators, pluginName)
+		return
+	}
+	configValidators[pluginName] = validator
+}
+
+func configValidatorFor(pluginName string) ConfigValidator {
+	configValidatorsMu.RLock()
+	defer configValidatorsMu.RUnlock()
+
+	return configValidators[pluginName]
+}
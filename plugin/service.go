@@ -191,6 +191,23 @@ func (s *PluginManager) Reload(name PluginInterfaceName) (bool, error) {
 	return true, nil
 }
 
+// Reconfigure pushes the configuration of the plugin to the running plugin without restarting it.
+// Use Reload instead if the plugin doesn't support reconfiguration.
+func (s *PluginManager) Reconfigure(name PluginInterfaceName) (bool, error) {
+	p, ok := s.getPlugin(name)
+	if !ok {
+		return false, fmt.Errorf("no such plugin provider: %s", name)
+	}
+	rp, ok := p.(reconfigurablePlugin)
+	if !ok {
+		return false, fmt.Errorf("plugin provider %s does not support reconfiguration", name)
+	}
+	if err := rp.Reconfigure(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // this is to configure delegate APIs call to the plugins
 func (s *PluginManager) delegateAPIs() []rpc.API {
 	apis := make([]rpc.API, 0)
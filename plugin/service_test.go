@@ -144,3 +144,24 @@ func (i invalidPluginTemplate) Stop() error {
 func (i invalidPluginTemplate) Info() (PluginInterfaceName, interface{}) {
 	panic("implement me")
 }
+
+func TestPluginManager_Reconfigure_whenPluginNotStarted(t *testing.T) {
+	assert := testifyassert.New(t)
+	testObject := typicalPluginManager(t)
+
+	ok, err := testObject.Reconfigure(HelloWorldPluginInterfaceName)
+
+	assert.False(ok)
+	assert.EqualError(err, "plugin is not started")
+}
+
+func TestPluginManager_Reconfigure_whenPluginNotReconfigurable(t *testing.T) {
+	assert := testifyassert.New(t)
+	testObject := typicalPluginManager(t)
+	testObject.plugins[HelloWorldPluginInterfaceName] = new(invalidPluginTemplate)
+
+	ok, err := testObject.Reconfigure(HelloWorldPluginInterfaceName)
+
+	assert.False(ok)
+	assert.EqualError(err, "plugin provider helloworld does not support reconfiguration")
+}
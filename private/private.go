@@ -49,6 +49,13 @@ type PrivateTransactionManager interface {
 	Groups() ([]engine.PrivacyGroup, error)
 }
 
+// PayloadSizer is implemented by the private transaction managers which can report the size in bytes of
+// a payload without returning it
+type PayloadSizer interface {
+	// Returns 0 if not found
+	PayloadSize(hash common.EncryptedPayloadHash) (int, error)
+}
+
 // This loads any config specified via the legacy environment variable
 func GetLegacyEnvironmentConfig() (http2.Config, error) {
 	return FromEnvironmentOrNil("PRIVATE_CONFIG")
@@ -843,6 +843,32 @@ func (c *cleaner) Delete(key []byte) error {
 	panic("not implemented")
 }
 
+// ResetCleanCache drops the nodes of the clean cache, e.g. once nodes have been deleted from
+// the persistent database so they can't be served anymore.
+func (db *Database) ResetCleanCache() {
+	if db.cleans != nil {
+		db.cleans.Reset()
+	}
+}
+
+// DiscardUnreferenced drops the given roots from the memory database if they aren't referenced,
+// neither by the meta root nor by another node, i.e. the tries committed to the memory database
+// without being referenced nor flushed to disk. The nodes shared with other tries are kept. It
+// returns the number of dropped nodes.
+func (db *Database) DiscardUnreferenced(roots []common.Hash) int {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	nodes := len(db.dirties)
+	for _, root := range roots {
+		// the children of the dropped roots are dropped with them
+		if node, ok := db.dirties[root]; ok && root != (common.Hash{}) && node.parents == 0 {
+			db.dereference(root, common.Hash{})
+		}
+	}
+	return nodes - len(db.dirties)
+}
+
 // Size returns the current storage size of the memory cache in front of the
 // persistent database layer.
 func (db *Database) Size() (common.StorageSize, common.StorageSize) {